@@ -0,0 +1,139 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ArrayHandling converts columns holding arrays, e.g. audience tags, before rows reach connector's mapping code.
+// Some destinations reject array values, so the strategy is set with 'arrayHandling' option:
+//
+//   - "array" passes arrays as is, the default
+//   - "join" joins elements into a string delimited with 'arrayDelimiter', "," by default: ["a","b"] -> "a,b"
+//   - "explode" turns the row into a row per element: {"tag":["a","b"]} -> {"tag":"a"}, {"tag":"b"}. Several array
+//     columns are exploded into all combinations of their elements, at most MaxExplodedRows rows. Empty arrays
+//     are replaced with null
+//
+// 'arrayColumns' limits the strategy to listed columns, arrays of other columns are passed as is.
+// Nil ArrayHandling passes arrays as is
+type ArrayHandling struct {
+	strategy  string
+	delimiter string
+	// columns are columns the strategy applies to, all columns if nil
+	columns map[string]bool
+}
+
+// Strategies of ArrayHandling
+const (
+	ArrayPass    = "array"
+	ArrayJoin    = "join"
+	ArrayExplode = "explode"
+)
+
+// MaxExplodedRows limits the number of rows a single row is exploded into
+const MaxExplodedRows = 1000
+
+// ArrayHandlingFromCredentials creates ArrayHandling from 'arrayHandling', 'arrayDelimiter' and 'arrayColumns'
+// options. Nil if arrays are passed as is
+func ArrayHandlingFromCredentials(credentials map[string]any) (*ArrayHandling, error) {
+	strategy, _ := credentials["arrayHandling"].(string)
+	switch strategy {
+	case "", ArrayPass:
+		return nil, nil
+	case ArrayJoin, ArrayExplode:
+	default:
+		return nil, fmt.Errorf("arrayHandling must be %s, %s or %s, got: %s", ArrayPass, ArrayJoin, ArrayExplode, strategy)
+	}
+	a := &ArrayHandling{strategy: strategy, delimiter: ","}
+	if delimiter, ok := credentials["arrayDelimiter"].(string); ok {
+		a.delimiter = delimiter
+	}
+	if raw, ok := credentials["arrayColumns"].([]any); ok && len(raw) > 0 {
+		a.columns = make(map[string]bool, len(raw))
+		for _, c := range raw {
+			s, ok := c.(string)
+			if !ok || s == "" {
+				return nil, fmt.Errorf("arrayColumns must be an array of column names, got: %v", c)
+			}
+			a.columns[s] = true
+		}
+	}
+	return a, nil
+}
+
+// Apply converts array columns of the row. Returns the row itself, modified in place, unless it's exploded.
+// Exploded rows are copies of the row with an element in place of each array
+func (a *ArrayHandling) Apply(row map[string]any) ([]map[string]any, error) {
+	if a == nil {
+		return []map[string]any{row}, nil
+	}
+	var arrays []string
+	for name, value := range row {
+		if _, ok := value.([]any); ok && (a.columns == nil || a.columns[name]) {
+			arrays = append(arrays, name)
+		}
+	}
+	if len(arrays) == 0 {
+		return []map[string]any{row}, nil
+	}
+	if a.strategy == ArrayJoin {
+		for _, name := range arrays {
+			row[name] = joinArray(row[name].([]any), a.delimiter)
+		}
+		return []map[string]any{row}, nil
+	}
+	// sorted, so rows are exploded in the same order every run
+	sort.Strings(arrays)
+	rows := []map[string]any{row}
+	for _, name := range arrays {
+		elements := row[name].([]any)
+		if len(elements) == 0 {
+			for _, r := range rows {
+				r[name] = nil
+			}
+			continue
+		}
+		if len(rows)*len(elements) > MaxExplodedRows {
+			return nil, fmt.Errorf("row explodes into more than %d rows, column %s has %d elements", MaxExplodedRows, name, len(elements))
+		}
+		exploded := make([]map[string]any, 0, len(rows)*len(elements))
+		for _, r := range rows {
+			for _, element := range elements {
+				copied := make(map[string]any, len(r))
+				for k, v := range r {
+					copied[k] = v
+				}
+				copied[name] = element
+				exploded = append(exploded, copied)
+			}
+		}
+		rows = exploded
+	}
+	return rows, nil
+}
+
+// joinArray joins string representations of elements. Nulls are skipped, objects and arrays are joined as JSON
+func joinArray(elements []any, delimiter string) string {
+	parts := make([]string, 0, len(elements))
+	for _, element := range elements {
+		switch v := element.(type) {
+		case nil:
+			continue
+		case string:
+			parts = append(parts, v)
+		case json.Number:
+			parts = append(parts, v.String())
+		case float64:
+			parts = append(parts, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			parts = append(parts, strconv.FormatBool(v))
+		default:
+			b, _ := json.Marshal(v)
+			parts = append(parts, string(b))
+		}
+	}
+	return strings.Join(parts, delimiter)
+}
@@ -0,0 +1,60 @@
+package sdk
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestArrayHandlingJoin(t *testing.T) {
+	a, err := ArrayHandlingFromCredentials(map[string]any{"arrayHandling": "join", "arrayDelimiter": "|"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := map[string]any{"tags": []any{"a", json.Number("1"), nil, map[string]any{"k": "v"}}, "name": "x"}
+	rows, err := a.Apply(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []map[string]any{{"tags": `a|1|{"k":"v"}`, "name": "x"}}; !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+}
+
+func TestArrayHandlingExplode(t *testing.T) {
+	a, err := ArrayHandlingFromCredentials(map[string]any{"arrayHandling": "explode", "arrayColumns": []any{"tags", "sizes", "empty"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := map[string]any{"tags": []any{"a", "b"}, "sizes": []any{"s", "m"}, "empty": []any{}, "other": []any{1.0}}
+	rows, err := a.Apply(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]any{
+		{"sizes": "s", "tags": "a", "empty": nil, "other": []any{1.0}},
+		{"sizes": "s", "tags": "b", "empty": nil, "other": []any{1.0}},
+		{"sizes": "m", "tags": "a", "empty": nil, "other": []any{1.0}},
+		{"sizes": "m", "tags": "b", "empty": nil, "other": []any{1.0}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %v, want %v", rows, want)
+	}
+	huge := make([]any, MaxExplodedRows+1)
+	if _, err = a.Apply(map[string]any{"tags": huge}); err == nil {
+		t.Error("row exploding into more than MaxExplodedRows rows must be rejected")
+	}
+}
+
+func TestArrayHandlingFromCredentials(t *testing.T) {
+	if a, err := ArrayHandlingFromCredentials(map[string]any{"arrayHandling": "array"}); a != nil || err != nil {
+		t.Errorf("arrays must be passed as is: %v %v", a, err)
+	}
+	if _, err := ArrayHandlingFromCredentials(map[string]any{"arrayHandling": "split"}); err == nil {
+		t.Error("unknown strategy must be rejected")
+	}
+	row := map[string]any{"tags": []any{"a"}}
+	if rows, _ := (*ArrayHandling)(nil).Apply(row); len(rows) != 1 || !reflect.DeepEqual(rows[0], row) {
+		t.Errorf("nil ArrayHandling must pass the row as is: %v", rows)
+	}
+}
@@ -0,0 +1,654 @@
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ArrowReader reads rows from Arrow IPC data: stream format or file format (Feather v2). Hosts and sources use it
+// to hand off warehouse-scale results without encoding each row as JSON.
+//
+// Only flat schemas are supported: null, bool, integers, floats, decimal128, utf8, binary, date, time and timestamp
+// columns without dictionary encoding and body compression. Values are represented as described in RowReader
+type ArrowReader struct {
+	r       *bufio.Reader
+	columns []arrowColumn
+	// file is set for file format with continuation markers. Writers may omit the end-of-stream marker before the
+	// footer, so a message without the marker is the footer
+	file bool
+
+	batch    [][]any
+	batchLen int
+	row      int
+}
+
+type arrowColumn struct {
+	Column
+	typeId   byte
+	bitWidth int
+	signed   bool
+	unit     int
+	timezone string
+}
+
+// Arrow type ids, see Schema.fbs
+const (
+	arrowNull          = 1
+	arrowInt           = 2
+	arrowFloatingPoint = 3
+	arrowBinary        = 4
+	arrowUtf8          = 5
+	arrowBool          = 6
+	arrowDecimal       = 7
+	arrowDate          = 8
+	arrowTime          = 9
+	arrowTimestamp     = 10
+	arrowLargeBinary   = 19
+	arrowLargeUtf8     = 20
+)
+
+// Arrow message header types, see Message.fbs
+const (
+	arrowSchemaMessage      = 1
+	arrowRecordBatchMessage = 3
+)
+
+var arrowFileMagic = []byte("ARROW1")
+
+// Lengths and counts of corrupt data must not make the reader allocate unbounded memory. Metadata is a few KiB even
+// for wide schemas. Rows of a batch are kept in memory like rows of a Parquet row group
+const (
+	maxArrowMetadataLength = 64 << 20
+	maxArrowBodyLength     = math.MaxInt32 * 8
+	maxArrowBatchRows      = maxParquetRowGroupRows
+)
+
+// NewArrowReader reads the schema of Arrow IPC data
+func NewArrowReader(r io.Reader) (*ArrowReader, error) {
+	a := &ArrowReader{r: bufio.NewReaderSize(r, 1024*1024)}
+	magic, err := a.r.Peek(len(arrowFileMagic))
+	if err == nil && bytes.Equal(magic, arrowFileMagic) {
+		// file format is the stream format between 8 bytes of padded magic and the footer
+		if _, err = a.r.Discard(8); err != nil {
+			return nil, err
+		}
+		marker, _ := a.r.Peek(4)
+		a.file = bytes.Equal(marker, []byte{0xFF, 0xFF, 0xFF, 0xFF})
+	}
+	headerType, header, _, err := a.readMessage()
+	if err != nil {
+		return nil, fmt.Errorf("error reading arrow schema: %w", err)
+	}
+	if headerType != arrowSchemaMessage {
+		return nil, fmt.Errorf("arrow data must start with schema message, got message type %d", headerType)
+	}
+	if a.columns, err = parseArrowSchema(header); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Columns returns the columns of the schema
+func (a *ArrowReader) Columns() []Column {
+	columns := make([]Column, len(a.columns))
+	for i, c := range a.columns {
+		columns[i] = c.Column
+	}
+	return columns
+}
+
+// Next returns the next row. Returns io.EOF after the last row
+func (a *ArrowReader) Next() (map[string]any, error) {
+	for a.row >= a.batchLen {
+		if err := a.readBatch(); err != nil {
+			return nil, err
+		}
+	}
+	row := make(map[string]any, len(a.columns))
+	for i, column := range a.columns {
+		row[column.Name] = a.batch[i][a.row]
+	}
+	a.row++
+	return row, nil
+}
+
+func (a *ArrowReader) readBatch() error {
+	headerType, header, body, err := a.readMessage()
+	if err != nil {
+		return err
+	}
+	switch headerType {
+	case arrowRecordBatchMessage:
+		a.batch, a.batchLen, err = decodeArrowBatch(a.columns, header, body)
+		a.row = 0
+		return err
+	case arrowSchemaMessage:
+		return fmt.Errorf("unexpected arrow schema message in the middle of the stream")
+	default:
+		return fmt.Errorf("unsupported arrow message type %d. Dictionary encoded columns are not supported", headerType)
+	}
+}
+
+// readMessage reads encapsulated message: continuation marker, metadata length, Message flatbuffer and body.
+// Returns io.EOF at the end-of-stream marker or at the end of the data
+func (a *ArrowReader) readMessage() (headerType byte, header fbTable, body []byte, err error) {
+	var length uint32
+	if err = binary.Read(a.r, binary.LittleEndian, &length); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = io.EOF
+		}
+		return
+	}
+	if length == 0xFFFFFFFF {
+		if err = binary.Read(a.r, binary.LittleEndian, &length); err != nil {
+			return
+		}
+	} else if a.file {
+		err = io.EOF
+		return
+	}
+	if length == 0 {
+		err = io.EOF
+		return
+	}
+	if length > maxArrowMetadataLength {
+		err = fmt.Errorf("invalid arrow metadata length %d", length)
+		return
+	}
+	metadata, err := readLength(a.r, int64(length))
+	if err != nil {
+		return
+	}
+	message, err := fbRoot(metadata)
+	if err != nil {
+		return
+	}
+	headerType = message.byteField(1, 0)
+	header, ok := message.tableField(2)
+	if !ok {
+		err = fmt.Errorf("arrow message has no header")
+		return
+	}
+	bodyLength := message.int64Field(3, 0)
+	if bodyLength < 0 || bodyLength > maxArrowBodyLength {
+		err = fmt.Errorf("invalid arrow message body length %d", bodyLength)
+		return
+	}
+	body, err = readLength(a.r, bodyLength)
+	return
+}
+
+// readLength reads n bytes. The buffer grows as the data is read, so a length claimed by truncated data isn't
+// allocated up front
+func readLength(r io.Reader, n int64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, n))
+	if err == nil && int64(len(b)) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return b, err
+}
+
+func parseArrowSchema(schema fbTable) ([]arrowColumn, error) {
+	if schema.int16Field(0, 0) != 0 {
+		return nil, fmt.Errorf("big endian arrow data is not supported")
+	}
+	fields, err := schema.vectorField(1, 4)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]arrowColumn, fields.len())
+	for i := range columns {
+		field := fields.table(i)
+		c := &columns[i]
+		c.Name = field.stringField(0)
+		c.Nullable = field.byteField(1, 0) != 0
+		c.typeId = field.byteField(2, 0)
+		typ, _ := field.tableField(3)
+		if _, ok := field.tableField(4); ok {
+			return nil, fmt.Errorf("column '%s': dictionary encoded columns are not supported", c.Name)
+		}
+		if children, err := field.vectorField(5, 4); err != nil || children.len() > 0 {
+			return nil, fmt.Errorf("column '%s': nested columns are not supported", c.Name)
+		}
+		switch c.typeId {
+		case arrowNull:
+			c.Type = "string"
+		case arrowInt:
+			c.Type = "integer"
+			c.bitWidth = int(typ.int32Field(0, 0))
+			c.signed = typ.byteField(1, 0) != 0
+			if c.bitWidth != 8 && c.bitWidth != 16 && c.bitWidth != 32 && c.bitWidth != 64 {
+				return nil, fmt.Errorf("column '%s': invalid integer bit width %d", c.Name, c.bitWidth)
+			}
+		case arrowFloatingPoint:
+			c.Type = "float"
+			precision := typ.int16Field(0, 0)
+			if precision < 0 || precision > 2 {
+				return nil, fmt.Errorf("column '%s': invalid float precision %d", c.Name, precision)
+			}
+			if c.bitWidth = []int{16, 32, 64}[precision]; c.bitWidth == 16 {
+				return nil, fmt.Errorf("column '%s': half float columns are not supported", c.Name)
+			}
+		case arrowUtf8, arrowLargeUtf8:
+			c.Type = "string"
+		case arrowBinary, arrowLargeBinary:
+			c.Type = "binary"
+		case arrowBool:
+			c.Type = "boolean"
+		case arrowDecimal:
+			c.Type = "decimal"
+			c.Scale = int(typ.int32Field(1, 0))
+			if c.bitWidth = int(typ.int32Field(2, 128)); c.bitWidth != 128 {
+				return nil, fmt.Errorf("column '%s': decimal%d columns are not supported", c.Name, c.bitWidth)
+			}
+		case arrowDate:
+			c.Type = "date"
+			// DAY or MILLISECOND
+			c.unit = int(typ.int16Field(0, 1))
+		case arrowTime:
+			c.Type = "time"
+			c.unit = int(typ.int16Field(0, 1))
+			if c.bitWidth = int(typ.int32Field(1, 32)); c.bitWidth != 32 && c.bitWidth != 64 {
+				return nil, fmt.Errorf("column '%s': invalid time bit width %d", c.Name, c.bitWidth)
+			}
+		case arrowTimestamp:
+			c.Type = "timestamp"
+			c.unit = int(typ.int16Field(0, 0))
+			c.timezone = typ.stringField(1)
+		default:
+			return nil, fmt.Errorf("column '%s': unsupported arrow type %d", c.Name, c.typeId)
+		}
+	}
+	return columns, nil
+}
+
+type arrowBuffer struct {
+	offset, length int64
+}
+
+// decodeArrowBatch converts columns of the record batch to values
+func decodeArrowBatch(columns []arrowColumn, batch fbTable, body []byte) ([][]any, int, error) {
+	if _, ok := batch.tableField(3); ok {
+		return nil, 0, fmt.Errorf("compressed arrow record batches are not supported")
+	}
+	length := batch.int64Field(0, 0)
+	if length < 0 || length > maxArrowBatchRows {
+		return nil, 0, fmt.Errorf("invalid arrow record batch length %d", length)
+	}
+	nodes, err := batch.vectorField(1, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	rawBuffers, err := batch.vectorField(2, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	if nodes.len() != len(columns) {
+		return nil, 0, fmt.Errorf("arrow record batch has %d field nodes, schema has %d columns", nodes.len(), len(columns))
+	}
+	nextBuffer := 0
+	buffer := func() ([]byte, error) {
+		if nextBuffer >= rawBuffers.len() {
+			return nil, fmt.Errorf("arrow record batch has not enough buffers")
+		}
+		s := rawBuffers.structAt(nextBuffer, 16)
+		nextBuffer++
+		b := arrowBuffer{offset: int64(binary.LittleEndian.Uint64(s)), length: int64(binary.LittleEndian.Uint64(s[8:]))}
+		if b.offset < 0 || b.length < 0 || b.offset > int64(len(body)) || b.length > int64(len(body))-b.offset {
+			return nil, fmt.Errorf("arrow buffer [%d:%d] is out of the body of %d bytes", b.offset, b.offset+b.length, len(body))
+		}
+		return body[b.offset : b.offset+b.length], nil
+	}
+	result := make([][]any, len(columns))
+	for i := range columns {
+		c := &columns[i]
+		node := nodes.structAt(i, 16)
+		if n := int64(binary.LittleEndian.Uint64(node)); n != length {
+			return nil, 0, fmt.Errorf("column '%s' has %d values, record batch has %d rows", c.Name, n, length)
+		}
+		n := int(length)
+		if c.typeId == arrowNull {
+			result[i] = make([]any, n)
+			continue
+		}
+		validity, err := buffer()
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := buffer()
+		if err != nil {
+			return nil, 0, err
+		}
+		var content []byte
+		if c.typeId == arrowUtf8 || c.typeId == arrowLargeUtf8 || c.typeId == arrowBinary || c.typeId == arrowLargeBinary {
+			if content, err = buffer(); err != nil {
+				return nil, 0, err
+			}
+		}
+		// buffers must hold n values before n values are allocated
+		if (len(validity) > 0 && len(validity) < (n+7)/8) || len(data) < c.dataLength(n) {
+			return nil, 0, fmt.Errorf("column '%s': buffers are shorter than %d values", c.Name, n)
+		}
+		values := make([]any, n)
+		result[i] = values
+		for row := 0; row < n; row++ {
+			if len(validity) > 0 && validity[row>>3]&(1<<(row&7)) == 0 {
+				continue
+			}
+			if values[row], err = c.value(data, content, row); err != nil {
+				return nil, 0, fmt.Errorf("column '%s' row %d: %w", c.Name, row, err)
+			}
+		}
+	}
+	return result, int(length), nil
+}
+
+// dataLength is the minimal length of the data buffer of n values
+func (c *arrowColumn) dataLength(n int) int {
+	switch c.typeId {
+	case arrowBool:
+		return (n + 7) / 8
+	case arrowInt, arrowFloatingPoint, arrowTime:
+		return n * c.bitWidth / 8
+	case arrowDecimal:
+		return n * 16
+	case arrowDate:
+		if c.unit == 0 {
+			return n * 4
+		}
+		return n * 8
+	case arrowTimestamp, arrowLargeUtf8, arrowLargeBinary:
+		// large offsets have n+1 entries, checked by value
+		return n * 8
+	default:
+		return n * 4
+	}
+}
+
+// value decodes the value of the row from the column data. content is the value data of variable length columns
+func (c *arrowColumn) value(data, content []byte, row int) (any, error) {
+	fixed := func(width int) ([]byte, error) {
+		if (row+1)*width > len(data) {
+			return nil, fmt.Errorf("value is out of the buffer")
+		}
+		return data[row*width : (row+1)*width], nil
+	}
+	switch c.typeId {
+	case arrowBool:
+		if row>>3 >= len(data) {
+			return nil, fmt.Errorf("value is out of the buffer")
+		}
+		return data[row>>3]&(1<<(row&7)) != 0, nil
+	case arrowInt:
+		b, err := fixed(c.bitWidth / 8)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(arrowInteger(b, c.signed)), nil
+	case arrowFloatingPoint:
+		b, err := fixed(c.bitWidth / 8)
+		if err != nil {
+			return nil, err
+		}
+		var f float64
+		if c.bitWidth == 32 {
+			f = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		} else {
+			f = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, nil
+		}
+		return json.Number(strconv.FormatFloat(f, 'f', -1, c.bitWidth)), nil
+	case arrowDecimal:
+		b, err := fixed(16)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(arrowDecimalString(b, c.Scale)), nil
+	case arrowDate:
+		if c.unit == 0 {
+			b, err := fixed(4)
+			if err != nil {
+				return nil, err
+			}
+			days := int64(int32(binary.LittleEndian.Uint32(b)))
+			return time.Unix(days*86400, 0).UTC().Format(time.DateOnly), nil
+		}
+		b, err := fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return time.UnixMilli(int64(binary.LittleEndian.Uint64(b))).UTC().Format(time.DateOnly), nil
+	case arrowTime:
+		b, err := fixed(c.bitWidth / 8)
+		if err != nil {
+			return nil, err
+		}
+		var v int64
+		if c.bitWidth == 32 {
+			v = int64(int32(binary.LittleEndian.Uint32(b)))
+		} else {
+			v = int64(binary.LittleEndian.Uint64(b))
+		}
+		return unitTime(v, c.unit).UTC().Format(timeLayout), nil
+	case arrowTimestamp:
+		b, err := fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return formatTimestamp(unitTime(int64(binary.LittleEndian.Uint64(b)), c.unit), c.timezone != ""), nil
+	default:
+		// utf8 and binary: offsets followed by content
+		var start, end int64
+		if c.typeId == arrowLargeUtf8 || c.typeId == arrowLargeBinary {
+			b, err := fixed(8)
+			if err != nil || (row+2)*8 > len(data) {
+				return nil, fmt.Errorf("value is out of the buffer")
+			}
+			start, end = int64(binary.LittleEndian.Uint64(b)), int64(binary.LittleEndian.Uint64(data[(row+1)*8:]))
+		} else {
+			b, err := fixed(4)
+			if err != nil || (row+2)*4 > len(data) {
+				return nil, fmt.Errorf("value is out of the buffer")
+			}
+			start, end = int64(int32(binary.LittleEndian.Uint32(b))), int64(int32(binary.LittleEndian.Uint32(data[(row+1)*4:])))
+		}
+		if start < 0 || end < start || end > int64(len(content)) {
+			return nil, fmt.Errorf("value is out of the buffer")
+		}
+		if c.typeId == arrowBinary || c.typeId == arrowLargeBinary {
+			return base64.StdEncoding.EncodeToString(content[start:end]), nil
+		}
+		return string(content[start:end]), nil
+	}
+}
+
+func arrowInteger(b []byte, signed bool) string {
+	switch len(b) {
+	case 1:
+		if signed {
+			return strconv.FormatInt(int64(int8(b[0])), 10)
+		}
+		return strconv.FormatUint(uint64(b[0]), 10)
+	case 2:
+		v := binary.LittleEndian.Uint16(b)
+		if signed {
+			return strconv.FormatInt(int64(int16(v)), 10)
+		}
+		return strconv.FormatUint(uint64(v), 10)
+	case 4:
+		v := binary.LittleEndian.Uint32(b)
+		if signed {
+			return strconv.FormatInt(int64(int32(v)), 10)
+		}
+		return strconv.FormatUint(uint64(v), 10)
+	default:
+		v := binary.LittleEndian.Uint64(b)
+		if signed {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatUint(v, 10)
+	}
+}
+
+// arrowDecimalString formats little endian two's complement 128-bit integer as decimal with the scale
+func arrowDecimalString(b []byte, scale int) string {
+	be := make([]byte, 16)
+	for i := range b {
+		be[15-i] = b[i]
+	}
+	return formatDecimal(signedBigEndian(be), scale)
+}
+
+// fbTable is a flatbuffers table. Arrow metadata is read with it without generated code
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+type fbVector struct {
+	buf    []byte
+	pos    int
+	length int
+}
+
+func fbRoot(buf []byte) (fbTable, error) {
+	if len(buf) < 4 {
+		return fbTable{}, fmt.Errorf("arrow metadata is too short")
+	}
+	t := fbTable{buf: buf, pos: int(binary.LittleEndian.Uint32(buf))}
+	if t.pos+4 > len(buf) {
+		return fbTable{}, fmt.Errorf("invalid arrow metadata")
+	}
+	return t, nil
+}
+
+// fieldOffset returns position of the field relative to the table. 0 if the field is not set
+func (t fbTable) fieldOffset(field int) int {
+	if t.buf == nil || t.pos+4 > len(t.buf) {
+		return 0
+	}
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if vtable < 0 || vtable+4 > len(t.buf) {
+		return 0
+	}
+	vtableSize := int(binary.LittleEndian.Uint16(t.buf[vtable:]))
+	slot := 4 + field*2
+	if slot+2 > vtableSize || vtable+slot+2 > len(t.buf) {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint16(t.buf[vtable+slot:]))
+}
+
+func (t fbTable) scalar(field int, size int) []byte {
+	off := t.fieldOffset(field)
+	if off == 0 || t.pos+off+size > len(t.buf) {
+		return nil
+	}
+	return t.buf[t.pos+off : t.pos+off+size]
+}
+
+func (t fbTable) byteField(field int, def byte) byte {
+	if b := t.scalar(field, 1); b != nil {
+		return b[0]
+	}
+	return def
+}
+
+func (t fbTable) int16Field(field int, def int16) int16 {
+	if b := t.scalar(field, 2); b != nil {
+		return int16(binary.LittleEndian.Uint16(b))
+	}
+	return def
+}
+
+func (t fbTable) int32Field(field int, def int32) int32 {
+	if b := t.scalar(field, 4); b != nil {
+		return int32(binary.LittleEndian.Uint32(b))
+	}
+	return def
+}
+
+func (t fbTable) int64Field(field int, def int64) int64 {
+	if b := t.scalar(field, 8); b != nil {
+		return int64(binary.LittleEndian.Uint64(b))
+	}
+	return def
+}
+
+// indirect follows uoffset stored in the field
+func (t fbTable) indirect(field int) (int, bool) {
+	b := t.scalar(field, 4)
+	if b == nil {
+		return 0, false
+	}
+	target := t.pos + t.fieldOffset(field) + int(binary.LittleEndian.Uint32(b))
+	if target+4 > len(t.buf) {
+		return 0, false
+	}
+	return target, true
+}
+
+func (t fbTable) tableField(field int) (fbTable, bool) {
+	pos, ok := t.indirect(field)
+	if !ok {
+		return fbTable{}, false
+	}
+	return fbTable{buf: t.buf, pos: pos}, true
+}
+
+func (t fbTable) stringField(field int) string {
+	pos, ok := t.indirect(field)
+	if !ok {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(t.buf[pos:]))
+	if pos+4+n > len(t.buf) {
+		return ""
+	}
+	return string(t.buf[pos+4 : pos+4+n])
+}
+
+// vectorField returns the vector of elements of the size. The vector must be within the buffer
+func (t fbTable) vectorField(field int, size int) (fbVector, error) {
+	pos, ok := t.indirect(field)
+	if !ok {
+		return fbVector{}, nil
+	}
+	length := int(binary.LittleEndian.Uint32(t.buf[pos:]))
+	if length > (len(t.buf)-pos-4)/size {
+		return fbVector{}, fmt.Errorf("arrow metadata vector of %d elements is out of the metadata", length)
+	}
+	return fbVector{buf: t.buf, pos: pos + 4, length: length}, nil
+}
+
+func (v fbVector) len() int {
+	return v.length
+}
+
+func (v fbVector) table(i int) fbTable {
+	pos := v.pos + i*4
+	if pos+4 > len(v.buf) {
+		return fbTable{}
+	}
+	return fbTable{buf: v.buf, pos: pos + int(binary.LittleEndian.Uint32(v.buf[pos:]))}
+}
+
+// structAt returns i-th struct of the vector of structs of the size
+func (v fbVector) structAt(i int, size int) []byte {
+	pos := v.pos + i*size
+	if pos+size > len(v.buf) {
+		return make([]byte, size)
+	}
+	return v.buf[pos : pos+size]
+}
@@ -0,0 +1,313 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fbObject is a flatbuffers table for building test data. Fields are nil (not set), []byte (inline scalar),
+// string, fbObject, []fbObject (vector of tables) or [][]byte (vector of structs)
+type fbObject []any
+
+// fbBuild serializes the root table. Children are written after parents, so all uoffsets are positive
+func fbBuild(root fbObject) []byte {
+	buf := make([]byte, 4)
+	pos := fbWrite(&buf, root)
+	binary.LittleEndian.PutUint32(buf, uint32(pos))
+	return buf
+}
+
+func fbWrite(buf *[]byte, obj fbObject) int {
+	vtable := len(*buf)
+	offsets := make([]int, len(obj))
+	size := 4
+	for i, f := range obj {
+		switch v := f.(type) {
+		case nil:
+		case []byte:
+			offsets[i] = size
+			size += len(v)
+		default:
+			offsets[i] = size
+			size += 4
+		}
+	}
+	*buf = binary.LittleEndian.AppendUint16(*buf, uint16(4+2*len(obj)))
+	*buf = binary.LittleEndian.AppendUint16(*buf, uint16(size))
+	for _, off := range offsets {
+		*buf = binary.LittleEndian.AppendUint16(*buf, uint16(off))
+	}
+	table := len(*buf)
+	*buf = binary.LittleEndian.AppendUint32(*buf, uint32(table-vtable))
+	*buf = append(*buf, make([]byte, size-4)...)
+	for i, f := range obj {
+		slot := table + offsets[i]
+		switch v := f.(type) {
+		case nil:
+		case []byte:
+			copy((*buf)[slot:], v)
+		case string:
+			fbPatch(*buf, slot, len(*buf))
+			*buf = binary.LittleEndian.AppendUint32(*buf, uint32(len(v)))
+			*buf = append(append(*buf, v...), 0)
+		case fbObject:
+			fbPatch(*buf, slot, fbWrite(buf, v))
+		case []fbObject:
+			fbPatch(*buf, slot, len(*buf))
+			*buf = binary.LittleEndian.AppendUint32(*buf, uint32(len(v)))
+			elements := len(*buf)
+			*buf = append(*buf, make([]byte, 4*len(v))...)
+			for j, child := range v {
+				fbPatch(*buf, elements+4*j, fbWrite(buf, child))
+			}
+		case [][]byte:
+			fbPatch(*buf, slot, len(*buf))
+			*buf = binary.LittleEndian.AppendUint32(*buf, uint32(len(v)))
+			for _, s := range v {
+				*buf = append(*buf, s...)
+			}
+		}
+	}
+	return table
+}
+
+func fbPatch(buf []byte, slot int, target int) {
+	binary.LittleEndian.PutUint32(buf[slot:], uint32(target-slot))
+}
+
+func le16(v int16) []byte { return binary.LittleEndian.AppendUint16(nil, uint16(v)) }
+func le32(v int32) []byte { return binary.LittleEndian.AppendUint32(nil, uint32(v)) }
+func le64(v int64) []byte { return binary.LittleEndian.AppendUint64(nil, uint64(v)) }
+
+func arrowMessage(headerType byte, header fbObject, body []byte) []byte {
+	metadata := fbBuild(fbObject{le16(4), []byte{headerType}, header, le64(int64(len(body)))})
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, 0xFFFFFFFF)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(metadata)))
+	return append(append(b, metadata...), body...)
+}
+
+// arrowColumnData is a column of a test record batch: validity bitmap (nil if all valid) and data buffers
+type arrowColumnData struct {
+	validity []byte
+	buffers  [][]byte
+}
+
+func arrowBatch(length int, columns []arrowColumnData) []byte {
+	var body []byte
+	var nodes, buffers [][]byte
+	addBuffer := func(b []byte) {
+		buffers = append(buffers, append(le64(int64(len(body))), le64(int64(len(b)))...))
+		body = append(body, b...)
+	}
+	for _, c := range columns {
+		nodes = append(nodes, append(le64(int64(length)), le64(0)...))
+		addBuffer(c.validity)
+		for _, b := range c.buffers {
+			addBuffer(b)
+		}
+	}
+	return arrowMessage(arrowRecordBatchMessage, fbObject{le64(int64(length)), nodes, buffers}, body)
+}
+
+func testArrowData() []byte {
+	field := func(name string, typeId byte, typ fbObject) fbObject {
+		return fbObject{name, []byte{1}, []byte{typeId}, typ}
+	}
+	schema := arrowMessage(arrowSchemaMessage, fbObject{nil, []fbObject{
+		field("id", arrowInt, fbObject{le32(64), []byte{1}}),
+		field("name", arrowUtf8, fbObject{}),
+		field("cost", arrowDecimal, fbObject{le32(10), le32(2)}),
+		field("day", arrowDate, fbObject{le16(0)}),
+		field("ts", arrowTimestamp, fbObject{le16(2), "UTC"}),
+		field("flag", arrowBool, fbObject{}),
+		field("ratio", arrowFloatingPoint, fbObject{le16(2)}),
+	}}, nil)
+	decimal := func(v int64) []byte {
+		hi := int64(0)
+		if v < 0 {
+			hi = -1
+		}
+		return append(le64(v), le64(hi)...)
+	}
+	concat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	batch1 := arrowBatch(2, []arrowColumnData{
+		{buffers: [][]byte{concat(le64(1), le64(-2))}},
+		{validity: []byte{0b01}, buffers: [][]byte{concat(le32(0), le32(3), le32(3)), []byte("foo")}},
+		{buffers: [][]byte{concat(decimal(12345), decimal(-5))}},
+		{buffers: [][]byte{concat(le32(19874), le32(0))}},
+		{buffers: [][]byte{concat(le64(1717243200123456), le64(0))}},
+		{buffers: [][]byte{{0b10}}},
+		{buffers: [][]byte{concat(le64(int64(math.Float64bits(0.25))), le64(int64(math.Float64bits(math.NaN()))))}},
+	})
+	batch2 := arrowBatch(1, []arrowColumnData{
+		{buffers: [][]byte{le64(3)}},
+		{buffers: [][]byte{concat(le32(0), le32(3)), []byte("bar")}},
+		{validity: []byte{0}, buffers: [][]byte{decimal(0)}},
+		{buffers: [][]byte{le32(1)}},
+		{buffers: [][]byte{le64(1)}},
+		{buffers: [][]byte{{0}}},
+		{buffers: [][]byte{le64(int64(math.Float64bits(1e21)))}},
+	})
+	return concat(schema, batch1, batch2, le32(-1), le32(0))
+}
+
+func TestArrowReader(t *testing.T) {
+	data := testArrowData()
+	expected := []map[string]any{
+		{"id": json.Number("1"), "name": "foo", "cost": json.Number("123.45"), "day": "2024-05-31",
+			"ts": "2024-06-01T12:00:00.123456Z", "flag": false, "ratio": json.Number("0.25")},
+		{"id": json.Number("-2"), "name": nil, "cost": json.Number("-0.05"), "day": "1970-01-01",
+			"ts": "1970-01-01T00:00:00Z", "flag": true, "ratio": nil},
+		{"id": json.Number("3"), "name": "bar", "cost": nil, "day": "1970-01-02",
+			"ts": "1970-01-01T00:00:00.000001Z", "flag": false, "ratio": json.Number("1000000000000000000000")},
+	}
+	// file format wraps the stream with magic
+	file := append(append([]byte("ARROW1\x00\x00"), data...), []byte("footerARROW1")...)
+	for _, input := range [][]byte{data, file} {
+		reader, err := NewArrowReader(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if columns := reader.Columns(); len(columns) != 7 || columns[2].Type != "decimal" || columns[2].Scale != 2 || columns[4].Type != "timestamp" {
+			t.Errorf("unexpected columns %+v", columns)
+		}
+		var rows []map[string]any
+		for {
+			row, err := reader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, row)
+		}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Errorf("rows = %v\nwant %v", rows, expected)
+		}
+	}
+}
+
+func TestArrowReaderUnsupported(t *testing.T) {
+	schema := arrowMessage(arrowSchemaMessage, fbObject{nil, []fbObject{
+		{"tags", []byte{1}, []byte{12}, fbObject{}, nil, []fbObject{{"item", []byte{1}, []byte{arrowUtf8}, fbObject{}}}},
+	}}, nil)
+	if _, err := NewArrowReader(bytes.NewReader(schema)); err == nil {
+		t.Error("nested columns must be rejected")
+	}
+	if _, err := NewArrowReader(bytes.NewReader([]byte("not arrow"))); err == nil {
+		t.Error("invalid data must be rejected")
+	}
+}
+
+func TestArrowReaderFixtures(t *testing.T) {
+	// values written to the files
+	const expected = `[
+		{"i8":-128,"u16":0,"i64":-9223372036854775808,"f32":1.5,"f64":3.14159,"dec":123.45,"s":"hello","ls":"large","b":"AAEC","flag":true,
+			"d32":"2024-05-31","d64":"2024-06-01","t32":"12:34:56.789","t64":"12:34:56.789012","ts":"2024-06-01T12:00:00.123456Z","tsn":"2024-06-01T12:00:00.123456789","n":null},
+		{"i8":null,"u16":null,"i64":null,"f32":null,"f64":null,"dec":null,"s":null,"ls":null,"b":null,"flag":null,
+			"d32":null,"d64":null,"t32":null,"t64":null,"ts":null,"tsn":null,"n":null},
+		{"i8":127,"u16":65535,"i64":9223372036854775807,"f32":-0.25,"f64":0.0000001,"dec":-0.05,"s":"wörld","ls":"","b":"","flag":false,
+			"d32":"1969-12-31","d64":"1970-01-01","t32":"00:00:00","t64":"00:00:00.000001","ts":"1969-12-31T23:59:59.999999Z","tsn":"1970-01-01T00:00:00.000000001","n":null},
+		{"i8":1,"u16":1,"i64":1,"f32":1,"f64":1,"dec":1.00,"s":"second batch","ls":null,"b":null,"flag":true,
+			"d32":"1970-01-02","d64":null,"t32":null,"t64":null,"ts":null,"tsn":null,"n":null}
+	]`
+	var want []map[string]any
+	decoder := json.NewDecoder(strings.NewReader(expected))
+	decoder.UseNumber()
+	if err := decoder.Decode(&want); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"testdata/types.arrows", "testdata/types.arrow"} {
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			reader, err := NewArrowReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if columns := reader.Columns(); len(columns) != 17 || columns[5].Type != "decimal" || columns[5].Scale != 2 {
+				t.Errorf("unexpected columns %+v", columns)
+			}
+			var rows []map[string]any
+			for {
+				row, err := reader.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if !reflect.DeepEqual(rows, want) {
+				t.Errorf("rows = %v\nwant %v", rows, want)
+			}
+		})
+	}
+}
+
+func TestArrowReaderCorrupt(t *testing.T) {
+	schema := arrowMessage(arrowSchemaMessage, fbObject{nil, []fbObject{
+		{"id", []byte{1}, []byte{arrowInt}, fbObject{le32(64), []byte{1}}},
+	}}, nil)
+	batch := func(length int64, buffer []byte) []byte {
+		return arrowMessage(arrowRecordBatchMessage, fbObject{le64(length), [][]byte{append(le64(length), le64(0)...)},
+			[][]byte{append(le64(0), le64(0)...), buffer}}, le64(1))
+	}
+	tests := map[string][]byte{
+		"huge metadata":         append(le32(-1), le32(math.MaxInt32)...),
+		"truncated metadata":    append(append(le32(-1), le32(1<<20)...), 1, 2, 3),
+		"huge batch":            batch(math.MaxInt64, append(le64(0), le64(8)...)),
+		"negative batch":        batch(-1, append(le64(0), le64(8)...)),
+		"rows over the buffers": batch(1<<20, append(le64(0), le64(8)...)),
+		"buffer out of body":    batch(1, append(le64(math.MaxInt64), le64(math.MaxInt64)...)),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			reader, err := NewArrowReader(bytes.NewReader(append(append([]byte{}, schema...), data...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := reader.Next(); err == nil || err == io.EOF {
+				t.Errorf("corrupt data must be rejected, got %v", err)
+			}
+		})
+	}
+	vector := fbBuild(fbObject{le16(4), []byte{arrowSchemaMessage}, fbObject{nil, []fbObject{}}, le64(0)})
+	// the fields vector claims 2^32-1 columns
+	binary.LittleEndian.PutUint32(vector[len(vector)-4:], math.MaxUint32)
+	data := append(append(le32(-1), le32(int32(len(vector)))...), vector...)
+	if _, err := NewArrowReader(bytes.NewReader(data)); err == nil {
+		t.Error("vector out of the metadata must be rejected")
+	}
+}
+
+// FuzzArrowReader checks that corrupt data is rejected with errors and doesn't panic or allocate unbounded
+func FuzzArrowReader(f *testing.F) {
+	f.Add(testArrowData())
+	for _, name := range []string{"testdata/types.arrows", "testdata/types.arrow"} {
+		if fixture, err := os.ReadFile(name); err == nil {
+			f.Add(fixture)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader, err := NewArrowReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		reader.Columns()
+		for err == nil {
+			_, err = reader.Next()
+		}
+	})
+}
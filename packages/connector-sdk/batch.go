@@ -0,0 +1,440 @@
+package sdk
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Classes of row-error replies, see protocol RowErrorMessage
+const (
+	RowErrorValidation = "validation"
+	RowErrorApi        = "api"
+	RowErrorRateLimit  = "rate-limit"
+)
+
+// BatchEvent is an event of API that accepts events in batches, e.g. Facebook Conversions API or TikTok Events API.
+// Events are deduplicated by name and id
+type BatchEvent interface {
+	// Key returns name and id of the event
+	Key() (name string, id string)
+	// SetId replaces id of the event
+	SetId(id string)
+}
+
+// BatchError is an error response of API. Errors of other types, e.g. network errors, are retried and reported
+// with api class
+type BatchError interface {
+	error
+	// Retryable returns true if the request may succeed if retried
+	Retryable() bool
+	// RateLimited returns true if the request was throttled
+	RateLimited() bool
+}
+
+// BatchResponse is a response of API to the accepted batch
+type BatchResponse struct {
+	// Accepted is the number of events API accepted
+	Accepted int
+	// TraceId identifies the request in logs of API
+	TraceId string
+	// Messages are warnings of API about the batch
+	Messages []string
+}
+
+// BatchStatus is stream-result of BatchedDestination
+type BatchStatus struct {
+	Received int `json:"received"`
+	Success  int `json:"success"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+	// Duplicates is the number of rows skipped because an event with the same id was sent earlier in the run
+	Duplicates int `json:"duplicates"`
+	// DedupSkipped is the number of rows skipped because the event was delivered by a previous run
+	DedupSkipped int `json:"dedupSkipped,omitempty"`
+	// Exploded is the number of events added by exploding rows with arrayHandling
+	Exploded int `json:"exploded,omitempty"`
+}
+
+type BatchOptions struct {
+	// Api is the name of API in logs, e.g. "Conversions API"
+	Api string
+	// MaxBatchSize is the maximum number of events in one request. batchSize option may only lower it
+	MaxBatchSize int
+	// EventColumn is the column with the event name. Failed rows are reported with values of it and event_id column
+	EventColumn string
+	// DedupPrefix is the prefix of state keys of events delivered by previous runs, see DedupStore
+	DedupPrefix []string
+	// MaxAttempts is the number of attempts to send a batch, DefaultBatchMaxAttempts if not set
+	MaxAttempts int
+	// RetryDelay is the delay before the first retry, doubled for subsequent ones. DefaultBatchRetryDelay if not set
+	RetryDelay time.Duration
+}
+
+const DefaultBatchMaxAttempts = 4
+
+const DefaultBatchRetryDelay = time.Second
+
+// BatchedDestination sends rows as events in batches. Connectors embed it, set NewEvent and Send and call Start
+// from StartStream, Row and EndStream are implemented by BatchedDestination:
+//   - rows are exploded with arrayHandling option. Events of exploded rows get id suffixed with the number of the event
+//   - events with id sent earlier in the run are skipped, with dedupTtlDays option events delivered by previous runs
+//     are skipped too
+//   - rejected batches are retried with exponential backoff. Events of the batch that failed anyway are counted
+//     as failed and reported with row-error
+//   - under memory pressure events are sent right away rather than held in batches
+//
+// Customer information is not sent back in row-error, rows are identified by event name and id only
+type BatchedDestination[E BatchEvent] struct {
+	BatchOptions
+	// NewEvent converts the row to event. Returns error if the row is invalid, such events would make API reject
+	// the whole batch
+	NewEvent func(row map[string]any) (E, error)
+	// Send sends the batch in a single request. BatchError tells if the batch may be retried
+	Send    func(ctx context.Context, events []E) (*BatchResponse, error)
+	Session *Session
+	Status  BatchStatus
+
+	stream       string
+	batchSize    int
+	rateLimiter  *RateLimiter
+	batch        []E
+	seenEventIds map[string]bool
+	dedup        *DedupStore
+	arrays       *ArrayHandling
+	startTime    time.Time
+	// batchOrdinals are ordinals of rows of the batch, held until the batch is sent
+	batchOrdinals []int64
+}
+
+// Start reads batchSize, rate limit, dedup and arrayHandling options from credentials. Returns HTTP client for
+// requests to API: it's rate limited, limited by session Limits, measured by session Metrics and captured
+// with DEBUG_CAPTURE_DIR
+func (d *BatchedDestination[E]) Start(stream string, session *Session, creds map[string]any, options BatchOptions) (*http.Client, error) {
+	d.BatchOptions = options
+	if d.MaxAttempts == 0 {
+		d.MaxAttempts = DefaultBatchMaxAttempts
+	}
+	if d.RetryDelay == 0 {
+		d.RetryDelay = DefaultBatchRetryDelay
+	}
+	d.Session = session
+	d.Status = BatchStatus{}
+	d.stream = stream
+	d.batchSize = options.MaxBatchSize
+	if batchSize, ok := creds["batchSize"].(float64); ok {
+		if batchSize < 1 || batchSize > float64(options.MaxBatchSize) {
+			return nil, fmt.Errorf("batchSize must be between 1 and %d, got %v", options.MaxBatchSize, batchSize)
+		}
+		d.batchSize = int(batchSize)
+	}
+	rateLimiter, err := RateLimiterFromCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+	d.rateLimiter = rateLimiter
+	dedupOptions, dedupEnabled, err := DedupOptionsFromCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+	d.dedup = nil
+	if dedupEnabled {
+		d.dedup = NewDedupStore(session.State, dedupOptions, options.DedupPrefix...)
+	}
+	d.arrays, err = ArrayHandlingFromCredentials(creds)
+	if err != nil {
+		return nil, err
+	}
+	capture, err := CaptureFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if capture != nil {
+		session.Warn(fmt.Sprintf("Capturing requests to %s to %s", options.Api, capture.Dir()))
+	}
+	d.batch, d.batchOrdinals = nil, nil
+	d.seenEventIds = map[string]bool{}
+	d.startTime = time.Now()
+	// latency is measured after the rate limiter and connection limit waits
+	transport := session.Limits.Transport(session.Metrics.Transport(capture.Transport(http.DefaultTransport)))
+	return &http.Client{Timeout: time.Minute, Transport: rateLimiter.Transport(transport)}, nil
+}
+
+// Started logs start of the stream with details of the destination, e.g. "Pixel: 123"
+func (d *BatchedDestination[E]) Started(details string) {
+	dryRun := ""
+	if d.Session.DryRun != nil {
+		dryRun = " Dry run: nothing is sent."
+	}
+	d.Session.Info(fmt.Sprintf("Stream '%s' started. %s Batch size: %d.%s", d.stream, details, d.batchSize, dryRun))
+}
+
+func (d *BatchedDestination[E]) Row(ctx context.Context, row map[string]any) error {
+	d.Status.Received++
+	rows, err := d.arrays.Apply(row)
+	if err != nil {
+		d.Status.Failed++
+		d.replyRowError(row, "", RowErrorValidation, false, err.Error())
+		return nil
+	}
+	d.Status.Exploded += len(rows) - 1
+	for i, r := range rows {
+		if err = d.event(ctx, r, i, len(rows)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// event adds the event of the row to the batch. Events of exploded rows get id suffixed with the number of
+// the event, so they are not deduplicated with each other
+func (d *BatchedDestination[E]) event(ctx context.Context, row map[string]any, i int, exploded int) error {
+	event, err := d.NewEvent(row)
+	if err != nil {
+		d.Status.Failed++
+		d.replyRowError(row, "", RowErrorValidation, false, err.Error())
+		return nil
+	}
+	name, id := event.Key()
+	if exploded > 1 {
+		id = fmt.Sprintf("%s.%d", id, i)
+		event.SetId(id)
+	}
+	if d.seenEventIds[id] {
+		d.Status.Skipped++
+		d.Status.Duplicates++
+		return nil
+	}
+	d.seenEventIds[id] = true
+	delivered, err := d.dedup.Seen(name + "\x00" + id)
+	if err != nil {
+		// the event is sent, API deduplicates events it received recently
+		d.Session.Error("Error checking delivered events", err.Error())
+	}
+	if delivered {
+		d.Status.Skipped++
+		d.Status.DedupSkipped++
+		return nil
+	}
+	d.batch = append(d.batch, event)
+	if ordinal, ok := d.Session.HoldRow(); ok {
+		d.batchOrdinals = append(d.batchOrdinals, ordinal)
+	}
+	d.Session.Metrics.SetQueueDepth(len(d.batch))
+	// under memory pressure events are sent right away rather than held in batches
+	if len(d.batch) >= d.batchSize || d.Session.Limits.MemoryPressure() {
+		return d.flush(ctx)
+	}
+	return nil
+}
+
+func (d *BatchedDestination[E]) EndStream(ctx context.Context) (any, error) {
+	if err := d.flush(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.dedup.Flush(); err != nil {
+		d.Session.Error("Error saving delivered events", err.Error())
+	}
+	if d.rateLimiter != nil {
+		waits, waited := d.rateLimiter.Stats()
+		d.Session.Info(fmt.Sprintf("Rate limit: %d requests waited %s in total", waits, waited.Round(time.Millisecond)))
+	}
+	d.Session.Info(fmt.Sprintf("Stream '%s' finished. %d rows received, %d events sent in %s", d.stream, d.Status.Received, d.Status.Success, time.Since(d.startTime)))
+	return d.Status, nil
+}
+
+// flush sends the current batch. Events of rejected batch are counted as failed and reported with row-error.
+// Rows of the batch are delivered unless the batch may be retried. Returns error if the context is canceled or
+// batch sent hook fails
+func (d *BatchedDestination[E]) flush(ctx context.Context) error {
+	if len(d.batch) == 0 {
+		return nil
+	}
+	batch, ordinals := d.batch, d.batchOrdinals
+	d.batch, d.batchOrdinals = nil, nil
+	d.Session.Metrics.SetQueueDepth(0)
+	if d.Session.DryRun != nil {
+		payloads := make([]any, len(batch))
+		for i, event := range batch {
+			payloads[i] = event
+		}
+		d.Session.DryRun.Request(payloads...)
+		d.Status.Success += len(batch)
+		d.delivered(ordinals)
+		return nil
+	}
+	response, err := d.send(ctx, batch)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		d.Status.Failed += len(batch)
+		d.Session.Error(fmt.Sprintf("Batch of %d events rejected", len(batch)), err.Error())
+		class, retryable := RowErrorApi, true
+		var be BatchError
+		if errors.As(err, &be) {
+			retryable = be.Retryable()
+			if be.RateLimited() {
+				class = RowErrorRateLimit
+			}
+		}
+		for _, event := range batch {
+			name, id := event.Key()
+			d.replyRowError(map[string]any{d.EventColumn: name}, id, class, retryable, err.Error())
+		}
+		if !retryable {
+			d.delivered(ordinals)
+		}
+		return nil
+	}
+	d.delivered(ordinals)
+	d.Status.Success += len(batch)
+	for _, event := range batch {
+		name, id := event.Key()
+		d.dedup.Mark(name + "\x00" + id)
+	}
+	if response.Accepted != len(batch) {
+		d.Session.Warn(fmt.Sprintf("%s received %d events of %d sent", d.Api, response.Accepted, len(batch)), response.TraceId)
+	}
+	for _, message := range response.Messages {
+		d.Session.Warn(d.Api+": "+message, response.TraceId)
+	}
+	d.Session.Debug(fmt.Sprintf("Batch of %d events sent", len(batch)), response.TraceId)
+	if err = d.Session.Hooks.BatchSent(ctx, BatchInfo{Rows: len(batch), Accepted: response.Accepted}); err != nil {
+		return fmt.Errorf("batch sent hook failed: %w", err)
+	}
+	return nil
+}
+
+// send sends the batch, retrying errors other than non-retryable BatchError with exponential backoff
+func (d *BatchedDestination[E]) send(ctx context.Context, batch []E) (*BatchResponse, error) {
+	delay := d.RetryDelay
+	for attempt := 1; ; attempt++ {
+		response, err := d.Send(ctx, batch)
+		if err == nil {
+			return response, nil
+		}
+		var be BatchError
+		if (errors.As(err, &be) && !be.Retryable()) || attempt >= d.MaxAttempts {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		d.Session.Metrics.AddRetry()
+		delay *= 2
+	}
+}
+
+func (d *BatchedDestination[E]) delivered(ordinals []int64) {
+	for _, ordinal := range ordinals {
+		d.Session.RowDelivered(ordinal)
+	}
+}
+
+// replyRowError reports the failed row. Rows are identified by event name and id, customer information
+// is not sent back
+func (d *BatchedDestination[E]) replyRowError(row map[string]any, eventId string, class string, retryable bool, message string) {
+	key := map[string]any{}
+	if name := StringValue(row[d.EventColumn]); name != "" {
+		key[d.EventColumn] = name
+	}
+	if eventId == "" {
+		eventId = StringValue(row["event_id"])
+	}
+	payload := map[string]any{
+		"key":       key,
+		"class":     class,
+		"retryable": retryable,
+		"message":   message,
+	}
+	if eventId != "" {
+		payload["insertId"] = eventId
+	}
+	_ = d.Session.Reply("row-error", payload)
+}
+
+// HashSha256 returns SHA-256 hex of the value normalized with normalize. Values that are hashed already
+// are returned as is. Empty result means the value is empty after normalization
+func HashSha256(value string, normalize func(value string) string) string {
+	value = strings.TrimSpace(value)
+	if isSha256(value) {
+		return strings.ToLower(value)
+	}
+	value = normalize(value)
+	if value == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+func isSha256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// DeriveEventId returns id that is the same for the same event, so events resent by later runs are deduplicated.
+// Rows without event_time get current time, so only rows with event_time are deduplicated across runs
+func DeriveEventId(name string, eventTime int64, user map[string]string) string {
+	params := make([]string, 0, len(user))
+	for param := range user {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%d", name, eventTime)
+	for _, param := range params {
+		_, _ = fmt.Fprintf(h, "\x00%s=%s", param, user[param])
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// ParseEventTime parses unix timestamp in seconds or milliseconds, or ISO 8601 string. Returns now if v is not set
+func ParseEventTime(v any, now time.Time) (time.Time, error) {
+	s := StringValue(v)
+	if s == "" {
+		return now, nil
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(int64(n)), nil
+		}
+		return time.Unix(int64(n), 0), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", time.DateOnly} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("event_time must be unix timestamp or ISO 8601 string, got %q", s)
+}
+
+// StringValue returns string representation of scalar value. Empty string for nil
+func StringValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
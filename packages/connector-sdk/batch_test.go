@@ -0,0 +1,220 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEvent struct {
+	Name string `json:"name"`
+	Id   string `json:"id"`
+}
+
+func (e *testEvent) Key() (string, string) {
+	return e.Name, e.Id
+}
+
+func (e *testEvent) SetId(id string) {
+	e.Id = id
+}
+
+type testBatchError struct {
+	retryable bool
+}
+
+func (e *testBatchError) Error() string {
+	return fmt.Sprintf("rejected, retryable: %v", e.retryable)
+}
+
+func (e *testBatchError) Retryable() bool {
+	return e.retryable
+}
+
+func (e *testBatchError) RateLimited() bool {
+	return e.retryable
+}
+
+// startTestDestination starts destination with batches of 2 events, sent with send
+func startTestDestination(t *testing.T, creds map[string]any, session *Session, send func(events []*testEvent) (*BatchResponse, error)) *BatchedDestination[*testEvent] {
+	d := &BatchedDestination[*testEvent]{}
+	_, err := d.Start("events", session, creds, BatchOptions{Api: "Test API", MaxBatchSize: 2, EventColumn: "name", RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.NewEvent = func(row map[string]any) (*testEvent, error) {
+		name := StringValue(row["name"])
+		if name == "" {
+			return nil, errors.New("name is required")
+		}
+		return &testEvent{Name: name, Id: StringValue(row["event_id"])}, nil
+	}
+	d.Send = func(ctx context.Context, events []*testEvent) (*BatchResponse, error) {
+		return send(events)
+	}
+	return d
+}
+
+func rowErrors(out *bytes.Buffer) []map[string]any {
+	var result []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var m struct {
+			Type    string         `json:"type"`
+			Payload map[string]any `json:"payload"`
+		}
+		if json.Unmarshal([]byte(line), &m) == nil && m.Type == "row-error" {
+			result = append(result, m.Payload)
+		}
+	}
+	return result
+}
+
+func TestBatchedDestination(t *testing.T) {
+	var out bytes.Buffer
+	var batches [][]string
+	attempts := 0
+	d := startTestDestination(t, map[string]any{}, &Session{Replier: NewReplier(&out)}, func(events []*testEvent) (*BatchResponse, error) {
+		attempts++
+		switch events[0].Name {
+		case "throttled":
+			return nil, &testBatchError{retryable: true}
+		case "invalid":
+			return nil, &testBatchError{retryable: false}
+		}
+		var ids []string
+		for _, event := range events {
+			ids = append(ids, event.Id)
+		}
+		batches = append(batches, ids)
+		return &BatchResponse{Accepted: len(events)}, nil
+	})
+	ctx := context.Background()
+	rows := []map[string]any{
+		{"name": "lead", "event_id": "1"},
+		{"name": "lead", "event_id": "1"},
+		{"event_id": "2"},
+		{"name": "lead", "event_id": "3"},
+		{"name": "throttled", "event_id": "4"},
+		{"name": "throttled", "event_id": "5"},
+		{"name": "invalid", "event_id": "6"},
+	}
+	for _, row := range rows {
+		if err := d.Row(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := d.EndStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := BatchStatus{Received: 7, Success: 2, Skipped: 1, Failed: 4, Duplicates: 1}
+	if result != want {
+		t.Errorf("status = %+v, want %+v", result, want)
+	}
+	if len(batches) != 1 || strings.Join(batches[0], ",") != "1,3" {
+		t.Errorf("unexpected batches: %v", batches)
+	}
+	// the throttled batch is retried, the invalid one is not
+	if attempts != 1+DefaultBatchMaxAttempts+1 {
+		t.Errorf("attempts = %d", attempts)
+	}
+	errs := rowErrors(&out)
+	if len(errs) != 4 {
+		t.Fatalf("expected 4 row errors, got %v", errs)
+	}
+	tests := []struct {
+		insertId  string
+		class     string
+		retryable bool
+	}{
+		{"2", RowErrorValidation, false},
+		{"4", RowErrorRateLimit, true},
+		{"5", RowErrorRateLimit, true},
+		{"6", RowErrorApi, false},
+	}
+	for i, test := range tests {
+		e := errs[i]
+		if e["insertId"] != test.insertId || e["class"] != test.class || e["retryable"] != test.retryable {
+			t.Errorf("row error %d = %v, want %+v", i, e, test)
+		}
+	}
+	if key := errs[1]["key"].(map[string]any); key["name"] != "throttled" {
+		t.Errorf("row error must be identified by the event column: %v", key)
+	}
+}
+
+func TestBatchedDestinationDryRun(t *testing.T) {
+	dryRun := NewDryRun(DryRunSampleSize)
+	d := startTestDestination(t, map[string]any{}, &Session{Replier: NewReplier(&bytes.Buffer{}), DryRun: dryRun}, func(events []*testEvent) (*BatchResponse, error) {
+		t.Error("nothing must be sent in dry run")
+		return nil, nil
+	})
+	ctx := context.Background()
+	for _, id := range []string{"1", "2", "3"} {
+		if err := d.Row(ctx, map[string]any{"name": "lead", "event_id": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := d.EndStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := result.(BatchStatus); status.Success != 3 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	report := dryRun.Report()
+	if report["requests"] != 2 || report["payloadCount"] != 3 || report["payloads"].([]any)[0].(*testEvent).Id != "1" {
+		t.Errorf("unexpected dry-run report: %v", report)
+	}
+}
+
+func TestBatchedDestinationExplode(t *testing.T) {
+	var ids []string
+	creds := map[string]any{"arrayHandling": "explode", "arrayColumns": []any{"tags"}, "batchSize": 1.0}
+	d := startTestDestination(t, creds, &Session{Replier: NewReplier(&bytes.Buffer{})}, func(events []*testEvent) (*BatchResponse, error) {
+		ids = append(ids, events[0].Id)
+		return &BatchResponse{Accepted: len(events)}, nil
+	})
+	ctx := context.Background()
+	if err := d.Row(ctx, map[string]any{"name": "purchase", "event_id": "1", "tags": []any{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := d.EndStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := result.(BatchStatus); status.Received != 1 || status.Success != 2 || status.Exploded != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if strings.Join(ids, ",") != "1.0,1.1" {
+		t.Errorf("events of exploded row must get numbered ids: %v", ids)
+	}
+}
+
+func TestParseEventTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value any
+		want  int64
+	}{
+		{nil, now.Unix()},
+		{json.Number("1714557600"), 1714557600},
+		{1714557600000.0, 1714557600},
+		{"2024-05-01T10:00:00Z", 1714557600},
+		{"2024-05-01 10:00:00", 1714557600},
+	}
+	for _, test := range tests {
+		got, err := ParseEventTime(test.value, now)
+		if err != nil || got.Unix() != test.want {
+			t.Errorf("ParseEventTime(%v) = %v, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+	if _, err := ParseEventTime("yesterday", now); err == nil {
+		t.Error("invalid event_time must be rejected")
+	}
+}
@@ -0,0 +1,134 @@
+package sdk
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache keeps values shared by runs of a connector, e.g. OAuth tokens, ids of custom fields or exchange rates, so
+// they aren't fetched again by every run. Values are kept in memory and persisted to state under the prefix with
+// their expiration time. Values read from state are decoded JSON, so numbers are float64.
+//
+// Cache is safe for concurrent use. Concurrent GetOrLoad calls of the same key share a single load
+type Cache struct {
+	state  *RpcClient
+	prefix []string
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	loading map[string]*cacheLoad
+}
+
+type cacheEntry struct {
+	value     any
+	expiresAt time.Time
+}
+
+type cacheLoad struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// NewCache returns cache with state keys starting with prefix, e.g. ["type=hubspot.fields", "portal=123"].
+// With nil state values are kept only in memory
+func NewCache(state *RpcClient, prefix ...string) *Cache {
+	return &Cache{state: state, prefix: prefix, entries: make(map[string]cacheEntry), loading: make(map[string]*cacheLoad)}
+}
+
+func (c *Cache) stateKey(key string) []string {
+	return append(append([]string{}, c.prefix...), "key="+key)
+}
+
+// Get returns value of the key unless it's missing or expired. State is read if the value isn't in memory
+func (c *Cache) Get(key string) (any, bool, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok {
+		if time.Now().Before(entry.expiresAt) {
+			return entry.value, true, nil
+		}
+		return nil, false, nil
+	}
+	if c.state == nil {
+		return nil, false, nil
+	}
+	raw, err := c.state.Get(c.stateKey(key))
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting cached %s: %w", key, err)
+	}
+	stored, _ := raw.(map[string]any)
+	expiresAt, _ := stored["expiresAt"].(string)
+	entry.expiresAt, err = time.Parse(time.RFC3339, expiresAt)
+	if err != nil || !time.Now().Before(entry.expiresAt) {
+		return nil, false, nil
+	}
+	entry.value = stored["value"]
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+	return entry.value, true, nil
+}
+
+// Set saves value of the key for ttl. Value must be JSON serializable
+func (c *Cache) Set(key string, value any, ttl time.Duration) error {
+	entry := cacheEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	c.mu.Lock()
+	c.entries[key] = entry
+	c.mu.Unlock()
+	if c.state == nil {
+		return nil
+	}
+	err := c.state.Set(c.stateKey(key), map[string]any{
+		"value":     value,
+		"expiresAt": entry.expiresAt.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("error saving cached %s: %w", key, err)
+	}
+	return nil
+}
+
+// Delete removes value of the key, e.g. a token rejected by the API
+func (c *Cache) Delete(key string) error {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+	if c.state == nil {
+		return nil
+	}
+	if err := c.state.Del(c.stateKey(key)); err != nil {
+		return fmt.Errorf("error deleting cached %s: %w", key, err)
+	}
+	return nil
+}
+
+// GetOrLoad returns cached value of the key or loads it and caches it for ttl. Errors of reading state are treated
+// as a miss, while errors of saving loaded value are returned along with the value
+func (c *Cache) GetOrLoad(key string, ttl time.Duration, load func() (any, error)) (any, error) {
+	if value, ok, _ := c.Get(key); ok {
+		return value, nil
+	}
+	c.mu.Lock()
+	if l, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		<-l.done
+		return l.value, l.err
+	}
+	l := &cacheLoad{done: make(chan struct{})}
+	c.loading[key] = l
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.loading, key)
+		c.mu.Unlock()
+		close(l.done)
+	}()
+	l.value, l.err = load()
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.value, c.Set(key, l.value, ttl)
+}
@@ -0,0 +1,119 @@
+package sdk
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stateServer is an in-memory implementation of state RPC methods
+func stateServer(t *testing.T) (*RpcClient, map[string]any) {
+	var mu sync.Mutex
+	store := map[string]any{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Key    any `json:"key"`
+			Value  any `json:"value"`
+			Prefix any `json:"prefix"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		k, _ := json.Marshal(body.Key)
+		mu.Lock()
+		defer mu.Unlock()
+		var res any = map[string]any{}
+		switch strings.TrimPrefix(r.URL.Path, "/") {
+		case "state.get":
+			if v, ok := store[string(k)]; ok {
+				res = v
+			}
+		case "state.set":
+			store[string(k)] = body.Value
+		case "state.del":
+			delete(store, string(k))
+		case "state.list":
+			// single element prefix is sent as a string
+			if s, ok := body.Prefix.(string); ok {
+				body.Prefix = []string{s}
+			}
+			p, _ := json.Marshal(body.Prefix)
+			entries := []any{}
+			for key, value := range store {
+				if strings.HasPrefix(key, strings.TrimSuffix(string(p), "]")) {
+					var parsed []string
+					_ = json.Unmarshal([]byte(key), &parsed)
+					entries = append(entries, map[string]any{"key": parsed, "value": value})
+				}
+			}
+			res = entries
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(server.Close)
+	return NewRpcClient(server.URL), store
+}
+
+func TestCache(t *testing.T) {
+	state, store := stateServer(t)
+	cache := NewCache(state, "type=test.cache")
+	if _, ok, err := cache.Get("token"); ok || err != nil {
+		t.Fatalf("expected miss, got %v %v", ok, err)
+	}
+	if err := cache.Set("token", "secret", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store[`["type=test.cache","key=token"]`]; !ok {
+		t.Fatalf("value isn't saved to state: %v", store)
+	}
+	// a new cache, e.g. of the next run, reads the value from state
+	value, ok, err := NewCache(state, "type=test.cache").Get("token")
+	if !ok || err != nil || value != "secret" {
+		t.Errorf("Get() = %v %v %v, want secret", value, ok, err)
+	}
+	if err = cache.Set("expired", 1, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = NewCache(state, "type=test.cache").Get("expired"); ok {
+		t.Error("expired value returned")
+	}
+	if err = cache.Delete("token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ = NewCache(state, "type=test.cache").Get("token"); ok {
+		t.Error("deleted value returned")
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	cache := NewCache(nil)
+	var loads atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrLoad("rates", time.Minute, func() (any, error) {
+				loads.Add(1)
+				<-release
+				return map[string]any{"EUR": 1.1}, nil
+			})
+			if err != nil || value.(map[string]any)["EUR"] != 1.1 {
+				t.Errorf("GetOrLoad() = %v, %v", value, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Errorf("expected single load, got %d", loads.Load())
+	}
+	if _, err := cache.GetOrLoad("rates", time.Minute, func() (any, error) { t.Error("cached value loaded again"); return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,247 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DebugCaptureDirEnv enables capture of destination requests for bug reports. Each request and its response are
+// written to a numbered file of the directory:
+//
+//	{"request":{"method":"POST","url":"...","headers":{...},"body":{...}},"response":{"status":200,...},"durationMs":120}
+//
+// Credentials are redacted: headers, query parameters, JSON and form fields with names like authorization, token,
+// secret or password. Gzip-compressed bodies are decompressed. The host doesn't pass the variable to connectors
+// running in docker, so capture is never enabled in production
+const DebugCaptureDirEnv = "DEBUG_CAPTURE_DIR"
+
+const redacted = "[REDACTED]"
+
+// sensitiveNames are parts of names of headers and fields holding credentials
+var sensitiveNames = []string{"authorization", "cookie", "token", "secret", "password", "apikey", "api-key", "api_key", "signature"}
+
+// Capture writes destination requests to files. Nil Capture writes nothing
+type Capture struct {
+	dir string
+	mu  sync.Mutex
+	// count is the number of the last written file
+	count int
+}
+
+// CaptureFromEnv returns Capture to the directory set with DebugCaptureDirEnv or nil
+func CaptureFromEnv() (*Capture, error) {
+	dir := os.Getenv(DebugCaptureDirEnv)
+	if dir == "" {
+		return nil, nil
+	}
+	return NewCapture(dir)
+}
+
+// NewCapture creates the directory if needed. Numbering continues after files already in the directory
+func NewCapture(dir string) (*Capture, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("cannot create %s: %w", DebugCaptureDirEnv, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", DebugCaptureDirEnv, err)
+	}
+	return &Capture{dir: dir, count: len(entries)}, nil
+}
+
+// Dir returns the directory of captured requests
+func (c *Capture) Dir() string {
+	return c.dir
+}
+
+// Transport captures requests made with base transport
+func (c *Capture) Transport(base http.RoundTripper) http.RoundTripper {
+	if c == nil {
+		return base
+	}
+	return &captureTransport{base: base, capture: c}
+}
+
+type captureTransport struct {
+	base    http.RoundTripper
+	capture *Capture
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	entry := map[string]any{
+		"time": time.Now().UTC().Format(time.RFC3339Nano),
+		"request": map[string]any{
+			"method":  req.Method,
+			"url":     redactUrl(req.URL),
+			"headers": redactHeaders(req.Header),
+			"body":    redactBody(reqBody, req.Header),
+		},
+	}
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	entry["durationMs"] = time.Since(start).Milliseconds()
+	if err != nil {
+		entry["error"] = err.Error()
+	} else {
+		resBody, readErr := io.ReadAll(res.Body)
+		_ = res.Body.Close()
+		res.Body = io.NopCloser(bytes.NewReader(resBody))
+		response := map[string]any{
+			"status":  res.StatusCode,
+			"headers": redactHeaders(res.Header),
+			"body":    redactBody(resBody, res.Header),
+		}
+		if readErr != nil {
+			response["error"] = readErr.Error()
+		}
+		entry["response"] = response
+	}
+	t.capture.write(entry)
+	return res, err
+}
+
+// write saves the entry to the next numbered file. Capture is best effort: errors are written to stderr
+func (c *Capture) write(entry map[string]any) {
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Cannot capture request:", err.Error())
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	name := filepath.Join(c.dir, fmt.Sprintf("%06d.json", c.count))
+	if err = os.WriteFile(name, b, 0o600); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "Cannot capture request:", err.Error())
+	}
+}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if isSensitive(name) {
+			headers[name] = redacted
+		} else {
+			headers[name] = strings.Join(values, ", ")
+		}
+	}
+	return headers
+}
+
+func redactUrl(u *url.URL) string {
+	c := *u
+	if c.User != nil {
+		c.User = url.User(redacted)
+	}
+	c.RawQuery = redactValues(c.Query()).Encode()
+	return c.String()
+}
+
+func redactValues(values url.Values) url.Values {
+	for name := range values {
+		if isSensitive(name) {
+			values[name] = []string{redacted}
+		}
+	}
+	return values
+}
+
+// redactBody returns JSON body as a value with sensitive fields redacted, form body as redacted values and
+// other bodies as text
+func redactBody(body []byte, header http.Header) any {
+	if len(body) == 0 {
+		return nil
+	}
+	if strings.Contains(header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return fmt.Sprintf("<%d bytes of invalid gzip: %v>", len(body), err)
+		}
+		decompressed, err := io.ReadAll(gz)
+		if err != nil {
+			return fmt.Sprintf("<%d bytes of invalid gzip: %v>", len(body), err)
+		}
+		body = decompressed
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for name, v := range values {
+				if isSensitive(name) {
+					continue
+				}
+				// form fields of some APIs, e.g. Mixpanel, hold JSON with credentials
+				var value any
+				if len(v) == 1 && json.Unmarshal([]byte(v[0]), &value) == nil {
+					redactJson(value)
+					b, _ := json.Marshal(value)
+					values[name] = []string{string(b)}
+				}
+			}
+			return redactValues(values).Encode()
+		}
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err == nil {
+		return redactJson(value)
+	}
+	// NDJSON
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	values := make([]any, 0, len(lines))
+	for _, line := range lines {
+		var item any
+		if err := json.Unmarshal([]byte(line), &item); err != nil {
+			return string(body)
+		}
+		values = append(values, redactJson(item))
+	}
+	return values
+}
+
+// redactJson replaces values of sensitive fields in place
+func redactJson(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for name, field := range v {
+			if isSensitive(name) {
+				v[name] = redacted
+			} else {
+				redactJson(field)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			redactJson(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,54 @@
+package sdk
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=abc")
+		_, _ = w.Write([]byte(`{"events_received":1}`))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	capture, err := NewCapture(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: capture.Transport(http.DefaultTransport)}
+	body := `{"access_token":"secret-1","data":[{"event_name":"Purchase","user_data":{"em":"hash"}}]}`
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/events?access_token=secret-2&v=1", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret-3")
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(res.Body); string(b) != `{"events_received":1}` {
+		t.Errorf("response body must be passed through, got %s", b)
+	}
+	captured, err := os.ReadFile(filepath.Join(dir, "000001.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-1", "secret-2", "secret-3", "session=abc"} {
+		if strings.Contains(string(captured), secret) {
+			t.Errorf("%s must be redacted:\n%s", secret, captured)
+		}
+	}
+	for _, expected := range []string{`"event_name": "Purchase"`, `"events_received": 1`, `"status": 200`, "v=1"} {
+		if !strings.Contains(string(captured), expected) {
+			t.Errorf("expected %s in capture:\n%s", expected, captured)
+		}
+	}
+	capture, _ = NewCapture(dir)
+	if capture.count != 1 {
+		t.Errorf("numbering must continue after existing files, got %d", capture.count)
+	}
+}
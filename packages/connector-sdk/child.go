@@ -0,0 +1,209 @@
+package sdk
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ChildConfig is a connector process started by a connector that forwards rows to other connectors, e.g. tee
+// and router
+type ChildConfig struct {
+	Name                  string         `json:"name"`
+	Command               []string       `json:"command"`
+	Stream                string         `json:"stream"`
+	ConnectionCredentials map[string]any `json:"connectionCredentials"`
+	StreamOptions         map[string]any `json:"streamOptions"`
+}
+
+// ChildQueueSize is the number of messages queued for a child. A child that reads slower than others doesn't
+// stall them until its queue is full, so memory stays bounded
+const ChildQueueSize = 10000
+
+// Child is a connector process the rows are forwarded to. Logs and halts of the child are logged with the session
+// of the parent, prefixed with the child name
+type Child struct {
+	sync.Mutex
+	config  ChildConfig
+	session *Session
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writer  *bufio.Writer
+	// queue holds messages until the writer goroutine writes them to stdin of the child
+	queue  chan []byte
+	closed bool
+	// rows is the number of rows sent with SendRows
+	rows int
+	// done is closed when child closes stdout
+	done   chan struct{}
+	result any
+	// haltMessage is set if child replied with halt or died. Rows are not forwarded to halted child
+	haltMessage string
+}
+
+// StartChild starts the child process and sends start-stream message to it. Child gets syncId suffixed
+// with its name, so state of children doesn't collide
+func StartChild(config ChildConfig, stream, syncId string, session *Session) (*Child, error) {
+	if len(config.Command) == 0 {
+		return nil, fmt.Errorf("command of '%s' destination is empty", config.Name)
+	}
+	cmd := exec.Command(config.Command[0], config.Command[1:]...)
+	cmd.Env = os.Environ()
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("cannot start '%s' destination: %v", config.Name, err)
+	}
+	c := &Child{
+		config:  config,
+		session: session,
+		cmd:     cmd,
+		stdin:   stdin,
+		writer:  bufio.NewWriterSize(stdin, 256*1024),
+		queue:   make(chan []byte, ChildQueueSize),
+		done:    make(chan struct{}),
+	}
+	go c.readReplies(stdout)
+	go c.writeMessages()
+	if config.Stream != "" {
+		stream = config.Stream
+	}
+	start, _ := json.Marshal(Message{Type: "start-stream", Payload: map[string]any{
+		"stream":                stream,
+		"syncId":                syncId + "." + config.Name,
+		"connectionCredentials": config.ConnectionCredentials,
+		"streamOptions":         config.StreamOptions,
+	}})
+	c.Send(start)
+	return c, nil
+}
+
+// Name returns the name of the child from its config
+func (c *Child) Name() string {
+	return c.config.Name
+}
+
+// Send queues message line for the child. Blocks only if the queue of the child is full
+func (c *Child) Send(line []byte) {
+	c.Lock()
+	if c.haltMessage != "" || c.closed {
+		c.Unlock()
+		return
+	}
+	c.Unlock()
+	c.queue <- line
+}
+
+// SendRows queues row or rows message with the number of rows in it, see Rows
+func (c *Child) SendRows(line []byte, rows int) {
+	c.Lock()
+	c.rows += rows
+	c.Unlock()
+	c.Send(line)
+}
+
+// Rows returns the number of rows sent with SendRows, so rows of a child that failed without stream-result
+// can be counted as failed
+func (c *Child) Rows() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.rows
+}
+
+// writeMessages writes queued messages to stdin of the child. The buffer is flushed whenever the queue is empty,
+// so the child gets messages without delay. After a write error the queue is drained without writing
+func (c *Child) writeMessages() {
+	for line := range c.queue {
+		c.Lock()
+		failed := c.haltMessage != ""
+		c.Unlock()
+		if failed {
+			continue
+		}
+		_, err := c.writer.Write(append(line, '\n'))
+		if err == nil && len(c.queue) == 0 {
+			err = c.writer.Flush()
+		}
+		if err != nil {
+			c.Lock()
+			c.haltMessage = fmt.Sprintf("cannot write to the process: %v", err)
+			c.Unlock()
+			c.session.Error(fmt.Sprintf("[%s] %s", c.config.Name, c.haltMessage))
+		}
+	}
+	_ = c.writer.Flush()
+	_ = c.stdin.Close()
+}
+
+func (c *Child) readReplies(stdout io.Reader) {
+	defer close(c.done)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), MaxMessageSize)
+	for scanner.Scan() {
+		var message Message
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			c.session.Warn(fmt.Sprintf("[%s] cannot parse reply: %s", c.config.Name, scanner.Text()))
+			continue
+		}
+		switch message.Type {
+		case "log":
+			payload, _ := message.Payload.(map[string]any)
+			level, _ := payload["level"].(string)
+			msg, _ := payload["message"].(string)
+			params, _ := payload["params"].([]any)
+			c.session.Log(level, fmt.Sprintf("[%s] %s", c.config.Name, msg), params...)
+		case "halt":
+			payload, _ := message.Payload.(map[string]any)
+			msg, _ := payload["message"].(string)
+			c.session.Error(fmt.Sprintf("[%s] destination halted: %s", c.config.Name, msg))
+			c.Lock()
+			c.haltMessage = msg
+			c.Unlock()
+		case "stream-result":
+			c.Lock()
+			c.result = message.Payload
+			c.Unlock()
+		}
+	}
+}
+
+// Finish sends end-stream, waits for the child to exit and returns its stream-result. If the child halted
+// or exited without stream-result, returns {"error": "<message>"}
+func (c *Child) Finish(timeout time.Duration) any {
+	end, _ := json.Marshal(Message{Type: "end-stream", Payload: map[string]any{"reason": "success"}})
+	c.Send(end)
+	c.Lock()
+	c.closed = true
+	c.Unlock()
+	close(c.queue)
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		c.session.Error(fmt.Sprintf("[%s] destination didn't finish in %s. Killing it", c.config.Name, timeout))
+		_ = c.cmd.Process.Kill()
+		<-c.done
+	}
+	_ = c.cmd.Wait()
+	c.Lock()
+	defer c.Unlock()
+	if c.result != nil {
+		return c.result
+	}
+	msg := c.haltMessage
+	if msg == "" {
+		msg = "destination exited without stream-result"
+	}
+	return map[string]any{"error": msg}
+}
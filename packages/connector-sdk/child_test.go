@@ -0,0 +1,51 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChildQueue(t *testing.T) {
+	var out bytes.Buffer
+	session := &Session{Replier: NewReplier(&out)}
+	// the child doesn't read its input for a while, like a destination waiting for a rate limit
+	script := `sleep 1; n=$(grep -c '"type":"row"'); echo '{"type":"log","payload":{"level":"info","message":"slow"}}'; echo "{\"type\":\"stream-result\",\"payload\":{\"received\":$n}}"`
+	child, err := StartChild(ChildConfig{Name: "slow", Command: []string{"sh", "-c", script}}, "default", "sync", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, _ := json.Marshal(Message{Type: "row", Payload: map[string]any{"row": map[string]any{"value": strings.Repeat("x", 1024)}}})
+	started := time.Now()
+	// more than fits into the pipe to the child
+	for i := 0; i < 1000; i++ {
+		child.SendRows(row, 1)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("Send blocked on the slow child for %s", elapsed)
+	}
+	result := child.Finish(10 * time.Second)
+	if want := map[string]any{"received": 1000.0}; !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %v, want %v", result, want)
+	}
+	if child.Rows() != 1000 {
+		t.Errorf("rows = %d, want 1000", child.Rows())
+	}
+	if !strings.Contains(out.String(), `"message":"[slow] slow"`) {
+		t.Errorf("log of the child isn't forwarded: %s", out.String())
+	}
+}
+
+func TestChildWithoutResult(t *testing.T) {
+	session := &Session{Replier: NewReplier(&bytes.Buffer{})}
+	child, err := StartChild(ChildConfig{Name: "failing", Command: []string{"sh", "-c", `echo '{"type":"halt","payload":{"message":"invalid credentials"}}'`}}, "default", "sync", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]any{"error": "invalid credentials"}; !reflect.DeepEqual(child.Finish(10*time.Second), want) {
+		t.Errorf("halted child must finish with its halt message")
+	}
+}
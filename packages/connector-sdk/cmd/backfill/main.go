@@ -0,0 +1,318 @@
+// Backfill drives a destination connector through history in manageable chunks, so backfilling
+// years of data doesn't depend on a single run finishing. For every chunk of the date span it starts
+// the connector, sends start-stream, rows of the chunk and end-stream, and waits for stream-result:
+//
+//	backfill -connector "docker run -i --rm syncmaven/mixpanel" -credentials creds.json \
+//	  -rows ads.ndjson -from 2022-01-01 -to 2023-12-31 -chunk-days 30
+//
+// Rows are read from NDJSON file and split by the value of -date-column. initialSyncDays of credentials is
+// extended for each chunk, so connector doesn't skip old rows. Chunks are processed from the oldest.
+//
+// Progress is stored via the state API at RPC_URL (passed to the connector as well). Rerun with the same
+// -sync-id, -from and -to resumes from the first chunk that didn't complete.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	Type      string `json:"type"`
+	Direction string `json:"direction,omitempty"`
+	Payload   any    `json:"payload"`
+}
+
+type options struct {
+	connector   []string
+	credentials map[string]any
+	stream      string
+	rowsFile    string
+	dateColumn  string
+	syncId      string
+	from        time.Time
+	to          time.Time
+	chunkDays   int
+}
+
+func main() {
+	connector := flag.String("connector", "", "command starting the connector, e.g. \"docker run -i --rm syncmaven/mixpanel\"")
+	credentials := flag.String("credentials", "", "path to JSON file with connection credentials")
+	stream := flag.String("stream", "AdData", "stream name")
+	rowsFile := flag.String("rows", "", "path to NDJSON file with rows")
+	dateColumn := flag.String("date-column", "date", "column with the row date, YYYY-MM-DD")
+	syncId := flag.String("sync-id", "backfill", "sync id passed to the connector. Progress is stored per sync id")
+	from := flag.String("from", "", "first date of the span, YYYY-MM-DD")
+	to := flag.String("to", time.Now().UTC().Format(time.DateOnly), "last date of the span, YYYY-MM-DD")
+	chunkDays := flag.Int("chunk-days", 30, "number of days sent to the connector in one run")
+	flag.Parse()
+
+	opts, err := parseOptions(*connector, *credentials, *stream, *rowsFile, *dateColumn, *syncId, *from, *to, *chunkDays)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err = backfill(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "Backfill failed:", err)
+		os.Exit(1)
+	}
+}
+
+func parseOptions(connector, credentials, stream, rowsFile, dateColumn, syncId, from, to string, chunkDays int) (*options, error) {
+	opts := &options{connector: strings.Fields(connector), stream: stream, rowsFile: rowsFile, dateColumn: dateColumn, syncId: syncId, chunkDays: chunkDays}
+	if len(opts.connector) == 0 {
+		return nil, fmt.Errorf("-connector is required")
+	}
+	if rowsFile == "" {
+		return nil, fmt.Errorf("-rows is required")
+	}
+	if chunkDays <= 0 {
+		return nil, fmt.Errorf("-chunk-days must be positive")
+	}
+	b, err := os.ReadFile(credentials)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read -credentials: %v", err)
+	}
+	if err = json.Unmarshal(b, &opts.credentials); err != nil {
+		return nil, fmt.Errorf("cannot parse -credentials: %v", err)
+	}
+	if opts.from, err = time.Parse(time.DateOnly, from); err != nil {
+		return nil, fmt.Errorf("invalid -from: %v", err)
+	}
+	if opts.to, err = time.Parse(time.DateOnly, to); err != nil {
+		return nil, fmt.Errorf("invalid -to: %v", err)
+	}
+	if opts.to.Before(opts.from) {
+		return nil, fmt.Errorf("-to is before -from")
+	}
+	return opts, nil
+}
+
+func backfill(opts *options) error {
+	progress := newProgressStore(os.Getenv("RPC_URL"), opts)
+	completedThrough, err := progress.load()
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks(opts.from, opts.to, opts.chunkDays) {
+		if !c.to.After(completedThrough) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Chunk %s..%s started\n", c.from.Format(time.DateOnly), c.to.Format(time.DateOnly))
+		result, err := runChunk(opts, c)
+		if err != nil {
+			return fmt.Errorf("chunk %s..%s: %v", c.from.Format(time.DateOnly), c.to.Format(time.DateOnly), err)
+		}
+		fmt.Fprintf(os.Stderr, "Chunk %s..%s completed: %s\n", c.from.Format(time.DateOnly), c.to.Format(time.DateOnly), result)
+		if err = progress.save(c.to); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Backfill %s..%s completed\n", opts.from.Format(time.DateOnly), opts.to.Format(time.DateOnly))
+	return nil
+}
+
+type chunk struct {
+	from time.Time
+	to   time.Time
+}
+
+// chunks splits [from, to] span into consecutive chunks of days, oldest first
+func chunks(from, to time.Time, days int) []chunk {
+	var result []chunk
+	for start := from; !start.After(to); start = start.AddDate(0, 0, days) {
+		end := start.AddDate(0, 0, days-1)
+		if end.After(to) {
+			end = to
+		}
+		result = append(result, chunk{from: start, to: end})
+	}
+	return result
+}
+
+// runChunk runs the connector with rows of the chunk. Returns stream-result
+func runChunk(opts *options, c chunk) (string, error) {
+	credentials := make(map[string]any, len(opts.credentials)+1)
+	for k, v := range opts.credentials {
+		credentials[k] = v
+	}
+	// connector skips rows older than initialSyncDays
+	credentials["initialSyncDays"] = int(time.Since(c.from).Hours()/24) + 1
+
+	cmd := exec.Command(opts.connector[0], opts.connector[1:]...)
+	cmd.Env = os.Environ()
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err = cmd.Start(); err != nil {
+		return "", fmt.Errorf("cannot start connector: %v", err)
+	}
+	replies := make(chan replyResult, 1)
+	go func() {
+		replies <- readReplies(stdout)
+	}()
+	sendErr := sendChunk(stdin, opts, credentials, c)
+	_ = stdin.Close()
+	reply := <-replies
+	waitErr := cmd.Wait()
+	switch {
+	case reply.halt != "":
+		return "", fmt.Errorf("connector halted: %s", reply.halt)
+	case sendErr != nil:
+		return "", sendErr
+	case waitErr != nil:
+		return "", fmt.Errorf("connector failed: %v", waitErr)
+	case reply.result == "":
+		return "", fmt.Errorf("connector exited without stream-result")
+	}
+	return reply.result, nil
+}
+
+func sendChunk(w io.Writer, opts *options, credentials map[string]any, c chunk) error {
+	out := bufio.NewWriterSize(w, 256*1024)
+	send := func(msg Message) error {
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(b, '\n'))
+		return err
+	}
+	if err := send(Message{Type: "start-stream", Payload: map[string]any{
+		"stream":                opts.stream,
+		"syncId":                opts.syncId,
+		"connectionCredentials": credentials,
+	}}); err != nil {
+		return err
+	}
+	f, err := os.Open(opts.rowsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	from, to := c.from.Format(time.DateOnly), c.to.Format(time.DateOnly)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+		decoder.UseNumber()
+		var row map[string]any
+		if err = decoder.Decode(&row); err != nil {
+			return fmt.Errorf("%s line %d: %v", opts.rowsFile, line, err)
+		}
+		date, _ := row[opts.dateColumn].(string)
+		if len(date) >= len(time.DateOnly) {
+			date = date[:len(time.DateOnly)]
+		}
+		if date < from || date > to {
+			continue
+		}
+		if err = send(Message{Type: "row", Payload: map[string]any{"row": row}}); err != nil {
+			return err
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	if err = send(Message{Type: "end-stream", Payload: map[string]any{"reason": "success"}}); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+type replyResult struct {
+	result string
+	halt   string
+}
+
+// readReplies prints connector logs to stderr and returns stream-result or halt message
+func readReplies(r io.Reader) replyResult {
+	var res replyResult
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			fmt.Fprintln(os.Stderr, scanner.Text())
+			continue
+		}
+		switch msg.Type {
+		case "log":
+			var l struct {
+				Level   string `json:"level"`
+				Message string `json:"message"`
+				Params  []any  `json:"params"`
+			}
+			_ = json.Unmarshal(msg.Payload, &l)
+			if l.Level == "debug" {
+				continue
+			}
+			if len(l.Params) > 0 {
+				fmt.Fprintf(os.Stderr, "  [%s] %s %v\n", l.Level, l.Message, l.Params)
+			} else {
+				fmt.Fprintf(os.Stderr, "  [%s] %s\n", l.Level, l.Message)
+			}
+		case "halt":
+			var h struct {
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(msg.Payload, &h)
+			res.halt = h.Message
+		case "stream-result":
+			res.result = summarizeResult(msg.Payload)
+		}
+	}
+	// drain, so the connector doesn't block on write
+	_, _ = io.Copy(io.Discard, r)
+	return res
+}
+
+// summarizeResult returns totals of stream-result. Connectors reporting per-date statuses are summed up
+func summarizeResult(payload json.RawMessage) string {
+	var result map[string]any
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return string(payload)
+	}
+	counters := []string{"received", "success", "skipped", "failed"}
+	totals := make(map[string]float64, len(counters))
+	add := func(status map[string]any) {
+		for _, c := range counters {
+			n, _ := status[c].(float64)
+			totals[c] += n
+		}
+	}
+	if _, ok := result["received"]; ok {
+		add(result)
+	} else {
+		for _, v := range result {
+			if status, ok := v.(map[string]any); ok {
+				add(status)
+			}
+		}
+	}
+	parts := make([]string, len(counters))
+	for i, c := range counters {
+		parts[i] = fmt.Sprintf("%s=%d", c, int(totals[c]))
+	}
+	return strings.Join(parts, " ")
+}
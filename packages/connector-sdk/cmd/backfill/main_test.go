@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChunks(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse(time.DateOnly, s)
+		return d
+	}
+	got := chunks(date("2024-01-01"), date("2024-03-05"), 30)
+	want := [][2]string{{"2024-01-01", "2024-01-30"}, {"2024-01-31", "2024-02-29"}, {"2024-03-01", "2024-03-05"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %v", len(got), len(want), got)
+	}
+	for i, c := range got {
+		if c.from.Format(time.DateOnly) != want[i][0] || c.to.Format(time.DateOnly) != want[i][1] {
+			t.Errorf("chunk %d = %s..%s, want %s..%s", i, c.from.Format(time.DateOnly), c.to.Format(time.DateOnly), want[i][0], want[i][1])
+		}
+	}
+	if got := chunks(date("2024-01-01"), date("2024-01-01"), 7); len(got) != 1 || !got[0].to.Equal(date("2024-01-01")) {
+		t.Errorf("single day span: %v", got)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// progressStore keeps the last completed date of the span in the state:
+//
+//	key: ["type=backfill", "syncId=<sync id>"]
+//	value: {"from":"2022-01-01","to":"2023-12-31","completedThrough":"2022-03-01"}
+//
+// Progress of a different span is ignored, so changing -from or -to starts over.
+type progressStore struct {
+	url           string
+	signingSecret string
+	client        http.Client
+	key           []string
+	from, to      string
+}
+
+func newProgressStore(url string, opts *options) *progressStore {
+	return &progressStore{
+		url:           url,
+		signingSecret: os.Getenv(sdk.SigningSecretEnv),
+		client:        http.Client{Timeout: 10 * time.Second},
+		key:           []string{"type=backfill", "syncId=" + opts.syncId},
+		from:          opts.from.Format(time.DateOnly),
+		to:            opts.to.Format(time.DateOnly),
+	}
+}
+
+type progress struct {
+	From             string `json:"from"`
+	To               string `json:"to"`
+	CompletedThrough string `json:"completedThrough"`
+}
+
+// load returns the last completed date, or zero time if backfill of the span wasn't started
+func (p *progressStore) load() (time.Time, error) {
+	if p.url == "" {
+		fmt.Fprintln(os.Stderr, "RPC_URL is not set. Progress won't be saved, interrupted backfill will start over")
+		return time.Time{}, nil
+	}
+	var saved progress
+	if err := p.call("state.get", map[string]any{"key": p.key}, &saved); err != nil {
+		return time.Time{}, fmt.Errorf("cannot load backfill progress: %v", err)
+	}
+	if saved.From != p.from || saved.To != p.to || saved.CompletedThrough == "" {
+		return time.Time{}, nil
+	}
+	completedThrough, err := time.Parse(time.DateOnly, saved.CompletedThrough)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	fmt.Fprintf(os.Stderr, "Resuming backfill. Completed through %s\n", saved.CompletedThrough)
+	return completedThrough, nil
+}
+
+func (p *progressStore) save(completedThrough time.Time) error {
+	if p.url == "" {
+		return nil
+	}
+	value := progress{From: p.from, To: p.to, CompletedThrough: completedThrough.Format(time.DateOnly)}
+	if err := p.call("state.set", map[string]any{"key": p.key, "value": value}, nil); err != nil {
+		return fmt.Errorf("cannot save backfill progress: %v", err)
+	}
+	return nil
+}
+
+func (p *progressStore) call(method string, body any, response any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.url+"/"+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sdk.SignRequest(req, b, p.signingSecret, sdk.NewCallId())
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBytes, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s HTTP code = %d response: %s", method, resp.StatusCode, string(respBytes))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(respBytes, response)
+}
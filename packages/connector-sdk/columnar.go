@@ -0,0 +1,72 @@
+package sdk
+
+import (
+	"math/big"
+	"time"
+)
+
+// RowReader reads rows of columnar data handed off by the host, see ArrowReader and ParquetReader.
+// Rows have the same representation as JSON rows decoded with json.Decoder.UseNumber:
+//   - integers, floats and decimals are json.Number
+//   - dates are 2006-01-02 strings, timestamps with time zone are RFC 3339 strings in UTC,
+//     timestamps without time zone and times are ISO strings without offset
+//   - binary values are base64 strings
+type RowReader interface {
+	// Columns returns the columns of the schema
+	Columns() []Column
+	// Next returns the next row. Returns io.EOF after the last row
+	Next() (map[string]any, error)
+}
+
+// Column is a column of columnar data
+type Column struct {
+	Name     string
+	Nullable bool
+	// Type is a warehouse agnostic column type: decimal, integer, float, string, binary, timestamp, date, time, boolean
+	Type string
+	// Scale is number of digits after decimal point of decimal columns
+	Scale int
+}
+
+const naiveTimestampLayout = "2006-01-02T15:04:05.999999999"
+
+const timeLayout = "15:04:05.999999999"
+
+// formatTimestamp formats timestamp adjusted to UTC as RFC 3339 and local (naive) timestamp without offset
+func formatTimestamp(t time.Time, utc bool) string {
+	if utc {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return t.UTC().Format(naiveTimestampLayout)
+}
+
+// formatDecimal formats unscaled integer as decimal with the scale
+func formatDecimal(v *big.Int, scale int) string {
+	if scale <= 0 {
+		return v.Mul(v, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-scale)), nil)).String()
+	}
+	return new(big.Rat).SetFrac(v, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)).FloatString(scale)
+}
+
+// unitTime converts value in time unit (SECOND, MILLISECOND, MICROSECOND, NANOSECOND) to time
+func unitTime(v int64, unit int) time.Time {
+	switch unit {
+	case 0:
+		return time.Unix(v, 0)
+	case 1:
+		return time.UnixMilli(v)
+	case 2:
+		return time.UnixMicro(v)
+	default:
+		return time.Unix(0, v)
+	}
+}
+
+// signedBigEndian decodes big endian two's complement integer of any width
+func signedBigEndian(b []byte) *big.Int {
+	v := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return v
+}
@@ -0,0 +1,101 @@
+package sdk
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of protocol streams reduces IPC volume when host and connector run on different machines. Unlike gzip
+// framing, which compresses each incoming message separately, compression applies to whole streams in both
+// directions: the connector reads compressed stdin and writes compressed stdout. Messages inside the streams keep
+// their framing. Compression is selected with CompressionEnv, or by the host with hello message:
+//
+//	{"type":"hello","payload":{"compression":"zstd"}}
+//
+// Input after hello line is compressed. Hello reply is written uncompressed, replies after it are compressed and
+// flushed one by one, so the host receives them without delay. The host requests compression only from connectors
+// that list it in supportedCompressions of spec
+
+// CompressionEnv selects compression of protocol streams
+const CompressionEnv = "PROTOCOL_COMPRESSION"
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var SupportedCompressions = []string{CompressionNone, CompressionGzip, CompressionZstd}
+
+// CompressionFromEnv returns compression set with CompressionEnv. Default is none
+func CompressionFromEnv() (string, error) {
+	compression := os.Getenv(CompressionEnv)
+	if compression == "" {
+		return CompressionNone, nil
+	}
+	if !slices.Contains(SupportedCompressions, compression) {
+		return "", fmt.Errorf("unsupported %s: %s. Supported: %v", CompressionEnv, compression, SupportedCompressions)
+	}
+	return compression, nil
+}
+
+func checkCompression(compression string) error {
+	if !slices.Contains(SupportedCompressions, compression) {
+		return fmt.Errorf("unsupported compression: %s. Supported: %v", compression, SupportedCompressions)
+	}
+	return nil
+}
+
+// compressor is a compressed stream. Flush writes all data written so far
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newCompressor(compression string, out io.Writer) (compressor, error) {
+	switch compression {
+	case CompressionGzip:
+		return gzip.NewWriter(out), nil
+	case CompressionZstd:
+		return zstd.NewWriter(out, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, checkCompression(compression)
+	}
+}
+
+// decompressor reads compressed stream. Decoder is created on the first read, because it reads the stream header
+// and would block until the host sends the first message
+type decompressor struct {
+	r           io.Reader
+	compression string
+	dec         io.Reader
+}
+
+func (d *decompressor) Read(p []byte) (int, error) {
+	if d.dec == nil {
+		switch d.compression {
+		case CompressionGzip:
+			gz, err := gzip.NewReader(d.r)
+			if err != nil {
+				return 0, err
+			}
+			d.dec = gz
+		case CompressionZstd:
+			// single-threaded decoding with bounded window, so the memory doesn't depend on the input
+			zr, err := zstd.NewReader(d.r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+			if err != nil {
+				return 0, err
+			}
+			d.dec = zr
+		}
+	}
+	return d.dec.Read(p)
+}
+
+// maxZstdWindow is the largest zstd window accepted. zstd CLI and libraries use 8 MB at most by default
+const maxZstdWindow = 64 << 20
@@ -0,0 +1,96 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// compressed compresses messages flushing after each of them, as the host does
+func compressed(t *testing.T, compression string, messages ...string) []byte {
+	var b bytes.Buffer
+	w, err := newCompressor(compression, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range messages {
+		_, _ = w.Write([]byte(message + "\n"))
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decompressed(t *testing.T, compression string, data []byte) string {
+	res, err := io.ReadAll(&decompressor{r: bytes.NewReader(data), compression: compression})
+	if err != nil {
+		t.Fatalf("%s: replies cannot be decompressed: %v", compression, err)
+	}
+	return string(res)
+}
+
+func TestRunCompression(t *testing.T) {
+	row := `{"type":"row","payload":{"row":{"v":1}}}`
+	replies := `{"type":"log","direction":"reply","payload":{"level":"info","message":"row","params":[1]}}` + "\n" +
+		`{"type":"stream-result","direction":"reply","payload":{"received":1}}` + "\n"
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run("hello "+compression, func(t *testing.T) {
+			in := bytes.NewBufferString(`{"type":"hello","payload":{"compression":"` + compression + `"}}` + "\n")
+			in.Write(compressed(t, compression, row, `{"type":"end-stream"}`))
+			var out bytes.Buffer
+			if err := Run(context.Background(), in, &out, echoHandler); err != nil {
+				t.Fatal(err)
+			}
+			// hello reply isn't compressed, so the host can read it before switching
+			hello, rest, _ := bytes.Cut(out.Bytes(), []byte("\n"))
+			if !strings.Contains(string(hello), `"compression":"`+compression+`"`) {
+				t.Errorf("hello reply = %s", hello)
+			}
+			if got := decompressed(t, compression, rest); got != replies {
+				t.Errorf("unexpected replies:\n%s\nwant:\n%s", got, replies)
+			}
+		})
+		t.Run("env "+compression, func(t *testing.T) {
+			t.Setenv(CompressionEnv, compression)
+			var out bytes.Buffer
+			if err := Run(context.Background(), bytes.NewReader(compressed(t, compression, row, `{"type":"end-stream"}`)), &out, echoHandler); err != nil {
+				t.Fatal(err)
+			}
+			if got := decompressed(t, compression, out.Bytes()); got != replies {
+				t.Errorf("unexpected replies:\n%s\nwant:\n%s", got, replies)
+			}
+		})
+	}
+
+	// compression works with other framings
+	in := bytes.NewBufferString(`{"type":"hello","payload":{"framing":"length-prefixed","compression":"zstd"}}` + "\n")
+	var frames []byte
+	frames = append(frames, frame(t, row, false)...)
+	frames = append(frames, frame(t, `{"type":"end-stream"}`, false)...)
+	var b bytes.Buffer
+	w, _ := newCompressor(CompressionZstd, &b)
+	_, _ = w.Write(frames)
+	_ = w.Close()
+	in.Write(b.Bytes())
+	var out bytes.Buffer
+	if err := Run(context.Background(), in, &out, echoHandler); err != nil {
+		t.Fatal(err)
+	}
+	if _, rest, _ := bytes.Cut(out.Bytes(), []byte("\n")); decompressed(t, CompressionZstd, rest) != replies {
+		t.Errorf("unexpected replies of length-prefixed framing: %s", decompressed(t, CompressionZstd, rest))
+	}
+
+	if err := Run(context.Background(), strings.NewReader(`{"type":"hello","payload":{"compression":"brotli"}}`+"\n"), io.Discard, echoHandler); err == nil {
+		t.Error("expected error for unsupported compression")
+	}
+	t.Setenv(CompressionEnv, "brotli")
+	if err := Run(context.Background(), strings.NewReader(""), io.Discard, echoHandler); err == nil {
+		t.Errorf("expected error for unsupported %s", CompressionEnv)
+	}
+}
@@ -0,0 +1,511 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Connector is the business logic of a connector. NewConnectorHandler adapts it to Handler, which takes care of
+// message parsing, replies and lifecycle, so a connector binary is just:
+//
+//	func main() {
+//		sdk.Serve(&myConnector{})
+//	}
+type Connector interface {
+	// Describe returns payload of spec reply: roles, description and connectionCredentials schema
+	Describe() (map[string]any, error)
+	// DescribeStreams returns payload of stream-spec reply: roles, defaultStream and streams
+	DescribeStreams() (map[string]any, error)
+	// StartStream configures the connector for the stream. Error halts the stream as config error
+	StartStream(ctx context.Context, stream StartStream, session *Session) error
+	// Row handles a row of the stream. Rows that can't be sent should be counted in the stream result rather than
+	// returned as errors: error halts the stream
+	Row(ctx context.Context, row map[string]any) error
+	// EndStream sends remaining rows and returns payload of stream-result reply
+	EndStream(ctx context.Context) (any, error)
+}
+
+// Halter may be implemented by Connector to handle halt message of the host. Without it the stream is stopped
+// right away
+type Halter interface {
+	Halt(ctx context.Context, payload HaltPayload) error
+}
+
+// CredentialsUpdater may be implemented by Connector to switch to credentials rotated by the host during the stream.
+// Without it credentials-updated message is ignored and the stream continues with credentials of start-stream
+type CredentialsUpdater interface {
+	UpdateCredentials(ctx context.Context, credentials map[string]any) error
+}
+
+// StreamsDescriber may be implemented by Connector whose streams depend on credentials, e.g. rowType of a query
+// result. It's called instead of DescribeStreams with payload of describe-streams
+type StreamsDescriber interface {
+	DescribeStreamsOf(payload DescribeStreamsPayload, session *Session) (map[string]any, error)
+}
+
+// Source may be implemented by Connector that emits rows of the stream rather than receives them. Emit runs in
+// background after StartStream, so acknowledgements of the host keep coming while rows are emitted with the emitter
+// of Session.NewEmitter. Emit finishes the emitter, which replies stream-result. Run returns once Emit returns,
+// the error halts the stream
+type Source interface {
+	Emit(ctx context.Context) error
+}
+
+// Session gives the connector access to the host during the stream: replies, logs and state
+type Session struct {
+	Replier
+	// State is the client of host RPC server. Nil if RpcEnv is not set
+	State *RpcClient
+	// TraceId is trace id of start-stream, added to log messages
+	TraceId string
+	// Metrics are sent to the host with periodic metric replies. Rows are counted by the SDK, connectors record
+	// requests, retries and queue depth
+	Metrics *Metrics
+	// Ordinals track delivery of rows numbered by the host
+	Ordinals *OrdinalTracker
+	// Hooks are follow-ups registered by the connector, see Hooks
+	Hooks *Hooks
+	// DryRun is set if start-stream requests dry run. Connectors record requests instead of sending them
+	DryRun *DryRun
+	// Limits are resource limits set by the runner, nil if none is set. Connectors wrap their transport with
+	// Limits.Transport and check Limits.MemoryPressure
+	Limits *Limits
+	// rowOrdinal is the ordinal of the row being handled, if the host numbers rows
+	rowOrdinal *int64
+	held       bool
+	// emitter of a source stream receives acknowledgements of the host, see NewEmitter
+	emitterMu   sync.Mutex
+	emitter     *EmitterSession
+	inputClosed bool
+}
+
+// NewEmitter returns emitter of rows of a source stream, see Source. Rows are sent as replies, acknowledgements
+// of the host are passed to the emitter by the SDK
+func (s *Session) NewEmitter(stream string, options EmitterOptions) *EmitterSession {
+	emitter := newReplyEmitter(stream, s.Replier, options)
+	s.emitterMu.Lock()
+	defer s.emitterMu.Unlock()
+	s.emitter = emitter
+	if s.inputClosed {
+		emitter.Ack(0, true)
+	}
+	return emitter
+}
+
+// ack passes acknowledgement of the host to the emitter
+func (s *Session) ack(payload AckPayload) {
+	s.emitterMu.Lock()
+	defer s.emitterMu.Unlock()
+	if s.emitter != nil {
+		s.emitter.Ack(payload.Rows, payload.Final)
+	}
+}
+
+// closeInput unblocks the emitter when the host closes the input: nothing is acknowledged anymore
+func (s *Session) closeInput() {
+	s.emitterMu.Lock()
+	defer s.emitterMu.Unlock()
+	s.inputClosed = true
+	if s.emitter != nil {
+		s.emitter.Ack(0, true)
+	}
+}
+
+// HoldRow keeps the row being handled undelivered after Connector.Row returns. The connector reports its delivery
+// with RowDelivered. ok is false if the host doesn't number rows, then nothing has to be reported
+func (s *Session) HoldRow() (ordinal int64, ok bool) {
+	if s.rowOrdinal == nil {
+		return 0, false
+	}
+	s.held = true
+	return *s.rowOrdinal, true
+}
+
+// RowDelivered reports delivery of the row held with HoldRow
+func (s *Session) RowDelivered(ordinal int64) {
+	s.Ordinals.Deliver(ordinal)
+}
+
+// Info sends info log message to the host
+func (s *Session) Info(message string, params ...any) {
+	s.Log("info", message, params...)
+}
+
+// Debug sends debug log message to the host
+func (s *Session) Debug(message string, params ...any) {
+	s.Log("debug", message, params...)
+}
+
+// Warn sends warn log message to the host
+func (s *Session) Warn(message string, params ...any) {
+	s.Log("warn", message, params...)
+}
+
+// Error sends error log message to the host
+func (s *Session) Error(message string, params ...any) {
+	s.Log("error", message, params...)
+}
+
+// Log sends log message of the level to the host
+func (s *Session) Log(level string, message string, params ...any) {
+	l := map[string]any{
+		"level":   level,
+		"message": message,
+	}
+	if len(params) > 0 {
+		l["params"] = params
+	}
+	if s.TraceId != "" {
+		l["traceId"] = s.TraceId
+	}
+	_ = s.Reply("log", l)
+}
+
+// UnmarshalSchema parses embedded JSON schema. Panics on invalid JSON, since schemas are part of the binary
+func UnmarshalSchema(schema string) map[string]any {
+	var m map[string]any
+	if err := json.Unmarshal([]byte(schema), &m); err != nil {
+		panic(fmt.Sprintf("invalid schema: %v", err))
+	}
+	return m
+}
+
+// connectorHandler runs Connector lifecycle: describe and describe-streams are answered right away,
+// rows are accepted only between start-stream and end-stream
+type connectorHandler struct {
+	connector   Connector
+	state       *RpcClient
+	session     *Session
+	stopMetrics func()
+	stream      string
+	rows        int
+	// checkpointed is the watermark of rows reported with the last checkpoint
+	checkpointed   int64
+	checkpointedAt time.Time
+	// emitted receives the result of Source.Emit, nil unless rows of a source stream are emitted
+	emitted chan error
+}
+
+// CheckpointInterval is the minimal interval between checkpoint replies with the ordinal watermark
+const CheckpointInterval = time.Second
+
+// NewConnectorHandler adapts connector to Handler. state is passed to the connector in Session, may be nil
+func NewConnectorHandler(connector Connector, state *RpcClient) Handler {
+	return &connectorHandler{connector: connector, state: state}
+}
+
+func (h *connectorHandler) HandleMessage(ctx context.Context, message IncomingMessage, replier Replier) error {
+	switch message.Type {
+	case "describe":
+		session := &Session{Replier: replier}
+		describe, err := DecodeMessage[DescribePayload](message)
+		if err != nil {
+			session.Warn("Invalid describe message", err.Error())
+		}
+		CheckHostRequirements(describe.HostRequirements, h.capabilities(), session)
+		spec, err := h.connector.Describe()
+		if err != nil {
+			return err
+		}
+		if _, ok := spec["connector"]; !ok && spec != nil {
+			spec["connector"] = VersionInfo(h.capabilities())
+		}
+		// Run reads messages with MessageReader, so the host may switch framing and compression with hello
+		if _, ok := spec["supportedFramings"]; !ok && spec != nil {
+			spec["supportedFramings"] = SupportedFramings
+		}
+		if _, ok := spec["supportedCompressions"]; !ok && spec != nil {
+			spec["supportedCompressions"] = SupportedCompressions
+		}
+		if err = replier.Reply("spec", spec); err != nil {
+			return err
+		}
+		return ErrStop
+	case "describe-streams":
+		var spec map[string]any
+		var err error
+		if describer, ok := h.connector.(StreamsDescriber); ok {
+			var payload DescribeStreamsPayload
+			if payload, err = DecodeMessage[DescribeStreamsPayload](message); err != nil {
+				return err
+			}
+			spec, err = describer.DescribeStreamsOf(payload, &Session{Replier: replier})
+		} else {
+			spec, err = h.connector.DescribeStreams()
+		}
+		if err != nil {
+			return err
+		}
+		return replier.Reply("stream-spec", spec)
+	case "start-stream":
+		if h.session != nil {
+			return fmt.Errorf("stream is already started")
+		}
+		h.session = &Session{Replier: replier, State: h.state, Metrics: NewMetrics(), Ordinals: NewOrdinalTracker(), Hooks: NewHooks()}
+		stream, err := DecodeMessage[StartStream](message)
+		if err != nil {
+			return h.halt(err)
+		}
+		interval, err := MetricsIntervalFromEnv()
+		if err != nil {
+			return h.halt(err)
+		}
+		limits, err := LimitsFromEnv()
+		if err != nil {
+			return h.halt(err)
+		}
+		h.stopMetrics = h.session.Metrics.Report(replier, interval)
+		if limits != nil {
+			limits.OnMemoryPressure(func(message string) { h.session.Warn(message) })
+			h.session.Limits = limits
+		}
+		h.session.TraceId = stream.TraceId
+		h.stream = stream.Stream
+		if h.state != nil {
+			h.state.TraceId = stream.TraceId
+			h.state.ReadOnly = stream.DryRun
+		}
+		if stream.DryRun {
+			h.session.DryRun = NewDryRun(DryRunSampleSize)
+		}
+		CheckHostRequirements(stream.HostRequirements, h.capabilities(), h.session)
+		if err = h.connector.StartStream(ctx, stream, h.session); err != nil {
+			return h.halt(err)
+		}
+		if source, ok := h.connector.(Source); ok {
+			h.emit(ctx, source)
+		}
+		return nil
+	case "row", "rows":
+		if h.session == nil {
+			return fmt.Errorf("%s message received before start-stream", message.Type)
+		}
+		rows, ordinals, err := decodeRows(message)
+		if err != nil {
+			return h.halt(err)
+		}
+		h.session.Metrics.AddRows(len(rows))
+		for i, row := range rows {
+			h.rows++
+			h.session.rowOrdinal, h.session.held = nil, false
+			if ordinals != nil {
+				if err = h.session.Ordinals.Receive(ordinals[i]); err != nil {
+					return h.halt(err)
+				}
+				h.session.rowOrdinal = &ordinals[i]
+			}
+			if err = h.connector.Row(ctx, row); err != nil {
+				return h.halt(err)
+			}
+			if ordinals != nil && !h.session.held {
+				h.session.Ordinals.Deliver(ordinals[i])
+			}
+		}
+		h.session.rowOrdinal = nil
+		return h.checkpoint(replier)
+	case "end-stream":
+		if h.session == nil {
+			return fmt.Errorf("end-stream message received before start-stream")
+		}
+		result, err := h.connector.EndStream(ctx)
+		if err != nil {
+			return h.halt(err)
+		}
+		// follow-ups of dry run would change the destination
+		if h.session.DryRun == nil {
+			if err = h.session.Hooks.StreamCompleted(ctx, result); err != nil {
+				return h.halt(fmt.Errorf("stream completed hook failed: %w", err))
+			}
+		}
+		h.stopMetrics()
+		if h.session.DryRun != nil {
+			if err = replier.Reply("dry-run", h.session.DryRun.Report()); err != nil {
+				return err
+			}
+			if result, err = withField(result, "dryRun", true); err != nil {
+				return h.halt(err)
+			}
+		}
+		if h.session.Limits != nil {
+			if result, err = withField(result, "limits", h.session.Limits.Report()); err != nil {
+				return h.halt(err)
+			}
+		}
+		if ordinal, ok := h.session.Ordinals.Watermark(); ok {
+			if result, err = withField(result, "ordinal", ordinal); err != nil {
+				return h.halt(err)
+			}
+		}
+		if err = replier.Reply("stream-result", result); err != nil {
+			return err
+		}
+		return ErrStop
+	case "ack":
+		if h.session == nil {
+			return nil
+		}
+		payload, err := DecodeMessage[AckPayload](message)
+		if err != nil {
+			return err
+		}
+		h.session.ack(payload)
+		return nil
+	case "halt":
+		halter, ok := h.connector.(Halter)
+		if !ok || h.session == nil {
+			return ErrStop
+		}
+		payload, err := DecodeMessage[HaltPayload](message)
+		if err != nil {
+			return err
+		}
+		return halter.Halt(ctx, payload)
+	case "credentials-updated":
+		if h.session == nil {
+			return fmt.Errorf("credentials-updated message received before start-stream")
+		}
+		updater, ok := h.connector.(CredentialsUpdater)
+		if !ok {
+			h.session.Warn("Connector doesn't support credentials rotation. Stream continues with previous credentials")
+			return nil
+		}
+		payload, err := DecodeMessage[CredentialsUpdatedPayload](message)
+		if err != nil {
+			return h.halt(err)
+		}
+		if err = updater.UpdateCredentials(ctx, payload.ConnectionCredentials); err != nil {
+			return h.halt(err)
+		}
+		return nil
+	default:
+		(&Session{Replier: replier}).Error("Unknown message type", message.Type)
+		return nil
+	}
+}
+
+// capabilities returns capabilities of the connector, see CapabilitiesDescriber
+func (h *connectorHandler) capabilities() []string {
+	if describer, ok := h.connector.(CapabilitiesDescriber); ok {
+		return describer.Capabilities()
+	}
+	return nil
+}
+
+// emit runs Emit of the source in background. Run returns with its result, see finisher
+func (h *connectorHandler) emit(ctx context.Context, source Source) {
+	h.emitted = make(chan error, 1)
+	go func() {
+		err := source.Emit(ctx)
+		if err != nil {
+			err = h.halt(err)
+		} else {
+			h.stopMetrics()
+			err = ErrStop
+		}
+		h.emitted <- err
+	}()
+}
+
+func (h *connectorHandler) finished() <-chan error {
+	return h.emitted
+}
+
+func (h *connectorHandler) inputClosed() {
+	if h.session != nil {
+		h.session.closeInput()
+	}
+}
+
+// decodeRows decodes rows of row or rows message and their ordinals, nil if the host doesn't number rows.
+// Numbers are decoded as json.Number
+func decodeRows(message IncomingMessage) ([]map[string]any, []int64, error) {
+	if message.Type == "row" {
+		var payload RowMessage
+		if err := message.DecodePayload(&payload); err != nil {
+			return nil, nil, payloadError(message.Type, err)
+		}
+		if err := payload.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s payload: %w", message.Type, err)
+		}
+		if payload.Ordinal != nil {
+			return []map[string]any{payload.Row}, []int64{*payload.Ordinal}, nil
+		}
+		return []map[string]any{payload.Row}, nil, nil
+	}
+	var payload RowsMessage
+	if err := message.DecodePayload(&payload); err != nil {
+		return nil, nil, payloadError(message.Type, err)
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s payload: %w", message.Type, err)
+	}
+	return payload.Rows, payload.Ordinals, nil
+}
+
+// checkpoint replies checkpoint if the ordinal watermark advanced, at most once per CheckpointInterval
+func (h *connectorHandler) checkpoint(replier Replier) error {
+	ordinal, ok := h.session.Ordinals.Watermark()
+	if !ok {
+		return nil
+	}
+	if !h.checkpointedAt.IsZero() && (ordinal == h.checkpointed || time.Since(h.checkpointedAt) < CheckpointInterval) {
+		return nil
+	}
+	h.checkpointed, h.checkpointedAt = ordinal, time.Now()
+	return replier.Reply("checkpoint", map[string]any{"stream": h.stream, "rows": h.rows, "ordinal": ordinal})
+}
+
+// withField adds the field to stream-result payload. Payloads other than maps are converted to maps with JSON
+func withField(result any, name string, value any) (any, error) {
+	m, ok := result.(map[string]any)
+	if !ok {
+		b, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, &m); err != nil || m == nil {
+			// payload is not an object, e.g. null
+			return result, nil
+		}
+	}
+	m[name] = value
+	return m, nil
+}
+
+// halt replies halt with the error, so the host stops sending rows, and returns the error
+func (h *connectorHandler) halt(err error) error {
+	if h.stopMetrics != nil {
+		h.stopMetrics()
+	}
+	h.session.Error(err.Error())
+	_ = h.session.Reply("halt", map[string]any{
+		"status":  "error",
+		"message": err.Error(),
+	})
+	return err
+}
+
+// Serve runs the connector with stdin and stdout. State client is configured from RpcEnv.
+// Exits with code 1 if the stream fails, 0 otherwise
+func Serve(connector Connector) {
+	var state *RpcClient
+	if url := os.Getenv(RpcEnv); url != "" {
+		state = NewRpcClient(url)
+	}
+	err := Run(context.Background(), os.Stdin, os.Stdout, NewConnectorHandler(connector, state))
+	if state != nil {
+		if flushErr := state.Flush(); flushErr != nil {
+			_, _ = fmt.Fprintln(os.Stderr, flushErr.Error())
+		}
+	}
+	if err != nil && !errors.Is(err, context.Canceled) {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
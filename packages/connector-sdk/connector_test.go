@@ -0,0 +1,298 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// countingConnector counts rows and fails on rows with fail column
+type countingConnector struct {
+	stream  StartStream
+	session *Session
+	rows    int
+}
+
+func (c *countingConnector) Describe() (map[string]any, error) {
+	return map[string]any{"roles": []string{"destination"}}, nil
+}
+
+func (c *countingConnector) DescribeStreams() (map[string]any, error) {
+	return map[string]any{"roles": []string{"destination"}, "defaultStream": "default"}, nil
+}
+
+func (c *countingConnector) StartStream(ctx context.Context, stream StartStream, session *Session) error {
+	if stream.ConnectionCredentials["token"] == nil {
+		return errors.New("token is required")
+	}
+	c.stream = stream
+	c.session = session
+	session.Info("started", stream.Stream)
+	return nil
+}
+
+func (c *countingConnector) Row(ctx context.Context, row map[string]any) error {
+	if row["fail"] != nil {
+		return errors.New("row failed")
+	}
+	c.rows++
+	return nil
+}
+
+func (c *countingConnector) EndStream(ctx context.Context) (any, error) {
+	return map[string]any{"received": c.rows}, nil
+}
+
+func exchange(t *testing.T, connector Connector, messages ...Message) ([]Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewClient(ctx, NewConnectorHandler(connector, nil))
+	for _, m := range messages {
+		if err := client.Send(m.Type, m.Payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// all exchanges end with the handler stopped, which closes replies
+	var replies []Message
+	for reply := range client.Replies() {
+		replies = append(replies, reply)
+	}
+	return replies, client.Close()
+}
+
+func TestConnectorLifecycle(t *testing.T) {
+	c := &countingConnector{}
+	replies, err := exchange(t, c,
+		Message{Type: "describe-streams"},
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "syncId": "1", "connectionCredentials": map[string]any{"token": "t"}}},
+		Message{Type: "row", Payload: map[string]any{"row": map[string]any{"a": 1}}},
+		Message{Type: "rows", Payload: map[string]any{"rows": []any{map[string]any{"a": 2}, map[string]any{"a": 3}}}},
+		Message{Type: "end-stream"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, r := range replies {
+		types = append(types, r.Type)
+	}
+	if b, _ := json.Marshal(types); string(b) != `["stream-spec","log","metric","stream-result"]` {
+		t.Fatalf("unexpected replies: %s", b)
+	}
+	if metric := replies[2].Payload.(map[string]any); metric["rows"] != 3.0 {
+		t.Errorf("unexpected metric: %v", metric)
+	}
+	if b, _ := json.Marshal(replies[3].Payload); string(b) != `{"received":3}` {
+		t.Errorf("unexpected stream-result: %s", b)
+	}
+	if c.stream.SyncId != "1" {
+		t.Errorf("unexpected start-stream payload: %+v", c.stream)
+	}
+}
+
+func TestConnectorDescribe(t *testing.T) {
+	replies, err := exchange(t, &countingConnector{}, Message{Type: "describe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := json.Marshal(replies); string(b) != `[{"type":"spec","direction":"reply","payload":{"connector":{"buildDate":"unknown","capabilities":[],"compression":["none","gzip","zstd"],"gitSha":"unknown","protocolVersion":1,"version":"dev"},"roles":["destination"],"supportedCompressions":["none","gzip","zstd"],"supportedFramings":["ndjson","length-prefixed","gzip"]}}]` {
+		t.Errorf("spec must advertise version and framings read by Run: %s", b)
+	}
+}
+
+// capableConnector supports state capability
+type capableConnector struct {
+	countingConnector
+}
+
+func (c *capableConnector) Capabilities() []string {
+	return []string{"state"}
+}
+
+func TestConnectorHostRequirements(t *testing.T) {
+	requirements := map[string]any{"protocolVersion": 2, "capabilities": []string{"state", "arrow"}}
+	replies, err := exchange(t, &capableConnector{}, Message{Type: "describe", Payload: requirements})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []string
+	for _, r := range replies {
+		if r.Type == "log" {
+			b, _ := json.Marshal(r.Payload)
+			warnings = append(warnings, string(b))
+		}
+	}
+	if b, _ := json.Marshal(warnings); string(b) != `["{\"level\":\"warn\",\"message\":\"Host requested protocol version 2, but connector supports version 1. Consider upgrading connector\"}","{\"level\":\"warn\",\"message\":\"Host requested capabilities not supported by connector version dev\",\"params\":[[\"arrow\"]]}"]` {
+		t.Errorf("unexpected warnings: %s", b)
+	}
+	spec := replies[len(replies)-1].Payload.(map[string]any)
+	if b, _ := json.Marshal(spec["connector"].(map[string]any)["capabilities"]); string(b) != `["state"]` {
+		t.Errorf("spec must report capabilities of the connector: %s", b)
+	}
+}
+
+func TestConnectorHalt(t *testing.T) {
+	replies, err := exchange(t, &countingConnector{},
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}}},
+		Message{Type: "row", Payload: map[string]any{"row": map[string]any{"fail": true}}},
+	)
+	if err == nil || err.Error() != "row failed" {
+		t.Fatalf("expected row error, got %v", err)
+	}
+	if last := replies[len(replies)-1]; last.Type != "halt" {
+		t.Errorf("expected halt reply, got %+v", last)
+	}
+	_, err = exchange(t, &countingConnector{}, Message{Type: "row", Payload: map[string]any{"row": map[string]any{}}})
+	if err == nil {
+		t.Error("expected error for row before start-stream")
+	}
+}
+
+// hookedConnector registers stream completed hook failing with the error of the token
+type hookedConnector struct {
+	countingConnector
+	completed any
+}
+
+func (c *hookedConnector) StartStream(ctx context.Context, stream StartStream, session *Session) error {
+	session.Hooks.OnStreamCompleted(func(ctx context.Context, result any) error {
+		c.completed = result
+		if stream.ConnectionCredentials["token"] == "fail" {
+			return errors.New("merge failed")
+		}
+		return nil
+	})
+	return c.countingConnector.StartStream(ctx, stream, session)
+}
+
+func TestConnectorHooks(t *testing.T) {
+	c := &hookedConnector{}
+	replies, err := exchange(t, c,
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}}},
+		Message{Type: "row", Payload: map[string]any{"row": map[string]any{"a": 1}}},
+		Message{Type: "end-stream"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := json.Marshal(c.completed); string(b) != `{"received":1}` || replies[len(replies)-1].Type != "stream-result" {
+		t.Errorf("hook must run before stream-result with its payload, got %s", b)
+	}
+	_, err = exchange(t, &hookedConnector{},
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "fail"}}},
+		Message{Type: "end-stream"},
+	)
+	if err == nil || err.Error() != "stream completed hook failed: merge failed" {
+		t.Errorf("expected hook error, got %v", err)
+	}
+}
+
+// dryRunConnector records each row as a request in dry run
+type dryRunConnector struct {
+	countingConnector
+}
+
+func (c *dryRunConnector) Row(ctx context.Context, row map[string]any) error {
+	if c.session.DryRun != nil {
+		c.session.DryRun.Request(row)
+	}
+	return c.countingConnector.Row(ctx, row)
+}
+
+func TestConnectorDryRun(t *testing.T) {
+	c := &dryRunConnector{}
+	var rows []any
+	for i := 0; i < DryRunSampleSize+2; i++ {
+		rows = append(rows, map[string]any{"a": i})
+	}
+	replies, err := exchange(t, c,
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}, "dryRun": true}},
+		Message{Type: "rows", Payload: map[string]any{"rows": rows}},
+		Message{Type: "end-stream"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dryRun, result := replies[len(replies)-2], replies[len(replies)-1]
+	if dryRun.Type != "dry-run" {
+		t.Fatalf("expected dry-run reply before stream-result, got %+v", dryRun)
+	}
+	report := dryRun.Payload.(map[string]any)
+	if len(report["payloads"].([]any)) != DryRunSampleSize || report["payloadCount"] != 12.0 || report["requests"] != 12.0 {
+		t.Errorf("unexpected dry-run report: %v", report)
+	}
+	if b, _ := json.Marshal(result.Payload); string(b) != `{"dryRun":true,"received":12}` {
+		t.Errorf("unexpected stream-result: %s", b)
+	}
+}
+
+func TestConnectorLimits(t *testing.T) {
+	t.Setenv(MaxMemoryEnv, "")
+	t.Setenv("GOMEMLIMIT", "")
+	t.Setenv(MaxConnectionsEnv, "2")
+	c := &countingConnector{}
+	replies, err := exchange(t, c,
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}}},
+		Message{Type: "end-stream"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.session.Limits == nil {
+		t.Fatal("limits must be set in session")
+	}
+	result := replies[len(replies)-1]
+	if b, _ := json.Marshal(result.Payload); string(b) != `{"limits":{"connectionWaitMs":0,"connectionWaits":0,"maxConnections":2},"received":0}` {
+		t.Errorf("unexpected stream-result: %s", b)
+	}
+}
+
+// sourceConnector emits rows one by one, each waits for acknowledgement of the previous one
+type sourceConnector struct {
+	countingConnector
+}
+
+func (c *sourceConnector) DescribeStreamsOf(payload DescribeStreamsPayload, session *Session) (map[string]any, error) {
+	return map[string]any{"defaultStream": payload.Credentials["table"]}, nil
+}
+
+func (c *sourceConnector) Emit(ctx context.Context) error {
+	emitter := c.session.NewEmitter(c.stream.Stream, EmitterOptions{MaxInFlight: 1, AckTimeout: time.Second})
+	for i := 1; i <= 3; i++ {
+		if err := emitter.EmitRow(map[string]any{"i": i}); err != nil {
+			return err
+		}
+	}
+	_, err := emitter.Finish()
+	return err
+}
+
+func TestConnectorSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewClient(ctx, NewConnectorHandler(&sourceConnector{}, nil))
+	_ = client.Send("describe-streams", map[string]any{"credentials": map[string]any{"table": "events"}})
+	if spec := <-client.Replies(); spec.Type != "stream-spec" || spec.Payload.(map[string]any)["defaultStream"] != "events" {
+		t.Fatalf("streams must be described with credentials: %+v", spec)
+	}
+	_ = client.Send("start-stream", map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}})
+	var rows int
+	for reply := range client.Replies() {
+		switch reply.Type {
+		case "row":
+			rows++
+			// the next row is emitted only after acknowledgement
+			_ = client.Send("ack", map[string]any{"rows": rows})
+		case "stream-result":
+			_ = client.Send("ack", map[string]any{"rows": rows, "final": true})
+		}
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 {
+		t.Errorf("emitted %d rows", rows)
+	}
+}
@@ -0,0 +1,221 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DedupStore remembers rows delivered by previous runs, so connectors of destinations without server-side
+// deduplication (like Mixpanel $insert_id) skip them. Row keys are hashed, hashes are kept in state under the prefix
+// with the time they were delivered, split into dedupBuckets entries by the first character of the hash:
+//
+//	["type=facebook-capi.dedup","pixel=123","bucket=a"] → {"hashes":{"a1b2c3d4e5f60718":1714557600}}
+//
+// Hashes older than TTL are forgotten, and once there are more than MaxEntries hashes the oldest are forgotten
+// first. State is read on the first Seen call and written by Flush. DedupStore is safe for concurrent use.
+// Nil store remembers nothing
+type DedupStore struct {
+	state   *RpcClient
+	prefix  []string
+	options DedupOptions
+
+	mu     sync.Mutex
+	loaded bool
+	hashes map[string]int64
+	dirty  map[string]bool
+}
+
+type DedupOptions struct {
+	// TTL is how long delivered rows are remembered. 0 means forever
+	TTL time.Duration
+	// MaxEntries is the maximum number of remembered rows. 0 means unlimited
+	MaxEntries int
+}
+
+const dedupBuckets = 16
+
+const dedupHashLength = 16
+
+// NewDedupStore returns store with state keys starting with prefix. With nil state rows are remembered only
+// during the run
+func NewDedupStore(state *RpcClient, options DedupOptions, prefix ...string) *DedupStore {
+	return &DedupStore{state: state, prefix: prefix, options: options, hashes: make(map[string]int64), dirty: make(map[string]bool)}
+}
+
+// DedupOptionsFromCredentials reads 'dedupTtlDays' and 'dedupMaxEntries' credentials options. The second return
+// value is false if dedupTtlDays is not set, i.e. deduplication is disabled
+func DedupOptionsFromCredentials(creds map[string]any) (DedupOptions, bool, error) {
+	ttlDays, ok := creds["dedupTtlDays"].(float64)
+	maxEntries, _ := creds["dedupMaxEntries"].(float64)
+	if ttlDays < 0 || maxEntries < 0 {
+		return DedupOptions{}, false, fmt.Errorf("dedupTtlDays and dedupMaxEntries must not be negative")
+	}
+	if !ok {
+		if maxEntries > 0 {
+			return DedupOptions{}, false, fmt.Errorf("dedupMaxEntries requires dedupTtlDays")
+		}
+		return DedupOptions{}, false, nil
+	}
+	return DedupOptions{TTL: time.Duration(ttlDays * float64(24*time.Hour)), MaxEntries: int(maxEntries)}, true, nil
+}
+
+// DedupKey returns key of the row made of values of the columns, e.g. a primary key
+func DedupKey(row map[string]any, columns ...string) string {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		b, _ := json.Marshal(row[column])
+		parts[i] = string(b)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+func dedupHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])[:dedupHashLength]
+}
+
+func (d *DedupStore) bucketKey(bucket string) []string {
+	return append(append([]string{}, d.prefix...), "bucket="+bucket)
+}
+
+// Seen checks whether the row with the key was delivered and isn't expired
+func (d *DedupStore) Seen(key string) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return false, err
+	}
+	deliveredAt, ok := d.hashes[dedupHash(key)]
+	return ok && !d.expired(deliveredAt, time.Now()), nil
+}
+
+// Mark remembers the row with the key as delivered. Call Flush to save it to state
+func (d *DedupStore) Mark(key string) {
+	if d == nil {
+		return
+	}
+	hash := dedupHash(key)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hashes[hash] = time.Now().Unix()
+	d.dirty[hash[:1]] = true
+}
+
+// Len returns the number of remembered rows
+func (d *DedupStore) Len() int {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.hashes)
+}
+
+// Flush forgets expired and excess rows and saves changed buckets to state
+func (d *DedupStore) Flush() error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.load(); err != nil {
+		return err
+	}
+	d.evict(time.Now())
+	if d.state == nil {
+		d.dirty = make(map[string]bool)
+		return nil
+	}
+	buckets := make(map[string]map[string]int64, len(d.dirty))
+	for bucket := range d.dirty {
+		buckets[bucket] = make(map[string]int64)
+	}
+	for hash, deliveredAt := range d.hashes {
+		if bucket, ok := buckets[hash[:1]]; ok {
+			bucket[hash] = deliveredAt
+		}
+	}
+	for bucket, hashes := range buckets {
+		var err error
+		if len(hashes) == 0 {
+			err = d.state.Del(d.bucketKey(bucket))
+		} else {
+			err = d.state.Set(d.bucketKey(bucket), map[string]any{"hashes": hashes})
+		}
+		if err != nil {
+			return fmt.Errorf("error saving dedup state: %w", err)
+		}
+		delete(d.dirty, bucket)
+	}
+	return nil
+}
+
+// load reads hashes from state once. Must be called with d.mu held
+func (d *DedupStore) load() error {
+	if d.loaded || d.state == nil {
+		return nil
+	}
+	now := time.Now()
+	// buckets are decoded one at a time, so only unexpired hashes are kept in memory
+	err := d.state.StreamList(d.prefix, func(key []string, raw any) error {
+		value, _ := raw.(map[string]any)
+		hashes, _ := value["hashes"].(map[string]any)
+		for hash, raw := range hashes {
+			deliveredAt, _ := raw.(float64)
+			if len(hash) != dedupHashLength || d.expired(int64(deliveredAt), now) {
+				continue
+			}
+			// hashes marked during the run are newer
+			if _, ok := d.hashes[hash]; !ok {
+				d.hashes[hash] = int64(deliveredAt)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error loading dedup state: %w", err)
+	}
+	d.loaded = true
+	return nil
+}
+
+func (d *DedupStore) expired(deliveredAt int64, now time.Time) bool {
+	return d.options.TTL > 0 && now.Sub(time.Unix(deliveredAt, 0)) > d.options.TTL
+}
+
+// evict forgets expired hashes, then the oldest hashes over MaxEntries. Must be called with d.mu held
+func (d *DedupStore) evict(now time.Time) {
+	for hash, deliveredAt := range d.hashes {
+		if d.expired(deliveredAt, now) {
+			delete(d.hashes, hash)
+			d.dirty[hash[:1]] = true
+		}
+	}
+	excess := len(d.hashes) - d.options.MaxEntries
+	if d.options.MaxEntries == 0 || excess <= 0 {
+		return
+	}
+	hashes := make([]string, 0, len(d.hashes))
+	for hash := range d.hashes {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		if d.hashes[hashes[i]] != d.hashes[hashes[j]] {
+			return d.hashes[hashes[i]] < d.hashes[hashes[j]]
+		}
+		return hashes[i] < hashes[j]
+	})
+	for _, hash := range hashes[:excess] {
+		delete(d.hashes, hash)
+		d.dirty[hash[:1]] = true
+	}
+}
@@ -0,0 +1,73 @@
+package sdk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupStore(t *testing.T) {
+	state, store := stateServer(t)
+	dedup := NewDedupStore(state, DedupOptions{TTL: time.Hour, MaxEntries: 3}, "type=test.dedup")
+	keys := []string{
+		DedupKey(map[string]any{"id": 1.0, "email": "a@example.com"}, "id"),
+		DedupKey(map[string]any{"id": 2.0}, "id"),
+		DedupKey(map[string]any{"id": "2"}, "id"),
+	}
+	for _, key := range keys {
+		if seen, err := dedup.Seen(key); seen || err != nil {
+			t.Fatalf("Seen(%q) = %v %v before Mark", key, seen, err)
+		}
+		dedup.Mark(key)
+	}
+	if err := dedup.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store) == 0 {
+		t.Fatalf("hashes aren't saved to state")
+	}
+	// the next run reads hashes from state
+	next := NewDedupStore(state, DedupOptions{TTL: time.Hour, MaxEntries: 3}, "type=test.dedup")
+	for _, key := range keys {
+		if seen, err := next.Seen(key); !seen || err != nil {
+			t.Errorf("Seen(%q) = %v %v, want true", key, seen, err)
+		}
+	}
+	// hashes over MaxEntries are forgotten, the oldest first
+	next.hashes[dedupHash(keys[0])] -= 60
+	next.Mark("4")
+	if err := next.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if next.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", next.Len())
+	}
+	third := NewDedupStore(state, DedupOptions{TTL: time.Hour}, "type=test.dedup")
+	if seen, _ := third.Seen(keys[0]); seen {
+		t.Errorf("the oldest row must be evicted")
+	}
+	if seen, _ := third.Seen("4"); !seen {
+		t.Errorf("the last marked row must be remembered")
+	}
+	// expired hashes are not seen
+	third.hashes[dedupHash("4")] -= 7200
+	if seen, _ := third.Seen("4"); seen {
+		t.Errorf("expired row must not be seen")
+	}
+	var nilStore *DedupStore
+	if seen, err := nilStore.Seen("4"); seen || err != nil {
+		t.Errorf("nil store must not remember rows")
+	}
+}
+
+func TestDedupOptionsFromCredentials(t *testing.T) {
+	options, enabled, err := DedupOptionsFromCredentials(map[string]any{"dedupTtlDays": 2.0, "dedupMaxEntries": 1000.0})
+	if err != nil || !enabled || options.TTL != 48*time.Hour || options.MaxEntries != 1000 {
+		t.Errorf("unexpected options: %+v %v %v", options, enabled, err)
+	}
+	if _, enabled, err = DedupOptionsFromCredentials(map[string]any{}); enabled || err != nil {
+		t.Errorf("dedup must be disabled without dedupTtlDays: %v %v", enabled, err)
+	}
+	if _, _, err = DedupOptionsFromCredentials(map[string]any{"dedupMaxEntries": 10.0}); err == nil {
+		t.Errorf("dedupMaxEntries without dedupTtlDays must be rejected")
+	}
+}
@@ -0,0 +1,51 @@
+package sdk
+
+import "sync"
+
+// DryRun records requests the connector would have made in dry run. Connectors run full validation, mapping and
+// batching, but instead of calling the destination API pass payloads of the request to Request. State is not written
+// and hooks are not run either. Before stream-result the SDK replies a sample of the payloads, so mappings can be verified without using
+// API quota:
+//
+//	{"type":"dry-run","direction":"reply","payload":{"payloads":[...],"payloadCount":1200,"requests":12}}
+//
+// and adds "dryRun":true to stream-result. Nil DryRun means the run is not dry
+type DryRun struct {
+	mu         sync.Mutex
+	sampleSize int
+	payloads   []any
+	count      int
+	requests   int
+}
+
+// DryRunSampleSize is the number of payloads reported in dry-run reply
+const DryRunSampleSize = 10
+
+func NewDryRun(sampleSize int) *DryRun {
+	return &DryRun{sampleSize: sampleSize}
+}
+
+// Request records a request that would have been sent with the payloads, e.g. events of a batch
+func (d *DryRun) Request(payloads ...any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests++
+	d.count += len(payloads)
+	for _, payload := range payloads {
+		if len(d.payloads) >= d.sampleSize {
+			break
+		}
+		d.payloads = append(d.payloads, payload)
+	}
+}
+
+// Report returns payload of dry-run reply
+func (d *DryRun) Report() map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	payloads := d.payloads
+	if payloads == nil {
+		payloads = []any{}
+	}
+	return map[string]any{"payloads": payloads, "payloadCount": d.count, "requests": d.requests}
+}
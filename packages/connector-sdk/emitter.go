@@ -0,0 +1,195 @@
+package sdk
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Message is a protocol message
+type Message struct {
+	Type      string `json:"type"`
+	Direction string `json:"direction"`
+	Payload   any    `json:"payload"`
+}
+
+// EmitterOptions configures flow control of EmitterSession
+type EmitterOptions struct {
+	// MaxInFlight is the maximum number of rows emitted but not acknowledged by the host.
+	// EmitRow blocks when the limit is reached. 0 disables backpressure.
+	MaxInFlight int
+	// AckTimeout is how long Finish waits for the final acknowledgement from the host. 0 means don't wait
+	AckTimeout time.Duration
+}
+
+// EmitterSession emits rows of a source stream to the host with correct framing:
+//
+//	{"type":"row","direction":"reply","payload":{"row":{...}}}
+//	{"type":"checkpoint","direction":"reply","payload":{"stream":"...","rows":1000,"state":{...}}}
+//	{"type":"stream-result","direction":"reply","payload":{"received":1000,"success":1000,"skipped":0,"failed":0}}
+//
+// Host acknowledges processed rows with {"type":"ack","payload":{"rows":<total acknowledged>}}
+// and the end of the stream with {"type":"ack","payload":{"final":true}}.
+type EmitterSession struct {
+	stream  string
+	options EmitterOptions
+
+	mu  sync.Mutex
+	out *bufio.Writer
+	// replier sends messages instead of out, see Session.NewEmitter
+	replier  Replier
+	emitted  int
+	acked    int
+	final    bool
+	ackCond  *sync.Cond
+	finished bool
+}
+
+func NewEmitterSession(stream string, out io.Writer, options EmitterOptions) *EmitterSession {
+	s := &EmitterSession{stream: stream, options: options, out: bufio.NewWriterSize(out, 256*1024)}
+	s.ackCond = sync.NewCond(&s.mu)
+	return s
+}
+
+// newReplyEmitter returns emitter sending messages with replier. Messages aren't buffered, since the replier is
+// shared with logs of the connector
+func newReplyEmitter(stream string, replier Replier, options EmitterOptions) *EmitterSession {
+	s := &EmitterSession{stream: stream, options: options, replier: replier}
+	s.ackCond = sync.NewCond(&s.mu)
+	return s
+}
+
+// ListenAcks reads incoming messages and handles acknowledgements. Other messages are passed to onMessage, if set.
+// Typically run in a separate goroutine with the scanner of stdin.
+func (s *EmitterSession) ListenAcks(scanner *bufio.Scanner, onMessage func(msgType string, payload json.RawMessage)) {
+	for scanner.Scan() {
+		var message struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			continue
+		}
+		if message.Type != "ack" {
+			if onMessage != nil {
+				onMessage(message.Type, message.Payload)
+			}
+			continue
+		}
+		var ack AckPayload
+		_ = json.Unmarshal(message.Payload, &ack)
+		s.Ack(ack.Rows, ack.Final)
+	}
+	// host closed stdin: nothing will be acknowledged anymore
+	s.Ack(0, true)
+}
+
+// Ack records that host processed rows total rows. final means that host received stream-result
+func (s *EmitterSession) Ack(rows int, final bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rows > s.acked {
+		s.acked = rows
+	}
+	if final {
+		s.final = true
+	}
+	s.ackCond.Broadcast()
+}
+
+// EmitRow sends a row to the host. Blocks while MaxInFlight rows are not acknowledged
+func (s *EmitterSession) EmitRow(row any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return fmt.Errorf("session of stream '%s' is finished", s.stream)
+	}
+	if s.options.MaxInFlight > 0 && s.emitted-s.acked >= s.options.MaxInFlight {
+		// host can't acknowledge rows it didn't receive
+		if err := s.flush(); err != nil {
+			return err
+		}
+		for s.emitted-s.acked >= s.options.MaxInFlight && !s.final {
+			s.ackCond.Wait()
+		}
+	}
+	if err := s.write("row", map[string]any{"row": row}); err != nil {
+		return err
+	}
+	s.emitted++
+	return nil
+}
+
+// EmitCheckpoint sends a checkpoint with the source state. Host persists the state once all previous rows are processed,
+// so the source can resume from it after a failure
+func (s *EmitterSession) EmitCheckpoint(state any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.write("checkpoint", map[string]any{"stream": s.stream, "rows": s.emitted, "state": state}); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// Finish sends stream-result and waits for the final acknowledgement if AckTimeout is set.
+// Returns number of emitted rows
+func (s *EmitterSession) Finish() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.finished {
+		return s.emitted, nil
+	}
+	s.finished = true
+	err := s.write("stream-result", map[string]any{"received": s.emitted, "success": s.emitted, "skipped": 0, "failed": 0})
+	if err == nil {
+		err = s.flush()
+	}
+	if err != nil || s.options.AckTimeout <= 0 {
+		return s.emitted, err
+	}
+	timer := time.AfterFunc(s.options.AckTimeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.ackCond.Broadcast()
+	})
+	defer timer.Stop()
+	deadline := time.Now().Add(s.options.AckTimeout)
+	for !s.final && time.Now().Before(deadline) {
+		s.ackCond.Wait()
+	}
+	if !s.final {
+		return s.emitted, fmt.Errorf("host didn't acknowledge stream '%s' in %s", s.stream, s.options.AckTimeout)
+	}
+	return s.emitted, nil
+}
+
+// Flush writes buffered messages to the host
+func (s *EmitterSession) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *EmitterSession) flush() error {
+	if s.out == nil {
+		return nil
+	}
+	return s.out.Flush()
+}
+
+func (s *EmitterSession) write(msgType string, payload any) error {
+	if s.replier != nil {
+		return s.replier.Reply(msgType, payload)
+	}
+	data, err := json.Marshal(Message{Type: msgType, Direction: "reply", Payload: payload})
+	if err != nil {
+		return err
+	}
+	if _, err = s.out.Write(data); err != nil {
+		return err
+	}
+	return s.out.WriteByte('\n')
+}
@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmitterSessionBackpressure(t *testing.T) {
+	out := &bytes.Buffer{}
+	s := NewEmitterSession("test", out, EmitterOptions{MaxInFlight: 2, AckTimeout: time.Second})
+	for i := 0; i < 2; i++ {
+		if err := s.EmitRow(map[string]any{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	emitted := make(chan struct{})
+	go func() {
+		_ = s.EmitRow(map[string]any{"i": 2})
+		close(emitted)
+	}()
+	select {
+	case <-emitted:
+		t.Fatal("EmitRow didn't block with 2 rows in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.Ack(1, false)
+	select {
+	case <-emitted:
+	case <-time.After(time.Second):
+		t.Fatal("EmitRow is blocked after acknowledgement")
+	}
+
+	pr, pw := io.Pipe()
+	go s.ListenAcks(bufio.NewScanner(pr), nil)
+	go func() {
+		_, _ = pw.Write([]byte(`{"type":"ack","payload":{"final":true}}` + "\n"))
+	}()
+	n, err := s.Finish()
+	if err != nil || n != 3 {
+		t.Fatalf("Finish() = %d, %v", n, err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 3 rows and stream-result, got: %v", lines)
+	}
+	var last map[string]any
+	_ = json.Unmarshal([]byte(lines[3]), &last)
+	if last["type"] != "stream-result" {
+		t.Errorf("last message = %v", last)
+	}
+}
@@ -0,0 +1,115 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Flattener expands columns holding nested JSON, objects or JSON strings of objects, into columns named with dot
+// paths before rows reach connector's mapping code. Warehouse rows often embed ids or utm data in a JSON column,
+// flattening saves users an upstream transformation:
+//
+//	{"ids": "{\"user\":{\"id\":1},\"utm_source\":\"google\"}"} -> {"ids.user.id": 1, "ids.utm_source": "google"}
+//
+// Arrays are kept as values. Flattened columns don't replace columns of the row with the same name.
+// Nil Flattener flattens nothing
+type Flattener struct {
+	// columns are sorted, so conflicts are resolved the same way for every row
+	columns []string
+}
+
+// NewFlattener creates flattener of the columns. Empty columns flatten nothing
+func NewFlattener(columns []string) *Flattener {
+	if len(columns) == 0 {
+		return nil
+	}
+	sorted := append([]string(nil), columns...)
+	sort.Strings(sorted)
+	return &Flattener{columns: sorted}
+}
+
+// FlattenerFromCredentials creates flattener from 'flattenColumns' array of column names. Nil if not set
+func FlattenerFromCredentials(credentials map[string]any) (*Flattener, error) {
+	raw, ok := credentials["flattenColumns"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	arr, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("flattenColumns must be an array of column names, got: %v", raw)
+	}
+	columns := make([]string, 0, len(arr))
+	for _, c := range arr {
+		s, ok := c.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("flattenColumns must be an array of column names, got: %v", c)
+		}
+		columns = append(columns, s)
+	}
+	return NewFlattener(columns), nil
+}
+
+// Enabled returns true if any column is flattened
+func (f *Flattener) Enabled() bool {
+	return f != nil && len(f.columns) > 0
+}
+
+// Apply flattens the columns of the row in place. Null and empty values are removed. Values that are neither objects
+// nor JSON strings of objects are kept as is and reported with error, the rest of the row is flattened anyway.
+// Numbers of JSON strings are decoded as json.Number, as numbers of rows are
+func (f *Flattener) Apply(row map[string]any) error {
+	if f == nil {
+		return nil
+	}
+	var errs []string
+	for _, column := range f.columns {
+		value, ok := row[column]
+		if !ok {
+			continue
+		}
+		if s, isString := value.(string); isString {
+			if strings.TrimSpace(s) == "" {
+				delete(row, column)
+				continue
+			}
+			decoder := json.NewDecoder(bytes.NewReader([]byte(s)))
+			decoder.UseNumber()
+			var parsed any
+			if err := decoder.Decode(&parsed); err != nil {
+				errs = append(errs, fmt.Sprintf("column %s is not valid JSON: %v", column, err))
+				continue
+			}
+			value = parsed
+		}
+		switch v := value.(type) {
+		case nil:
+			delete(row, column)
+		case map[string]any:
+			delete(row, column)
+			flattenInto(row, column, v)
+		default:
+			errs = append(errs, fmt.Sprintf("column %s is not a JSON object: %v", column, value))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot flatten %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// flattenInto adds fields of the object to the row as prefix.field columns, nested objects recursively
+func flattenInto(row map[string]any, prefix string, object map[string]any) {
+	for name, value := range object {
+		column := prefix + "." + name
+		if nested, ok := value.(map[string]any); ok {
+			flattenInto(row, column, nested)
+			continue
+		}
+		if _, exists := row[column]; !exists {
+			row[column] = value
+		}
+	}
+}
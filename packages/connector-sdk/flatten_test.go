@@ -0,0 +1,42 @@
+package sdk
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFlattener(t *testing.T) {
+	f, err := FlattenerFromCredentials(map[string]any{"flattenColumns": []any{"ids", "context", "tags", "empty"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := map[string]any{
+		"ids":     `{"user":{"id":1},"utm_source":"google"}`,
+		"context": map[string]any{"page": map[string]any{"url": "/a"}, "list": []any{1.0}},
+		"tags":    "[1,2]",
+		"empty":   "",
+		// existing columns are not replaced
+		"ids.utm_source": "bing",
+	}
+	err = f.Apply(row)
+	if err == nil || err.Error() != "cannot flatten column tags is not a JSON object: [1 2]" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := map[string]any{
+		"ids.user.id":      json.Number("1"),
+		"ids.utm_source":   "bing",
+		"context.page.url": "/a",
+		"context.list":     []any{1.0},
+		"tags":             "[1,2]",
+	}
+	if !reflect.DeepEqual(row, want) {
+		t.Errorf("got %v, want %v", row, want)
+	}
+	if f, err = FlattenerFromCredentials(map[string]any{}); f.Enabled() || err != nil {
+		t.Errorf("flattener must not be enabled without flattenColumns: %v", err)
+	}
+	if _, err = FlattenerFromCredentials(map[string]any{"flattenColumns": "ids"}); err == nil {
+		t.Error("flattenColumns must be an array")
+	}
+}
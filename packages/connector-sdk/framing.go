@@ -0,0 +1,246 @@
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+// Framing of incoming messages. By default messages are NDJSON lines, which breaks if a producer writes raw newlines
+// inside strings. With other framings each message is preceded by its size as 4-byte big-endian unsigned integer:
+//   - ndjson: one message per line (default)
+//   - length-prefixed: size, then JSON of the message
+//   - gzip: size, then gzip-compressed JSON of the message. The size limit applies to the decompressed message too
+//
+// Framing is selected with FramingEnv, or by the host with hello message sent as the first NDJSON line:
+//
+//	{"type":"hello","payload":{"framing":"length-prefixed"}}
+//
+// Messages after hello use the requested framing. Connector confirms it with hello reply. Replies are always NDJSON
+// The host sends hello only to connectors that list the framing in supportedFramings of spec. Hello may request
+// compression of both streams too, see CompressionEnv
+
+// FramingEnv selects framing of incoming messages
+const FramingEnv = "PROTOCOL_FRAMING"
+
+const (
+	FramingNdjson         = "ndjson"
+	FramingLengthPrefixed = "length-prefixed"
+	FramingGzip           = "gzip"
+)
+
+var SupportedFramings = []string{FramingNdjson, FramingLengthPrefixed, FramingGzip}
+
+// HelloPayload is payload of hello message
+type HelloPayload struct {
+	Framing     string `json:"framing,omitempty"`
+	Compression string `json:"compression,omitempty"`
+}
+
+// HelloReply is payload of hello reply
+func HelloReply(framing, compression string) map[string]any {
+	return map[string]any{
+		"framing":               framing,
+		"supportedFramings":     SupportedFramings,
+		"compression":           compression,
+		"supportedCompressions": SupportedCompressions,
+	}
+}
+
+// ReplyHello confirms framing and compression the reader switched to with hello reply. Replies after it are
+// compressed as requested
+func ReplyHello(reader *MessageReader, w *ReplyWriter) error {
+	if err := w.Reply("hello", HelloReply(reader.Framing(), reader.Compression())); err != nil {
+		return err
+	}
+	return w.Compress(reader.Compression())
+}
+
+// FramingFromEnv returns framing set with FramingEnv. Default is ndjson
+func FramingFromEnv() (string, error) {
+	framing := os.Getenv(FramingEnv)
+	if framing == "" {
+		return FramingNdjson, nil
+	}
+	if !slices.Contains(SupportedFramings, framing) {
+		return "", fmt.Errorf("unsupported %s: %s. Supported: %v", FramingEnv, framing, SupportedFramings)
+	}
+	return framing, nil
+}
+
+// MessageReader reads incoming messages of any framing. Like LineReader it skips messages larger than the limit
+// and reports them with *MessageTooLargeError
+type MessageReader struct {
+	r           *bufio.Reader
+	lines       *LineReader
+	maxSize     int
+	framing     string
+	compression string
+	// count is the number of messages read
+	count int
+	frame int
+	buf   []byte
+	out   bytes.Buffer
+}
+
+func NewMessageReader(r io.Reader, maxSize int, framing string) (*MessageReader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	m := &MessageReader{r: br, lines: &LineReader{r: br, maxSize: maxSize}, maxSize: maxSize, compression: CompressionNone}
+	if err := m.setFraming(framing); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Framing returns framing of the next message
+func (m *MessageReader) Framing() string {
+	return m.framing
+}
+
+// Compression returns compression of the input
+func (m *MessageReader) Compression() string {
+	return m.compression
+}
+
+// SetCompression decompresses the rest of the input, e.g. with compression selected with CompressionEnv before
+// the first message. Compression can't be changed once set
+func (m *MessageReader) SetCompression(compression string) error {
+	if err := checkCompression(compression); err != nil {
+		return err
+	}
+	if compression == m.compression {
+		return nil
+	}
+	if m.compression != CompressionNone {
+		return fmt.Errorf("input is %s-compressed already", m.compression)
+	}
+	m.r = bufio.NewReaderSize(&decompressor{r: m.r, compression: compression}, 64*1024)
+	m.lines.r = m.r
+	m.compression = compression
+	return nil
+}
+
+func (m *MessageReader) setFraming(framing string) error {
+	if !slices.Contains(SupportedFramings, framing) {
+		return fmt.Errorf("unsupported framing: %s. Supported: %v", framing, SupportedFramings)
+	}
+	m.framing = framing
+	return nil
+}
+
+// Next returns the next message without surrounding whitespace. The message is valid until the next call.
+// If the first message is hello, framing and compression are switched as requested. hello is returned too, so
+// the caller can reply to it with ReplyHello. Returns io.EOF when input is exhausted
+func (m *MessageReader) Next() ([]byte, error) {
+	var message []byte
+	var err error
+	if m.framing == FramingNdjson {
+		message, err = m.lines.Next()
+	} else {
+		message, err = m.nextFrame()
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.count++
+	if m.count == 1 {
+		var hello IncomingMessage
+		if json.Unmarshal(message, &hello) == nil && hello.Type == "hello" {
+			var payload HelloPayload
+			if err = hello.DecodePayload(&payload); err != nil {
+				return nil, fmt.Errorf("invalid hello payload: %w", err)
+			}
+			if payload.Framing != "" {
+				if err = m.setFraming(payload.Framing); err != nil {
+					return nil, err
+				}
+			}
+			if payload.Compression != "" {
+				if err = m.SetCompression(payload.Compression); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return message, nil
+}
+
+// nextFrame returns the next non-empty frame of length-prefixed or gzip framing
+func (m *MessageReader) nextFrame() ([]byte, error) {
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(m.r, header[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("frame %d is truncated: %w", m.frame+1, err)
+			}
+			return nil, err
+		}
+		m.frame++
+		size := int(binary.BigEndian.Uint32(header[:]))
+		if size > m.maxSize {
+			return nil, m.skipFrame(size)
+		}
+		m.buf = slices.Grow(m.buf[:0], size)[:size]
+		if _, err := io.ReadFull(m.r, m.buf); err != nil {
+			return nil, fmt.Errorf("frame %d is truncated: %w", m.frame, noEOF(err))
+		}
+		message := m.buf
+		if m.framing == FramingGzip && size > 0 {
+			gz, err := gzip.NewReader(bytes.NewReader(m.buf))
+			if err != nil {
+				return nil, fmt.Errorf("frame %d is not gzip-compressed: %w", m.frame, err)
+			}
+			m.out.Reset()
+			n, err := m.out.ReadFrom(io.LimitReader(gz, int64(m.maxSize)+1))
+			if err != nil {
+				return nil, fmt.Errorf("frame %d cannot be decompressed: %w", m.frame, err)
+			}
+			if n > int64(m.maxSize) {
+				return nil, m.tooLarge(int(n), m.out.Bytes())
+			}
+			message = m.out.Bytes()
+		}
+		if message = bytes.TrimSpace(message); len(message) > 0 {
+			return message, nil
+		}
+	}
+}
+
+// skipFrame discards the frame larger than the limit keeping its prefix to identify the message
+func (m *MessageReader) skipFrame(size int) error {
+	prefix := make([]byte, min(size, messagePrefixSize))
+	if _, err := io.ReadFull(m.r, prefix); err != nil {
+		return fmt.Errorf("frame %d is truncated: %w", m.frame, noEOF(err))
+	}
+	if _, err := m.r.Discard(size - len(prefix)); err != nil {
+		return fmt.Errorf("frame %d is truncated: %w", m.frame, noEOF(err))
+	}
+	if m.framing == FramingGzip {
+		// the type can't be found in compressed data
+		prefix = nil
+	}
+	return m.tooLarge(size, prefix)
+}
+
+func (m *MessageReader) tooLarge(size int, prefix []byte) *MessageTooLargeError {
+	tooLarge := &MessageTooLargeError{Line: m.frame, Frame: true, Size: size, MaxSize: m.maxSize}
+	if match := messageTypePattern.FindSubmatch(prefix[:min(len(prefix), messagePrefixSize)]); match != nil {
+		tooLarge.Type = string(match[1])
+	}
+	return tooLarge
+}
+
+// noEOF converts io.EOF in the middle of a frame to io.ErrUnexpectedEOF, so it isn't mistaken for the end of input
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func frame(t *testing.T, message string, compress bool) []byte {
+	body := []byte(message)
+	if compress {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		_, _ = gz.Write(body)
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+		body = b.Bytes()
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)
+}
+
+func TestMessageReader(t *testing.T) {
+	// raw newline inside of a string breaks NDJSON, but not length-prefixed frames
+	row := "{\"type\":\"row\",\"payload\":{\"row\":{\"v\":\"a\nb\"}}}"
+	big := `{"type":"row","payload":{"row":{"v":"` + strings.Repeat("x", 2048) + `"}}}`
+	for _, framing := range []string{FramingLengthPrefixed, FramingGzip} {
+		compress := framing == FramingGzip
+		var in bytes.Buffer
+		in.WriteString(`{"type":"hello","payload":{"framing":"` + framing + `"}}` + "\n")
+		in.Write(frame(t, row, compress))
+		in.Write(frame(t, big, compress))
+		in.Write(frame(t, "", compress))
+		in.Write(frame(t, `{"type":"end-stream"}`, compress))
+		reader, err := NewMessageReader(&in, 1024, FramingNdjson)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message, err := reader.Next(); err != nil || !strings.Contains(string(message), "hello") || reader.Framing() != framing {
+			t.Fatalf("%s: hello = %s, %v, framing %s", framing, message, err, reader.Framing())
+		}
+		if message, err := reader.Next(); err != nil || string(message) != row {
+			t.Fatalf("%s: Next() = %s, %v", framing, message, err)
+		}
+		_, err = reader.Next()
+		var tooLarge *MessageTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Line != 2 || !tooLarge.Frame {
+			t.Fatalf("%s: expected too large error, got %v", framing, err)
+		}
+		if message, err := reader.Next(); err != nil || string(message) != `{"type":"end-stream"}` {
+			t.Errorf("%s: reading must continue after too large message: %s, %v", framing, message, err)
+		}
+		if _, err = reader.Next(); err != io.EOF {
+			t.Errorf("%s: expected EOF, got %v", framing, err)
+		}
+	}
+	reader, _ := NewMessageReader(bytes.NewReader(frame(t, `{"type":"end-stream"}`, false)[:10]), 1024, FramingLengthPrefixed)
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("expected error for truncated frame, got %v", err)
+	}
+	reader, _ = NewMessageReader(strings.NewReader(`{"type":"hello","payload":{"framing":"xml"}}`), 1024, FramingNdjson)
+	if _, err := reader.Next(); err == nil {
+		t.Errorf("expected error for unsupported framing")
+	}
+}
+
+func TestRunRepliesHello(t *testing.T) {
+	var in bytes.Buffer
+	in.WriteString(`{"type":"hello","payload":{"framing":"length-prefixed"}}` + "\n")
+	in.Write(frame(t, `{"type":"end-stream"}`, false))
+	out := &bytes.Buffer{}
+	if err := Run(context.Background(), &in, out, echoHandler); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"hello","direction":"reply","payload":{"compression":"none","framing":"length-prefixed","supportedCompressions":["none","gzip","zstd"],"supportedFramings":["ndjson","length-prefixed","gzip"]}}` + "\n" +
+		`{"type":"stream-result","direction":"reply","payload":{"received":1}}` + "\n"
+	if out.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,344 @@
+package sdk
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Function is a function of connector expression languages, e.g. routing rules. Functions take and return
+// values of decoded JSON rows: strings, numbers (float64 or json.Number), booleans and nil. Missing or unparseable
+// input yields nil rather than error, errors are reserved for invalid arguments like unknown units. Such arguments
+// are checked before values, so expressions may be validated by calling functions with nil values.
+//
+// Connectors include Functions in spec reply, so hosts can offer autocomplete and docs
+type Function struct {
+	Name        string `json:"name"`
+	Signature   string `json:"signature"`
+	Description string `json:"description"`
+	Example     string `json:"example"`
+	minArgs     int
+	// maxArgs is -1 for variadic functions
+	maxArgs int
+	call    func(args []any) (any, error)
+}
+
+var functions = map[string]*Function{}
+
+func init() {
+	for _, f := range []*Function{
+		{
+			Name:        "sha256",
+			Signature:   "sha256(value)",
+			Description: "Hex encoded SHA-256 hash of the value as string",
+			Example:     `sha256(email) == "f660ab91..."`,
+			minArgs:     1,
+			maxArgs:     1,
+			call: func(args []any) (any, error) {
+				return hashString(args[0], func(b []byte) []byte { h := sha256.Sum256(b); return h[:] }), nil
+			},
+		},
+		{
+			Name:        "md5",
+			Signature:   "md5(value)",
+			Description: "Hex encoded MD5 hash of the value as string",
+			Example:     `md5(user_id) == "9f3d..."`,
+			minArgs:     1,
+			maxArgs:     1,
+			call: func(args []any) (any, error) {
+				return hashString(args[0], func(b []byte) []byte { h := md5.Sum(b); return h[:] }), nil
+			},
+		},
+		{
+			Name:        "lower",
+			Signature:   "lower(value)",
+			Description: "Value as lower case string",
+			Example:     `lower(region) == "eu"`,
+			minArgs:     1,
+			maxArgs:     1,
+			call: func(args []any) (any, error) {
+				if args[0] == nil {
+					return nil, nil
+				}
+				return strings.ToLower(functionString(args[0])), nil
+			},
+		},
+		{
+			Name:        "coalesce",
+			Signature:   "coalesce(value, ...)",
+			Description: "The first argument that is not null",
+			Example:     `coalesce(country, region) in ["DE", "EU"]`,
+			minArgs:     1,
+			maxArgs:     -1,
+			call: func(args []any) (any, error) {
+				for _, arg := range args {
+					if arg != nil {
+						return arg, nil
+					}
+				}
+				return nil, nil
+			},
+		},
+		{
+			Name:        "date_trunc",
+			Signature:   "date_trunc(unit, value)",
+			Description: "Truncates date or RFC 3339 timestamp to the start of second, minute, hour, day, week (Monday), month, quarter or year. Dates stay dates, timestamps are returned in UTC",
+			Example:     `date_trunc("month", date) == "2024-01-01"`,
+			minArgs:     2,
+			maxArgs:     2,
+			call:        dateTrunc,
+		},
+		{
+			Name:        "parse_url",
+			Signature:   "parse_url(url, part[, key])",
+			Description: "Part of URL: protocol, host, port, path, query or fragment. With key, value of the query parameter",
+			Example:     `parse_url(landing_page, "query", "utm_source") == "google"`,
+			minArgs:     2,
+			maxArgs:     3,
+			call:        parseUrl,
+		},
+		{
+			Name:        "regex_extract",
+			Signature:   "regex_extract(value, pattern[, group])",
+			Description: "Capturing group of the first match of RE2 pattern, the first group by default or the whole match if pattern has no groups. Null if nothing matches",
+			Example:     `regex_extract(campaign_name, "^(\\w+)_") == "brand"`,
+			minArgs:     2,
+			maxArgs:     3,
+			call:        regexExtract,
+		},
+		{
+			Name:        "to_e164",
+			Signature:   "to_e164(phone[, country_code])",
+			Description: "Phone number in E.164 format, e.g. +4915112345678. Numbers without international prefix get the country calling code, with national trunk prefix 0 removed. Null if the number is invalid",
+			Example:     `to_e164(phone, "49") =~ "^\\+49"`,
+			minArgs:     1,
+			maxArgs:     2,
+			call:        toE164,
+		},
+	} {
+		functions[f.Name] = f
+	}
+}
+
+// Functions returns all functions sorted by name
+func Functions() []*Function {
+	result := make([]*Function, 0, len(functions))
+	for _, f := range functions {
+		result = append(result, f)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// LookupFunction returns the function by name
+func LookupFunction(name string) (*Function, bool) {
+	f, ok := functions[strings.ToLower(name)]
+	return f, ok
+}
+
+// CheckArgs checks the number of arguments, so expressions can be validated when they are parsed
+func (f *Function) CheckArgs(n int) error {
+	if n < f.minArgs || (f.maxArgs >= 0 && n > f.maxArgs) {
+		return fmt.Errorf("%s: wrong number of arguments %d, expected %s", f.Name, n, f.Signature)
+	}
+	return nil
+}
+
+// Call calls the function
+func (f *Function) Call(args ...any) (any, error) {
+	if err := f.CheckArgs(len(args)); err != nil {
+		return nil, err
+	}
+	return f.call(args)
+}
+
+// functionString converts value to string. Numbers are formatted without exponent
+func functionString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func hashString(v any, hash func([]byte) []byte) any {
+	if v == nil {
+		return nil
+	}
+	return hex.EncodeToString(hash([]byte(functionString(v))))
+}
+
+var truncUnits = []string{"second", "minute", "hour", "day", "week", "month", "quarter", "year"}
+
+func dateTrunc(args []any) (any, error) {
+	unit := strings.ToLower(functionString(args[0]))
+	if !slices.Contains(truncUnits, unit) {
+		return nil, fmt.Errorf("date_trunc: unknown unit '%s'", unit)
+	}
+	s, ok := args[1].(string)
+	if !ok {
+		return nil, nil
+	}
+	date, err := time.Parse(time.DateOnly, s)
+	dateOnly := err == nil
+	if !dateOnly {
+		date, err = time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, nil
+		}
+		date = date.UTC()
+	}
+	y, m, d := date.Date()
+	switch unit {
+	case "second":
+		date = date.Truncate(time.Second)
+	case "minute":
+		date = date.Truncate(time.Minute)
+	case "hour":
+		date = date.Truncate(time.Hour)
+	case "day":
+		date = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	case "week":
+		weekday := (int(date.Weekday()) + 6) % 7
+		date = time.Date(y, m, d-weekday, 0, 0, 0, 0, time.UTC)
+	case "month":
+		date = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	case "quarter":
+		date = time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	case "year":
+		date = time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if dateOnly {
+		return date.Format(time.DateOnly), nil
+	}
+	return date.Format(time.RFC3339Nano), nil
+}
+
+var urlParts = []string{"protocol", "scheme", "host", "port", "path", "query", "fragment"}
+
+func parseUrl(args []any) (any, error) {
+	part := strings.ToLower(functionString(args[1]))
+	if !slices.Contains(urlParts, part) {
+		return nil, fmt.Errorf("parse_url: unknown part '%s'", part)
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(functionString(args[0]))
+	if err != nil {
+		return nil, nil
+	}
+	var value string
+	switch part {
+	case "protocol", "scheme":
+		value = u.Scheme
+	case "host":
+		value = u.Hostname()
+	case "port":
+		value = u.Port()
+	case "path":
+		value = u.Path
+	case "query":
+		if len(args) > 2 {
+			values := u.Query()
+			key := functionString(args[2])
+			if !values.Has(key) {
+				return nil, nil
+			}
+			return values.Get(key), nil
+		}
+		value = u.RawQuery
+	case "fragment":
+		value = u.Fragment
+	}
+	if value == "" {
+		return nil, nil
+	}
+	return value, nil
+}
+
+var regexpCache sync.Map
+
+func regexExtract(args []any) (any, error) {
+	pattern := functionString(args[1])
+	var re *regexp.Regexp
+	if cached, ok := regexpCache.Load(pattern); ok {
+		re = cached.(*regexp.Regexp)
+	} else {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("regex_extract: %v", err)
+		}
+		regexpCache.Store(pattern, re)
+	}
+	group := min(1, re.NumSubexp())
+	if len(args) > 2 {
+		n, err := strconv.Atoi(functionString(args[2]))
+		if err != nil || n < 0 || n > re.NumSubexp() {
+			return nil, fmt.Errorf("regex_extract: invalid group %v of pattern with %d groups", args[2], re.NumSubexp())
+		}
+		group = n
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	match := re.FindStringSubmatchIndex(functionString(args[0]))
+	if match == nil || match[2*group] < 0 {
+		return nil, nil
+	}
+	return functionString(args[0])[match[2*group]:match[2*group+1]], nil
+}
+
+func toE164(args []any) (any, error) {
+	countryCode := ""
+	if len(args) > 1 && args[1] != nil {
+		countryCode = strings.TrimPrefix(functionString(args[1]), "+")
+		if _, err := strconv.Atoi(countryCode); err != nil || len(countryCode) > 3 {
+			return nil, fmt.Errorf("to_e164: invalid country code '%v'", args[1])
+		}
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	phone := strings.TrimSpace(functionString(args[0]))
+	international := strings.HasPrefix(phone, "+")
+	var digits strings.Builder
+	for _, r := range strings.TrimPrefix(phone, "+") {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return nil, nil
+		}
+	}
+	number := digits.String()
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case countryCode != "":
+		number = countryCode + strings.TrimPrefix(number, "0")
+	default:
+		return nil, nil
+	}
+	// E.164 numbers have at most 15 digits, the shortest numbers in use have 8
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return nil, nil
+	}
+	return "+" + number, nil
+}
@@ -0,0 +1,80 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		name string
+		args []any
+		want any
+	}{
+		{"sha256", []any{"a@b.com"}, "fb98d44ad7501a959f3f4f4a3f004fe2d9e581ea6207e218c4b02c08a4d75adf"},
+		{"sha256", []any{nil}, nil},
+		{"md5", []any{json.Number("42")}, "a1d0c6e83f027327d8461063f4ac58a6"},
+		{"lower", []any{"EU"}, "eu"},
+		{"lower", []any{nil}, nil},
+		{"coalesce", []any{nil, "", "x"}, ""},
+		{"coalesce", []any{nil, nil}, nil},
+		{"date_trunc", []any{"month", "2024-02-17"}, "2024-02-01"},
+		{"date_trunc", []any{"week", "2024-02-18"}, "2024-02-12"},
+		{"date_trunc", []any{"quarter", "2024-06-30"}, "2024-04-01"},
+		{"date_trunc", []any{"hour", "2024-02-17T13:45:10+02:00"}, "2024-02-17T11:00:00Z"},
+		{"date_trunc", []any{"day", "yesterday"}, nil},
+		{"parse_url", []any{"https://example.com:8080/a/b?utm_source=google&x=1#top", "host"}, "example.com"},
+		{"parse_url", []any{"https://example.com:8080/a/b?utm_source=google&x=1#top", "PORT"}, "8080"},
+		{"parse_url", []any{"https://example.com/a/b?utm_source=google&x=1", "query", "utm_source"}, "google"},
+		{"parse_url", []any{"https://example.com/a/b?x=1", "query", "utm_source"}, nil},
+		{"parse_url", []any{"https://example.com/a/b#top", "fragment"}, "top"},
+		{"regex_extract", []any{"brand_search_de", `^(\w+?)_`}, "brand"},
+		{"regex_extract", []any{"brand_search_de", `_(\w+)_(\w+)$`, json.Number("2")}, "de"},
+		{"regex_extract", []any{"brand_search_de", `search`}, "search"},
+		{"regex_extract", []any{"brand", `^x(\w+)`}, nil},
+		{"to_e164", []any{"+49 (151) 123-45678"}, "+4915112345678"},
+		{"to_e164", []any{"0151 12345678", "+49"}, "+4915112345678"},
+		{"to_e164", []any{"0049 151 12345678"}, "+4915112345678"},
+		{"to_e164", []any{"(415) 555-2671", json.Number("1")}, "+14155552671"},
+		{"to_e164", []any{"555-2671"}, nil},
+		{"to_e164", []any{"call me", "1"}, nil},
+	}
+	for _, tt := range tests {
+		f, ok := LookupFunction(tt.name)
+		if !ok {
+			t.Fatalf("function %s not found", tt.name)
+		}
+		got, err := f.Call(tt.args...)
+		if err != nil {
+			t.Errorf("%s%v error: %v", tt.name, tt.args, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s%v = %v, want %v", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFunctionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []any
+	}{
+		{"lower", []any{}},
+		{"lower", []any{"a", "b"}},
+		{"date_trunc", []any{"fortnight", nil}},
+		{"parse_url", []any{nil, "user"}},
+		{"regex_extract", []any{nil, "("}},
+		{"regex_extract", []any{nil, "(a)", json.Number("2")}},
+		{"to_e164", []any{nil, "abc"}},
+	}
+	for _, tt := range tests {
+		f, _ := LookupFunction(tt.name)
+		if _, err := f.Call(tt.args...); err == nil {
+			t.Errorf("%s%v expected error", tt.name, tt.args)
+		}
+	}
+	if len(Functions()) != 8 || Functions()[0].Name != "coalesce" {
+		t.Errorf("unexpected functions: %v", Functions())
+	}
+}
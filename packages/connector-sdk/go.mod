@@ -0,0 +1,8 @@
+module github.com/jitsucom/syncmaven/connector-sdk
+
+go 1.22
+
+require (
+	github.com/klauspost/compress v1.16.7
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,154 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// HealthAddrEnv sets address of health endpoints, e.g. ":8080". Endpoints are served only if it's set
+const HealthAddrEnv = "HEALTH_ADDR"
+
+// DestinationCheckTTL is how long /readyz reuses the last result of a request to the destination
+const DestinationCheckTTL = 30 * time.Second
+
+// Health serves /healthz and /readyz endpoints for connectors deployed as services, so orchestrators can restart
+// wedged connector containers:
+//   - /healthz reports protocol handshake status and time since the last received message
+//   - /readyz additionally checks that a stream is started and the destination is reachable
+//
+// Reachability is the result of the last real request to the destination, see DestinationResult. Only if there
+// was no request within TTL, /readyz checks the destination with Check, so frequent probes don't hit the destination
+type Health struct {
+	// Check checks that the destination is reachable. It should go through the transport of destination requests,
+	// so proxy settings apply. Nil means the destination is not checked
+	Check func(ctx context.Context) error
+	TTL   time.Duration
+
+	mu              sync.Mutex
+	lastMessage     time.Time
+	lastMessageType string
+	streamStarted   bool
+	lastError       string
+	// unreachable is the error of the last request that didn't reach the destination, nil if it did
+	unreachable error
+	checkedAt   time.Time
+	// checking serializes checks, so concurrent probes make one request
+	checking sync.Mutex
+}
+
+// NewHealth returns Health with default TTL
+func NewHealth() *Health {
+	return &Health{TTL: DestinationCheckTTL}
+}
+
+// MessageReceived records a message received from the host
+func (h *Health) MessageReceived(msgType string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastMessage = time.Now()
+	h.lastMessageType = msgType
+	if msgType == "start-stream" {
+		h.streamStarted = true
+	}
+}
+
+// DestinationResult records the result of a request to the destination, nil if it succeeded. Errors of HTTP
+// transport, e.g. connection refused, mean the destination is unreachable. Error responses mean it's reachable
+func (h *Health) DestinationResult(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError = ""
+	h.unreachable = nil
+	if err != nil {
+		h.lastError = err.Error()
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			h.unreachable = err
+		}
+	}
+	h.checkedAt = time.Now()
+}
+
+// destination returns reachability of the destination, checking it if the last result is older than TTL
+func (h *Health) destination(ctx context.Context) error {
+	h.checking.Lock()
+	defer h.checking.Unlock()
+	h.mu.Lock()
+	fresh := !h.checkedAt.IsZero() && time.Since(h.checkedAt) < h.TTL
+	unreachable := h.unreachable
+	h.mu.Unlock()
+	if fresh || h.Check == nil {
+		return unreachable
+	}
+	err := h.Check(ctx)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unreachable = err
+	h.checkedAt = time.Now()
+	return err
+}
+
+// Handler returns handler of /healthz and /readyz
+func (h *Health) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		status := map[string]any{
+			"status":          "ok",
+			"handshake":       !h.lastMessage.IsZero(),
+			"lastMessageType": h.lastMessageType,
+		}
+		if !h.lastMessage.IsZero() {
+			status["lastMessageAgoSec"] = int(time.Since(h.lastMessage).Seconds())
+		}
+		h.mu.Unlock()
+		writeHealth(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+		err := h.destination(ctx)
+		h.mu.Lock()
+		streamStarted, lastError := h.streamStarted, h.lastError
+		h.mu.Unlock()
+		status := map[string]any{"streamStarted": streamStarted, "destination": "ok"}
+		code := http.StatusOK
+		if err != nil {
+			status["destination"] = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+		if lastError != "" {
+			status["lastError"] = lastError
+		}
+		if !streamStarted {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, status)
+	})
+	return mux
+}
+
+// Serve serves health endpoints in background if HealthAddrEnv is set. Server errors are logged with session
+func (h *Health) Serve(session *Session) {
+	addr := os.Getenv(HealthAddrEnv)
+	if addr == "" {
+		return
+	}
+	go func() {
+		if err := http.ListenAndServe(addr, h.Handler()); err != nil {
+			session.Error("Health server failed", err.Error())
+		}
+	}()
+}
+
+func writeHealth(w http.ResponseWriter, code int, status map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,59 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHealthReadiness(t *testing.T) {
+	h := NewHealth()
+	checks := 0
+	h.Check = func(ctx context.Context) error {
+		checks++
+		return errors.New("connection refused")
+	}
+	server := httptest.NewServer(h.Handler())
+	defer server.Close()
+	readyz := func() (int, map[string]any) {
+		res, err := http.Get(server.URL + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var status map[string]any
+		_ = json.NewDecoder(res.Body).Decode(&status)
+		return res.StatusCode, status
+	}
+
+	if code, status := readyz(); code != http.StatusServiceUnavailable || status["destination"] != "connection refused" {
+		t.Errorf("unreachable destination: %d %v", code, status)
+	}
+	// the result of the check is reused within TTL
+	readyz()
+	if checks != 1 {
+		t.Errorf("destination checked %d times, want 1", checks)
+	}
+
+	h.MessageReceived("start-stream")
+	h.DestinationResult(errors.New("Invalid token"))
+	if code, status := readyz(); code != http.StatusOK || status["destination"] != "ok" || status["lastError"] != "Invalid token" {
+		t.Errorf("error response means the destination is reachable: %d %v", code, status)
+	}
+	h.DestinationResult(&url.Error{Op: "Post", URL: "https://api.example.com", Err: errors.New("i/o timeout")})
+	if code, _ := readyz(); code != http.StatusServiceUnavailable || checks != 1 {
+		t.Errorf("failed request means the destination is unreachable: %d, %d checks", code, checks)
+	}
+
+	h.TTL = time.Millisecond
+	time.Sleep(2 * time.Millisecond)
+	readyz()
+	if checks != 2 {
+		t.Errorf("destination must be checked once the last result is older than TTL, %d checks", checks)
+	}
+}
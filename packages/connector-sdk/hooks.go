@@ -0,0 +1,102 @@
+package sdk
+
+import (
+	"context"
+	"sync"
+)
+
+// Hooks run connector-specific follow-ups at well-defined points of the stream, e.g. list recalculation after each
+// batch, MERGE of a staging table once a day is complete or lookup table refresh at the end. Hooks are registered with
+// OnBatchSent, OnDayCompleted and OnStreamCompleted, usually in StartStream. Connectors report sent batches and
+// completed days with BatchSent and DayCompleted, the SDK runs stream completed hooks after Connector.EndStream.
+// Hooks run in order of registration, the first error stops the rest. Nil Hooks run nothing
+type Hooks struct {
+	mu              sync.Mutex
+	batchSent       []func(ctx context.Context, batch BatchInfo) error
+	dayCompleted    []func(ctx context.Context, day string) error
+	streamCompleted []func(ctx context.Context, result any) error
+}
+
+// BatchInfo describes a batch sent to the destination
+type BatchInfo struct {
+	// Rows is the number of rows of the batch
+	Rows int
+	// Accepted is the number of rows accepted by the destination
+	Accepted int
+	// Days are dates of rows of the batch, if the connector tracks days
+	Days []string
+}
+
+func NewHooks() *Hooks {
+	return &Hooks{}
+}
+
+// OnBatchSent registers a hook run after each batch is sent
+func (h *Hooks) OnBatchSent(hook func(ctx context.Context, batch BatchInfo) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.batchSent = append(h.batchSent, hook)
+}
+
+// OnDayCompleted registers a hook run once all rows of a day are sent and the day is saved to the state
+func (h *Hooks) OnDayCompleted(hook func(ctx context.Context, day string) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dayCompleted = append(h.dayCompleted, hook)
+}
+
+// OnStreamCompleted registers a hook run after the stream is ended, before stream-result. result is the payload of
+// stream-result. Error halts the stream
+func (h *Hooks) OnStreamCompleted(hook func(ctx context.Context, result any) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.streamCompleted = append(h.streamCompleted, hook)
+}
+
+// BatchSent runs batch sent hooks
+func (h *Hooks) BatchSent(ctx context.Context, batch BatchInfo) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	hooks := h.batchSent
+	h.mu.Unlock()
+	for _, hook := range hooks {
+		if err := hook(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DayCompleted runs day completed hooks
+func (h *Hooks) DayCompleted(ctx context.Context, day string) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	hooks := h.dayCompleted
+	h.mu.Unlock()
+	for _, hook := range hooks {
+		if err := hook(ctx, day); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamCompleted runs stream completed hooks
+func (h *Hooks) StreamCompleted(ctx context.Context, result any) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	hooks := h.streamCompleted
+	h.mu.Unlock()
+	for _, hook := range hooks {
+		if err := hook(ctx, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
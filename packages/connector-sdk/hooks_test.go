@@ -0,0 +1,44 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	var calls []string
+	hooks := NewHooks()
+	hooks.OnBatchSent(func(ctx context.Context, batch BatchInfo) error {
+		calls = append(calls, "first")
+		if batch.Accepted < batch.Rows {
+			return errors.New("partial batch")
+		}
+		return nil
+	})
+	hooks.OnBatchSent(func(ctx context.Context, batch BatchInfo) error {
+		calls = append(calls, "second")
+		return nil
+	})
+	hooks.OnDayCompleted(func(ctx context.Context, day string) error {
+		calls = append(calls, day)
+		return nil
+	})
+	ctx := context.Background()
+	if err := hooks.BatchSent(ctx, BatchInfo{Rows: 2, Accepted: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hooks.BatchSent(ctx, BatchInfo{Rows: 2, Accepted: 1}); err == nil {
+		t.Error("expected error of the first hook")
+	}
+	if err := hooks.DayCompleted(ctx, "2025-09-01"); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 4 || calls[0] != "first" || calls[1] != "second" || calls[2] != "first" || calls[3] != "2025-09-01" {
+		t.Errorf("unexpected calls: %v", calls)
+	}
+	var nilHooks *Hooks
+	if err := nilHooks.StreamCompleted(ctx, nil); err != nil {
+		t.Errorf("nil hooks must run nothing, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits are resource limits the connector imposes on itself, so a misbehaving connector degrades predictably
+// inside shared runners instead of being killed by the OOM killer or exhausting connections of the runner:
+//
+//   - MaxMemoryEnv sets the soft memory limit in MiB. The limit is passed to the Go runtime the same way as
+//     GOMEMLIMIT, so GC works harder as memory grows. GOMEMLIMIT itself is used if the variable is not set.
+//     Above MemoryPressureRatio of the limit the connector is under memory pressure: connectors check
+//     MemoryPressure and send batches early or lower concurrency
+//   - MaxConnectionsEnv limits simultaneous outbound requests made with Transport. Requests over the limit wait
+//     for a free connection
+//
+// The SDK warns once memory pressure is detected and adds usage to stream-result as 'limits':
+//
+//	{"maxMemoryMb":512,"peakMemoryMb":470,"memoryPressure":3,"maxConnections":8,"connectionWaits":12,"connectionWaitMs":840}
+//
+// Nil Limits limit nothing
+type Limits struct {
+	maxMemory      int64
+	maxConnections int
+	connections    chan struct{}
+
+	mu             sync.Mutex
+	checkedAt      time.Time
+	underPressure  bool
+	peakMemory     int64
+	pressureEvents int
+	waits          int
+	waited         time.Duration
+	warn           func(message string)
+}
+
+const (
+	MaxMemoryEnv      = "MAX_MEMORY_MB"
+	MaxConnectionsEnv = "MAX_CONNECTIONS"
+)
+
+// MemoryPressureRatio is the share of the memory limit above which the connector is under memory pressure
+const MemoryPressureRatio = 0.9
+
+// memoryCheckInterval limits how often memory usage is read, MemoryPressure may be called for each row
+const memoryCheckInterval = 100 * time.Millisecond
+
+// NewLimits creates limits and sets the memory limit of the Go runtime. Zero values mean no limit
+func NewLimits(maxMemory int64, maxConnections int) *Limits {
+	l := &Limits{maxMemory: maxMemory, maxConnections: maxConnections}
+	if maxMemory > 0 {
+		debug.SetMemoryLimit(maxMemory)
+	}
+	if maxConnections > 0 {
+		l.connections = make(chan struct{}, maxConnections)
+	}
+	return l
+}
+
+// LimitsFromEnv returns limits set with MaxMemoryEnv, GOMEMLIMIT and MaxConnectionsEnv, or nil if none is set
+func LimitsFromEnv() (*Limits, error) {
+	var maxMemory int64
+	if s := os.Getenv(MaxMemoryEnv); s != "" {
+		mb, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || mb <= 0 {
+			return nil, fmt.Errorf("%s must be a positive integer, got: %s", MaxMemoryEnv, s)
+		}
+		maxMemory = mb * 1024 * 1024
+	} else if os.Getenv("GOMEMLIMIT") != "" {
+		// applied by the runtime already, negative input only reads the limit
+		maxMemory = debug.SetMemoryLimit(-1)
+	}
+	var maxConnections int
+	if s := os.Getenv(MaxConnectionsEnv); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%s must be a positive integer, got: %s", MaxConnectionsEnv, s)
+		}
+		maxConnections = n
+	}
+	if maxMemory <= 0 && maxConnections == 0 {
+		return nil, nil
+	}
+	return NewLimits(maxMemory, maxConnections), nil
+}
+
+// OnMemoryPressure sets the function called once memory pressure is first detected, e.g. Session.Warn
+func (l *Limits) OnMemoryPressure(warn func(message string)) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warn = warn
+}
+
+// MemoryPressure checks whether memory used by the connector is above MemoryPressureRatio of the limit.
+// Usage is read at most every 100ms
+func (l *Limits) MemoryPressure() bool {
+	if l == nil || l.maxMemory <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	if time.Since(l.checkedAt) < memoryCheckInterval {
+		defer l.mu.Unlock()
+		return l.underPressure
+	}
+	l.checkedAt = time.Now()
+	used := memoryUsed()
+	l.peakMemory = max(l.peakMemory, used)
+	wasUnderPressure := l.underPressure
+	l.underPressure = float64(used) > float64(l.maxMemory)*MemoryPressureRatio
+	var warn func(message string)
+	if l.underPressure && !wasUnderPressure {
+		l.pressureEvents++
+		if l.pressureEvents == 1 {
+			warn = l.warn
+		}
+	}
+	underPressure := l.underPressure
+	l.mu.Unlock()
+	if warn != nil {
+		warn(fmt.Sprintf("Memory used %d MiB is above %.0f%% of the limit of %d MiB. Connector slows down to stay within the limit", used>>20, MemoryPressureRatio*100, l.maxMemory>>20))
+	}
+	return underPressure
+}
+
+// memoryUsed returns memory used by the Go runtime, as accounted by the memory limit
+func memoryUsed() int64 {
+	samples := []metrics.Sample{{Name: "/memory/classes/total:bytes"}, {Name: "/memory/classes/heap/released:bytes"}}
+	metrics.Read(samples)
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+// Transport limits simultaneous requests made with base transport. A connection is held until the response
+// body is closed
+func (l *Limits) Transport(base http.RoundTripper) http.RoundTripper {
+	if l == nil || l.connections == nil {
+		return base
+	}
+	return &limitingTransport{base: base, limits: l}
+}
+
+// acquire waits for a free connection
+func (l *Limits) acquire(ctx context.Context) error {
+	select {
+	case l.connections <- struct{}{}:
+		return nil
+	default:
+	}
+	start := time.Now()
+	select {
+	case l.connections <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.waits++
+	l.waited += time.Since(start)
+	return nil
+}
+
+func (l *Limits) release() {
+	<-l.connections
+}
+
+type limitingTransport struct {
+	base   http.RoundTripper
+	limits *Limits
+}
+
+func (t *limitingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limits.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.limits.release()
+		return nil, err
+	}
+	res.Body = &releasingBody{ReadCloser: res.Body, release: t.limits.release}
+	return res, nil
+}
+
+// releasingBody releases the connection once the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// Report returns usage of the limits for stream-result
+func (l *Limits) Report() map[string]any {
+	if l == nil {
+		return nil
+	}
+	// peak includes memory at the end of the stream
+	l.MemoryPressure()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report := map[string]any{}
+	if l.maxMemory > 0 {
+		report["maxMemoryMb"] = l.maxMemory >> 20
+		report["peakMemoryMb"] = l.peakMemory >> 20
+		report["memoryPressure"] = l.pressureEvents
+	}
+	if l.maxConnections > 0 {
+		report["maxConnections"] = l.maxConnections
+		report["connectionWaits"] = l.waits
+		report["connectionWaitMs"] = l.waited.Milliseconds()
+	}
+	return report
+}
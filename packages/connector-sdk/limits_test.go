@@ -0,0 +1,95 @@
+package sdk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimitsTransport(t *testing.T) {
+	var active, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+	limits := NewLimits(0, 2)
+	client := &http.Client{Transport: limits.Transport(http.DefaultTransport)}
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = res.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if peak.Load() > 2 {
+		t.Errorf("%d simultaneous requests, want at most 2", peak.Load())
+	}
+	report := limits.Report()
+	if report["maxConnections"] != 2 || report["connectionWaits"].(int) == 0 {
+		t.Errorf("unexpected report: %v", report)
+	}
+	if _, ok := report["maxMemoryMb"]; ok {
+		t.Errorf("memory must not be reported without memory limit: %v", report)
+	}
+}
+
+func TestLimitsMemoryPressure(t *testing.T) {
+	// not created with NewLimits, so the limit of the test process is not changed
+	var warnings []string
+	limits := &Limits{maxMemory: 1 << 20}
+	limits.OnMemoryPressure(func(message string) { warnings = append(warnings, message) })
+	if !limits.MemoryPressure() {
+		t.Fatal("memory pressure must be detected above 1 MiB")
+	}
+	limits.checkedAt = time.Time{}
+	limits.MemoryPressure()
+	if len(warnings) != 1 {
+		t.Errorf("memory pressure must be warned once, got: %v", warnings)
+	}
+	report := limits.Report()
+	if report["maxMemoryMb"] != int64(1) || report["memoryPressure"] != 1 || report["peakMemoryMb"].(int64) < 1 {
+		t.Errorf("unexpected report: %v", report)
+	}
+	if (*Limits)(nil).MemoryPressure() || NewLimits(0, 0).MemoryPressure() {
+		t.Error("memory pressure must not be detected without memory limit")
+	}
+}
+
+func TestLimitsFromEnv(t *testing.T) {
+	t.Setenv(MaxMemoryEnv, "")
+	t.Setenv("GOMEMLIMIT", "")
+	t.Setenv(MaxConnectionsEnv, "")
+	if limits, err := LimitsFromEnv(); limits != nil || err != nil {
+		t.Errorf("limits must not be created without env: %v %v", limits, err)
+	}
+	t.Setenv(MaxConnectionsEnv, "4")
+	limits, err := LimitsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.maxConnections != 4 || limits.maxMemory != 0 {
+		t.Errorf("unexpected limits: %+v", limits)
+	}
+	t.Setenv(MaxMemoryEnv, "-1")
+	if _, err = LimitsFromEnv(); err == nil {
+		t.Error("negative memory limit must be rejected")
+	}
+}
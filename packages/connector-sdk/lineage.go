@@ -0,0 +1,121 @@
+package sdk
+
+import (
+	"sort"
+	"sync"
+)
+
+// Lineage collects column-level lineage of a stream: which source columns became which destination
+// properties, with what transforms, and which columns were dropped. Connectors send it in a "lineage" reply
+// before stream-result, so data catalog integrations (OpenLineage, DataHub) can record lineage of each sync:
+//
+//	{"type":"lineage","direction":"reply","payload":{"stream":"AdData","destination":"mixpanel","dataset":"$ad_spend",
+//	  "columns":[{"sources":["cost"],"destination":"$ad_cost","transforms":["decimal","round:2:half-even"]}],
+//	  "dropped":[{"source":"raw_json","reason":"not_selected"}]}}
+type Lineage struct {
+	mu          sync.Mutex
+	stream      string
+	destination string
+	dataset     string
+	columns     map[string]*ColumnLineage
+	dropped     map[string]string
+}
+
+// ColumnLineage describes how a destination property is derived from source columns
+type ColumnLineage struct {
+	Sources     []string `json:"sources"`
+	Destination string   `json:"destination"`
+	Transforms  []string `json:"transforms,omitempty"`
+}
+
+// DroppedColumn is a source column that didn't reach the destination
+type DroppedColumn struct {
+	Source string `json:"source"`
+	Reason string `json:"reason"`
+}
+
+// Reasons of dropped columns
+const (
+	DropNotSelected = "not_selected"
+	DropUnmapped    = "unmapped"
+	DropRouting     = "routing"
+)
+
+// NewLineage creates lineage of the stream written to dataset (e.g. table or event name) of the destination
+func NewLineage(stream, destination, dataset string) *Lineage {
+	return &Lineage{stream: stream, destination: destination, dataset: dataset, columns: make(map[string]*ColumnLineage), dropped: make(map[string]string)}
+}
+
+// Map records that destination property is derived from sources with transforms. Repeated calls for
+// the same destination add new sources and transforms
+func (l *Lineage) Map(destination string, sources []string, transforms ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.columns[destination]
+	if !ok {
+		c = &ColumnLineage{Destination: destination}
+		l.columns[destination] = c
+	}
+	c.Sources = appendMissing(c.Sources, sources...)
+	c.Transforms = appendMissing(c.Transforms, transforms...)
+}
+
+// Drop records that source column didn't reach the destination. The first reason wins
+func (l *Lineage) Drop(source string, reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.dropped[source]; !ok {
+		l.dropped[source] = reason
+	}
+}
+
+// Columns returns mapped properties sorted by destination name
+func (l *Lineage) Columns() []ColumnLineage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]ColumnLineage, 0, len(l.columns))
+	for _, c := range l.columns {
+		result = append(result, ColumnLineage{Sources: append([]string(nil), c.Sources...), Destination: c.Destination, Transforms: append([]string(nil), c.Transforms...)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Destination < result[j].Destination })
+	return result
+}
+
+// Dropped returns dropped columns sorted by source name
+func (l *Lineage) Dropped() []DroppedColumn {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]DroppedColumn, 0, len(l.dropped))
+	for source, reason := range l.dropped {
+		result = append(result, DroppedColumn{Source: source, Reason: reason})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Source < result[j].Source })
+	return result
+}
+
+// Payload returns payload of the lineage reply
+func (l *Lineage) Payload() map[string]any {
+	return map[string]any{
+		"stream":      l.stream,
+		"destination": l.destination,
+		"dataset":     l.dataset,
+		"columns":     l.Columns(),
+		"dropped":     l.Dropped(),
+	}
+}
+
+func appendMissing(values []string, add ...string) []string {
+	for _, a := range add {
+		found := false
+		for _, v := range values {
+			if v == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, a)
+		}
+	}
+	return values
+}
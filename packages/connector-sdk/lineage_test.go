@@ -0,0 +1,22 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLineage(t *testing.T) {
+	l := NewLineage("AdData", "mixpanel", "$ad_spend")
+	l.Map("$ad_cost", []string{"cost"}, "decimal")
+	l.Map("$ad_cost", []string{"cost"}, "decimal", "round:2:half-even")
+	l.Map("$insert_id", []string{"date", "source"}, "md5")
+	l.Drop("raw", DropNotSelected)
+	l.Drop("raw", DropUnmapped)
+	b, _ := json.Marshal(l.Payload())
+	want := `{"columns":[{"sources":["cost"],"destination":"$ad_cost","transforms":["decimal","round:2:half-even"]},` +
+		`{"sources":["date","source"],"destination":"$insert_id","transforms":["md5"]}],` +
+		`"dataset":"$ad_spend","destination":"mixpanel","dropped":[{"source":"raw","reason":"not_selected"}],"stream":"AdData"}`
+	if string(b) != want {
+		t.Errorf("got  %s\nwant %s", b, want)
+	}
+}
@@ -0,0 +1,114 @@
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// MaxMessageSizeEnv overrides MaxMessageSize, in megabytes
+const MaxMessageSizeEnv = "MAX_MESSAGE_SIZE_MB"
+
+// messagePrefixSize is how much of an oversized message is kept to identify it
+const messagePrefixSize = 256
+
+var messageTypePattern = regexp.MustCompile(`"type"\s*:\s*"([^"]*)"`)
+
+// MessageTooLargeError is returned by LineReader for a message larger than the limit. The message is skipped,
+// so reading may continue with the next one
+type MessageTooLargeError struct {
+	// Line is the number of the line, starting from 1. Number of the frame if Frame is set, see MessageReader
+	Line  int
+	Frame bool
+	// Type is the message type if it's found in the beginning of the message
+	Type    string
+	Size    int
+	MaxSize int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	msgType := e.Type
+	if msgType == "" {
+		msgType = "unknown"
+	}
+	unit := "line"
+	if e.Frame {
+		unit = "frame"
+	}
+	return fmt.Sprintf("message on %s %d of type '%s' is %d bytes, larger than the limit of %d bytes. Increase %s to accept it",
+		unit, e.Line, msgType, e.Size, e.MaxSize, MaxMessageSizeEnv)
+}
+
+// MaxMessageSizeFromEnv returns MaxMessageSize or the limit set with MaxMessageSizeEnv
+func MaxMessageSizeFromEnv() (int, error) {
+	s := os.Getenv(MaxMessageSizeEnv)
+	if s == "" {
+		return MaxMessageSize, nil
+	}
+	mb, err := strconv.Atoi(s)
+	if err != nil || mb <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got: %s", MaxMessageSizeEnv, s)
+	}
+	return mb * 1024 * 1024, nil
+}
+
+// LineReader reads NDJSON messages. Unlike bufio.Scanner it doesn't stop on a message larger than the limit:
+// the message is skipped and reported with *MessageTooLargeError
+type LineReader struct {
+	r       *bufio.Reader
+	maxSize int
+	line    int
+	buf     []byte
+}
+
+func NewLineReader(r io.Reader, maxSize int) *LineReader {
+	return &LineReader{r: bufio.NewReaderSize(r, 64*1024), maxSize: maxSize}
+}
+
+// Next returns the next non-empty line without surrounding whitespace. The line is valid until the next call.
+// Returns io.EOF when input is exhausted
+func (l *LineReader) Next() ([]byte, error) {
+	for {
+		line, err := l.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func (l *LineReader) readLine() ([]byte, error) {
+	l.buf = l.buf[:0]
+	l.line++
+	size := 0
+	for {
+		chunk, err := l.r.ReadSlice('\n')
+		size += len(chunk)
+		if size <= l.maxSize {
+			l.buf = append(l.buf, chunk...)
+		} else if len(l.buf) < messagePrefixSize {
+			l.buf = append(l.buf, chunk[:min(len(chunk), messagePrefixSize-len(l.buf))]...)
+		}
+		switch {
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case err != nil && (err != io.EOF || size == 0):
+			return nil, err
+		}
+		if size > l.maxSize {
+			tooLarge := &MessageTooLargeError{Line: l.line, Size: size, MaxSize: l.maxSize}
+			if m := messageTypePattern.FindSubmatch(l.buf); m != nil {
+				tooLarge.Type = string(m[1])
+			}
+			return nil, tooLarge
+		}
+		return l.buf, nil
+	}
+}
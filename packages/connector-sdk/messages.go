@@ -0,0 +1,244 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Typed payloads of incoming messages. DecodeMessage decodes and validates them, so connectors don't read payloads
+// with unchecked type assertions. Errors name the invalid field, so they can be replied with halt as is
+
+// HostRequirements may be sent by the host in describe and start-stream payloads
+type HostRequirements struct {
+	ProtocolVersion int      `json:"protocolVersion,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
+}
+
+// DescribePayload is payload of describe message
+type DescribePayload struct {
+	HostRequirements
+}
+
+// StartStream is payload of start-stream message
+type StartStream struct {
+	HostRequirements
+	Stream                string         `json:"stream"`
+	SyncId                string         `json:"syncId"`
+	ConnectionCredentials map[string]any `json:"connectionCredentials"`
+	StreamOptions         map[string]any `json:"streamOptions"`
+	FullRefresh           bool           `json:"fullRefresh,omitempty"`
+	// TraceId identifies the sync run across systems. Connectors add it to logs and requests, see TraceIdHeader
+	TraceId string `json:"traceId,omitempty"`
+	// RowsFile is a file with rows of the stream, read by the connector instead of row messages
+	RowsFile json.RawMessage `json:"rowsFile,omitempty"`
+	// StartDate and EndDate (YYYY-MM-DD, inclusive) override the days the connector computes from its state
+	// and settings, e.g. initial sync days and lookback window. Days of the range are resent even if already sent
+	StartDate string `json:"startDate,omitempty"`
+	EndDate   string `json:"endDate,omitempty"`
+	// Restate are ranges the connector must send again, even though its state says they are sent
+	Restate []RestatementRange `json:"restate,omitempty"`
+	// FeatureFlags enable experimental behaviors of the connector for this sync
+	FeatureFlags FeatureFlags `json:"featureFlags,omitempty"`
+	// DryRun makes the connector validate, map and batch rows without sending them, see DryRun
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// FeatureFlags let the host roll out risky connector behaviors gradually, sync by sync. Values are booleans
+// or strings, e.g. {"adaptiveConcurrency": true, "insertIdStrategy": "sha256"}. Connectors ignore unknown flags
+type FeatureFlags map[string]any
+
+// Bool returns value of boolean flag. Strings "true" and "false" are accepted too. Returns def if the flag
+// is not set or isn't boolean
+func (f FeatureFlags) Bool(name string, def bool) bool {
+	switch v := f[name].(type) {
+	case bool:
+		return v
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// String returns value of string flag. Returns def if the flag is not set or is empty
+func (f FeatureFlags) String(name string, def string) string {
+	if v, ok := f[name].(string); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Has returns true if the flag is set
+func (f FeatureFlags) Has(name string) bool {
+	_, ok := f[name]
+	return ok
+}
+
+// Names returns sorted names of the flags, e.g. to log them at the stream start
+func (f FeatureFlags) Names() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RestatementRange is an inclusive range of dates (YYYY-MM-DD) or RFC 3339 datetimes
+type RestatementRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Tenant of the range, if the connector writes to several destinations. Empty means all tenants
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// DateRange returns the overridden range of days. Zero time means the bound is not overridden
+func (s *StartStream) DateRange() (from time.Time, to time.Time, err error) {
+	if s.StartDate != "" {
+		if from, err = time.Parse(time.DateOnly, s.StartDate); err != nil {
+			return from, to, fmt.Errorf("startDate must be YYYY-MM-DD, got %q", s.StartDate)
+		}
+	}
+	if s.EndDate != "" {
+		if to, err = time.Parse(time.DateOnly, s.EndDate); err != nil {
+			return from, to, fmt.Errorf("endDate must be YYYY-MM-DD, got %q", s.EndDate)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return from, to, fmt.Errorf("endDate %s is before startDate %s", s.EndDate, s.StartDate)
+	}
+	return from, to, nil
+}
+
+func (s *StartStream) Validate() error {
+	if s.Stream == "" {
+		return fmt.Errorf("stream is required")
+	}
+	if s.ConnectionCredentials == nil {
+		return fmt.Errorf("connectionCredentials are required")
+	}
+	for i, r := range s.Restate {
+		if r.From == "" || r.To == "" {
+			return fmt.Errorf("restate range #%d must have from and to", i)
+		}
+	}
+	_, _, err := s.DateRange()
+	return err
+}
+
+// RowMessage is payload of row message
+type RowMessage struct {
+	Row map[string]any `json:"row"`
+	// Ordinal is set by the host to a monotonically increasing number of the row, see OrdinalTracker
+	Ordinal *int64 `json:"ordinal,omitempty"`
+}
+
+func (m *RowMessage) Validate() error {
+	if m.Row == nil {
+		return fmt.Errorf("row is missing")
+	}
+	return nil
+}
+
+// RowsMessage is payload of rows message
+type RowsMessage struct {
+	Rows []map[string]any `json:"rows"`
+	// Ordinals are ordinals of the rows, see RowMessage.Ordinal
+	Ordinals []int64 `json:"ordinals,omitempty"`
+}
+
+func (m *RowsMessage) Validate() error {
+	for i, row := range m.Rows {
+		if row == nil {
+			return fmt.Errorf("row #%d is not an object", i)
+		}
+	}
+	if m.Ordinals != nil && len(m.Ordinals) != len(m.Rows) {
+		return fmt.Errorf("%d ordinals for %d rows", len(m.Ordinals), len(m.Rows))
+	}
+	return nil
+}
+
+// HaltPayload is payload of halt message. Policy is either flush or discard
+type HaltPayload struct {
+	Reason string `json:"reason,omitempty"`
+	Policy string `json:"policy,omitempty"`
+}
+
+// HistoryPayload is payload of history message
+type HistoryPayload struct {
+	SyncId string `json:"syncId"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+func (h *HistoryPayload) Validate() error {
+	if h.SyncId == "" {
+		return fmt.Errorf("syncId is required")
+	}
+	return nil
+}
+
+// CredentialsUpdatedPayload is payload of credentials-updated message, sent by the host when credentials
+// are rotated during the stream
+type CredentialsUpdatedPayload struct {
+	ConnectionCredentials map[string]any `json:"connectionCredentials"`
+}
+
+func (c *CredentialsUpdatedPayload) Validate() error {
+	if c.ConnectionCredentials == nil {
+		return fmt.Errorf("connectionCredentials are required")
+	}
+	return nil
+}
+
+// DescribeStreamsPayload is payload of describe-streams message
+type DescribeStreamsPayload struct {
+	Credentials map[string]any `json:"credentials"`
+}
+
+// AckPayload is payload of ack message, sent by the host to sources. Rows is the total number of processed rows,
+// Final means that the host received stream-result
+type AckPayload struct {
+	Rows  int  `json:"rows"`
+	Final bool `json:"final"`
+}
+
+// CheckPayload is payload of check message
+type CheckPayload struct {
+	ConnectionCredentials map[string]any `json:"connectionCredentials"`
+}
+
+// DecodeMessage decodes payload of the message into T and validates it if T has Validate method.
+// Numbers of untyped fields are decoded as float64. Rows that may contain big numbers should be decoded with
+// DecodePayload instead
+func DecodeMessage[T any](message IncomingMessage) (T, error) {
+	var payload T
+	if len(message.Payload) > 0 {
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			return payload, payloadError(message.Type, err)
+		}
+	}
+	if v, ok := any(&payload).(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return payload, fmt.Errorf("invalid %s payload: %w", message.Type, err)
+		}
+	}
+	return payload, nil
+}
+
+// payloadError describes decoding error, e.g. "invalid start-stream payload: 'stream' must be string, got number"
+func payloadError(messageType string, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field == "" {
+			return fmt.Errorf("invalid %s payload: must be %s, got %s", messageType, typeErr.Type, typeErr.Value)
+		}
+		return fmt.Errorf("invalid %s payload: '%s' must be %s, got %s", messageType, typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	return fmt.Errorf("invalid %s payload: %w", messageType, err)
+}
@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	message := IncomingMessage{Type: "start-stream", Payload: json.RawMessage(
+		`{"stream":"s","protocolVersion":2,"connectionCredentials":{"batchSize":10},"rowsFile":{"path":"rows.parquet"}}`)}
+	stream, err := DecodeMessage[StartStream](message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Stream != "s" || stream.ProtocolVersion != 2 || stream.ConnectionCredentials["batchSize"] != 10.0 {
+		t.Errorf("unexpected payload: %+v", stream)
+	}
+	if string(stream.RowsFile) != `{"path":"rows.parquet"}` {
+		t.Errorf("unexpected rowsFile: %s", stream.RowsFile)
+	}
+	tests := []struct {
+		payload string
+		err     string
+	}{
+		{`{"stream":1,"connectionCredentials":{}}`, "invalid start-stream payload: 'stream' must be string, got number"},
+		{`{"stream":"s","connectionCredentials":[]}`, "invalid start-stream payload: 'connectionCredentials' must be map[string]interface {}, got array"},
+		{`{"stream":"s"}`, "invalid start-stream payload: connectionCredentials are required"},
+		{`[]`, "invalid start-stream payload: must be sdk.StartStream, got array"},
+		{`{"stream":"s","connectionCredentials":{},"restate":[{"from":"2024-03-01"}]}`, "invalid start-stream payload: restate range #0 must have from and to"},
+		{`{"stream":"s","connectionCredentials":{},"startDate":"2024-03"}`, `invalid start-stream payload: startDate must be YYYY-MM-DD, got "2024-03"`},
+		{`{"stream":"s","connectionCredentials":{},"startDate":"2024-03-07","endDate":"2024-03-01"}`, "invalid start-stream payload: endDate 2024-03-01 is before startDate 2024-03-07"},
+	}
+	for _, test := range tests {
+		message.Payload = json.RawMessage(test.payload)
+		if _, err = DecodeMessage[StartStream](message); err == nil || err.Error() != test.err {
+			t.Errorf("DecodeMessage(%s) = %v, want %q", test.payload, err, test.err)
+		}
+	}
+	message.Payload = json.RawMessage(`{"stream":"s","connectionCredentials":{},"featureFlags":{"aggregate":true,"legacy":"false","insertIdStrategy":"sha256"}}`)
+	if stream, err = DecodeMessage[StartStream](message); err != nil {
+		t.Fatal(err)
+	}
+	flags := stream.FeatureFlags
+	if !flags.Bool("aggregate", false) || flags.Bool("legacy", true) || !flags.Bool("missing", true) || flags.Bool("insertIdStrategy", false) {
+		t.Errorf("unexpected boolean flags: %v", flags)
+	}
+	if flags.String("insertIdStrategy", "md5") != "sha256" || flags.String("missing", "md5") != "md5" || flags.String("aggregate", "x") != "x" {
+		t.Errorf("unexpected string flags: %v", flags)
+	}
+	if names := flags.Names(); len(names) != 3 || names[0] != "aggregate" || !flags.Has("legacy") {
+		t.Errorf("unexpected flag names: %v", names)
+	}
+	if _, err = DecodeMessage[HaltPayload](IncomingMessage{Type: "halt"}); err != nil {
+		t.Errorf("payload of halt is optional: %v", err)
+	}
+}
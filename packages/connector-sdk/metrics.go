@@ -0,0 +1,202 @@
+package sdk
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics are performance counters of the stream sent to the host with periodic metric replies, so the orchestrator
+// can scrape them instead of parsing log lines. Counters are totals since the stream start, rates and latency
+// percentiles are computed over the interval since the previous reply:
+//
+//	{"rows":1200,"rowsPerSecond":40.5,"bytesSent":524288,"requests":12,"retries":1,
+//	 "latencyMs":{"p50":120,"p95":480},"queueDepth":2,"intervalSeconds":30}
+//
+// Nil Metrics records nothing
+type Metrics struct {
+	mu         sync.Mutex
+	start      time.Time
+	rows       int64
+	bytesSent  int64
+	requests   int64
+	retries    int64
+	queueDepth int
+	// latencies are observed since the last snapshot, at most maxLatencySamples of them
+	latencies []time.Duration
+	lastRows  int64
+	lastAt    time.Time
+}
+
+// MetricsIntervalEnv sets the interval of metric replies in seconds. 0 disables them
+const MetricsIntervalEnv = "METRICS_INTERVAL_SECONDS"
+
+const DefaultMetricsInterval = 30 * time.Second
+
+const maxLatencySamples = 10000
+
+func NewMetrics() *Metrics {
+	now := time.Now()
+	return &Metrics{start: now, lastAt: now}
+}
+
+// MetricsIntervalFromEnv returns DefaultMetricsInterval or the interval set with MetricsIntervalEnv
+func MetricsIntervalFromEnv() (time.Duration, error) {
+	s := os.Getenv(MetricsIntervalEnv)
+	if s == "" {
+		return DefaultMetricsInterval, nil
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number, got: %s", MetricsIntervalEnv, s)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// AddRows counts rows received from the host
+func (m *Metrics) AddRows(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rows += int64(n)
+}
+
+// AddBytesSent counts bytes sent to the destination
+func (m *Metrics) AddBytesSent(n int) {
+	if m == nil || n <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytesSent += int64(n)
+}
+
+// ObserveRequest records a request to the destination API and its latency
+func (m *Metrics) ObserveRequest(latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	if len(m.latencies) < maxLatencySamples {
+		m.latencies = append(m.latencies, latency)
+	}
+}
+
+// AddRetry counts a retried request
+func (m *Metrics) AddRetry() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries++
+}
+
+// SetQueueDepth sets the number of batches or rows waiting to be sent
+func (m *Metrics) SetQueueDepth(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queueDepth = n
+}
+
+// Snapshot returns payload of metric reply and starts a new interval
+func (m *Metrics) Snapshot() map[string]any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	interval := now.Sub(m.lastAt)
+	payload := map[string]any{
+		"rows":            m.rows,
+		"bytesSent":       m.bytesSent,
+		"requests":        m.requests,
+		"retries":         m.retries,
+		"queueDepth":      m.queueDepth,
+		"intervalSeconds": interval.Seconds(),
+		"elapsedSeconds":  now.Sub(m.start).Seconds(),
+	}
+	if interval > 0 {
+		payload["rowsPerSecond"] = float64(m.rows-m.lastRows) / interval.Seconds()
+	}
+	if len(m.latencies) > 0 {
+		slices.Sort(m.latencies)
+		payload["latencyMs"] = map[string]any{
+			"p50": percentile(m.latencies, 0.50).Milliseconds(),
+			"p95": percentile(m.latencies, 0.95).Milliseconds(),
+		}
+	}
+	m.latencies = m.latencies[:0]
+	m.lastRows = m.rows
+	m.lastAt = now
+	return payload
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(float64(len(sorted))*p+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// Report replies metric every interval until the returned stop function is called. Stop sends the last metric
+// reply, so the host gets totals of the stream. Nothing is sent with zero interval or nil Metrics
+func (m *Metrics) Report(replier Replier, interval time.Duration) (stop func()) {
+	if m == nil || interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_ = replier.Reply("metric", m.Snapshot())
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			_ = replier.Reply("metric", m.Snapshot())
+		})
+	}
+}
+
+// Transport records requests made with base transport: latency and bytes of request bodies
+func (m *Metrics) Transport(base http.RoundTripper) http.RoundTripper {
+	if m == nil {
+		return base
+	}
+	return &metricsTransport{base: base, metrics: m}
+}
+
+type metricsTransport struct {
+	base    http.RoundTripper
+	metrics *Metrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	t.metrics.ObserveRequest(time.Since(start))
+	if err == nil {
+		t.metrics.AddBytesSent(int(req.ContentLength))
+	}
+	return res, err
+}
@@ -0,0 +1,67 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.AddRows(10)
+	m.AddRetry()
+	m.SetQueueDepth(3)
+	for i := 1; i <= 20; i++ {
+		m.ObserveRequest(time.Duration(i) * time.Millisecond)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: m.Transport(http.DefaultTransport)}
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	snapshot := m.Snapshot()
+	if snapshot["rows"] != int64(10) || snapshot["retries"] != int64(1) || snapshot["queueDepth"] != 3 ||
+		snapshot["requests"] != int64(21) || snapshot["bytesSent"] != int64(7) {
+		t.Errorf("unexpected snapshot: %v", snapshot)
+	}
+	latency := snapshot["latencyMs"].(map[string]any)
+	if latency["p50"] != int64(10) || latency["p95"].(int64) < 19 {
+		t.Errorf("unexpected latency: %v", latency)
+	}
+	// rates and latencies are reset with each snapshot
+	if snapshot = m.Snapshot(); snapshot["rowsPerSecond"] != 0.0 || snapshot["latencyMs"] != nil || snapshot["rows"] != int64(10) {
+		t.Errorf("unexpected second snapshot: %v", snapshot)
+	}
+	var nilMetrics *Metrics
+	nilMetrics.AddRows(1)
+	nilMetrics.Report(nil, time.Second)()
+}
+
+func TestMetricsReport(t *testing.T) {
+	out := &bytes.Buffer{}
+	replier := NewReplyWriter(out)
+	m := NewMetrics()
+	m.AddRows(5)
+	stop := m.Report(replier, 10*time.Millisecond)
+	time.Sleep(35 * time.Millisecond)
+	stop()
+	stop()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected periodic and final metric replies, got %s", out.String())
+	}
+	var last Message
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil || last.Type != "metric" {
+		t.Fatalf("unexpected reply: %s, %v", lines[len(lines)-1], err)
+	}
+	if last.Payload.(map[string]any)["rows"] != 5.0 {
+		t.Errorf("unexpected metric: %v", last.Payload)
+	}
+}
@@ -0,0 +1,89 @@
+package sdk
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Naming conventions of destination properties
+const (
+	NamingPreserve  = "preserve"
+	NamingSnakeCase = "snake_case"
+	NamingCamelCase = "camelCase"
+)
+
+// Naming converts column names to destination property names
+type Naming struct {
+	convention string
+	template   string
+}
+
+// NewNaming creates Naming with the convention and the template of property names. Template may contain {column}
+// placeholder that is replaced with converted column name, e.g. "ad_{column}". Empty template means "{column}"
+func NewNaming(convention, template string) (*Naming, error) {
+	switch convention {
+	case "":
+		convention = NamingPreserve
+	case NamingPreserve, NamingSnakeCase, NamingCamelCase:
+	default:
+		return nil, fmt.Errorf("unknown naming convention: %s", convention)
+	}
+	if template != "" && !strings.Contains(template, "{column}") {
+		return nil, fmt.Errorf("property name template must contain {column} placeholder: %s", template)
+	}
+	return &Naming{convention: convention, template: template}, nil
+}
+
+// Convert applies naming convention to the name
+func (n *Naming) Convert(name string) string {
+	if n == nil {
+		return name
+	}
+	switch n.convention {
+	case NamingSnakeCase:
+		return strings.Join(splitWords(name), "_")
+	case NamingCamelCase:
+		words := splitWords(name)
+		for i := 1; i < len(words); i++ {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+		return strings.Join(words, "")
+	}
+	return name
+}
+
+// PropertyName applies naming convention and the template to the column name
+func (n *Naming) PropertyName(column string) string {
+	name := n.Convert(column)
+	if n == nil || n.template == "" {
+		return name
+	}
+	return strings.ReplaceAll(n.template, "{column}", name)
+}
+
+// splitWords splits name into lower-cased words on separators and case changes: "adGroup_ID" -> [ad group id]
+func splitWords(name string) []string {
+	var words []string
+	var current []rune
+	runes := []rune(name)
+	flush := func() {
+		if len(current) > 0 {
+			words = append(words, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ' || r == '.':
+			flush()
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))):
+			flush()
+			current = append(current, r)
+		default:
+			current = append(current, r)
+		}
+	}
+	flush()
+	return words
+}
@@ -0,0 +1,31 @@
+package sdk
+
+import "testing"
+
+func TestNaming(t *testing.T) {
+	tests := []struct {
+		convention string
+		template   string
+		column     string
+		want       string
+	}{
+		{NamingPreserve, "", "Ad_Group", "Ad_Group"},
+		{NamingSnakeCase, "", "adGroupID", "ad_group_id"},
+		{NamingSnakeCase, "", "HTTPStatus code", "http_status_code"},
+		{NamingCamelCase, "", "utm_source", "utmSource"},
+		{NamingCamelCase, "", "Campaign-Name", "campaignName"},
+		{NamingSnakeCase, "ad_{column}", "creativeType", "ad_creative_type"},
+	}
+	for _, tt := range tests {
+		n, err := NewNaming(tt.convention, tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := n.PropertyName(tt.column); got != tt.want {
+			t.Errorf("%s %s PropertyName(%s) = %s, want %s", tt.convention, tt.template, tt.column, got, tt.want)
+		}
+	}
+	if _, err := NewNaming("kebab", ""); err == nil {
+		t.Error("expected error for unknown convention")
+	}
+}
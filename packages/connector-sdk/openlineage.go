@@ -0,0 +1,176 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenLineage run event types
+const (
+	OpenLineageStart    = "START"
+	OpenLineageComplete = "COMPLETE"
+	OpenLineageFail     = "FAIL"
+	OpenLineageAbort    = "ABORT"
+)
+
+const openLineageProducer = "https://github.com/syncmaven/syncmaven/tree/main/packages/connector-sdk"
+const openLineageSchemaURL = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/$defs/RunEvent"
+
+// OpenLineage posts run events of a sync to an OpenLineage endpoint (Marquez, DataHub, etc.), so syncs show up
+// in lineage graphs. Start is sent when the stream starts; Complete, Fail or Abort when it ends:
+//
+//	{"eventType":"START","eventTime":"2024-05-01T10:00:00Z","run":{"runId":"..."},
+//	  "job":{"namespace":"syncmaven","name":"<sync id>"},"inputs":[...],"outputs":[...],"producer":"...","schemaURL":"..."}
+type OpenLineage struct {
+	url       string
+	apiKey    string
+	namespace string
+	job       string
+	runId     string
+	client    http.Client
+}
+
+// OpenLineageDataset is an input or output dataset of the run
+type OpenLineageDataset struct {
+	Namespace string         `json:"namespace"`
+	Name      string         `json:"name"`
+	Facets    map[string]any `json:"facets,omitempty"`
+	// OutputFacets are run-specific facets of output datasets, e.g. outputStatistics
+	OutputFacets map[string]any `json:"outputFacets,omitempty"`
+}
+
+// NewOpenLineage creates a client posting events of the job run to url, e.g. http://marquez:5000/api/v1/lineage.
+// apiKey is sent as Bearer token if set. Empty runId is replaced with a random one, see NewRunId
+func NewOpenLineage(url, apiKey, namespace, job, runId string) *OpenLineage {
+	if namespace == "" {
+		namespace = "syncmaven"
+	}
+	if runId == "" {
+		runId = NewRunId()
+	}
+	return &OpenLineage{url: url, apiKey: apiKey, namespace: namespace, job: job, runId: runId, client: http.Client{Timeout: 5 * time.Second}}
+}
+
+// Namespace returns namespace of the job. Datasets produced by syncmaven (e.g. streams) use it too
+func (o *OpenLineage) Namespace() string {
+	return o.namespace
+}
+
+// RunId returns id of the run reported in events
+func (o *OpenLineage) RunId() string {
+	return o.runId
+}
+
+// Emit posts a run event. errorMessage is reported in errorMessage run facet, usually for FAIL events
+func (o *OpenLineage) Emit(eventType string, inputs, outputs []OpenLineageDataset, errorMessage string) error {
+	run := map[string]any{"runId": o.runId}
+	if errorMessage != "" {
+		run["facets"] = map[string]any{"errorMessage": map[string]any{
+			"_producer":           openLineageProducer,
+			"_schemaURL":          "https://openlineage.io/spec/facets/1-0-0/ErrorMessageRunFacet.json#/$defs/ErrorMessageRunFacet",
+			"message":             errorMessage,
+			"programmingLanguage": "go",
+		}}
+	}
+	if inputs == nil {
+		inputs = []OpenLineageDataset{}
+	}
+	if outputs == nil {
+		outputs = []OpenLineageDataset{}
+	}
+	event := map[string]any{
+		"eventType": eventType,
+		"eventTime": time.Now().UTC().Format(time.RFC3339Nano),
+		"run":       run,
+		"job":       map[string]any{"namespace": o.namespace, "name": o.job},
+		"inputs":    inputs,
+		"outputs":   outputs,
+		"producer":  openLineageProducer,
+		"schemaURL": openLineageSchemaURL,
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot post OpenLineage %s event: %v", eventType, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		respBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("OpenLineage %s event rejected. HTTP code = %d response: %s", eventType, resp.StatusCode, string(respBytes))
+	}
+	return nil
+}
+
+// SchemaFacet returns schema dataset facet with destination properties of the lineage
+func SchemaFacet(lineage *Lineage) map[string]any {
+	columns := lineage.Columns()
+	fields := make([]map[string]any, len(columns))
+	for i, c := range columns {
+		fields[i] = map[string]any{"name": c.Destination}
+	}
+	return map[string]any{
+		"_producer":  openLineageProducer,
+		"_schemaURL": "https://openlineage.io/spec/facets/1-1-1/SchemaDatasetFacet.json#/$defs/SchemaDatasetFacet",
+		"fields":     fields,
+	}
+}
+
+// ColumnLineageFacet returns column lineage dataset facet of the output. Sources of the lineage are fields of the input dataset
+func ColumnLineageFacet(lineage *Lineage, input OpenLineageDataset) map[string]any {
+	fields := make(map[string]any)
+	for _, c := range lineage.Columns() {
+		inputFields := make([]map[string]any, len(c.Sources))
+		for i, source := range c.Sources {
+			inputFields[i] = map[string]any{"namespace": input.Namespace, "name": input.Name, "field": source}
+		}
+		transformationType := "IDENTITY"
+		if len(c.Transforms) > 0 {
+			transformationType = "TRANSFORMED"
+		}
+		fields[c.Destination] = map[string]any{
+			"inputFields":               inputFields,
+			"transformationDescription": strings.Join(c.Transforms, ", "),
+			"transformationType":        transformationType,
+		}
+	}
+	return map[string]any{
+		"_producer":  openLineageProducer,
+		"_schemaURL": "https://openlineage.io/spec/facets/1-0-1/ColumnLineageDatasetFacet.json#/$defs/ColumnLineageDatasetFacet",
+		"fields":     fields,
+	}
+}
+
+// OutputStatisticsFacet returns output statistics facet with the number of rows written
+func OutputStatisticsFacet(rowCount int) map[string]any {
+	return map[string]any{
+		"_producer":  openLineageProducer,
+		"_schemaURL": "https://openlineage.io/spec/facets/1-0-2/OutputStatisticsOutputDatasetFacet.json#/$defs/OutputStatisticsOutputDatasetFacet",
+		"rowCount":   rowCount,
+	}
+}
+
+// NewRunId returns a random UUID identifying a run in OpenLineage events, manifests and logs
+func NewRunId() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
@@ -0,0 +1,63 @@
+package sdk
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenLineageEmit(t *testing.T) {
+	var events []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	lineage := NewLineage("AdData", "mixpanel", "$ad_spend")
+	lineage.Map("$ad_cost", []string{"cost"}, "decimal")
+	lineage.Map("campaign_id", []string{"campaign_id"})
+	ol := NewOpenLineage(srv.URL, "key", "", "sync-1", "")
+	input := OpenLineageDataset{Namespace: ol.Namespace(), Name: "AdData"}
+	output := OpenLineageDataset{Namespace: "mixpanel://api.mixpanel.com", Name: "$ad_spend"}
+	if err := ol.Emit(OpenLineageStart, []OpenLineageDataset{input}, []OpenLineageDataset{output}, ""); err != nil {
+		t.Fatal(err)
+	}
+	output.Facets = map[string]any{"columnLineage": ColumnLineageFacet(lineage, input)}
+	if err := ol.Emit(OpenLineageFail, []OpenLineageDataset{input}, []OpenLineageDataset{output}, "destination unavailable"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events", len(events))
+	}
+	start, fail := events[0], events[1]
+	if start["eventType"] != "START" || fail["eventType"] != "FAIL" || start["job"].(map[string]any)["namespace"] != "syncmaven" {
+		t.Errorf("unexpected events: %v", events)
+	}
+	if start["run"].(map[string]any)["runId"] != fail["run"].(map[string]any)["runId"] || len(ol.RunId()) != 36 {
+		t.Errorf("events must share run id %s", ol.RunId())
+	}
+	if msg := fail["run"].(map[string]any)["facets"].(map[string]any)["errorMessage"].(map[string]any)["message"]; msg != "destination unavailable" {
+		t.Errorf("errorMessage facet = %v", msg)
+	}
+	fields := fail["outputs"].([]any)[0].(map[string]any)["facets"].(map[string]any)["columnLineage"].(map[string]any)["fields"].(map[string]any)
+	cost := fields["$ad_cost"].(map[string]any)
+	if cost["transformationType"] != "TRANSFORMED" || cost["inputFields"].([]any)[0].(map[string]any)["field"] != "cost" {
+		t.Errorf("unexpected column lineage of $ad_cost: %v", cost)
+	}
+	if fields["campaign_id"].(map[string]any)["transformationType"] != "IDENTITY" {
+		t.Errorf("unexpected column lineage of campaign_id: %v", fields["campaign_id"])
+	}
+
+	bad := NewOpenLineage(srv.URL, "wrong", "", "sync-1", ol.RunId())
+	if err := bad.Emit(OpenLineageStart, nil, nil, ""); err == nil {
+		t.Error("expected error for rejected event")
+	}
+}
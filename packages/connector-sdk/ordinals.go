@@ -0,0 +1,77 @@
+package sdk
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// OrdinalTracker tracks delivery of rows numbered by the host with monotonically increasing ordinals. Watermark is
+// the highest ordinal such that the row and all rows before it are delivered, so the host can resume the stream
+// right after it regardless of date-based state. The SDK reports the watermark with checkpoint replies and adds it
+// to stream-result as 'ordinal':
+//
+//	{"type":"checkpoint","direction":"reply","payload":{"stream":"...","rows":1000,"ordinal":998}}
+//
+// A row is delivered once Connector.Row returns, unless the connector holds it with Session.HoldRow and reports
+// delivery later with Session.RowDelivered, e.g. after the batch of the row is sent
+type OrdinalTracker struct {
+	mu sync.Mutex
+	// pending are received ordinals after the watermark, in increasing order
+	pending   []pendingOrdinal
+	last      int64
+	received  bool
+	watermark int64
+	// delivered is set once the watermark is set
+	delivered bool
+}
+
+type pendingOrdinal struct {
+	ordinal   int64
+	delivered bool
+}
+
+func NewOrdinalTracker() *OrdinalTracker {
+	return &OrdinalTracker{}
+}
+
+// Receive records the ordinal of a received row. Ordinals must increase
+func (o *OrdinalTracker) Receive(ordinal int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.received && ordinal <= o.last {
+		return fmt.Errorf("row ordinal %d must be greater than ordinal %d of the previous row", ordinal, o.last)
+	}
+	o.received = true
+	o.last = ordinal
+	o.pending = append(o.pending, pendingOrdinal{ordinal: ordinal})
+	return nil
+}
+
+// Deliver marks the row delivered and advances the watermark over delivered rows. Unknown ordinals are ignored
+func (o *OrdinalTracker) Deliver(ordinal int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	i := sort.Search(len(o.pending), func(i int) bool { return o.pending[i].ordinal >= ordinal })
+	if i == len(o.pending) || o.pending[i].ordinal != ordinal {
+		return
+	}
+	o.pending[i].delivered = true
+	n := 0
+	for n < len(o.pending) && o.pending[n].delivered {
+		n++
+	}
+	if n > 0 {
+		o.watermark = o.pending[n-1].ordinal
+		o.delivered = true
+		o.pending = o.pending[n:]
+	}
+}
+
+// Watermark returns the highest ordinal delivered together with all rows before it. ok is false until the first
+// row is delivered
+func (o *OrdinalTracker) Watermark() (ordinal int64, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.watermark, o.delivered
+}
@@ -0,0 +1,92 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestOrdinalTracker(t *testing.T) {
+	o := NewOrdinalTracker()
+	if _, ok := o.Watermark(); ok {
+		t.Error("watermark must not be set before delivery")
+	}
+	for _, ordinal := range []int64{1, 2, 5, 7} {
+		if err := o.Receive(ordinal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := o.Receive(7); err == nil {
+		t.Error("expected error for not increasing ordinal")
+	}
+	o.Deliver(2)
+	o.Deliver(5)
+	if _, ok := o.Watermark(); ok {
+		t.Error("watermark must wait for the first row")
+	}
+	o.Deliver(1)
+	if ordinal, _ := o.Watermark(); ordinal != 5 {
+		t.Errorf("watermark = %d, want 5", ordinal)
+	}
+	o.Deliver(3)
+	o.Deliver(7)
+	if ordinal, _ := o.Watermark(); ordinal != 7 {
+		t.Errorf("watermark = %d, want 7", ordinal)
+	}
+}
+
+// holdingConnector holds rows with hold column until the end of the stream
+type holdingConnector struct {
+	countingConnector
+	held []int64
+}
+
+func (c *holdingConnector) Row(ctx context.Context, row map[string]any) error {
+	if row["hold"] != nil {
+		if ordinal, ok := c.session.HoldRow(); ok {
+			c.held = append(c.held, ordinal)
+		}
+	}
+	return c.countingConnector.Row(ctx, row)
+}
+
+func (c *holdingConnector) EndStream(ctx context.Context) (any, error) {
+	for _, ordinal := range c.held {
+		c.session.RowDelivered(ordinal)
+	}
+	return c.countingConnector.EndStream(ctx)
+}
+
+func TestConnectorOrdinals(t *testing.T) {
+	replies, err := exchange(t, &holdingConnector{},
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}}},
+		Message{Type: "row", Payload: map[string]any{"row": map[string]any{"a": 1}, "ordinal": 1}},
+		Message{Type: "row", Payload: map[string]any{"row": map[string]any{"hold": true}, "ordinal": 2}},
+		Message{Type: "rows", Payload: map[string]any{"rows": []any{map[string]any{"a": 3}, map[string]any{"a": 4}}, "ordinals": []int64{5, 6}}},
+		Message{Type: "end-stream"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, r := range replies {
+		types = append(types, r.Type)
+	}
+	if b, _ := json.Marshal(types); string(b) != `["log","checkpoint","metric","stream-result"]` {
+		t.Fatalf("unexpected replies: %s", b)
+	}
+	if b, _ := json.Marshal(replies[1].Payload); string(b) != `{"ordinal":1,"rows":1,"stream":"s"}` {
+		t.Errorf("unexpected checkpoint: %s", b)
+	}
+	if b, _ := json.Marshal(replies[3].Payload); string(b) != `{"ordinal":6,"received":4}` {
+		t.Errorf("unexpected stream-result: %s", b)
+	}
+
+	_, err = exchange(t, &holdingConnector{},
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}}},
+		Message{Type: "rows", Payload: map[string]any{"rows": []any{map[string]any{"a": 1}, map[string]any{"a": 2}}, "ordinals": []int64{2, 1}}},
+	)
+	if err == nil {
+		t.Error("expected error for decreasing ordinals")
+	}
+}
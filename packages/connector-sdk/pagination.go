@@ -0,0 +1,177 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Cursor is a position in a paginated source. It's saved with checkpoints, so a stream can be resumed
+// from the page following the last processed one
+type Cursor struct {
+	Token  string `json:"token,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+// TokenPageFetcher fetches a page by page token. Empty next token means the last page
+type TokenPageFetcher func(ctx context.Context, token string) (rows []any, next string, err error)
+
+// OffsetPageFetcher fetches up to limit rows starting from offset. Page shorter than limit means the last page
+type OffsetPageFetcher func(ctx context.Context, offset, limit int) (rows []any, err error)
+
+// PageHandler processes rows of a page. cursor points to the next page
+type PageHandler func(rows []any, cursor Cursor) error
+
+type PaginationOptions struct {
+	// RequestsPerSecond limits the rate of fetch calls. 0 means unlimited
+	RequestsPerSecond float64
+	// RateLimiter is shared with other calls of the connector to the same API. Nil means unlimited
+	RateLimiter *RateLimiter
+	// MaxRetries is the number of retries of a failed fetch. Errors wrapped with Permanent are not retried
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry. It doubles with each attempt. Default is 1 second
+	RetryBackoff time.Duration
+	// RetryBudget is shared by retrying callers of the run. Nil means unlimited
+	RetryBudget *RetryBudget
+}
+
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p permanentError) Unwrap() error {
+	return p.err
+}
+
+// Permanent wraps error that must not be retried, e.g. authentication failure
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// PaginateByToken fetches pages starting from the token until the page without next token.
+// handle is called for each page, so the checkpoint with the cursor is made after each page
+func PaginateByToken(ctx context.Context, token string, fetch TokenPageFetcher, handle PageHandler, options PaginationOptions) error {
+	p := newPaginator(options)
+	for {
+		var rows []any
+		var next string
+		err := p.call(ctx, func() error {
+			var err error
+			rows, next, err = fetch(ctx, token)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error fetching page with token '%s': %w", token, err)
+		}
+		if err = handle(rows, Cursor{Token: next}); err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		token = next
+	}
+}
+
+// PaginateByOffset fetches pages of limit rows starting from the offset until a page shorter than limit
+func PaginateByOffset(ctx context.Context, offset, limit int, fetch OffsetPageFetcher, handle PageHandler, options PaginationOptions) error {
+	if limit <= 0 {
+		return fmt.Errorf("page size must be positive, got %d", limit)
+	}
+	p := newPaginator(options)
+	for {
+		var rows []any
+		err := p.call(ctx, func() error {
+			var err error
+			rows, err = fetch(ctx, offset, limit)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error fetching page at offset %d: %w", offset, err)
+		}
+		offset += len(rows)
+		if err = handle(rows, Cursor{Offset: offset}); err != nil {
+			return err
+		}
+		if len(rows) < limit {
+			return nil
+		}
+	}
+}
+
+// PageHandler returns a handler that emits page rows and a checkpoint with the cursor after each page
+func (s *EmitterSession) PageHandler() PageHandler {
+	return func(rows []any, cursor Cursor) error {
+		for _, row := range rows {
+			if err := s.EmitRow(row); err != nil {
+				return err
+			}
+		}
+		return s.EmitCheckpoint(cursor)
+	}
+}
+
+type paginator struct {
+	options  PaginationOptions
+	interval time.Duration
+	last     time.Time
+}
+
+func newPaginator(options PaginationOptions) *paginator {
+	if options.RetryBackoff <= 0 {
+		options.RetryBackoff = time.Second
+	}
+	p := &paginator{options: options}
+	if options.RequestsPerSecond > 0 {
+		p.interval = time.Duration(float64(time.Second) / options.RequestsPerSecond)
+	}
+	return p
+}
+
+// call runs f respecting rate limit and retrying failures with exponential backoff
+func (p *paginator) call(ctx context.Context, f func() error) error {
+	backoff := p.options.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		if err := p.wait(ctx, time.Until(p.last.Add(p.interval))); err != nil {
+			return err
+		}
+		p.last = time.Now()
+		if err := p.options.RateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := f()
+		if err == nil {
+			return nil
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) || attempt >= p.options.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		if budgetErr := p.options.RetryBudget.Reserve(backoff); budgetErr != nil {
+			return fmt.Errorf("%w, last error: %w", budgetErr, err)
+		}
+		if err := p.wait(ctx, backoff); err != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (p *paginator) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPaginateByToken(t *testing.T) {
+	pages := map[string][]any{"": {1, 2}, "p2": {3}, "p3": {4, 5}}
+	next := map[string]string{"": "p2", "p2": "p3", "p3": ""}
+	failures := 1
+	fetch := func(ctx context.Context, token string) ([]any, string, error) {
+		if token == "p2" && failures > 0 {
+			failures--
+			return nil, "", errors.New("temporary error")
+		}
+		return pages[token], next[token], nil
+	}
+	var rows []any
+	var cursors []Cursor
+	handle := func(page []any, cursor Cursor) error {
+		rows = append(rows, page...)
+		cursors = append(cursors, cursor)
+		return nil
+	}
+	err := PaginateByToken(context.Background(), "", fetch, handle, PaginationOptions{MaxRetries: 1, RetryBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || len(cursors) != 3 || cursors[0].Token != "p2" || cursors[2].Token != "" {
+		t.Errorf("rows = %v, cursors = %v", rows, cursors)
+	}
+}
+
+func TestPaginateByOffsetPermanentError(t *testing.T) {
+	calls := 0
+	fetch := func(ctx context.Context, offset, limit int) ([]any, error) {
+		calls++
+		if offset > 0 {
+			return nil, Permanent(errors.New("unauthorized"))
+		}
+		return []any{1, 2}, nil
+	}
+	var last Cursor
+	err := PaginateByOffset(context.Background(), 0, 2, fetch, func(rows []any, cursor Cursor) error {
+		last = cursor
+		return nil
+	}, PaginationOptions{MaxRetries: 3, RetryBackoff: time.Millisecond})
+	if err == nil || calls != 2 || last.Offset != 2 {
+		t.Errorf("err = %v, calls = %d, last cursor = %v", err, calls, last)
+	}
+}
+
+func TestPaginateRetryBudget(t *testing.T) {
+	budget := NewRetryBudget(3, 0)
+	fetch := func(ctx context.Context, token string) ([]any, string, error) {
+		return nil, "", errors.New("unavailable")
+	}
+	options := PaginationOptions{MaxRetries: 2, RetryBackoff: time.Millisecond, RetryBudget: budget}
+	if err := PaginateByToken(context.Background(), "", fetch, func([]any, Cursor) error { return nil }, options); errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("2 retries fit the budget: %v", err)
+	}
+	// the budget is shared, so the second pagination may retry only once
+	err := PaginateByToken(context.Background(), "", fetch, func([]any, Cursor) error { return nil }, options)
+	if !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("expected exhausted budget, got %v", err)
+	}
+	if attempts, _ := budget.Used(); attempts != 3 {
+		t.Errorf("%d retries accounted, want 3", attempts)
+	}
+}
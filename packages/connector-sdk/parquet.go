@@ -0,0 +1,874 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParquetReader reads rows of Parquet file handed off by the host. The file is read with random access,
+// so it can be re-read, e.g. by a retried run, without the host sending rows again.
+//
+// Only flat schemas are supported: columns must not be nested or repeated. Supported are PLAIN and dictionary
+// encodings, data pages v1 and v2 and UNCOMPRESSED, SNAPPY and GZIP codecs. Values are represented as described
+// in RowReader
+type ParquetReader struct {
+	r         io.ReaderAt
+	size      int64
+	columns   []parquetColumn
+	rowGroups []thriftStruct
+
+	group  int
+	values [][]any
+	rows   int
+	row    int
+}
+
+type parquetColumn struct {
+	Column
+	physical   int64
+	typeLength int
+	// kind is how physical values are converted: string, binary, integer, unsigned, float, decimal, date, time,
+	// timestamp, int96, uuid or boolean
+	kind string
+	unit int
+	utc  bool
+}
+
+// Parquet physical types
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+var parquetMagic = []byte("PAR1")
+
+// maxParquetRowGroupRows bounds the rows of a row group. Values of a row group are kept in memory, so counts
+// of a corrupt file must not make the reader allocate or loop unbounded
+const maxParquetRowGroupRows = 1 << 26
+
+// NewParquetReader reads metadata of Parquet file of the size
+func NewParquetReader(r io.ReaderAt, size int64) (*ParquetReader, error) {
+	if size < 12 {
+		return nil, fmt.Errorf("parquet file is too short")
+	}
+	tail := make([]byte, 8)
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(tail[4:], parquetMagic) {
+		return nil, fmt.Errorf("not a parquet file")
+	}
+	metadataLength := int64(binary.LittleEndian.Uint32(tail))
+	if metadataLength > size-12 {
+		return nil, fmt.Errorf("invalid parquet footer length %d", metadataLength)
+	}
+	metadata := make([]byte, metadataLength)
+	if _, err := r.ReadAt(metadata, size-8-metadataLength); err != nil {
+		return nil, err
+	}
+	fileMetadata, err := (&thriftReader{b: metadata}).readStruct()
+	if err != nil {
+		return nil, fmt.Errorf("error reading parquet metadata: %w", err)
+	}
+	p := &ParquetReader{r: r, size: size}
+	if p.columns, err = parseParquetSchema(fileMetadata.list(2)); err != nil {
+		return nil, err
+	}
+	for _, rg := range fileMetadata.list(4) {
+		if s, ok := rg.(thriftStruct); ok {
+			p.rowGroups = append(p.rowGroups, s)
+		}
+	}
+	return p, nil
+}
+
+// OpenParquet opens local Parquet file or downloads it from http(s) URL, e.g. a signed URL of object storage,
+// to a temp file. close closes the file and removes the downloaded copy
+func OpenParquet(location string) (reader *ParquetReader, close func() error, err error) {
+	path := location
+	var temp bool
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		if path, err = download(location); err != nil {
+			return nil, nil, err
+		}
+		temp = true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	close = func() error {
+		err := f.Close()
+		if temp {
+			_ = os.Remove(path)
+		}
+		return err
+	}
+	stat, err := f.Stat()
+	if err == nil {
+		reader, err = NewParquetReader(f, stat.Size())
+	}
+	if err != nil {
+		_ = close()
+		return nil, nil, err
+	}
+	return reader, close, nil
+}
+
+// download saves the content of url to a temp file
+func download(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// don't log the url: signed urls are credentials
+		return "", fmt.Errorf("error downloading file: HTTP %d", resp.StatusCode)
+	}
+	f, err := os.CreateTemp("", "syncmaven-*.parquet")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("error downloading file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// Columns returns the columns of the schema
+func (p *ParquetReader) Columns() []Column {
+	columns := make([]Column, len(p.columns))
+	for i, c := range p.columns {
+		columns[i] = c.Column
+	}
+	return columns
+}
+
+// Next returns the next row. Returns io.EOF after the last row
+func (p *ParquetReader) Next() (map[string]any, error) {
+	for p.row >= p.rows {
+		if p.group >= len(p.rowGroups) {
+			return nil, io.EOF
+		}
+		if err := p.readRowGroup(p.rowGroups[p.group]); err != nil {
+			return nil, fmt.Errorf("row group %d: %w", p.group, err)
+		}
+		p.group++
+	}
+	row := make(map[string]any, len(p.columns))
+	for i, column := range p.columns {
+		row[column.Name] = p.values[i][p.row]
+	}
+	p.row++
+	return row, nil
+}
+
+func parseParquetSchema(schema []any) ([]parquetColumn, error) {
+	if len(schema) == 0 {
+		return nil, fmt.Errorf("parquet file has no schema")
+	}
+	root, _ := schema[0].(thriftStruct)
+	if int(root.int(5, 0)) != len(schema)-1 {
+		return nil, fmt.Errorf("nested columns are not supported")
+	}
+	columns := make([]parquetColumn, len(schema)-1)
+	for i := range columns {
+		element, _ := schema[i+1].(thriftStruct)
+		c := &columns[i]
+		c.Name = element.string(4)
+		if element.int(5, 0) > 0 {
+			return nil, fmt.Errorf("column '%s': nested columns are not supported", c.Name)
+		}
+		switch element.int(3, 0) {
+		case 1:
+			c.Nullable = true
+		case 2:
+			return nil, fmt.Errorf("column '%s': repeated columns are not supported", c.Name)
+		}
+		c.physical = element.int(1, -1)
+		c.typeLength = int(element.int(2, 0))
+		if err := c.resolveType(element); err != nil {
+			return nil, fmt.Errorf("column '%s': %w", c.Name, err)
+		}
+	}
+	return columns, nil
+}
+
+// resolveType chooses conversion of the column by its logical type, converted (legacy) type or physical type
+func (c *parquetColumn) resolveType(element thriftStruct) error {
+	logical := element.strct(10)
+	converted := element.int(6, -1)
+	switch {
+	case logical.has(1) || logical.has(4) || logical.has(12) || converted == 0 || converted == 4 || converted == 19:
+		c.kind, c.Type = "string", "string"
+	case logical.has(5):
+		c.kind, c.Type, c.Scale = "decimal", "decimal", int(logical.strct(5).int(1, 0))
+	case converted == 5:
+		c.kind, c.Type, c.Scale = "decimal", "decimal", int(element.int(7, 0))
+	case logical.has(6) || converted == 6:
+		c.kind, c.Type = "date", "date"
+	case logical.has(7):
+		c.kind, c.Type, c.unit = "time", "time", parquetTimeUnit(logical.strct(7).strct(2))
+	case converted == 7 || converted == 8:
+		c.kind, c.Type, c.unit = "time", "time", int(converted-6)
+	case logical.has(8):
+		timestamp := logical.strct(8)
+		c.kind, c.Type, c.unit, c.utc = "timestamp", "timestamp", parquetTimeUnit(timestamp.strct(2)), timestamp.bool(1, false)
+	case converted == 9 || converted == 10:
+		c.kind, c.Type, c.unit, c.utc = "timestamp", "timestamp", int(converted-8), true
+	case logical.has(10) && !logical.strct(10).bool(2, false), converted >= 11 && converted <= 14:
+		c.kind, c.Type = "unsigned", "integer"
+	case logical.has(14) && c.physical == parquetFixedLenByteArray && c.typeLength == 16:
+		c.kind, c.Type = "uuid", "string"
+	default:
+		switch c.physical {
+		case parquetBoolean:
+			c.kind, c.Type = "boolean", "boolean"
+		case parquetInt32, parquetInt64:
+			c.kind, c.Type = "integer", "integer"
+		case parquetInt96:
+			c.kind, c.Type, c.utc = "int96", "timestamp", true
+		case parquetFloat, parquetDouble:
+			c.kind, c.Type = "float", "float"
+		case parquetByteArray, parquetFixedLenByteArray:
+			c.kind, c.Type = "binary", "binary"
+		default:
+			return fmt.Errorf("unsupported physical type %d", c.physical)
+		}
+	}
+	// conversions read values of the width of the physical type
+	physical := map[string][]int64{
+		"integer":   {parquetInt32, parquetInt64},
+		"unsigned":  {parquetInt32, parquetInt64},
+		"date":      {parquetInt32},
+		"time":      {parquetInt32, parquetInt64},
+		"timestamp": {parquetInt64},
+		"float":     {parquetFloat, parquetDouble},
+	}[c.kind]
+	switch {
+	case c.physical < parquetBoolean || c.physical > parquetFixedLenByteArray,
+		physical != nil && !slices.Contains(physical, c.physical):
+		return fmt.Errorf("physical type %d can't be %s", c.physical, c.Type)
+	case c.physical == parquetFixedLenByteArray && c.typeLength <= 0:
+		return fmt.Errorf("invalid type length %d", c.typeLength)
+	}
+	return nil
+}
+
+// parquetTimeUnit converts TimeUnit union (MILLIS, MICROS, NANOS) to unit of unitTime
+func parquetTimeUnit(unit thriftStruct) int {
+	switch {
+	case unit.has(2):
+		return 2
+	case unit.has(3):
+		return 3
+	default:
+		return 1
+	}
+}
+
+func (p *ParquetReader) readRowGroup(rowGroup thriftStruct) error {
+	chunks := rowGroup.list(1)
+	if len(chunks) != len(p.columns) {
+		return fmt.Errorf("row group has %d column chunks, schema has %d columns", len(chunks), len(p.columns))
+	}
+	rows := rowGroup.int(3, 0)
+	if rows < 0 || rows > maxParquetRowGroupRows {
+		return fmt.Errorf("invalid number of rows %d", rows)
+	}
+	p.rows, p.row = int(rows), 0
+	p.values = make([][]any, len(p.columns))
+	for i := range p.columns {
+		chunk, _ := chunks[i].(thriftStruct)
+		values, err := p.readColumnChunk(&p.columns[i], chunk.strct(3), p.rows)
+		if err != nil {
+			return fmt.Errorf("column '%s': %w", p.columns[i].Name, err)
+		}
+		p.values[i] = values
+	}
+	return nil
+}
+
+// readColumnChunk reads the dictionary page and data pages of the column chunk
+func (p *ParquetReader) readColumnChunk(c *parquetColumn, meta thriftStruct, rows int) ([]any, error) {
+	codec := meta.int(4, 0)
+	start := meta.int(9, 0)
+	if dictionaryOffset := meta.int(11, 0); dictionaryOffset > 0 && dictionaryOffset < start {
+		start = dictionaryOffset
+	}
+	length := meta.int(7, 0)
+	if start < 0 || length < 0 || start > p.size || length > p.size-start || length > math.MaxInt32 {
+		return nil, fmt.Errorf("invalid column chunk offsets")
+	}
+	data := make([]byte, length)
+	if _, err := p.r.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	values := make([]any, 0, min(rows, len(data)))
+	var dictionary []any
+	reader := &thriftReader{b: data}
+	for len(values) < rows && reader.pos < len(data) {
+		header, err := reader.readStruct()
+		if err != nil {
+			return nil, fmt.Errorf("error reading page header: %w", err)
+		}
+		compressedSize := int(header.int(3, 0))
+		if compressedSize < 0 || reader.pos+compressedSize > len(data) {
+			return nil, fmt.Errorf("page is out of the column chunk")
+		}
+		page := data[reader.pos : reader.pos+compressedSize]
+		reader.pos += compressedSize
+		uncompressedSize := int(header.int(2, 0))
+		switch header.int(1, -1) {
+		case 2:
+			// dictionary page
+			if page, err = decompress(codec, page, uncompressedSize); err != nil {
+				return nil, err
+			}
+			dictionaryHeader := header.strct(7)
+			if dictionary, err = c.plainValues(page, int(dictionaryHeader.int(1, 0))); err != nil {
+				return nil, fmt.Errorf("error reading dictionary: %w", err)
+			}
+		case 0:
+			dataHeader := header.strct(5)
+			if page, err = decompress(codec, page, uncompressedSize); err != nil {
+				return nil, err
+			}
+			numValues := int(dataHeader.int(1, 0))
+			if numValues < 0 || numValues > rows-len(values) {
+				return nil, fmt.Errorf("invalid number of page values %d", numValues)
+			}
+			var defined []bool
+			if c.Nullable {
+				if dataHeader.int(3, 3) != 3 {
+					return nil, fmt.Errorf("unsupported definition level encoding %d", dataHeader.int(3, 0))
+				}
+				if len(page) < 4 {
+					return nil, fmt.Errorf("page is too short")
+				}
+				levelsLength := int(binary.LittleEndian.Uint32(page))
+				if levelsLength > len(page)-4 {
+					return nil, fmt.Errorf("definition levels are out of the page")
+				}
+				if defined, err = definitionLevels(page[4:4+levelsLength], numValues); err != nil {
+					return nil, err
+				}
+				page = page[4+levelsLength:]
+			}
+			if values, err = c.appendPageValues(values, page, int(dataHeader.int(2, 0)), numValues, defined, dictionary); err != nil {
+				return nil, err
+			}
+		case 3:
+			dataHeader := header.strct(8)
+			numValues := int(dataHeader.int(1, 0))
+			if numValues < 0 || numValues > rows-len(values) {
+				return nil, fmt.Errorf("invalid number of page values %d", numValues)
+			}
+			repetitionLength, definitionLength := int(dataHeader.int(6, 0)), int(dataHeader.int(5, 0))
+			if repetitionLength < 0 || definitionLength < 0 || repetitionLength > len(page) || definitionLength > len(page)-repetitionLength {
+				return nil, fmt.Errorf("levels are out of the page")
+			}
+			var defined []bool
+			if c.Nullable {
+				if defined, err = definitionLevels(page[repetitionLength:repetitionLength+definitionLength], numValues); err != nil {
+					return nil, err
+				}
+			}
+			page = page[repetitionLength+definitionLength:]
+			if dataHeader.bool(7, true) {
+				if page, err = decompress(codec, page, uncompressedSize-repetitionLength-definitionLength); err != nil {
+					return nil, err
+				}
+			}
+			if values, err = c.appendPageValues(values, page, int(dataHeader.int(4, 0)), numValues, defined, dictionary); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(values) != rows {
+		return nil, fmt.Errorf("column chunk has %d values, row group has %d rows", len(values), rows)
+	}
+	return values, nil
+}
+
+// decompress decompresses the page of size bytes declared by the page header. Pages that decompress to
+// another size are corrupt, so size also bounds the memory a page takes
+func decompress(codec int64, data []byte, size int) ([]byte, error) {
+	var page []byte
+	var err error
+	switch codec {
+	case 0:
+		page = data
+	case 1:
+		page, err = snappyDecode(data)
+	case 2:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			page, err = io.ReadAll(io.LimitReader(r, int64(size)+1))
+		}
+	default:
+		names := map[int64]string{3: "LZO", 4: "BROTLI", 5: "LZ4", 6: "ZSTD", 7: "LZ4_RAW"}
+		return nil, fmt.Errorf("unsupported compression codec %s. Supported are UNCOMPRESSED, SNAPPY and GZIP", names[codec])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing page: %w", err)
+	}
+	if codec != 0 && len(page) != size {
+		return nil, fmt.Errorf("page is decompressed to %d bytes, expected %d", len(page), size)
+	}
+	return page, nil
+}
+
+// definitionLevels decodes definition levels of a flat optional column: 1 means the value is defined, 0 is null
+func definitionLevels(data []byte, n int) ([]bool, error) {
+	levels, err := decodeHybrid(data, 1, n)
+	if err != nil {
+		return nil, fmt.Errorf("error reading definition levels: %w", err)
+	}
+	defined := make([]bool, n)
+	for i, level := range levels {
+		defined[i] = level == 1
+	}
+	return defined, nil
+}
+
+// appendPageValues decodes values of the data page and appends them to values, nil for undefined ones
+func (c *parquetColumn) appendPageValues(values []any, page []byte, encoding int, numValues int, defined []bool, dictionary []any) ([]any, error) {
+	nonNull := numValues
+	if defined != nil {
+		nonNull = 0
+		for _, d := range defined {
+			if d {
+				nonNull++
+			}
+		}
+	}
+	var decoded []any
+	var err error
+	switch encoding {
+	case 0:
+		decoded, err = c.plainValues(page, nonNull)
+	case 2, 8:
+		// PLAIN_DICTIONARY, RLE_DICTIONARY: bit width followed by indices
+		if len(page) == 0 {
+			if nonNull > 0 {
+				return nil, fmt.Errorf("dictionary indices are missing")
+			}
+			break
+		}
+		var indices []int
+		if indices, err = decodeHybrid(page[1:], int(page[0]), nonNull); err != nil {
+			return nil, fmt.Errorf("error reading dictionary indices: %w", err)
+		}
+		decoded = make([]any, nonNull)
+		for i, index := range indices {
+			if index >= len(dictionary) {
+				return nil, fmt.Errorf("dictionary index %d is out of the dictionary of %d values", index, len(dictionary))
+			}
+			decoded[i] = dictionary[index]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %d. Supported are PLAIN and dictionary encodings", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	next := 0
+	for i := 0; i < numValues; i++ {
+		if defined != nil && !defined[i] {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[next])
+		next++
+	}
+	return values, nil
+}
+
+// plainValues decodes n PLAIN encoded values
+func (c *parquetColumn) plainValues(data []byte, n int) ([]any, error) {
+	errShort := errors.New("page is too short")
+	// every value takes at least a bit (booleans) or a byte, so n is checked before allocating
+	if n < 0 || (c.physical == parquetBoolean && (n+7)/8 > len(data)) || (c.physical != parquetBoolean && n > len(data)) {
+		return nil, errShort
+	}
+	values := make([]any, n)
+	if c.physical == parquetBoolean {
+		for i := range values {
+			values[i] = data[i>>3]&(1<<(i&7)) != 0
+		}
+		return values, nil
+	}
+	width := map[int64]int{parquetInt32: 4, parquetInt64: 8, parquetInt96: 12, parquetFloat: 4, parquetDouble: 8, parquetFixedLenByteArray: c.typeLength}
+	pos := 0
+	for i := range values {
+		var raw []byte
+		if c.physical == parquetByteArray {
+			if pos+4 > len(data) {
+				return nil, errShort
+			}
+			length := int(binary.LittleEndian.Uint32(data[pos:]))
+			pos += 4
+			if length > len(data)-pos {
+				return nil, errShort
+			}
+			raw = data[pos : pos+length]
+			pos += length
+		} else {
+			w := width[c.physical]
+			if w > len(data)-pos {
+				return nil, errShort
+			}
+			raw = data[pos : pos+w]
+			pos += w
+		}
+		value, err := c.convert(raw)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// convert converts little endian physical value to the representation of RowReader
+func (c *parquetColumn) convert(raw []byte) (any, error) {
+	switch c.kind {
+	case "string":
+		return string(raw), nil
+	case "binary":
+		return base64.StdEncoding.EncodeToString(raw), nil
+	case "float":
+		var f float64
+		bitSize := 64
+		if c.physical == parquetFloat {
+			f, bitSize = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw))), 32
+		} else {
+			f = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, nil
+		}
+		return json.Number(strconv.FormatFloat(f, 'f', -1, bitSize)), nil
+	case "integer", "unsigned":
+		if len(raw) == 4 {
+			v := binary.LittleEndian.Uint32(raw)
+			if c.kind == "unsigned" {
+				return json.Number(strconv.FormatUint(uint64(v), 10)), nil
+			}
+			return json.Number(strconv.FormatInt(int64(int32(v)), 10)), nil
+		}
+		v := binary.LittleEndian.Uint64(raw)
+		if c.kind == "unsigned" {
+			return json.Number(strconv.FormatUint(v, 10)), nil
+		}
+		return json.Number(strconv.FormatInt(int64(v), 10)), nil
+	case "decimal":
+		var unscaled *big.Int
+		switch c.physical {
+		case parquetInt32:
+			unscaled = big.NewInt(int64(int32(binary.LittleEndian.Uint32(raw))))
+		case parquetInt64:
+			unscaled = big.NewInt(int64(binary.LittleEndian.Uint64(raw)))
+		default:
+			// byte arrays are big endian
+			unscaled = signedBigEndian(raw)
+		}
+		return json.Number(formatDecimal(unscaled, c.Scale)), nil
+	case "date":
+		days := int64(int32(binary.LittleEndian.Uint32(raw)))
+		return time.Unix(days*86400, 0).UTC().Format(time.DateOnly), nil
+	case "time":
+		var v int64
+		if len(raw) == 4 {
+			v = int64(int32(binary.LittleEndian.Uint32(raw)))
+		} else {
+			v = int64(binary.LittleEndian.Uint64(raw))
+		}
+		return unitTime(v, c.unit).UTC().Format(timeLayout), nil
+	case "timestamp":
+		return formatTimestamp(unitTime(int64(binary.LittleEndian.Uint64(raw)), c.unit), c.utc), nil
+	case "int96":
+		// nanoseconds of the day followed by julian day
+		nanos := int64(binary.LittleEndian.Uint64(raw))
+		days := int64(binary.LittleEndian.Uint32(raw[8:])) - 2440588
+		return formatTimestamp(time.Unix(days*86400, nanos), true), nil
+	case "uuid":
+		h := fmt.Sprintf("%x", raw)
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+	case "boolean":
+		return raw[0] != 0, nil
+	}
+	return nil, fmt.Errorf("unsupported column type %s", c.kind)
+}
+
+// decodeHybrid decodes n values of RLE / bit-packing hybrid encoding
+func decodeHybrid(data []byte, bitWidth int, n int) ([]int, error) {
+	if bitWidth < 0 || bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid number of values %d", n)
+	}
+	// runs may repeat a value many times, so the capacity is bounded by the data, not by the claimed n
+	values := make([]int, 0, min(n, 8*len(data)))
+	pos := 0
+	for len(values) < n {
+		header, read := binary.Uvarint(data[pos:])
+		if read <= 0 {
+			return nil, fmt.Errorf("unexpected end of data")
+		}
+		pos += read
+		if header&1 == 0 {
+			// RLE run: repeated value of ceil(bitWidth/8) bytes
+			count := int(header >> 1)
+			width := (bitWidth + 7) / 8
+			if pos+width > len(data) {
+				return nil, fmt.Errorf("unexpected end of data")
+			}
+			value := 0
+			for i := width - 1; i >= 0; i-- {
+				value = value<<8 | int(data[pos+i])
+			}
+			pos += width
+			for i := 0; i < count && len(values) < n; i++ {
+				values = append(values, value)
+			}
+		} else {
+			// bit-packed run of groups of 8 values, least significant bit first
+			if header>>1 > uint64(len(data)) {
+				return nil, fmt.Errorf("unexpected end of data")
+			}
+			count := int(header>>1) * 8
+			if count*bitWidth/8 > len(data)-pos {
+				return nil, fmt.Errorf("unexpected end of data")
+			}
+			for i := 0; i < count && len(values) < n; i++ {
+				value := 0
+				for bit := 0; bit < bitWidth; bit++ {
+					position := i*bitWidth + bit
+					if data[pos+position/8]&(1<<(position%8)) != 0 {
+						value |= 1 << bit
+					}
+				}
+				values = append(values, value)
+			}
+			pos += count * bitWidth / 8
+		}
+	}
+	return values, nil
+}
+
+// thriftStruct is a struct decoded with thrift compact protocol: field id to value. Values are bool, int64
+// (for all integer types), float64, []byte, []any or thriftStruct. Parquet metadata is read with it without
+// generated code
+type thriftStruct map[int16]any
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) int(id int16, def int64) int64 {
+	if v, ok := s[id].(int64); ok {
+		return v
+	}
+	return def
+}
+
+func (s thriftStruct) bool(id int16, def bool) bool {
+	if v, ok := s[id].(bool); ok {
+		return v
+	}
+	return def
+}
+
+func (s thriftStruct) string(id int16) string {
+	v, _ := s[id].([]byte)
+	return string(v)
+}
+
+func (s thriftStruct) strct(id int16) thriftStruct {
+	v, _ := s[id].(thriftStruct)
+	return v
+}
+
+func (s thriftStruct) list(id int16) []any {
+	v, _ := s[id].([]any)
+	return v
+}
+
+// thriftReader decodes thrift compact protocol
+type thriftReader struct {
+	b     []byte
+	pos   int
+	depth int
+}
+
+// maxThriftDepth bounds nesting of structs and lists. Parquet metadata is a few levels deep
+const maxThriftDepth = 64
+
+var errThriftCorrupt = errors.New("corrupt thrift data")
+
+func (t *thriftReader) byte() (byte, error) {
+	if t.pos >= len(t.b) {
+		return 0, errThriftCorrupt
+	}
+	t.pos++
+	return t.b[t.pos-1], nil
+}
+
+func (t *thriftReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(t.b[t.pos:])
+	if n <= 0 {
+		return 0, errThriftCorrupt
+	}
+	t.pos += n
+	return v, nil
+}
+
+func (t *thriftReader) zigzag() (int64, error) {
+	v, err := t.uvarint()
+	return int64(v>>1) ^ -int64(v&1), err
+}
+
+func (t *thriftReader) readStruct() (thriftStruct, error) {
+	if t.depth++; t.depth > maxThriftDepth {
+		return nil, errThriftCorrupt
+	}
+	defer func() { t.depth-- }()
+	s := make(thriftStruct)
+	var id int16
+	for {
+		header, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		if header == 0 {
+			return s, nil
+		}
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := t.zigzag()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+		typ := header & 0x0f
+		switch typ {
+		case 1, 2:
+			// booleans are encoded in the field type
+			s[id] = typ == 1
+		default:
+			if s[id], err = t.readValue(typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (t *thriftReader) readValue(typ byte) (any, error) {
+	switch typ {
+	case 1, 2:
+		b, err := t.byte()
+		return b == 1, err
+	case 3:
+		b, err := t.byte()
+		return int64(int8(b)), err
+	case 4, 5, 6:
+		return t.zigzag()
+	case 7:
+		if t.pos+8 > len(t.b) {
+			return nil, errThriftCorrupt
+		}
+		t.pos += 8
+		return math.Float64frombits(binary.LittleEndian.Uint64(t.b[t.pos-8:])), nil
+	case 8:
+		n, err := t.uvarint()
+		if err != nil || n > uint64(len(t.b)-t.pos) {
+			return nil, errThriftCorrupt
+		}
+		t.pos += int(n)
+		return t.b[t.pos-int(n) : t.pos], nil
+	case 9, 10:
+		header, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = t.uvarint(); err != nil {
+				return nil, err
+			}
+		}
+		// every element takes at least a byte
+		if size > uint64(len(t.b)-t.pos) {
+			return nil, errThriftCorrupt
+		}
+		if t.depth++; t.depth > maxThriftDepth {
+			return nil, errThriftCorrupt
+		}
+		defer func() { t.depth-- }()
+		list := make([]any, size)
+		for i := range list {
+			if list[i], err = t.readValue(header & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case 11:
+		// maps are not used by parquet metadata that is read, skipped
+		size, err := t.uvarint()
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		if size > uint64(len(t.b)-t.pos) {
+			return nil, errThriftCorrupt
+		}
+		types, err := t.byte()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < size; i++ {
+			if _, err = t.readValue(types >> 4); err != nil {
+				return nil, err
+			}
+			if _, err = t.readValue(types & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case 12:
+		return t.readStruct()
+	default:
+		return nil, fmt.Errorf("unsupported thrift type %d", typ)
+	}
+}
@@ -0,0 +1,323 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tStruct is a thrift struct for building test data: fields in the order of ids. Values are bool, int32, int64,
+// string, tStruct or []tStruct
+type tStruct []tField
+
+type tField struct {
+	id int16
+	v  any
+}
+
+func thriftEncode(s tStruct) []byte {
+	var b []byte
+	var last int16
+	for _, f := range s {
+		var typ byte
+		switch f.v.(type) {
+		case bool:
+			typ = 2
+			if f.v.(bool) {
+				typ = 1
+			}
+		case int32:
+			typ = 5
+		case int64:
+			typ = 6
+		case string:
+			typ = 8
+		case []tStruct:
+			typ = 9
+		case tStruct:
+			typ = 12
+		}
+		b = append(b, byte(f.id-last)<<4|typ)
+		last = f.id
+		switch v := f.v.(type) {
+		case int32:
+			b = binary.AppendVarint(b, int64(v))
+		case int64:
+			b = binary.AppendVarint(b, v)
+		case string:
+			b = append(binary.AppendUvarint(b, uint64(len(v))), v...)
+		case []tStruct:
+			b = append(b, byte(len(v))<<4|12)
+			for _, e := range v {
+				b = append(b, thriftEncode(e)...)
+			}
+		case tStruct:
+			b = append(b, thriftEncode(v)...)
+		}
+	}
+	return append(b, 0)
+}
+
+// parquetChunk is a column chunk of a test file: pages with headers, the codec and offset of the dictionary page
+type parquetChunk struct {
+	codec      int32
+	pages      [][]byte
+	dictionary bool
+}
+
+func parquetPage(header tStruct, data []byte) []byte {
+	return append(thriftEncode(append(tStruct{{1, header[0].v}, {2, int32(len(data))}, {3, int32(len(data))}}, header[1:]...)), data...)
+}
+
+func testParquetFile(schema []tStruct, rows int64, chunks []parquetChunk) []byte {
+	file := []byte("PAR1")
+	var columns []tStruct
+	for _, chunk := range chunks {
+		start := int64(len(file))
+		for _, page := range chunk.pages {
+			file = append(file, page...)
+		}
+		meta := tStruct{{4, chunk.codec}, {7, int64(len(file)) - start}, {9, start}}
+		if chunk.dictionary {
+			meta = append(meta, tField{11, start})
+		}
+		columns = append(columns, tStruct{{2, start}, {3, meta}})
+	}
+	metadata := thriftEncode(tStruct{
+		{1, int32(2)},
+		{2, schema},
+		{3, rows},
+		{4, []tStruct{{{1, columns}, {2, int64(len(file))}, {3, rows}}}},
+	})
+	file = append(file, metadata...)
+	return append(binary.LittleEndian.AppendUint32(file, uint32(len(metadata))), "PAR1"...)
+}
+
+func testParquetData() []byte {
+	element := func(name string, physical int32, optional bool, extra ...tField) tStruct {
+		repetition := int32(0)
+		if optional {
+			repetition = 1
+		}
+		return append(tStruct{{1, physical}, {3, repetition}, {4, name}}, extra...)
+	}
+	schema := []tStruct{
+		{{4, "schema"}, {5, int32(5)}},
+		element("id", parquetInt64, false),
+		element("name", parquetByteArray, true, tField{6, int32(0)}),
+		element("cost", parquetInt64, true, tField{6, int32(5)}, tField{7, int32(2)}, tField{8, int32(10)}),
+		element("day", parquetInt32, false, tField{10, tStruct{{6, tStruct{}}}}),
+		element("ts", parquetInt64, false, tField{10, tStruct{{8, tStruct{{1, true}, {2, tStruct{{2, tStruct{}}}}}}}}),
+	}
+	concat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	byteArray := func(s string) []byte { return append(le32(int32(len(s))), s...) }
+	// definition levels: length prefixed RLE / bit-packed hybrid
+	levels := func(hybrid ...byte) []byte { return append(le32(int32(len(hybrid))), hybrid...) }
+	dataPage := func(n int32, encoding int32, data []byte) []byte {
+		return parquetPage(tStruct{{1, int32(0)}, {5, tStruct{{1, n}, {2, encoding}, {3, int32(3)}, {4, int32(3)}}}}, data)
+	}
+	// snappy literal of the whole data
+	snappy := func(data []byte) []byte {
+		return concat(binary.AppendUvarint(nil, uint64(len(data))), []byte{byte(len(data)-1) << 2}, data)
+	}
+	snappyPage := func(n int32, data []byte) []byte {
+		compressed := snappy(data)
+		header := thriftEncode(tStruct{{1, int32(0)}, {2, int32(len(data))}, {3, int32(len(compressed))},
+			{5, tStruct{{1, n}, {2, int32(0)}, {3, int32(3)}, {4, int32(3)}}}})
+		return append(header, compressed...)
+	}
+	return testParquetFile(schema, 3, []parquetChunk{
+		// two pages
+		{pages: [][]byte{dataPage(2, 0, concat(le64(1), le64(-2))), dataPage(1, 0, le64(3))}},
+		// dictionary encoded, second value is null: bit-packed levels 1, 0, 1 and indices 1, 0
+		{dictionary: true, pages: [][]byte{
+			parquetPage(tStruct{{1, int32(2)}, {7, tStruct{{1, int32(2)}, {2, int32(0)}}}}, concat(byteArray("bar"), byteArray("foo"))),
+			dataPage(3, 8, concat(levels(0b11, 0b101), []byte{1, 0b11, 0b01})),
+		}},
+		// data page v2 with uncompressed levels: RLE run of 2 defined values, RLE run of 1 null
+		{pages: [][]byte{parquetPage(tStruct{{1, int32(3)}, {8, tStruct{{1, int32(3)}, {2, int32(1)}, {3, int32(3)}, {4, int32(0)}, {5, int32(4)}, {6, int32(0)}, {7, false}}}},
+			concat([]byte{4, 1, 2, 0}, le64(12345), le64(-5)))}},
+		{codec: 1, pages: [][]byte{snappyPage(3, concat(le32(19874), le32(0), le32(1)))}},
+		{pages: [][]byte{dataPage(3, 0, concat(le64(1717243200123456), le64(0), le64(1)))}},
+	})
+}
+
+func TestParquetReader(t *testing.T) {
+	data := testParquetData()
+	reader, err := NewParquetReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns := reader.Columns(); len(columns) != 5 || columns[1].Type != "string" || !columns[1].Nullable ||
+		columns[2].Type != "decimal" || columns[2].Scale != 2 || columns[3].Type != "date" || columns[4].Type != "timestamp" {
+		t.Errorf("unexpected columns %+v", columns)
+	}
+	expected := []map[string]any{
+		{"id": json.Number("1"), "name": "foo", "cost": json.Number("123.45"), "day": "2024-05-31", "ts": "2024-06-01T12:00:00.123456Z"},
+		{"id": json.Number("-2"), "name": nil, "cost": json.Number("-0.05"), "day": "1970-01-01", "ts": "1970-01-01T00:00:00Z"},
+		{"id": json.Number("3"), "name": "bar", "cost": nil, "day": "1970-01-02", "ts": "1970-01-01T00:00:00.000001Z"},
+	}
+	var rows []map[string]any
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("rows = %v\nwant %v", rows, expected)
+	}
+}
+
+func TestParquetReaderUnsupported(t *testing.T) {
+	repeated := testParquetFile([]tStruct{
+		{{4, "schema"}, {5, int32(1)}},
+		{{1, int32(parquetByteArray)}, {3, int32(2)}, {4, "tags"}},
+	}, 0, nil)
+	if _, err := NewParquetReader(bytes.NewReader(repeated), int64(len(repeated))); err == nil {
+		t.Error("repeated columns must be rejected")
+	}
+	zstd := testParquetFile([]tStruct{
+		{{4, "schema"}, {5, int32(1)}},
+		{{1, int32(parquetInt64)}, {3, int32(0)}, {4, "id"}},
+	}, 1, []parquetChunk{{codec: 6, pages: [][]byte{parquetPage(tStruct{{1, int32(0)}, {5, tStruct{{1, int32(1)}, {2, int32(0)}}}}, le64(1))}}})
+	reader, err := NewParquetReader(bytes.NewReader(zstd), int64(len(zstd)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err == nil || !strings.Contains(err.Error(), "ZSTD") {
+		t.Errorf("unsupported codec must be reported, got %v", err)
+	}
+	if _, err := NewParquetReader(strings.NewReader("not parquet"), 11); err == nil {
+		t.Error("invalid data must be rejected")
+	}
+}
+
+func TestSnappyDecode(t *testing.T) {
+	tests := []struct {
+		encoded  string
+		expected string
+	}{
+		{"201468656c6c6f20420600202c20736e6170707921", "hello hello hello hello, snappy!"},
+		{"a4031c6162636465666768fe0800fe0800fe0800fe0800de08000078fe01008a0100", strings.Repeat("abcdefgh", 40) + strings.Repeat("x", 100)},
+	}
+	for _, test := range tests {
+		encoded, _ := hex.DecodeString(test.encoded)
+		decoded, err := snappyDecode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != test.expected {
+			t.Errorf("snappyDecode(%s) = %q, want %q", test.encoded, decoded, test.expected)
+		}
+	}
+	if _, err := snappyDecode([]byte{10, 0x0d, 1}); err == nil {
+		t.Error("copy before the start must be rejected")
+	}
+}
+
+func TestParquetReaderFixture(t *testing.T) {
+	// rows as printed by parquet_reader of Apache Arrow Go
+	const expected = `[
+		{"carat":0.23,"cut":"Ideal","color":"E","clarity":"SI2","depth":61.5,"table":55,"price":326,"x":3.95,"y":3.98,"z":2.43,"__index_level_0__":0},
+		{"carat":0.21,"cut":"Premium","color":"E","clarity":"SI1","depth":59.8,"table":61,"price":326,"x":3.89,"y":3.84,"z":2.31,"__index_level_0__":1},
+		{"carat":0.23,"cut":"Good","color":"E","clarity":"VS1","depth":56.9,"table":65,"price":327,"x":4.05,"y":4.07,"z":2.31,"__index_level_0__":2},
+		{"carat":0.29,"cut":"Premium","color":"I","clarity":"VS2","depth":62.4,"table":58,"price":334,"x":4.2,"y":4.23,"z":2.63,"__index_level_0__":3},
+		{"carat":0.31,"cut":"Good","color":"J","clarity":"SI2","depth":63.3,"table":58,"price":335,"x":4.34,"y":4.35,"z":2.75,"__index_level_0__":4},
+		{"carat":0.24,"cut":"Very Good","color":"J","clarity":"VVS2","depth":62.8,"table":57,"price":336,"x":3.94,"y":3.96,"z":2.48,"__index_level_0__":5},
+		{"carat":0.24,"cut":"Very Good","color":"I","clarity":"VVS1","depth":62.3,"table":57,"price":336,"x":3.95,"y":3.98,"z":2.47,"__index_level_0__":6},
+		{"carat":0.26,"cut":"Very Good","color":"H","clarity":"SI1","depth":61.9,"table":55,"price":337,"x":4.07,"y":4.11,"z":2.53,"__index_level_0__":7},
+		{"carat":0.22,"cut":"Fair","color":"E","clarity":"VS2","depth":65.1,"table":61,"price":337,"x":3.87,"y":3.78,"z":2.49,"__index_level_0__":8},
+		{"carat":0.23,"cut":"Very Good","color":"H","clarity":"VS1","depth":59.4,"table":61,"price":338,"x":4,"y":4.05,"z":2.39,"__index_level_0__":9}
+	]`
+	var want []map[string]any
+	decoder := json.NewDecoder(strings.NewReader(expected))
+	decoder.UseNumber()
+	if err := decoder.Decode(&want); err != nil {
+		t.Fatal(err)
+	}
+	reader, closeFile, err := OpenParquet("testdata/diamonds.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFile()
+	if columns := reader.Columns(); len(columns) != 11 || columns[1].Type != "string" || columns[6].Type != "integer" {
+		t.Errorf("unexpected columns %+v", columns)
+	}
+	var rows []map[string]any
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v\nwant %v", rows, want)
+	}
+}
+
+func TestParquetReaderCorrupt(t *testing.T) {
+	file := func(rows int64, numValues int32, data []byte) []byte {
+		return testParquetFile([]tStruct{
+			{{4, "schema"}, {5, int32(1)}},
+			{{1, int32(parquetByteArray)}, {3, int32(0)}, {4, "s"}},
+		}, rows, []parquetChunk{{pages: [][]byte{parquetPage(tStruct{{1, int32(0)}, {5, tStruct{{1, numValues}, {2, int32(0)}}}}, data)}}})
+	}
+	tests := map[string][]byte{
+		"negative rows":         file(-1, 1, append(le32(1), 'a')),
+		"huge rows":             file(math.MaxInt64, 1, append(le32(1), 'a')),
+		"negative page values":  file(1, -1, append(le32(1), 'a')),
+		"page values over rows": file(1, math.MaxInt32, append(le32(1), 'a')),
+		"value over the page":   file(1, 1, append(le32(math.MaxInt32), 'a')),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			reader, err := NewParquetReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := reader.Next(); err == nil || err == io.EOF {
+				t.Errorf("corrupt file must be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+// FuzzParquetReader checks that corrupt files are rejected with errors and don't panic or allocate unbounded
+func FuzzParquetReader(f *testing.F) {
+	f.Add(testParquetData())
+	if fixture, err := os.ReadFile("testdata/diamonds.parquet"); err == nil {
+		f.Add(fixture)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader, err := NewParquetReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		reader.Columns()
+		for err == nil {
+			_, err = reader.Next()
+		}
+	})
+}
+
+func FuzzSnappyDecode(f *testing.F) {
+	encoded, _ := hex.DecodeString("a4031c6162636465666768fe0800fe0800fe0800fe0800de08000078fe01008a0100")
+	f.Add(encoded)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, _ = snappyDecode(data)
+	})
+}
@@ -0,0 +1,74 @@
+package sdk
+
+import (
+	"sort"
+	"sync"
+)
+
+// Projection strips columns not selected by the host (selectedColumns stream option) before rows reach
+// connector's mapping code. It reduces payload size and prevents accidental PII transfer.
+// Names of dropped columns are collected to be reported in stream-result.
+type Projection struct {
+	selected map[string]bool
+	mu       sync.Mutex
+	dropped  map[string]bool
+}
+
+// NewProjection creates projection keeping only selected columns. Empty selection keeps all columns
+func NewProjection(selected []string) *Projection {
+	p := &Projection{dropped: make(map[string]bool)}
+	if len(selected) > 0 {
+		p.selected = make(map[string]bool, len(selected))
+		for _, c := range selected {
+			p.selected[c] = true
+		}
+	}
+	return p
+}
+
+// ProjectionFromOptions creates projection from 'selectedColumns' array of start-stream options
+func ProjectionFromOptions(options map[string]any) *Projection {
+	raw, _ := options["selectedColumns"].([]any)
+	selected := make([]string, 0, len(raw))
+	for _, c := range raw {
+		if s, ok := c.(string); ok {
+			selected = append(selected, s)
+		}
+	}
+	return NewProjection(selected)
+}
+
+// Enabled returns true if the host selected columns
+func (p *Projection) Enabled() bool {
+	return p != nil && p.selected != nil
+}
+
+// Apply removes unselected columns from the row in place
+func (p *Projection) Apply(row map[string]any) {
+	if !p.Enabled() {
+		return
+	}
+	for name := range row {
+		if !p.selected[name] {
+			delete(row, name)
+			p.mu.Lock()
+			p.dropped[name] = true
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Dropped returns sorted names of columns removed from rows so far
+func (p *Projection) Dropped() []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dropped := make([]string, 0, len(p.dropped))
+	for name := range p.dropped {
+		dropped = append(dropped, name)
+	}
+	sort.Strings(dropped)
+	return dropped
+}
@@ -0,0 +1,109 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by outbound calls of a connector, so APIs with RPS or RPM quotas are not
+// overrun: up to Burst calls are made right away, further calls wait for tokens refilled at RequestsPerSecond.
+// Nil limiter doesn't limit
+type RateLimiter struct {
+	RequestsPerSecond float64
+	Burst             int
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	waits  int
+	waited time.Duration
+}
+
+// NewRateLimiter returns a limiter with full bucket. Burst less than 1 is 1
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	burst = max(burst, 1)
+	return &RateLimiter{RequestsPerSecond: requestsPerSecond, Burst: burst, tokens: float64(burst)}
+}
+
+// RateLimiterFromCredentials creates limiter from 'requestsPerSecond' and 'requestsBurst' credentials options.
+// Returns nil if requestsPerSecond is not set
+func RateLimiterFromCredentials(creds map[string]any) (*RateLimiter, error) {
+	rps, _ := creds["requestsPerSecond"].(float64)
+	burst, _ := creds["requestsBurst"].(float64)
+	if rps < 0 || burst < 0 {
+		return nil, fmt.Errorf("requestsPerSecond and requestsBurst must not be negative")
+	}
+	if rps == 0 {
+		if burst > 0 {
+			return nil, fmt.Errorf("requestsBurst requires requestsPerSecond")
+		}
+		return nil, nil
+	}
+	return NewRateLimiter(rps, int(burst)), nil
+}
+
+// Wait blocks until a call is allowed. Returns early with error if ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.RequestsPerSecond, float64(l.Burst))
+	}
+	l.last = now
+	// the token is taken right away, so concurrent callers queue up behind each other
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.RequestsPerSecond * float64(time.Second))
+		l.waits++
+		l.waited += delay
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the number of calls that waited and total wait time
+func (l *RateLimiter) Stats() (int, time.Duration) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waits, l.waited
+}
+
+// Transport applies the limiter to requests made with base transport
+func (l *RateLimiter) Transport(base http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return base
+	}
+	return &rateLimitingTransport{base: base, limiter: l}
+}
+
+type rateLimitingTransport struct {
+	base    http.RoundTripper
+	limiter *RateLimiter
+}
+
+func (t *rateLimitingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(100, 3)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 3 calls fit the burst, 2 more wait 10ms each
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("5 calls took %s, expected about 20ms", elapsed)
+	}
+	if waits, waited := limiter.Stats(); waits != 2 || waited < 15*time.Millisecond {
+		t.Errorf("Stats() = %d, %s", waits, waited)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := NewRateLimiter(0.1, 1)
+	_ = slow.Wait(ctx)
+	if err := slow.Wait(ctx); err == nil {
+		t.Error("Wait must return when context is done")
+	}
+}
+
+func TestRateLimiterTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	limiter, err := RateLimiterFromCredentials(map[string]any{"requestsPerSecond": 10.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: limiter.Transport(http.DefaultTransport)}
+	for i := 0; i < 3; i++ {
+		res, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+	}
+	if waits, _ := limiter.Stats(); waits != 2 {
+		t.Errorf("%d calls waited, want 2", waits)
+	}
+	if limiter, err = RateLimiterFromCredentials(map[string]any{}); limiter != nil || err != nil {
+		t.Errorf("limiter must not be created without requestsPerSecond: %v %v", limiter, err)
+	}
+}
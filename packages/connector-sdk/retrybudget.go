@@ -0,0 +1,54 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrRetryBudgetExhausted is returned instead of retrying once the run has used up its retry budget
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// RetryBudget bounds retries of the whole run, shared by all retrying callers, e.g. paginators and RpcClient.
+// Without it each caller backs off on its own, so a pathological destination may stretch a sync for hours.
+// Once exhausted, retries fail promptly with ErrRetryBudgetExhausted. Nil budget is unlimited
+type RetryBudget struct {
+	// MaxAttempts is the maximum number of retries. 0 means unlimited
+	MaxAttempts int
+	// MaxDelay is the maximum total time spent waiting before retries. 0 means unlimited
+	MaxDelay time.Duration
+
+	mu       sync.Mutex
+	attempts int
+	delay    time.Duration
+}
+
+func NewRetryBudget(maxAttempts int, maxDelay time.Duration) *RetryBudget {
+	return &RetryBudget{MaxAttempts: maxAttempts, MaxDelay: maxDelay}
+}
+
+// Reserve accounts a retry after delay. Returns ErrRetryBudgetExhausted if the retry doesn't fit the budget
+func (b *RetryBudget) Reserve(delay time.Duration) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if (b.MaxAttempts > 0 && b.attempts+1 > b.MaxAttempts) || (b.MaxDelay > 0 && b.delay+delay > b.MaxDelay) {
+		return fmt.Errorf("%w: %d retries, %s of backoff so far", ErrRetryBudgetExhausted, b.attempts, b.delay)
+	}
+	b.attempts++
+	b.delay += delay
+	return nil
+}
+
+// Used returns the number of retries and total delay accounted so far
+func (b *RetryBudget) Used() (int, time.Duration) {
+	if b == nil {
+		return 0, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.attempts, b.delay
+}
@@ -0,0 +1,455 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RpcEnv is the environment variable with URL of the host RPC server
+const RpcEnv = "RPC_URL"
+
+// TraceIdHeader carries trace id of the sync, see StartStream.TraceId, on RPC calls and requests to destinations
+const TraceIdHeader = "X-Syncmaven-Trace-Id"
+
+// ErrRpcUnavailable matches errors of calls that failed because the host didn't respond, responded with 429 or 5xx,
+// or the circuit is open
+var ErrRpcUnavailable = errors.New("rpc server is unavailable")
+
+// idempotentMethods are retried on failures
+var idempotentMethods = map[string]bool{"state.get": true, "state.list": true}
+
+// RpcClient calls RPC methods of the host, e.g. state.get and state.set.
+//
+// Idempotent calls are retried with exponential backoff. After BreakerThreshold consecutive failures the circuit
+// opens: calls fail fast with ErrRpcUnavailable for BreakerCooldown, then a single call decides whether it's closed
+// again. state.set calls that fail while the host is unavailable are kept in write-behind buffer and replayed
+// in order once a call succeeds, so a transient orchestrator hiccup doesn't lose state writes. Buffered values are
+// returned by Get and List. Flush must be called before exit
+type RpcClient struct {
+	url    string
+	client http.Client
+	// signingSecret is shared with the host. If set, requests are signed so the host can verify them
+	signingSecret string
+
+	// Retries is the number of retries of idempotent calls
+	Retries int
+	// RetryBackoff is the delay before the first retry. It doubles with each retry
+	RetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failures that open the circuit. 0 disables circuit breaking
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// MaxPendingWrites bounds write-behind buffer. Writes that don't fit fail. 0 disables buffering
+	MaxPendingWrites int
+	// RetryBudget is shared by retrying callers of the run. Nil means unlimited
+	RetryBudget *RetryBudget
+	// TraceId is sent with TraceIdHeader if set
+	TraceId string
+	// ReadOnly skips writes, e.g. in dry run. Reads return the stored state
+	ReadOnly bool
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	// pending are buffered state.set calls in the order they were made. Keys are unique, the last write wins
+	pending   []pendingWrite
+	replaying bool
+}
+
+type pendingWrite struct {
+	key   []string
+	value any
+	// callId of the write that failed, replay sends it again with the same idempotency key
+	callId string
+}
+
+// NewRpcClient returns client of the RPC server at url. Requests are signed with the secret from SigningSecretEnv
+func NewRpcClient(url string) *RpcClient {
+	return &RpcClient{
+		url:              url,
+		client:           http.Client{Timeout: time.Second * 5},
+		signingSecret:    os.Getenv(SigningSecretEnv),
+		Retries:          3,
+		RetryBackoff:     200 * time.Millisecond,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+		MaxPendingWrites: 1000,
+	}
+}
+
+// unavailableError is a failure that may be retried
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *unavailableError) Is(target error) bool {
+	return target == ErrRpcUnavailable
+}
+
+// Call posts body to the method and returns decoded response. NDJSON responses are returned as arrays.
+// Idempotent methods are retried while the host is unavailable
+func (r *RpcClient) Call(method string, body any) (any, error) {
+	return r.call(method, body, NewCallId(), decodeResponse)
+}
+
+// call posts body to the method and reads successful response with read. Errors of read are not retried: read may
+// have consumed a part of the response. Retries share callId, so the host can recognize repeated calls
+func (r *RpcClient) call(method string, body any, callId string, read func(resp *http.Response) (any, error)) (any, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	attempts := 1
+	if idempotentMethods[method] {
+		attempts += r.Retries
+	}
+	backoff := r.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := r.callOnce(method, b, callId, read)
+		if err == nil {
+			r.replay()
+		}
+		if err == nil || !errors.Is(err, ErrRpcUnavailable) || attempt >= attempts || r.isOpen() {
+			return resp, err
+		}
+		if budgetErr := r.RetryBudget.Reserve(backoff); budgetErr != nil {
+			return nil, fmt.Errorf("%w, last error: %w", budgetErr, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// callOnce makes a single call unless the circuit is open
+func (r *RpcClient) callOnce(method string, b []byte, callId string, read func(resp *http.Response) (any, error)) (any, error) {
+	if r.isOpen() {
+		return nil, &unavailableError{fmt.Errorf("POST %s/%s skipped: circuit is open after %d failures", r.url, method, r.BreakerThreshold)}
+	}
+	resp, err := r.post(method, b, callId, read)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if errors.Is(err, ErrRpcUnavailable) {
+		r.failures++
+		if r.BreakerThreshold > 0 && r.failures >= r.BreakerThreshold {
+			r.openedAt = time.Now()
+		}
+	} else {
+		r.failures = 0
+		r.openedAt = time.Time{}
+	}
+	return resp, err
+}
+
+// isOpen checks whether calls are skipped. After cooldown the circuit is half-open: calls are made,
+// and the next failure opens the circuit again
+func (r *RpcClient) isOpen() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.openedAt.IsZero() && time.Since(r.openedAt) < r.BreakerCooldown
+}
+
+func (r *RpcClient) post(method string, b []byte, callId string, read func(resp *http.Response) (any, error)) (any, error) {
+	url := r.url + "/" + method
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.TraceId != "" {
+		req.Header.Set(TraceIdHeader, r.TraceId)
+	}
+	// retries of the call share the idempotency key
+	SignRequest(req, b, r.signingSecret, callId)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, &unavailableError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBytes, _ := io.ReadAll(resp.Body)
+		err = fmt.Errorf("POST %s HTTP code = %d response: %s", url, resp.StatusCode, string(respBytes))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, &unavailableError{err}
+		}
+		return nil, err
+	}
+	return read(resp)
+}
+
+// decodeResponse decodes the whole response, NDJSON as an array
+func decodeResponse(resp *http.Response) (any, error) {
+	if resp.Header.Get("Content-Type") == "application/x-ndjson" {
+		decoder := json.NewDecoder(resp.Body)
+		arr := make([]any, 0)
+		for {
+			var object any
+			err := decoder.Decode(&object)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return nil, err
+			}
+			arr = append(arr, object)
+		}
+		return arr, nil
+	} else {
+		var response any
+		err := json.NewDecoder(resp.Body).Decode(&response)
+		if err != nil {
+			return nil, fmt.Errorf("POST %s Error unmarshalling response: %v", resp.Request.URL, err)
+		}
+		return response, nil
+
+	}
+}
+
+// Get returns state value of the key
+func (r *RpcClient) Get(key []string) (any, error) {
+	if value, ok := r.pendingValue(key); ok {
+		return value, nil
+	}
+	body := make(map[string]any, 1)
+	if len(key) == 1 {
+		body["key"] = key[0]
+	} else {
+		body["key"] = key
+	}
+	return r.Call("state.get", body)
+}
+
+// List returns state entries with keys starting with prefix as {"key", "value"} objects
+func (r *RpcClient) List(prefix []string) ([]any, error) {
+	body := make(map[string]any, 1)
+	if len(prefix) == 1 {
+		body["prefix"] = prefix[0]
+	} else {
+		body["prefix"] = prefix
+	}
+	resp, err := r.Call("state.list", body)
+	if err != nil {
+		return nil, err
+	}
+	if arr, ok := resp.([]any); ok {
+		return r.withPending(prefix, arr), nil
+	} else {
+		return nil, fmt.Errorf("unexpected response: %v", resp)
+	}
+}
+
+// Set saves state value of the key. If the host is unavailable, the value is buffered and saved once it recovers
+func (r *RpcClient) Set(key []string, value any) error {
+	if r.ReadOnly {
+		return nil
+	}
+	// the new value supersedes the buffered one
+	r.dropPending(func(k []string) bool { return slices.Equal(k, key) })
+	callId := NewCallId()
+	err := r.set(key, value, callId)
+	if errors.Is(err, ErrRpcUnavailable) && r.buffer(key, value, callId) {
+		return nil
+	}
+	return err
+}
+
+func (r *RpcClient) set(key []string, value any, callId string) error {
+	body := make(map[string]any, 2)
+	if len(key) == 1 {
+		body["key"] = key[0]
+	} else {
+		body["key"] = key
+	}
+	body["value"] = value
+	_, err := r.call("state.set", body, callId, decodeResponse)
+	return err
+}
+
+// Del deletes state value of the key
+func (r *RpcClient) Del(key []string) error {
+	if r.ReadOnly {
+		return nil
+	}
+	r.dropPending(func(k []string) bool { return slices.Equal(k, key) })
+	body := make(map[string]any, 2)
+	if len(key) == 1 {
+		body["key"] = key[0]
+	} else {
+		body["key"] = key
+	}
+	_, err := r.Call("state.del", body)
+	return err
+}
+
+// DeleteByPrefix deletes state values with keys starting with prefix
+func (r *RpcClient) DeleteByPrefix(prefix []string) error {
+	if r.ReadOnly {
+		return nil
+	}
+	r.dropPending(func(k []string) bool { return hasKeyPrefix(k, prefix) })
+	body := make(map[string]any, 2)
+	if len(prefix) == 1 {
+		body["prefix"] = prefix[0]
+	} else {
+		body["prefix"] = prefix
+	}
+	_, err := r.Call("state.deleteByPrefix", body)
+	return err
+}
+
+// Size returns size of state value of the key
+func (r *RpcClient) Size(key []string) (int, error) {
+	body := make(map[string]any, 1)
+	if len(key) == 1 {
+		body["key"] = key[0]
+	} else {
+		body["key"] = key
+	}
+	resp, err := r.Call("state.get", body)
+	if err != nil {
+		return -1, err
+	}
+	if mp, ok := resp.(map[string]any); ok {
+		return int(mp["size"].(float64)), nil
+	} else {
+		return -1, fmt.Errorf("unexpected response: %v", resp)
+	}
+}
+
+// Flush replays buffered writes. Returns error if some of them are still not saved
+func (r *RpcClient) Flush() error {
+	r.replay()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) > 0 {
+		return fmt.Errorf("%d state writes are not saved: %w", len(r.pending), ErrRpcUnavailable)
+	}
+	return nil
+}
+
+// PendingWrites returns the number of buffered writes
+func (r *RpcClient) PendingWrites() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+// buffer adds the write to write-behind buffer. Returns false if the buffer is full
+func (r *RpcClient) buffer(key []string, value any, callId string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) >= r.MaxPendingWrites {
+		return false
+	}
+	r.pending = append(r.pending, pendingWrite{key: slices.Clone(key), value: value, callId: callId})
+	return true
+}
+
+// replay saves buffered writes in order. Stops at the first write that fails because the host is unavailable.
+// Writes rejected by the host are dropped, they would have failed without buffering too
+func (r *RpcClient) replay() {
+	r.mu.Lock()
+	if r.replaying || len(r.pending) == 0 {
+		r.mu.Unlock()
+		return
+	}
+	r.replaying = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.replaying = false
+		r.mu.Unlock()
+	}()
+	for {
+		r.mu.Lock()
+		if len(r.pending) == 0 {
+			r.mu.Unlock()
+			return
+		}
+		w := r.pending[0]
+		r.mu.Unlock()
+		err := r.set(w.key, w.value, w.callId)
+		if errors.Is(err, ErrRpcUnavailable) {
+			return
+		}
+		// the write may be superseded or deleted while in flight, so it's removed only if it's still the first one
+		r.mu.Lock()
+		if len(r.pending) > 0 && slices.Equal(r.pending[0].key, w.key) {
+			r.pending = r.pending[1:]
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *RpcClient) dropPending(match func(key []string) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = slices.DeleteFunc(r.pending, func(w pendingWrite) bool { return match(w.key) })
+}
+
+func (r *RpcClient) pendingValue(key []string) (any, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.pending {
+		if slices.Equal(w.key, key) {
+			return w.value, true
+		}
+	}
+	return nil, false
+}
+
+// withPending replaces values of listed entries with buffered ones and adds buffered entries missing from the list
+func (r *RpcClient) withPending(prefix []string, entries []any) []any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range r.pending {
+		if !hasKeyPrefix(w.key, prefix) {
+			continue
+		}
+		found := false
+		for _, e := range entries {
+			entry, ok := e.(map[string]any)
+			if ok && slices.Equal(entryKey(entry["key"]), w.key) {
+				entry["value"] = w.value
+				found = true
+			}
+		}
+		if !found {
+			entries = append(entries, map[string]any{"key": w.key, "value": w.value})
+		}
+	}
+	return entries
+}
+
+func hasKeyPrefix(key []string, prefix []string) bool {
+	return len(key) >= len(prefix) && slices.Equal(key[:len(prefix)], prefix)
+}
+
+// entryKey converts key of state.list entry, a string or an array of segments, to segments
+func entryKey(raw any) []string {
+	switch key := raw.(type) {
+	case string:
+		return []string{key}
+	case []any:
+		segments := make([]string, len(key))
+		for i, segment := range key {
+			segments[i] = fmt.Sprint(segment)
+		}
+		return segments
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package sdk
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer responds with 503 while down is set, otherwise passes calls to the state server
+func flakyServer(t *testing.T) (*RpcClient, map[string]any, *atomic.Bool, *atomic.Int32) {
+	state, store := stateServer(t)
+	var down atomic.Bool
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		proxy, _ := http.NewRequest(r.Method, state.url+r.URL.Path, r.Body)
+		res, err := http.DefaultClient.Do(proxy)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		w.WriteHeader(res.StatusCode)
+		b, _ := io.ReadAll(res.Body)
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(server.Close)
+	client := NewRpcClient(server.URL)
+	client.RetryBackoff = time.Millisecond
+	client.BreakerThreshold = 3
+	client.BreakerCooldown = 50 * time.Millisecond
+	return client, store, &down, &calls
+}
+
+func TestRpcClientRetriesAndBreaker(t *testing.T) {
+	client, _, down, calls := flakyServer(t)
+	down.Store(true)
+	if _, err := client.Get([]string{"a"}); !errors.Is(err, ErrRpcUnavailable) {
+		t.Fatalf("expected unavailable error, got %v", err)
+	}
+	// the circuit opens after the third failed attempt, so the last retry is skipped
+	if n := calls.Load(); n != 3 {
+		t.Errorf("state.get made %d calls, want 3", n)
+	}
+	if _, err := client.Get([]string{"a"}); !errors.Is(err, ErrRpcUnavailable) || calls.Load() != 3 {
+		t.Errorf("calls must be skipped while the circuit is open: %v, %d calls", err, calls.Load())
+	}
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := client.Get([]string{"a"}); err != nil {
+		t.Errorf("half-open circuit must allow the call: %v", err)
+	}
+}
+
+func TestRpcClientWriteBehind(t *testing.T) {
+	client, store, down, _ := flakyServer(t)
+	down.Store(true)
+	if err := client.Set([]string{"sync", "a"}, 1.0); err != nil {
+		t.Fatalf("write must be buffered while the host is down: %v", err)
+	}
+	_ = client.Set([]string{"sync", "b"}, 2.0)
+	_ = client.Set([]string{"sync", "a"}, 3.0)
+	if n := client.PendingWrites(); n != 2 {
+		t.Errorf("%d pending writes, want 2", n)
+	}
+	if v, err := client.Get([]string{"sync", "a"}); err != nil || v != 3.0 {
+		t.Errorf("buffered value must be returned: %v %v", v, err)
+	}
+	if err := client.Flush(); !errors.Is(err, ErrRpcUnavailable) {
+		t.Errorf("flush must fail while the host is down: %v", err)
+	}
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if store[`["sync","a"]`] != 3.0 || store[`["sync","b"]`] != 2.0 {
+		t.Errorf("buffered writes are not replayed: %v", store)
+	}
+}
+
+func TestRpcClientReadOnly(t *testing.T) {
+	client, store, _, _ := flakyServer(t)
+	if err := client.Set([]string{"sync", "a"}, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	client.ReadOnly = true
+	if err := client.Set([]string{"sync", "a"}, 2.0); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.Del([]string{"sync", "a"})
+	_ = client.DeleteByPrefix([]string{"sync"})
+	if v, err := client.Get([]string{"sync", "a"}); err != nil || v != 1.0 || store[`["sync","a"]`] != 1.0 {
+		t.Errorf("read-only client must not change the state: %v %v %v", v, err, store)
+	}
+}
+
+func TestRpcClientTraceId(t *testing.T) {
+	var traceId string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceId = r.Header.Get(TraceIdHeader)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := NewRpcClient(server.URL)
+	client.TraceId = "4bf92f35"
+	if _, err := client.Get([]string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if traceId != "4bf92f35" {
+		t.Errorf("trace id header = %q", traceId)
+	}
+}
+
+func TestRpcClientIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		// the first attempt of each call fails
+		if len(keys)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := NewRpcClient(server.URL)
+	client.RetryBackoff = time.Millisecond
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get([]string{"a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(keys) != 4 || keys[0] == "" || keys[0] != keys[1] || keys[2] != keys[3] {
+		t.Errorf("retries must share the idempotency key: %v", keys)
+	}
+	if keys[1] == keys[2] {
+		t.Errorf("calls with the same body must get different idempotency keys: %v", keys)
+	}
+}
+
+func TestRpcClientReplayIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		mu.Unlock()
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := NewRpcClient(server.URL)
+	down.Store(true)
+	if err := client.Set([]string{"sync", "a"}, 1.0); err != nil {
+		t.Fatalf("write must be buffered while the host is down: %v", err)
+	}
+	down.Store(false)
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// the host may have applied the failed write, so the replay must be recognized as the same write
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("replayed state.set must send the idempotency key of the buffered write: %v", keys)
+	}
+}
@@ -0,0 +1,338 @@
+package sdk
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// IncomingMessage is a message received from the host. Payload is kept raw, so it can be decoded according to the message type
+type IncomingMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// DecodePayload decodes payload of the message into v. Numbers are decoded as json.Number when v contains interfaces
+func (m IncomingMessage) DecodePayload(v any) error {
+	if len(m.Payload) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(m.Payload))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// Replier sends reply messages to the host
+type Replier interface {
+	Reply(msgType string, payload any) error
+}
+
+// Handler handles messages received from the host
+type Handler interface {
+	HandleMessage(ctx context.Context, message IncomingMessage, replier Replier) error
+}
+
+// HandlerFunc adapts a function to Handler
+type HandlerFunc func(ctx context.Context, message IncomingMessage, replier Replier) error
+
+func (f HandlerFunc) HandleMessage(ctx context.Context, message IncomingMessage, replier Replier) error {
+	return f(ctx, message, replier)
+}
+
+// ErrStop may be returned by Handler to stop Run without an error, e.g. after the reply to end-stream
+var ErrStop = errors.New("stop")
+
+// MaxMessageSize is the default maximum size of a single incoming message line, see MaxMessageSizeEnv
+const MaxMessageSize = 64 * 1024 * 1024
+
+// ReplyWriter writes replies as NDJSON lines, compressed once compression is switched on with Compress.
+// Safe for concurrent use
+type ReplyWriter struct {
+	mu         sync.Mutex
+	out        io.Writer
+	compressed compressor
+}
+
+// NewReplyWriter returns ReplyWriter writing replies to out
+func NewReplyWriter(out io.Writer) *ReplyWriter {
+	return &ReplyWriter{out: out}
+}
+
+// NewReplier returns Replier writing replies to out as NDJSON lines, for connectors that read messages themselves,
+// e.g. sources passing stdin to EmitterSession.ListenAcks. Safe for concurrent use
+func NewReplier(out io.Writer) Replier {
+	return NewReplyWriter(out)
+}
+
+func (w *ReplyWriter) Reply(msgType string, payload any) error {
+	data, err := json.Marshal(Message{Type: msgType, Direction: "reply", Payload: payload})
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.compressed == nil {
+		_, err = w.out.Write(append(data, '\n'))
+		return err
+	}
+	if _, err = w.compressed.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return w.compressed.Flush()
+}
+
+// Compress compresses replies written after it, see CompressionEnv. Compression can't be changed once set
+func (w *ReplyWriter) Compress(compression string) error {
+	if err := checkCompression(compression); err != nil || compression == CompressionNone {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.compressed != nil {
+		return nil
+	}
+	compressed, err := newCompressor(compression, w.out)
+	if err != nil {
+		return err
+	}
+	w.compressed = compressed
+	return nil
+}
+
+// Close ends compressed stream of replies. Replies written after it are not compressed
+func (w *ReplyWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.compressed == nil {
+		return nil
+	}
+	err := w.compressed.Close()
+	w.compressed = nil
+	return err
+}
+
+// RecordingReplier keeps replies in memory instead of sending them. Payloads are kept as passed to Reply.
+// Used to test connectors without the host. Safe for concurrent use
+type RecordingReplier struct {
+	mu      sync.Mutex
+	replies []Message
+}
+
+func (r *RecordingReplier) Reply(msgType string, payload any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replies = append(r.replies, Message{Type: msgType, Direction: "reply", Payload: payload})
+	return nil
+}
+
+// Replies returns payloads of replies of msgType in the order they were sent
+func (r *RecordingReplier) Replies(msgType string) []any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var payloads []any
+	for _, reply := range r.replies {
+		if reply.Type == msgType {
+			payloads = append(payloads, reply.Payload)
+		}
+	}
+	return payloads
+}
+
+// finisher is implemented by handlers that finish on their own rather than with a message of the host, e.g.
+// after emitting rows of a source stream. finished returns nil unless such work is running. inputClosed is called
+// when the input is exhausted while it's running
+type finisher interface {
+	finished() <-chan error
+	inputClosed()
+}
+
+// Run reads messages from in, passes them to the handler and writes replies to out. Connector binaries
+// run it with stdin and stdout, while hosts and tests may run the same handler in-process, see Client.
+// Returns when in is exhausted, ctx is cancelled or handler returns an error. ErrStop is not returned.
+// Messages larger than the limit are skipped with error log reply. Messages are NDJSON unless other framing
+// is selected with FramingEnv or hello message, hello is replied by Run itself. Both streams are compressed if
+// requested with CompressionEnv or hello. start-stream payload is merged with StartStreamFileEnv document
+func Run(ctx context.Context, in io.Reader, out io.Writer, handler Handler) error {
+	maxSize, err := MaxMessageSizeFromEnv()
+	if err != nil {
+		return err
+	}
+	framing, err := FramingFromEnv()
+	if err != nil {
+		return err
+	}
+	compression, err := CompressionFromEnv()
+	if err != nil {
+		return err
+	}
+	reader, err := NewMessageReader(in, maxSize, framing)
+	if err != nil {
+		return err
+	}
+	replier := NewReplyWriter(out)
+	if err = reader.SetCompression(compression); err != nil {
+		return err
+	}
+	if err = replier.Compress(compression); err != nil {
+		return err
+	}
+	defer replier.Close()
+	lines := make(chan []byte)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		for {
+			line, err := reader.Next()
+			var tooLarge *MessageTooLargeError
+			if errors.As(err, &tooLarge) {
+				_ = replier.Reply("log", map[string]any{"level": "error", "message": err.Error()})
+				continue
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				scanErr <- err
+				return
+			}
+			select {
+			case lines <- bytes.Clone(line):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	f, _ := handler.(finisher)
+	for {
+		var finished <-chan error
+		if f != nil {
+			finished = f.finished()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-finished:
+			return noStop(err)
+		case line, ok := <-lines:
+			if !ok {
+				var err error
+				select {
+				case err = <-scanErr:
+				default:
+					err = ctx.Err()
+				}
+				if err == nil && finished != nil {
+					f.inputClosed()
+					select {
+					case err = <-finished:
+						err = noStop(err)
+					case <-ctx.Done():
+						err = ctx.Err()
+					}
+				}
+				return err
+			}
+			var message IncomingMessage
+			if err := json.Unmarshal(line, &message); err != nil {
+				return fmt.Errorf("message cannot be parsed: %s: %w", line, err)
+			}
+			message, path, err := MergeStartStreamFile(message)
+			if err != nil {
+				_ = replier.Reply("halt", map[string]any{"status": "error", "message": err.Error()})
+				return err
+			}
+			if path != "" {
+				_ = replier.Reply("log", map[string]any{"level": "info", "message": "Merging start-stream payload with " + path})
+			}
+			if message.Type == "hello" {
+				// framing and compression are switched by the reader already
+				if err := ReplyHello(reader, replier); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := handler.HandleMessage(ctx, message, replier); err != nil {
+				if errors.Is(err, ErrStop) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+// noStop returns nil for ErrStop
+func noStop(err error) error {
+	if errors.Is(err, ErrStop) {
+		return nil
+	}
+	return err
+}
+
+// Client runs a connector handler in-process and exchanges messages with it the same way host does with a connector process
+type Client struct {
+	in      *io.PipeWriter
+	replies chan Message
+	// done is closed when all replies are read, stopped when the handler returns
+	done    chan struct{}
+	stopped chan struct{}
+	err     error
+}
+
+// NewClient starts the handler. Replies must be consumed, otherwise the handler blocks on writing them
+func NewClient(ctx context.Context, handler Handler) *Client {
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	c := &Client{in: inWriter, replies: make(chan Message, 1024), done: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(c.stopped)
+		c.err = Run(ctx, inReader, outWriter, handler)
+		_ = inReader.CloseWithError(io.ErrClosedPipe)
+		_ = outWriter.Close()
+	}()
+	go func() {
+		defer close(c.done)
+		defer close(c.replies)
+		scanner := bufio.NewScanner(outReader)
+		scanner.Buffer(make([]byte, 0, 64*1024), MaxMessageSize)
+		for scanner.Scan() {
+			var message Message
+			if err := json.Unmarshal(scanner.Bytes(), &message); err == nil {
+				c.replies <- message
+			}
+		}
+	}()
+	return c
+}
+
+// Send sends a message to the handler
+func (c *Client) Send(msgType string, payload any) error {
+	data, err := json.Marshal(map[string]any{"type": msgType, "direction": "incoming", "payload": payload})
+	if err != nil {
+		return err
+	}
+	_, err = c.in.Write(append(data, '\n'))
+	return err
+}
+
+// Replies returns the channel of reply messages. It is closed when the handler stops
+func (c *Client) Replies() <-chan Message {
+	return c.replies
+}
+
+// Close closes the input of the handler, waits until it stops and returns its error. Unconsumed replies are discarded
+func (c *Client) Close() error {
+	_ = c.in.Close()
+	go func() {
+		for range c.replies {
+		}
+	}()
+	<-c.done
+	<-c.stopped
+	return c.err
+}
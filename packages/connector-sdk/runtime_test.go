@@ -0,0 +1,93 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoHandler replies to each row with its value and stops after end-stream
+var echoHandler = HandlerFunc(func(ctx context.Context, message IncomingMessage, replier Replier) error {
+	switch message.Type {
+	case "row":
+		var payload struct {
+			Row map[string]any `json:"row"`
+		}
+		if err := message.DecodePayload(&payload); err != nil {
+			return err
+		}
+		return replier.Reply("log", map[string]any{"level": "info", "message": "row", "params": []any{payload.Row["v"]}})
+	case "end-stream":
+		if err := replier.Reply("stream-result", map[string]any{"received": 1}); err != nil {
+			return err
+		}
+		return ErrStop
+	}
+	return nil
+})
+
+func TestRun(t *testing.T) {
+	in := strings.NewReader("{\"type\":\"row\",\"payload\":{\"row\":{\"v\":1}}}\n\n{\"type\":\"end-stream\"}\n{\"type\":\"row\",\"payload\":{\"row\":{\"v\":2}}}\n")
+	out := &bytes.Buffer{}
+	if err := Run(context.Background(), in, out, echoHandler); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"log","direction":"reply","payload":{"level":"info","message":"row","params":[1]}}` + "\n" +
+		`{"type":"stream-result","direction":"reply","payload":{"received":1}}` + "\n"
+	if out.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if err := Run(context.Background(), strings.NewReader("not json\n"), out, echoHandler); err == nil {
+		t.Error("expected error for unparseable message")
+	}
+}
+
+func TestLineReaderSkipsTooLargeMessages(t *testing.T) {
+	big := `{"type":"row","payload":{"row":{"v":"` + strings.Repeat("x", 200*1024) + `"}}}`
+	reader := NewLineReader(strings.NewReader("{\"type\":\"describe\"}\n"+big+"\n  \n{\"type\":\"end-stream\"}"), 100*1024)
+	line, err := reader.Next()
+	if err != nil || string(line) != `{"type":"describe"}` {
+		t.Fatalf("Next() = %s, %v", line, err)
+	}
+	_, err = reader.Next()
+	var tooLarge *MessageTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.Line != 2 || tooLarge.Type != "row" || tooLarge.Size != len(big)+1 {
+		t.Fatalf("expected too large error, got %v", err)
+	}
+	if line, err = reader.Next(); err != nil || string(line) != `{"type":"end-stream"}` {
+		t.Errorf("reading must continue after too large message: %s, %v", line, err)
+	}
+	if _, err = reader.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewClient(ctx, echoHandler)
+	if err := client.Send("row", map[string]any{"row": map[string]any{"v": "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	reply := <-client.Replies()
+	if reply.Type != "log" {
+		t.Errorf("unexpected reply: %+v", reply)
+	}
+	if err := client.Send("end-stream", nil); err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for reply := range client.Replies() {
+		types = append(types, reply.Type)
+	}
+	if strings.Join(types, ",") != "stream-result" {
+		t.Errorf("unexpected replies: %v", types)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,152 @@
+package sdk
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+)
+
+// SchemaInferrer infers JSON schema rowType from sampled rows of loosely-typed sources (CSV, Sheets, SQL).
+// Types are widened as new rows are added:
+//   - null makes a column nullable
+//   - integer + number = number
+//   - date + date-time = date-time string
+//   - any other mix of types = string
+//
+// Columns present and non-null in every row are required. The result is meant to be published
+// as rowType of a stream in stream-spec reply.
+type SchemaInferrer struct {
+	maxRows int
+	rows    int
+	columns map[string]*columnType
+}
+
+type columnType struct {
+	typ      string
+	format   string
+	nullable bool
+	present  int
+}
+
+// NewSchemaInferrer creates inferrer that takes into account first maxRows rows. 0 means all rows
+func NewSchemaInferrer(maxRows int) *SchemaInferrer {
+	return &SchemaInferrer{maxRows: maxRows, columns: make(map[string]*columnType)}
+}
+
+// Add adds a sampled row. Returns false if maxRows rows are already sampled
+func (s *SchemaInferrer) Add(row map[string]any) bool {
+	if s.maxRows > 0 && s.rows >= s.maxRows {
+		return false
+	}
+	s.rows++
+	for name, value := range row {
+		c, ok := s.columns[name]
+		if !ok {
+			c = &columnType{}
+			s.columns[name] = c
+		}
+		if value == nil {
+			c.nullable = true
+			continue
+		}
+		c.present++
+		typ, format := valueType(value)
+		c.widen(typ, format)
+	}
+	return true
+}
+
+// Schema returns inferred JSON schema
+func (s *SchemaInferrer) Schema() map[string]any {
+	properties := make(map[string]any, len(s.columns))
+	required := make([]string, 0)
+	for name, c := range s.columns {
+		typ := c.typ
+		if typ == "" {
+			// only nulls were seen
+			typ = "string"
+		}
+		property := map[string]any{"type": typ}
+		if c.nullable || c.present < s.rows {
+			property["type"] = []string{typ, "null"}
+		} else {
+			required = append(required, name)
+		}
+		if c.format != "" {
+			property["format"] = c.format
+		}
+		properties[name] = property
+	}
+	sort.Strings(required)
+	return map[string]any{
+		"$schema":    "http://json-schema.org/draft-07/schema#",
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// InferSchema infers JSON schema of the rows
+func InferSchema(rows []map[string]any) map[string]any {
+	s := NewSchemaInferrer(0)
+	for _, row := range rows {
+		s.Add(row)
+	}
+	return s.Schema()
+}
+
+func (c *columnType) widen(typ, format string) {
+	switch {
+	case c.typ == "":
+		c.typ, c.format = typ, format
+	case c.typ == typ:
+		if c.format != format {
+			if slices.Contains([]string{"date", "date-time"}, c.format) && slices.Contains([]string{"date", "date-time"}, format) {
+				c.format = "date-time"
+			} else {
+				c.format = ""
+			}
+		}
+	case (c.typ == "integer" && typ == "number") || (c.typ == "number" && typ == "integer"):
+		c.typ = "number"
+	default:
+		c.typ, c.format = "string", ""
+	}
+}
+
+func valueType(v any) (typ string, format string) {
+	switch t := v.(type) {
+	case bool:
+		return "boolean", ""
+	case float64:
+		if t == float64(int64(t)) {
+			return "integer", ""
+		}
+		return "number", ""
+	case float32:
+		return "number", ""
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "integer", ""
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			return "integer", ""
+		}
+		return "number", ""
+	case string:
+		if _, err := time.Parse(time.DateOnly, t); err == nil {
+			return "string", "date"
+		}
+		if _, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			return "string", "date-time"
+		}
+		return "string", ""
+	case time.Time:
+		return "string", "date-time"
+	case map[string]any:
+		return "object", ""
+	case []any:
+		return "array", ""
+	}
+	return "string", ""
+}
@@ -0,0 +1,31 @@
+package sdk
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	schema := InferSchema([]map[string]any{
+		{"id": float64(1), "amount": float64(10), "day": "2024-01-01", "code": float64(1), "note": nil, "flag": true},
+		{"id": json.Number("2"), "amount": 10.5, "day": "2024-01-02T10:00:00Z", "code": "A1", "flag": false},
+	})
+	properties := schema["properties"].(map[string]any)
+	expected := map[string]any{
+		"id":     map[string]any{"type": "integer"},
+		"amount": map[string]any{"type": "number"},
+		"day":    map[string]any{"type": "string", "format": "date-time"},
+		"code":   map[string]any{"type": "string"},
+		"note":   map[string]any{"type": []string{"string", "null"}},
+		"flag":   map[string]any{"type": "boolean"},
+	}
+	for name, want := range expected {
+		if !reflect.DeepEqual(properties[name], want) {
+			t.Errorf("%s: got %v, want %v", name, properties[name], want)
+		}
+	}
+	if want := []string{"amount", "code", "day", "flag", "id"}; !reflect.DeepEqual(schema["required"], want) {
+		t.Errorf("required = %v, want %v", schema["required"], want)
+	}
+}
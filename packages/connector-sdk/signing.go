@@ -0,0 +1,77 @@
+package sdk
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of signed requests. Signature is HMAC-SHA256 of "<timestamp>.<body>" with the shared secret:
+//
+//	X-Syncmaven-Timestamp: 1700000000
+//	X-Syncmaven-Signature: sha256=5d41402abc4b2a76b9719d911017c592...
+//	Idempotency-Key: 9f86d081884c7d659a2feaa0c55ad015
+const (
+	TimestampHeader      = "X-Syncmaven-Timestamp"
+	SignatureHeader      = "X-Syncmaven-Signature"
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// SigningSecretEnv is the environment variable with the secret shared with the host for signing RPC calls
+const SigningSecretEnv = "RPC_SIGNING_SECRET"
+
+// Signature returns HMAC-SHA256 signature of the body sent at timestamp
+func Signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// IdempotencyKey derives the key from the batch id, so retried deliveries of the same batch share the key
+func IdempotencyKey(batchId string) string {
+	h := sha256.Sum256([]byte(batchId))
+	return hex.EncodeToString(h[:16])
+}
+
+// NewCallId returns a random batch id for SignRequest. The id is generated once per logical call and reused by its
+// retries and by replay of buffered writes, so two calls with the same body, e.g. setting a value back, are both applied
+func NewCallId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SignRequest adds timestamp, signature and idempotency key headers to the request.
+// Signature is not added if secret is empty. Idempotency key is not added if batchId is empty
+func SignRequest(req *http.Request, body []byte, secret string, batchId string) {
+	if secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(SignatureHeader, Signature(secret, timestamp, body))
+	}
+	if batchId != "" {
+		req.Header.Set(IdempotencyKeyHeader, IdempotencyKey(batchId))
+	}
+}
+
+// VerifySignature checks signature headers of a request with the body. Requests signed more than tolerance ago are rejected
+func VerifySignature(header http.Header, body []byte, secret string, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header", TimestampHeader)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("request timestamp is outside of %s tolerance", tolerance)
+	}
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Signature(secret, timestamp, body))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package sdk
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignRequest(t *testing.T) {
+	body := []byte(`{"key":"a","value":1}`)
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost/state.set", nil)
+	SignRequest(req, body, "secret", "state.set:batch-1")
+	if err := VerifySignature(req.Header, body, "secret", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature(req.Header, []byte(`{"key":"a","value":2}`), "secret", time.Minute); err == nil {
+		t.Error("expected error for tampered body")
+	}
+	if err := VerifySignature(req.Header, body, "other", time.Minute); err == nil {
+		t.Error("expected error for wrong secret")
+	}
+	if got, want := req.Header.Get(IdempotencyKeyHeader), IdempotencyKey("state.set:batch-1"); got != want || len(got) != 32 {
+		t.Errorf("idempotency key = %s, want %s", got, want)
+	}
+	// known value, so other implementations (node-cdk, host) can be checked against it
+	if got, want := Signature("secret", 1700000000, body), "sha256=c6ea90c0e59c4ad75ba22110d979045f33607d970a15bf93b31063cdc400dee1"; got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package sdk
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+// snappyDecode decompresses snappy block format, which is used by Parquet SNAPPY codec
+func snappyDecode(src []byte) ([]byte, error) {
+	n, read := binary.Uvarint(src)
+	// no element expands more than a copy of 3 bytes to 64 bytes, so the claimed length is checked against the
+	// input before allocating
+	if read <= 0 || n > 1<<31 || n > 22*uint64(len(src)) {
+		return nil, errSnappyCorrupt
+	}
+	src = src[read:]
+	dst := make([]byte, 0, n)
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 3 {
+		case 0:
+			// literal: length-1 is in the tag or in the following 1-4 bytes
+			length := int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				bytes := length - 59
+				if len(src) < bytes {
+					return nil, errSnappyCorrupt
+				}
+				length = 0
+				for i := bytes - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[bytes:]
+			}
+			length++
+			if length <= 0 || length > len(src) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length := 4 + int(tag>>2)&7
+			offset := int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		case 2:
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			length := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		case 3:
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			length := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+			if err := snappyCopy(&dst, offset, length); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if uint64(len(dst)) != n {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
+
+// snappyCopy appends length bytes starting offset bytes back. Ranges may overlap
+func snappyCopy(dst *[]byte, offset, length int) error {
+	if offset <= 0 || offset > len(*dst) {
+		return errSnappyCorrupt
+	}
+	start := len(*dst) - offset
+	for i := 0; i < length; i++ {
+		*dst = append(*dst, (*dst)[start+i])
+	}
+	return nil
+}
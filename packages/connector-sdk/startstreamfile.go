@@ -0,0 +1,113 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StartStreamFileEnv points to a YAML or JSON document that is merged into start-stream payload. Container
+// deployments (e.g. Kubernetes) may mount stream config as a file instead of passing it through the protocol.
+// Values sent by the host take precedence over the file
+const StartStreamFileEnv = "START_STREAM_FILE"
+
+// MergeStartStreamFile merges the document of StartStreamFileEnv into payload of start-stream message. Objects are
+// merged recursively, other values of the host replace values of the file. Returns the path of the merged file, empty
+// if the message isn't start-stream, the variable isn't set or the file is empty. Run merges it before
+// passing the message to the handler, connectors reading messages themselves call it before decoding start-stream
+func MergeStartStreamFile(message IncomingMessage) (IncomingMessage, string, error) {
+	path := os.Getenv(StartStreamFileEnv)
+	if message.Type != "start-stream" || path == "" {
+		return message, "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return message, "", fmt.Errorf("cannot read %s %s: %w", StartStreamFileEnv, path, err)
+	}
+	var node yaml.Node
+	if err = yaml.Unmarshal(data, &node); err != nil {
+		return message, "", fmt.Errorf("cannot read %s %s: %w", StartStreamFileEnv, path, err)
+	}
+	doc, err := yamlValue(&node)
+	if err != nil {
+		return message, "", fmt.Errorf("cannot read %s %s: %w", StartStreamFileEnv, path, err)
+	}
+	if doc == nil {
+		return message, "", nil
+	}
+	base, ok := doc.(map[string]any)
+	if !ok {
+		return message, "", fmt.Errorf("%s %s must contain an object, got %T", StartStreamFileEnv, path, doc)
+	}
+	payload := make(map[string]any)
+	if err = message.DecodePayload(&payload); err != nil {
+		return message, "", payloadError(message.Type, err)
+	}
+	merged, err := json.Marshal(deepMerge(base, payload))
+	if err != nil {
+		return message, "", fmt.Errorf("%s %s: %w", StartStreamFileEnv, path, err)
+	}
+	message.Payload = merged
+	return message, path, nil
+}
+
+// deepMerge returns base with values of override. Objects of both are merged recursively
+func deepMerge(base, override map[string]any) map[string]any {
+	res := make(map[string]any, len(base)+len(override))
+	for key, value := range base {
+		res[key] = value
+	}
+	for key, value := range override {
+		b, baseIsObject := res[key].(map[string]any)
+		o, isObject := value.(map[string]any)
+		if baseIsObject && isObject {
+			res[key] = deepMerge(b, o)
+		} else {
+			res[key] = value
+		}
+	}
+	return res
+}
+
+// yamlValue converts YAML node to a JSON value. Timestamps are kept as written, e.g. startDate: 2024-01-01 is a date
+// string of start-stream rather than a time
+func yamlValue(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case 0:
+		// empty document
+		return nil, nil
+	case yaml.DocumentNode:
+		return yamlValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]any, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := yamlValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[node.Content[i].Value] = value
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		s := make([]any, len(node.Content))
+		for i, child := range node.Content {
+			value, err := yamlValue(child)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = value
+		}
+		return s, nil
+	default:
+		if node.ShortTag() == "!!timestamp" {
+			return node.Value, nil
+		}
+		var value any
+		err := node.Decode(&value)
+		return value, err
+	}
+}
@@ -0,0 +1,51 @@
+package sdk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStartStreamFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stream.yaml")
+	t.Setenv(StartStreamFileEnv, path)
+	doc := "stream: from-file\nsyncId: file\nstartDate: 2024-01-01\nconnectionCredentials:\n  token: file-token\n  region: eu\n"
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := &countingConnector{}
+	replies, err := exchange(t, c,
+		Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{"token": "t"}}},
+		Message{Type: "end-stream"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// values of the host take precedence, objects are merged
+	if c.stream.Stream != "s" || c.stream.SyncId != "file" || c.stream.StartDate != "2024-01-01" ||
+		c.stream.ConnectionCredentials["token"] != "t" || c.stream.ConnectionCredentials["region"] != "eu" {
+		t.Errorf("unexpected start-stream payload: %+v", c.stream)
+	}
+	if replies[0].Type != "log" || !strings.Contains(replies[0].Payload.(map[string]any)["message"].(string), path) {
+		t.Errorf("merge must be logged: %v", replies)
+	}
+
+	for _, doc := range []string{"- a\n- b\n", "stream: [\n"} {
+		if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		replies, err = exchange(t, &countingConnector{},
+			Message{Type: "start-stream", Payload: map[string]any{"stream": "s", "connectionCredentials": map[string]any{}}},
+		)
+		if err == nil || len(replies) != 1 || replies[0].Type != "halt" {
+			t.Errorf("invalid file %q must halt the stream: %v %v", doc, err, replies)
+		}
+	}
+
+	// other messages are passed as is
+	message := IncomingMessage{Type: "row", Payload: []byte(`{"row":{}}`)}
+	if merged, path, err := MergeStartStreamFile(message); err != nil || path != "" || string(merged.Payload) != `{"row":{}}` {
+		t.Errorf("row message is changed: %s %s %v", merged.Payload, path, err)
+	}
+}
@@ -0,0 +1,184 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// StreamList calls fn for each state entry with key starting with prefix. Unlike List, entries are decoded one at a
+// time as the host sends them, so states with millions of keys, e.g. audience members, don't have to fit in memory.
+// Buffered writes are applied: fn gets buffered values, buffered entries missing from the state come last.
+// Error of fn stops listing and is returned. The call is retried only until the first entry is received
+func (r *RpcClient) StreamList(prefix []string, fn func(key []string, value any) error) error {
+	body := make(map[string]any, 1)
+	if len(prefix) == 1 {
+		body["prefix"] = prefix[0]
+	} else {
+		body["prefix"] = prefix
+	}
+	pending := r.pendingWithPrefix(prefix)
+	seen := make(map[string]bool, len(pending))
+	_, err := r.call("state.list", body, NewCallId(), func(resp *http.Response) (any, error) {
+		return nil, streamEntries(resp, func(key []string, value any) error {
+			if w, ok := findPending(pending, key); ok {
+				seen[strings.Join(key, "\x00")] = true
+				value = w.value
+			}
+			return fn(key, value)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, w := range pending {
+		if !seen[strings.Join(w.key, "\x00")] {
+			if err = fn(w.key, w.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StreamGet decodes state value of the key incrementally: fn is called with each element of array value, or with
+// each field of object value and its name. Elements are decoded one at a time, so large values, e.g. member lists,
+// don't have to fit in memory. Other values are passed to fn as a whole, missing value calls nothing.
+// Error of fn stops decoding and is returned. The call is retried only until the first element is received
+func (r *RpcClient) StreamGet(key []string, fn func(field string, value any) error) error {
+	if value, ok := r.pendingValue(key); ok {
+		return walkValue(value, fn)
+	}
+	body := make(map[string]any, 1)
+	if len(key) == 1 {
+		body["key"] = key[0]
+	} else {
+		body["key"] = key
+	}
+	_, err := r.call("state.get", body, NewCallId(), func(resp *http.Response) (any, error) {
+		return nil, streamValue(json.NewDecoder(resp.Body), fn)
+	})
+	return err
+}
+
+// streamEntries decodes state.list response: NDJSON of entries or JSON array of entries
+func streamEntries(resp *http.Response, fn func(key []string, value any) error) error {
+	decoder := json.NewDecoder(resp.Body)
+	entry := func(e map[string]any) error {
+		return fn(entryKey(e["key"]), e["value"])
+	}
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		return streamValue(decoder, func(_ string, value any) error {
+			e, ok := value.(map[string]any)
+			if !ok {
+				return fmt.Errorf("unexpected state.list entry: %v", value)
+			}
+			return entry(e)
+		})
+	}
+	for {
+		var e map[string]any
+		if err := decoder.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := entry(e); err != nil {
+			return err
+		}
+	}
+}
+
+// streamValue decodes elements of array or fields of object one at a time
+func streamValue(decoder *json.Decoder, fn func(field string, value any) error) error {
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		if token == nil {
+			return nil
+		}
+		return fn("", token)
+	}
+	for decoder.More() {
+		field := ""
+		if delim == '{' {
+			name, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			field, _ = name.(string)
+		}
+		var value any
+		if err = decoder.Decode(&value); err != nil {
+			return err
+		}
+		if err = fn(field, value); err != nil {
+			return err
+		}
+	}
+	// closing delimiter
+	_, err = decoder.Token()
+	return err
+}
+
+// walkValue calls fn the same way streamValue does for a decoded value. Fields are passed in sorted order
+func walkValue(value any, fn func(field string, value any) error) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []any:
+		for _, item := range v {
+			if err := fn("", item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			if err := fn(field, v[field]); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fn("", value)
+	}
+}
+
+// pendingWithPrefix returns a copy of buffered writes of keys starting with prefix
+func (r *RpcClient) pendingWithPrefix(prefix []string) []pendingWrite {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var writes []pendingWrite
+	for _, w := range r.pending {
+		if hasKeyPrefix(w.key, prefix) {
+			writes = append(writes, w)
+		}
+	}
+	return writes
+}
+
+func findPending(writes []pendingWrite, key []string) (pendingWrite, bool) {
+	for _, w := range writes {
+		if slices.Equal(w.key, key) {
+			return w, true
+		}
+	}
+	return pendingWrite{}, false
+}
@@ -0,0 +1,83 @@
+package sdk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStreamList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(w, `{"key":["audience","%d"],"value":%d}`+"\n", i, i)
+		}
+	}))
+	defer server.Close()
+	client := NewRpcClient(server.URL)
+	var listed []string
+	err := client.StreamList([]string{"audience"}, func(key []string, value any) error {
+		listed = append(listed, fmt.Sprintf("%s=%v", strings.Join(key, "/"), value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"audience/0=0", "audience/1=1", "audience/2=2"}; !reflect.DeepEqual(listed, want) {
+		t.Errorf("listed %v, want %v", listed, want)
+	}
+	stop := errors.New("stop")
+	calls := 0
+	err = client.StreamList([]string{"audience"}, func(key []string, value any) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("error of fn must stop listing: %v, %d calls", err, calls)
+	}
+}
+
+func TestStreamListPendingWrites(t *testing.T) {
+	client, _, down, _ := flakyServer(t)
+	_ = client.Set([]string{"sync", "a"}, 1.0)
+	_ = client.Set([]string{"sync", "b"}, 2.0)
+	down.Store(true)
+	_ = client.Set([]string{"sync", "a"}, 3.0)
+	_ = client.Set([]string{"sync", "c"}, 4.0)
+	down.Store(false)
+	client.BreakerCooldown = 0
+	listed := map[string]any{}
+	err := client.StreamList([]string{"sync"}, func(key []string, value any) error {
+		listed[strings.Join(key, "/")] = value
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]any{"sync/a": 3.0, "sync/b": 2.0, "sync/c": 4.0}; !reflect.DeepEqual(listed, want) {
+		t.Errorf("listed %v, want %v", listed, want)
+	}
+}
+
+func TestStreamGet(t *testing.T) {
+	client, _ := stateServer(t)
+	_ = client.Set([]string{"members"}, []any{"a", "b", "c"})
+	_ = client.Set([]string{"counts"}, map[string]any{"x": 1.0, "y": 2.0})
+	var got []string
+	collect := func(field string, value any) error {
+		got = append(got, fmt.Sprintf("%s:%v", field, value))
+		return nil
+	}
+	for _, key := range []string{"members", "counts", "missing"} {
+		if err := client.StreamGet([]string{key}, collect); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{":a", ":b", ":c", "x:1", "y:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
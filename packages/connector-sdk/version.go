@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Build information of the connector binary, reported in spec. Set with
+//
+//	go build -ldflags "-X github.com/jitsucom/syncmaven/connector-sdk.Version=... -X github.com/jitsucom/syncmaven/connector-sdk.GitSha=... -X github.com/jitsucom/syncmaven/connector-sdk.BuildDate=..."
+var (
+	Version   = "dev"
+	GitSha    = "unknown"
+	BuildDate = "unknown"
+)
+
+// ProtocolVersion is the version of syncmaven protocol implemented by the SDK
+const ProtocolVersion = 1
+
+// CapabilitiesDescriber may be implemented by Connector that supports optional protocol features, e.g. "state".
+// Capabilities are reported in spec and checked against capabilities requested by the host
+type CapabilitiesDescriber interface {
+	Capabilities() []string
+}
+
+// VersionInfo returns build information and capabilities of the connector, 'connector' field of spec
+func VersionInfo(capabilities []string) map[string]any {
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+	return map[string]any{
+		"version":         Version,
+		"gitSha":          GitSha,
+		"buildDate":       BuildDate,
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    capabilities,
+		"compression":     SupportedCompressions,
+	}
+}
+
+// CheckHostRequirements warns if host requests protocol version or capabilities that the connector doesn't support.
+// Host may pass 'protocolVersion' and 'capabilities' fields in describe or start-stream payload
+func CheckHostRequirements(requirements HostRequirements, capabilities []string, session *Session) {
+	if v := requirements.ProtocolVersion; v > ProtocolVersion {
+		session.Warn(fmt.Sprintf("Host requested protocol version %d, but connector supports version %d. Consider upgrading connector", v, ProtocolVersion))
+	}
+	var unsupported []string
+	for _, name := range requirements.Capabilities {
+		if name != "" && !slices.Contains(capabilities, name) {
+			unsupported = append(unsupported, name)
+		}
+	}
+	if len(unsupported) > 0 {
+		session.Warn(fmt.Sprintf("Host requested capabilities not supported by connector version %s", Version), unsupported)
+	}
+}
@@ -0,0 +1,71 @@
+package sdk
+
+import (
+	"sync"
+	"time"
+)
+
+// Watchdog limits run time of a sync, so runaway runs don't hold scheduler slots indefinitely.
+// Connector selects on Exceeded() and wraps up the run: finishes the current batch, commits state
+// and reports a partial result that the next run resumes from.
+type Watchdog struct {
+	maxRuntime time.Duration
+	startedAt  time.Time
+	timer      *time.Timer
+	exceeded   chan struct{}
+	once       sync.Once
+}
+
+// NewWatchdog starts a watchdog. Zero maxRuntime disables it: Exceeded() never fires
+func NewWatchdog(maxRuntime time.Duration) *Watchdog {
+	w := &Watchdog{maxRuntime: maxRuntime, startedAt: time.Now(), exceeded: make(chan struct{})}
+	if maxRuntime > 0 {
+		w.timer = time.AfterFunc(maxRuntime, w.fire)
+	}
+	return w
+}
+
+// MaxRuntimeFromCredentials returns run time limit from 'maxRuntimeMinutes' credentials option. 0 means no limit
+func MaxRuntimeFromCredentials(credentials map[string]any) time.Duration {
+	minutes, _ := credentials["maxRuntimeMinutes"].(float64)
+	if minutes <= 0 {
+		return 0
+	}
+	return time.Duration(minutes * float64(time.Minute))
+}
+
+func (w *Watchdog) fire() {
+	w.once.Do(func() { close(w.exceeded) })
+}
+
+// Exceeded is closed when the run time limit is exceeded
+func (w *Watchdog) Exceeded() <-chan struct{} {
+	return w.exceeded
+}
+
+// IsExceeded checks whether the run time limit is exceeded
+func (w *Watchdog) IsExceeded() bool {
+	select {
+	case <-w.exceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// MaxRuntime returns the run time limit. 0 means no limit
+func (w *Watchdog) MaxRuntime() time.Duration {
+	return w.maxRuntime
+}
+
+// Elapsed returns time since the watchdog was started
+func (w *Watchdog) Elapsed() time.Duration {
+	return time.Since(w.startedAt)
+}
+
+// Stop disables the watchdog, e.g. once the run completed
+func (w *Watchdog) Stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
@@ -0,0 +1,38 @@
+package sdk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	w := NewWatchdog(20 * time.Millisecond)
+	if w.IsExceeded() {
+		t.Fatal("exceeded right after start")
+	}
+	select {
+	case <-w.Exceeded():
+	case <-time.After(time.Second):
+		t.Fatal("watchdog didn't fire")
+	}
+	if !w.IsExceeded() {
+		t.Error("IsExceeded = false after firing")
+	}
+
+	stopped := NewWatchdog(20 * time.Millisecond)
+	stopped.Stop()
+	disabled := NewWatchdog(0)
+	time.Sleep(50 * time.Millisecond)
+	if stopped.IsExceeded() || disabled.IsExceeded() {
+		t.Error("stopped or disabled watchdog fired")
+	}
+}
+
+func TestMaxRuntimeFromCredentials(t *testing.T) {
+	if got := MaxRuntimeFromCredentials(map[string]any{"maxRuntimeMinutes": 1.5}); got != 90*time.Second {
+		t.Errorf("got %s, want 1m30s", got)
+	}
+	if got := MaxRuntimeFromCredentials(map[string]any{}); got != 0 {
+		t.Errorf("got %s, want 0", got)
+	}
+}
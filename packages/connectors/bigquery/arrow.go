@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+)
+
+// readArrow decodes Arrow IPC stream of Storage Read API. onSchema is called with the schema of the stream
+// before the first row, then emit is called for each row. Values are converted to JSON friendly types:
+// timestamps and dates to ISO strings, NUMERIC to decimal strings, so precision is not lost
+func readArrow(r io.Reader, onSchema func(schema *arrow.Schema) error, emit func(row map[string]any) error) error {
+	reader, err := ipc.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("error reading arrow schema: %w", err)
+	}
+	defer reader.Release()
+	if err = onSchema(reader.Schema()); err != nil {
+		return err
+	}
+	for reader.Next() {
+		record := reader.Record()
+		for i := 0; i < int(record.NumRows()); i++ {
+			row := make(map[string]any, record.NumCols())
+			for c := 0; c < int(record.NumCols()); c++ {
+				row[record.ColumnName(c)] = arrowValue(record.Column(c), i)
+			}
+			if err = emit(row); err != nil {
+				return err
+			}
+		}
+	}
+	return reader.Err()
+}
+
+func arrowValue(column arrow.Array, i int) any {
+	if column.IsNull(i) {
+		return nil
+	}
+	switch a := column.(type) {
+	case *array.Int64:
+		return a.Value(i)
+	case *array.Float64:
+		return a.Value(i)
+	case *array.Boolean:
+		return a.Value(i)
+	case *array.String:
+		return a.Value(i)
+	case *array.Timestamp:
+		typ := a.DataType().(*arrow.TimestampType)
+		t := a.Value(i).ToTime(typ.Unit)
+		if typ.TimeZone == "" {
+			// DATETIME: civil time without time zone
+			return t.Format("2006-01-02T15:04:05.999999")
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	case *array.Date32:
+		return a.Value(i).ToTime().Format(time.DateOnly)
+	case *array.Time64:
+		return a.Value(i).ToTime(a.DataType().(*arrow.Time64Type).Unit).Format("15:04:05.999999")
+	case *array.Decimal128:
+		return a.Value(i).ToString(a.DataType().(*arrow.Decimal128Type).Scale)
+	default:
+		// RECORD, REPEATED, BIGNUMERIC, BYTES
+		return column.GetOneForMarshal(i)
+	}
+}
+
+// arrowCursorType returns BigQuery type of the cursor column by its Arrow type
+func arrowCursorType(typ arrow.DataType) (string, error) {
+	switch t := typ.(type) {
+	case *arrow.TimestampType:
+		if t.TimeZone == "" {
+			return "DATETIME", nil
+		}
+		return "TIMESTAMP", nil
+	case *arrow.Date32Type:
+		return "DATE", nil
+	case *arrow.Int64Type:
+		return "INT64", nil
+	case *arrow.Decimal128Type:
+		return "NUMERIC", nil
+	case *arrow.StringType:
+		return "STRING", nil
+	default:
+		return "", fmt.Errorf("column type %s can't be used as cursor", typ)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/apache/arrow/go/v15/arrow"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// Connection is BigQuery client configured from credentials
+type Connection struct {
+	client   *bigquery.Client
+	location string
+	// debug logs details of reading. May be nil
+	debug func(message string, params ...any)
+}
+
+func connect(ctx context.Context, creds map[string]any) (*Connection, error) {
+	projectId, _ := creds["projectId"].(string)
+	if projectId == "" {
+		return nil, fmt.Errorf("projectId is required")
+	}
+	var opts []option.ClientOption
+	if key, _ := creds["serviceAccountKey"].(string); key != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(key)))
+	}
+	client, err := bigquery.NewClient(ctx, projectId, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create BigQuery client: %w", err)
+	}
+	location, _ := creds["location"].(string)
+	client.Location = location
+	if err = client.EnableStorageReadClient(ctx, opts...); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("cannot create BigQuery Storage Read client: %w", err)
+	}
+	return &Connection{client: client, location: location}, nil
+}
+
+func (c *Connection) Close() error {
+	return c.client.Close()
+}
+
+func (c *Connection) query(sql string, cursor *Cursor) *bigquery.Query {
+	q := c.client.Query(sql)
+	q.Location = c.location
+	if cursor != nil {
+		q.Parameters = []bigquery.QueryParameter{{
+			Name: "cursor",
+			Value: &bigquery.QueryParameterValue{
+				Type:  bigquery.StandardSQLDataType{TypeKind: cursor.Type},
+				Value: cursor.Value,
+			},
+		}}
+	}
+	return q
+}
+
+// DryRun validates the query and returns the schema of its result and the number of bytes it would process
+func (c *Connection) DryRun(ctx context.Context, sql string, cursor *Cursor) (bigquery.Schema, int64, error) {
+	q := c.query(sql, cursor)
+	q.DryRun = true
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	stats, ok := job.LastStatus().Statistics.Details.(*bigquery.QueryStatistics)
+	if !ok {
+		return nil, 0, fmt.Errorf("dry run returned no query statistics")
+	}
+	return stats.Schema, job.LastStatus().Statistics.TotalBytesProcessed, nil
+}
+
+// Read runs the query and streams the result via Storage Read API in Arrow format. onCursorType is called with
+// BigQuery type of cursorColumn before the first row. Small results that BigQuery returns with the query response
+// are read with the regular row iterator
+func (c *Connection) Read(ctx context.Context, sql string, cursor *Cursor, cursorColumn string,
+	onCursorType func(typ string) error, emit func(row map[string]any) error) error {
+	it, err := c.query(sql, cursor).Read(ctx)
+	if err != nil {
+		return err
+	}
+	arrowIt, err := it.ArrowIterator()
+	if err == nil {
+		return readArrow(bigquery.NewArrowIteratorReader(arrowIt), func(schema *arrow.Schema) error {
+			if cursorColumn == "" {
+				return nil
+			}
+			fields, ok := schema.FieldsByName(cursorColumn)
+			if !ok || len(fields) == 0 {
+				return fmt.Errorf("query result has no cursor column '%s'", cursorColumn)
+			}
+			typ, err := arrowCursorType(fields[0].Type)
+			if err != nil {
+				return err
+			}
+			return onCursorType(typ)
+		}, emit)
+	}
+	if c.debug != nil {
+		c.debug("Storage Read API is not used for the result: " + err.Error())
+	}
+	first := true
+	for {
+		var row map[string]bigquery.Value
+		err = it.Next(&row)
+		if errors.Is(err, iterator.Done) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if first && cursorColumn != "" {
+			first = false
+			typ, err := fieldCursorType(it.Schema, cursorColumn)
+			if err != nil {
+				return err
+			}
+			if err = onCursorType(typ); err != nil {
+				return err
+			}
+		}
+		converted := make(map[string]any, len(row))
+		for name, value := range row {
+			converted[name] = bigQueryValue(value)
+		}
+		if err = emit(converted); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strings"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// BigQuery is a source that runs a configured query and emits its rows. With cursorColumn the query is incremental:
+// each run selects only rows with cursor greater than the greatest value emitted by the previous run.
+// Results are streamed with BigQuery Storage Read API in Arrow format.
+
+//go:embed credentials.schema.json
+var credentialSchemaString string
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
+
+type bigQuery struct {
+	stream  sdk.StartStream
+	session *sdk.Session
+	config  QueryConfig
+}
+
+func main() {
+	sdk.Serve(&bigQuery{})
+}
+
+func (b *bigQuery) Describe() (map[string]any, error) {
+	return map[string]any{
+		"roles":                 []string{"source"},
+		"description":           "Google BigQuery. Emits rows of a query, incrementally by cursor column",
+		"connectionCredentials": credentialSchema,
+	}, nil
+}
+
+func (b *bigQuery) DescribeStreams() (map[string]any, error) {
+	return b.DescribeStreamsOf(sdk.DescribeStreamsPayload{}, nil)
+}
+
+// DescribeStreamsOf describes the query stream. rowType is the schema of the query result, if credentials are valid
+func (b *bigQuery) DescribeStreamsOf(payload sdk.DescribeStreamsPayload, session *sdk.Session) (map[string]any, error) {
+	stream := map[string]any{"name": "query"}
+	if schema, err := describeQuery(payload.Credentials); err != nil {
+		if session != nil {
+			session.Error("Error describing query result", err.Error())
+		}
+	} else {
+		stream["rowType"] = schema
+	}
+	return map[string]any{
+		"roles":         []string{"source"},
+		"defaultStream": "query",
+		"streams":       []any{stream},
+	}, nil
+}
+
+func (b *bigQuery) StartStream(ctx context.Context, stream sdk.StartStream, session *sdk.Session) error {
+	config, err := queryConfig(stream.ConnectionCredentials)
+	if err != nil {
+		return err
+	}
+	b.stream, b.session, b.config = stream, session, config
+	return nil
+}
+
+// Row fails: BigQuery is a source
+func (b *bigQuery) Row(ctx context.Context, row map[string]any) error {
+	return fmt.Errorf("BigQuery connector is a source, it doesn't accept rows")
+}
+
+// EndStream fails: stream of a source ends when its rows are emitted, see Emit
+func (b *bigQuery) EndStream(ctx context.Context) (any, error) {
+	return nil, fmt.Errorf("BigQuery connector is a source, stream ends when query result is emitted")
+}
+
+func queryConfig(creds map[string]any) (QueryConfig, error) {
+	query, _ := creds["query"].(string)
+	cursorColumn, _ := creds["cursorColumn"].(string)
+	config := QueryConfig{Query: query, CursorColumn: cursorColumn}
+	if initialCursor, _ := creds["initialCursor"].(string); initialCursor != "" {
+		cursorType, _ := creds["cursorType"].(string)
+		if cursorType == "" {
+			cursorType = "TIMESTAMP"
+		}
+		config.InitialCursor = &Cursor{Value: initialCursor, Type: strings.ToUpper(cursorType)}
+	}
+	return config, config.Validate()
+}
+
+// describeQuery returns rowType of the query result without running the query
+func describeQuery(creds map[string]any) (map[string]any, error) {
+	config, err := queryConfig(creds)
+	if err != nil {
+		return nil, err
+	}
+	sql, cursor, err := config.Build(nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	conn, err := connect(ctx, creds)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	schema, _, err := conn.DryRun(ctx, sql, cursor)
+	if err != nil {
+		return nil, err
+	}
+	return rowType(schema), nil
+}
+
+// Emit runs the query and emits its rows followed by 'stream-result'. Checkpoints with the cursor are emitted
+// every checkpointEvery rows. The cursor is saved to the state after the whole result is emitted
+func (b *bigQuery) Emit(ctx context.Context) error {
+	config, creds, session := b.config, b.stream.ConnectionCredentials, b.session
+	checkpointEvery := 10000
+	if v, ok := creds["checkpointEvery"].(float64); ok {
+		checkpointEvery = int(v)
+	}
+	options := sdk.EmitterOptions{}
+	if v, ok := creds["maxInFlight"].(float64); ok {
+		options.MaxInFlight = int(v)
+		options.AckTimeout = time.Minute
+	}
+	if session.State == nil {
+		session.Warn("RPC_URL is not set. Cursor won't be saved, each run is a full sync")
+	}
+	store := newCursorStore(session.State, b.stream.SyncId, config.Hash())
+	state, err := store.load()
+	if err != nil {
+		return err
+	}
+	sql, cursor, err := config.Build(state)
+	if err != nil {
+		return err
+	}
+	conn, err := connect(ctx, creds)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.debug = session.Debug
+	if cursor != nil {
+		session.Info(fmt.Sprintf("Running incremental query. Cursor: %s > %s", config.CursorColumn, cursor.Value))
+	} else {
+		session.Info("Running full query")
+	}
+	session.Debug("Query: " + sql)
+	emitter := session.NewEmitter(b.stream.Stream, options)
+	tracker := &cursorTracker{}
+	rows := 0
+	started := time.Now()
+	err = conn.Read(ctx, sql, cursor, config.CursorColumn, func(typ string) error {
+		if cursor != nil && cursor.Type != typ {
+			return fmt.Errorf("cursor column '%s' is %s, but saved cursor is %s", config.CursorColumn, typ, cursor.Type)
+		}
+		tracker.typ = typ
+		return nil
+	}, func(row map[string]any) error {
+		if config.CursorColumn != "" {
+			if value := row[config.CursorColumn]; value != nil {
+				tracker.observe(fmt.Sprint(value))
+			}
+		}
+		if err := emitter.EmitRow(row); err != nil {
+			return err
+		}
+		rows++
+		if checkpointEvery > 0 && rows%checkpointEvery == 0 && tracker.complete != nil {
+			return emitter.EmitCheckpoint(map[string]any{"cursor": tracker.complete})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading query result: %w", err)
+	}
+	if _, err = emitter.Finish(); err != nil {
+		return err
+	}
+	session.Info(fmt.Sprintf("Emitted %d rows in %s", rows, time.Since(started)))
+	if tracker.last != nil {
+		session.Info(fmt.Sprintf("Next run continues from %s > %s", config.CursorColumn, tracker.last.Value))
+		return store.save(tracker.last)
+	}
+	return nil
+}
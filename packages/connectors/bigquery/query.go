@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Cursor is the state of incremental sync: the greatest value of the cursor column emitted so far.
+// Value is formatted as BigQuery query parameter of Type, e.g. TIMESTAMP '2024-05-01T10:00:00.000001Z'
+type Cursor struct {
+	Value string `json:"value"`
+	Type  string `json:"type"`
+}
+
+// QueryConfig is the configured query and its incremental settings
+type QueryConfig struct {
+	// Query is the SQL of the stream. It may reference @cursor parameter to put the incremental predicate
+	// where BigQuery can use it for partition pruning. Otherwise, the query is wrapped with the predicate
+	Query string
+	// CursorColumn is the column that grows with each change of the source, e.g. updated_at
+	CursorColumn string
+	// InitialCursor is bound to @cursor on the first run
+	InitialCursor *Cursor
+}
+
+var cursorParam = regexp.MustCompile(`@cursor\b`)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (c QueryConfig) Validate() error {
+	if strings.TrimSpace(c.Query) == "" {
+		return fmt.Errorf("query is required")
+	}
+	if c.CursorColumn != "" && !identifier.MatchString(c.CursorColumn) {
+		return fmt.Errorf("cursorColumn must be a column name, got '%s'", c.CursorColumn)
+	}
+	if c.CursorColumn == "" && c.referencesCursor() {
+		return fmt.Errorf("cursorColumn is required when query references @cursor")
+	}
+	if c.InitialCursor != nil && !supportedCursorTypes[c.InitialCursor.Type] {
+		return fmt.Errorf("unsupported cursor type '%s'", c.InitialCursor.Type)
+	}
+	return nil
+}
+
+// Hash identifies the query and the cursor column. Saved cursor is used only with the same hash
+func (c QueryConfig) Hash() string {
+	h := sha256.Sum256([]byte(c.CursorColumn + "\n" + strings.TrimSpace(c.Query)))
+	return hex.EncodeToString(h[:])[:16]
+}
+
+func (c QueryConfig) referencesCursor() bool {
+	return cursorParam.MatchString(c.Query)
+}
+
+// Build returns SQL of the run and the cursor to bind to @cursor parameter. nil cursor means that
+// the query has no parameters. Without cursorColumn the query is a full sync and used as is.
+// With cursorColumn rows are ordered by it, so the last emitted row has the greatest cursor value:
+//
+//	SELECT * FROM (<query>) WHERE `updated_at` > @cursor ORDER BY `updated_at`
+func (c QueryConfig) Build(state *Cursor) (string, *Cursor, error) {
+	query := strings.TrimRight(strings.TrimSpace(c.Query), ";")
+	if c.CursorColumn == "" {
+		return query, nil, nil
+	}
+	cursor := state
+	if cursor == nil {
+		cursor = c.InitialCursor
+	}
+	column := "`" + c.CursorColumn + "`"
+	switch {
+	case c.referencesCursor():
+		if cursor == nil {
+			return "", nil, fmt.Errorf("initialCursor is required on the first run, since query references @cursor")
+		}
+		return fmt.Sprintf("SELECT * FROM (%s) ORDER BY %s", query, column), cursor, nil
+	case cursor == nil:
+		return fmt.Sprintf("SELECT * FROM (%s) ORDER BY %s", query, column), nil, nil
+	default:
+		return fmt.Sprintf("SELECT * FROM (%s) WHERE %s > @cursor ORDER BY %s", query, column, column), cursor, nil
+	}
+}
+
+// supportedCursorTypes are BigQuery types that may be used as cursor column
+var supportedCursorTypes = map[string]bool{
+	"TIMESTAMP": true,
+	"DATETIME":  true,
+	"DATE":      true,
+	"INT64":     true,
+	"NUMERIC":   true,
+	"STRING":    true,
+}
+
+// cursorTracker follows the cursor column of emitted rows. Rows are ordered by cursor, but rows with the same
+// value may be split between checkpoints. So checkpoint uses the last value whose rows are all emitted,
+// and the final state after the stream is complete uses the last value
+type cursorTracker struct {
+	typ      string
+	last     *Cursor
+	complete *Cursor
+}
+
+func (t *cursorTracker) observe(value string) {
+	if t.last != nil && t.last.Value == value {
+		return
+	}
+	t.complete = t.last
+	t.last = &Cursor{Value: value, Type: t.typ}
+}
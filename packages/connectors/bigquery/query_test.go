@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestBuildQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  QueryConfig
+		state   *Cursor
+		sql     string
+		cursor  string
+		wantErr bool
+	}{
+		{name: "full sync", config: QueryConfig{Query: "SELECT * FROM ds.t;"}, sql: "SELECT * FROM ds.t"},
+		{name: "first run", config: QueryConfig{Query: "SELECT * FROM ds.t", CursorColumn: "updated_at"},
+			sql: "SELECT * FROM (SELECT * FROM ds.t) ORDER BY `updated_at`"},
+		{name: "incremental", config: QueryConfig{Query: "SELECT * FROM ds.t", CursorColumn: "updated_at"},
+			state:  &Cursor{Value: "2024-05-01T00:00:00Z", Type: "TIMESTAMP"},
+			sql:    "SELECT * FROM (SELECT * FROM ds.t) WHERE `updated_at` > @cursor ORDER BY `updated_at`",
+			cursor: "2024-05-01T00:00:00Z"},
+		{name: "query with parameter", config: QueryConfig{Query: "SELECT * FROM ds.t WHERE day > @cursor", CursorColumn: "day",
+			InitialCursor: &Cursor{Value: "2024-01-01", Type: "DATE"}},
+			sql: "SELECT * FROM (SELECT * FROM ds.t WHERE day > @cursor) ORDER BY `day`", cursor: "2024-01-01"},
+		{name: "query with parameter without initial cursor", config: QueryConfig{Query: "SELECT * FROM ds.t WHERE day > @cursor", CursorColumn: "day"},
+			wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, cursor, err := tt.config.Build(tt.state)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if sql != tt.sql {
+				t.Errorf("sql = %s, want %s", sql, tt.sql)
+			}
+			if cursor == nil && tt.cursor != "" || cursor != nil && cursor.Value != tt.cursor {
+				t.Errorf("cursor = %+v, want %s", cursor, tt.cursor)
+			}
+		})
+	}
+	if err := (QueryConfig{Query: "SELECT 1", CursorColumn: "a; DROP"}).Validate(); err == nil {
+		t.Error("cursorColumn must be validated")
+	}
+}
+
+func TestCursorTracker(t *testing.T) {
+	tracker := &cursorTracker{typ: "INT64"}
+	for _, v := range []string{"1", "1", "2", "2"} {
+		tracker.observe(v)
+	}
+	if tracker.complete.Value != "1" || tracker.last.Value != "2" {
+		t.Errorf("complete = %+v last = %+v", tracker.complete, tracker.last)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// rowType converts schema of query result to JSON schema published as rowType of the stream
+func rowType(schema bigquery.Schema) map[string]any {
+	properties := make(map[string]any, len(schema))
+	var required []string
+	for _, field := range schema {
+		properties[field.Name] = fieldType(field)
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	result := map[string]any{
+		"$schema":    "http://json-schema.org/draft-07/schema#",
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		result["required"] = required
+	}
+	return result
+}
+
+func fieldType(field *bigquery.FieldSchema) map[string]any {
+	var t map[string]any
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		t = map[string]any{"type": "integer"}
+	case bigquery.FloatFieldType:
+		t = map[string]any{"type": "number"}
+	case bigquery.BooleanFieldType:
+		t = map[string]any{"type": "boolean"}
+	case bigquery.TimestampFieldType:
+		t = map[string]any{"type": "string", "format": "date-time"}
+	case bigquery.DateFieldType:
+		t = map[string]any{"type": "string", "format": "date"}
+	case bigquery.RecordFieldType:
+		t = rowType(field.Schema)
+		delete(t, "$schema")
+	case bigquery.JSONFieldType:
+		t = map[string]any{}
+	default:
+		// STRING, BYTES, NUMERIC, BIGNUMERIC, DATETIME, TIME, GEOGRAPHY are strings
+		t = map[string]any{"type": "string"}
+	}
+	if field.Repeated {
+		return map[string]any{"type": "array", "items": t}
+	}
+	if typ, ok := t["type"].(string); ok && !field.Required {
+		t["type"] = []string{typ, "null"}
+	}
+	return t
+}
+
+// fieldCursorType returns BigQuery type of the cursor column by the schema of query result
+func fieldCursorType(schema bigquery.Schema, column string) (string, error) {
+	for _, field := range schema {
+		if field.Name != column {
+			continue
+		}
+		switch field.Type {
+		case bigquery.TimestampFieldType:
+			return "TIMESTAMP", nil
+		case bigquery.DateTimeFieldType:
+			return "DATETIME", nil
+		case bigquery.DateFieldType:
+			return "DATE", nil
+		case bigquery.IntegerFieldType:
+			return "INT64", nil
+		case bigquery.NumericFieldType:
+			return "NUMERIC", nil
+		case bigquery.StringFieldType:
+			return "STRING", nil
+		default:
+			return "", fmt.Errorf("column type %s can't be used as cursor", field.Type)
+		}
+	}
+	return "", fmt.Errorf("query result has no cursor column '%s'", column)
+}
+
+// bigQueryValue converts value of the row iterator to the same representation as Arrow values, see arrowValue
+func bigQueryValue(value bigquery.Value) any {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *big.Rat:
+		s := v.FloatString(38)
+		if strings.Contains(s, ".") {
+			s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+		}
+		return s
+	case []bigquery.Value:
+		arr := make([]any, len(v))
+		for i, item := range v {
+			arr[i] = bigQueryValue(item)
+		}
+		return arr
+	case map[string]bigquery.Value:
+		m := make(map[string]any, len(v))
+		for name, item := range v {
+			m[name] = bigQueryValue(item)
+		}
+		return m
+	case fmt.Stringer:
+		// civil.Date, civil.Time, civil.DateTime
+		return v.String()
+	default:
+		return v
+	}
+}
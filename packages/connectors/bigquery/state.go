@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// cursorStore keeps the cursor of incremental sync in the state:
+//
+//	key: ["syncId=<sync id>", "type=bigquery.cursor"]
+//	value: {"query":"<hash of query>","cursor":{"value":"2024-05-01T10:00:00Z","type":"TIMESTAMP"}}
+//
+// Cursor saved for a different query or cursor column is ignored, so changing the query starts a full sync.
+type cursorStore struct {
+	state     *sdk.RpcClient
+	key       []string
+	queryHash string
+}
+
+// newCursorStore returns store of the cursor in state. state may be nil if RPC_URL is not set, then nothing is saved
+func newCursorStore(state *sdk.RpcClient, syncId string, queryHash string) *cursorStore {
+	return &cursorStore{
+		state:     state,
+		key:       []string{"syncId=" + syncId, "type=bigquery.cursor"},
+		queryHash: queryHash,
+	}
+}
+
+type savedCursor struct {
+	Query  string  `json:"query"`
+	Cursor *Cursor `json:"cursor"`
+}
+
+// load returns saved cursor. nil if the stream wasn't synced yet
+func (s *cursorStore) load() (*Cursor, error) {
+	if s.state == nil {
+		return nil, nil
+	}
+	raw, err := s.state.Get(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load cursor: %v", err)
+	}
+	var saved savedCursor
+	if b, err := json.Marshal(raw); err != nil || json.Unmarshal(b, &saved) != nil {
+		return nil, fmt.Errorf("cannot load cursor: invalid state value %v", raw)
+	}
+	if saved.Query != s.queryHash || saved.Cursor == nil || saved.Cursor.Value == "" {
+		return nil, nil
+	}
+	return saved.Cursor, nil
+}
+
+func (s *cursorStore) save(cursor *Cursor) error {
+	if s.state == nil || cursor == nil {
+		return nil
+	}
+	if err := s.state.Set(s.key, savedCursor{Query: s.queryHash, Cursor: cursor}); err != nil {
+		return fmt.Errorf("cannot save cursor: %v", err)
+	}
+	return nil
+}
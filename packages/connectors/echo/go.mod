@@ -0,0 +1,12 @@
+module github.com/jitsucom/syncmaven/connection-echo
+
+go 1.22
+
+require github.com/jitsucom/syncmaven/connector-sdk v0.0.0
+
+require (
+	github.com/klauspost/compress v1.16.7 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/jitsucom/syncmaven/connector-sdk => ../../connector-sdk
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Echo is a destination that accepts any stream and sends rows nowhere. Rows are counted, optionally logged
+// and written to a local file. It's a minimal reference implementation of sdk.Connector and a tool
+// for debugging host-side model issues without hitting real destinations.
+
+//go:embed credentials.schema.json
+var credentialSchemaString string
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
+
+type Status struct {
+	Received int `json:"received"`
+	Success  int `json:"success"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+type echo struct {
+	session    *sdk.Session
+	status     Status
+	stream     string
+	logRows    bool
+	output     *bufio.Writer
+	outputFile *os.File
+	startTime  time.Time
+}
+
+func main() {
+	sdk.Serve(&echo{})
+}
+
+func (e *echo) Describe() (map[string]any, error) {
+	return map[string]any{
+		"roles":                 []string{"destination"},
+		"description":           "Echo Connector. Accepts rows of any stream and sends them nowhere",
+		"connectionCredentials": credentialSchema,
+	}, nil
+}
+
+func (e *echo) DescribeStreams() (map[string]any, error) {
+	return map[string]any{
+		"roles":         []string{"destination"},
+		"defaultStream": "default",
+		"streams":       []any{map[string]any{"name": "default", "rowType": map[string]any{"type": "object"}}},
+	}, nil
+}
+
+func (e *echo) StartStream(ctx context.Context, stream sdk.StartStream, session *sdk.Session) error {
+	e.session = session
+	e.stream = stream.Stream
+	creds := stream.ConnectionCredentials
+	e.logRows, _ = creds["logRows"].(bool)
+	if path, _ := creds["outputFile"].(string); path != "" {
+		outputFile, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("cannot open output file %s: %w", path, err)
+		}
+		e.outputFile = outputFile
+		e.output = bufio.NewWriter(outputFile)
+	}
+	e.startTime = time.Now()
+	session.Info(fmt.Sprintf("Stream '%s' started", e.stream))
+	return nil
+}
+
+func (e *echo) Row(ctx context.Context, row map[string]any) error {
+	e.status.Received++
+	if e.logRows {
+		e.session.Debug(fmt.Sprintf("Row #%d", e.status.Received), row)
+	}
+	if e.output != nil {
+		b, err := json.Marshal(row)
+		if err == nil {
+			_, err = e.output.Write(append(b, '\n'))
+		}
+		if err != nil {
+			e.status.Failed++
+			e.session.Error("Error writing row to output file", err.Error())
+			return nil
+		}
+	}
+	e.status.Success++
+	return nil
+}
+
+func (e *echo) EndStream(ctx context.Context) (any, error) {
+	if e.output != nil {
+		if err := e.output.Flush(); err != nil {
+			e.session.Error("Error flushing output file", err.Error())
+		}
+		_ = e.outputFile.Close()
+	}
+	e.session.Info(fmt.Sprintf("Stream '%s' finished. %d rows received in %s", e.stream, e.status.Received, time.Since(e.startTime)))
+	return e.status, nil
+}
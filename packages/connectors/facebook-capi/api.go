@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// maxBatchSize is the maximum number of events in one request to Conversions API
+const maxBatchSize = 1000
+
+var graphApiUrl = "https://graph.facebook.com"
+
+// Error codes of Graph API that mean the request may succeed if retried
+// https://developers.facebook.com/docs/graph-api/guides/error-handling
+var transientErrorCodes = map[int]bool{1: true, 2: true, 4: true, 17: true, 32: true, 341: true, 613: true}
+
+// apiError is an error response of Graph API
+type apiError struct {
+	StatusCode   int    `json:"-"`
+	Message      string `json:"message"`
+	Type         string `json:"type"`
+	Code         int    `json:"code"`
+	ErrorSubcode int    `json:"error_subcode"`
+	IsTransient  bool   `json:"is_transient"`
+	UserMessage  string `json:"error_user_msg"`
+	FbTraceId    string `json:"fbtrace_id"`
+}
+
+func (e *apiError) Error() string {
+	message := e.Message
+	if e.UserMessage != "" {
+		message += ": " + e.UserMessage
+	}
+	return fmt.Sprintf("Conversions API error %d (code %d, subcode %d, fbtrace_id %s): %s", e.StatusCode, e.Code, e.ErrorSubcode, e.FbTraceId, message)
+}
+
+// Retryable returns true if the request failed because of rate limits or temporary problems of Meta
+func (e *apiError) Retryable() bool {
+	return e.IsTransient || transientErrorCodes[e.Code] || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// RateLimited returns true if the request was throttled
+func (e *apiError) RateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.Code == 4 || e.Code == 17 || e.Code == 32 || e.Code == 613
+}
+
+// eventsResponse is a successful response of events endpoint
+type eventsResponse struct {
+	EventsReceived int      `json:"events_received"`
+	Messages       []string `json:"messages"`
+	FbTraceId      string   `json:"fbtrace_id"`
+}
+
+// capiClient sends events to the pixel
+type capiClient struct {
+	httpClient    *http.Client
+	apiVersion    string
+	pixelId       string
+	accessToken   string
+	testEventCode string
+}
+
+// send sends the batch of events. Conversions API accepts or rejects the batch as a whole
+func (c *capiClient) send(ctx context.Context, events []*serverEvent) (*sdk.BatchResponse, error) {
+	body := map[string]any{
+		"data":         events,
+		"access_token": c.accessToken,
+	}
+	if c.testEventCode != "" {
+		body["test_event_code"] = c.testEventCode
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/%s/%s/events", graphApiUrl, c.apiVersion, c.pixelId)
+	response, err := c.post(ctx, url, b)
+	if err != nil {
+		return nil, err
+	}
+	return &sdk.BatchResponse{Accepted: response.EventsReceived, TraceId: response.FbTraceId, Messages: response.Messages}, nil
+}
+
+func (c *capiClient) post(ctx context.Context, url string, body []byte) (*eventsResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var errorResponse struct {
+			Error *apiError `json:"error"`
+		}
+		if json.Unmarshal(b, &errorResponse) != nil || errorResponse.Error == nil {
+			errorResponse.Error = &apiError{Message: string(b)}
+		}
+		errorResponse.Error.StatusCode = res.StatusCode
+		return nil, errorResponse.Error
+	}
+	var response eventsResponse
+	if err = json.Unmarshal(b, &response); err != nil {
+		return nil, fmt.Errorf("invalid response of Conversions API: %w", err)
+	}
+	return &response, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Rows are converted to server events of Conversions API:
+// https://developers.facebook.com/docs/marketing-api/conversions-api/parameters/server-event
+//
+// Customer information is normalized and hashed as required by Meta, so the same person gets the same hash
+// regardless of formatting in the source, e.g. " John.Doe@Example.com" and "john.doe@example.com"
+
+// hashedColumns maps columns of customer information to user_data parameters that must be hashed
+var hashedColumns = map[string]string{
+	"email":       "em",
+	"phone":       "ph",
+	"first_name":  "fn",
+	"last_name":   "ln",
+	"city":        "ct",
+	"state":       "st",
+	"zip":         "zp",
+	"country":     "country",
+	"external_id": "external_id",
+}
+
+// plainColumns maps columns to user_data parameters that are sent as is
+var plainColumns = map[string]string{
+	"client_ip_address": "client_ip_address",
+	"client_user_agent": "client_user_agent",
+	"fbc":               "fbc",
+	"fbp":               "fbp",
+}
+
+// eventColumns are columns mapped to parameters of the event itself. Other columns go to custom_data
+var eventColumns = map[string]bool{
+	"event_name":       true,
+	"event_time":       true,
+	"event_id":         true,
+	"action_source":    true,
+	"event_source_url": true,
+}
+
+// serverEvent is an event of Conversions API
+type serverEvent struct {
+	EventName      string            `json:"event_name"`
+	EventTime      int64             `json:"event_time"`
+	EventId        string            `json:"event_id"`
+	ActionSource   string            `json:"action_source"`
+	EventSourceUrl string            `json:"event_source_url,omitempty"`
+	UserData       map[string]string `json:"user_data"`
+	CustomData     map[string]any    `json:"custom_data,omitempty"`
+}
+
+// Key returns event_name and event_id, Meta deduplicates events by them
+func (e *serverEvent) Key() (string, string) {
+	return e.EventName, e.EventId
+}
+
+func (e *serverEvent) SetId(id string) {
+	e.EventId = id
+}
+
+// newServerEvent converts the row to event. Returns error if the row is invalid, such events would make
+// Conversions API reject the whole batch
+func newServerEvent(row map[string]any, defaultActionSource string, now time.Time) (*serverEvent, error) {
+	event := &serverEvent{UserData: map[string]string{}, CustomData: map[string]any{}}
+	event.EventName = sdk.StringValue(row["event_name"])
+	if event.EventName == "" {
+		return nil, fmt.Errorf("event_name is required")
+	}
+	eventTime, err := sdk.ParseEventTime(row["event_time"], now)
+	if err != nil {
+		return nil, err
+	}
+	event.EventTime = eventTime.Unix()
+	event.ActionSource = sdk.StringValue(row["action_source"])
+	if event.ActionSource == "" {
+		event.ActionSource = defaultActionSource
+	}
+	event.EventSourceUrl = sdk.StringValue(row["event_source_url"])
+	for column, value := range row {
+		if param, ok := hashedColumns[column]; ok {
+			if hashed := hashValue(param, sdk.StringValue(value)); hashed != "" {
+				event.UserData[param] = hashed
+			}
+		} else if param, ok := plainColumns[column]; ok {
+			if s := sdk.StringValue(value); s != "" {
+				event.UserData[param] = s
+			}
+		} else if !eventColumns[column] && value != nil {
+			event.CustomData[column] = value
+		}
+	}
+	if len(event.UserData) == 0 {
+		return nil, fmt.Errorf("row has no customer information, at least one of email, phone, external_id, fbc, fbp or client_ip_address is required")
+	}
+	if event.ActionSource == "website" && event.UserData["client_user_agent"] == "" {
+		return nil, fmt.Errorf("client_user_agent is required for events with website action source")
+	}
+	event.EventId = sdk.StringValue(row["event_id"])
+	if event.EventId == "" {
+		event.EventId = sdk.DeriveEventId(event.EventName, event.EventTime, event.UserData)
+	}
+	return event, nil
+}
+
+// hashValue normalizes value of user_data parameter and returns its SHA-256 hex. Values that are hashed already
+// are returned as is. Empty result means the value is empty after normalization
+func hashValue(param string, value string) string {
+	return sdk.HashSha256(value, func(value string) string { return normalize(param, value) })
+}
+
+// normalize applies normalization rules of Meta to value of user_data parameter
+func normalize(param string, value string) string {
+	value = strings.ToLower(value)
+	switch param {
+	case "ph":
+		// digits with country code, without symbols and leading zeros
+		return strings.TrimLeft(keepOnly(value, unicode.IsDigit), "0")
+	case "ct", "st":
+		return keepOnly(value, unicode.IsLetter)
+	case "zp":
+		return keepOnly(value, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) })
+	case "country":
+		return keepOnly(value, unicode.IsLetter)
+	}
+	return value
+}
+
+func keepOnly(s string, keep func(r rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if keep(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
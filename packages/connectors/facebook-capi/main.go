@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Facebook Conversions API destination. Rows of conversions stream are sent as server events to a pixel (dataset)
+// in batches of up to 1000 events, see sdk.BatchedDestination. Customer information is hashed with SHA-256 before
+// sending, see event.go. Events are deduplicated by event_id: duplicates within a run are skipped, and Meta deduplicates events of
+// different runs and events sent by the browser pixel with the same event_name and event_id. Meta remembers events
+// for 48 hours only, with dedupTtlDays events delivered by previous runs are remembered in state and skipped
+
+//go:embed credentials.schema.json
+var credentialSchemaString string
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
+
+//go:embed row.schema.json
+var rowSchemaString string
+var rowSchema = sdk.UnmarshalSchema(rowSchemaString)
+
+const streamConversions = "conversions"
+
+type capi struct {
+	sdk.BatchedDestination[*serverEvent]
+	client *capiClient
+}
+
+func main() {
+	sdk.Serve(&capi{})
+}
+
+func (c *capi) Describe() (map[string]any, error) {
+	return map[string]any{
+		"roles":                 []string{"destination"},
+		"description":           "Facebook Conversions API Connector. Sends conversions to Meta pixel as server events",
+		"connectionCredentials": credentialSchema,
+	}, nil
+}
+
+func (c *capi) DescribeStreams() (map[string]any, error) {
+	return map[string]any{
+		"roles":         []string{"destination"},
+		"defaultStream": streamConversions,
+		"streams":       []any{map[string]any{"name": streamConversions, "rowType": rowSchema}},
+	}, nil
+}
+
+func (c *capi) StartStream(ctx context.Context, stream sdk.StartStream, session *sdk.Session) error {
+	if stream.Stream != streamConversions {
+		return fmt.Errorf("unknown stream: %s", stream.Stream)
+	}
+	creds := stream.ConnectionCredentials
+	client := &capiClient{apiVersion: "v19.0"}
+	client.pixelId, _ = creds["pixelId"].(string)
+	client.accessToken, _ = creds["accessToken"].(string)
+	if client.pixelId == "" || client.accessToken == "" {
+		return fmt.Errorf("pixelId and accessToken are required")
+	}
+	if apiVersion, _ := creds["apiVersion"].(string); apiVersion != "" {
+		client.apiVersion = apiVersion
+	}
+	client.testEventCode, _ = creds["testEventCode"].(string)
+	actionSource, _ := creds["actionSource"].(string)
+	if actionSource == "" {
+		actionSource = "website"
+	}
+	httpClient, err := c.Start(stream.Stream, session, creds, sdk.BatchOptions{
+		Api:          "Conversions API",
+		MaxBatchSize: maxBatchSize,
+		EventColumn:  "event_name",
+		DedupPrefix:  []string{"type=facebook-capi.dedup", "pixel=" + client.pixelId},
+	})
+	if err != nil {
+		return err
+	}
+	client.httpClient = httpClient
+	c.client = client
+	c.NewEvent = func(row map[string]any) (*serverEvent, error) {
+		return newServerEvent(row, actionSource, time.Now())
+	}
+	c.Send = client.send
+	details := fmt.Sprintf("Pixel: %s API version: %s", client.pixelId, client.apiVersion)
+	if client.testEventCode != "" {
+		details += fmt.Sprintf(" Test event code: %s", client.testEventCode)
+	}
+	c.Started(details)
+	return nil
+}
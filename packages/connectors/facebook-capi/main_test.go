@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+func TestHashValue(t *testing.T) {
+	tests := []struct {
+		param, value, normalized string
+	}{
+		{"em", " John.Doe@Example.com ", "john.doe@example.com"},
+		{"ph", "+1 (650) 555-1212", "16505551212"},
+		{"ph", "00 44 20 7946 0958", "442079460958"},
+		{"ct", "San Francisco", "sanfrancisco"},
+		{"zp", "SW1A 1AA", "sw1a1aa"},
+		{"country", "US", "us"},
+	}
+	for _, test := range tests {
+		if got, want := hashValue(test.param, test.value), hashValue(test.param, test.normalized); got != want {
+			t.Errorf("hashValue(%s, %q) = %s, want hash of %q", test.param, test.value, got, test.normalized)
+		}
+	}
+	hashed := hashValue("em", "john.doe@example.com")
+	if got := hashValue("em", hashed); got != hashed {
+		t.Errorf("hashed value is hashed again: %s", got)
+	}
+	if got := hashValue("ph", "n/a"); got != "" {
+		t.Errorf("empty value after normalization must not be hashed: %s", got)
+	}
+}
+
+func TestNewServerEvent(t *testing.T) {
+	row := map[string]any{
+		"event_name":        "Purchase",
+		"event_time":        json.Number("1714557600"),
+		"email":             "John.Doe@Example.com",
+		"client_user_agent": "Mozilla/5.0",
+		"value":             json.Number("42.5"),
+		"currency":          "USD",
+	}
+	event, err := newServerEvent(row, "website", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventTime != 1714557600 || event.UserData["em"] != hashValue("em", "john.doe@example.com") || event.UserData["client_user_agent"] != "Mozilla/5.0" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.CustomData["value"] != json.Number("42.5") || event.CustomData["currency"] != "USD" || event.CustomData["email"] != nil {
+		t.Errorf("unexpected custom_data: %v", event.CustomData)
+	}
+	again, _ := newServerEvent(row, "website", time.Now().Add(time.Hour))
+	if event.EventId == "" || again.EventId != event.EventId {
+		t.Errorf("derived event_id must be stable: %s, %s", event.EventId, again.EventId)
+	}
+	delete(row, "client_user_agent")
+	if _, err = newServerEvent(row, "website", time.Now()); err == nil {
+		t.Errorf("website event without client_user_agent must be rejected")
+	}
+	if _, err = newServerEvent(row, "system_generated", time.Now()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConversionsStream(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]serverEvent
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/v19.0/123/events" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		var body struct {
+			Data        []serverEvent `json:"data"`
+			AccessToken string        `json:"access_token"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.AccessToken != "token" {
+			t.Errorf("unexpected access_token: %s", body.AccessToken)
+		}
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"error":{"message":"rate limited","code":17}}`))
+			return
+		}
+		if body.Data[0].EventName == "Invalid" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"Invalid parameter","code":100,"fbtrace_id":"abc"}}`))
+			return
+		}
+		batches = append(batches, body.Data)
+		_ = json.NewEncoder(w).Encode(map[string]any{"events_received": len(body.Data), "fbtrace_id": "abc"})
+	}))
+	defer server.Close()
+	graphApiUrl = server.URL
+
+	replier := &sdk.RecordingReplier{}
+	c := &capi{}
+	ctx := context.Background()
+	err := c.StartStream(ctx, sdk.StartStream{
+		Stream:                streamConversions,
+		ConnectionCredentials: map[string]any{"pixelId": "123", "accessToken": "token", "batchSize": 2.0, "actionSource": "system_generated"},
+	}, &sdk.Session{Replier: replier})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RetryDelay = time.Millisecond
+	rows := []map[string]any{
+		{"event_name": "Lead", "event_id": "1", "email": "a@example.com"},
+		{"event_name": "Lead", "event_id": "2", "email": "b@example.com"},
+		{"event_name": "Lead", "event_id": "1", "email": "a@example.com"},
+		{"event_name": "Lead", "event_id": "3"},
+		{"event_name": "Invalid", "event_id": "4", "email": "d@example.com"},
+		{"event_name": "Lead", "event_id": "5", "phone": "+1 650 555 1212"},
+	}
+	for _, row := range rows {
+		if err = c.Row(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := c.EndStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := result.(sdk.BatchStatus)
+	if status.Received != 6 || status.Success != 2 || status.Duplicates != 1 || status.Failed != 3 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0].EventId != "1" || batches[0][1].EventId != "2" {
+		t.Errorf("unexpected batches: %+v", batches)
+	}
+	rowErrors := replier.Replies("row-error")
+	if len(rowErrors) != 3 {
+		t.Fatalf("expected 3 row errors, got %v", rowErrors)
+	}
+	if e := rowErrors[0].(map[string]any); e["class"] != sdk.RowErrorValidation || e["insertId"] != "3" {
+		t.Errorf("unexpected row error: %v", e)
+	}
+	if e := rowErrors[1].(map[string]any); e["class"] != sdk.RowErrorApi || e["retryable"] != false || e["insertId"] != "4" {
+		t.Errorf("unexpected row error: %v", e)
+	}
+}
+
+func TestExplodeArrays(t *testing.T) {
+	var events []serverEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Data []serverEvent `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		events = append(events, body.Data...)
+		_ = json.NewEncoder(w).Encode(map[string]any{"events_received": len(body.Data)})
+	}))
+	defer server.Close()
+	graphApiUrl = server.URL
+	c := &capi{}
+	ctx := context.Background()
+	err := c.StartStream(ctx, sdk.StartStream{
+		Stream:                streamConversions,
+		ConnectionCredentials: map[string]any{"pixelId": "123", "accessToken": "token", "actionSource": "system_generated", "arrayHandling": "explode", "arrayColumns": []any{"content_ids"}},
+	}, &sdk.Session{Replier: &sdk.RecordingReplier{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	row := map[string]any{"event_name": "Purchase", "event_id": "1", "email": "a@example.com", "content_ids": []any{"a", "b"}, "tags": []any{"x"}}
+	if err = c.Row(ctx, row); err != nil {
+		t.Fatal(err)
+	}
+	result, err := c.EndStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := result.(sdk.BatchStatus); status.Received != 1 || status.Success != 2 || status.Exploded != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if len(events) != 2 || events[0].EventId != "1.0" || events[1].EventId != "1.1" || events[1].CustomData["content_ids"] != "b" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+	if tags, _ := events[0].CustomData["tags"].([]any); len(tags) != 1 {
+		t.Errorf("arrays of other columns must be sent as is: %v", events[0].CustomData)
+	}
+}
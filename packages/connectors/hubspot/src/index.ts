@@ -1,5 +1,7 @@
 import {
   BaseRateLimitedOutputStream,
+  CircuitBreakers,
+  CircuitOpenError,
   DestinationProvider,
   DestinationStream,
   OutputStreamConfiguration,
@@ -88,6 +90,8 @@ abstract class BaseHubspotStream<RowT extends Record<string, any>> extends BaseR
   protected model: Model;
   protected knownCustomAttributes: Record<string, any> = {};
   protected customAttributesPolicy: CustomAttributesPolicy;
+  //companies of contacts are optional. Once their endpoints fail repeatedly, contacts are saved without companies
+  readonly breakers: CircuitBreakers;
 
   protected constructor(config: OutputStreamConfiguration<HubspotCredentials>, ctx: ExecutionContext, model: Model) {
     super(config, ctx, 1000 / 60);
@@ -99,6 +103,13 @@ abstract class BaseHubspotStream<RowT extends Record<string, any>> extends BaseR
       );
     }
     this.client = createClient(config.credentials);
+    this.breakers = new CircuitBreakers({
+      threshold: this.config.options?.circuitBreakerThreshold,
+      cooldownMs:
+        this.config.options?.circuitBreakerCooldownSeconds !== undefined
+          ? this.config.options.circuitBreakerCooldownSeconds * 1000
+          : undefined,
+    });
   }
 
   public async init(ctx: ExecutionContext) {
@@ -265,7 +276,9 @@ class ContactsOutputStream extends BaseHubspotStream<ContactRowType> {
         let companyHubspotId = this.companiesMap[id.toString()];
         if (!companyHubspotId) {
           try {
-            const hid = await this.searchByField("company", "external_id", id.toString());
+            const hid = await this.breakers
+              .get("companies.search")
+              .call(() => this.searchByField("company", "external_id", id.toString()));
             if (hid) {
               companyHubspotIds.push(hid);
               this.companiesMap[id.toString()] = hid;
@@ -274,6 +287,10 @@ class ContactsOutputStream extends BaseHubspotStream<ContactRowType> {
               console.warn(`Not found company with external_id=${id}`);
             }
           } catch (e: any) {
+            if (e instanceof CircuitOpenError) {
+              // the contact is saved without this company, skipped calls are reported in stream-result
+              continue;
+            }
             console.error(`Failed to search company by external_id=${id}: ${e.message}`);
             throw toAPIError(e);
           }
@@ -298,9 +315,14 @@ class ContactsOutputStream extends BaseHubspotStream<ContactRowType> {
     }
     for (const companyHubspotId of companyHubspotIds) {
       try {
-        await this.associateContactWithCompany(contactHubspotId, companyHubspotId);
+        await this.breakers
+          .get("associations")
+          .call(() => this.associateContactWithCompany(contactHubspotId!, companyHubspotId));
         console.log(`Contact linked to company: ${contactHubspotId} -> ${companyHubspotId}`);
       } catch (e) {
+        if (e instanceof CircuitOpenError) {
+          continue;
+        }
         throw toAPIError(e, { request: { id: companyHubspotId } });
       }
     }
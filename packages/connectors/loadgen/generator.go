@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+)
+
+const letters = "abcdefghijklmnopqrstuvwxyz"
+
+// Generator produces random values matching a subset of JSON schema
+type Generator struct {
+	rnd             *rand.Rand
+	nullProbability float64
+	now             time.Time
+}
+
+func NewGenerator(seed int64, nullProbability float64) *Generator {
+	return &Generator{rnd: rand.New(rand.NewSource(seed)), nullProbability: nullProbability, now: time.Now().UTC()}
+}
+
+func (g *Generator) Generate(schema map[string]any) any {
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		return enum[g.rnd.Intn(len(enum))]
+	}
+	types := schemaTypes(schema)
+	if slices.Contains(types, "null") && g.rnd.Float64() < g.nullProbability {
+		return nil
+	}
+	for _, t := range types {
+		switch t {
+		case "object":
+			return g.object(schema)
+		case "array":
+			return g.array(schema)
+		case "string":
+			return g.string(schema)
+		case "integer":
+			min, max := bounds(schema, 0, 1000000)
+			return int64(min) + g.rnd.Int63n(int64(max-min)+1)
+		case "number":
+			min, max := bounds(schema, 0, 1000)
+			return float64(int64((min+g.rnd.Float64()*(max-min))*100)) / 100
+		case "boolean":
+			return g.rnd.Intn(2) == 1
+		}
+	}
+	return nil
+}
+
+func (g *Generator) object(schema map[string]any) map[string]any {
+	properties, _ := schema["properties"].(map[string]any)
+	obj := make(map[string]any, len(properties))
+	// iterate in stable order so the same seed produces the same rows
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		ps, _ := properties[name].(map[string]any)
+		obj[name] = g.Generate(ps)
+	}
+	return obj
+}
+
+func (g *Generator) array(schema map[string]any) []any {
+	items, _ := schema["items"].(map[string]any)
+	arr := make([]any, g.rnd.Intn(4))
+	for i := range arr {
+		arr[i] = g.Generate(items)
+	}
+	return arr
+}
+
+func (g *Generator) string(schema map[string]any) string {
+	format, _ := schema["format"].(string)
+	switch format {
+	case "date":
+		return g.now.AddDate(0, 0, -g.rnd.Intn(365)).Format(time.DateOnly)
+	case "date-time":
+		return g.now.Add(-time.Duration(g.rnd.Int63n(int64(365 * 24 * time.Hour)))).Format(time.RFC3339)
+	}
+	b := strings.Builder{}
+	n := 5 + g.rnd.Intn(10)
+	for i := 0; i < n; i++ {
+		b.WriteByte(letters[g.rnd.Intn(len(letters))])
+	}
+	return b.String()
+}
+
+func schemaTypes(schema map[string]any) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	if _, ok := schema["properties"]; ok {
+		return []string{"object"}
+	}
+	return []string{"string"}
+}
+
+func bounds(schema map[string]any, defMin, defMax float64) (float64, float64) {
+	min, max := defMin, defMax
+	if v, ok := schema["minimum"].(float64); ok {
+		min = v
+	}
+	if v, ok := schema["maximum"].(float64); ok {
+		max = v
+	}
+	if max < min {
+		max = min
+	}
+	return min, max
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+	"os"
+	"strings"
+	"time"
+)
+
+// Loadgen is a source that emits synthetic rows matching a supplied row schema at a configurable rate.
+// It's used to load-test destination connectors and the host pipeline without a warehouse.
+
+//go:embed credentials.schema.json
+var credentialSchemaString string
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
+
+//go:embed default.schema.json
+var defaultRowSchemaString string
+var defaultRowSchema = sdk.UnmarshalSchema(defaultRowSchemaString)
+
+var out = bufio.NewWriterSize(os.Stdout, 1024*1024)
+
+// session replies to the host
+var session = &sdk.Session{Replier: sdk.NewReplier(out)}
+
+func main() {
+	defer out.Flush()
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var message sdk.Message
+		err := json.Unmarshal([]byte(line), &message)
+		if err != nil {
+			session.Error("Message received cannot be parsed: "+line, err.Error())
+			out.Flush()
+			os.Exit(1)
+		}
+		switch message.Type {
+		case "describe":
+			_ = session.Reply("spec", map[string]any{
+				"roles":                 []string{"source"},
+				"description":           "Load generator. Emits synthetic rows matching a row schema",
+				"connectionCredentials": credentialSchema,
+				"connector":             sdk.VersionInfo(nil),
+			})
+			out.Flush()
+			os.Exit(0)
+		case "describe-streams":
+			payload, _ := message.Payload.(map[string]any)
+			creds, _ := payload["credentials"].(map[string]any)
+			_ = session.Reply("stream-spec", map[string]any{
+				"roles":         []string{"source"},
+				"defaultStream": "default",
+				"streams":       []any{map[string]any{"name": "default", "rowType": rowSchema(creds)}},
+			})
+		case "start-stream":
+			payload, _ := message.Payload.(map[string]any)
+			creds, _ := payload["connectionCredentials"].(map[string]any)
+			stream, _ := payload["stream"].(string)
+			generate(stream, creds, scanner)
+			out.Flush()
+			os.Exit(0)
+		default:
+			session.Error("Unknown message type", message.Type)
+		}
+		out.Flush()
+	}
+	err := scanner.Err()
+	if err != nil {
+		session.Error(err.Error())
+	}
+}
+
+func rowSchema(creds map[string]any) map[string]any {
+	if schema, ok := creds["rowSchema"].(map[string]any); ok {
+		return schema
+	}
+	return defaultRowSchema
+}
+
+// generate emits rows followed by 'stream-result'
+func generate(stream string, creds map[string]any, scanner *bufio.Scanner) {
+	rows := 1000
+	if v, ok := creds["rows"].(float64); ok {
+		rows = int(v)
+	}
+	rowsPerSecond, _ := creds["rowsPerSecond"].(float64)
+	nullProbability := 0.1
+	if v, ok := creds["nullProbability"].(float64); ok {
+		nullProbability = v
+	}
+	seed := time.Now().UnixNano()
+	if v, ok := creds["seed"].(float64); ok {
+		seed = int64(v)
+	}
+	options := sdk.EmitterOptions{}
+	if v, ok := creds["maxInFlight"].(float64); ok {
+		options.MaxInFlight = int(v)
+		options.AckTimeout = time.Minute
+	}
+	schema := rowSchema(creds)
+	generator := NewGenerator(seed, nullProbability)
+	session.Info(fmt.Sprintf("Generating %d rows. Rate: %v rows/sec Seed: %d", rows, rowsPerSecond, seed))
+	out.Flush()
+	emitter := sdk.NewEmitterSession(stream, os.Stdout, options)
+	go emitter.ListenAcks(scanner, nil)
+	started := time.Now()
+	for i := 0; i < rows; i++ {
+		if rowsPerSecond > 0 {
+			// sleep if we are ahead of the schedule
+			expected := time.Duration(float64(i) / rowsPerSecond * float64(time.Second))
+			if ahead := expected - time.Since(started); ahead > 0 {
+				_ = emitter.Flush()
+				time.Sleep(ahead)
+			}
+		}
+		if err := emitter.EmitRow(generator.Generate(schema)); err != nil {
+			session.Error("Error emitting row", err.Error())
+			return
+		}
+	}
+	elapsed := time.Since(started)
+	_ = emitter.Flush()
+	session.Info(fmt.Sprintf("Generated %d rows in %s (%.0f rows/sec)", rows, elapsed, float64(rows)/elapsed.Seconds()))
+	out.Flush()
+	if _, err := emitter.Finish(); err != nil {
+		session.Error("Error finishing stream", err.Error())
+	}
+}
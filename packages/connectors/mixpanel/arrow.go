@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// RowsArrowMessage hands off rows in Arrow IPC format instead of JSON. Host writes record batches to a file,
+// e.g. a temp file on a shared volume or /dev/fd/N of an inherited pipe, and sends its path:
+//
+//	{"type":"rows-arrow","payload":{"path":"/tmp/syncmaven/batch-0001.arrow","delete":true}}
+//
+// Rows are processed the same way as rows of 'rows' message before the next message is read.
+// With delete the file is owned by the connector and removed once all rows are read
+type RowsArrowMessage struct {
+	Path        string                `json:"path"`
+	Delete      bool                  `json:"delete,omitempty"`
+	ColumnTypes map[string]ColumnHint `json:"columnTypes,omitempty"`
+}
+
+// acceptArrowRows reads rows of Arrow IPC file and accepts them. Returns number of rows
+func acceptArrowRows(m RowsArrowMessage) (int, error) {
+	if m.Path == "" {
+		return 0, fmt.Errorf("path is required")
+	}
+	f, err := os.Open(m.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+		if m.Delete {
+			if err := os.Remove(m.Path); err != nil {
+				session.Warn(fmt.Sprintf("Cannot remove arrow file %s: %v", m.Path, err))
+			}
+		}
+	}()
+	reader, err := sdk.NewArrowReader(f)
+	if err != nil {
+		return 0, err
+	}
+	return acceptReaderRows(reader, m.ColumnTypes, acceptRow)
+}
+
+// acceptReaderRows passes rows of columnar reader to accept. Column hints are derived from the schema unless
+// columnTypes are given. Returns number of rows
+func acceptReaderRows(reader sdk.RowReader, columnTypes map[string]ColumnHint, accept func(row map[string]any)) (int, error) {
+	if len(columnTypes) > 0 {
+		setColumnHints(columnTypes)
+	} else {
+		setColumnHints(schemaColumnHints(reader.Columns()))
+	}
+	rows := 0
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return rows, fmt.Errorf("error reading row #%d: %w", rows, err)
+		}
+		accept(row)
+		rows++
+	}
+}
+
+// schemaColumnHints derives column hints from schema of columnar data, so decimal columns are rounded to their scale
+func schemaColumnHints(columns []sdk.Column) map[string]ColumnHint {
+	hints := make(map[string]ColumnHint, len(columns))
+	for _, c := range columns {
+		hint := ColumnHint{Type: c.Type}
+		if c.Type == "decimal" {
+			scale := c.Scale
+			hint.Scale = &scale
+		}
+		hints[c.Name] = hint
+	}
+	return hints
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxUploadBytesPerSecond limits bandwidth of requests to Mixpanel, so big backfills don't saturate constrained links.
+// Request bodies of all tenants share the limit. 0 means no limit
+var maxUploadBytesPerSecond = 0
+
+// uploadLimiter is nil unless maxUploadBytesPerSecond is set
+var uploadLimiter *bandwidthLimiter
+
+// bandwidthLimiter spreads writes evenly over time. Shared by concurrent requests
+type bandwidthLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int
+	// next is the time when already reserved bytes are sent
+	next time.Time
+}
+
+// limitUploadBandwidth makes requests to Mixpanel send bodies not faster than bytesPerSecond.
+// Must be called after configureProxy
+func limitUploadBandwidth(bytesPerSecond int) {
+	maxUploadBytesPerSecond = bytesPerSecond
+	if bytesPerSecond <= 0 {
+		uploadLimiter = nil
+		return
+	}
+	uploadLimiter = &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+	baseTransport = &throttlingTransport{base: baseTransport, limiter: uploadLimiter}
+}
+
+// chunkSize is the maximum size of a single write, so bytes are sent in about 10 chunks per second
+func (l *bandwidthLimiter) chunkSize() int {
+	return max(l.bytesPerSecond/10, 512)
+}
+
+// wait blocks until n bytes fit within the limit. Returns early if ctx is done
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.bytesPerSecond) * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttlingTransport limits bandwidth of request bodies
+type throttlingTransport struct {
+	base    http.RoundTripper
+	limiter *bandwidthLimiter
+}
+
+func (t *throttlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Body = &throttledReader{ctx: req.Context(), body: req.Body, limiter: t.limiter}
+	return t.base.RoundTrip(req)
+}
+
+// throttledReader is a request body read by the transport not faster than the limit
+type throttledReader struct {
+	ctx     context.Context
+	body    io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if chunk := r.limiter.chunkSize(); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.body.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (r *throttledReader) Close() error {
+	return r.body.Close()
+}
+
+// throttledTimeout extends timeout of the request by time needed to upload payloads within maxUploadBytesPerSecond.
+// Uncompressed size is used, so the estimate is an upper bound
+func throttledTimeout(timeout time.Duration, payloads ...any) time.Duration {
+	if uploadLimiter == nil {
+		return timeout
+	}
+	size := 0
+	for _, payload := range payloads {
+		data, _ := json.Marshal(payload)
+		size += len(data)
+	}
+	return timeout + time.Duration(float64(size)/float64(maxUploadBytesPerSecond)*float64(time.Second))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottlingTransport(t *testing.T) {
+	var received int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = len(b)
+	}))
+	defer srv.Close()
+	limiter := &bandwidthLimiter{bytesPerSecond: 10_000}
+	client := &http.Client{Transport: &throttlingTransport{base: http.DefaultTransport, limiter: limiter}}
+	start := time.Now()
+	res, err := client.Post(srv.URL, "application/octet-stream", bytes.NewReader(make([]byte, 5_000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	elapsed := time.Since(start)
+	if received != 5_000 {
+		t.Errorf("received %d bytes, want 5000", received)
+	}
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("5000 bytes at 10000 bytes/s uploaded in %s, want about 500ms", elapsed)
+	}
+}
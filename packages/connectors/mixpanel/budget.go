@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// runBudget limits the number and the size of events sent per run, protecting metered destinations
+// from runaway upstream queries. Shared by all tenants
+type runBudget struct {
+	sync.Mutex
+	maxEvents int
+	maxBytes  int
+	events    int
+	bytes     int
+	exceeded  bool
+}
+
+var budget = &runBudget{}
+
+// reserve accounts the event within the budget. Returns false if the event doesn't fit.
+// Once exceeded the budget stays exceeded, so remaining rows are skipped even if smaller events would fit
+func (b *runBudget) reserve(event *mixpanel.Event) bool {
+	if b.maxEvents <= 0 && b.maxBytes <= 0 {
+		return true
+	}
+	size := 0
+	if b.maxBytes > 0 {
+		data, _ := json.Marshal(event)
+		size = len(data)
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.exceeded {
+		return false
+	}
+	if (b.maxEvents > 0 && b.events+1 > b.maxEvents) || (b.maxBytes > 0 && b.bytes+size > b.maxBytes) {
+		b.exceeded = true
+		session.Warn("Run budget exceeded. Remaining rows are skipped", map[string]any{"events": b.events, "bytes": b.bytes})
+		return false
+	}
+	b.events++
+	b.bytes += size
+	return true
+}
+
+func (b *runBudget) isExceeded() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.exceeded
+}
+
+// result returns budget usage for stream-result
+func (b *runBudget) result() map[string]any {
+	b.Lock()
+	defer b.Unlock()
+	result := map[string]any{"events": b.events}
+	if b.maxBytes > 0 {
+		result["bytes"] = b.bytes
+	}
+	return result
+}
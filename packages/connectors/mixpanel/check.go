@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// handleCheck verifies credentials without running a sync and replies with connection-status:
+//
+//	{"type":"connection-status","payload":{"status":"failed","reason":"Project token of tenant 'acme' is rejected by Mixpanel"}}
+//
+// Each project token is checked with strict import of an event with invalid time. Mixpanel authenticates the request
+// before validating events, so validation error means that the token is accepted. Nothing is imported
+func handleCheck(payload sdk.CheckPayload) {
+	if err := checkConnection(payload.ConnectionCredentials); err != nil {
+		session.Warn("Connection check failed", err.Error())
+		_ = session.Reply("connection-status", map[string]any{"status": "failed", "reason": err.Error()})
+		exit(exitConfigError)
+	}
+	session.Info("Connection check succeeded")
+	_ = session.Reply("connection-status", map[string]any{"status": "ok"})
+	exit(exitOK)
+}
+
+func checkConnection(creds map[string]any) error {
+	if creds == nil {
+		return fmt.Errorf("connectionCredentials are required")
+	}
+	projectToken, _ := creds["projectToken"].(string)
+	residency, _ := creds["residency"].(string)
+	rTenants, _ := creds["tenants"].(map[string]any)
+	rTenantColumn, _ := creds["tenantColumn"].(string)
+	rRequestHeaders, _ := creds["requestHeaders"].(map[string]any)
+	if err := configureRequestHeaders(rRequestHeaders); err != nil {
+		return err
+	}
+	rProxyUrl, _ := creds["proxyUrl"].(string)
+	if err := configureProxy(rProxyUrl); err != nil {
+		return err
+	}
+	rAllowedEgressIps, _ := creds["allowedEgressIps"].([]any)
+	allowedEgressIps, err := parseAllowedIps(rAllowedEgressIps)
+	if err != nil {
+		return err
+	}
+	if len(allowedEgressIps) > 0 {
+		rEgressIpEndpoint, _ := creds["egressIpEndpoint"].(string)
+		if _, err = verifyEgressIp(rEgressIpEndpoint, allowedEgressIps); err != nil {
+			return err
+		}
+	}
+	if err := configureTenants(projectToken, residency, rTenants, rTenantColumn); err != nil {
+		return err
+	}
+	for _, t := range allTenants() {
+		if err := t.checkToken(); err != nil {
+			if t.key != "" {
+				return fmt.Errorf("tenant '%s': %w", t.key, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *tenant) checkToken() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+	event := t.mp.NewEvent("$syncmaven_check", "", map[string]any{"time": "invalid", "$insert_id": "syncmaven-check"})
+	_, err := t.mp.Import(ctx, []*mixpanel.Event{event}, mixpanel.ImportOptions{Strict: true})
+	var validationErr mixpanel.ImportFailedValidationError
+	var genericErr mixpanel.ImportGenericError
+	switch {
+	case err == nil || errors.As(err, &validationErr):
+		return nil
+	case errors.As(err, &genericErr) && (genericErr.Code == http.StatusUnauthorized || genericErr.Code == http.StatusForbidden):
+		return fmt.Errorf("project token is rejected by Mixpanel: %w", err)
+	default:
+		return fmt.Errorf("cannot reach Mixpanel: %w", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// Import requests are compressed according to 'compression' (gzip or none) and 'compressionLevel' (1-9) options.
+// Mixpanel doesn't accept zstd. The client library compresses with the default gzip level only, so other levels are
+// applied by compressingTransport. Without compressionLevel the level is picked automatically: best speed on
+// single-CPU hosts, where compression competes with row processing, the default level otherwise
+
+// importCompression is compression of import requests made by the client library
+var importCompression = mixpanel.Gzip
+
+// configureCompression sets compression of import requests. Must be called after configureProxy
+func configureCompression(codec string, level int) error {
+	importCompression = mixpanel.Gzip
+	switch codec {
+	case "", "gzip":
+	case "none":
+		importCompression = mixpanel.None
+		return nil
+	case "zstd":
+		return fmt.Errorf("zstd compression is not supported by Mixpanel. Supported: gzip, none")
+	default:
+		return fmt.Errorf("unsupported compression: %s. Supported: gzip, none", codec)
+	}
+	if level == 0 && runtime.NumCPU() <= 1 {
+		level = gzip.BestSpeed
+	}
+	if level == 0 {
+		return nil
+	}
+	if level < gzip.BestSpeed || level > gzip.BestCompression {
+		return fmt.Errorf("compressionLevel must be between %d and %d, got: %d", gzip.BestSpeed, gzip.BestCompression, level)
+	}
+	session.Debug(fmt.Sprintf("Import requests are compressed with gzip level %d", level))
+	importCompression = mixpanel.None
+	baseTransport = &compressingTransport{base: baseTransport, level: level}
+	return nil
+}
+
+// compressingTransport gzips bodies of import requests
+type compressingTransport struct {
+	base  http.RoundTripper
+	level int
+}
+
+func (t *compressingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody || !strings.HasSuffix(req.URL.Path, "/import") {
+		return t.base.RoundTrip(req)
+	}
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, t.level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(gz, req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err = gz.Close(); err != nil {
+		return nil, err
+	}
+	compressed := buf.Bytes()
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(compressed))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.ContentLength = int64(len(compressed))
+	req.Header.Set("Content-Encoding", "gzip")
+	return t.base.RoundTrip(req)
+}
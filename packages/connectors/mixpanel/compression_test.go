@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+	"github.com/mixpanel/mixpanel-go"
+)
+
+func TestCompressionLevel(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	defer func() {
+		_ = configureProxy("")
+		importCompression = mixpanel.Gzip
+	}()
+	if err := configureCompression("gzip", 1); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := fakeImportServer(t, 10)
+	defer srv.Close()
+	tn := newTenant("", "token", "")
+	tn.mp = mixpanel.NewApiClient("token", mixpanel.ProxyApiLocation(srv.URL), mixpanel.HttpClient(&http.Client{Transport: tn.rateLimits}))
+	b := &eventBatch{status: &Status{}}
+	// fake server fails the test unless the body is gzipped
+	imported := tn.importEvents(b, []*mixpanel.Event{tn.mp.NewEvent("$ad_spend", "", map[string]any{"$insert_id": "a"})}, []string{"a"})
+	if len(imported) != 1 {
+		t.Errorf("imported %v", imported)
+	}
+	for _, tt := range []struct {
+		codec string
+		level int
+	}{{"zstd", 0}, {"brotli", 0}, {"gzip", 10}} {
+		if err := configureCompression(tt.codec, tt.level); err == nil {
+			t.Errorf("configureCompression(%s, %d) must fail", tt.codec, tt.level)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// With 'adaptiveConcurrency' option sendConcurrency is the upper bound of parallel imports of a tenant. The actual limit
+// is adjusted by AIMD: it grows by one after a window of healthy imports and is halved when Mixpanel responds with 429,
+// 5xx or the request fails. Imports much slower than the fastest recent ones don't grow the limit, so the controller
+// settles below the point where Mixpanel starts queueing requests
+
+const (
+	// slowImportFactor is how much slower than the baseline an import may be to count as healthy
+	slowImportFactor = 2
+	// baselineDecay lets the baseline latency follow slowly growing payloads
+	baselineDecay = 0.05
+)
+
+// adaptiveConcurrency enables concurrencyController of background senders
+var adaptiveConcurrency = false
+
+// concurrencyController limits imports of a tenant in flight. Shared by senders of the tenant
+type concurrencyController struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	max      int
+	limit    float64
+	inFlight int
+	// baseline is the latency of healthy imports
+	baseline time.Duration
+}
+
+func newConcurrencyController(max int) *concurrencyController {
+	c := &concurrencyController{max: max, limit: 1}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// acquire blocks until one more import fits within the limit
+func (c *concurrencyController) acquire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.inFlight >= int(c.limit) {
+		c.cond.Wait()
+	}
+	c.inFlight++
+}
+
+// release adjusts the limit by result of the import that took latency
+func (c *concurrencyController) release(latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	defer c.cond.Broadcast()
+	if isOverloaded(err) {
+		limit := max(c.limit/2, 1)
+		if int(limit) < int(c.limit) {
+			session.Debug(fmt.Sprintf("Import concurrency decreased to %d", int(limit)), err.Error())
+		}
+		c.limit = limit
+		return
+	}
+	if err != nil {
+		return
+	}
+	switch {
+	case c.baseline == 0 || latency < c.baseline:
+		c.baseline = latency
+	case latency > c.baseline*slowImportFactor:
+		return
+	default:
+		c.baseline += time.Duration(float64(latency-c.baseline) * baselineDecay)
+	}
+	// additive increase: about one per window of limit imports
+	limit := min(c.limit+1/c.limit, float64(c.max))
+	if int(limit) > int(c.limit) {
+		session.Debug(fmt.Sprintf("Import concurrency increased to %d", int(limit)))
+	}
+	c.limit = limit
+}
+
+// current is the current limit
+func (c *concurrencyController) current() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+// isOverloaded tells whether Mixpanel rejected the import because of load: 429, 5xx or a failed request.
+// Validation and other client errors are not related to load
+func isOverloaded(err error) bool {
+	var validationErr mixpanel.ImportFailedValidationError
+	var genericErr mixpanel.ImportGenericError
+	var rateLimitErr mixpanel.ImportRateLimitError
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &rateLimitErr):
+		return true
+	case errors.As(err, &validationErr), errors.As(err, &genericErr):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mixpanel/mixpanel-go"
+)
+
+func TestConcurrencyController(t *testing.T) {
+	c := newConcurrencyController(4)
+	for i := 0; i < 10; i++ {
+		c.acquire()
+		c.release(100*time.Millisecond, nil)
+	}
+	if n := c.current(); n != 4 {
+		t.Fatalf("limit must grow to the maximum on healthy imports, got %d", n)
+	}
+	c.acquire()
+	c.release(time.Second, nil)
+	if n := c.current(); n != 4 {
+		t.Errorf("slow import must not change the limit, got %d", n)
+	}
+	c.acquire()
+	c.release(100*time.Millisecond, mixpanel.ImportRateLimitError{})
+	if n := c.current(); n != 2 {
+		t.Errorf("limit must be halved on 429, got %d", n)
+	}
+	c.acquire()
+	c.release(100*time.Millisecond, errors.New("unexpected status code: 503"))
+	c.acquire()
+	c.release(100*time.Millisecond, mixpanel.ImportGenericError{Code: 401})
+	if n := c.current(); n != 1 {
+		t.Errorf("limit must be halved on 5xx only, got %d", n)
+	}
+}
+
+func TestConcurrencyControllerLimitsInFlight(t *testing.T) {
+	c := newConcurrencyController(4)
+	c.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		c.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("second import must wait while the limit is 1")
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.release(10*time.Millisecond, nil)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("waiting import must proceed after release")
+	}
+}
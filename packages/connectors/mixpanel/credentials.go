@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// handleCredentialsUpdated switches tenants to credentials rotated by the host during the stream:
+//
+//	{"type":"credentials-updated","payload":{"connectionCredentials":{"projectToken":"..."}}}
+//
+// Only project tokens are rotated, other options keep values of start-stream. Clients are replaced between batches:
+// the worker holds the tenant lock while it builds and sends batches and background senders release it only for
+// requests, so requests in flight complete with the previous token and the next ones use the new one.
+// Invalid credentials are rejected and the stream continues with the previous ones
+func handleCredentialsUpdated(payload sdk.CredentialsUpdatedPayload) {
+	if !streamStarted || streamEnded {
+		session.Warn("Received credentials-updated message outside of the stream. Ignored")
+		return
+	}
+	tokens, err := tenantTokens(payload.ConnectionCredentials)
+	if err != nil {
+		session.Error("Updated credentials are rejected. Stream continues with previous credentials", err.Error())
+		return
+	}
+	rotated := 0
+	for _, t := range allTenants() {
+		t.mu.Lock()
+		if t.rotateToken(tokens[t.key]) {
+			rotated++
+		}
+		t.mu.Unlock()
+	}
+	session.Info(fmt.Sprintf("Credentials updated. Project tokens rotated: %d", rotated))
+}
+
+// tenantTokens returns project tokens of configured tenants by tenant key. Tenants can't be added mid-run,
+// so tokens of unknown tenants are ignored
+func tenantTokens(creds map[string]any) (map[string]string, error) {
+	if tenantColumn == "" {
+		token, _ := creds["projectToken"].(string)
+		if token == "" {
+			return nil, fmt.Errorf("projectToken is required")
+		}
+		return map[string]string{"": token}, nil
+	}
+	rawTenants, _ := creds["tenants"].(map[string]any)
+	tokens := make(map[string]string, len(tenants))
+	for key := range tenants {
+		token, _ := rawTenants[key].(string)
+		if token == "" {
+			return nil, fmt.Errorf("project token of tenant '%s' must be a non-empty string", key)
+		}
+		tokens[key] = token
+	}
+	return tokens, nil
+}
+
+// rotateToken replaces the client of the tenant. Must be called with t.mu held
+func (t *tenant) rotateToken(token string) bool {
+	if token == t.projectToken {
+		return false
+	}
+	t.projectToken = token
+	t.mp = newMixpanelClient(token, t.residency, t.rateLimits)
+	return true
+}
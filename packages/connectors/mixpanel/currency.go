@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Currency conversion. With 'targetCurrency' option $ad_cost and conversion_value are converted from currency of cost
+// (taken from the column hint, see hints.go) using 'exchangeRates': units of target currency per unit of the source
+// currency, e.g. {"EUR": 1.08} with USD target. Rows without currency hint are considered to be in target currency.
+// Cost before conversion is kept in original_cost and original_currency properties, so analysts can audit
+// conversions in Mixpanel. Rows in currency without exchange rate fail
+
+// targetCurrency is ISO 4217 code of the currency $ad_cost is converted to. Empty means no conversion
+var targetCurrency = ""
+var exchangeRates = make(map[string]*big.Rat)
+
+func configureCurrencyConversion(target string, rates map[string]any) error {
+	if target == "" {
+		if len(rates) > 0 {
+			return fmt.Errorf("exchangeRates requires targetCurrency")
+		}
+		return nil
+	}
+	targetCurrency = strings.ToUpper(target)
+	for currency, v := range rates {
+		rate, ok := toDecimal(v)
+		if !ok || rate.Sign() <= 0 {
+			return fmt.Errorf("exchange rate of %s must be a positive number, got %v", currency, v)
+		}
+		exchangeRates[strings.ToUpper(currency)] = rate
+	}
+	return nil
+}
+
+// convertCurrency converts $ad_cost and conversion_value properties to target currency and sets original_cost
+// and original_currency properties. roas is left as is, it doesn't depend on currency
+func convertCurrency(properties map[string]any, payload *RowPayload) error {
+	if targetCurrency == "" {
+		return nil
+	}
+	currency := strings.ToUpper(payload.Currency)
+	if currency == "" {
+		currency = targetCurrency
+	}
+	rate := big.NewRat(1, 1)
+	if currency != targetCurrency {
+		var ok bool
+		if rate, ok = exchangeRates[currency]; !ok {
+			return fmt.Errorf("no exchange rate of %s to %s", currency, targetCurrency)
+		}
+	}
+	cost := properties["$ad_cost"].(float64)
+	properties["original_cost"] = cost
+	properties["original_currency"] = currency
+	properties["$ad_cost"] = convertAmount(cost, rate)
+	properties["currency"] = targetCurrency
+	if value, ok := properties["conversion_value"].(float64); ok {
+		properties["conversion_value"] = convertAmount(value, rate)
+	}
+	return nil
+}
+
+// convertAmount multiplies the amount by the rate exactly, then rounds it like costAmount does
+func convertAmount(amount float64, rate *big.Rat) float64 {
+	r, _ := toDecimal(amount)
+	r.Mul(r, rate)
+	if costScale >= 0 {
+		r = roundDecimal(r, costScale, costRounding)
+	}
+	f, _ := r.Float64()
+	return f
+}
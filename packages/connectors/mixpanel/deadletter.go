@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Mixpanel import rejects events outside of this window
+const maxEventAge = time.Hour * 24 * 365 * 5
+const maxEventFuture = time.Hour
+
+// maxDeadLetters limits the number of entries kept in the dead-letter queue. Oldest entries are dropped first
+const maxDeadLetters = 1000
+
+// deadLetter is a row that can't be sent to Mixpanel, kept in state for restatement or inspection
+type deadLetter struct {
+	Date       string `json:"date"`
+	Source     string `json:"source"`
+	CampaignId string `json:"campaign_id"`
+	InsertId   string `json:"insertId"`
+	Reason     string `json:"reason"`
+	At         string `json:"at"`
+}
+
+// checkEventTime returns the reason why Mixpanel would reject an event with the time, or empty string
+func checkEventTime(t time.Time) string {
+	now := time.Now()
+	if t.Before(now.Add(-maxEventAge)) {
+		return "event time is older than 5 years"
+	}
+	if t.After(now.Add(maxEventFuture)) {
+		return "event time is in the future"
+	}
+	return ""
+}
+
+func (t *tenant) deadLetterKey() []string {
+	key := []string{"syncId=" + syncId, "type=mixpanel.deadletter"}
+	if t.key != "" {
+		key = append(key, "tenant="+t.key)
+	}
+	return key
+}
+
+// addDeadLetter queues the row. Queue is saved to state when the tenant worker finishes
+func (t *tenant) addDeadLetter(payload *RowPayload, insertId string, reason string) {
+	t.deadLetters = append(t.deadLetters, deadLetter{
+		Date:       payload.Date,
+		Source:     payload.Source,
+		CampaignId: payload.CampaignId,
+		InsertId:   insertId,
+		Reason:     reason,
+		At:         time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// saveDeadLetters appends rows queued during the run to the dead-letter queue in state
+func (t *tenant) saveDeadLetters() {
+	if len(t.deadLetters) == 0 {
+		return
+	}
+	raw, err := rpcClient.Get(t.deadLetterKey())
+	if err != nil {
+		session.Error("Error getting dead-letter queue", err.Error())
+	}
+	existing, _ := raw.([]any)
+	queue := make([]any, 0, len(existing)+len(t.deadLetters))
+	queue = append(queue, existing...)
+	for _, dl := range t.deadLetters {
+		queue = append(queue, dl)
+	}
+	if len(queue) > maxDeadLetters {
+		queue = queue[len(queue)-maxDeadLetters:]
+	}
+	if err = rpcClient.Set(t.deadLetterKey(), queue); err != nil {
+		session.Error("Error saving dead-letter queue", err.Error())
+		return
+	}
+	session.Warn(fmt.Sprintf("%d rows added to dead-letter queue", len(t.deadLetters)), t.deadLetterKey())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+func TestDeadLetters(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	startDate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer func() { startDate = time.Time{} }()
+	store := make(map[string]any)
+	fakeStateServer(t, store)
+	now := time.Now().UTC()
+	tests := []struct {
+		date   string
+		reason string
+	}{
+		{date: now.AddDate(-6, 0, 0).Format(time.DateOnly), reason: "event time is older than 5 years"},
+		{date: now.AddDate(0, 0, 2).Format(time.DateOnly), reason: "event time is in the future"},
+		{date: now.AddDate(0, 0, -1).Format(time.DateOnly)},
+	}
+	tn := newTenant("", "token", "")
+	for _, test := range tests {
+		processRow(tn, &RowPayload{Date: test.date, Source: "google", CampaignId: "1", Cost: 1}, 0)
+	}
+	if len(tn.deadLetters) != 2 || len(tn.batch) != 1 {
+		t.Fatalf("%d rows dead-lettered and %d batched, want 2 and 1", len(tn.deadLetters), len(tn.batch))
+	}
+	for i, test := range tests {
+		status := tn.statuses[test.date]
+		if test.reason == "" {
+			if status.DeadLettered != 0 {
+				t.Errorf("%s: row in the window is dead-lettered", test.date)
+			}
+			continue
+		}
+		dl := tn.deadLetters[i]
+		if status.DeadLettered != 1 || status.Failed != 0 || status.Skipped != 0 || dl.Date != test.date || dl.Reason != test.reason || dl.InsertId == "" {
+			t.Errorf("%s: status %+v, dead letter %+v, want reason %q", test.date, status, dl, test.reason)
+		}
+	}
+
+	// the queue in state is appended to
+	key := strings.Join(tn.deadLetterKey(), "::")
+	store[key] = []any{map[string]any{"date": "2019-01-01"}}
+	tn.saveDeadLetters()
+	if queue, _ := store[key].([]any); len(queue) != 3 {
+		t.Errorf("dead-letter queue = %v, want 3 entries", store[key])
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Rounding modes applied when exact decimal values are downcast to float64 for Mixpanel
+const (
+	roundHalfEven = "half-even"
+	roundHalfUp   = "half-up"
+	roundDown     = "down"
+	roundUp       = "up"
+)
+
+// costScale is number of digits after decimal point $ad_cost is rounded to. Negative means no rounding
+var costScale = -1
+var costRounding = roundHalfEven
+
+func configureCostRounding(scale *int, mode string) error {
+	switch mode {
+	case "":
+	case roundHalfEven, roundHalfUp, roundDown, roundUp:
+		costRounding = mode
+	default:
+		return fmt.Errorf("unknown rounding mode: %s", mode)
+	}
+	if scale != nil {
+		costScale = *scale
+	}
+	return nil
+}
+
+// toDecimal returns exact value of a number decoded as json.Number or string. float64 values are converted as is
+func toDecimal(v any) (*big.Rat, bool) {
+	switch t := v.(type) {
+	case json.Number:
+		return new(big.Rat).SetString(t.String())
+	case string:
+		return new(big.Rat).SetString(t)
+	case float64:
+		return new(big.Rat).SetString(strconv.FormatFloat(t, 'f', -1, 64))
+	}
+	return nil, false
+}
+
+// roundDecimal rounds r to scale digits after decimal point using the rounding mode
+func roundDecimal(r *big.Rat, scale int, mode string) *big.Rat {
+	multiplier := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(multiplier))
+	// quotient truncated toward zero and remainder of the same sign as scaled
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		// compare 2*|rem| with denominator to find out if remainder is more, less or exactly half
+		half := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom())
+		awayFromZero := false
+		switch mode {
+		case roundUp:
+			awayFromZero = true
+		case roundDown:
+			awayFromZero = false
+		case roundHalfUp:
+			awayFromZero = half >= 0
+		default:
+			awayFromZero = half > 0 || (half == 0 && quo.Bit(0) == 1)
+		}
+		if awayFromZero {
+			quo.Add(quo, big.NewInt(int64(scaled.Sign())))
+		}
+	}
+	return new(big.Rat).SetFrac(quo, multiplier)
+}
+
+// costAmount downcasts the exact cost to float64 at the destination boundary applying costScale and costRounding
+func costAmount(payload *RowPayload) float64 {
+	if payload.CostDecimal == nil {
+		return payload.Cost
+	}
+	r := payload.CostDecimal
+	if costScale >= 0 {
+		r = roundDecimal(r, costScale, costRounding)
+	}
+	f, _ := r.Float64()
+	return f
+}
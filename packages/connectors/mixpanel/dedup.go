@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// dedupNamespace enables deduplication of events across syncs writing to the same project.
+// Hashes of sent insert ids are kept in state under the namespace, one entry per sent batch of an event date
+var dedupNamespace string
+
+// dedupTtl is how long the hashes of sent insert ids are kept
+var dedupTtl = time.Hour * 24 * 7
+
+const dedupHashLength = 16
+
+// dedupEntry holds hashes of a single event date, merged from all its batch entries
+type dedupEntry struct {
+	Hashes map[string]bool
+}
+
+func dedupHash(insertId string) string {
+	h := sha256.Sum256([]byte(insertId))
+	return hex.EncodeToString(h[:])[:dedupHashLength]
+}
+
+// dedupPrefix is the state key prefix of the tenant project. Project token is hashed, so it isn't exposed in state
+func (t *tenant) dedupPrefix() []string {
+	return []string{"type=mixpanel.dedup", "namespace=" + dedupNamespace, "project=" + dedupHash(t.projectToken)}
+}
+
+func (t *tenant) dedupKey(date string) []string {
+	return append(t.dedupPrefix(), "date="+date)
+}
+
+// loadDedupEntry reads hashes of insert ids sent with the date by all syncs of the namespace. Expired entries are skipped
+func (t *tenant) loadDedupEntry(date string) *dedupEntry {
+	entry := &dedupEntry{Hashes: make(map[string]bool)}
+	err := rpcClient.StreamList(t.dedupKey(date), func(key []string, raw any) error {
+		value, _ := raw.(map[string]any)
+		expiresAt, _ := value["expiresAt"].(string)
+		if t, err := time.Parse(time.RFC3339, expiresAt); err != nil || t.Before(time.Now()) {
+			return nil
+		}
+		hashes, _ := value["hashes"].([]any)
+		for _, h := range hashes {
+			if s, ok := h.(string); ok {
+				entry.Hashes[s] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		session.Error(fmt.Sprintf("[%s] Error getting dedup state", date), err.Error())
+	}
+	return entry
+}
+
+// isDuplicate checks whether an event with the insert id was already sent by any sync of the namespace
+func (t *tenant) isDuplicate(date string, insertId string) bool {
+	if dedupNamespace == "" {
+		return false
+	}
+	entry, ok := t.dedup[date]
+	if !ok {
+		entry = t.loadDedupEntry(date)
+		t.dedup[date] = entry
+	}
+	return entry.Hashes[dedupHash(insertId)]
+}
+
+// recordSent saves hashes of sent insert ids under a new key of the date, so a batch writes only its own hashes
+// and concurrent syncs never overwrite each other's entries. Hashes recorded by a concurrent sync after the date
+// was loaded are seen by later runs only
+func (t *tenant) recordSent(date string, insertIds []string) {
+	if dedupNamespace == "" || len(insertIds) == 0 {
+		return
+	}
+	entry := t.dedup[date]
+	hashes := make([]string, 0, len(insertIds))
+	for _, id := range insertIds {
+		h := dedupHash(id)
+		hashes = append(hashes, h)
+		if entry != nil {
+			entry.Hashes[h] = true
+		}
+	}
+	sort.Strings(hashes)
+	err := rpcClient.Set(append(t.dedupKey(date), "batch="+sdk.NewCallId()), map[string]any{
+		"expiresAt": time.Now().Add(dedupTtl).Format(time.RFC3339),
+		"hashes":    hashes,
+	})
+	if err != nil {
+		session.Error(fmt.Sprintf("[%s] Error saving dedup state", date), err.Error())
+	}
+}
+
+// pruneDedup deletes expired entries of the tenant project
+func (t *tenant) pruneDedup() {
+	if dedupNamespace == "" {
+		return
+	}
+	// a day may have many batch entries, so they are decoded one at a time and only expired keys are kept.
+	// Keys are deleted once listing is done
+	var expired [][]string
+	err := rpcClient.StreamList(t.dedupPrefix(), func(key []string, raw any) error {
+		value, _ := raw.(map[string]any)
+		expiresAt, _ := value["expiresAt"].(string)
+		if t, err := time.Parse(time.RFC3339, expiresAt); err == nil && t.After(time.Now()) {
+			return nil
+		}
+		if len(key) > 0 {
+			expired = append(expired, key)
+		}
+		return nil
+	})
+	if err != nil {
+		session.Error("Error listing dedup state", err.Error())
+		return
+	}
+	pruned := 0
+	for _, key := range expired {
+		if err = rpcClient.Del(key); err != nil {
+			session.Error("Error deleting expired dedup state", err.Error())
+			continue
+		}
+		pruned++
+	}
+	if pruned > 0 {
+		session.Info(fmt.Sprintf("Pruned %d expired dedup entries", pruned))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// fakeStateServer is an in-memory state RPC. It counts calls by method
+func fakeStateServer(t *testing.T, store map[string]any) map[string]int {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Key    []string `json:"key"`
+			Value  any      `json:"value"`
+			Prefix []string `json:"prefix"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		defer mu.Unlock()
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		calls[method]++
+		var res any = map[string]any{}
+		switch method {
+		case "state.get":
+			if value, ok := store[strings.Join(body.Key, "::")]; ok {
+				res = value
+			}
+		case "state.set":
+			store[strings.Join(body.Key, "::")] = body.Value
+		case "state.list":
+			prefix := strings.Join(body.Prefix, "::")
+			entries := []any{}
+			for key, value := range store {
+				if key == prefix || strings.HasPrefix(key, prefix+"::") {
+					entries = append(entries, map[string]any{"key": strings.Split(key, "::"), "value": value})
+				}
+			}
+			res = entries
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	t.Cleanup(srv.Close)
+	rpcClient = sdk.NewRpcClient(srv.URL)
+	return calls
+}
+
+func TestDedupBatchesAreStoredSeparately(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	dedupNamespace = "test"
+	defer func() { dedupNamespace = "" }()
+	store := make(map[string]any)
+	calls := fakeStateServer(t, store)
+
+	// two syncs of the same project record their batches concurrently
+	first, second := newTenant("", "token", ""), newTenant("", "token", "")
+	first.isDuplicate("2024-01-01", "a")
+	second.isDuplicate("2024-01-01", "b")
+	first.recordSent("2024-01-01", []string{"a1", "a2"})
+	second.recordSent("2024-01-01", []string{"b1"})
+	first.recordSent("2024-01-01", []string{"a3"})
+	if calls["state.set"] != 3 || calls["state.get"] != 0 || calls["state.list"] != 2 {
+		t.Errorf("each batch must be written once without reading state: %v", calls)
+	}
+	if len(store) != 3 {
+		t.Errorf("each batch must be stored under its own key: %v", store)
+	}
+	if !first.isDuplicate("2024-01-01", "a3") || first.isDuplicate("2024-01-01", "b1") {
+		t.Error("sent insert ids must be added to the loaded hashes of the date")
+	}
+
+	// expired entries are ignored
+	store[strings.Join(append(first.dedupKey("2024-01-01"), "batch=old"), "::")] = map[string]any{
+		"expiresAt": time.Now().Add(-time.Hour).Format(time.RFC3339),
+		"hashes":    []string{dedupHash("old")},
+	}
+	later := newTenant("", "token", "")
+	for _, id := range []string{"a1", "a2", "a3", "b1"} {
+		if !later.isDuplicate("2024-01-01", id) {
+			t.Errorf("%s sent by an earlier sync isn't a duplicate", id)
+		}
+	}
+	if later.isDuplicate("2024-01-01", "old") || later.isDuplicate("2024-01-02", "a1") {
+		t.Error("expired entries and other dates must not be duplicates")
+	}
+}
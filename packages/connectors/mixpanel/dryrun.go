@@ -0,0 +1,55 @@
+package main
+
+import (
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// dryRun is set if start-stream requests dry run. Rows are validated, mapped and batched as usual, but imports and
+// profile updates are recorded instead of being sent and nothing is written to the state. A sample of the events
+// is reported in dry-run reply before stream-result. First run preview is skipped, dry run shows the same
+var dryRun *sdk.DryRun
+
+func startDryRun(requested bool) {
+	if !requested {
+		return
+	}
+	dryRun = sdk.NewDryRun(sdk.DryRunSampleSize)
+	rpcClient.ReadOnly = true
+	session.Warn("Dry run: nothing is sent to Mixpanel and state is not saved")
+}
+
+// dryRunImport records the import and returns the response of successful import
+func dryRunImport(events []*mixpanel.Event) *mixpanel.ImportSuccess {
+	payloads := make([]any, len(events))
+	for i, e := range events {
+		properties := make(map[string]any, len(e.Properties))
+		for name, value := range e.Properties {
+			// project token is added to each event, it's not a part of the mapping
+			if name != "token" {
+				properties[name] = value
+			}
+		}
+		payloads[i] = map[string]any{"event": e.Name, "properties": properties}
+	}
+	dryRun.Request(payloads...)
+	return &mixpanel.ImportSuccess{Code: 200, NumRecordsImported: len(events), Status: "dry run"}
+}
+
+// dryRunEngage records profile updates
+func dryRunEngage(operation string, profiles []*mixpanel.PeopleProperties) {
+	payloads := make([]any, len(profiles))
+	for i, p := range profiles {
+		payloads[i] = map[string]any{"operation": operation, "distinct_id": p.DistinctID, "properties": p.Properties}
+	}
+	dryRun.Request(payloads...)
+}
+
+// replyDryRun replies the sample of recorded requests and marks stream-result as dry run
+func replyDryRun(result map[string]any) {
+	if dryRun == nil {
+		return
+	}
+	_ = session.Reply("dry-run", dryRun.Report())
+	result["dryRun"] = true
+}
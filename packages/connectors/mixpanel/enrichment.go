@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Campaign metadata enrichment attaches objective, labels and budget of the campaign to events as campaign_objective,
+// campaign_labels and campaign_budget properties, so ad data doesn't have to be joined with campaigns upstream.
+// Metadata is loaded once per run and cached in memory. It is read from CSV file at campaignMetadataUrl (http(s) URL
+// or local path) with campaign_id, source, objective, labels (separated with ';' or '|') and budget columns, only
+// campaign_id is required. The host may also send metadata before rows, e.g. rows of a campaigns stream:
+//
+//	{"type":"campaign-metadata","payload":{"rows":[{"source":"google","campaign_id":"123","objective":"sales","labels":["brand"],"budget":100}]}}
+//
+// With campaignMetadataState metadata received in messages is saved to state and loaded by subsequent runs.
+// Metadata without source matches campaigns of all sources
+type campaignMetadata struct {
+	Objective string   `json:"objective,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+	Budget    *float64 `json:"budget,omitempty"`
+}
+
+var campaignsLock sync.RWMutex
+
+// campaigns is the metadata keyed by campaignKey. Nil if enrichment is disabled
+var campaigns map[string]campaignMetadata
+
+var campaignMetadataState = false
+
+// enrichedEvents and notEnrichedEvents count events with and without metadata of their campaign.
+// Guarded by enrichmentLock
+var enrichmentLock sync.Mutex
+var enrichedEvents, notEnrichedEvents int
+
+const campaignMetadataTimeout = time.Second * 30
+
+func campaignKey(source, campaignId string) string {
+	return strings.ToLower(source) + "/" + campaignId
+}
+
+func campaignStateKey() []string {
+	return []string{"syncId=" + syncId, "type=mixpanel.campaigns"}
+}
+
+// loadCampaignMetadata loads metadata from CSV and state, if configured
+func loadCampaignMetadata(url string, fromState bool) error {
+	campaignMetadataState = fromState
+	if url == "" && !fromState {
+		return nil
+	}
+	campaigns = make(map[string]campaignMetadata)
+	if fromState {
+		raw, err := rpcClient.Get(campaignStateKey())
+		if err != nil {
+			return fmt.Errorf("error getting campaign metadata state: %w", err)
+		}
+		rows, _ := raw.([]any)
+		addCampaignRows(rows)
+	}
+	if url != "" {
+		rows, err := readCampaignCsv(url)
+		if err != nil {
+			return fmt.Errorf("error loading campaign metadata: %w", err)
+		}
+		addCampaignRows(rows)
+	}
+	session.Info(fmt.Sprintf("Campaign metadata loaded: %d campaigns", len(campaigns)))
+	return nil
+}
+
+// readCampaignCsv reads CSV with header from http(s) URL or local file
+func readCampaignCsv(url string) ([]any, error) {
+	var body io.Reader
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		client := http.Client{Timeout: campaignMetadataTimeout}
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(url)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		body = f
+	}
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header: %w", err)
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+	}
+	var rows []any
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(header))
+		for i, value := range record {
+			if i < len(header) && value != "" {
+				row[header[i]] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// addCampaignRows adds metadata rows to the cache. Returns number of valid rows
+func addCampaignRows(rows []any) int {
+	campaignsLock.Lock()
+	defer campaignsLock.Unlock()
+	if campaigns == nil {
+		campaigns = make(map[string]campaignMetadata)
+	}
+	added := 0
+	for _, r := range rows {
+		row, _ := r.(map[string]any)
+		campaignId, _ := canonicalString(row["campaign_id"])
+		if row["campaign_id"] == nil || campaignId == "" {
+			continue
+		}
+		source, _ := row["source"].(string)
+		var metadata campaignMetadata
+		metadata.Objective, _ = row["objective"].(string)
+		switch labels := row["labels"].(type) {
+		case string:
+			for _, label := range strings.FieldsFunc(labels, func(r rune) bool { return r == ';' || r == '|' }) {
+				if label = strings.TrimSpace(label); label != "" {
+					metadata.Labels = append(metadata.Labels, label)
+				}
+			}
+		case []any:
+			for _, label := range labels {
+				if s, _ := canonicalString(label); s != "" {
+					metadata.Labels = append(metadata.Labels, s)
+				}
+			}
+		}
+		if row["budget"] != nil {
+			s, _ := canonicalString(row["budget"])
+			if budget, err := strconv.ParseFloat(s, 64); err == nil {
+				metadata.Budget = &budget
+			}
+		}
+		campaigns[campaignKey(source, campaignId)] = metadata
+		added++
+	}
+	return added
+}
+
+// handleCampaignMetadata adds metadata of campaign-metadata message and saves all metadata to state, if configured
+func handleCampaignMetadata(payload map[string]any) {
+	rows, _ := payload["rows"].([]any)
+	added := addCampaignRows(rows)
+	session.Debug(fmt.Sprintf("Received metadata of %d campaigns", added))
+	if campaignMetadataState && added > 0 {
+		if err := rpcClient.Set(campaignStateKey(), campaignStateValue()); err != nil {
+			session.Error("Error saving campaign metadata state", err.Error())
+		}
+	}
+}
+
+// campaignStateValue returns cached metadata as rows
+func campaignStateValue() []any {
+	campaignsLock.RLock()
+	defer campaignsLock.RUnlock()
+	rows := make([]any, 0, len(campaigns))
+	for key, metadata := range campaigns {
+		source, campaignId, _ := strings.Cut(key, "/")
+		row := map[string]any{"campaign_id": campaignId}
+		setIfNotEmpty(row, "source", source)
+		setIfNotEmpty(row, "objective", metadata.Objective)
+		if len(metadata.Labels) > 0 {
+			row["labels"] = metadata.Labels
+		}
+		if metadata.Budget != nil {
+			row["budget"] = *metadata.Budget
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// enrichCampaign adds metadata of the campaign of the row to event properties
+func enrichCampaign(properties map[string]any, payload *RowPayload) {
+	campaignsLock.RLock()
+	if campaigns == nil {
+		campaignsLock.RUnlock()
+		return
+	}
+	metadata, ok := campaigns[campaignKey(payload.Source, payload.CampaignId)]
+	if !ok {
+		metadata, ok = campaigns[campaignKey("", payload.CampaignId)]
+	}
+	campaignsLock.RUnlock()
+	enrichmentLock.Lock()
+	if ok {
+		enrichedEvents++
+	} else {
+		notEnrichedEvents++
+	}
+	enrichmentLock.Unlock()
+	if !ok {
+		return
+	}
+	setIfNotEmpty(properties, "campaign_objective", metadata.Objective)
+	if len(metadata.Labels) > 0 {
+		properties["campaign_labels"] = metadata.Labels
+	}
+	if metadata.Budget != nil {
+		properties["campaign_budget"] = *metadata.Budget
+	}
+}
+
+// enrichmentResult returns counts of enriched events for stream-result. Nil if enrichment is disabled
+func enrichmentResult() map[string]any {
+	campaignsLock.RLock()
+	defer campaignsLock.RUnlock()
+	if campaigns == nil {
+		return nil
+	}
+	enrichmentLock.Lock()
+	defer enrichmentLock.Unlock()
+	return map[string]any{
+		"campaigns":   len(campaigns),
+		"enriched":    enrichedEvents,
+		"notEnriched": notEnrichedEvents,
+	}
+}
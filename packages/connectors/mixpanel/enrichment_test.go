@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCampaignEnrichment(t *testing.T) {
+	defer func() { campaigns = nil }()
+	path := filepath.Join(t.TempDir(), "campaigns.csv")
+	csv := "Campaign_Id,Source,Objective,Labels,Budget\n123,Google,sales,brand; search,100.5\n456,,awareness,,\n"
+	if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadCampaignMetadata(path, false); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		payload RowPayload
+		want    map[string]any
+	}{
+		{RowPayload{Source: "google", CampaignId: "123"}, map[string]any{"campaign_objective": "sales", "campaign_labels": []string{"brand", "search"}, "campaign_budget": 100.5}},
+		// metadata without source matches all sources
+		{RowPayload{Source: "facebook", CampaignId: "456"}, map[string]any{"campaign_objective": "awareness"}},
+		{RowPayload{Source: "facebook", CampaignId: "123"}, map[string]any{}},
+	}
+	for _, tt := range tests {
+		properties := map[string]any{}
+		enrichCampaign(properties, &tt.payload)
+		if !reflect.DeepEqual(properties, tt.want) {
+			t.Errorf("enrichCampaign(%s %s) = %v, want %v", tt.payload.Source, tt.payload.CampaignId, properties, tt.want)
+		}
+	}
+	// campaign-metadata message overrides metadata of the campaign
+	handleCampaignMetadata(map[string]any{"rows": []any{map[string]any{"campaign_id": 456.0, "labels": []any{"video"}}}})
+	properties := map[string]any{}
+	enrichCampaign(properties, &RowPayload{Source: "twitter", CampaignId: "456"})
+	if !reflect.DeepEqual(properties, map[string]any{"campaign_labels": []string{"video"}}) {
+		t.Errorf("unexpected properties after campaign-metadata message: %v", properties)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Exit codes of the connector process, so orchestration around connector binary can branch on outcomes
+const (
+	// exitOK means all rows were processed without failures
+	exitOK = 0
+	// exitError means protocol or internal error, e.g. unparseable message
+	exitError = 1
+	// exitPartial means the run finished, but some rows failed or were not sent because of run budget or maxRuntimeMinutes
+	exitPartial = 2
+	// exitConfigError means invalid configuration or credentials rejected by Mixpanel
+	exitConfigError = 3
+	// exitUnavailable means nothing could be sent because Mixpanel was unavailable
+	exitUnavailable = 4
+	// exitConfirmRequired means pre-flight check or first run preview requires confirm option to proceed
+	exitConfirmRequired = 5
+	// exitCancelled means the host halted the stream or the process was stopped by SIGTERM or SIGINT
+	exitCancelled = 6
+	// exitRetryLater means Mixpanel rate limited the run and the host should reschedule it, see retry-later reply
+	exitRetryLater = 7
+	// exitQualityFailed means the run was halted because it exceeded maxWarnings or maxFailedPercent
+	exitQualityFailed = 8
+)
+
+var exitStatuses = map[int]string{
+	exitOK:              "success",
+	exitError:           "error",
+	exitPartial:         "partial",
+	exitConfigError:     "config_error",
+	exitUnavailable:     "destination_unavailable",
+	exitConfirmRequired: "confirm_required",
+	exitCancelled:       "cancelled",
+	exitRetryLater:      "retry_later",
+	exitQualityFailed:   "quality_failed",
+}
+
+// streamStarted is set once start-stream is received, so describe calls don't print the summary
+var streamStarted = false
+
+// streamEnded is set once stream-result is sent in reply to end-stream
+var streamEnded = false
+
+// runExitCode returns exit code of a finished run
+func runExitCode() int {
+	imported, failed, authErrors, unavailableErrors := 0, unknownTenantRows, 0, 0
+	for _, t := range allTenants() {
+		imported += t.imported
+		authErrors += t.authErrors
+		unavailableErrors += t.unavailableErrors
+		for _, status := range t.statuses {
+			failed += status.Failed
+		}
+	}
+	switch {
+	case imported == 0 && authErrors > 0:
+		return exitConfigError
+	case imported == 0 && unavailableErrors > 0:
+		return exitUnavailable
+	case failed > 0 || budget.isExceeded() || !committed:
+		return exitPartial
+	}
+	return exitOK
+}
+
+// printSummary writes a single-line machine-readable summary of the run to stderr:
+// SYNCMAVEN_SUMMARY {"status":"partial","exitCode":2,...}
+func printSummary(code int) {
+	if !streamStarted && code == exitOK {
+		return
+	}
+	summaryOnce.Do(func() { writeSummary(code) })
+}
+
+var summaryOnce sync.Once
+
+// runTotals sums up statuses of all tenants and events exported by Events stream
+func runTotals() Status {
+	total := *exportStatus
+	for _, t := range allTenants() {
+		for _, status := range t.statuses {
+			total.Received += status.Received
+			total.Success += status.Success
+			total.Skipped += status.Skipped
+			total.Failed += status.Failed
+		}
+	}
+	return total
+}
+
+func writeSummary(code int) {
+	summary := map[string]any{
+		"status":          exitStatuses[code],
+		"exitCode":        code,
+		"durationSeconds": time.Since(startTime).Seconds(),
+	}
+	if streamStarted {
+		total := runTotals()
+		summary["received"] = total.Received
+		summary["success"] = total.Success
+		summary["skipped"] = total.Skipped
+		summary["failed"] = total.Failed
+	}
+	b, _ := json.Marshal(summary)
+	_, _ = fmt.Fprintln(os.Stderr, "SYNCMAVEN_SUMMARY "+string(b))
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Events stream is the source mode of the connector. Raw events of the project are exported with Raw Export API
+// day by day and emitted to the host as rows. The last exported day is kept in state, so subsequent runs export
+// only new days. Today is not exported, because its events are still arriving
+
+const (
+	exportEndpoint   = "https://data.mixpanel.com/api/2.0/export"
+	exportEndpointEU = "https://data-eu.mixpanel.com/api/2.0/export"
+	// exportTimeout bounds export of a single day
+	exportTimeout = 10 * time.Minute
+	// exportAttempts is the number of attempts of rate limited or failed export requests
+	exportAttempts = 3
+)
+
+// exportStatus counts events of Events stream
+var exportStatus = &Status{}
+
+// exportState is saved to state after each exported day and sent with checkpoint reply
+type exportState struct {
+	LastDate string `json:"lastDate"`
+}
+
+func exportStateKey() []string {
+	return []string{"syncId=" + syncId, "type=mixpanel.export"}
+}
+
+// exportError is an export failure. auth is set if Mixpanel rejected the API secret
+type exportError struct {
+	err  error
+	auth bool
+}
+
+func (e *exportError) Error() string {
+	return e.err.Error()
+}
+
+// runExport exports events of days since the last exported day up to yesterday and exits
+func runExport(apiSecret string, residency string, fullRefresh bool) {
+	if apiSecret == "" {
+		session.Error("apiSecret is required for Events stream")
+		_ = session.Reply("halt", map[string]any{
+			"message": "apiSecret is required for Events stream",
+		})
+		exit(exitConfigError)
+	}
+	endpoint := exportEndpoint
+	if residency == "EU" {
+		endpoint = exportEndpointEU
+	}
+	from, to, err := exportRange(fullRefresh)
+	if err != nil {
+		session.Error("Cannot load export state", err.Error())
+		_ = session.Reply("halt", map[string]any{
+			"message": err.Error(),
+		})
+		exit(exitError)
+	}
+	client := &http.Client{Transport: &taggingTransport{base: baseTransport}}
+	session.Info(fmt.Sprintf("Exporting events from %s to %s", from.Format(time.DateOnly), to.Format(time.DateOnly)))
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		n, err := exportDay(client, endpoint, apiSecret, date)
+		if err != nil {
+			session.Error(fmt.Sprintf("[%s] export failed", date), err.Error())
+			_ = session.Reply("halt", map[string]any{
+				"message": fmt.Sprintf("export of %s failed: %s", date, err.Error()),
+			})
+			var ee *exportError
+			if errors.As(err, &ee) && ee.auth {
+				exit(exitConfigError)
+			}
+			exit(exitUnavailable)
+		}
+		state := exportState{LastDate: date}
+		// overridden range is a restatement, it doesn't move the state
+		if startDate.IsZero() && endDate.IsZero() {
+			if err = rpcClient.Set(exportStateKey(), state); err != nil {
+				// the day will be exported again by the next run
+				session.Warn("Cannot save export state", err.Error())
+			}
+		}
+		_ = session.Reply("checkpoint", map[string]any{"stream": streamEvents, "rows": exportStatus.Received, "state": state})
+		session.Info(fmt.Sprintf("[%s] %d events exported", date, n))
+	}
+	_ = session.Reply("stream-result", exportStatus)
+	streamEnded = true
+	exit(exitOK)
+}
+
+// exportRange returns days to export: from the day after the last exported one, or initialSyncDays ago
+// on the first run, to yesterday. startDate and endDate of start-stream override the range
+func exportRange(fullRefresh bool) (time.Time, time.Time, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to := today.AddDate(0, 0, -1)
+	if !endDate.IsZero() && endDate.Before(to) {
+		to = endDate
+	}
+	from := today.AddDate(0, 0, -initialSyncDays)
+	if !startDate.IsZero() {
+		return startDate, to, nil
+	}
+	if fullRefresh {
+		return from, to, nil
+	}
+	raw, err := rpcClient.Get(exportStateKey())
+	if err != nil {
+		return from, to, err
+	}
+	var state exportState
+	if b, _ := json.Marshal(raw); b != nil {
+		_ = json.Unmarshal(b, &state)
+	}
+	if state.LastDate != "" {
+		lastDate, err := time.Parse(time.DateOnly, state.LastDate)
+		if err != nil {
+			return from, to, fmt.Errorf("invalid lastDate in export state: %w", err)
+		}
+		from = lastDate.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+// exportDay emits events of the day. Rate limited and failed requests are retried unless rows were already emitted
+func exportDay(client *http.Client, endpoint string, apiSecret string, date string) (int, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var n int
+		var retryAfter time.Duration
+		n, retryAfter, err = exportRequest(client, endpoint, apiSecret, date)
+		if err == nil || n > 0 || retryAfter == 0 || attempt == exportAttempts {
+			return n, err
+		}
+		if budgetErr := retryBudget.Reserve(retryAfter); budgetErr != nil {
+			return n, fmt.Errorf("%w, last error: %w", budgetErr, err)
+		}
+		session.Warn(fmt.Sprintf("[%s] export failed, retrying in %s", date, retryAfter), err.Error())
+		time.Sleep(retryAfter)
+	}
+}
+
+// exportRequest streams events of the day. Returns delay before retry if the request may be retried
+func exportRequest(client *http.Client, endpoint string, apiSecret string, date string) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	query := url.Values{"from_date": {date}, "to_date": {date}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.SetBasicAuth(apiSecret, "")
+	req.Header.Set("Accept", "text/plain")
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, 10 * time.Second, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		err = fmt.Errorf("status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+		switch {
+		case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+			return 0, 0, &exportError{err: err, auth: true}
+		case res.StatusCode == http.StatusTooManyRequests:
+			retryAfter := time.Minute
+			if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+			return 0, retryAfter, &exportError{err: err}
+		case res.StatusCode >= 500:
+			return 0, 10 * time.Second, &exportError{err: err}
+		}
+		return 0, 0, &exportError{err: err}
+	}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	n := 0
+	for {
+		var event exportedEvent
+		err = decoder.Decode(&event)
+		if err == io.EOF {
+			return n, 0, nil
+		}
+		if err != nil {
+			return n, 0, fmt.Errorf("error reading export after %d events: %w", n, err)
+		}
+		exportStatus.Received++
+		exportStatus.Success++
+		_ = session.Reply("row", map[string]any{"row": event.row()})
+		n++
+	}
+}
+
+// exportedEvent is a line of Raw Export API response
+type exportedEvent struct {
+	Event      string         `json:"event"`
+	Properties map[string]any `json:"properties"`
+}
+
+// row converts the event to a row of Events stream. distinct_id, time and $insert_id are moved from properties to
+// columns, time is converted to RFC 3339 timestamp
+func (e *exportedEvent) row() map[string]any {
+	properties := make(map[string]any, len(e.Properties))
+	for name, value := range e.Properties {
+		properties[name] = value
+	}
+	row := map[string]any{
+		"event":       e.Event,
+		"distinct_id": properties["distinct_id"],
+		"insert_id":   properties["$insert_id"],
+		"time":        nil,
+		"properties":  properties,
+	}
+	delete(properties, "distinct_id")
+	delete(properties, "$insert_id")
+	if t, ok := properties["time"].(json.Number); ok {
+		if ts, err := t.Int64(); err == nil {
+			// time is in seconds, but projects with millisecond precision report milliseconds
+			if ts > 1e11 {
+				row["time"] = time.UnixMilli(ts).UTC().Format(time.RFC3339Nano)
+			} else {
+				row["time"] = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+			}
+			delete(properties, "time")
+		}
+	}
+	return row
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+func TestExportRequest(t *testing.T) {
+	var out bytes.Buffer
+	stdout.ReplyWriter = sdk.NewReplyWriter(&out)
+	defer func() { stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard); exportStatus = &Status{} }()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"Unable to authenticate request"}`))
+			return
+		}
+		if r.URL.Query().Get("from_date") == "2025-09-02" {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"event":"Signup","properties":{"time":1756684800,"distinct_id":"u1","$insert_id":"i1","plan":"pro"}}
+{"event":"Login","properties":{"time":1756684800123,"distinct_id":"u2"}}
+`))
+	}))
+	defer srv.Close()
+	client := srv.Client()
+
+	n, _, err := exportRequest(client, srv.URL, "secret", "2025-09-01")
+	if err != nil || n != 2 {
+		t.Fatalf("exportRequest() = %d, %v", n, err)
+	}
+	var first struct {
+		Payload struct {
+			Row map[string]any `json:"row"`
+		} `json:"payload"`
+	}
+	if err = json.Unmarshal([]byte(strings.SplitN(out.String(), "\n", 2)[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"event": "Signup", "distinct_id": "u1", "insert_id": "i1", "time": "2025-09-01T00:00:00Z", "properties": map[string]any{"plan": "pro"}}
+	if b, w := mustJson(first.Payload.Row), mustJson(want); b != w {
+		t.Errorf("row = %s, want %s", b, w)
+	}
+	if !strings.Contains(out.String(), `"time":"2025-09-01T00:00:00.123Z"`) {
+		t.Errorf("millisecond time is not converted: %s", out.String())
+	}
+
+	_, retryAfter, err := exportRequest(client, srv.URL, "secret", "2025-09-02")
+	if err == nil || retryAfter != 5*time.Second {
+		t.Errorf("expected rate limit error with retry after 5s, got %v %v", retryAfter, err)
+	}
+	_, retryAfter, err = exportRequest(client, srv.URL, "wrong", "2025-09-01")
+	var ee *exportError
+	if !errors.As(err, &ee) || !ee.auth || retryAfter != 0 {
+		t.Errorf("expected auth error, got %v %v", retryAfter, err)
+	}
+}
+
+func mustJson(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Feature flags are sent by the host in start-stream to roll out experimental behaviors sync by sync. A flag changes
+// the default of the credentials option with the same name, options set in credentials take precedence.
+// Supported flags:
+//   - adaptiveConcurrency (bool), see concurrency.go
+//   - insertIdStrategy (string), see insertid.go
+var featureFlags sdk.FeatureFlags
+
+// boolOption returns boolean credentials option, or the feature flag if the option isn't set
+func boolOption(creds map[string]any, name string) bool {
+	if v, ok := creds[name].(bool); ok {
+		return v
+	}
+	return featureFlags.Bool(name, false)
+}
+
+// stringOption returns string credentials option, or the feature flag if the option isn't set
+func stringOption(creds map[string]any, name string) string {
+	if v, _ := creds[name].(string); v != "" {
+		return v
+	}
+	return featureFlags.String(name, "")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// crashProtectionWindow closes the double-send window after an unclean exit: batch imported, but the process died
+// before state was saved, so the next run sends the batch again. Fingerprint of each batch (hash of its insert ids)
+// is saved to state before import, and the leading batch of a run started within the window after such exit is
+// skipped if it has the same fingerprint. The fingerprint is deleted when the run finishes cleanly. Zero disables
+var crashProtectionWindow time.Duration
+
+func (t *tenant) lastBatchKey() []string {
+	key := []string{"syncId=" + syncId, "type=mixpanel.lastbatch"}
+	if t.key != "" {
+		key = append(key, "tenant="+t.key)
+	}
+	return key
+}
+
+// batchFingerprint hashes insert ids of the batch regardless of their order
+func batchFingerprint(insertIds []string) string {
+	ids := append([]string{}, insertIds...)
+	sort.Strings(ids)
+	h := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+	return hex.EncodeToString(h[:])
+}
+
+// loadLastBatch reads fingerprint of the batch left by unclean exit of the previous run, if it's within the window
+func (t *tenant) loadLastBatch() {
+	if crashProtectionWindow <= 0 {
+		return
+	}
+	raw, err := rpcClient.Get(t.lastBatchKey())
+	if err != nil {
+		session.Error("Error getting last batch fingerprint", err.Error())
+		return
+	}
+	value, _ := raw.(map[string]any)
+	fingerprint, _ := value["fingerprint"].(string)
+	savedAt, _ := value["savedAt"].(string)
+	if fingerprint == "" {
+		return
+	}
+	if at, err := time.Parse(time.RFC3339, savedAt); err != nil || time.Since(at) > crashProtectionWindow {
+		session.Debug("Last batch fingerprint is out of crash protection window", savedAt)
+		return
+	}
+	message := fmt.Sprintf("Previous run didn't finish cleanly at %s. Leading batch will be skipped if it was already sent", savedAt)
+	if t.key != "" {
+		message = fmt.Sprintf("[%s] %s", t.key, message)
+	}
+	session.Warn(message)
+	t.lastBatch = fingerprint
+}
+
+// isResentBatch checks whether the batch is the leading batch of the run and was the last batch of unclean run
+func (t *tenant) isResentBatch(insertIds []string) bool {
+	if t.lastBatch == "" {
+		return false
+	}
+	fingerprint := t.lastBatch
+	// only the leading batch may repeat the last one
+	t.lastBatch = ""
+	return batchFingerprint(insertIds) == fingerprint
+}
+
+// saveLastBatch saves fingerprint of the batch before import
+func (t *tenant) saveLastBatch(insertIds []string) {
+	if crashProtectionWindow <= 0 {
+		return
+	}
+	err := rpcClient.Set(t.lastBatchKey(), map[string]any{
+		"fingerprint": batchFingerprint(insertIds),
+		"savedAt":     time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		session.Error("Error saving last batch fingerprint", err.Error())
+		return
+	}
+	t.lastBatchSaved = true
+}
+
+// clearLastBatch deletes the fingerprint when the tenant finished cleanly
+func (t *tenant) clearLastBatch() {
+	if !t.lastBatchSaved {
+		return
+	}
+	if err := rpcClient.Del(t.lastBatchKey()); err != nil {
+		session.Error("Error deleting last batch fingerprint", err.Error())
+	}
+	t.lastBatchSaved = false
+}
@@ -4,6 +4,17 @@ go 1.22
 
 require github.com/mixpanel/mixpanel-go v1.2.1
 
-require github.com/felixenescu/date-range v1.0.0
-
 require github.com/mitchellh/mapstructure v1.5.0
+
+require github.com/jitsucom/syncmaven/connector-sdk v0.0.0
+
+replace github.com/jitsucom/syncmaven/connector-sdk => ../../connector-sdk
+
+require github.com/jitsucom/syncmaven/schemas v0.0.0
+
+require (
+	github.com/klauspost/compress v1.16.7 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/jitsucom/syncmaven/schemas => ../../schemas
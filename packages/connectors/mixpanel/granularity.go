@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Granularity of ad data. With hour granularity rows are hourly metrics: date column holds date and hour,
+// or hour is taken from the hour column. Events, insert ids, statuses and state are per hour
+const (
+	granularityDay  = "day"
+	granularityHour = "hour"
+)
+
+var granularity = granularityDay
+
+// hourLayout is the format of hours in insert ids, statuses and dedup keys. Mixpanel doesn't allow colons in $insert_id
+const hourLayout = "2006-01-02T15"
+
+// hourInputLayouts are accepted formats of date column with hour granularity, besides RFC 3339
+var hourInputLayouts = []string{hourLayout, "2006-01-02T15:04", "2006-01-02T15:04:05", "2006-01-02 15", "2006-01-02 15:04", "2006-01-02 15:04:05"}
+
+func configureGranularity(value string) error {
+	switch value {
+	case "", granularityDay:
+		granularity = granularityDay
+	case granularityHour:
+		granularity = granularityHour
+	default:
+		return fmt.Errorf("unknown granularity '%s'. Supported: day, hour", value)
+	}
+	return nil
+}
+
+// periodDuration is the length of a period of the granularity
+func periodDuration() time.Duration {
+	if granularity == granularityHour {
+		return time.Hour
+	}
+	return time.Hour * 24
+}
+
+// truncatePeriod returns start of the period containing t in UTC
+func truncatePeriod(t time.Time) time.Time {
+	t = t.UTC()
+	if granularity == granularityHour {
+		return t.Truncate(time.Hour)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// parsePeriod parses date or hour of normalized row
+func parsePeriod(s string) (time.Time, error) {
+	if granularity == granularityHour {
+		return time.Parse(hourLayout, s)
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+// normalizeHour replaces date column of the row with the hour in hourLayout. Hour is taken from the date column
+// if it contains time, otherwise from the hour column (0-23). Time with offset is converted to UTC
+func normalizeHour(row map[string]any) error {
+	date, _ := row["date"].(string)
+	if date == "" {
+		return nil
+	}
+	if !strings.ContainsAny(date, "T ") {
+		day, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return fmt.Errorf("invalid date: %s", date)
+		}
+		hour, ok := columnInt(row["hour"])
+		if !ok || hour < 0 || hour > 23 {
+			return fmt.Errorf("hour is missing or invalid: date %s, hour %v", date, row["hour"])
+		}
+		row["date"] = day.Add(time.Hour * time.Duration(hour)).Format(hourLayout)
+		delete(row, "hour")
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, date)
+	for _, layout := range hourInputLayouts {
+		if err == nil {
+			break
+		}
+		t, err = time.Parse(layout, date)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid date and hour: %s", date)
+	}
+	row["date"] = t.UTC().Truncate(time.Hour).Format(hourLayout)
+	delete(row, "hour")
+	return nil
+}
+
+// columnInt converts integer column value delivered as number or string
+func columnInt(value any) (int, bool) {
+	if value == nil {
+		return 0, false
+	}
+	s, _ := canonicalString(value)
+	i, err := strconv.Atoi(s)
+	return i, err == nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Policies of handling rows received but not sent yet when the host halts the stream, e.g. user cancelled the sync
+const (
+	// haltFlush sends received rows before exit
+	haltFlush = "flush"
+	// haltDiscard drops rows that were not sent yet
+	haltDiscard = "discard"
+)
+
+var haltPolicy = haltFlush
+
+func configureHaltPolicy(policy string) error {
+	switch policy {
+	case "":
+	case haltFlush, haltDiscard:
+		haltPolicy = policy
+	default:
+		return fmt.Errorf("unknown halt policy: %s", policy)
+	}
+	return nil
+}
+
+// handleHalt stops the stream on halt received from the host:
+//
+//	{"type":"halt","payload":{"reason":"cancelled by user","policy":"discard"}}
+//
+// Rows are flushed or discarded according to the policy (haltPolicy option by default), state of sent days
+// is saved and stream-result with "cancelled" status is replied before exit with exitCancelled code, see endRun
+func handleHalt(payload sdk.HaltPayload) {
+	reason := payload.Reason
+	policy := haltPolicy
+	if payload.Policy != "" {
+		policy = payload.Policy
+	}
+	if policy != haltFlush && policy != haltDiscard {
+		session.Warn(fmt.Sprintf("Unknown halt policy: %s. Using %s", policy, haltPolicy))
+		policy = haltPolicy
+	}
+	session.Info(fmt.Sprintf("Received halt message. Reason: %s Policy: %s", reason, policy))
+	if !streamStarted || streamEnded {
+		exit(exitCancelled)
+	}
+	var extra map[string]any
+	if reason != "" {
+		extra = map[string]any{"reason": reason}
+	}
+	endRun("cancelled", policy == haltDiscard, extra, exitCancelled)
+}
+
+// endRun ends the run that is halted, stopped by a signal or maxRuntimeMinutes, rate limited or failed
+// the quality budget. Tenants are stopped (see stopTenants), then lineage and stream-result with the status
+// and extra fields are replied before exit with exitCode. The run didn't send all rows, so the result is partial.
+// It's resumable unless the run is atomic: the next run sends the days that were not saved to state
+func endRun(status string, discard bool, extra map[string]any, exitCode int) {
+	stopTenants(discard)
+	replyLineage()
+	result := streamResult()
+	result["status"] = status
+	result["partial"] = true
+	result["resumable"] = !atomicRun
+	for k, v := range extra {
+		result[k] = v
+	}
+	_ = session.Reply("stream-result", result)
+	streamEnded = true
+	exit(exitCode)
+}
+
+// tenantsFinished is set once tenant workers are finished by end-stream or stopTenants
+var tenantsFinished = false
+
+// stopTenants finishes tenant workers before end-stream. Queued rows are sent or, if discard is true, dropped.
+// State of sent days is saved, unless the run is atomic: atomic run that ends early is rolled back.
+// The last received day may be incomplete, so it's not marked processed and is sent again by the next run
+func stopTenants(discard bool) {
+	if atomicRun {
+		committed = false
+	}
+	// rows held back by pre-flight check were never confirmed to be sent
+	preflightPending = false
+	preflightRows = nil
+	if tenantsFinished {
+		return
+	}
+	tenantsFinished = true
+	for _, t := range allTenants() {
+		if discard {
+			t.cancel()
+		}
+		t.finish()
+		t.forgetIncompleteDay()
+		if !atomicRun {
+			t.saveState()
+		}
+	}
+}
+
+// cancel makes the worker drop queued rows and the unsent batch
+func (t *tenant) cancel() {
+	close(t.cancelled)
+}
+
+func (t *tenant) isCancelled() bool {
+	select {
+	case <-t.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// discardBatch drops events that were not sent. Must be called by the worker
+func (t *tenant) discardBatch() {
+	if len(t.batch) > 0 {
+		session.Info(fmt.Sprintf("%s %d events discarded", t.logPrefix(), len(t.batch)))
+		t.currentStatus.Skipped += len(t.batch)
+		t.batch = nil
+		t.batchInsertIds = nil
+		t.batchKeys = nil
+	}
+	if len(t.profiles) > 0 {
+		session.Info(fmt.Sprintf("%s %d profiles discarded", t.logPrefix(), len(t.profiles)))
+		t.getStatus(profilesStatusKey).Skipped += len(t.profiles)
+		t.profiles = nil
+	}
+}
+
+// forgetIncompleteDay removes the last received day from processed ranges. Must be called after the worker finished
+func (t *tenant) forgetIncompleteDay() {
+	if t.lastProcessedDate == "" {
+		return
+	}
+	day, err := parsePeriod(t.lastProcessedDate)
+	if err != nil || t.initialState.contains(day) {
+		return
+	}
+	t.processedRanges = t.processedRanges.remove(day)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// runUntilExit calls fn and returns the exit code the run ended with
+func runUntilExit(t *testing.T, fn func()) (code int) {
+	osExit = func(code int) { panic(code) }
+	defer func() { osExit = os.Exit }()
+	defer func() {
+		c, ok := recover().(int)
+		if !ok {
+			t.Fatal("the run didn't exit")
+		}
+		code = c
+	}()
+	fn()
+	return -1
+}
+
+func TestHalt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	rpcClient = sdk.NewRpcClient(srv.URL)
+	tests := []struct {
+		name      string
+		atomic    bool
+		resumable bool
+	}{
+		{name: "incremental", resumable: true},
+		{name: "atomic", atomic: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			stdout.ReplyWriter = sdk.NewReplyWriter(&out)
+			atomicRun, committed, streamStarted, streamEnded, tenantsFinished = test.atomic, true, true, false, false
+			defer func() {
+				atomicRun, committed, streamStarted, streamEnded, tenantsFinished = false, true, false, false, false
+			}()
+			tn := newTenant("", "token", "")
+			defaultTenant = tn
+			defer func() { defaultTenant = nil }()
+			tn.start()
+
+			code := runUntilExit(t, func() {
+				handleHalt(sdk.HaltPayload{Reason: "cancelled by user", Policy: haltDiscard})
+			})
+			if code != exitCancelled || !streamEnded {
+				t.Errorf("exit code = %d, want %d", code, exitCancelled)
+			}
+			var result map[string]any
+			for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+				var m struct {
+					Type    string         `json:"type"`
+					Payload map[string]any `json:"payload"`
+				}
+				if json.Unmarshal([]byte(line), &m) == nil && m.Type == "stream-result" {
+					result = m.Payload
+				}
+			}
+			if result["status"] != "cancelled" || result["reason"] != "cancelled by user" || result["partial"] != true || result["resumable"] != test.resumable {
+				t.Errorf("stream-result = %v", result)
+			}
+			if _, ok := result["committed"]; ok != test.atomic || (test.atomic && result["committed"] != false) {
+				t.Errorf("halted atomic run must not be committed: %v", result)
+			}
+		})
+	}
+}
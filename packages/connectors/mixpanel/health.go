@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// health serves /healthz and /readyz if HEALTH_ADDR is set, see sdk.Health. Results of imports tell whether Mixpanel
+// is reachable, /readyz requests Mixpanel API host itself only if there were no imports recently
+var health = newHealth()
+
+var apiHostMu sync.Mutex
+
+// apiHost is Mixpanel API host of the residency of the project
+var apiHost = "api.mixpanel.com"
+
+func newHealth() *sdk.Health {
+	h := sdk.NewHealth()
+	h.Check = checkApiHost
+	return h
+}
+
+func setApiHost(host string) {
+	apiHostMu.Lock()
+	defer apiHostMu.Unlock()
+	apiHost = host
+}
+
+func getApiHost() string {
+	apiHostMu.Lock()
+	defer apiHostMu.Unlock()
+	return apiHost
+}
+
+// checkApiHost requests Mixpanel API host through the proxy, if configured. Any HTTP response means it's reachable
+func checkApiHost(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, "https://"+getApiHost()+"/", nil)
+	if err != nil {
+		return err
+	}
+	res, err := (&http.Client{Transport: proxyTransport}).Do(req)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
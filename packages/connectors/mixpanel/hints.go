@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// ColumnHint is an optional type annotation of a row column that host may send in 'columnTypes' block
+// of a row message, e.g. {"cost": {"type": "decimal", "scale": 2, "currency": "USD"}}.
+// Hints are sticky: they apply to all subsequent rows until a new block is received.
+type ColumnHint struct {
+	// Type is a warehouse agnostic column type: decimal, integer, float, string, timestamp, date, boolean
+	Type string `json:"type"`
+	// Scale is number of digits after decimal point of decimal columns
+	Scale *int `json:"scale,omitempty"`
+	// Precision is number of fractional second digits of timestamp columns
+	Precision *int `json:"precision,omitempty"`
+	// Currency is ISO 4217 code of monetary columns
+	Currency string `json:"currency,omitempty"`
+}
+
+var columnHints = make(map[string]ColumnHint)
+
+func setColumnHints(hints map[string]ColumnHint) {
+	if len(hints) > 0 {
+		columnHints = hints
+	}
+}
+
+// applyColumnHints rounds decimal metric columns to the declared scale. Metrics are decoded as json.Number,
+// so the value is exact until this point and stays exact after rounding.
+func applyColumnHints(row map[string]any) {
+	for _, col := range metricColumns {
+		hint, ok := columnHints[col]
+		if !ok || hint.Type != "decimal" || hint.Scale == nil {
+			continue
+		}
+		if r, ok := toDecimal(row[col]); ok {
+			row[col] = json.Number(roundDecimal(r, *hint.Scale, costRounding).FloatString(*hint.Scale))
+		}
+	}
+}
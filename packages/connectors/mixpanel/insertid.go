@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Insert id strategies. Mixpanel accepts $insert_id up to 36 alphanumeric characters (and dashes)
+const (
+	// insertIdMd5 is the legacy strategy: key fields joined with dashes, or truncated MD5 if the key is too long.
+	// Ids are formatted as before they were normalized, see legacyIds
+	insertIdMd5 = "md5"
+	// insertIdSha256 is a 36 characters prefix of hex encoded SHA-256 of the key fields
+	insertIdSha256 = "sha256"
+	// insertIdUuidV5 is a name based UUID (RFC 4122) of the key fields on insertIdNamespace
+	insertIdUuidV5 = "uuidv5"
+	// insertIdColumn takes id as is from the insertIdColumnName column of the row
+	insertIdColumn = "column"
+)
+
+// defaultInsertIdNamespace is used for uuidv5 strategy when no namespace is configured
+var defaultInsertIdNamespace = uuidV5(uuidNamespaceURL, "https://syncmaven.sh/connectors/mixpanel")
+
+var insertIdStrategy = insertIdMd5
+var insertIdNamespace = defaultInsertIdNamespace
+var insertIdColumnName string
+
+// uuidNamespaceURL is the predefined RFC 4122 namespace for URLs
+var uuidNamespaceURL = [16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+func configureInsertId(strategy, namespace, column string) error {
+	switch strategy {
+	case "", insertIdMd5:
+		insertIdStrategy = insertIdMd5
+	case insertIdSha256:
+		insertIdStrategy = strategy
+	case insertIdUuidV5:
+		insertIdStrategy = strategy
+		if namespace != "" {
+			ns, err := parseUuid(namespace)
+			if err != nil {
+				return fmt.Errorf("invalid insertIdNamespace: %v", err)
+			}
+			insertIdNamespace = ns
+		}
+	case insertIdColumn:
+		if column == "" {
+			return fmt.Errorf("insertIdColumn is required for '%s' insert id strategy", insertIdColumn)
+		}
+		insertIdStrategy = strategy
+		insertIdColumnName = column
+	default:
+		return fmt.Errorf("unknown insert id strategy: %s", strategy)
+	}
+	return nil
+}
+
+// makeInsertId returns $insert_id for the row according to the configured strategy.
+// For 'column' strategy rows with an empty id column fall back to the legacy md5 strategy.
+func makeInsertId(payload *RowPayload) string {
+	switch insertIdStrategy {
+	case insertIdColumn:
+		if payload.InsertId == "" {
+			return makeMd5InsertId(payload)
+		}
+		if len(payload.InsertId) > 36 {
+			return makeSha256InsertId(payload.InsertId)
+		}
+		return payload.InsertId
+	case insertIdSha256:
+		return makeSha256InsertId(insertIdKey(payload))
+	case insertIdUuidV5:
+		return formatUuid(uuidV5(insertIdNamespace, insertIdKey(payload)))
+	default:
+		return makeMd5InsertId(payload)
+	}
+}
+
+func insertIdKey(payload *RowPayload) string {
+	builder := strings.Builder{}
+	builder.WriteString(sourcePrefix(payload.Source))
+	builder.WriteString("-")
+	builder.WriteString(payload.Date)
+	builder.WriteString("-")
+	builder.WriteString(payload.CampaignId)
+	if payload.GroupId != "" {
+		builder.WriteString("-")
+		builder.WriteString(payload.GroupId)
+	}
+	if payload.AdId != "" {
+		builder.WriteString("-")
+		builder.WriteString(payload.AdId)
+	}
+	return builder.String()
+}
+
+// sourcePrefix returns the upper-cased first letter of the source, or "X" if source is empty
+func sourcePrefix(source string) string {
+	for _, r := range source {
+		return strings.ToUpper(string(r))
+	}
+	return "X"
+}
+
+func makeMd5InsertId(payload *RowPayload) string {
+	key := insertIdKey(payload)
+	if payload.LegacyIds != "" {
+		key = sourcePrefix(payload.Source) + "-" + payload.Date + "-" + payload.LegacyIds
+	}
+	if len(key) > 36 {
+		sum := md5.Sum([]byte(key))
+		// 23 hex digits with dates, hours are 3 characters longer
+		prefix := sourcePrefix(payload.Source) + "-" + payload.Date + "-"
+		return prefix + hex.EncodeToString(sum[:])[0:36-len(prefix)]
+	}
+	return key
+}
+
+func makeSha256InsertId(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])[0:36]
+}
+
+func uuidV5(namespace [16]byte, name string) [16]byte {
+	hasher := sha1.New()
+	hasher.Write(namespace[:])
+	hasher.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], hasher.Sum(nil))
+	u[6] = (u[6] & 0x0f) | 0x50
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+func formatUuid(u [16]byte) string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func parseUuid(s string) ([16]byte, error) {
+	var u [16]byte
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return u, err
+	}
+	if len(b) != 16 {
+		return u, fmt.Errorf("expected 16 bytes uuid, got %d bytes", len(b))
+	}
+	copy(u[:], b)
+	return u, nil
+}
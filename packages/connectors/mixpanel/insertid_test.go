@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestMakeInsertId(t *testing.T) {
+	defer func() { insertIdStrategy = insertIdMd5 }()
+	short := &RowPayload{Source: "google", Date: "2024-01-01", CampaignId: "123"}
+	long := &RowPayload{Source: "google", Date: "2024-01-01", CampaignId: "123456789012", GroupId: "123456789012", AdId: "123456789012"}
+	tests := []struct {
+		strategy string
+		payload  *RowPayload
+		want     string
+	}{
+		{strategy: insertIdMd5, payload: short, want: "G-2024-01-01-123"},
+		{strategy: insertIdMd5, payload: long, want: "G-2024-01-01-a8c1ab8e20512a99642877b"},
+		{strategy: insertIdMd5, payload: &RowPayload{Source: "google", Date: "2024-01-01T13", CampaignId: "123456789012", GroupId: "123456789012"}, want: "G-2024-01-01T13-5da8fbd8c250b3edd84d"},
+		{strategy: insertIdSha256, payload: short, want: "acd1e82079939ce1410b2ca2957440c8ad50"},
+		{strategy: insertIdUuidV5, payload: short, want: "23facd14-6517-53ed-8731-2bed060c4516"},
+		{strategy: insertIdColumn, payload: &RowPayload{InsertId: "custom-id"}, want: "custom-id"},
+		{strategy: insertIdColumn, payload: short, want: "G-2024-01-01-123"},
+	}
+	for _, tt := range tests {
+		insertIdStrategy = tt.strategy
+		if got := makeInsertId(tt.payload); got != tt.want {
+			t.Errorf("%s: makeInsertId() = %s, want %s", tt.strategy, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultInsertIdMatchesBaseline(t *testing.T) {
+	if err := configureInsertId("", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// ids of the connector before insert id strategies were added: numbers were decoded as float64
+	tests := []struct {
+		row  string
+		want string
+	}{
+		{row: `{"date":"2024-01-01","source":"facebook","campaign_id":1234567}`, want: "F-2024-01-01-1.234567e+06"},
+		{row: `{"date":"2024-01-01","source":"google","campaign_id":123,"group_id":45}`, want: "G-2024-01-01-123-45"},
+		{row: `{"date":"2024-01-01","source":"facebook","campaign_id":1234567,"group_id":89,"ad_id":12345678901}`, want: "F-2024-01-01-95678314f175003c15e1e04"},
+		{row: `{"date":"2024-01-01","source":"google","campaign_id":1.5,"group_id":0.1}`, want: "G-2024-01-01-1.5-0.1"},
+		{row: `{"date":"2024-01-01","source":"google","campaign_id":12345678901234567890,"group_id":2.5e-7,"ad_id":1e21}`, want: "G-2024-01-01-74ececc9a316f81b6c00183"},
+		{row: `{"date":"2024-01-01","source":"tiktok","campaign_id":"cmp-1","group_id":"","ad_id":null}`, want: "T-2024-01-01-cmp-1-"},
+		{row: `{"date":"2024-01-01","source":"tiktok","campaign_id":"0123456789","group_id":"0123456789","ad_id":"0123456789"}`, want: "T-2024-01-01-b68104d45f5f7feac689c48"},
+	}
+	for _, tt := range tests {
+		if got := makeInsertId(handledPayload(t, tt.row)); got != tt.want {
+			t.Errorf("insert id of %s = %s, want %s", tt.row, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRow(t *testing.T) {
+	tests := []struct {
+		payload *RowPayload
+		want    string
+	}{
+		{payload: &RowPayload{Date: "2024-01-01", Source: "google", CampaignId: "123"}},
+		{payload: &RowPayload{Date: "2024-01-01", CampaignId: "123"}, want: "required fields are missing: source"},
+		{payload: &RowPayload{Source: "google", CampaignId: "123"}, want: "required fields are missing: date"},
+		{payload: &RowPayload{Date: "2024-01-01", Source: "google"}, want: "required fields are missing: campaign_id"},
+		{payload: &RowPayload{}, want: "required fields are missing: date, source, campaign_id"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if err := validateRow(tt.payload); err != nil {
+			got = err.Error()
+		}
+		if got != tt.want {
+			t.Errorf("validateRow(%+v) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+	// insert id of a row without source must not panic
+	if id := makeInsertId(&RowPayload{Date: "2024-01-01", CampaignId: "123"}); id == "" {
+		t.Error("empty insert id")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policies of handling values over Mixpanel limits
+const (
+	// limitTruncate truncates long strings and drops custom properties over the count limit
+	limitTruncate = "truncate"
+	// limitDrop drops properties with long strings and custom properties over the count limit
+	limitDrop = "drop"
+	// limitSkip fails rows exceeding limits
+	limitSkip = "skip"
+)
+
+// Mixpanel rejects events with more properties or longer string values
+var maxProperties = 255
+var maxStringLength = 255
+var limitPolicy = limitTruncate
+
+// requiredProperties are never dropped to fit the property count limit
+var requiredProperties = map[string]bool{"$insert_id": true, "time": true, "distinct_id": true, "token": true, "$ad_platform": true, "$ad_cost": true}
+
+func configureLimits(policy string, rMaxProperties, rMaxStringLength int) error {
+	switch policy {
+	case "":
+	case limitTruncate, limitDrop, limitSkip:
+		limitPolicy = policy
+	default:
+		return fmt.Errorf("unknown limit policy: %s", policy)
+	}
+	if rMaxProperties > 0 {
+		maxProperties = rMaxProperties
+	}
+	if rMaxStringLength > 0 {
+		maxStringLength = rMaxStringLength
+	}
+	return nil
+}
+
+// enforceLimits adjusts properties of an event to fit Mixpanel limits according to limitPolicy,
+// so a single oversized row doesn't get the whole batch rejected.
+// Returns descriptions of adjustments made, or an error if the row must be skipped
+func enforceLimits(properties map[string]any) ([]string, error) {
+	var adjustments []string
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s, ok := properties[name].(string)
+		if !ok || utf8.RuneCountInString(s) <= maxStringLength {
+			continue
+		}
+		switch limitPolicy {
+		case limitSkip:
+			return nil, fmt.Errorf("property '%s' is longer than %d characters", name, maxStringLength)
+		case limitDrop:
+			delete(properties, name)
+			adjustments = append(adjustments, fmt.Sprintf("property '%s' longer than %d characters dropped", name, maxStringLength))
+		default:
+			properties[name] = truncateString(s, maxStringLength)
+			adjustments = append(adjustments, fmt.Sprintf("property '%s' truncated to %d characters", name, maxStringLength))
+		}
+	}
+	if len(properties) > maxProperties {
+		if limitPolicy == limitSkip {
+			return nil, fmt.Errorf("event has %d properties, maximum is %d", len(properties), maxProperties)
+		}
+		var dropped []string
+		// custom properties are dropped in reverse alphabetical order, so the same properties are dropped for every row
+		for i := len(names) - 1; i >= 0 && len(properties) > maxProperties; i-- {
+			name := names[i]
+			if _, ok := properties[name]; !ok || requiredProperties[name] || strings.HasPrefix(name, "$") {
+				continue
+			}
+			delete(properties, name)
+			dropped = append(dropped, name)
+		}
+		adjustments = append(adjustments, fmt.Sprintf("%d properties over the limit of %d dropped: %s", len(dropped), maxProperties, strings.Join(dropped, ", ")))
+	}
+	return adjustments, nil
+}
+
+// truncateString cuts s to n characters without breaking multibyte characters
+func truncateString(s string, n int) string {
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos]
+		}
+		i++
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnforceLimits(t *testing.T) {
+	defer func(p string, mp, ml int) { limitPolicy, maxProperties, maxStringLength = p, mp, ml }(limitPolicy, maxProperties, maxStringLength)
+	maxProperties, maxStringLength = 4, 3
+	newProperties := func() map[string]any {
+		return map[string]any{"$insert_id": "id", "$ad_cost": 1.0, "a": "ab", "b": "ёжик", "c": 2}
+	}
+
+	limitPolicy = limitTruncate
+	properties := newProperties()
+	adjustments, err := enforceLimits(properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(adjustments) != 2 || properties["b"] != "ёжи" || properties["c"] != nil || len(properties) != 4 {
+		t.Errorf("truncate: unexpected result %v %v", properties, adjustments)
+	}
+
+	limitPolicy = limitDrop
+	properties = newProperties()
+	if _, err = enforceLimits(properties); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := properties["b"]; ok || len(properties) != 4 {
+		t.Errorf("drop: unexpected result %v", properties)
+	}
+
+	limitPolicy = limitSkip
+	if _, err = enforceLimits(newProperties()); err == nil || !strings.Contains(err.Error(), "'b'") {
+		t.Errorf("skip: expected error, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// lineage records the mapping applied to rows of the stream. It's sent in "lineage" reply before stream-result
+var lineage = sdk.NewLineage("AdData", "mixpanel", "$ad_spend")
+
+// lineageSeen holds columns already recorded, so each column is looked at once per run
+var lineageSeen = make(map[string]bool)
+var lineageCurrency = false
+var lineageRoas = false
+
+// columnProperties maps row columns to $ad_spend properties
+var columnProperties = map[string]string{
+	"date":          "time",
+	"source":        "$ad_platform",
+	"campaign_id":   "campaign_id",
+	"cost":          "$ad_cost",
+	"clicks":        "$ad_clicks",
+	"impressions":   "$ad_impressions",
+	"conversions":   "conversions",
+	"group_id":      "ad_group_id",
+	"ad_id":         "ad_id",
+	"campaign_name": "campaign_name",
+	"utm_campaign":  "utm_campaign",
+	"utm_source":    "utm_source",
+	"utm_medium":    "utm_medium",
+	"utm_term":      "utm_term",
+	"utm_content":   "utm_content",
+
+	// revenue is an alias of conversion_value
+	"conversion_value": "conversion_value",
+	"revenue":          "conversion_value",
+}
+
+// insertIdColumns are parts of generated $insert_id
+var insertIdColumns = []string{"source", "date", "campaign_id", "group_id", "ad_id"}
+
+// recordLineage adds columns of the row that weren't seen before to lineage
+func recordLineage(row map[string]any, payload *RowPayload) {
+	var columns []string
+	for column, value := range row {
+		if value != nil && !lineageSeen[column] {
+			lineageSeen[column] = true
+			columns = append(columns, column)
+		}
+	}
+	// sorted, so sources of $insert_id are listed in the same order by every run
+	slices.Sort(columns)
+	for _, column := range columns {
+		recordColumnLineage(column)
+	}
+	if payload.Currency != "" && !lineageCurrency {
+		lineageCurrency = true
+		lineage.Map(propertyName("currency"), []string{"cost"}, "column_hint:currency")
+	}
+	if computeRoas && !lineageRoas {
+		if source := revenueColumn(row); source != "" {
+			lineageRoas = true
+			lineage.Map(propertyName("roas"), []string{source, "cost"}, "divide")
+		}
+	}
+}
+
+func recordColumnLineage(column string) {
+	if column == tenantColumn && tenantColumn != "" {
+		lineage.Drop(column, sdk.DropRouting)
+		return
+	}
+	if column == insertIdColumnName && insertIdStrategy == insertIdColumn {
+		lineage.Map("$insert_id", []string{column}, "insert_id:column")
+		return
+	}
+	property, ok := columnProperties[column]
+	if !ok {
+		if sendUnmapped {
+			lineage.Map(propertyName(naming.PropertyName(column)), []string{column})
+		} else {
+			lineage.Drop(column, sdk.DropUnmapped)
+		}
+		return
+	}
+	var transforms []string
+	switch {
+	case column == "date":
+		transforms = append(transforms, "date_to_timestamp:utc")
+	case column == "cost":
+		transforms = append(transforms, "decimal")
+		if costScale >= 0 {
+			transforms = append(transforms, fmt.Sprintf("round:%d:%s", costScale, costRounding))
+		}
+	case slices.Contains(metricColumns, column):
+		transforms = append(transforms, "number")
+	default:
+		transforms = append(transforms, "to_string")
+	}
+	if targetCurrency != "" && (property == "$ad_cost" || property == "conversion_value") {
+		transforms = append(transforms, "currency_conversion:"+targetCurrency)
+	}
+	lineage.Map(propertyName(property), []string{column}, transforms...)
+	if targetCurrency != "" && column == "cost" {
+		lineage.Map(propertyName("original_cost"), []string{column}, "decimal")
+		lineage.Map(propertyName("original_currency"), []string{column}, "column_hint:currency")
+	}
+	if slices.Contains(insertIdColumns, column) {
+		lineage.Map("$insert_id", []string{column}, "insert_id:"+insertIdStrategy)
+	}
+}
+
+// replyLineage sends lineage of the run. Columns removed by projection are reported as not selected.
+// Lineage describes $ad_spend mapping, so it's not sent for UserProfiles stream
+func replyLineage() {
+	if currentStream != streamAdData {
+		return
+	}
+	for _, column := range projection.Dropped() {
+		lineage.Drop(column, sdk.DropNotSelected)
+	}
+	_ = session.Reply("lineage", lineage.Payload())
+}
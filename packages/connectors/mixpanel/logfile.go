@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LOG_FILE env var enables writing logs to a file in addition to protocol log replies, so troubleshooting
+// doesn't require grepping logs out of the protocol stream. Each line is a JSON object:
+//
+//	{"time":"2024-05-01T10:00:00.123Z","level":"info","message":"...","params":[...]}
+//
+// File is rotated when it exceeds LOG_FILE_MAX_SIZE_MB (default 100): LOG_FILE is renamed to LOG_FILE.1,
+// LOG_FILE.1 to LOG_FILE.2 and so on. At most LOG_FILE_MAX_BACKUPS (default 3) rotated files are kept.
+const (
+	logFileEnv           = "LOG_FILE"
+	logFileMaxSizeEnv    = "LOG_FILE_MAX_SIZE_MB"
+	logFileMaxBackupsEnv = "LOG_FILE_MAX_BACKUPS"
+)
+
+// logSink is safe for concurrent use by tenant workers
+type logSink struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// logFile is nil unless LOG_FILE is set
+var logFile *logSink
+
+// openLogFile opens LOG_FILE for appending if the env var is set
+func openLogFile() error {
+	path := os.Getenv(logFileEnv)
+	if path == "" {
+		return nil
+	}
+	maxSizeMb, err := intEnv(logFileMaxSizeEnv, 100)
+	if err != nil {
+		return err
+	}
+	maxBackups, err := intEnv(logFileMaxBackupsEnv, 3)
+	if err != nil {
+		return err
+	}
+	sink := &logSink{path: path, maxSize: int64(maxSizeMb) * 1024 * 1024, maxBackups: maxBackups}
+	if err = sink.open(); err != nil {
+		return err
+	}
+	logFile = sink
+	return nil
+}
+
+func intEnv(name string, defaultValue int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, got: %s", name, s)
+	}
+	return n, nil
+}
+
+func (l *logSink) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open %s %s: %v", logFileEnv, l.path, err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.file = f
+	l.size = stat.Size()
+	return nil
+}
+
+func (l *logSink) write(level string, message string, params []any) {
+	entry := map[string]any{
+		"time":    time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		"level":   level,
+		"message": message,
+	}
+	if len(params) > 0 {
+		entry["params"] = params
+	}
+	if traceId != "" {
+		entry["traceId"] = traceId
+	}
+	data, _ := json.Marshal(entry)
+	data = append(data, '\n')
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		// if rotation fails keep writing to the current file rather than lose logs
+		_ = l.rotate()
+	}
+	n, _ := l.file.Write(data)
+	l.size += int64(n)
+}
+
+// rotate shifts backups and reopens the log file. Must be called with the lock held
+func (l *logSink) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+	if l.maxBackups == 0 {
+		_ = os.Remove(l.path)
+	} else {
+		_ = os.Remove(l.path + "." + strconv.Itoa(l.maxBackups))
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+		}
+		_ = os.Rename(l.path, l.path+".1")
+	}
+	return l.open()
+}
+
+func (l *logSink) close() {
+	l.Lock()
+	defer l.Unlock()
+	if l.file != nil {
+		_ = l.file.Close()
+		l.file = nil
+	}
+}
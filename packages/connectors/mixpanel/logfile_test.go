@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLogSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connector.log")
+	sink := &logSink{path: path, maxSize: 1024, maxBackups: 2}
+	if err := sink.open(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				sink.write("info", "Batch sent", []any{i})
+			}
+		}()
+	}
+	wg.Wait()
+	sink.close()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		stat, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if stat.Size() > 1024 {
+			t.Errorf("%s size = %d, want <= 1024", name, stat.Size())
+		}
+		f, _ := os.Open(name)
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var entry map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Errorf("%s: interleaved or broken line %q", name, scanner.Text())
+			} else if entry["level"] != "info" || entry["message"] != "Batch sent" || entry["time"] == nil {
+				t.Errorf("%s: unexpected entry %v", name, entry)
+			}
+		}
+		_ = f.Close()
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, got %s.3", path)
+	}
+}
@@ -1,41 +1,58 @@
 package main
 
 import (
-	"bufio"
-	"context"
-	"crypto"
+	"bytes"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
-	daterange "github.com/felixenescu/date-range"
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+	"github.com/jitsucom/syncmaven/schemas"
 	"github.com/mitchellh/mapstructure"
-	"github.com/mixpanel/mixpanel-go"
+	"io"
+	"math/big"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 //go:embed credentials.schema.json
 var credentialSchemaString string
-var credentialSchema = UnmarshalSchema(credentialSchemaString)
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
 
+// row.schema.json references canonical AdData schema, see schemas package
+//
 //go:embed row.schema.json
 var rowSchemaString string
-var rowSchema = UnmarshalSchema(rowSchemaString)
+var rowSchema = resolveSchema(sdk.UnmarshalSchema(rowSchemaString))
 
-type Message struct {
-	Type      string `json:"type"`
-	Direction string `json:"direction"`
-	Payload   any    `json:"payload"`
+//go:embed profile.schema.json
+var profileSchemaString string
+var profileSchema = sdk.UnmarshalSchema(profileSchemaString)
+
+//go:embed event.schema.json
+var eventSchemaString string
+var eventSchema = sdk.UnmarshalSchema(eventSchemaString)
+
+type RowMessage struct {
+	Row         map[string]any        `json:"row"`
+	ColumnTypes map[string]ColumnHint `json:"columnTypes,omitempty"`
+}
+
+// RowsMessage is a batched alternative to RowMessage that saves envelope overhead for high-volume syncs
+type RowsMessage struct {
+	Rows        []map[string]any      `json:"rows"`
+	ColumnTypes map[string]ColumnHint `json:"columnTypes,omitempty"`
 }
 
 type RowPayload struct {
 	Date         string  `mapstructure:"date"`
 	Source       string  `mapstructure:"source"`
-	CampaignId   any     `mapstructure:"campaign_id"`
+	CampaignId   string  `mapstructure:"campaign_id"`
 	CampaignName string  `mapstructure:"campaign_name"`
-	GroupId      any     `mapstructure:"group_id"`
-	AdId         any     `mapstructure:"ad_id"`
+	GroupId      string  `mapstructure:"group_id"`
+	AdId         string  `mapstructure:"ad_id"`
 	Cost         float64 `mapstructure:"cost"`
 	Clicks       float64 `mapstructure:"clicks"`
 	Impressions  float64 `mapstructure:"impressions"`
@@ -45,6 +62,19 @@ type RowPayload struct {
 	UtmMedium    string  `mapstructure:"utm_medium"`
 	UtmTerm      string  `mapstructure:"utm_term"`
 	UtmContent   string  `mapstructure:"utm_content"`
+	// CostDecimal is the exact cost value. Cost is downcast to float64 with costAmount()
+	CostDecimal *big.Rat `mapstructure:"-"`
+	// Unmapped are columns not mapped to $ad_spend properties. Sent only if propertyNameTemplate is configured
+	Unmapped map[string]any `mapstructure:"-"`
+	// Currency is taken from the cost column hint
+	Currency string `mapstructure:"-"`
+	// InsertId is taken from the insertIdColumn column when 'column' insert id strategy is used
+	InsertId string `mapstructure:"-"`
+	// LegacyIds are id columns formatted as before they were normalized, see legacyIds
+	LegacyIds string `mapstructure:"-"`
+	// ConversionValue and Revenue are nil if the row doesn't have the column, see conversionValue()
+	ConversionValue *float64 `mapstructure:"conversion_value"`
+	Revenue         *float64 `mapstructure:"revenue"`
 }
 
 type Status struct {
@@ -52,75 +82,247 @@ type Status struct {
 	Success  int `json:"success"`
 	Skipped  int `json:"skipped"`
 	Failed   int `json:"failed"`
+	Coerced  int `json:"coerced,omitempty"`
+	// Adjusted counts rows with properties truncated or dropped to fit Mixpanel limits
+	Adjusted int `json:"adjusted,omitempty"`
+	// ZeroSkipped counts rows skipped because all metrics are zero. They are not included in Skipped
+	ZeroSkipped int `json:"zeroSkipped,omitempty"`
+	// DedupSkipped counts rows skipped because the same event was already sent by a sync sharing dedupNamespace.
+	// They are included in Skipped
+	DedupSkipped int `json:"dedupSkipped,omitempty"`
+	// DeadLettered counts rows with event time outside of the window accepted by Mixpanel. They are added to
+	// dead-letter queue in state instead of being sent. They are not included in Skipped or Failed
+	DeadLettered int `json:"deadLettered,omitempty"`
+	// ResendSkipped counts rows of the leading batch skipped because it was the last batch of unclean previous run,
+	// see crashProtectionMinutes. They are included in Skipped
+	ResendSkipped int `json:"resendSkipped,omitempty"`
+	// BudgetSkipped counts rows skipped because maxEventsPerRun or maxBytesPerRun was exceeded. They are included in Skipped
+	BudgetSkipped int `json:"budgetSkipped,omitempty"`
+	// ErrorSamples contains first maxErrorSamples errors of failed rows
+	ErrorSamples []string `json:"errorSamples,omitempty"`
+}
+
+const maxErrorSamples = 10
+
+func (s *Status) addErrorSample(err string) {
+	if len(s.ErrorSamples) < maxErrorSamples {
+		s.ErrorSamples = append(s.ErrorSamples, err)
+	}
 }
 
 var lookbackWindow = 2
 var initialSyncDays = 30
+
+// startDate and endDate of start-stream override initialSyncDays and lookbackWindow. Zero means not set
+var startDate, endDate time.Time
 var batchSize = 2000
+var skipZeroRows = false
+
+// atomicRun commits state only if the whole run succeeds without failed rows
+var atomicRun = false
+
+// committed is false if state of atomic run was rolled back, or the run ended before end-stream
+var committed = true
+
+// strictImport makes Mixpanel report invalid events instead of silently dropping them
+var strictImport = false
+var maxEventsPerMinute = 0
+var maxDaysPerRun = 0
 var syncId string
-var stateKey []string
 
-var rpcClient = NewRpcClient(os.Getenv("RPC_URL"))
+// traceId of start-stream is added to logs and requests, so the run can be traced across systems
+var traceId string
+
+var rpcClient = sdk.NewRpcClient(os.Getenv(sdk.RpcEnv))
+
+// retryBudget bounds retries of the run, shared by export requests and state calls. Nil means unlimited
+var retryBudget *sdk.RetryBudget
+
+// rateLimiter limits requests to Mixpanel of all tenants. Nil means unlimited
+var rateLimiter *sdk.RateLimiter
+
+// limits are memory and connection limits set by the runner. Nil means unlimited
+var limits *sdk.Limits
 
-var batch []*mixpanel.Event
-var initialState daterange.DateRanges
-var commitedState daterange.DateRanges
-var processedRanges daterange.DateRanges
 var startTime = time.Now()
-var lastDate = startTime
-var statuses = make(map[string]*Status)
 
-var lastProcessedDate string
-var currentStatus *Status
+// daysLock guards runDays and deferredDays shared by tenant workers
+var daysLock sync.Mutex
+
+// runDays are dates sent during this run. Limited by maxDaysPerRun
+var runDays = make(map[string]bool)
+
+// deferredDays are dates skipped because maxDaysPerRun was reached. They will be sent by subsequent runs
+var deferredDays = make(map[string]bool)
+
+// naming converts names of custom properties. Unmapped columns are sent only if property name template is set
+var naming *sdk.Naming
+var sendUnmapped bool
+
+// projection strips columns not selected by the host
+var projection *sdk.Projection
+
+// flattener expands JSON columns listed in flattenColumns into dot path columns
+var flattener *sdk.Flattener
 
 func main() {
-	var mp *mixpanel.ApiClient
-	//mp := mixpanel.NewApiClient("PROJECT_TOKEN")
+	health.Serve(session)
+	handleSignals()
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
+	if err := openLogFile(); err != nil {
+		// file logging is a troubleshooting aid, the sync can run without it
+		session.Warn("Logging to file is disabled", err.Error())
+	}
+	maxMessageSize, err := sdk.MaxMessageSizeFromEnv()
+	if err != nil {
+		session.Error("Invalid message size limit", err.Error())
+		_ = session.Reply("halt", map[string]any{
+			"message": err.Error(),
+		})
+		exit(exitConfigError)
+	}
+	var reader *sdk.MessageReader
+	framing, err := sdk.FramingFromEnv()
+	if err == nil {
+		reader, err = sdk.NewMessageReader(os.Stdin, maxMessageSize, framing)
+	}
+	if err != nil {
+		session.Error("Invalid protocol framing", err.Error())
+		_ = session.Reply("halt", map[string]any{
+			"message": err.Error(),
+		})
+		exit(exitConfigError)
+	}
+	// PROTOCOL_COMPRESSION compresses both streams from the start, the host requests it with hello instead
+	compression, err := sdk.CompressionFromEnv()
+	if err == nil {
+		err = reader.SetCompression(compression)
+	}
+	if err == nil {
+		err = stdout.Compress(compression)
+	}
+	if err != nil {
+		session.Error("Invalid protocol compression", err.Error())
+		_ = session.Reply("halt", map[string]any{
+			"message": err.Error(),
+		})
+		exit(exitConfigError)
+	}
+	for {
+		var lineBytes []byte
+		lineBytes, err = reader.Next()
+		var tooLarge *sdk.MessageTooLargeError
+		if errors.As(err, &tooLarge) {
+			// the rest of the input is still valid, so a single oversized row doesn't fail the run
+			session.Error("Message skipped", err.Error())
 			continue
 		}
-		var message Message
-		err := json.Unmarshal([]byte(line), &message)
 		if err != nil {
-			lerror("Message received cannot be parsed: "+line, err.Error())
-			os.Exit(1)
+			if err != io.EOF {
+				session.Error(err.Error())
+			}
+			break
 		}
+		line := string(lineBytes)
+		var message sdk.IncomingMessage
+		err = json.Unmarshal(lineBytes, &message)
+		if err != nil {
+			session.Error("Message received cannot be parsed: "+line, err.Error())
+			exit(exitError)
+		}
+		health.MessageReceived(message.Type)
+		runMu.Lock()
 		switch message.Type {
+		case "hello":
+			// the reader switched framing and compression already
+			_ = sdk.ReplyHello(reader, stdout.ReplyWriter)
 		case "describe":
-			reply("spec", map[string]any{
-				"roles":                 []string{"destination"},
+			describe, err := sdk.DecodeMessage[sdk.DescribePayload](message)
+			if err != nil {
+				session.Warn("Invalid describe message", err.Error())
+			}
+			sdk.CheckHostRequirements(describe.HostRequirements, capabilities, session)
+			_ = session.Reply("spec", map[string]any{
+				"roles":                 []string{"destination", "source"},
 				"description":           "Mixpanel Connector",
 				"connectionCredentials": credentialSchema,
+				"connector":             sdk.VersionInfo(capabilities),
+				"scheduling":            schedulingHints,
+				"supportedFramings":     sdk.SupportedFramings,
+				"supportedCompressions": sdk.SupportedCompressions,
 			})
-			os.Exit(0)
+			exit(exitOK)
 		case "describe-streams":
-			reply("stream-spec", map[string]any{
-				"roles":         []string{"destination"},
-				"defaultStream": "AdData",
-				"streams":       []any{map[string]any{"name": "AdData", "rowType": rowSchema}},
+			_ = session.Reply("stream-spec", map[string]any{
+				"roles":         []string{"destination", "source"},
+				"defaultStream": streamAdData,
+				"streams": []any{
+					map[string]any{"name": streamAdData, "rowType": rowSchema},
+					map[string]any{"name": streamUserProfiles, "rowType": profileSchema},
+					map[string]any{"name": streamEvents, "rowType": eventSchema, "roles": []string{"source"}},
+				},
 			})
 		case "start-stream":
-			payload := message.Payload.(map[string]any)
-			stream, ok := payload["stream"]
-			if !ok || stream != "AdData" {
-				lerror("Unknown stream", stream)
-				reply("halt", map[string]any{
+			message, path, err := sdk.MergeStartStreamFile(message)
+			var payload sdk.StartStream
+			if err == nil {
+				payload, err = sdk.DecodeMessage[sdk.StartStream](message)
+			}
+			if err != nil {
+				session.Error("Invalid start-stream message", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			if path != "" {
+				session.Info("Merging start-stream payload with " + path)
+			}
+			sdk.CheckHostRequirements(payload.HostRequirements, capabilities, session)
+			stream := payload.Stream
+			if stream != streamAdData && stream != streamUserProfiles && stream != streamEvents {
+				session.Error("Unknown stream", stream)
+				_ = session.Reply("halt", map[string]any{
 					"message": fmt.Sprintf("Unknown stream: %s", stream),
 				})
-				os.Exit(1)
-			}
-			syncId, _ = payload["syncId"].(string)
-			creds, ok := payload["connectionCredentials"].(map[string]any)
-			if !ok {
-				lerror("No credentials provided: " + line)
-				reply("halt", map[string]any{
-					"message": "connectionCredentials are required",
+				exit(exitConfigError)
+			}
+			currentStream = stream
+			syncId = payload.SyncId
+			traceId = payload.TraceId
+			session.TraceId = traceId
+			rpcClient.TraceId = traceId
+			rowsFile, err = parseRowsFile(payload.RowsFile)
+			if err != nil {
+				session.Error("Invalid start-stream message", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			// validated by DecodeMessage
+			startDate, endDate, _ = payload.DateRange()
+			restateRanges = payload.Restate
+			featureFlags = payload.FeatureFlags
+			if len(featureFlags) > 0 {
+				var flags []string
+				for _, name := range featureFlags.Names() {
+					flags = append(flags, fmt.Sprintf("%s=%v", name, featureFlags[name]))
+				}
+				session.Info("Feature flags: " + strings.Join(flags, ", "))
+			}
+			startDryRun(payload.DryRun)
+			streamStarted = true
+			if err = startMetrics(); err != nil {
+				session.Error("Invalid metrics interval", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
 				})
+				exit(exitConfigError)
 			}
+			projection = sdk.ProjectionFromOptions(payload.StreamOptions)
+			creds := payload.ConnectionCredentials
+			runCredentials = creds
 			projectToken, _ := creds["projectToken"].(string)
 			residency, _ := creds["residency"].(string)
 			rInitialSyncDays, ok := creds["initialSyncDays"].(float64)
@@ -131,221 +333,829 @@ func main() {
 			if ok {
 				lookbackWindow = int(rLookbackWindow)
 			}
+			rGranularity, _ := creds["granularity"].(string)
+			if err = configureGranularity(rGranularity); err != nil {
+				session.Error("Invalid granularity", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
 			rBatchSize, ok := creds["batchSize"].(float64)
 			if ok {
 				batchSize = int(rBatchSize)
 			}
-			stateKey = []string{"syncId=" + syncId, "type=mixpanel.state"}
-			raw, err := rpcClient.Get(stateKey)
+			rSendConcurrency, ok := creds["sendConcurrency"].(float64)
+			if ok {
+				sendConcurrency = int(rSendConcurrency)
+			}
+			adaptiveConcurrency = boolOption(creds, "adaptiveConcurrency")
+			skipZeroRows, _ = creds["skipZeroRows"].(bool)
+			computeRoas, _ = creds["computeRoas"].(bool)
+			strictImport, _ = creds["strictImport"].(bool)
+			atomicRun, _ = creds["atomic"].(bool)
+			rCaptureResponses, ok := creds["captureResponses"].(float64)
+			if ok {
+				captureResponses = int(rCaptureResponses)
+			}
+			captureFile, _ = creds["captureFile"].(string)
+			preflight, _ = creds["preflight"].(bool)
+			preflightPending = preflight
+			confirm, _ = creds["confirm"].(bool)
+			rPreflightMaxEvents, ok := creds["preflightMaxEvents"].(float64)
+			if ok {
+				preflightMaxEvents = int(rPreflightMaxEvents)
+			}
+			rPreviewEvents, ok := creds["previewEvents"].(float64)
+			if ok {
+				previewEvents = int(rPreviewEvents)
+			}
+			rMaxDaysPerRun, ok := creds["maxDaysPerRun"].(float64)
+			if ok {
+				maxDaysPerRun = int(rMaxDaysPerRun)
+			}
+			rMaxEventsPerRun, ok := creds["maxEventsPerRun"].(float64)
+			if ok {
+				budget.maxEvents = int(rMaxEventsPerRun)
+			}
+			rMaxBytesPerRun, ok := creds["maxBytesPerRun"].(float64)
+			if ok {
+				budget.maxBytes = int(rMaxBytesPerRun)
+			}
+			rMaxEventsPerMinute, ok := creds["maxEventsPerMinute"].(float64)
+			if ok {
+				maxEventsPerMinute = int(rMaxEventsPerMinute)
+			}
+			rInsertIdStrategy := stringOption(creds, "insertIdStrategy")
+			rInsertIdNamespace, _ := creds["insertIdNamespace"].(string)
+			rInsertIdColumn, _ := creds["insertIdColumn"].(string)
+			err = configureInsertId(rInsertIdStrategy, rInsertIdNamespace, rInsertIdColumn)
 			if err != nil {
-				lerror("Error getting state", err.Error())
-			} else {
-				initialState, err = dateRangesFromAny(raw)
-				if err != nil {
-					lerror("Error parsing state", err.Error())
-				} else if !initialState.IsZero() {
-					processedRanges = daterange.NewDateRanges(initialState.ToSlice()...)
-					commitedState = daterange.NewDateRanges(initialState.ToSlice()...)
-					info("State loaded", fmt.Sprint(initialState))
-					lastDate = initialState.LastDate()
+				session.Error("Invalid insert id configuration", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			var rCostScale *int
+			if v, ok := creds["costScale"].(float64); ok {
+				scale := int(v)
+				rCostScale = &scale
+			}
+			rCostRounding, _ := creds["costRounding"].(string)
+			err = configureCostRounding(rCostScale, rCostRounding)
+			if err != nil {
+				session.Error("Invalid cost rounding configuration", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rTargetCurrency, _ := creds["targetCurrency"].(string)
+			rExchangeRates, _ := creds["exchangeRates"].(map[string]any)
+			if err = configureCurrencyConversion(rTargetCurrency, rExchangeRates); err != nil {
+				session.Error("Invalid currency conversion configuration", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rLimitPolicy, _ := creds["limitPolicy"].(string)
+			rMaxProperties, _ := creds["maxProperties"].(float64)
+			rMaxStringLength, _ := creds["maxStringLength"].(float64)
+			err = configureLimits(rLimitPolicy, int(rMaxProperties), int(rMaxStringLength))
+			if err != nil {
+				session.Error("Invalid limits configuration", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rRetryLaterMinSeconds, _ := creds["retryLaterMinSeconds"].(float64)
+			retryLaterThreshold = time.Duration(rRetryLaterMinSeconds) * time.Second
+			rHaltPolicy, _ := creds["haltPolicy"].(string)
+			err = configureHaltPolicy(rHaltPolicy)
+			if err != nil {
+				session.Error("Invalid halt policy", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			if rDiffThresholdPercent, ok := creds["diffThresholdPercent"].(float64); ok {
+				diffThresholdPercent = rDiffThresholdPercent
+			}
+			err = configureQualityBudget(creds)
+			if err != nil {
+				session.Error("Invalid data quality budget", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rNamingConvention, _ := creds["namingConvention"].(string)
+			rPropertyNameTemplate, _ := creds["propertyNameTemplate"].(string)
+			naming, err = sdk.NewNaming(rNamingConvention, rPropertyNameTemplate)
+			if err != nil {
+				session.Error("Invalid naming configuration", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			sendUnmapped = rPropertyNameTemplate != ""
+			rDecimalSeparator, ok := creds["decimalSeparator"].(string)
+			if ok && rDecimalSeparator != "" {
+				decimalSeparator = rDecimalSeparator
+				if decimalSeparator == "," {
+					thousandsSeparator = "."
+				}
+			}
+			rThousandsSeparator, ok := creds["thousandsSeparator"].(string)
+			if ok {
+				thousandsSeparator = rThousandsSeparator
+			}
+			dedupNamespace, _ = creds["dedupNamespace"].(string)
+			rDedupTtlDays, ok := creds["dedupTtlDays"].(float64)
+			if ok {
+				dedupTtl = time.Hour * 24 * time.Duration(rDedupTtlDays)
+			}
+			rProfileSetOnce, _ := creds["profileSetOnce"].([]any)
+			for _, column := range rProfileSetOnce {
+				if c, ok := column.(string); ok {
+					profileSetOnce[c] = true
+				}
+			}
+			rCrashProtectionMinutes, ok := creds["crashProtectionMinutes"].(float64)
+			if ok {
+				crashProtectionWindow = time.Minute * time.Duration(rCrashProtectionMinutes)
+			}
+			rRequestHeaders, _ := creds["requestHeaders"].(map[string]any)
+			err = configureRequestHeaders(rRequestHeaders)
+			if err != nil {
+				session.Error("Invalid request headers", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rProxyUrl, _ := creds["proxyUrl"].(string)
+			if err = configureProxy(rProxyUrl); err != nil {
+				session.Error("Invalid proxy", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rMaxUploadBytesPerSecond, ok := creds["maxUploadBytesPerSecond"].(float64)
+			if ok {
+				limitUploadBandwidth(int(rMaxUploadBytesPerSecond))
+			}
+			rCompression, _ := creds["compression"].(string)
+			rCompressionLevel, _ := creds["compressionLevel"].(float64)
+			if err = configureCompression(rCompression, int(rCompressionLevel)); err != nil {
+				session.Error("Invalid compression", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rateLimiter, err = sdk.RateLimiterFromCredentials(creds)
+			if err != nil {
+				session.Error("Invalid rate limit", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			capture, err := sdk.CaptureFromEnv()
+			if err != nil {
+				session.Error("Invalid request capture", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			if capture != nil {
+				session.Warn("Capturing requests to Mixpanel to " + capture.Dir())
+			}
+			limits, err = sdk.LimitsFromEnv()
+			if err != nil {
+				session.Error("Invalid limits", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			limits.OnMemoryPressure(func(message string) { session.Warn(message) })
+			flattener, err = sdk.FlattenerFromCredentials(creds)
+			if err != nil {
+				session.Error("Invalid flattenColumns", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			// latency of imports is measured after the rate limiter and connection limit waits
+			baseTransport = rateLimiter.Transport(limits.Transport(metrics.Transport(capture.Transport(baseTransport))))
+			rMaxRetryAttempts, _ := creds["maxRetryAttempts"].(float64)
+			rMaxRetrySeconds, _ := creds["maxRetrySeconds"].(float64)
+			if rMaxRetryAttempts > 0 || rMaxRetrySeconds > 0 {
+				retryBudget = sdk.NewRetryBudget(int(rMaxRetryAttempts), time.Duration(rMaxRetrySeconds*float64(time.Second)))
+				rpcClient.RetryBudget = retryBudget
+			}
+			rAllowedEgressIps, _ := creds["allowedEgressIps"].([]any)
+			allowedEgressIps, err := parseAllowedIps(rAllowedEgressIps)
+			if err != nil {
+				session.Error("Invalid allowedEgressIps", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			if len(allowedEgressIps) > 0 {
+				rEgressIpEndpoint, _ := creds["egressIpEndpoint"].(string)
+				checkEgressIp(rEgressIpEndpoint, allowedEgressIps)
+			}
+			if currentStream == streamEvents {
+				apiSecret, _ := creds["apiSecret"].(string)
+				runExport(apiSecret, residency, payload.FullRefresh)
+			}
+			rTenants, _ := creds["tenants"].(map[string]any)
+			rTenantColumn, _ := creds["tenantColumn"].(string)
+			err = configureTenants(projectToken, residency, rTenants, rTenantColumn)
+			if err != nil {
+				session.Error("Invalid tenants configuration", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			rCampaignMetadataUrl, _ := creds["campaignMetadataUrl"].(string)
+			rCampaignMetadataState, _ := creds["campaignMetadataState"].(bool)
+			if err = loadCampaignMetadata(rCampaignMetadataUrl, rCampaignMetadataState); err != nil {
+				session.Error("Cannot load campaign metadata", err.Error())
+				_ = session.Reply("halt", map[string]any{
+					"message": err.Error(),
+				})
+				exit(exitConfigError)
+			}
+			for _, t := range allTenants() {
+				if currentStream == streamAdData {
+					// profiles are not tracked by date, so there are no ranges to skip and no preview
+					t.loadState()
+					t.applyRestatement()
+					t.checkProject()
+					t.loadLastBatch()
 				}
+				t.pruneDedup()
+			}
+			if currentStream == streamAdData {
+				startPreview()
+			}
+			for _, t := range allTenants() {
+				t.start()
 			}
 			if residency == "EU" {
-				mp = mixpanel.NewApiClient(projectToken, mixpanel.EuResidency())
-			} else {
-				mp = mixpanel.NewApiClient(projectToken)
+				setApiHost("api-eu.mixpanel.com")
+			}
+			startOpenLineage(creds, stream, getApiHost())
+			startWatchdog(creds)
+			startRetryLaterListener()
+			session.Info(fmt.Sprintf("Stream '%s' started. Version: %s Residency: %s SyncId: %s InitialSyncDays: %d LookbackWindow: %d", stream, sdk.Version, residency, syncId, initialSyncDays, lookbackWindow))
+			if payload.StartDate != "" || payload.EndDate != "" {
+				session.Info(fmt.Sprintf("Date range is overridden: %s..%s. Days of the range are sent regardless of state", payload.StartDate, payload.EndDate))
 			}
-			info(fmt.Sprintf("Stream '%s' started. Residency: %s SyncId: %s InitialSyncDays: %d LookbackWindow: %d", stream, residency, syncId, initialSyncDays, lookbackWindow))
 		case "end-stream":
-			info("Received end-stream message.")
-			sendBatch(mp)
-			reply("stream-result", statuses)
-			time.AfterFunc(1000, func() {
-				info("Bye!")
-				os.Exit(0)
-			})
+			session.Info("Received end-stream message.")
+			if streamEnded {
+				// the stream of rowsFile is ended by the connector
+				break
+			}
+			endStream()
 		case "row":
-			payload := message.Payload.(map[string]any)
-			var rowPayload RowPayload
-			err = mapstructure.Decode(payload["row"], &rowPayload)
+			var rowMessage RowMessage
+			err = decodeRowMessage(message.Payload, &rowMessage)
+			if err != nil {
+				session.Error("Cannot parse row message: "+line, err.Error())
+				exit(exitError)
+			}
+			setColumnHints(rowMessage.ColumnTypes)
+			acceptRow(rowMessage.Row)
+		case "rows":
+			var rowsMessage RowsMessage
+			err = decodeRowMessage(message.Payload, &rowsMessage)
+			if err != nil {
+				session.Error("Cannot parse rows message", err.Error())
+				exit(exitError)
+			}
+			setColumnHints(rowsMessage.ColumnTypes)
+			for _, row := range rowsMessage.Rows {
+				acceptRow(row)
+			}
+		case "rows-arrow":
+			var arrowMessage RowsArrowMessage
+			err = decodeRowMessage(message.Payload, &arrowMessage)
+			if err == nil {
+				var rows int
+				rows, err = acceptArrowRows(arrowMessage)
+				session.Debug(fmt.Sprintf("Received %d rows in arrow file %s", rows, arrowMessage.Path))
+			}
+			if err != nil {
+				session.Error("Cannot read rows-arrow message", err.Error())
+				exit(exitError)
+			}
+		case "campaign-metadata":
+			handleCampaignMetadata(payloadMap(message))
+		case "halt":
+			payload, err := sdk.DecodeMessage[sdk.HaltPayload](message)
+			if err != nil {
+				session.Warn("Invalid halt message", err.Error())
+			}
+			handleHalt(payload)
+		case "history":
+			payload, err := sdk.DecodeMessage[sdk.HistoryPayload](message)
+			if err != nil {
+				_ = session.Reply("halt", map[string]any{"status": "error", "message": err.Error()})
+				exit(exitConfigError)
+			}
+			replyHistory(payload)
+			exit(exitOK)
+		case "credentials-updated":
+			payload, err := sdk.DecodeMessage[sdk.CredentialsUpdatedPayload](message)
 			if err != nil {
-				lerror("Cannot parse row payload: "+line, err.Error())
-				os.Exit(1)
-			} else {
-				processRow(mp, &rowPayload)
+				session.Error("Invalid credentials-updated message", err.Error())
+				break
 			}
+			handleCredentialsUpdated(payload)
+		case "check":
+			payload, err := sdk.DecodeMessage[sdk.CheckPayload](message)
+			if err != nil {
+				_ = session.Reply("connection-status", map[string]any{"status": "failed", "reason": err.Error()})
+				exit(exitConfigError)
+			}
+			handleCheck(payload)
 		default:
-			lerror("Unknown message type", message.Type)
+			session.Error("Unknown message type", message.Type)
 		}
+		enforceQualityBudget()
+		runMu.Unlock()
+		if rowsFile != nil {
+			f := rowsFile
+			rowsFile = nil
+			streamRowsFile(f)
+		}
+	}
+	if streamEnded {
+		exit(runExitCode())
+	} else if streamStarted {
+		session.Error("Input closed before end-stream message")
+		exit(exitError)
+	}
+	_ = stdout.Close()
+}
+
+// endStream sends remaining rows, saves state and replies stream-result. The connector exits shortly after
+func endStream() {
+	watchdog.Stop()
+	finishPreflight(true)
+	for _, t := range allTenants() {
+		t.finish()
+	}
+	tenantsFinished = true
+	diff := compareRunStats()
+	if reason := qualityBudget.exceeded(true); reason != "" {
+		failQualityBudget(reason)
+	}
+	replyLineage()
+	// state of atomic run is saved only if the run passed the quality budget
+	if atomicRun {
+		commitAtomicRun()
+	}
+	if syncId != "" && (!atomicRun || committed) {
+		saveRunStats()
+	}
+	result := streamResult()
+	if diff != nil {
+		result["diff"] = diff
+	}
+	if limits != nil {
+		result["limits"] = limits.Report()
+	}
+	replyDryRun(result)
+	_ = session.Reply("stream-result", result)
+	streamEnded = true
+	time.AfterFunc(1000, func() {
+		session.Info("Bye!")
+		exit(runExitCode())
+	})
+}
+
+// handleRow normalizes raw row and passes it to processRow
+func handleRow(row map[string]any) {
+	projection.Apply(row)
+	if err := flattener.Apply(row); err != nil {
+		warning(warningFlatten, err.Error())
 	}
-	err := scanner.Err()
+	t, err := tenantFor(row)
 	if err != nil {
-		logErr(err)
+		unknownTenantRows++
+		session.Error("Row skipped", err.Error())
+		return
+	}
+	if currentStream == streamUserProfiles {
+		handleProfileRow(t, row)
+		return
+	}
+	legacy := legacyIds(row)
+	coerced := normalizeRow(row)
+	metricsCoerced, err := normalizeMetrics(row)
+	if err != nil {
+		date, _ := row["date"].(string)
+		t.submit(rowJob{date: date, err: err, key: rowKeyOf(row)})
+		return
+	}
+	coerced += metricsCoerced
+	if coerced > 0 {
+		date, _ := row["date"].(string)
+		warning(warningCoercion, fmt.Sprintf("[%s] %d values coerced to the expected type", date, coerced))
+	}
+	applyColumnHints(row)
+	if granularity == granularityHour {
+		if err = normalizeHour(row); err != nil {
+			date, _ := row["date"].(string)
+			t.submit(rowJob{date: date, err: err, key: rowKeyOf(row)})
+			return
+		}
+	}
+	var rowPayload RowPayload
+	err = mapstructure.Decode(row, &rowPayload)
+	if err != nil {
+		b, _ := json.Marshal(row)
+		session.Error("Cannot parse row payload: "+string(b), err.Error())
+		exit(exitError)
 	}
+	rowPayload.CostDecimal, _ = toDecimal(row["cost"])
+	rowPayload.LegacyIds = legacy
+	if insertIdStrategy == insertIdColumn && row[insertIdColumnName] != nil {
+		rowPayload.InsertId, _ = canonicalString(row[insertIdColumnName])
+	}
+	if sendUnmapped {
+		rowPayload.Unmapped = unmappedColumns(row)
+	} else {
+		warnUnknownColumns(row)
+	}
+	rowPayload.Currency = columnHints["cost"].Currency
+	recordLineage(row, &rowPayload)
+	t.submit(rowJob{payload: &rowPayload, coerced: coerced})
 }
 
-func processRow(mp *mixpanel.ApiClient, payload *RowPayload) {
-	if lastProcessedDate != payload.Date {
-		if lastProcessedDate != "" {
-			sendBatch(mp)
+func processRow(tn *tenant, payload *RowPayload, coerced int) {
+	if tn.lastProcessedDate != payload.Date {
+		if tn.lastProcessedDate != "" {
+			tn.sendBatch()
+			if tn.retryLaterDelay > 0 {
+				// the day stays the last processed one, so it's not marked processed
+				return
+			}
 		}
-		lastProcessedDate = payload.Date
-		currentStatus = getStatus(payload.Date)
+		tn.lastProcessedDate = payload.Date
+		tn.currentStatus = tn.getStatus(payload.Date)
 	}
+	currentStatus := tn.currentStatus
 	currentStatus.Received++
-	t, err := time.Parse(time.DateOnly, payload.Date)
+	currentStatus.Coerced += coerced
+	if err := validateRow(payload); err != nil {
+		currentStatus.Failed++
+		currentStatus.addErrorSample(err.Error())
+		tn.replyRowError(payloadKey(payload), "", rowErrorValidation, false, err.Error())
+		return
+	}
+	t, err := parsePeriod(payload.Date)
 	if err != nil {
 		currentStatus.Failed++
-		lerror("Error parsing time: "+payload.Date, err.Error())
+		session.Error("Error parsing time: "+payload.Date, err.Error())
+		tn.replyRowError(payloadKey(payload), "", rowErrorValidation, false, err.Error())
+		return
+	}
+	if value, _ := conversionValue(payload); skipZeroRows && payload.Cost == 0 && payload.Clicks == 0 && payload.Impressions == 0 && payload.Conversions == 0 && value == 0 {
+		currentStatus.ZeroSkipped++
 		return
 	}
 	initialSyncStart := startTime.Truncate(time.Hour * 24).Add(time.Hour * 24 * time.Duration(-initialSyncDays))
-	lookbackWindowStart := lastDate.Add(time.Hour * 24 * time.Duration(-lookbackWindow))
+	// lookback window is in days with both granularities, so hourly data is restated for the same days
+	lookbackWindowStart := tn.lastDate.Add(time.Hour * 24 * time.Duration(-lookbackWindow))
 
-	if t.Before(initialSyncStart) {
+	if !endDate.IsZero() && !t.Before(endDate.AddDate(0, 0, 1)) {
 		currentStatus.Skipped++
-		//debug("Row skipped. Too old", t)
 		return
 	}
-	if initialState.Contains(t) {
+	if !startDate.IsZero() {
+		if t.Before(startDate) {
+			currentStatus.Skipped++
+			return
+		}
+	} else if t.Before(initialSyncStart) {
+		currentStatus.Skipped++
+		//session.Debug("Row skipped. Too old", t)
+		return
+	} else if tn.initialState.contains(t) {
 		if t.Before(lookbackWindowStart) {
 			currentStatus.Skipped++
-			//debug("Row skipped. Already processed", t)
+			//session.Debug("Row skipped. Already processed", t)
 			return
 		}
 	}
-	event := mp.NewEvent("$ad_spend", "", map[string]any{
-		"$insert_id":      makeInsertId(payload),
+	if !allowDay(t.Format(time.DateOnly)) {
+		currentStatus.Skipped++
+		return
+	}
+	insertId := makeInsertId(payload)
+	if reason := checkEventTime(t); reason != "" {
+		// such events would be rejected by Mixpanel, so they are kept in state instead
+		warning(warningClockSkew, fmt.Sprintf("[%s] row added to dead-letter queue: %s", payload.Date, reason))
+		currentStatus.DeadLettered++
+		tn.addDeadLetter(payload, insertId, reason)
+		tn.replyRowError(payloadKey(payload), insertId, rowErrorValidation, false, reason)
+		return
+	}
+	if tn.isDuplicate(payload.Date, insertId) {
+		currentStatus.Skipped++
+		currentStatus.DedupSkipped++
+		return
+	}
+	properties := map[string]any{
+		"$insert_id":      insertId,
 		"time":            t,
 		"$ad_platform":    payload.Source,
 		"campaign_id":     payload.CampaignId,
-		"$ad_cost":        payload.Cost,
+		"$ad_cost":        costAmount(payload),
 		"$ad_clicks":      payload.Clicks,
 		"$ad_impressions": payload.Impressions,
 		"conversions":     payload.Conversions,
-		"ad_group_id":     payload.GroupId,
-		"ad_id":           payload.AdId,
-		"campaign_name":   payload.CampaignName,
-		"utm_campaign":    payload.UtmCampaign,
-		"utm_source":      payload.UtmSource,
-		"utm_medium":      payload.UtmMedium,
-		"utm_term":        payload.UtmTerm,
-		"utm_content":     payload.UtmContent,
-	})
-	batch = append(batch, event)
-	processedRanges.Append(daterange.NewDateRange(t, t))
-	if len(batch) >= batchSize {
-		sendBatch(mp)
 	}
-}
-
-func sendBatch(mp *mixpanel.ApiClient) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
-	defer cancel()
-	if len(batch) > 0 {
-		res, err := mp.Import(ctx, batch, mixpanel.ImportOptions{Compression: mixpanel.Gzip, Strict: false})
-		if err != nil {
-			currentStatus.Failed += len(batch)
-			s, _ := json.Marshal(err)
-			lerror(fmt.Sprintf("[%s] wrror importing %d rows.", lastProcessedDate, len(batch)), string(s))
-		} else {
-			if res.Code != 200 || res.NumRecordsImported == 0 {
-				lerror(fmt.Sprintf("[%s] error importing %d rows. Code: %d Status: %+v", lastProcessedDate, len(batch), res.Code, res.Status))
-				currentStatus.Failed += len(batch)
-			} else {
-				if !processedRanges.Equal(commitedState) {
-					err = rpcClient.Set(stateKey, dateRangesToAny(processedRanges))
-					if err != nil {
-						lerror("Error saving state", err.Error())
-					}
-					commitedState = daterange.NewDateRanges(processedRanges.ToSlice()...)
-				}
-				currentStatus.Success += len(batch)
-				info(fmt.Sprintf("[%s] %d rows sent", lastProcessedDate, len(batch)), res.Code, res.NumRecordsImported, res.Status)
-			}
+	setIfNotEmpty(properties, "currency", payload.Currency)
+	setRevenueProperties(properties, payload, properties["$ad_cost"].(float64))
+	if err := convertCurrency(properties, payload); err != nil {
+		currentStatus.Failed++
+		currentStatus.addErrorSample(err.Error())
+		tn.replyRowError(payloadKey(payload), insertId, rowErrorValidation, false, err.Error())
+		return
+	}
+	cost, _ := properties["$ad_cost"].(float64)
+	setIfNotEmpty(properties, "ad_group_id", payload.GroupId)
+	setIfNotEmpty(properties, "ad_id", payload.AdId)
+	setIfNotEmpty(properties, "campaign_name", payload.CampaignName)
+	setIfNotEmpty(properties, "utm_campaign", payload.UtmCampaign)
+	setIfNotEmpty(properties, "utm_source", payload.UtmSource)
+	setIfNotEmpty(properties, "utm_medium", payload.UtmMedium)
+	setIfNotEmpty(properties, "utm_term", payload.UtmTerm)
+	setIfNotEmpty(properties, "utm_content", payload.UtmContent)
+	enrichCampaign(properties, payload)
+	for column, value := range payload.Unmapped {
+		name := naming.PropertyName(column)
+		if _, ok := properties[name]; !ok {
+			properties[name] = value
+		}
+	}
+	properties = applyNamingConvention(properties)
+	adjustments, err := enforceLimits(properties)
+	if err != nil {
+		currentStatus.Failed++
+		currentStatus.addErrorSample(err.Error())
+		tn.replyRowError(payloadKey(payload), insertId, rowErrorValidation, false, err.Error())
+		return
+	}
+	if len(adjustments) > 0 {
+		currentStatus.Adjusted++
+		warning(warningTruncation, fmt.Sprintf("[%s] event adjusted to fit Mixpanel limits", payload.Date), adjustments)
+	}
+	event := tn.mp.NewEvent("$ad_spend", "", properties)
+	if !budget.reserve(event) {
+		currentStatus.Skipped++
+		currentStatus.BudgetSkipped++
+		if !tn.initialState.contains(t) {
+			// the day is sent only partially, so it must be sent again by subsequent runs
+			tn.processedRanges = tn.processedRanges.remove(t)
 		}
-		batch = nil
+		return
+	}
+	addPreview(tn, event)
+	tn.batch = append(tn.batch, event)
+	tn.batchInsertIds = append(tn.batchInsertIds, insertId)
+	if tn.batchKeys == nil {
+		tn.batchKeys = make(map[string]rowKey)
+	}
+	tn.batchKeys[insertId] = payloadKey(payload)
+	tn.processedRanges.add(t)
+	recordRunStats(payload.Source, cost)
+	// under memory pressure rows are sent right away rather than held in batches
+	if len(tn.batch) >= batchSize || limits.MemoryPressure() {
+		tn.sendBatch()
 	}
 }
 
-func getStatus(date string) *Status {
-	if _, ok := statuses[date]; !ok {
-		statuses[date] = &Status{}
+// allowDay checks whether rows of the date may be sent within maxDaysPerRun limit.
+// Dates over the limit are recorded in deferredDays
+func allowDay(date string) bool {
+	if maxDaysPerRun <= 0 {
+		return true
+	}
+	daysLock.Lock()
+	defer daysLock.Unlock()
+	if runDays[date] {
+		return true
+	}
+	// rows are expected to be ordered by date, so the oldest days are sent first
+	if len(runDays) >= maxDaysPerRun {
+		deferredDays[date] = true
+		return false
 	}
-	return statuses[date]
+	runDays[date] = true
+	return true
 }
 
-func makeInsertId(payload *RowPayload) string {
-	builder := strings.Builder{}
-	builder.WriteString(strings.ToUpper(payload.Source[0:1]))
-	builder.WriteString("-")
-	builder.WriteString(payload.Date)
-	builder.WriteString("-")
-	builder.WriteString(fmt.Sprint(payload.CampaignId))
-	if payload.GroupId != nil {
-		builder.WriteString("-")
-		builder.WriteString(fmt.Sprint(payload.GroupId))
-	}
-	if payload.AdId != nil {
-		builder.WriteString("-")
-		builder.WriteString(fmt.Sprint(payload.AdId))
-	}
-	if builder.Len() > 36 {
-		hasher := crypto.MD5.New()
-		_, _ = hasher.Write([]byte(builder.String()))
-		return strings.ToUpper(payload.Source[0:1]) + "-" + payload.Date + "-" + fmt.Sprintf("%x", hasher.Sum(nil))[0:23]
-	} else {
-		return builder.String()
-	}
+var pacingLock sync.Mutex
+var pacingStart time.Time
+var pacedEvents int
 
+// pace sleeps before sending n events if sending them now would exceed maxEventsPerMinute
+// on average since the first batch was sent. The limit is shared by all tenants
+func pace(n int) {
+	if maxEventsPerMinute <= 0 {
+		return
+	}
+	pacingLock.Lock()
+	if pacingStart.IsZero() {
+		pacingStart = time.Now()
+	}
+	// time when already sent events are allowed to be followed by the next ones
+	allowedAt := pacingStart.Add(time.Duration(float64(pacedEvents) / float64(maxEventsPerMinute) * float64(time.Minute)))
+	pacedEvents += n
+	pacingLock.Unlock()
+	if wait := time.Until(allowedAt); wait > 0 {
+		session.Debug(fmt.Sprintf("Throttling: waiting %s before sending %d events", wait.Round(time.Millisecond), n))
+		time.Sleep(wait)
+	}
 }
 
-func logErr(err error) {
-	log("error", err.Error())
+// commitAtomicRun saves state of all tenants if no rows failed. Otherwise state is left as it was before the run
+func commitAtomicRun() {
+	committed = unknownTenantRows == 0
+	for _, t := range allTenants() {
+		if t.hasFailures() {
+			committed = false
+		}
+	}
+	if !committed {
+		session.Error("Run has failed rows. State is rolled back to the initial state, all rows will be sent again by the next run")
+		return
+	}
+	for _, t := range allTenants() {
+		t.saveState()
+	}
 }
 
-func info(message string, params ...any) {
-	log("info", message, params...)
+// streamResult returns totals of the run and per-date statuses under "statuses" key along with run-level fields,
+// see protocol StreamResult. In multi-tenant mode per-date statuses are grouped by tenant under "tenants" key
+func streamResult() map[string]any {
+	total := runTotals()
+	result := map[string]any{
+		"received": total.Received,
+		"success":  total.Success,
+		"skipped":  total.Skipped,
+		"failed":   total.Failed,
+	}
+	if tenantColumn == "" {
+		statuses := make(map[string]*Status, len(defaultTenant.statuses))
+		for date, status := range defaultTenant.statuses {
+			statuses[date] = status
+		}
+		result["statuses"] = statuses
+	} else {
+		tenantResults := make(map[string]any, len(tenants))
+		for key, t := range tenants {
+			tenantResults[key] = t.result()
+		}
+		result["tenants"] = tenantResults
+		result["unknownTenantRows"] = unknownTenantRows
+	}
+	if maxDaysPerRun > 0 {
+		result["remainingDays"] = len(deferredDays)
+	}
+	if budget.maxEvents > 0 || budget.maxBytes > 0 {
+		result["budget"] = budget.result()
+		if budget.isExceeded() {
+			// not all rows were sent. Skipped days are not saved to state, so subsequent runs will send them
+			result["partial"] = true
+		}
+	}
+	if rateLimiter != nil {
+		waits, waited := rateLimiter.Stats()
+		session.Info(fmt.Sprintf("Rate limit: %d requests waited %s in total", waits, waited.Round(time.Millisecond)))
+		result["rateLimit"] = map[string]any{"waitedRequests": waits, "waitSeconds": waited.Seconds()}
+	}
+	if atomicRun {
+		result["committed"] = committed
+		if !committed {
+			result["status"] = "failed"
+		}
+	}
+	if warnings := warningsResult(); len(warnings) > 0 {
+		result["warnings"] = warnings
+	}
+	if projection.Enabled() {
+		result["droppedColumns"] = projection.Dropped()
+	}
+	if enrichment := enrichmentResult(); enrichment != nil {
+		result["enrichment"] = enrichment
+	}
+	return result
 }
 
-func debug(message string, params ...any) {
-	log("debug", message, params...)
+// reservedProperties are Mixpanel properties without $ prefix that must not be renamed
+var reservedProperties = map[string]bool{"time": true, "distinct_id": true, "token": true}
+
+// applyNamingConvention converts names of custom properties. Mixpanel properties ($-prefixed and reserved) are kept as is
+func applyNamingConvention(properties map[string]any) map[string]any {
+	converted := make(map[string]any, len(properties))
+	for name, value := range properties {
+		converted[propertyName(name)] = value
+	}
+	return converted
 }
 
-func warn(message string, params ...any) {
-	log("warn", message, params...)
+// propertyName applies naming convention to a custom property name
+func propertyName(name string) string {
+	if strings.HasPrefix(name, "$") || reservedProperties[name] {
+		return name
+	}
+	return naming.Convert(name)
 }
 
-func lerror(message string, params ...any) {
-	log("error", message, params...)
+// validateRow checks presence of the fields required to build $insert_id
+func validateRow(payload *RowPayload) error {
+	var missing []string
+	if payload.Date == "" {
+		missing = append(missing, "date")
+	}
+	if payload.Source == "" {
+		missing = append(missing, "source")
+	}
+	if payload.CampaignId == "" {
+		missing = append(missing, "campaign_id")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("required fields are missing: %s", strings.Join(missing, ", "))
+	}
+	return nil
 }
 
-func log(level string, message string, params ...any) {
-	l := map[string]any{
-		"level":   level,
-		"message": message,
+func setIfNotEmpty(properties map[string]any, name string, value string) {
+	if value != "" {
+		properties[name] = value
 	}
-	if len(params) > 0 {
-		l["params"] = params
+}
+
+// payloadMap decodes payload of a non-row message. Returns empty map if payload is missing
+func payloadMap(message sdk.IncomingMessage) map[string]any {
+	payload := make(map[string]any)
+	if len(message.Payload) > 0 {
+		if err := json.Unmarshal(message.Payload, &payload); err != nil {
+			session.Error(fmt.Sprintf("Cannot parse '%s' message payload", message.Type), err.Error())
+		}
 	}
-	reply("log", l)
+	return payload
 }
 
-func reply(msgType string, payload any) {
-	msg := Message{
-		Type:      msgType,
-		Direction: "reply",
-		Payload:   payload,
+// decodeRowMessage decodes row or rows message payload. Numbers are decoded as json.Number
+// so decimals and big integers are not mangled by float64
+func decodeRowMessage(raw json.RawMessage, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err := decoder.Decode(v)
+	if err != nil {
+		return err
+	}
+	switch m := v.(type) {
+	case *RowMessage:
+		if m.Row == nil {
+			return fmt.Errorf("row is missing")
+		}
+	case *RowsMessage:
+		for i, row := range m.Rows {
+			if row == nil {
+				return fmt.Errorf("row #%d is not an object", i)
+			}
+		}
 	}
-	data, _ := json.Marshal(&msg)
-	fmt.Println(string(data))
+	return nil
 }
 
-func UnmarshalSchema(line string) map[string]any {
-	var m map[string]any
-	err := json.Unmarshal([]byte(line), &m)
+func resolveSchema(schema map[string]any) map[string]any {
+	resolved, err := schemas.Resolve(schema)
 	if err != nil {
 		panic(err)
 	}
-	return m
+	return resolved
 }
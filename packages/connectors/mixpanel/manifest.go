@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// runId identifies the run in manifests and OpenLineage events
+var runId = sdk.NewRunId()
+
+// runCredentials are credentials of start-stream. Manifest includes their hash, so config changes can be told apart
+var runCredentials map[string]any
+
+var manifestOnce sync.Once
+
+// manifestRetention is the number of run manifests kept per sync
+const manifestRetention = 100
+
+// Manifest is an audit record of a finished run. Manifests are stored at
+//
+//	["type=mixpanel.manifest", "syncId=<sync id>", "run=<started at>-<run id>"]
+//
+// Key is derived from the run, so writing the manifest again overwrites it.
+type Manifest struct {
+	RunId            string         `json:"runId"`
+	SyncId           string         `json:"syncId"`
+	Status           string         `json:"status"`
+	ExitCode         int            `json:"exitCode"`
+	StartedAt        time.Time      `json:"startedAt"`
+	FinishedAt       time.Time      `json:"finishedAt"`
+	FirstDate        string         `json:"firstDate,omitempty"`
+	LastDate         string         `json:"lastDate,omitempty"`
+	Days             int            `json:"days"`
+	Received         int            `json:"received"`
+	Success          int            `json:"success"`
+	Skipped          int            `json:"skipped"`
+	Failed           int            `json:"failed"`
+	Imported         int            `json:"imported"`
+	ConfigHash       string         `json:"configHash"`
+	ConnectorVersion map[string]any `json:"connector"`
+}
+
+func manifestPrefix(syncId string) []string {
+	return []string{"type=mixpanel.manifest", "syncId=" + syncId}
+}
+
+func manifestKey(m *Manifest) []string {
+	return append(manifestPrefix(m.SyncId), "run="+m.StartedAt.UTC().Format("20060102T150405Z")+"-"+m.RunId)
+}
+
+// configHash returns hash of credentials. Values are hashed together with names, secrets can't be recovered from it
+func configHash(credentials map[string]any) string {
+	b, _ := json.Marshal(credentials)
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])[:16]
+}
+
+// buildManifest returns manifest of the run finished with the exit code
+func buildManifest(code int) *Manifest {
+	total := runTotals()
+	m := &Manifest{
+		RunId:            runId,
+		SyncId:           syncId,
+		Status:           exitStatuses[code],
+		ExitCode:         code,
+		StartedAt:        startTime.UTC(),
+		FinishedAt:       time.Now().UTC(),
+		Received:         total.Received,
+		Success:          total.Success,
+		Skipped:          total.Skipped,
+		Failed:           total.Failed,
+		ConfigHash:       configHash(runCredentials),
+		ConnectorVersion: sdk.VersionInfo(capabilities),
+	}
+	days := make(map[string]bool)
+	for _, t := range allTenants() {
+		m.Imported += t.imported
+		for date := range t.statuses {
+			if _, err := parsePeriod(date); err == nil {
+				days[date] = true
+			}
+		}
+	}
+	for date := range days {
+		if m.FirstDate == "" || date < m.FirstDate {
+			m.FirstDate = date
+		}
+		if date > m.LastDate {
+			m.LastDate = date
+		}
+	}
+	m.Days = len(days)
+	return m
+}
+
+// saveManifest writes manifest of the run and removes manifests beyond manifestRetention. Called once on exit
+func saveManifest(code int) {
+	if !streamStarted || syncId == "" {
+		return
+	}
+	m := buildManifest(code)
+	if err := rpcClient.Set(manifestKey(m), m); err != nil {
+		session.Error("Error saving run manifest", err.Error())
+		return
+	}
+	keys, err := manifestKeys(syncId)
+	if err != nil {
+		session.Error("Error listing run manifests", err.Error())
+		return
+	}
+	for len(keys) > manifestRetention {
+		if err = rpcClient.Del(keys[0]); err != nil {
+			session.Error("Error deleting old run manifest", err.Error())
+			return
+		}
+		keys = keys[1:]
+	}
+}
+
+// manifestKeys returns keys of stored manifests of the sync, oldest first
+func manifestKeys(syncId string) ([][]string, error) {
+	entries, err := rpcClient.List(manifestPrefix(syncId))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		entry, _ := e.(map[string]any)
+		rawKey, _ := entry["key"].([]any)
+		key := make([]string, len(rawKey))
+		for i, k := range rawKey {
+			key[i], _ = k.(string)
+		}
+		if len(key) > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return strings.Join(keys[i], "/") < strings.Join(keys[j], "/") })
+	return keys, nil
+}
+
+// replyHistory answers history message with the last manifests of the sync, newest first:
+//
+//	{"type":"history","payload":{"syncId":"...","limit":10}}
+//	{"type":"history-result","payload":{"syncId":"...","manifests":[...]}}
+func replyHistory(payload sdk.HistoryPayload) {
+	historySyncId := payload.SyncId
+	limit := 10
+	if payload.Limit > 0 {
+		limit = payload.Limit
+	}
+	entries, err := rpcClient.List(manifestPrefix(historySyncId))
+	if err != nil {
+		_ = session.Reply("halt", map[string]any{"status": "error", "message": fmt.Sprintf("cannot read run history: %v", err)})
+		exit(exitError)
+	}
+	manifests := make([]map[string]any, 0, len(entries))
+	for _, e := range entries {
+		entry, _ := e.(map[string]any)
+		if value, ok := entry["value"].(map[string]any); ok {
+			manifests = append(manifests, value)
+		}
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		si, _ := manifests[i]["startedAt"].(string)
+		sj, _ := manifests[j]["startedAt"].(string)
+		return si > sj
+	})
+	if len(manifests) > limit {
+		manifests = manifests[:limit]
+	}
+	_ = session.Reply("history-result", map[string]any{"syncId": historySyncId, "manifests": manifests})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// watchdog enforces maxRuntimeMinutes option
+var watchdog = sdk.NewWatchdog(0)
+
+// runMu is held by the main loop while it handles a message, so the watchdog stops the run between messages
+var runMu sync.Mutex
+
+// startWatchdog stops the run once maxRuntimeMinutes is exceeded: rows received so far are sent, state is saved
+// and stream-result with "timeout" status is replied, see endRun. The next run resumes from the days that were not sent
+func startWatchdog(credentials map[string]any) {
+	watchdog = sdk.NewWatchdog(sdk.MaxRuntimeFromCredentials(credentials))
+	if watchdog.MaxRuntime() == 0 {
+		return
+	}
+	go func() {
+		<-watchdog.Exceeded()
+		runMu.Lock()
+		if streamEnded {
+			runMu.Unlock()
+			return
+		}
+		session.Warn(fmt.Sprintf("Run exceeded maxRuntimeMinutes=%s. Finishing sent days and exiting, the next run resumes from the remaining days", watchdog.MaxRuntime()))
+		endRun("timeout", false, nil, exitPartial)
+	}()
+}
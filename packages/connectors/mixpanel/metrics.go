@@ -0,0 +1,23 @@
+package main
+
+import (
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// metrics are sent with periodic metric replies, see sdk.Metrics. Requests are measured by the transport, splits
+// of rejected batches are counted as retries and queue depth is the number of batches waiting for background senders
+var metrics *sdk.Metrics
+
+// stopMetrics stops periodic metric replies and sends the last one. Called before stream-result
+var stopMetrics = func() {}
+
+// startMetrics starts metric replies with interval of sdk.MetricsIntervalEnv
+func startMetrics() error {
+	interval, err := sdk.MetricsIntervalFromEnv()
+	if err != nil {
+		return err
+	}
+	metrics = sdk.NewMetrics()
+	stopMetrics = metrics.Report(session, interval)
+	return nil
+}
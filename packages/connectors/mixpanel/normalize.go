@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// idColumns hold identifiers. Warehouses deliver them as strings, integers, floats or nulls
+// depending on the column type, so they are always converted to canonical strings.
+var idColumns = []string{"campaign_id", "group_id", "ad_id"}
+
+// stringColumns are expected to be strings, but may arrive as numbers or booleans
+var stringColumns = []string{"date", "source", "campaign_name", "utm_source", "utm_campaign", "utm_medium", "utm_term", "utm_content"}
+
+// metricColumns are numeric. Some warehouses export them as locale formatted strings like "1.234,56"
+var metricColumns = []string{"cost", "clicks", "impressions", "conversions", "conversion_value", "revenue"}
+
+var decimalSeparator = "."
+var thousandsSeparator = ","
+
+// normalizeRow converts id and string columns of the row to strings in place and drops null values.
+// Returns the number of values that had to be coerced from a different type.
+func normalizeRow(row map[string]any) int {
+	coerced := 0
+	for _, columns := range [][]string{idColumns, stringColumns} {
+		for _, col := range columns {
+			v, ok := row[col]
+			if !ok {
+				continue
+			}
+			if v == nil {
+				delete(row, col)
+				continue
+			}
+			s, converted := canonicalString(v)
+			if converted {
+				coerced++
+			}
+			row[col] = s
+		}
+	}
+	return coerced
+}
+
+// canonicalString returns string representation of a scalar value. Whole numbers are rendered
+// without exponent or fraction, so 1.23456789e+08 becomes "123456789".
+// The second return value is true if the value wasn't a string.
+func canonicalString(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, false
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32), true
+	case json.Number:
+		return t.String(), true
+	case bool:
+		return strconv.FormatBool(t), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(t), true
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Sprint(t), true
+		}
+		return string(b), true
+	}
+}
+
+// legacyIds joins id columns of the row formatted as before they were normalized: numbers were decoded as float64
+// and printed with fmt.Sprint, so 1234567 was "1.234567e+06", and empty group and ad ids were kept. The legacy md5
+// $insert_id is computed from them, so rows sent by earlier versions keep their ids and aren't counted twice.
+// Returns empty string if the row has no campaign_id
+func legacyIds(row map[string]any) string {
+	if row["campaign_id"] == nil {
+		return ""
+	}
+	ids := []string{legacyIdString(row["campaign_id"])}
+	for _, col := range []string{"group_id", "ad_id"} {
+		if v := row[col]; v != nil {
+			ids = append(ids, legacyIdString(v))
+		}
+	}
+	return strings.Join(ids, "-")
+}
+
+func legacyIdString(v any) string {
+	if n, ok := v.(json.Number); ok {
+		if f, err := n.Float64(); err == nil {
+			return fmt.Sprint(f)
+		}
+	}
+	return fmt.Sprint(v)
+}
+
+// normalizeMetrics parses metric columns delivered as strings according to decimalSeparator
+// and thousandsSeparator. Empty strings are treated as nulls and dropped.
+// Returns the number of values that had to be coerced.
+func normalizeMetrics(row map[string]any) (int, error) {
+	coerced := 0
+	for _, col := range metricColumns {
+		s, ok := row[col].(string)
+		if !ok {
+			continue
+		}
+		coerced++
+		if strings.TrimSpace(s) == "" {
+			delete(row, col)
+			continue
+		}
+		n, err := parseNumber(s)
+		if err != nil {
+			return coerced, fmt.Errorf("cannot parse '%s' column value %q as number: %v", col, s, err)
+		}
+		row[col] = n
+	}
+	return coerced, nil
+}
+
+// parseNumber converts locale formatted number to json.Number, keeping the exact value
+func parseNumber(s string) (json.Number, error) {
+	s = strings.TrimSpace(s)
+	if thousandsSeparator != "" {
+		s = strings.ReplaceAll(s, thousandsSeparator, "")
+	}
+	if decimalSeparator != "." {
+		s = strings.Replace(s, decimalSeparator, ".", 1)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return "", err
+	}
+	return json.Number(s), nil
+}
+
+// unmappedColumns returns non-null columns of the row that are not mapped to $ad_spend properties
+func unmappedColumns(row map[string]any) map[string]any {
+	unmapped := make(map[string]any)
+	for name, value := range row {
+		if value == nil || name == insertIdColumnName || name == tenantColumn || slices.Contains(idColumns, name) || slices.Contains(stringColumns, name) || slices.Contains(metricColumns, name) {
+			continue
+		}
+		unmapped[name] = value
+	}
+	return unmapped
+}
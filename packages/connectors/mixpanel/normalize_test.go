@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+func TestNormalizeRow(t *testing.T) {
+	tests := []struct {
+		name    string
+		row     map[string]any
+		want    map[string]any
+		coerced int
+	}{
+		{
+			name:    "strings are kept as is",
+			row:     map[string]any{"campaign_id": "123", "source": "google", "utm_source": "g"},
+			want:    map[string]any{"campaign_id": "123", "source": "google", "utm_source": "g"},
+			coerced: 0,
+		},
+		{
+			name:    "postgres bigint decoded as float64",
+			row:     map[string]any{"campaign_id": float64(123456789012), "ad_id": float64(42)},
+			want:    map[string]any{"campaign_id": "123456789012", "ad_id": "42"},
+			coerced: 2,
+		},
+		{
+			name:    "snowflake number with fraction",
+			row:     map[string]any{"group_id": 12.5},
+			want:    map[string]any{"group_id": "12.5"},
+			coerced: 1,
+		},
+		{
+			name:    "nulls are dropped",
+			row:     map[string]any{"campaign_id": "1", "group_id": nil, "ad_id": nil, "utm_term": nil},
+			want:    map[string]any{"campaign_id": "1"},
+			coerced: 0,
+		},
+		{
+			name:    "bigquery json number and boolean utm",
+			row:     map[string]any{"campaign_id": json.Number("9007199254740993"), "utm_content": true, "utm_medium": float64(0)},
+			want:    map[string]any{"campaign_id": "9007199254740993", "utm_content": "true", "utm_medium": "0"},
+			coerced: 3,
+		},
+		{
+			name:    "metric columns are not touched",
+			row:     map[string]any{"cost": 1.5, "clicks": nil},
+			want:    map[string]any{"cost": 1.5, "clicks": nil},
+			coerced: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coerced := normalizeRow(tt.row)
+			if coerced != tt.coerced {
+				t.Errorf("coerced = %d, want %d", coerced, tt.coerced)
+			}
+			if !reflect.DeepEqual(tt.row, tt.want) {
+				t.Errorf("row = %v, want %v", tt.row, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		decimal   string
+		thousands string
+		value     any
+		want      any
+		wantErr   bool
+	}{
+		{name: "numbers are kept", decimal: ".", thousands: ",", value: 12.5, want: 12.5},
+		{name: "us format", decimal: ".", thousands: ",", value: "1,234.56", want: json.Number("1234.56")},
+		{name: "european format", decimal: ",", thousands: ".", value: "1.234,56", want: json.Number("1234.56")},
+		{name: "swiss format", decimal: ".", thousands: "'", value: "1'234.5", want: json.Number("1234.5")},
+		{name: "blank string is dropped", decimal: ".", thousands: ",", value: "  ", want: nil},
+		{name: "garbage", decimal: ".", thousands: ",", value: "n/a", wantErr: true},
+	}
+	defer func(d, t string) { decimalSeparator, thousandsSeparator = d, t }(decimalSeparator, thousandsSeparator)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decimalSeparator, thousandsSeparator = tt.decimal, tt.thousands
+			row := map[string]any{"cost": tt.value}
+			_, err := normalizeMetrics(row)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && row["cost"] != tt.want {
+				t.Errorf("cost = %v, want %v", row["cost"], tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundDecimal(t *testing.T) {
+	tests := []struct {
+		value string
+		scale int
+		mode  string
+		want  string
+	}{
+		{value: "1.235", scale: 2, mode: roundHalfEven, want: "1.24"},
+		{value: "1.245", scale: 2, mode: roundHalfEven, want: "1.24"},
+		{value: "1.245", scale: 2, mode: roundHalfUp, want: "1.25"},
+		{value: "-1.245", scale: 2, mode: roundHalfUp, want: "-1.25"},
+		{value: "1.249", scale: 2, mode: roundDown, want: "1.24"},
+		{value: "1.241", scale: 2, mode: roundUp, want: "1.25"},
+		{value: "90071992547409.935", scale: 2, mode: roundHalfEven, want: "90071992547409.94"},
+	}
+	for _, tt := range tests {
+		r, _ := toDecimal(json.Number(tt.value))
+		if got := roundDecimal(r, tt.scale, tt.mode).FloatString(tt.scale); got != tt.want {
+			t.Errorf("roundDecimal(%s, %d, %s) = %s, want %s", tt.value, tt.scale, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeHour(t *testing.T) {
+	tests := []struct {
+		row  map[string]any
+		want string
+	}{
+		{map[string]any{"date": "2024-05-01T13:45:00Z"}, "2024-05-01T13"},
+		{map[string]any{"date": "2024-05-01T01:30:00+02:00"}, "2024-04-30T23"},
+		{map[string]any{"date": "2024-05-01 07:00:00"}, "2024-05-01T07"},
+		{map[string]any{"date": "2024-05-01", "hour": json.Number("9")}, "2024-05-01T09"},
+		{map[string]any{"date": "2024-05-01", "hour": "23"}, "2024-05-01T23"},
+		{map[string]any{"date": "2024-05-01"}, ""},
+		{map[string]any{"date": "2024-05-01", "hour": 24.0}, ""},
+	}
+	for _, tt := range tests {
+		date := tt.row["date"]
+		err := normalizeHour(tt.row)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("normalizeHour(%v) must fail", date)
+			}
+			continue
+		}
+		if err != nil || tt.row["date"] != tt.want || tt.row["hour"] != nil {
+			t.Errorf("normalizeHour(%v) = %v, %v, want %s", date, tt.row["date"], err, tt.want)
+		}
+	}
+}
+
+// handledPayload passes the row JSON through handleRow and returns the payload queued for the tenant
+func handledPayload(t *testing.T, row string) *RowPayload {
+	t.Helper()
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	tn := newTenant("", "token", "")
+	tn.queue = make(chan rowJob, 1)
+	defaultTenant = tn
+	defer func() { defaultTenant = nil }()
+	var m map[string]any
+	decoder := json.NewDecoder(strings.NewReader(row))
+	decoder.UseNumber()
+	if err := decoder.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	handleRow(m)
+	job := <-tn.queue
+	if job.payload == nil {
+		t.Fatalf("row %s is rejected: %v", row, job.err)
+	}
+	return job.payload
+}
+
+func TestNormalizedIdsKeepLegacyInsertId(t *testing.T) {
+	// ids are normalized, but the md5 insert id is computed from ids formatted as before
+	payload := handledPayload(t, `{"date":"2024-01-01","source":"facebook","campaign_id":1234567,"group_id":89,"ad_id":12345678901}`)
+	if payload.CampaignId != "1234567" || payload.AdId != "12345678901" {
+		t.Errorf("ids are not normalized: %+v", payload)
+	}
+	// md5 of "F-2024-01-01-1.234567e+06-89-1.2345678901e+10"
+	if id := makeInsertId(payload); id != "F-2024-01-01-95678314f175003c15e1e04" {
+		t.Errorf("insert id = %s, want the id of earlier versions", id)
+	}
+}
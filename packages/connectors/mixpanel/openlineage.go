@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// openLineage posts OpenLineage run events if openLineageUrl option is set. Input dataset is the stream
+// in the job namespace, outputs are $ad_spend events of each Mixpanel project. Project tokens are hashed
+var openLineage *sdk.OpenLineage
+var openLineageStream string
+var openLineageApiHost = "api.mixpanel.com"
+var openLineageOnce sync.Once
+
+// startOpenLineage sends START event of the run
+func startOpenLineage(creds map[string]any, stream string, apiHost string) {
+	url, _ := creds["openLineageUrl"].(string)
+	if url == "" {
+		return
+	}
+	apiKey, _ := creds["openLineageApiKey"].(string)
+	namespace, _ := creds["openLineageNamespace"].(string)
+	job := syncId
+	if job == "" {
+		job = "mixpanel." + stream
+	}
+	openLineage = sdk.NewOpenLineage(url, apiKey, namespace, job, runId)
+	openLineageStream = stream
+	openLineageApiHost = apiHost
+	if err := openLineage.Emit(sdk.OpenLineageStart, openLineageInputs(), openLineageOutputs(false), ""); err != nil {
+		session.Warn("Cannot send OpenLineage event", err.Error())
+	}
+}
+
+// finishOpenLineage sends COMPLETE, ABORT or FAIL event depending on the exit code. Called once on exit
+func finishOpenLineage(code int) {
+	if openLineage == nil {
+		return
+	}
+	openLineageOnce.Do(func() {
+		eventType, message := sdk.OpenLineageComplete, ""
+		switch code {
+		case exitOK, exitPartial:
+		case exitCancelled, exitRetryLater:
+			eventType = sdk.OpenLineageAbort
+		default:
+			eventType, message = sdk.OpenLineageFail, fmt.Sprintf("run finished with %s status (exit code %d)", exitStatuses[code], code)
+		}
+		if err := openLineage.Emit(eventType, openLineageInputs(), openLineageOutputs(true), message); err != nil {
+			session.Warn("Cannot send OpenLineage event", err.Error())
+		}
+	})
+}
+
+func openLineageInputs() []sdk.OpenLineageDataset {
+	return []sdk.OpenLineageDataset{{Namespace: openLineage.Namespace(), Name: openLineageStream}}
+}
+
+// openLineageOutputs returns datasets of tenant projects. Final events carry schema and column lineage facets
+// and the number of imported events
+func openLineageOutputs(final bool) []sdk.OpenLineageDataset {
+	tenants := allTenants()
+	outputs := make([]sdk.OpenLineageDataset, 0, len(tenants))
+	for _, t := range tenants {
+		output := sdk.OpenLineageDataset{Namespace: "mixpanel://" + openLineageApiHost, Name: "project=" + dedupHash(t.projectToken) + "/$ad_spend"}
+		if final {
+			output.Facets = map[string]any{
+				"schema":        sdk.SchemaFacet(lineage),
+				"columnLineage": sdk.ColumnLineageFacet(lineage, openLineageInputs()[0]),
+			}
+			output.OutputFacets = map[string]any{"outputStatistics": sdk.OutputStatisticsFacet(t.imported)}
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// With sendConcurrency > 1 batches are sent by background senders, so the worker keeps processing rows while imports
+// are in flight. At most sendConcurrency batches wait for a sender, the worker blocks when senders fall behind.
+// Batches may complete out of order, but state is committed in the order they were queued: state of a batch covers
+// days of all batches queued before it, so it's committed only after all of them are sent.
+// With adaptiveConcurrency imports in flight are limited by concurrencyController
+
+// sendConcurrency is the number of batches of a tenant sent at the same time. 1 means batches are sent by the worker
+var sendConcurrency = 1
+
+// eventBatch is a batch of events of a single date
+type eventBatch struct {
+	seq       int
+	date      string
+	status    *Status
+	events    []*mixpanel.Event
+	insertIds []string
+	// keys are keys of rows by insert id, reported with row-error
+	keys map[string]rowKey
+	// state is processed ranges at the time the batch was queued
+	state periodRanges
+	sent  bool
+	// dropped is set if the batch was not sent because of retry-later or halt with discard policy
+	dropped bool
+}
+
+// startSenders launches background senders of the tenant. Must be called after loadState
+func (t *tenant) startSenders() {
+	if sendConcurrency <= 1 {
+		return
+	}
+	t.sends = make(chan *eventBatch, sendConcurrency)
+	t.completedBatches = make(map[int]*eventBatch)
+	t.sentState = t.processedRanges.clone()
+	if adaptiveConcurrency {
+		t.concurrency = newConcurrencyController(sendConcurrency)
+	}
+	for i := 0; i < sendConcurrency; i++ {
+		t.senders.Add(1)
+		go func() {
+			defer t.senders.Done()
+			for b := range t.sends {
+				t.mu.Lock()
+				t.sendQueued(b)
+				t.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// sendQueued imports the batch queued by the worker. Must be called with t.mu held
+func (t *tenant) sendQueued(b *eventBatch) {
+	if t.isCancelled() {
+		session.Info(fmt.Sprintf("%s %d events discarded", t.periodPrefix(b.date), len(b.events)))
+		b.status.Skipped += len(b.events)
+		b.dropped = true
+		t.completeBatch(b)
+		return
+	}
+	imported := t.importEvents(b, b.events, b.insertIds)
+	b.sent = len(imported) > 0
+	t.completeBatch(b)
+	if b.sent {
+		t.recordSent(b.date, imported)
+	}
+}
+
+// queueBatch passes the batch to senders. Must be called with t.mu held. The lock is released while the queue is full,
+// because senders need it to complete batches
+func (t *tenant) queueBatch(b *eventBatch) {
+	b.seq = t.queuedBatches
+	t.queuedBatches++
+	metrics.SetQueueDepth(t.queuedBatches - t.committedBatches)
+	b.state = t.processedRanges.clone()
+	t.mu.Unlock()
+	t.sends <- b
+	t.mu.Lock()
+}
+
+// completeBatch commits state of completed batches in the order they were queued. Once a dropped batch is reached,
+// processed ranges are rolled back to state of the last sent batch before it, so the rest is sent by the next run
+func (t *tenant) completeBatch(b *eventBatch) {
+	t.completedBatches[b.seq] = b
+	for {
+		next, ok := t.completedBatches[t.committedBatches]
+		if !ok {
+			metrics.SetQueueDepth(t.queuedBatches - t.committedBatches)
+			return
+		}
+		delete(t.completedBatches, t.committedBatches)
+		t.committedBatches++
+		switch {
+		case t.batchDropped:
+		case next.dropped:
+			t.batchDropped = true
+			t.processedRanges = t.sentState.clone()
+			t.lastProcessedDate = next.date
+		case next.sent:
+			t.sentState = next.state
+			if !atomicRun {
+				t.commitState(next.state)
+			}
+		}
+	}
+}
+
+// waitSenders waits until queued batches are sent. Must be called by the worker after the last batch is queued
+func (t *tenant) waitSenders() {
+	if t.sends == nil {
+		return
+	}
+	close(t.sends)
+	t.senders.Wait()
+}
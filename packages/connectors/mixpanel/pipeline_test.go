@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+func TestCompleteBatchCommitsInOrder(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	// atomic runs don't save state to RPC, only sentState is tracked
+	atomicRun = true
+	defer func() { atomicRun = false }()
+	d := func(s string) time.Time {
+		v, _ := time.Parse(time.DateOnly, s)
+		return v
+	}
+	tn := newTenant("", "token", "")
+	tn.completedBatches = make(map[int]*eventBatch)
+	tn.sentState = periodRanges{}
+	batches := make([]*eventBatch, 4)
+	for i, date := range []string{"2024-01-01", "2024-01-02", "2024-01-03", "2024-01-04"} {
+		tn.processedRanges.add(d(date))
+		batches[i] = &eventBatch{seq: i, date: date, status: &Status{}, state: tn.processedRanges.clone()}
+	}
+
+	batches[1].sent = true
+	tn.completeBatch(batches[1])
+	if !tn.sentState.isZero() {
+		t.Fatalf("state of batch 1 committed before batch 0: %s", tn.sentState)
+	}
+	batches[0].sent = true
+	tn.completeBatch(batches[0])
+	if !tn.sentState.equal(batches[1].state) {
+		t.Errorf("sentState = %s, want %s", tn.sentState, batches[1].state)
+	}
+
+	batches[3].sent = true
+	tn.completeBatch(batches[3])
+	batches[2].dropped = true
+	tn.completeBatch(batches[2])
+	if !tn.sentState.equal(batches[1].state) || !tn.batchDropped {
+		t.Errorf("state committed past the dropped batch: %s", tn.sentState)
+	}
+	if !tn.processedRanges.equal(batches[1].state) || tn.lastProcessedDate != "2024-01-03" {
+		t.Errorf("processed ranges are not rolled back to the dropped batch: %s %s", tn.processedRanges, tn.lastProcessedDate)
+	}
+}
+
+func TestAtomicRunWithFailedRowKeepsState(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	var writes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/state.set" {
+			writes = append(writes, r.URL.Path)
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	rpcClient = sdk.NewRpcClient(srv.URL)
+	atomicRun, committed = true, true
+	defer func() { atomicRun, committed = false, true }()
+
+	tn := newTenant("", "token", "")
+	defaultTenant = tn
+	defer func() { defaultTenant = nil }()
+	day, _ := time.Parse(time.DateOnly, "2024-01-01")
+	tn.processedRanges.add(day)
+	tn.start()
+	tn.submit(rowJob{date: "2024-01-01", err: errors.New("time is required")})
+	tn.finish()
+	commitAtomicRun()
+
+	if committed {
+		t.Error("atomic run with a failed row is committed")
+	}
+	if len(writes) > 0 || !tn.commitedState.isZero() {
+		t.Errorf("state of the rolled back run is saved: %v", writes)
+	}
+	result := streamResult()
+	if result["committed"] != false || result["status"] != "failed" {
+		t.Errorf("stream-result of the rolled back run: %v", result)
+	}
+	// per-date statuses are not mixed with run-level fields
+	statuses, _ := result["statuses"].(map[string]*Status)
+	if status := statuses["2024-01-01"]; status == nil || status.Failed != 1 || result["received"] != 1 || result["failed"] != 1 {
+		t.Errorf("stream-result = %v, want totals and statuses by date", result)
+	}
+	if _, ok := result["2024-01-01"]; ok {
+		t.Errorf("status of the date must be under statuses: %v", result)
+	}
+}
+
+func TestAtomicRunEndedEarlyIsNotCommitted(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	atomicRun, committed = true, true
+	defer func() { atomicRun, committed = false, true }()
+	tn := newTenant("", "token", "")
+	defaultTenant = tn
+	defer func() { defaultTenant = nil }()
+	tn.start()
+	// halt, signals, watchdog, retry-later and quality budget stop tenants before end-stream
+	stopTenants(true)
+	tenantsFinished = false
+	if committed || runExitCode() != exitPartial {
+		t.Error("atomic run stopped before end-stream must not be reported as committed")
+	}
+}
+
+func TestSkipZeroRows(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	defer func() { skipZeroRows = false }()
+	date := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	value := 0.0
+	tests := []struct {
+		name    string
+		skip    bool
+		payload RowPayload
+		skipped bool
+	}{
+		{name: "zero row is sent by default", payload: RowPayload{}},
+		{name: "zero row", skip: true, payload: RowPayload{}, skipped: true},
+		{name: "zero conversion value", skip: true, payload: RowPayload{ConversionValue: &value}, skipped: true},
+		{name: "cost", skip: true, payload: RowPayload{Cost: 1.5}},
+		{name: "clicks", skip: true, payload: RowPayload{Clicks: 1}},
+		{name: "impressions", skip: true, payload: RowPayload{Impressions: 100}},
+		{name: "conversions", skip: true, payload: RowPayload{Conversions: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			skipZeroRows = test.skip
+			tn := newTenant("", "token", "")
+			payload := test.payload
+			payload.Date, payload.Source, payload.CampaignId = date, "google", "1"
+			processRow(tn, &payload, 0)
+			status := tn.statuses[date]
+			if skipped := status.ZeroSkipped == 1; skipped != test.skipped || status.Skipped != 0 {
+				t.Errorf("status = %+v, want zero row skipped: %v", status, test.skipped)
+			}
+			if sent := len(tn.batch) == 1; sent == test.skipped {
+				t.Errorf("%d events batched", len(tn.batch))
+			}
+		})
+	}
+}
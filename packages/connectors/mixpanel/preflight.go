@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// preflight holds back first rows of the run to estimate its size before anything is sent
+var preflight = false
+
+// preflightMaxEvents is the number of projected events above which the run requires confirm option
+var preflightMaxEvents = 0
+var confirm = false
+
+// preflightSampleSize is the number of rows used to estimate the number of rows per day
+const preflightSampleSize = 1000
+
+var preflightPending = false
+var preflightRows []map[string]any
+var preflightDays = make(map[string]bool)
+var preflightBytes = 0
+
+// acceptRow passes the row to handleRow. During pre-flight rows are buffered until the sample is complete
+func acceptRow(row map[string]any) {
+	metrics.AddRows(1)
+	qualityBudget.rowReceived()
+	if !preflightPending {
+		handleRow(row)
+		return
+	}
+	preflightRows = append(preflightRows, row)
+	if date, ok := row["date"].(string); ok {
+		// hourly rows are counted by their days
+		preflightDays[date[:min(len(date), len(time.DateOnly))]] = true
+	}
+	b, _ := json.Marshal(row)
+	preflightBytes += len(b)
+	if len(preflightRows) >= preflightSampleSize {
+		finishPreflight(false)
+	}
+}
+
+// finishPreflight reports estimated size of the run and halts if it exceeds preflightMaxEvents without confirm.
+// Otherwise buffered rows are processed. complete is true if all rows of the run were received
+func finishPreflight(complete bool) {
+	if !preflightPending {
+		return
+	}
+	preflightPending = false
+	report := preflightReport(complete)
+	_ = session.Reply("preflight", report)
+	projected := report["projectedEvents"].(int)
+	if preflightMaxEvents > 0 && projected > preflightMaxEvents && !confirm {
+		message := fmt.Sprintf("Pre-flight check: run is projected to send %d events, more than preflightMaxEvents=%d. Set confirm option to proceed", projected, preflightMaxEvents)
+		session.Error(message)
+		_ = session.Reply("halt", map[string]any{
+			"status":  "error",
+			"message": message,
+			"data":    report,
+		})
+		exit(exitConfirmRequired)
+	}
+	rows := preflightRows
+	preflightRows = nil
+	for _, row := range rows {
+		handleRow(row)
+	}
+}
+
+// preflightReport projects the number of events, bytes, API calls and duration of the run from the sampled rows
+func preflightReport(complete bool) map[string]any {
+	sampled := len(preflightRows)
+	projected := sampled
+	projectedBytes := preflightBytes
+	days := daysToSend()
+	if !complete && len(preflightDays) > 0 && days > len(preflightDays) {
+		rowsPerDay := float64(sampled) / float64(len(preflightDays))
+		projected = int(math.Ceil(rowsPerDay * float64(days)))
+		projectedBytes = int(float64(preflightBytes) / float64(sampled) * float64(projected))
+	}
+	calls := int(math.Ceil(float64(projected) / float64(batchSize)))
+	report := map[string]any{
+		"sampledRows":     sampled,
+		"complete":        complete,
+		"days":            days,
+		"projectedEvents": projected,
+		"projectedBytes":  projectedBytes,
+		"apiCalls":        calls,
+	}
+	if maxEventsPerMinute > 0 {
+		report["estimatedDurationSeconds"] = int(math.Ceil(float64(projected) / float64(maxEventsPerMinute) * 60))
+	} else {
+		// rough estimate assuming a second per import call
+		report["estimatedDurationSeconds"] = calls
+	}
+	if budget.maxEvents > 0 {
+		report["eventsBudgetUsage"] = float64(projected) / float64(budget.maxEvents)
+	}
+	if budget.maxBytes > 0 {
+		report["bytesBudgetUsage"] = float64(projectedBytes) / float64(budget.maxBytes)
+	}
+	return report
+}
+
+// daysToSend returns the number of days the run is expected to send, the largest among tenants
+func daysToSend() int {
+	today := startTime.Truncate(time.Hour * 24)
+	end := today
+	if !endDate.IsZero() && endDate.Before(end) {
+		end = endDate
+	}
+	initialSyncStart := today.Add(time.Hour * 24 * time.Duration(-initialSyncDays))
+	days := 0
+	for _, t := range allTenants() {
+		start := initialSyncStart
+		if !startDate.IsZero() {
+			start = startDate
+		} else if !t.initialState.isZero() {
+			if lookbackStart := t.lastDate.Add(time.Hour * 24 * time.Duration(-lookbackWindow)); lookbackStart.After(start) {
+				start = lookbackStart
+			}
+		}
+		if d := int(end.Sub(start).Hours()/24) + 1; d > days {
+			days = d
+		}
+	}
+	if maxDaysPerRun > 0 && days > maxDaysPerRun {
+		days = maxDaysPerRun
+	}
+	return days
+}
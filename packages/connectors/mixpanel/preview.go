@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mixpanel/mixpanel-go"
+	"sync"
+)
+
+// previewEvents is the number of adapted events reported in preview reply on the first run of the sync, i.e.
+// when no tenant has state yet. Nothing is sent until the preview is complete, and unless confirm is set the run
+// halts after the preview, so mapping mistakes are caught before the whole history is imported
+var previewEvents = 0
+
+var previewLock sync.Mutex
+var previewPending = false
+var previewPayloads []json.RawMessage
+
+// startPreview enables preview if configured and the run is the first run of the sync. Must be called after state is loaded
+func startPreview() {
+	if previewEvents <= 0 || dryRun != nil {
+		return
+	}
+	for _, t := range allTenants() {
+		if !t.initialState.isZero() {
+			return
+		}
+	}
+	session.Info(fmt.Sprintf("First run of the sync. Preview of the first %d events will be sent before import", previewEvents))
+	previewPending = true
+}
+
+// addPreview records the event for preview. Finishes preview when previewEvents are recorded
+func addPreview(t *tenant, event *mixpanel.Event) {
+	previewLock.Lock()
+	defer previewLock.Unlock()
+	if !previewPending {
+		return
+	}
+	entry := map[string]any{
+		"event":      event.Name,
+		"properties": event.Properties,
+	}
+	if tenantColumn != "" {
+		entry["tenant"] = t.key
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		session.Error("Cannot serialize event for preview", err.Error())
+		return
+	}
+	previewPayloads = append(previewPayloads, b)
+	if len(previewPayloads) >= previewEvents {
+		finishPreviewLocked()
+	}
+}
+
+// finishPreview reports recorded events before the first batch is sent or at the end of the stream, if fewer
+// than previewEvents were produced. Halts the run unless confirm is set
+func finishPreview() {
+	previewLock.Lock()
+	defer previewLock.Unlock()
+	finishPreviewLocked()
+}
+
+func finishPreviewLocked() {
+	if !previewPending {
+		return
+	}
+	previewPending = false
+	if len(previewPayloads) == 0 {
+		return
+	}
+	report := map[string]any{
+		"events": previewPayloads,
+	}
+	_ = session.Reply("preview", report)
+	if !confirm {
+		message := fmt.Sprintf("First run preview: %d events were reported in preview reply and nothing was sent. Set confirm option to proceed", len(previewPayloads))
+		session.Error(message)
+		_ = session.Reply("halt", map[string]any{
+			"status":  "error",
+			"message": message,
+		})
+		exit(exitConfirmRequired)
+	}
+	previewPayloads = nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/mixpanel/mixpanel-go"
+	"time"
+)
+
+// Streams of the connector. AdData rows are sent as $ad_spend events, UserProfiles rows update user profiles
+// with Engage API. Events is a source stream, see export.go
+const (
+	streamAdData       = "AdData"
+	streamUserProfiles = "UserProfiles"
+	streamEvents       = "Events"
+)
+
+var currentStream = streamAdData
+
+// profilesStatusKey is the key of UserProfiles status in stream-result, instead of dates of AdData
+const profilesStatusKey = "profiles"
+
+// profileSetOnce are columns sent with $set_once, so values set before, e.g. by the product, are kept
+var profileSetOnce = map[string]bool{}
+
+// profileReservedColumns maps well-known columns to reserved profile properties
+var profileReservedColumns = map[string]string{
+	"email":        "$email",
+	"name":         "$name",
+	"first_name":   "$first_name",
+	"last_name":    "$last_name",
+	"phone":        "$phone",
+	"avatar":       "$avatar",
+	"created_at":   "$created",
+	"city":         "$city",
+	"region":       "$region",
+	"country_code": "$country_code",
+	"timezone":     "$timezone",
+	"ip":           "$ip",
+}
+
+// profile is a UserProfiles row mapped to $set and $set_once properties
+type profile struct {
+	distinctId string
+	set        map[string]any
+	setOnce    map[string]any
+}
+
+// makeProfile maps row to profile properties. Traits of properties column are merged with other columns.
+// Null values are skipped, so they don't erase traits set before. Returns nil if the row has no traits
+func makeProfile(row map[string]any) (*profile, error) {
+	distinctId, _ := canonicalString(row["distinct_id"])
+	if row["distinct_id"] == nil || distinctId == "" {
+		return nil, fmt.Errorf("distinct_id is missing")
+	}
+	p := &profile{distinctId: distinctId, set: map[string]any{}, setOnce: map[string]any{}}
+	add := func(column string, value any) {
+		if value == nil {
+			return
+		}
+		name, ok := profileReservedColumns[column]
+		if !ok {
+			name = propertyName(column)
+		}
+		if profileSetOnce[column] {
+			p.setOnce[name] = value
+		} else {
+			p.set[name] = value
+		}
+	}
+	for column, value := range row {
+		switch column {
+		case "distinct_id":
+		case "properties":
+			traits, _ := value.(map[string]any)
+			for c, v := range traits {
+				add(c, v)
+			}
+		default:
+			add(column, value)
+		}
+	}
+	if len(p.set) == 0 && len(p.setOnce) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// handleProfileRow passes UserProfiles row to the tenant worker
+func handleProfileRow(t *tenant, row map[string]any) {
+	p, err := makeProfile(row)
+	job := rowJob{date: profilesStatusKey, profile: p, err: err}
+	if err != nil {
+		job.key = rowKeyOf(row)
+	}
+	t.submit(job)
+}
+
+// addProfile adds the profile to the batch. Batch is sent when it reaches batchSize or Engage API limit
+func (t *tenant) addProfile(p *profile) {
+	status := t.getStatus(profilesStatusKey)
+	status.Received++
+	if p == nil {
+		status.Skipped++
+		return
+	}
+	t.profiles = append(t.profiles, p)
+	if len(t.profiles) >= min(batchSize, mixpanel.MaxPeopleEvents) {
+		t.sendProfiles()
+	}
+}
+
+// sendProfiles sends the batch with $set and $set_once requests. Rows are marked failed if any of them fails
+func (t *tenant) sendProfiles() {
+	if len(t.profiles) == 0 {
+		return
+	}
+	profiles := t.profiles
+	t.profiles = nil
+	var set, setOnce []*mixpanel.PeopleProperties
+	for _, p := range profiles {
+		if len(p.set) > 0 {
+			set = append(set, mixpanel.NewPeopleProperties(p.distinctId, p.set))
+		}
+		if len(p.setOnce) > 0 {
+			setOnce = append(setOnce, mixpanel.NewPeopleProperties(p.distinctId, p.setOnce))
+		}
+	}
+	pace(len(profiles))
+	status := t.getStatus(profilesStatusKey)
+	importStart := time.Now()
+	err := t.engage(set, setOnce)
+	t.importTime += time.Since(importStart)
+	if err != nil {
+		health.DestinationResult(err)
+		t.failed += len(profiles)
+		status.Failed += len(profiles)
+		status.addErrorSample(err.Error())
+		session.Error(fmt.Sprintf("%s error sending %d profiles", t.logPrefix(), len(profiles)), err.Error())
+		class, retryable := classifyImportError(err)
+		for _, p := range profiles {
+			t.replyRowError(rowKey{DistinctId: p.distinctId}, "", class, retryable, err.Error())
+		}
+		return
+	}
+	health.DestinationResult(nil)
+	t.imported += len(profiles)
+	status.Success += len(profiles)
+	session.Info(fmt.Sprintf("%s %d profiles sent", t.logPrefix(), len(profiles)))
+}
+
+func (t *tenant) engage(set, setOnce []*mixpanel.PeopleProperties) error {
+	if dryRun != nil {
+		dryRunEngage("$set", set)
+		dryRunEngage("$set_once", setOnce)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), throttledTimeout(time.Second*15, set, setOnce))
+	defer cancel()
+	if len(set) > 0 {
+		if err := t.mp.PeopleSet(ctx, set); err != nil {
+			return fmt.Errorf("$set: %w", err)
+		}
+	}
+	if len(setOnce) > 0 {
+		if err := t.mp.PeopleSetOnce(ctx, setOnce); err != nil {
+			return fmt.Errorf("$set_once: %w", err)
+		}
+	}
+	return nil
+}
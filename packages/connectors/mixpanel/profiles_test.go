@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMakeProfile(t *testing.T) {
+	profileSetOnce = map[string]bool{"created_at": true, "plan": true}
+	defer func() { profileSetOnce = map[string]bool{} }()
+	p, err := makeProfile(map[string]any{
+		"distinct_id": json.Number("42"),
+		"email":       "a@b.com",
+		"created_at":  "2024-01-01T00:00:00Z",
+		"city":        nil,
+		"properties":  map[string]any{"plan": "pro", "tier": "gold"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.distinctId != "42" {
+		t.Errorf("distinctId = %s, want 42", p.distinctId)
+	}
+	if want := map[string]any{"$email": "a@b.com", "tier": "gold"}; !reflect.DeepEqual(p.set, want) {
+		t.Errorf("set = %v, want %v", p.set, want)
+	}
+	if want := map[string]any{"$created": "2024-01-01T00:00:00Z", "plan": "pro"}; !reflect.DeepEqual(p.setOnce, want) {
+		t.Errorf("setOnce = %v, want %v", p.setOnce, want)
+	}
+
+	if _, err = makeProfile(map[string]any{"email": "a@b.com"}); err == nil {
+		t.Error("expected error for missing distinct_id")
+	}
+	if p, err = makeProfile(map[string]any{"distinct_id": "u1", "city": nil}); p != nil || err != nil {
+		t.Errorf("expected no profile without traits, got %v %v", p, err)
+	}
+}
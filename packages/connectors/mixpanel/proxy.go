@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Some Mixpanel projects accept calls only from allow-listed IPs. Requests to Mixpanel may go through HTTP or SOCKS5
+// proxy set by 'proxyUrl' option. With 'allowedEgressIps' the connector verifies egress IP before anything is sent:
+// the IP is returned as plain text by an echo endpoint, requested through the same proxy
+
+const (
+	defaultEgressIpEndpoint = "https://api.ipify.org"
+	egressIpTimeout         = 10 * time.Second
+)
+
+// baseTransport is the transport of requests to Mixpanel. It's http.DefaultTransport unless proxy is configured
+var baseTransport http.RoundTripper = http.DefaultTransport
+
+// proxyTransport is baseTransport with proxy settings only, without limits and metrics of imports. Used by health checks
+var proxyTransport http.RoundTripper = http.DefaultTransport
+
+var errEgressIpNotAllowed = errors.New("egress IP is not in allowedEgressIps")
+
+// configureProxy makes requests to Mixpanel go through the proxy. Supported schemes are http, https and socks5
+func configureProxy(proxyUrl string) error {
+	baseTransport = http.DefaultTransport
+	proxyTransport = http.DefaultTransport
+	if proxyUrl == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return fmt.Errorf("invalid proxyUrl: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("proxyUrl scheme must be http, https or socks5, got: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxyUrl must contain host")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(u)
+	baseTransport = transport
+	proxyTransport = transport
+	return nil
+}
+
+// parseAllowedIps parses allowedEgressIps option. Items are IP addresses or CIDR ranges
+func parseAllowedIps(raw []any) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(raw))
+	for _, item := range raw {
+		s, _ := item.(string)
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("allowedEgressIps item must be IP address or CIDR range, got: %v", item)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// verifyEgressIp resolves egress IP with the echo endpoint and checks that it's allowed
+func verifyEgressIp(endpoint string, allowed []netip.Prefix) (netip.Addr, error) {
+	if endpoint == "" {
+		endpoint = defaultEgressIpEndpoint
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), egressIpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid egressIpEndpoint: %w", err)
+	}
+	client := &http.Client{Transport: &taggingTransport{base: baseTransport}}
+	res, err := client.Do(req)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("cannot resolve egress IP: %w", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+	if res.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("cannot resolve egress IP: %s responded with status %d", endpoint, res.StatusCode)
+	}
+	ip, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("cannot resolve egress IP: %s responded with %q", endpoint, body)
+	}
+	ip = ip.Unmap()
+	for _, prefix := range allowed {
+		if prefix.Contains(ip) {
+			return ip, nil
+		}
+	}
+	return ip, fmt.Errorf("%w: %s", errEgressIpNotAllowed, ip)
+}
+
+// checkEgressIp halts the stream unless egress IP is allowed. Must be called before anything is sent to Mixpanel
+func checkEgressIp(endpoint string, allowed []netip.Prefix) {
+	ip, err := verifyEgressIp(endpoint, allowed)
+	if err != nil {
+		session.Error("Egress IP check failed", err.Error())
+		_ = session.Reply("halt", map[string]any{
+			"message": err.Error(),
+		})
+		if errors.Is(err, errEgressIpNotAllowed) {
+			exit(exitConfigError)
+		}
+		exit(exitUnavailable)
+	}
+	session.Info("Egress IP is allowed", ip.String())
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Data quality budget. Runs with too many data quality issues fail instead of degrading silently, e.g. when 40%
+// of rows fail coercion. With 'maxWarnings' the run fails once the total number of warnings exceeds it, with
+// 'maxFailedPercent' once the share of rows reported with row-error exceeds it. The share is checked after
+// qualityMinRows rows are received and at the end of the stream, so a few failed first rows don't fail the run.
+// Once exceeded, the stream is halted early: rows not sent yet are discarded, state of sent days is saved,
+// halt with diagnostics and stream-result with failed status are replied and the run exits with exitQualityFailed
+
+// qualityMinRows is the number of received rows after which maxFailedPercent is checked during the stream
+const qualityMinRows = 100
+
+var qualityBudget = &dataQualityBudget{maxWarnings: -1, maxFailedPercent: -1}
+
+type dataQualityBudget struct {
+	// negative limits are not checked
+	maxWarnings      int
+	maxFailedPercent float64
+
+	mu       sync.Mutex
+	received int
+	failed   int
+	warnings int
+}
+
+func configureQualityBudget(creds map[string]any) error {
+	if v, ok := creds["maxWarnings"].(float64); ok {
+		if v < 0 {
+			return fmt.Errorf("maxWarnings must not be negative, got %v", v)
+		}
+		qualityBudget.maxWarnings = int(v)
+	}
+	if v, ok := creds["maxFailedPercent"].(float64); ok {
+		if v < 0 || v > 100 {
+			return fmt.Errorf("maxFailedPercent must be between 0 and 100, got %v", v)
+		}
+		qualityBudget.maxFailedPercent = v
+	}
+	return nil
+}
+
+func (q *dataQualityBudget) enabled() bool {
+	return q.maxWarnings >= 0 || q.maxFailedPercent >= 0
+}
+
+func (q *dataQualityBudget) rowReceived() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.received++
+}
+
+func (q *dataQualityBudget) rowFailed() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failed++
+}
+
+func (q *dataQualityBudget) warning() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.warnings++
+}
+
+// exceeded returns the reason if the budget is exceeded. final is set at the end of the stream, when the share
+// of failed rows is checked regardless of the number of rows
+func (q *dataQualityBudget) exceeded(final bool) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxWarnings >= 0 && q.warnings > q.maxWarnings {
+		return fmt.Sprintf("%d warnings, more than maxWarnings=%d", q.warnings, q.maxWarnings)
+	}
+	if q.maxFailedPercent >= 0 && q.received > 0 && (final || q.received >= qualityMinRows) {
+		if percent := q.failedPercent(); percent > q.maxFailedPercent {
+			return fmt.Sprintf("%d of %d rows failed (%.1f%%), more than maxFailedPercent=%v", q.failed, q.received, percent, q.maxFailedPercent)
+		}
+	}
+	return ""
+}
+
+// failedPercent must be called with q.mu held
+func (q *dataQualityBudget) failedPercent() float64 {
+	if q.received == 0 {
+		return 0
+	}
+	return float64(q.failed) * 100 / float64(q.received)
+}
+
+// diagnostics returns details of the exceeded budget sent with halt and stream-result
+func (q *dataQualityBudget) diagnostics(reason string) map[string]any {
+	q.mu.Lock()
+	diagnostics := map[string]any{
+		"reason":        reason,
+		"received":      q.received,
+		"failed":        q.failed,
+		"failedPercent": q.failedPercent(),
+	}
+	q.mu.Unlock()
+	if q.maxWarnings >= 0 {
+		diagnostics["maxWarnings"] = q.maxWarnings
+	}
+	if q.maxFailedPercent >= 0 {
+		diagnostics["maxFailedPercent"] = q.maxFailedPercent
+	}
+	if warnings := warningsResult(); len(warnings) > 0 {
+		diagnostics["warnings"] = warnings
+	}
+	return diagnostics
+}
+
+// enforceQualityBudget halts the stream if the budget is exceeded. Called by the main loop between messages
+func enforceQualityBudget() {
+	if !qualityBudget.enabled() || !streamStarted || streamEnded {
+		return
+	}
+	reason := qualityBudget.exceeded(false)
+	if reason == "" {
+		return
+	}
+	failQualityBudget(reason)
+}
+
+// failQualityBudget replies halt and failed stream-result with diagnostics and exits
+func failQualityBudget(reason string) {
+	diagnostics := qualityBudget.diagnostics(reason)
+	message := "Data quality budget exceeded: " + reason
+	session.Error(message)
+	_ = session.Reply("halt", map[string]any{
+		"status":  "error",
+		"message": message,
+		"data":    diagnostics,
+	})
+	endRun("failed", true, map[string]any{"qualityBudget": diagnostics}, exitQualityFailed)
+}
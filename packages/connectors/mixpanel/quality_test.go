@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDataQualityBudget(t *testing.T) {
+	q := &dataQualityBudget{maxWarnings: -1, maxFailedPercent: 10}
+	for i := 0; i < qualityMinRows-1; i++ {
+		q.rowReceived()
+	}
+	for i := 0; i < 40; i++ {
+		q.rowFailed()
+	}
+	if reason := q.exceeded(false); reason != "" {
+		t.Errorf("failed percent must not be checked before %d rows: %s", qualityMinRows, reason)
+	}
+	if reason := q.exceeded(true); !strings.Contains(reason, "40 of 99 rows failed") {
+		t.Errorf("expected failed percent at the end of the stream, got %q", reason)
+	}
+	q.rowReceived()
+	if reason := q.exceeded(false); !strings.Contains(reason, "maxFailedPercent=10") {
+		t.Errorf("expected failed percent after %d rows, got %q", qualityMinRows, reason)
+	}
+
+	q = &dataQualityBudget{maxWarnings: 2, maxFailedPercent: -1}
+	q.warning()
+	q.warning()
+	if reason := q.exceeded(true); reason != "" {
+		t.Errorf("budget must not be exceeded at the limit: %s", reason)
+	}
+	q.warning()
+	if reason := q.exceeded(false); !strings.Contains(reason, "3 warnings") {
+		t.Errorf("expected warnings reason, got %q", reason)
+	}
+}
@@ -3,82 +3,229 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	daterange "github.com/felixenescu/date-range"
+	"sort"
+	"strings"
 	"time"
 )
 
-func marshalDateRanges(dr daterange.DateRanges) ([]byte, error) {
-	b, err := json.Marshal(dateRangesToAny(dr))
-	if err != nil {
-		return nil, fmt.Errorf("error marshalling date ranges: %v", err)
+// periodRange is an inclusive range of periods (days or hours, see granularity) identified by their start time
+type periodRange struct {
+	from time.Time
+	to   time.Time
+}
+
+// periodRanges are sorted ranges of periods. Overlapping and adjacent ranges are merged
+type periodRanges []periodRange
+
+// newPeriodRanges returns normalized ranges
+func newPeriodRanges(ranges ...periodRange) periodRanges {
+	var result periodRanges
+	result.append(ranges...)
+	return result
+}
+
+func (pr *periodRanges) append(ranges ...periodRange) {
+	merged := append(append(periodRanges{}, *pr...), ranges...)
+	sort.Slice(merged, func(i, j int) bool { return merged[i].from.Before(merged[j].from) })
+	result := periodRanges{}
+	for _, r := range merged {
+		if last := len(result) - 1; last >= 0 && !r.from.After(result[last].to.Add(periodDuration())) {
+			if r.to.After(result[last].to) {
+				result[last].to = r.to
+			}
+			continue
+		}
+		result = append(result, r)
 	}
-	return b, nil
+	*pr = result
+}
+
+// add adds the period starting at t
+func (pr *periodRanges) add(t time.Time) {
+	if !pr.contains(t) {
+		pr.append(periodRange{t, t})
+	}
+}
+
+func (pr periodRanges) contains(t time.Time) bool {
+	for _, r := range pr {
+		if !t.Before(r.from) && !t.After(r.to) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pr periodRanges) isZero() bool {
+	return len(pr) == 0
+}
+
+// last returns start of the last period
+func (pr periodRanges) last() time.Time {
+	if len(pr) == 0 {
+		return time.Time{}
+	}
+	return pr[len(pr)-1].to
+}
+
+func (pr periodRanges) equal(other periodRanges) bool {
+	if len(pr) != len(other) {
+		return false
+	}
+	for i := range pr {
+		if !pr[i].from.Equal(other[i].from) || !pr[i].to.Equal(other[i].to) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pr periodRanges) clone() periodRanges {
+	return append(periodRanges{}, pr...)
 }
 
-func dateRangesToAny(dr daterange.DateRanges) []any {
-	arr := make([]any, dr.Len())
-	for i, r := range dr.ToSlice() {
-		if r.From() == r.To() {
-			arr[i] = r.From().Format(time.DateOnly)
+func (pr periodRanges) String() string {
+	b, _ := json.Marshal(pr.toAny())
+	return string(b)
+}
+
+// remove returns ranges without the period starting at t
+func (pr periodRanges) remove(t time.Time) periodRanges {
+	result := periodRanges{}
+	step := periodDuration()
+	for _, r := range pr {
+		if t.Before(r.from) || t.After(r.to) {
+			result = append(result, r)
+			continue
+		}
+		if r.from.Before(t) {
+			result = append(result, periodRange{r.from, t.Add(-step)})
+		}
+		if r.to.After(t) {
+			result = append(result, periodRange{t.Add(step), r.to})
+		}
+	}
+	return result
+}
+
+// subtract returns ranges without periods of other
+func (pr periodRanges) subtract(other periodRanges) periodRanges {
+	result := pr.clone()
+	step := periodDuration()
+	for _, o := range other {
+		next := periodRanges{}
+		for _, r := range result {
+			if o.to.Before(r.from) || o.from.After(r.to) {
+				next = append(next, r)
+				continue
+			}
+			if r.from.Before(o.from) {
+				next = append(next, periodRange{r.from, o.from.Add(-step)})
+			}
+			if r.to.After(o.to) {
+				next = append(next, periodRange{o.to.Add(step), r.to})
+			}
+		}
+		result = next
+	}
+	return result
+}
+
+// toAny converts ranges to state value: dates with day granularity, RFC 3339 datetimes with hour granularity.
+// Single periods are strings, longer ranges are arrays of the first and the last period
+func (pr periodRanges) toAny() []any {
+	layout := time.DateOnly
+	if granularity == granularityHour {
+		layout = time.RFC3339
+	}
+	arr := make([]any, len(pr))
+	for i, r := range pr {
+		if r.from.Equal(r.to) {
+			arr[i] = r.from.Format(layout)
 		} else {
-			arr[i] = []string{r.From().Format(time.DateOnly), r.To().Format(time.DateOnly)}
+			arr[i] = []string{r.from.Format(layout), r.to.Format(layout)}
 		}
 	}
 	return arr
 }
 
-func dateRangesFromAny(raw any) (daterange.DateRanges, error) {
-	nl := daterange.NewDateRanges()
+func marshalPeriodRanges(pr periodRanges) ([]byte, error) {
+	b, err := json.Marshal(pr.toAny())
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling date ranges: %v", err)
+	}
+	return b, nil
+}
+
+// periodRangesFromAny parses state value. State saved with the other granularity is converted: days are
+// expanded to all hours of the days, hours are truncated to their days
+func periodRangesFromAny(raw any) (periodRanges, error) {
 	switch arr := raw.(type) {
 	case []any:
-		dr := daterange.NewDateRanges()
+		result := periodRanges{}
 		for _, r := range arr {
 			switch mr := r.(type) {
 			case string:
-				start, err := time.Parse(time.DateOnly, mr)
+				from, to, err := parseStatePeriod(mr)
 				if err != nil {
-					return nl, fmt.Errorf("error parsing date: %v", err)
+					return nil, fmt.Errorf("error parsing date: %v", err)
 				}
-				dr.Append(daterange.NewDateRange(start, start))
+				result = append(result, periodRange{from, to})
 			case []any:
 				if len(mr) != 2 {
-					return nl, fmt.Errorf("expected array of length 2, got %v", mr)
+					return nil, fmt.Errorf("expected array of length 2, got %v", mr)
 				}
 				s, _ := mr[0].(string)
 				e, _ := mr[1].(string)
-				start, err := time.Parse(time.DateOnly, s)
+				from, _, err := parseStatePeriod(s)
 				if err != nil {
-					return nl, fmt.Errorf("error parsing start date: %v", err)
+					return nil, fmt.Errorf("error parsing start date: %v", err)
 				}
-				end, err := time.Parse(time.DateOnly, e)
+				_, to, err := parseStatePeriod(e)
 				if err != nil {
-					return nl, fmt.Errorf("error parsing start date: %v", err)
+					return nil, fmt.Errorf("error parsing end date: %v", err)
 				}
-				dr.Append(daterange.NewDateRange(start, end))
+				result = append(result, periodRange{from, to})
 			default:
-				return nl, fmt.Errorf("expected array, got %T", r)
+				return nil, fmt.Errorf("expected array, got %T", r)
 			}
 		}
-		return dr, nil
+		return newPeriodRanges(result...), nil
 	case map[string]any:
 		if len(arr) > 0 {
-			return nl, fmt.Errorf("expected array of ranges, got map: %+v", arr)
-		} else {
-			return nl, nil
+			return nil, fmt.Errorf("expected array of ranges, got map: %+v", arr)
 		}
+		return periodRanges{}, nil
 	case nil:
-		return nl, nil
+		return periodRanges{}, nil
 	default:
-		return nl, fmt.Errorf("expected array of ranges, got %T", raw)
+		return nil, fmt.Errorf("expected array of ranges, got %T", raw)
 	}
+}
 
+// parseStatePeriod parses date or datetime of state. Returns the first and the last period of the configured
+// granularity it covers
+func parseStatePeriod(s string) (time.Time, time.Time, error) {
+	if !strings.Contains(s, "T") {
+		day, err := time.Parse(time.DateOnly, s)
+		if err != nil || granularity == granularityDay {
+			return day, day, err
+		}
+		return day, day.Add(time.Hour * 23), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, t, err
+	}
+	t = truncatePeriod(t)
+	return t, t, nil
 }
 
-func unmarshalDateRanges(b []byte) (daterange.DateRanges, error) {
+func unmarshalPeriodRanges(b []byte) (periodRanges, error) {
 	var raw any
 	err := json.Unmarshal(b, &raw)
 	if err != nil {
-		return daterange.NewDateRanges(), fmt.Errorf("error unmarshalling date ranges: %v", err)
+		return periodRanges{}, fmt.Errorf("error unmarshalling date ranges: %v", err)
 	}
-	return dateRangesFromAny(raw)
+	return periodRangesFromAny(raw)
 }
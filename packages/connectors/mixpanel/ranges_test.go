@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemoveDate(t *testing.T) {
+	d := func(s string) time.Time {
+		v, _ := time.Parse(time.DateOnly, s)
+		return v
+	}
+	dr := newPeriodRanges(periodRange{d("2024-01-01"), d("2024-01-05")}, periodRange{d("2024-01-10"), d("2024-01-10")})
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2024-01-03", `["2024-01-01","2024-01-02"],["2024-01-04","2024-01-05"],"2024-01-10"`},
+		{"2024-01-01", `["2024-01-02","2024-01-05"],"2024-01-10"`},
+		{"2024-01-10", `["2024-01-01","2024-01-05"]`},
+		{"2024-01-07", `["2024-01-01","2024-01-05"],"2024-01-10"`},
+	}
+	for _, tt := range tests {
+		b, err := marshalPeriodRanges(dr.remove(d(tt.date)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b); got != "["+tt.want+"]" {
+			t.Errorf("remove(%s) = %s, want [%s]", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	d := func(s string) time.Time {
+		v, _ := time.Parse(time.DateOnly, s)
+		return v
+	}
+	dr := newPeriodRanges(periodRange{d("2024-01-01"), d("2024-01-10")}, periodRange{d("2024-01-20"), d("2024-01-25")})
+	got := dr.subtract(newPeriodRanges(periodRange{d("2024-01-03"), d("2024-01-04")}, periodRange{d("2024-01-09"), d("2024-01-21")})).String()
+	if want := `[["2024-01-01","2024-01-02"],["2024-01-05","2024-01-08"],["2024-01-22","2024-01-25"]]`; got != want {
+		t.Errorf("subtract = %s, want %s", got, want)
+	}
+	if got = dr.subtract(newPeriodRanges(periodRange{d("2023-12-01"), d("2024-02-01")})).String(); got != "[]" {
+		t.Errorf("subtract of covering range = %s", got)
+	}
+}
+
+func TestHourRanges(t *testing.T) {
+	granularity = granularityHour
+	defer func() { granularity = granularityDay }()
+	h := func(s string) time.Time {
+		v, _ := time.Parse(hourLayout, s)
+		return v
+	}
+	var pr periodRanges
+	for _, hour := range []string{"2024-01-01T22", "2024-01-01T23", "2024-01-02T00", "2024-01-02T05"} {
+		pr.add(h(hour))
+	}
+	if got := pr.String(); got != `[["2024-01-01T22:00:00Z","2024-01-02T00:00:00Z"],"2024-01-02T05:00:00Z"]` {
+		t.Errorf("hour ranges = %s", got)
+	}
+	if !pr.contains(h("2024-01-01T23")) || pr.contains(h("2024-01-02T01")) {
+		t.Error("unexpected contains result")
+	}
+	if got := pr.remove(h("2024-01-01T23")).String(); got != `["2024-01-01T22:00:00Z","2024-01-02T00:00:00Z","2024-01-02T05:00:00Z"]` {
+		t.Errorf("remove = %s", got)
+	}
+	// state saved with day granularity covers all hours of the days
+	days, err := unmarshalPeriodRanges([]byte(`[["2024-01-01","2024-01-02"]]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := days.String(); got != `[["2024-01-01T00:00:00Z","2024-01-02T23:00:00Z"]]` {
+		t.Errorf("day state with hour granularity = %s", got)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// captureResponses is the number of last import responses kept in state for post-mortem debugging. 0 disables capturing
+var captureResponses = 0
+
+// captureFile is a local file where all import responses are appended as NDJSON
+var captureFile string
+
+var captureFileLock sync.Mutex
+
+// maxCapturedFailedRecords limits the number of failed records kept per response
+const maxCapturedFailedRecords = 10
+
+// importResponse is an envelope of Mixpanel import response
+type importResponse struct {
+	At                 string                         `json:"at"`
+	Tenant             string                         `json:"tenant,omitempty"`
+	Date               string                         `json:"date"`
+	Events             int                            `json:"events"`
+	Code               int                            `json:"code"`
+	NumRecordsImported int                            `json:"num_records_imported"`
+	Status             any                            `json:"status,omitempty"`
+	Error              string                         `json:"error,omitempty"`
+	FailedRecords      []mixpanel.ImportFailedRecords `json:"failed_records,omitempty"`
+	TotalFailedRecords int                            `json:"total_failed_records,omitempty"`
+}
+
+func (t *tenant) responsesKey() []string {
+	key := []string{"syncId=" + syncId, "type=mixpanel.responses"}
+	if t.key != "" {
+		key = append(key, "tenant="+t.key)
+	}
+	return key
+}
+
+// captureResponse records the response of importing events
+func (t *tenant) captureResponse(events int, res *mixpanel.ImportSuccess, err error) {
+	if captureResponses <= 0 && captureFile == "" {
+		return
+	}
+	r := importResponse{
+		At:     time.Now().UTC().Format(time.RFC3339Nano),
+		Tenant: t.key,
+		Date:   t.lastProcessedDate,
+		Events: events,
+	}
+	if res != nil {
+		r.Code = res.Code
+		r.NumRecordsImported = res.NumRecordsImported
+		r.Status = res.Status
+	}
+	if err != nil {
+		r.Error = err.Error()
+		var validationErr mixpanel.ImportFailedValidationError
+		var genericErr mixpanel.ImportGenericError
+		var rateLimitErr mixpanel.ImportRateLimitError
+		switch {
+		case errors.As(err, &validationErr):
+			r.Code = validationErr.Code
+			r.NumRecordsImported = validationErr.NumRecordsImported
+			r.Status = validationErr.Status
+			r.TotalFailedRecords = len(validationErr.FailedImportRecords)
+			r.FailedRecords = validationErr.FailedImportRecords[:min(len(validationErr.FailedImportRecords), maxCapturedFailedRecords)]
+		case errors.As(err, &genericErr):
+			r.Code = genericErr.Code
+			r.Status = genericErr.Status
+		case errors.As(err, &rateLimitErr):
+			r.Code = rateLimitErr.Code
+			r.Status = rateLimitErr.Status
+		}
+	}
+	if captureResponses > 0 {
+		t.responses = append(t.responses, r)
+		if len(t.responses) > captureResponses {
+			t.responses = t.responses[len(t.responses)-captureResponses:]
+		}
+	}
+	if captureFile != "" {
+		b, _ := json.Marshal(r)
+		captureFileLock.Lock()
+		defer captureFileLock.Unlock()
+		f, err := os.OpenFile(captureFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			session.Error("Cannot open capture file", err.Error())
+			captureFile = ""
+			return
+		}
+		defer f.Close()
+		if _, err = f.Write(append(b, '\n')); err != nil {
+			session.Error("Cannot write capture file", err.Error())
+		}
+	}
+}
+
+// saveResponses saves captured responses to state along with responses of previous runs, keeping last captureResponses
+func (t *tenant) saveResponses() {
+	if captureResponses <= 0 || len(t.responses) == 0 {
+		return
+	}
+	raw, err := rpcClient.Get(t.responsesKey())
+	if err != nil {
+		session.Error("Error getting captured responses", err.Error())
+	}
+	existing, _ := raw.([]any)
+	all := make([]any, 0, len(existing)+len(t.responses))
+	all = append(all, existing...)
+	for _, r := range t.responses {
+		all = append(all, r)
+	}
+	if len(all) > captureResponses {
+		all = all[len(all)-captureResponses:]
+	}
+	if err = rpcClient.Set(t.responsesKey(), all); err != nil {
+		session.Error("Error saving captured responses", err.Error())
+		return
+	}
+	session.Debug(fmt.Sprintf("%d import responses saved", len(t.responses)), t.responsesKey())
+}
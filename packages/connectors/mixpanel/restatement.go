@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Fingerprint of the project token is kept in state, so the connector detects that data of the sync goes to another
+// project than before, e.g. the token was replaced after the project was purged or recreated. Periods of the state
+// are missing in the new project, so the connector requests their restatement with request-restatement reply.
+// The host passes requested ranges to restate of start-stream of the next run, and the connector removes them
+// from the state before processing rows
+
+// restateRanges are ranges of start-stream restate
+var restateRanges []sdk.RestatementRange
+
+func (t *tenant) projectKey() []string {
+	key := []string{"syncId=" + syncId, "type=mixpanel.project"}
+	if t.key != "" {
+		key = append(key, "tenant="+t.key)
+	}
+	return key
+}
+
+func projectFingerprint(projectToken string) string {
+	h := sha256.Sum256([]byte(projectToken))
+	return hex.EncodeToString(h[:8])
+}
+
+// checkProject requests restatement of the loaded state if the project changed since the previous run.
+// Must be called after loadState
+func (t *tenant) checkProject() {
+	fingerprint := projectFingerprint(t.projectToken)
+	raw, err := rpcClient.Get(t.projectKey())
+	if err != nil {
+		session.Error("Error getting project fingerprint", err.Error())
+		return
+	}
+	value, _ := raw.(map[string]any)
+	previous, _ := value["fingerprint"].(string)
+	if previous == fingerprint {
+		return
+	}
+	if previous != "" && !t.initialState.isZero() {
+		requestRestatement(t, t.initialState, "project token changed, periods sent by previous runs are in another project")
+	}
+	if err = rpcClient.Set(t.projectKey(), map[string]any{"fingerprint": fingerprint}); err != nil {
+		session.Error("Error saving project fingerprint", err.Error())
+	}
+}
+
+// requestRestatement asks the host to resend the periods, even though state says they are sent
+func requestRestatement(t *tenant, ranges periodRanges, reason string) {
+	layout := time.DateOnly
+	if granularity == granularityHour {
+		layout = time.RFC3339
+	}
+	payload := make([]map[string]string, len(ranges))
+	for i, r := range ranges {
+		payload[i] = map[string]string{"from": r.from.Format(layout), "to": r.to.Format(layout)}
+	}
+	logMessage := fmt.Sprintf("Requesting restatement of %s: %s", ranges, reason)
+	if t.key != "" {
+		logMessage = fmt.Sprintf("[%s] %s", t.key, logMessage)
+	}
+	session.Warn(logMessage)
+	message := map[string]any{"ranges": payload, "reason": reason}
+	if t.key != "" {
+		message["tenant"] = t.key
+	}
+	_ = session.Reply("request-restatement", message)
+}
+
+// applyRestatement removes restated ranges of the tenant from the loaded state, so their rows are sent again even
+// if they are older than lookback window. The state is saved right away: if the run fails, later runs resend
+// the ranges too. Must be called after loadState
+func (t *tenant) applyRestatement() {
+	var ranges periodRanges
+	for _, r := range restateRanges {
+		if r.Tenant != "" && r.Tenant != t.key {
+			continue
+		}
+		from, _, err := parseStatePeriod(r.From)
+		if err == nil {
+			var to time.Time
+			_, to, err = parseStatePeriod(r.To)
+			if err == nil {
+				ranges.append(periodRange{from, to})
+				continue
+			}
+		}
+		session.Warn(fmt.Sprintf("Invalid restate range %s..%s", r.From, r.To), err.Error())
+	}
+	state := t.initialState.subtract(ranges)
+	if state.equal(t.initialState) {
+		return
+	}
+	logMessage := fmt.Sprintf("Restating %s. State: %s", ranges, state)
+	if t.key != "" {
+		logMessage = fmt.Sprintf("[%s] %s", t.key, logMessage)
+	}
+	session.Info(logMessage)
+	t.initialState = state
+	t.processedRanges = state.clone()
+	t.lastDate = state.last()
+	t.commitState(state)
+}
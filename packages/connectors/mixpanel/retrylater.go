@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// retryLaterThreshold enables retry-later replies. When Mixpanel rate limits with Retry-After of at least
+// this duration, the run ends instead of sleeping inside the container, so the host can reschedule it. 0 disables
+var retryLaterThreshold time.Duration
+
+// retryLaterRequests receives the delay suggested by the first rate limited tenant
+var retryLaterRequests = make(chan time.Duration, 1)
+
+// retryAfterRecorder is an http transport that remembers Retry-After of the last response,
+// since mixpanel client doesn't expose response headers
+type retryAfterRecorder struct {
+	base       http.RoundTripper
+	mu         sync.Mutex
+	retryAfter time.Duration
+}
+
+func (r *retryAfterRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	var retryAfter time.Duration
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	r.mu.Lock()
+	r.retryAfter = retryAfter
+	r.mu.Unlock()
+	return resp, err
+}
+
+// lastRetryAfter returns Retry-After of the last response. 0 if the response wasn't rate limited or had no header
+func (r *retryAfterRecorder) lastRetryAfter() time.Duration {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.retryAfter
+}
+
+// parseRetryAfter parses Retry-After header value: either delay in seconds or HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(time.Now()) {
+		return time.Until(t).Round(time.Second)
+	}
+	return 0
+}
+
+// deferRetryLater checks whether the rate limited import should end the run with retry-later reply.
+// If so, the tenant stops sending and the rest of the current day is left for the next run
+func (t *tenant) deferRetryLater(b *eventBatch, eventsCount int) bool {
+	delay := t.rateLimits.lastRetryAfter()
+	if retryLaterThreshold <= 0 || delay < retryLaterThreshold {
+		return false
+	}
+	session.Warn(fmt.Sprintf("%s Mixpanel rate limited the import with Retry-After %s. Ending the run, the next run resumes from %s", t.periodPrefix(b.date), delay, b.date))
+	t.retryLaterDelay = delay
+	b.status.Skipped += eventsCount
+	b.dropped = true
+	select {
+	case retryLaterRequests <- delay:
+	default:
+	}
+	return true
+}
+
+// startRetryLaterListener ends the run once a tenant is rate limited for longer than retryLaterThreshold:
+//
+//	{"type":"retry-later","payload":{"delaySeconds":900,"retryAt":"2024-05-01T10:15:00Z","committed":true}}
+//
+// Rows sent so far are committed to the state (unless the run is atomic), so the rescheduled run resumes from
+// the first day that wasn't sent. Reply is followed by stream-result with "retry_later" status.
+func startRetryLaterListener() {
+	if retryLaterThreshold <= 0 {
+		return
+	}
+	go func() {
+		delay := <-retryLaterRequests
+		runMu.Lock()
+		if streamEnded {
+			runMu.Unlock()
+			return
+		}
+		_ = session.Reply("retry-later", map[string]any{
+			"delaySeconds": int(delay.Seconds()),
+			"retryAt":      time.Now().Add(delay).UTC().Format(time.RFC3339),
+			"reason":       "Mixpanel rate limit",
+			"committed":    !atomicRun,
+		})
+		endRun("retry_later", true, map[string]any{"retryAfterSeconds": int(delay.Seconds())}, exitRetryLater)
+	}()
+}
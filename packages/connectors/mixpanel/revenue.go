@@ -0,0 +1,44 @@
+package main
+
+// Revenue attributed to ads is delivered in conversion_value column, revenue column is accepted as an alias.
+// It's sent as conversion_value property of $ad_spend, in currency of cost. With computeRoas the event also gets
+// roas property: return on ad spend, conversion value divided by cost. ROAS isn't set for rows without cost
+// or without conversion value, so 0 always means that the spend brought no revenue
+
+// computeRoas adds roas property derived from conversion value and cost
+var computeRoas = false
+
+// conversionValue returns conversion value of the row. The second return value is false if the row has neither
+// conversion_value nor revenue column
+func conversionValue(payload *RowPayload) (float64, bool) {
+	if payload.ConversionValue != nil {
+		return *payload.ConversionValue, true
+	}
+	if payload.Revenue != nil {
+		return *payload.Revenue, true
+	}
+	return 0, false
+}
+
+// setRevenueProperties sets conversion_value and roas properties of the event
+func setRevenueProperties(properties map[string]any, payload *RowPayload, cost float64) {
+	value, ok := conversionValue(payload)
+	if !ok {
+		return
+	}
+	properties["conversion_value"] = value
+	if computeRoas && cost > 0 {
+		properties["roas"] = value / cost
+	}
+}
+
+// revenueColumn returns the column conversion value of the row is taken from. Empty if there is none
+func revenueColumn(row map[string]any) string {
+	if row["conversion_value"] != nil {
+		return "conversion_value"
+	}
+	if row["revenue"] != nil {
+		return "revenue"
+	}
+	return ""
+}
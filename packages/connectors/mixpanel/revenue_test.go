@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+func TestRevenueProperties(t *testing.T) {
+	defer func() { computeRoas = false }()
+	computeRoas = true
+	tests := []struct {
+		row  map[string]any
+		cost float64
+		want map[string]any
+	}{
+		{map[string]any{"conversion_value": json.Number("50")}, 20, map[string]any{"conversion_value": 50.0, "roas": 2.5}},
+		{map[string]any{"revenue": 30.0, "conversion_value": json.Number("10")}, 20, map[string]any{"conversion_value": 10.0, "roas": 0.5}},
+		{map[string]any{"revenue": json.Number("0")}, 20, map[string]any{"conversion_value": 0.0, "roas": 0.0}},
+		{map[string]any{"revenue": json.Number("40")}, 0, map[string]any{"conversion_value": 40.0}},
+		{map[string]any{}, 20, map[string]any{}},
+	}
+	for _, test := range tests {
+		var payload RowPayload
+		if err := mapstructure.Decode(test.row, &payload); err != nil {
+			t.Fatal(err)
+		}
+		properties := map[string]any{}
+		setRevenueProperties(properties, &payload, test.cost)
+		if len(properties) != len(test.want) {
+			t.Errorf("%v: properties %v, want %v", test.row, properties, test.want)
+			continue
+		}
+		for name, value := range test.want {
+			if properties[name] != value {
+				t.Errorf("%v: properties %v, want %v", test.row, properties, test.want)
+			}
+		}
+	}
+}
+
+func TestConvertCurrency(t *testing.T) {
+	defer func() { targetCurrency, exchangeRates = "", make(map[string]*big.Rat) }()
+	if err := configureCurrencyConversion("usd", map[string]any{"eur": json.Number("1.08"), "GBP": 1.25}); err != nil {
+		t.Fatal(err)
+	}
+	properties := map[string]any{"$ad_cost": 10.0, "conversion_value": 25.0, "roas": 2.5}
+	if err := convertCurrency(properties, &RowPayload{Currency: "EUR"}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"$ad_cost": 10.8, "conversion_value": 27.0, "roas": 2.5, "currency": "USD", "original_cost": 10.0, "original_currency": "EUR"}
+	for name, value := range want {
+		if properties[name] != value {
+			t.Errorf("properties %v, want %v", properties, want)
+		}
+	}
+	properties = map[string]any{"$ad_cost": 10.0}
+	if err := convertCurrency(properties, &RowPayload{}); err != nil || properties["$ad_cost"] != 10.0 || properties["original_currency"] != "USD" {
+		t.Errorf("row without currency must be kept in target currency: %v, %v", properties, err)
+	}
+	if err := convertCurrency(map[string]any{"$ad_cost": 10.0}, &RowPayload{Currency: "JPY"}); err == nil {
+		t.Errorf("row in currency without exchange rate must fail")
+	}
+	if err := configureCurrencyConversion("USD", map[string]any{"EUR": -1.0}); err == nil {
+		t.Errorf("negative exchange rate must be rejected")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// Failed rows are reported to the host one by one with row-error reply, so it can route them to a dead-letter store:
+//
+//	{"type":"row-error","payload":{"key":{"date":"2024-05-01","source":"google","campaign_id":"42"},"insertId":"...","class":"api","retryable":true,"message":"..."}}
+//
+// Rows are identified by key columns, the row itself is not sent back
+
+// Classes of row errors
+const (
+	// rowErrorValidation means the row is invalid and is rejected the same way if resent
+	rowErrorValidation = "validation"
+	// rowErrorApi means Mixpanel failed to import the row
+	rowErrorApi = "api"
+	// rowErrorRateLimit means the row wasn't imported because Mixpanel rate limited the project
+	rowErrorRateLimit = "rate-limit"
+)
+
+// rowKey identifies a failed row
+type rowKey struct {
+	Date       string `json:"date,omitempty"`
+	Source     string `json:"source,omitempty"`
+	CampaignId string `json:"campaign_id,omitempty"`
+	DistinctId string `json:"distinct_id,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+}
+
+// rowKeyOf returns key of the raw row
+func rowKeyOf(row map[string]any) rowKey {
+	var key rowKey
+	key.Date, _ = canonicalString(row["date"])
+	key.Source, _ = canonicalString(row["source"])
+	key.CampaignId, _ = canonicalString(row["campaign_id"])
+	key.DistinctId, _ = canonicalString(row["distinct_id"])
+	return key
+}
+
+// payloadKey returns key of the normalized row
+func payloadKey(payload *RowPayload) rowKey {
+	return rowKey{Date: payload.Date, Source: payload.Source, CampaignId: payload.CampaignId}
+}
+
+// replyRowError reports the failed row of the tenant
+func (t *tenant) replyRowError(key rowKey, insertId string, class string, retryable bool, message string) {
+	key.Tenant = t.key
+	qualityBudget.rowFailed()
+	payload := map[string]any{
+		"key":       key,
+		"class":     class,
+		"retryable": retryable,
+		"message":   message,
+	}
+	if insertId != "" {
+		payload["insertId"] = insertId
+	}
+	_ = session.Reply("row-error", payload)
+}
+
+// replyImportErrors reports events of the batch that failed to import with err
+func (t *tenant) replyImportErrors(b *eventBatch, insertIds []string, err error) {
+	class, retryable := classifyImportError(err)
+	for _, id := range insertIds {
+		t.replyRowError(b.keys[id], id, class, retryable, err.Error())
+	}
+}
+
+// classifyImportError returns class of the import error and whether resending the rows may succeed
+func classifyImportError(err error) (string, bool) {
+	var validationErr mixpanel.ImportFailedValidationError
+	var genericErr mixpanel.ImportGenericError
+	var rateLimitErr mixpanel.ImportRateLimitError
+	switch {
+	case errors.As(err, &rateLimitErr):
+		return rowErrorRateLimit, true
+	case errors.As(err, &validationErr):
+		return rowErrorValidation, false
+	case errors.As(err, &genericErr) && genericErr.Code == http.StatusUnauthorized:
+		return rowErrorApi, false
+	}
+	return rowErrorApi, true
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// RowsFile points start-stream at a file with all rows of the stream instead of sending them to stdin:
+//
+//	{"type":"start-stream","payload":{"stream":"AdData","syncId":"...","rowsFile":{"url":"https://bucket.s3.amazonaws.com/rows.parquet?X-Amz-Signature=...","format":"parquet"}}}
+//
+// Either path of a local file or http(s) URL, e.g. signed URL of object storage, is set. The connector reads
+// rows from the file and ends the stream itself, the host must not send rows or end-stream. A retried run
+// re-reads the same file, so the host doesn't need to keep the rows
+type RowsFile struct {
+	Path        string                `json:"path,omitempty"`
+	Url         string                `json:"url,omitempty"`
+	Format      string                `json:"format,omitempty"`
+	ColumnTypes map[string]ColumnHint `json:"columnTypes,omitempty"`
+}
+
+// rowsFile is set by start-stream with rowsFile and read once start-stream is processed
+var rowsFile *RowsFile
+
+// parseRowsFile reads rowsFile of start-stream payload. Returns nil if it is not set
+func parseRowsFile(raw json.RawMessage) (*RowsFile, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var f RowsFile
+	if err := decodeRowMessage(raw, &f); err != nil {
+		return nil, fmt.Errorf("invalid rowsFile: %w", err)
+	}
+	if (f.Path == "") == (f.Url == "") {
+		return nil, fmt.Errorf("rowsFile must have either path or url")
+	}
+	if f.Format != "" && f.Format != "parquet" {
+		return nil, fmt.Errorf("unsupported rowsFile format '%s'. Supported formats: parquet", f.Format)
+	}
+	return &f, nil
+}
+
+// streamRowsFile reads rows of the file and ends the stream. Rows are accepted one by one under runMu,
+// so watchdog and retry-later listener may end the run in between
+func streamRowsFile(f *RowsFile) {
+	location := f.Path
+	if location == "" {
+		location = f.Url
+	}
+	reader, closeFile, err := sdk.OpenParquet(location)
+	if err != nil {
+		failRowsFile(err)
+	}
+	defer func() { _ = closeFile() }()
+	rows, err := acceptReaderRows(reader, f.ColumnTypes, func(row map[string]any) {
+		runMu.Lock()
+		defer runMu.Unlock()
+		if !streamEnded {
+			acceptRow(row)
+			enforceQualityBudget()
+		}
+	})
+	if err != nil {
+		failRowsFile(err)
+	}
+	runMu.Lock()
+	defer runMu.Unlock()
+	session.Info(fmt.Sprintf("Read %d rows from rows file", rows))
+	if !streamEnded {
+		endStream()
+	}
+}
+
+func failRowsFile(err error) {
+	runMu.Lock()
+	// url is not logged, signed urls are credentials
+	session.Error("Cannot read rows file", err.Error())
+	_ = session.Reply("halt", map[string]any{
+		"message": "Cannot read rows file: " + err.Error(),
+	})
+	exit(exitError)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Diff report between consecutive runs. Aggregates of rows sent by the run, the number of rows and cost per source,
+// are saved at ["syncId=<sync id>", "type=mixpanel.runstats"] at the end of the stream. The next run compares its
+// aggregates with them and reports deltas in stream-result under 'diff'. If a total changes by more than
+// 'diffThresholdPercent' (50 by default, 0 disables warnings), run_diff warning is reported, so upstream regressions,
+// e.g. a source that stopped delivering cost, are noticed
+
+var diffThresholdPercent = 50.0
+
+var runStatsLock sync.Mutex
+var runStats = make(map[string]*sourceStats)
+
+type sourceStats struct {
+	Rows int     `json:"rows"`
+	Cost float64 `json:"cost"`
+}
+
+type savedRunStats struct {
+	RunId   string                  `json:"runId"`
+	SavedAt string                  `json:"savedAt"`
+	Sources map[string]*sourceStats `json:"sources"`
+}
+
+func runStatsKey() []string {
+	return []string{"syncId=" + syncId, "type=mixpanel.runstats"}
+}
+
+// recordRunStats counts the row queued to be sent
+func recordRunStats(source string, cost float64) {
+	runStatsLock.Lock()
+	defer runStatsLock.Unlock()
+	stats, ok := runStats[source]
+	if !ok {
+		stats = &sourceStats{}
+		runStats[source] = stats
+	}
+	stats.Rows++
+	stats.Cost += cost
+}
+
+// loadRunStats returns aggregates saved by the previous run or nil
+func loadRunStats() *savedRunStats {
+	raw, err := rpcClient.Get(runStatsKey())
+	if err != nil {
+		session.Error("Error getting stats of the previous run", err.Error())
+		return nil
+	}
+	if raw == nil {
+		return nil
+	}
+	b, _ := json.Marshal(raw)
+	var saved savedRunStats
+	if err = json.Unmarshal(b, &saved); err != nil || saved.Sources == nil {
+		session.Debug("Ignoring invalid stats of the previous run", string(b))
+		return nil
+	}
+	return &saved
+}
+
+// saveRunStats saves aggregates of the run for the next run to compare with
+func saveRunStats() {
+	runStatsLock.Lock()
+	saved := savedRunStats{RunId: runId, SavedAt: time.Now().UTC().Format(time.RFC3339), Sources: runStats}
+	err := rpcClient.Set(runStatsKey(), saved)
+	runStatsLock.Unlock()
+	if err != nil {
+		session.Error("Error saving stats of the run", err.Error())
+	}
+}
+
+// compareRunStats reports run_diff warnings and returns the diff with the previous run, or nil on the first run
+func compareRunStats() map[string]any {
+	if syncId == "" {
+		return nil
+	}
+	previous := loadRunStats()
+	if previous == nil {
+		return nil
+	}
+	runStatsLock.Lock()
+	diff, anomalies := diffRunStats(previous.Sources, runStats, diffThresholdPercent)
+	runStatsLock.Unlock()
+	diff["previousRunId"] = previous.RunId
+	diff["previousRunAt"] = previous.SavedAt
+	for _, anomaly := range anomalies {
+		warning(warningRunDiff, anomaly)
+	}
+	return diff
+}
+
+// diffRunStats returns deltas of totals and of each source, and descriptions of changes beyond the threshold.
+// Zero threshold disables anomalies
+func diffRunStats(previous, current map[string]*sourceStats, threshold float64) (map[string]any, []string) {
+	var anomalies []string
+	compare := func(name string, prev, cur sourceStats) map[string]any {
+		delta := map[string]any{
+			"rows":         cur.Rows,
+			"previousRows": prev.Rows,
+			"cost":         cur.Cost,
+			"previousCost": prev.Cost,
+		}
+		for _, metric := range []struct {
+			name      string
+			prev, cur float64
+		}{{"rows", float64(prev.Rows), float64(cur.Rows)}, {"cost", prev.Cost, cur.Cost}} {
+			if metric.prev == 0 {
+				continue
+			}
+			change := (metric.cur - metric.prev) * 100 / metric.prev
+			delta[metric.name+"ChangePercent"] = change
+			if threshold > 0 && math.Abs(change) > threshold {
+				anomalies = append(anomalies, fmt.Sprintf("[%s] %s changed by %+.1f%% since the previous run: %v -> %v", name, metric.name, change, metric.prev, metric.cur))
+			}
+		}
+		return delta
+	}
+	names := make(map[string]bool)
+	for name := range previous {
+		names[name] = true
+	}
+	for name := range current {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	var prevTotal, curTotal sourceStats
+	sources := make(map[string]any, len(sorted))
+	for _, name := range sorted {
+		var prev, cur sourceStats
+		if s := previous[name]; s != nil {
+			prev = *s
+		}
+		if s := current[name]; s != nil {
+			cur = *s
+		}
+		prevTotal.Rows, prevTotal.Cost = prevTotal.Rows+prev.Rows, prevTotal.Cost+prev.Cost
+		curTotal.Rows, curTotal.Cost = curTotal.Rows+cur.Rows, curTotal.Cost+cur.Cost
+		sources[name] = compare(name, prev, cur)
+	}
+	// total is compared last, so anomalies of sources are reported first
+	total := compare("total", prevTotal, curTotal)
+	return map[string]any{"total": total, "sources": sources}, anomalies
+}
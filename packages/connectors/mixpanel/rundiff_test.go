@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffRunStats(t *testing.T) {
+	previous := map[string]*sourceStats{"google": {Rows: 10, Cost: 100}, "facebook": {Rows: 10, Cost: 50}}
+	current := map[string]*sourceStats{"google": {Rows: 10, Cost: 40}, "tiktok": {Rows: 5, Cost: 5}}
+	diff, anomalies := diffRunStats(previous, current, 50)
+	want := []string{"[facebook] rows changed by -100.0%", "[facebook] cost changed by -100.0%", "[google] cost changed by -60.0%", "[total] cost changed by -70.0%"}
+	if len(anomalies) != len(want) {
+		t.Fatalf("unexpected anomalies: %v", anomalies)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(anomalies[i], prefix) {
+			t.Errorf("anomaly %d = %q, want prefix %q", i, anomalies[i], prefix)
+		}
+	}
+	total := diff["total"].(map[string]any)
+	if total["rows"] != 15 || total["previousRows"] != 20 || total["rowsChangePercent"] != -25.0 {
+		t.Errorf("unexpected total: %v", total)
+	}
+	tiktok := diff["sources"].(map[string]any)["tiktok"].(map[string]any)
+	if _, ok := tiktok["rowsChangePercent"]; ok {
+		t.Errorf("change of a new source must not be reported: %v", tiktok)
+	}
+	if _, anomalies = diffRunStats(previous, current, 0); len(anomalies) != 0 {
+		t.Errorf("zero threshold must disable anomalies: %v", anomalies)
+	}
+}
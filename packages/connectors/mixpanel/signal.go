@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals stops the run gracefully on SIGTERM or SIGINT, e.g. when the container is stopped mid-sync:
+// received rows are sent, state of sent days is saved and stream-result with "terminated" status is replied before
+// exit with exitCancelled code, see endRun. A second signal exits immediately
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		go func() {
+			<-signals
+			session.Warn("Received second signal, exiting without sending queued rows")
+			exit(exitCancelled)
+		}()
+		session.Info(fmt.Sprintf("Received %s. Sending received rows and exiting", sig))
+		if currentStream == streamEvents && streamStarted {
+			// export saves state after each day, the day being exported is exported again by the next run
+			_ = session.Reply("stream-result", map[string]any{"status": "terminated", "reason": sig.String(), "received": exportStatus.Received})
+			exit(exitCancelled)
+		}
+		runMu.Lock()
+		if streamEnded {
+			// stream-result is sent, the process is exiting anyway
+			runMu.Unlock()
+			return
+		}
+		if !streamStarted {
+			exit(exitCancelled)
+		}
+		endRun("terminated", false, map[string]any{"reason": sig.String()}, exitCancelled)
+	}()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// replyWriter writes replies to stdout, compressed if the host requested compression of protocol streams,
+// see sdk.CompressionEnv
+type replyWriter struct {
+	*sdk.ReplyWriter
+}
+
+var stdout = &replyWriter{sdk.NewReplyWriter(os.Stdout)}
+
+// session replies to the host and logs. TraceId is set by start-stream
+var session = &sdk.Session{Replier: stdout}
+
+// Reply writes reply message to stdout. Logs are written to the log file too, see logfile.go
+func (w *replyWriter) Reply(msgType string, payload any) error {
+	switch msgType {
+	case "stream-result":
+		// the last metric reply carries totals of the stream
+		stopMetrics()
+	case "log":
+		if l, ok := payload.(map[string]any); ok && logFile != nil {
+			level, _ := l["level"].(string)
+			message, _ := l["message"].(string)
+			params, _ := l["params"].([]any)
+			logFile.write(level, message, params)
+		}
+	}
+	return w.ReplyWriter.Reply(msgType, payload)
+}
+
+// exit saves run manifest, sends OpenLineage event, flushes protocol stream, prints summary to stderr,
+// closes log file and terminates the process. See exitcodes.go for codes
+func exit(code int) {
+	manifestOnce.Do(func() { saveManifest(code) })
+	if err := rpcClient.Flush(); err != nil {
+		session.Error("State writes are lost", err.Error())
+	}
+	finishOpenLineage(code)
+	_ = stdout.Close()
+	printSummary(code)
+	if logFile != nil {
+		logFile.close()
+	}
+	osExit(code)
+}
+
+// osExit terminates the process. Tests replace it to check how the run ends
+var osExit = os.Exit
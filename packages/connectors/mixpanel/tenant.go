@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mixpanel/mixpanel-go"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tenant keeps batch, statuses and state ranges of a single Mixpanel project.
+// Without tenants configured all rows go to the default tenant.
+// Rows of each tenant are processed by its own worker, so a slow project doesn't hold up others
+type tenant struct {
+	key          string
+	projectToken string
+	residency    string
+	mp           *mixpanel.ApiClient
+	stateKey     []string
+
+	queue chan rowJob
+	done  sync.WaitGroup
+	// mu guards the tenant while batches are sent in the background, see sendConcurrency. The worker holds it while
+	// processing rows, senders hold it except for import requests
+	mu sync.Mutex
+	// sends is the queue of background senders, nil if batches are sent by the worker
+	sends   chan *eventBatch
+	senders sync.WaitGroup
+	// concurrency limits imports in flight if adaptiveConcurrency is set
+	concurrency *concurrencyController
+	// sequence numbers of queued batches and batches whose state is committed, see completeBatch
+	queuedBatches    int
+	committedBatches int
+	completedBatches map[int]*eventBatch
+	// sentState is state of the last batch sent in order. batchDropped stops commits after a dropped batch
+	sentState    periodRanges
+	batchDropped bool
+	// cancelled is closed when the host halts the stream with discard policy
+	cancelled chan struct{}
+	// rateLimits records Retry-After of Mixpanel responses
+	rateLimits *retryAfterRecorder
+	// retryLaterDelay is set when the tenant was rate limited for longer than retryLaterThreshold. Remaining rows are not sent
+	retryLaterDelay time.Duration
+	// stateVersion is incremented each time the state is committed, see replyCheckpoint
+	stateVersion int
+
+	batch           []*mixpanel.Event
+	profiles        []*profile
+	batchInsertIds  []string
+	batchKeys       map[string]rowKey
+	dedup           map[string]*dedupEntry
+	deadLetters     []deadLetter
+	responses       []importResponse
+	initialState    periodRanges
+	commitedState   periodRanges
+	processedRanges periodRanges
+	lastDate        time.Time
+	statuses        map[string]*Status
+
+	lastProcessedDate string
+	currentStatus     *Status
+
+	// lastBatch is fingerprint of the last batch of unclean previous run, see crashProtectionWindow
+	lastBatch      string
+	lastBatchSaved bool
+
+	// throughput counters
+	startedAt  time.Time
+	finishedAt time.Time
+	importTime time.Duration
+	imported   int
+	failed     int
+
+	// authErrors and unavailableErrors count failed imports by cause. They define exit code of the run
+	authErrors        int
+	unavailableErrors int
+}
+
+// rowJob is a row passed to tenant worker. Rows that failed normalization carry err.
+// Rows of UserProfiles stream carry profile
+type rowJob struct {
+	payload *RowPayload
+	profile *profile
+	coerced int
+	date    string
+	err     error
+	// key identifies the row that failed normalization
+	key rowKey
+}
+
+// tenantQueueSize bounds the number of rows waiting for a tenant worker
+const tenantQueueSize = 1000
+
+// tenantColumn selects tenant of the row. Empty means single tenant mode
+var tenantColumn string
+var tenants = make(map[string]*tenant)
+var defaultTenant *tenant
+
+// unknownTenantRows counts rows with tenant column value missing from tenants credentials
+var unknownTenantRows int
+
+func newTenant(key string, projectToken string, residency string) *tenant {
+	rateLimits := &retryAfterRecorder{base: &taggingTransport{base: baseTransport}}
+	mp := newMixpanelClient(projectToken, residency, rateLimits)
+	stateKey := []string{"syncId=" + syncId, "type=mixpanel.state"}
+	if key != "" {
+		stateKey = append(stateKey, "tenant="+key)
+	}
+	return &tenant{
+		key:             key,
+		projectToken:    projectToken,
+		residency:       residency,
+		mp:              mp,
+		rateLimits:      rateLimits,
+		stateKey:        stateKey,
+		initialState:    periodRanges{},
+		commitedState:   periodRanges{},
+		processedRanges: periodRanges{},
+		lastDate:        startTime,
+		statuses:        make(map[string]*Status),
+		dedup:           make(map[string]*dedupEntry),
+	}
+}
+
+func newMixpanelClient(projectToken string, residency string, transport http.RoundTripper) *mixpanel.ApiClient {
+	options := []mixpanel.Options{mixpanel.HttpClient(&http.Client{Transport: transport})}
+	if residency == "EU" {
+		options = append(options, mixpanel.EuResidency())
+	}
+	return mixpanel.NewApiClient(projectToken, options...)
+}
+
+// configureTenants creates tenants from credentials. rawTenants is a map of tenant key to project token
+func configureTenants(projectToken string, residency string, rawTenants map[string]any, column string) error {
+	tenantColumn = column
+	if tenantColumn == "" {
+		if len(rawTenants) > 0 {
+			return fmt.Errorf("tenantColumn is required when tenants are configured")
+		}
+		if projectToken == "" {
+			return fmt.Errorf("projectToken is required")
+		}
+		defaultTenant = newTenant("", projectToken, residency)
+		return nil
+	}
+	if len(rawTenants) == 0 {
+		return fmt.Errorf("tenants are required when tenantColumn is configured")
+	}
+	for key, rawToken := range rawTenants {
+		token, ok := rawToken.(string)
+		if !ok || token == "" {
+			return fmt.Errorf("project token of tenant '%s' must be a non-empty string", key)
+		}
+		tenants[key] = newTenant(key, token, residency)
+	}
+	return nil
+}
+
+// tenantFor returns tenant of the row
+func tenantFor(row map[string]any) (*tenant, error) {
+	if tenantColumn == "" {
+		return defaultTenant, nil
+	}
+	if row[tenantColumn] == nil {
+		return nil, fmt.Errorf("tenant column '%s' is missing", tenantColumn)
+	}
+	key, _ := canonicalString(row[tenantColumn])
+	if key == "" {
+		return nil, fmt.Errorf("tenant column '%s' is missing", tenantColumn)
+	}
+	t, ok := tenants[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown tenant: %s", key)
+	}
+	return t, nil
+}
+
+// allTenants returns tenants sorted by key
+func allTenants() []*tenant {
+	if tenantColumn == "" {
+		if defaultTenant == nil {
+			// tenants are not configured yet, e.g. start-stream halted on invalid credentials
+			return nil
+		}
+		return []*tenant{defaultTenant}
+	}
+	keys := make([]string, 0, len(tenants))
+	for key := range tenants {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]*tenant, len(keys))
+	for i, key := range keys {
+		result[i] = tenants[key]
+	}
+	return result
+}
+
+// logPrefix is added to log messages to tell tenants apart
+func (t *tenant) logPrefix() string {
+	period := t.lastProcessedDate
+	if currentStream == streamUserProfiles {
+		period = profilesStatusKey
+	}
+	return t.periodPrefix(period)
+}
+
+// periodPrefix is logPrefix of a batch, which may be sent after the worker moved to the next date
+func (t *tenant) periodPrefix(period string) string {
+	if t.key == "" {
+		return fmt.Sprintf("[%s]", period)
+	}
+	return fmt.Sprintf("[%s][%s]", t.key, period)
+}
+
+func (t *tenant) loadState() {
+	raw, err := rpcClient.Get(t.stateKey)
+	if err != nil {
+		session.Error("Error getting state", err.Error())
+		return
+	}
+	initialState, err := periodRangesFromAny(raw)
+	if err != nil {
+		session.Error("Error parsing state", err.Error())
+	} else if !initialState.isZero() {
+		t.initialState = initialState
+		t.processedRanges = initialState.clone()
+		t.commitedState = initialState.clone()
+		if t.key == "" {
+			session.Info("State loaded", fmt.Sprint(initialState))
+		} else {
+			session.Info(fmt.Sprintf("[%s] State loaded", t.key), fmt.Sprint(initialState))
+		}
+		t.lastDate = initialState.last()
+	}
+}
+
+// start launches the tenant worker
+func (t *tenant) start() {
+	t.queue = make(chan rowJob, tenantQueueSize)
+	t.cancelled = make(chan struct{})
+	t.startedAt = time.Now()
+	t.startSenders()
+	t.done.Add(1)
+	go func() {
+		defer t.done.Done()
+		for job := range t.queue {
+			t.mu.Lock()
+			t.process(job)
+			t.mu.Unlock()
+		}
+		t.mu.Lock()
+		if t.isCancelled() || t.retryLaterDelay > 0 {
+			t.discardBatch()
+		}
+		t.sendBatch()
+		t.mu.Unlock()
+		t.waitSenders()
+		t.sendProfiles()
+		t.saveDeadLetters()
+		t.saveResponses()
+		if budget.isExceeded() && !atomicRun {
+			// state may contain partially sent day saved before the budget was exceeded
+			t.saveState()
+		}
+		t.clearLastBatch()
+		t.finishedAt = time.Now()
+	}()
+}
+
+// process handles the row job. Must be called by the worker with t.mu held
+func (t *tenant) process(job rowJob) {
+	if t.isCancelled() || t.retryLaterDelay > 0 {
+		return
+	}
+	if job.err != nil {
+		status := t.getStatus(job.date)
+		status.Received++
+		status.Failed++
+		status.addErrorSample(job.err.Error())
+		session.Error(fmt.Sprintf("[%s] row skipped", job.date), job.err.Error())
+		t.replyRowError(job.key, "", rowErrorValidation, false, job.err.Error())
+		return
+	}
+	if currentStream == streamUserProfiles {
+		t.addProfile(job.profile)
+		return
+	}
+	processRow(t, job.payload, job.coerced)
+}
+
+// submit queues the row for the tenant worker. Blocks while the queue is full
+func (t *tenant) submit(job rowJob) {
+	t.queue <- job
+}
+
+// finish waits until the worker sends all queued rows
+func (t *tenant) finish() {
+	close(t.queue)
+	t.done.Wait()
+}
+
+// result returns per-date statuses and throughput of the tenant
+func (t *tenant) result() map[string]any {
+	elapsed := t.finishedAt.Sub(t.startedAt).Seconds()
+	eventsPerSecond := 0.0
+	if elapsed > 0 {
+		eventsPerSecond = float64(t.imported) / elapsed
+	}
+	result := map[string]any{
+		"days":            t.statuses,
+		"imported":        t.imported,
+		"failed":          t.failed,
+		"importSeconds":   t.importTime.Seconds(),
+		"eventsPerSecond": eventsPerSecond,
+	}
+	if t.concurrency != nil {
+		result["sendConcurrency"] = t.concurrency.current()
+	}
+	return result
+}
+
+func (t *tenant) saveState() {
+	t.commitState(t.processedRanges)
+}
+
+func (t *tenant) commitState(state periodRanges) {
+	if !state.equal(t.commitedState) {
+		err := rpcClient.Set(t.stateKey, state.toAny())
+		if err != nil {
+			session.Error("Error saving state", err.Error())
+		} else {
+			t.stateVersion++
+			t.replyCheckpoint(state)
+		}
+		t.commitedState = state.clone()
+	}
+}
+
+// replyCheckpoint reports progress of the stream after the state is committed, so the host can tell how far
+// the run got if it crashes:
+//
+//	{"type":"checkpoint","payload":{"stream":"ad_data","rows":2000,"committed":{"from":"2024-05-01","to":"2024-05-03"},"stateVersion":3}}
+//
+// committed is the last range of the state
+func (t *tenant) replyCheckpoint(state periodRanges) {
+	rows := 0
+	for _, status := range t.statuses {
+		rows += status.Received
+	}
+	payload := map[string]any{
+		"stream":       currentStream,
+		"rows":         rows,
+		"stateVersion": t.stateVersion,
+	}
+	if !state.isZero() {
+		last := state[len(state)-1]
+		layout := time.DateOnly
+		if granularity == granularityHour {
+			layout = time.RFC3339
+		}
+		payload["committed"] = map[string]string{"from": last.from.Format(layout), "to": last.to.Format(layout)}
+	}
+	if t.key != "" {
+		payload["tenant"] = t.key
+	}
+	_ = session.Reply("checkpoint", payload)
+}
+
+// hasFailures checks whether any row of the tenant failed
+func (t *tenant) hasFailures() bool {
+	for _, status := range t.statuses {
+		if status.Failed > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *tenant) getStatus(date string) *Status {
+	if _, ok := t.statuses[date]; !ok {
+		t.statuses[date] = &Status{}
+	}
+	return t.statuses[date]
+}
+
+// sendBatch sends the batch, or queues it for background senders. Must be called by the worker with t.mu held
+func (t *tenant) sendBatch() {
+	if len(t.batch) == 0 {
+		return
+	}
+	// nothing is sent before the first run preview is reported
+	finishPreview()
+	b := &eventBatch{date: t.lastProcessedDate, status: t.currentStatus, events: t.batch, insertIds: t.batchInsertIds, keys: t.batchKeys}
+	t.batch = nil
+	t.batchInsertIds = nil
+	t.batchKeys = nil
+	var imported []string
+	if t.isResentBatch(b.insertIds) {
+		session.Info(fmt.Sprintf("%s %d rows skipped. The batch was the last batch of unclean previous run", t.logPrefix(), len(b.events)))
+		b.status.Skipped += len(b.events)
+		b.status.ResendSkipped += len(b.events)
+		imported = b.insertIds
+	} else {
+		t.saveLastBatch(b.insertIds)
+		if t.sends != nil {
+			t.queueBatch(b)
+			return
+		}
+		imported = t.importEvents(b, b.events, b.insertIds)
+	}
+	if len(imported) > 0 {
+		if !atomicRun {
+			t.saveState()
+		}
+		t.recordSent(b.date, imported)
+	}
+}
+
+// importEvents imports events of the batch and updates its status. Batches rejected for size or with some events
+// rejected by validation are split in halves and retried, so only invalid events are marked failed.
+// Returns insert ids of imported events. Must be called with t.mu held
+func (t *tenant) importEvents(b *eventBatch, events []*mixpanel.Event, insertIds []string) []string {
+	if t.retryLaterDelay > 0 {
+		// the rest of the split batch is left for the next run too
+		b.status.Skipped += len(events)
+		b.dropped = true
+		return nil
+	}
+	importStart := time.Now()
+	res, err := t.importRequest(events)
+	t.importTime += time.Since(importStart)
+	t.captureResponse(len(events), res, err)
+	var validationErr mixpanel.ImportFailedValidationError
+	var genericErr mixpanel.ImportGenericError
+	var rateLimitErr mixpanel.ImportRateLimitError
+	switch {
+	case err == nil && res.Code == 200 && res.NumRecordsImported >= len(events):
+		health.DestinationResult(nil)
+		t.imported += len(events)
+		b.status.Success += len(events)
+		session.Info(fmt.Sprintf("%s %d rows sent", t.periodPrefix(b.date), len(events)), res.Code, res.NumRecordsImported, res.Status)
+		return insertIds
+	case err == nil && res.Code == 200 && res.NumRecordsImported > 0 && len(events) > 1:
+		// non-strict import silently drops invalid events. Already imported ones are deduplicated by $insert_id when resent
+		session.Debug(fmt.Sprintf("%s %d of %d rows imported. Splitting the batch to isolate invalid rows", t.periodPrefix(b.date), res.NumRecordsImported, len(events)))
+		return t.splitImport(b, events, insertIds)
+	case errors.As(err, &genericErr) && genericErr.Code == http.StatusRequestEntityTooLarge && len(events) > 1:
+		session.Debug(fmt.Sprintf("%s batch of %d rows is too large. Splitting", t.periodPrefix(b.date), len(events)))
+		return t.splitImport(b, events, insertIds)
+	case errors.As(err, &validationErr) && len(validationErr.FailedImportRecords) > 0:
+		// strict import reports invalid events, valid ones are imported
+		failed := make(map[int]bool, len(validationErr.FailedImportRecords))
+		for _, record := range validationErr.FailedImportRecords {
+			if record.Index >= 0 && record.Index < len(events) && !failed[record.Index] {
+				failed[record.Index] = true
+				b.status.addErrorSample(fmt.Sprintf("%s: %s %s", record.InsertID, record.Field, record.Message))
+				t.replyRowError(b.keys[insertIds[record.Index]], insertIds[record.Index], rowErrorValidation, false,
+					fmt.Sprintf("%s %s", record.Field, record.Message))
+			}
+		}
+		imported := make([]string, 0, len(events)-len(failed))
+		for i, id := range insertIds {
+			if !failed[i] {
+				imported = append(imported, id)
+			}
+		}
+		health.DestinationResult(validationErr)
+		t.imported += len(imported)
+		t.failed += len(failed)
+		b.status.Success += len(imported)
+		b.status.Failed += len(failed)
+		session.Error(fmt.Sprintf("%s %d of %d rows failed validation", t.periodPrefix(b.date), len(failed), len(events)), validationErr.ApiError)
+		return imported
+	case errors.As(err, &validationErr) && len(events) > 1:
+		session.Debug(fmt.Sprintf("%s batch of %d rows failed validation. Splitting to isolate invalid rows", t.periodPrefix(b.date), len(events)))
+		return t.splitImport(b, events, insertIds)
+	case errors.As(err, &rateLimitErr) && t.deferRetryLater(b, len(events)):
+		health.DestinationResult(err)
+		return nil
+	case err != nil:
+		if errors.As(err, &genericErr) && genericErr.Code == http.StatusUnauthorized {
+			t.authErrors++
+		} else if !errors.As(err, &validationErr) {
+			t.unavailableErrors++
+		}
+		t.failed += len(events)
+		b.status.Failed += len(events)
+		b.status.addErrorSample(err.Error())
+		health.DestinationResult(err)
+		t.replyImportErrors(b, insertIds, err)
+		s, _ := json.Marshal(err)
+		session.Error(fmt.Sprintf("%s wrror importing %d rows.", t.periodPrefix(b.date), len(events)), string(s))
+	default:
+		session.Error(fmt.Sprintf("%s error importing %d rows. Code: %d Status: %+v", t.periodPrefix(b.date), len(events), res.Code, res.Status))
+		t.failed += len(events)
+		b.status.Failed += len(events)
+		health.DestinationResult(fmt.Errorf("code %d", res.Code))
+		if res.Code == http.StatusOK {
+			// non-strict import dropped the event as invalid
+			for _, id := range insertIds {
+				t.replyRowError(b.keys[id], id, rowErrorValidation, false, "event is dropped by Mixpanel validation")
+			}
+		} else {
+			t.replyImportErrors(b, insertIds, fmt.Errorf("unexpected response code: %d", res.Code))
+		}
+	}
+	return nil
+}
+
+// importRequest sends events to Mixpanel. With background senders t.mu is released during the request,
+// so the worker and other senders keep going
+func (t *tenant) importRequest(events []*mixpanel.Event) (*mixpanel.ImportSuccess, error) {
+	// the client may be replaced by credentials rotation while the lock is released
+	mp := t.mp
+	for _, e := range events {
+		// events built before rotation carry the previous token
+		e.Properties["token"] = t.projectToken
+	}
+	if dryRun != nil {
+		return dryRunImport(events), nil
+	}
+	if t.sends != nil {
+		t.mu.Unlock()
+		defer t.mu.Lock()
+	}
+	pace(len(events))
+	if t.concurrency != nil {
+		t.concurrency.acquire()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), throttledTimeout(time.Second*15, events))
+	defer cancel()
+	start := time.Now()
+	res, err := mp.Import(ctx, events, mixpanel.ImportOptions{Compression: importCompression, Strict: strictImport})
+	if t.concurrency != nil {
+		t.concurrency.release(time.Since(start), err)
+	}
+	return res, err
+}
+
+// splitImport imports halves of the events separately
+func (t *tenant) splitImport(b *eventBatch, events []*mixpanel.Event, insertIds []string) []string {
+	metrics.AddRetry()
+	half := len(events) / 2
+	imported := t.importEvents(b, events[:half], insertIds[:half])
+	return append(imported, t.importEvents(b, events[half:], insertIds[half:])...)
+}
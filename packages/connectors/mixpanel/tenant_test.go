@@ -0,0 +1,325 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+	"github.com/mixpanel/mixpanel-go"
+)
+
+// fakeImportServer emulates Mixpanel import endpoint. Batches larger than maxEvents are rejected with 413,
+// events with "poison" property are rejected by validation
+func fakeImportServer(t *testing.T, maxEvents int) (*httptest.Server, *int) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events []mixpanel.Event
+		if err = json.NewDecoder(gz).Decode(&events); err != nil {
+			t.Fatal(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if len(events) > maxEvents {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			_, _ = w.Write([]byte(`{"code":413,"error":"request too large","status":"error"}`))
+			return
+		}
+		var failed []map[string]any
+		for i, e := range events {
+			if e.Properties["poison"] != nil {
+				failed = append(failed, map[string]any{"index": i, "insert_id": e.Properties["$insert_id"], "field": "poison", "message": "invalid"})
+			}
+		}
+		if r.URL.Query().Get("strict") == "1" && len(failed) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]any{"code": 400, "error": "some data points in the request failed validation",
+				"num_records_imported": len(events) - len(failed), "failed_records": failed})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"code": 200, "num_records_imported": len(events) - len(failed), "status": "OK"})
+	}))
+	return srv, &requests
+}
+
+func TestImportEventsSplitsBatches(t *testing.T) {
+	defer func() { strictImport, stdout.ReplyWriter = false, sdk.NewReplyWriter(io.Discard) }()
+	for _, strict := range []bool{false, true} {
+		strictImport = strict
+		var replies strings.Builder
+		stdout.ReplyWriter = sdk.NewReplyWriter(&replies)
+		srv, requests := fakeImportServer(t, 4)
+		tn := newTenant("", "token", "")
+		tn.mp = mixpanel.NewApiClient("token", mixpanel.ProxyApiLocation(srv.URL))
+		b := &eventBatch{status: &Status{}, keys: map[string]rowKey{"d": {Date: "2024-05-01", CampaignId: "3"}}}
+		var events []*mixpanel.Event
+		var ids []string
+		for i := 0; i < 10; i++ {
+			id := string(rune('a' + i))
+			properties := map[string]any{"$insert_id": id}
+			if i == 3 || i == 7 {
+				properties["poison"] = true
+			}
+			events = append(events, tn.mp.NewEvent("$ad_spend", "", properties))
+			ids = append(ids, id)
+		}
+		imported := tn.importEvents(b, events, ids)
+		srv.Close()
+		sort.Strings(imported)
+		if got, want := len(imported), 8; got != want {
+			t.Errorf("strict=%v: imported %d events, want %d: %v", strict, got, want, imported)
+		}
+		for _, id := range imported {
+			if id == "d" || id == "h" {
+				t.Errorf("strict=%v: poison event %s reported as imported", strict, id)
+			}
+		}
+		if b.status.Success != 8 || b.status.Failed != 2 {
+			t.Errorf("strict=%v: unexpected status %+v", strict, b.status)
+		}
+		var rowErrors []string
+		for _, line := range strings.Split(replies.String(), "\n") {
+			var m sdk.Message
+			if json.Unmarshal([]byte(line), &m) == nil && m.Type == "row-error" {
+				payload := m.Payload.(map[string]any)
+				rowErrors = append(rowErrors, fmt.Sprintf("%s %v %v", payload["insertId"], payload["class"], payload["key"]))
+			}
+		}
+		if want := []string{"d validation map[campaign_id:3 date:2024-05-01]", "h validation map[]"}; !slices.Equal(rowErrors, want) {
+			t.Errorf("strict=%v: row errors %q, want %q", strict, rowErrors, want)
+		}
+		t.Logf("strict=%v: %d requests", strict, *requests)
+	}
+}
+
+func TestCaptureResponses(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	defer func() { captureFile, captureResponses, strictImport = "", 0, false }()
+	captureFile = t.TempDir() + "/responses.ndjson"
+	captureResponses = 2
+	strictImport = true
+	srv, _ := fakeImportServer(t, 10)
+	defer srv.Close()
+	tn := newTenant("", "token", "")
+	tn.mp = mixpanel.NewApiClient("token", mixpanel.ProxyApiLocation(srv.URL))
+	batch := &eventBatch{status: &Status{}}
+	for _, poison := range []bool{false, true, false} {
+		properties := map[string]any{"$insert_id": "a"}
+		if poison {
+			properties["poison"] = true
+		}
+		event := tn.mp.NewEvent("$ad_spend", "", properties)
+		tn.importEvents(batch, []*mixpanel.Event{event}, []string{"a"})
+	}
+	if len(tn.responses) != 2 || tn.responses[0].Code != 400 || len(tn.responses[0].FailedRecords) != 1 || tn.responses[1].Code != 200 {
+		t.Errorf("unexpected captured responses: %+v", tn.responses)
+	}
+	b, err := os.ReadFile(captureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 3 {
+		t.Errorf("expected 3 responses in capture file, got %d", lines)
+	}
+}
+
+func TestRetryLater(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	defer func() { retryLaterThreshold = 0 }()
+	retryLaterThreshold = time.Minute
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "900")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte(`{"code":429,"error":"too many requests","status":"error"}`))
+	}))
+	defer srv.Close()
+	tn := newTenant("", "token", "")
+	tn.mp = mixpanel.NewApiClient("token", mixpanel.ProxyApiLocation(srv.URL), mixpanel.HttpClient(&http.Client{Transport: tn.rateLimits}))
+	b := &eventBatch{status: &Status{}}
+	event := tn.mp.NewEvent("$ad_spend", "", map[string]any{"$insert_id": "a"})
+	if imported := tn.importEvents(b, []*mixpanel.Event{event, event}, []string{"a", "b"}); len(imported) != 0 {
+		t.Errorf("imported %v", imported)
+	}
+	if tn.retryLaterDelay != 15*time.Minute {
+		t.Errorf("retryLaterDelay = %s, want 15m", tn.retryLaterDelay)
+	}
+	if b.status.Failed != 0 || b.status.Skipped != 2 || tn.failed != 0 {
+		t.Errorf("rate limited events must be left for the next run, not failed: %+v", b.status)
+	}
+	select {
+	case delay := <-retryLaterRequests:
+		if delay != 15*time.Minute {
+			t.Errorf("requested delay = %s", delay)
+		}
+	default:
+		t.Error("retry-later was not requested")
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	defer func() { syncId, requestHeaders = "", http.Header{} }()
+	syncId = "sync-1"
+	if err := configureRequestHeaders(map[string]any{"X-Integration": "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := configureRequestHeaders(map[string]any{"Authorization": "Basic x"}); err == nil {
+		t.Error("Authorization header must not be overridable")
+	}
+	_ = configureRequestHeaders(map[string]any{"X-Integration": "acme"})
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		_, _ = w.Write([]byte(`{"code":200,"num_records_imported":1,"status":"OK"}`))
+	}))
+	defer srv.Close()
+	tn := newTenant("", "token", "")
+	tn.mp = mixpanel.NewApiClient("token", mixpanel.ProxyApiLocation(srv.URL), mixpanel.HttpClient(&http.Client{Transport: tn.rateLimits}))
+	b := &eventBatch{status: &Status{}}
+	tn.importEvents(b, []*mixpanel.Event{tn.mp.NewEvent("$ad_spend", "", map[string]any{"$insert_id": "a"})}, []string{"a"})
+	if ua := got.Get("User-Agent"); ua != "syncmaven-mixpanel/dev (sync-1)" {
+		t.Errorf("User-Agent = %q", ua)
+	}
+	if got.Get("X-Integration") != "acme" || got.Get("Authorization") == "" {
+		t.Errorf("unexpected headers %v", got)
+	}
+}
+
+func TestRotateTokens(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	defer func() { tenantColumn, tenants = "", make(map[string]*tenant) }()
+	if err := configureTenants("", "", map[string]any{"acme": "t1", "globex": "t2"}, "tenant"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenantTokens(map[string]any{"tenants": map[string]any{"acme": "t3"}}); err == nil {
+		t.Error("credentials without token of a configured tenant must be rejected")
+	}
+	tokens, err := tenantTokens(map[string]any{"tenants": map[string]any{"acme": "t3", "globex": "t2", "initech": "t4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := tenants["acme"].mp
+	if !tenants["acme"].rotateToken(tokens["acme"]) || tenants["globex"].rotateToken(tokens["globex"]) {
+		t.Error("only the changed token must be rotated")
+	}
+	if tenants["acme"].projectToken != "t3" || tenants["acme"].mp == previous {
+		t.Errorf("client of acme is not replaced")
+	}
+	if len(tokens) != 2 {
+		t.Errorf("tokens of unknown tenants must be ignored: %v", tokens)
+	}
+}
+
+func TestConfigureTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		tenants map[string]any
+		column  string
+		wantErr string
+	}{
+		{name: "single tenant", token: "t1"},
+		{name: "single tenant without token", wantErr: "projectToken is required"},
+		{name: "tenants without column", tenants: map[string]any{"acme": "t1"}, wantErr: "tenantColumn is required"},
+		{name: "column without tenants", column: "tenant", wantErr: "tenants are required"},
+		{name: "empty token", tenants: map[string]any{"acme": ""}, column: "tenant", wantErr: "tenant 'acme' must be a non-empty string"},
+		{name: "token of wrong type", tenants: map[string]any{"acme": 1.0}, column: "tenant", wantErr: "tenant 'acme' must be a non-empty string"},
+		{name: "multi tenant", tenants: map[string]any{"acme": "t1", "globex": "t2"}, column: "tenant"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() { tenantColumn, tenants, defaultTenant = "", make(map[string]*tenant), nil }()
+			err := configureTenants(test.token, "", test.tenants, test.column)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := max(len(test.tenants), 1); len(allTenants()) != want {
+				t.Errorf("%d tenants configured, want %d", len(allTenants()), want)
+			}
+		})
+	}
+}
+
+func TestTenantFor(t *testing.T) {
+	defer func() { tenantColumn, tenants = "", make(map[string]*tenant) }()
+	if err := configureTenants("", "", map[string]any{"acme": "t1", "42": "t2"}, "tenant"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		row     map[string]any
+		want    string
+		wantErr string
+	}{
+		{name: "known tenant", row: map[string]any{"tenant": "acme"}, want: "acme"},
+		{name: "numeric tenant", row: map[string]any{"tenant": 42.0}, want: "42"},
+		{name: "unknown tenant", row: map[string]any{"tenant": "initech"}, wantErr: "unknown tenant: initech"},
+		{name: "missing column", row: map[string]any{"event": "signup"}, wantErr: "tenant column 'tenant' is missing"},
+		{name: "empty column", row: map[string]any{"tenant": ""}, wantErr: "tenant column 'tenant' is missing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tn, err := tenantFor(test.row)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Errorf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil || tn.key != test.want {
+				t.Errorf("tenantFor(%v) = %v, %v, want %s", test.row, tn, err, test.want)
+			}
+		})
+	}
+}
+
+func TestSlowTenantDoesNotHoldOthers(t *testing.T) {
+	stdout.ReplyWriter = sdk.NewReplyWriter(io.Discard)
+	slow, fast := newTenant("slow", "t1", ""), newTenant("fast", "t2", "")
+	slow.start()
+	fast.start()
+	// the worker of the slow tenant is busy, e.g. waiting for Mixpanel
+	slow.mu.Lock()
+	slow.submit(rowJob{date: "2024-05-01", err: errors.New("time is required")})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < tenantQueueSize+10; i++ {
+			fast.submit(rowJob{date: "2024-05-01", err: errors.New("time is required")})
+		}
+		fast.finish()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rows of the fast tenant wait for the slow one")
+	}
+	slow.mu.Unlock()
+	slow.finish()
+	if failed := fast.statuses["2024-05-01"].Failed; failed != tenantQueueSize+10 {
+		t.Errorf("fast tenant failed %d rows, want %d", failed, tenantQueueSize+10)
+	}
+	if failed := slow.statuses["2024-05-01"].Failed; failed != 1 {
+		t.Errorf("statuses of tenants are mixed: slow tenant failed %d rows, want 1", failed)
+	}
+}
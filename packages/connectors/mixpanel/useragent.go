@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// requestHeaders are set on every request to Mixpanel. User-Agent identifies connector version and sync,
+// so Mixpanel and proxies can attribute and rate limit traffic per integration
+var requestHeaders = http.Header{}
+
+// configureRequestHeaders sets User-Agent 'syncmaven-mixpanel/<version> (<syncId>)', trace id header of the sync
+// and custom headers from 'requestHeaders' credentials option. Custom headers may override User-Agent
+func configureRequestHeaders(custom map[string]any) error {
+	requestHeaders = http.Header{}
+	userAgent := "syncmaven-mixpanel/" + sdk.Version
+	if syncId != "" {
+		userAgent += " (" + syncId + ")"
+	}
+	requestHeaders.Set("User-Agent", userAgent)
+	if traceId != "" {
+		requestHeaders.Set(sdk.TraceIdHeader, traceId)
+	}
+	for name, v := range custom {
+		value, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("value of request header '%s' must be a string", name)
+		}
+		if name == "" || strings.ContainsAny(name, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid request header '%s'", name)
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Authorization", "Content-Type", "Content-Encoding", "Content-Length", "Host":
+			return fmt.Errorf("request header '%s' is set by the connector and can't be overridden", name)
+		}
+		requestHeaders.Set(name, value)
+	}
+	return nil
+}
+
+// taggingTransport is an http transport that adds requestHeaders to requests
+type taggingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *taggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range requestHeaders {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
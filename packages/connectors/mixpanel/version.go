@@ -0,0 +1,14 @@
+package main
+
+// capabilities are optional protocol features supported by the connector, see sdk.VersionInfo.
+// Build information is set in the SDK, see sdk.Version
+var capabilities = []string{"state", "history", "arrow", "parquet"}
+
+// schedulingHints tell host when to run syncs. Ad platforms finalize daily data in the morning UTC,
+// days synced earlier are restated by subsequent runs within lookback window
+var schedulingHints = map[string]any{
+	"preferredFrequency":  "daily",
+	"dataAvailableAfter":  "06:00",
+	"finalizationLagDays": 2,
+	"description":         "Ad platforms finalize data of the previous day at about 06:00 UTC. Prefer daily syncs after that",
+}
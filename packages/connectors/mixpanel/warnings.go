@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+)
+
+// Categories of data quality warnings
+const (
+	warningCoercion      = "coercion"
+	warningTruncation    = "truncation"
+	warningUnknownColumn = "unknown_column"
+	warningClockSkew     = "clock_skew"
+	warningRunDiff       = "run_diff"
+	warningFlatten       = "flatten"
+)
+
+// maxWarningReplies limits the number of warning messages sent per category. Further warnings are only counted
+const maxWarningReplies = 10
+
+var warningsLock sync.Mutex
+var warningCounts = make(map[string]int)
+
+// unknownColumns are unmapped columns already reported, so each column is reported once
+var unknownColumns = make(map[string]bool)
+
+// warning reports non-fatal data quality issue. Warnings are counted per category and reported in stream-result
+func warning(category string, message string, params ...any) {
+	warningsLock.Lock()
+	warningCounts[category]++
+	count := warningCounts[category]
+	warningsLock.Unlock()
+	qualityBudget.warning()
+	if count > maxWarningReplies {
+		return
+	}
+	payload := map[string]any{
+		"category": category,
+		"message":  message,
+	}
+	if len(params) > 0 {
+		payload["params"] = params
+	}
+	if count == maxWarningReplies {
+		payload["last"] = true
+	}
+	_ = session.Reply("warning", payload)
+}
+
+// warnUnknownColumns reports columns that are neither mapped to $ad_spend properties nor sent as custom properties
+func warnUnknownColumns(row map[string]any) {
+	for column := range unmappedColumns(row) {
+		warningsLock.Lock()
+		reported := unknownColumns[column]
+		unknownColumns[column] = true
+		warningsLock.Unlock()
+		if !reported {
+			warning(warningUnknownColumn, "Column is not mapped to any property and is ignored. Set propertyNameTemplate to send it as a custom property", column)
+		}
+	}
+}
+
+// warningsResult returns warning counts by category
+func warningsResult() map[string]int {
+	warningsLock.Lock()
+	defer warningsLock.Unlock()
+	result := make(map[string]int, len(warningCounts))
+	for category, count := range warningCounts {
+		result[category] = count
+	}
+	return result
+}
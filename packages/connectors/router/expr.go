@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Expression is a routing predicate. Syntax is a list of conditions joined with &&:
+//
+//	<column or function call> <op> <JSON literal>
+//
+// Supported operators: ==, !=, <, <=, >, >=, =~ (regexp match), in (array literal).
+// Arguments of functions are columns, JSON literals or function calls, see sdk.Functions for the list.
+// Special expressions 'true' and '*' match any row. Examples:
+//
+//	region == "EU"
+//	country in ["DE", "FR"] && cost > 0
+//	source =~ "^google"
+//	lower(parse_url(landing_page, "host")) == "shop.example.com"
+type Expression struct {
+	source     string
+	conditions []condition
+}
+
+type condition struct {
+	operand operand
+	op      string
+	value   any
+	re      *regexp.Regexp
+}
+
+// operand is a column, a literal or a function call
+type operand struct {
+	column   string
+	literal  any
+	function *sdk.Function
+	args     []operand
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "=~", "<", ">", " in "}
+
+func ParseExpression(source string) (*Expression, error) {
+	e := &Expression{source: source}
+	s := strings.TrimSpace(source)
+	if s == "" {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	if s == "true" || s == "*" {
+		return e, nil
+	}
+	for _, part := range splitConditions(s) {
+		c, err := parseCondition(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression '%s': %v", source, err)
+		}
+		e.conditions = append(e.conditions, c)
+	}
+	return e, nil
+}
+
+// splitConditions splits by && outside of string literals
+func splitConditions(s string) []string {
+	var parts []string
+	inString, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && inString:
+			escaped = true
+		case s[i] == '"':
+			inString = !inString
+		case !inString && strings.HasPrefix(s[i:], "&&"):
+			parts = append(parts, s[start:i])
+			start = i + 2
+			i++
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// findOperator returns position and the operator of the condition. Operators in string literals and function
+// arguments are skipped
+func findOperator(s string) (int, string) {
+	inString, escaped, depth := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && inString:
+			escaped = true
+		case s[i] == '"':
+			inString = !inString
+		case inString:
+		case s[i] == '(':
+			depth++
+		case s[i] == ')':
+			depth--
+		case depth == 0:
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					return i, op
+				}
+			}
+		}
+	}
+	return -1, ""
+}
+
+func parseCondition(s string) (condition, error) {
+	s = strings.TrimSpace(s)
+	if idx, op := findOperator(s); idx > 0 {
+		left, err := parseOperand(s[:idx])
+		if err != nil {
+			return condition{}, err
+		}
+		c := condition{operand: left, op: strings.TrimSpace(op)}
+		literal := strings.TrimSpace(s[idx+len(op):])
+		decoder := json.NewDecoder(bytes.NewReader([]byte(literal)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&c.value); err != nil {
+			return c, fmt.Errorf("invalid value %s: %v", literal, err)
+		}
+		switch c.op {
+		case "=~":
+			str, ok := c.value.(string)
+			if !ok {
+				return c, fmt.Errorf("=~ requires string regexp")
+			}
+			re, err := regexp.Compile(str)
+			if err != nil {
+				return c, err
+			}
+			c.re = re
+		case "in":
+			if _, ok := c.value.([]any); !ok {
+				return c, fmt.Errorf("'in' requires array value")
+			}
+		}
+		return c, nil
+	}
+	return condition{}, fmt.Errorf("no operator found in '%s'", s)
+}
+
+// parseOperand parses column, JSON literal or function call with arguments
+func parseOperand(s string) (operand, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return operand{}, fmt.Errorf("operand is missing")
+	}
+	open := strings.IndexByte(s, '(')
+	if open < 0 || strings.HasPrefix(s, "\"") {
+		if s[0] == '"' || s[0] == '-' || (s[0] >= '0' && s[0] <= '9') || s == "true" || s == "false" || s == "null" {
+			o := operand{}
+			decoder := json.NewDecoder(strings.NewReader(s))
+			decoder.UseNumber()
+			if err := decoder.Decode(&o.literal); err != nil {
+				return o, fmt.Errorf("invalid value %s: %v", s, err)
+			}
+			return o, nil
+		}
+		return operand{column: s}, nil
+	}
+	if !strings.HasSuffix(s, ")") {
+		return operand{}, fmt.Errorf("invalid function call '%s'", s)
+	}
+	name := strings.TrimSpace(s[:open])
+	function, ok := sdk.LookupFunction(name)
+	if !ok {
+		return operand{}, fmt.Errorf("unknown function '%s'", name)
+	}
+	o := operand{function: function}
+	if body := strings.TrimSpace(s[open+1 : len(s)-1]); body != "" {
+		for _, part := range splitArguments(body) {
+			arg, err := parseOperand(part)
+			if err != nil {
+				return o, err
+			}
+			o.args = append(o.args, arg)
+		}
+	}
+	if err := function.CheckArgs(len(o.args)); err != nil {
+		return o, err
+	}
+	// literal arguments like units and patterns are checked before values, so nil is passed for other arguments
+	args := make([]any, len(o.args))
+	for i, arg := range o.args {
+		args[i] = arg.literal
+	}
+	if _, err := function.Call(args...); err != nil {
+		return o, err
+	}
+	return o, nil
+}
+
+// splitArguments splits by commas outside of string literals and nested calls
+func splitArguments(s string) []string {
+	var parts []string
+	inString, escaped, depth, start := false, false, 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\' && inString:
+			escaped = true
+		case s[i] == '"':
+			inString = !inString
+		case inString:
+		case s[i] == '(' || s[i] == '[':
+			depth++
+		case s[i] == ')' || s[i] == ']':
+			depth--
+		case s[i] == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// eval returns value of the operand for the row. Function errors make the value null
+func (o operand) eval(row map[string]any) any {
+	switch {
+	case o.function != nil:
+		args := make([]any, len(o.args))
+		for i, arg := range o.args {
+			args[i] = arg.eval(row)
+		}
+		v, err := o.function.Call(args...)
+		if err != nil {
+			return nil
+		}
+		return v
+	case o.column != "":
+		return row[o.column]
+	}
+	return o.literal
+}
+
+// Match returns true if the row satisfies all conditions
+func (e *Expression) Match(row map[string]any) bool {
+	for _, c := range e.conditions {
+		if !c.match(c.operand.eval(row)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+func (c condition) match(v any) bool {
+	switch c.op {
+	case "==":
+		return compare(v, c.value) == 0
+	case "!=":
+		return compare(v, c.value) != 0
+	case "<":
+		return v != nil && compare(v, c.value) < 0
+	case "<=":
+		return v != nil && compare(v, c.value) <= 0
+	case ">":
+		return v != nil && compare(v, c.value) > 0
+	case ">=":
+		return v != nil && compare(v, c.value) >= 0
+	case "=~":
+		return v != nil && c.re.MatchString(toString(v))
+	case "in":
+		return slices.ContainsFunc(c.value.([]any), func(item any) bool { return compare(v, item) == 0 })
+	}
+	return false
+}
+
+// compare compares numbers numerically and everything else as strings. Returns 0 if values are equal
+func compare(a, b any) int {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0
+		}
+		return 1
+	}
+	fa, aok := toFloat(a)
+	fb, bok := toFloat(b)
+	if aok && bok {
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(toString(a), toString(b))
+}
+
+func toFloat(v any) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
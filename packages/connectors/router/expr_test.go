@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExpression(t *testing.T) {
+	row := map[string]any{"region": "EU", "country": "DE", "cost": json.Number("12.5"), "source": "google-ads", "note": "a && b",
+		"url": "https://shop.example.com/?utm_source=fb", "date": "2024-05-17"}
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{`*`, true},
+		{`region == "EU"`, true},
+		{`region != "EU"`, false},
+		{`country in ["DE", "FR"]`, true},
+		{`country in ["US"]`, false},
+		{`cost > 10 && region == "EU"`, true},
+		{`cost <= 12`, false},
+		{`source =~ "^google"`, true},
+		{`note == "a && b"`, true},
+		{`missing == null`, true},
+		{`missing > 1`, false},
+		{`lower(region) == "eu"`, true},
+		{`lower(coalesce(missing, country)) in ["de"]`, true},
+		{`regex_extract(source, "^(\\w+)-") == "google"`, true},
+		{`parse_url(url, "query", "utm_source") == "fb"`, true},
+		{`date_trunc("month", date) == "2024-05-01" && cost > 10`, true},
+		{`sha256(missing) == null`, true},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpression(tt.expression)
+		if err != nil {
+			t.Fatalf("ParseExpression(%s) error: %v", tt.expression, err)
+		}
+		if got := e.Match(row); got != tt.want {
+			t.Errorf("%s: Match() = %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+	for _, invalid := range []string{``, `region`, `country in "DE"`, `source =~ "("`, `upper(region) == "EU"`, `lower(region, country) == "eu"`,
+		`date_trunc("fortnight", date) == "2024-05-01"`, `lower(region == "eu"`} {
+		if _, err := ParseExpression(invalid); err == nil {
+			t.Errorf("ParseExpression(%s) expected error", invalid)
+		}
+	}
+}
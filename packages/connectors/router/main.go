@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Router is a destination that dispatches each row to exactly one of the child connectors
+// according to the rules, e.g. per-region ad accounts going to different Mixpanel projects.
+// Child connectors are started lazily, when the first row is routed to them.
+
+//go:embed credentials.schema.json
+var credentialSchemaString string
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
+
+type RuleConfig struct {
+	Expression  string `json:"expression"`
+	Destination string `json:"destination"`
+	Stream      string `json:"stream"`
+}
+
+type Rule struct {
+	expression  *Expression
+	destination sdk.ChildConfig
+	// key identifies the child process: destination name and stream if overridden
+	key string
+}
+
+type Credentials struct {
+	Destinations []sdk.ChildConfig `json:"destinations"`
+	Rules        []RuleConfig      `json:"rules"`
+}
+
+// routedRows are rows of a rows message routed to the same child
+type routedRows struct {
+	rows     []map[string]any
+	ordinals []int64
+}
+
+const finishTimeout = 10 * time.Minute
+
+var rules []Rule
+var children = make(map[string]*sdk.Child)
+var stream, syncId string
+var received, unrouted int
+
+// session replies to the host. Set by the first message, children log with it
+var session *sdk.Session
+
+func main() {
+	if err := sdk.Run(context.Background(), os.Stdin, os.Stdout, sdk.HandlerFunc(handle)); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func handle(ctx context.Context, message sdk.IncomingMessage, replier sdk.Replier) error {
+	if session == nil {
+		session = &sdk.Session{Replier: replier}
+	}
+	switch message.Type {
+	case "describe":
+		_ = session.Reply("spec", map[string]any{
+			"roles":                 []string{"destination"},
+			"description":           "Router Connector. Dispatches rows to destinations by rules",
+			"connectionCredentials": credentialSchema,
+			"connector":             sdk.VersionInfo(nil),
+			// functions of rule expressions
+			"functions":             sdk.Functions(),
+			"supportedFramings":     sdk.SupportedFramings,
+			"supportedCompressions": sdk.SupportedCompressions,
+		})
+		return sdk.ErrStop
+	case "describe-streams":
+		_ = session.Reply("stream-spec", map[string]any{
+			"roles":         []string{"destination"},
+			"defaultStream": "default",
+			"streams":       []any{map[string]any{"name": "default", "rowType": map[string]any{"type": "object"}}},
+		})
+	case "start-stream":
+		var payload struct {
+			Stream                string `json:"stream"`
+			SyncId                string `json:"syncId"`
+			ConnectionCredentials any    `json:"connectionCredentials"`
+		}
+		_ = message.DecodePayload(&payload)
+		stream, syncId = payload.Stream, payload.SyncId
+		if err := parseRules(payload.ConnectionCredentials); err != nil {
+			return halt(fmt.Sprintf("Invalid connection credentials: %v", err))
+		}
+		session.Info(fmt.Sprintf("Stream '%s' started. %d routing rules", stream, len(rules)))
+	case "row":
+		var payload struct {
+			Row map[string]any `json:"row"`
+		}
+		_ = message.DecodePayload(&payload)
+		child, err := route(payload.Row)
+		if err != nil {
+			return err
+		}
+		if child != nil {
+			line, _ := json.Marshal(message)
+			child.Send(line)
+		}
+	case "rows":
+		var payload struct {
+			Rows        []map[string]any `json:"rows"`
+			Ordinals    []int64          `json:"ordinals"`
+			ColumnTypes json.RawMessage  `json:"columnTypes"`
+		}
+		_ = message.DecodePayload(&payload)
+		routed := make(map[*sdk.Child]*routedRows)
+		for i, row := range payload.Rows {
+			child, err := route(row)
+			if err != nil {
+				return err
+			}
+			if child == nil {
+				continue
+			}
+			r := routed[child]
+			if r == nil {
+				r = &routedRows{}
+				routed[child] = r
+			}
+			r.rows = append(r.rows, row)
+			if len(payload.Ordinals) == len(payload.Rows) {
+				r.ordinals = append(r.ordinals, payload.Ordinals[i])
+			}
+		}
+		for child, r := range routed {
+			childPayload := map[string]any{"rows": r.rows}
+			// ordinals of the routed rows, so the child reports delivery of the same rows the host numbered
+			if r.ordinals != nil {
+				childPayload["ordinals"] = r.ordinals
+			}
+			// type hints are per column, so all of them apply to any subset of rows
+			if len(payload.ColumnTypes) > 0 {
+				childPayload["columnTypes"] = payload.ColumnTypes
+			}
+			b, _ := json.Marshal(sdk.Message{Type: "rows", Payload: childPayload})
+			child.Send(b)
+		}
+	case "end-stream":
+		results := make(map[string]any, len(children)+1)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for key, child := range children {
+			wg.Add(1)
+			go func(key string, c *sdk.Child) {
+				defer wg.Done()
+				r := c.Finish(finishTimeout)
+				mu.Lock()
+				results[key] = r
+				mu.Unlock()
+			}(key, child)
+		}
+		wg.Wait()
+		results["unrouted"] = unrouted
+		session.Info(fmt.Sprintf("Stream finished. %d rows received, %d didn't match any rule", received, unrouted))
+		_ = session.Reply("stream-result", results)
+		return sdk.ErrStop
+	default:
+		session.Error("Unknown message type", message.Type)
+	}
+	return nil
+}
+
+func parseRules(rawCredentials any) error {
+	b, _ := json.Marshal(rawCredentials)
+	var creds Credentials
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&creds); err != nil {
+		return err
+	}
+	destinations := make(map[string]sdk.ChildConfig, len(creds.Destinations))
+	for _, d := range creds.Destinations {
+		destinations[d.Name] = d
+	}
+	for i, r := range creds.Rules {
+		destination, ok := destinations[r.Destination]
+		if !ok {
+			return fmt.Errorf("rule #%d: unknown destination '%s'", i, r.Destination)
+		}
+		expression, err := ParseExpression(r.Expression)
+		if err != nil {
+			return fmt.Errorf("rule #%d: %v", i, err)
+		}
+		key := r.Destination
+		if r.Stream != "" {
+			destination.Stream = r.Stream
+			key += "/" + r.Stream
+		}
+		rules = append(rules, Rule{expression: expression, destination: destination, key: key})
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("rules are required")
+	}
+	return nil
+}
+
+// route returns child of the first rule matching the row, starting it if necessary. Returns nil if no rule matches.
+// Returns error if the child cannot be started
+func route(row map[string]any) (*sdk.Child, error) {
+	received++
+	for _, rule := range rules {
+		if !rule.expression.Match(row) {
+			continue
+		}
+		child, ok := children[rule.key]
+		if !ok {
+			var err error
+			child, err = sdk.StartChild(rule.destination, stream, syncId, session)
+			if err != nil {
+				return nil, halt(err.Error())
+			}
+			children[rule.key] = child
+			session.Info(fmt.Sprintf("Started '%s' destination", rule.key))
+		}
+		return child, nil
+	}
+	unrouted++
+	return nil, nil
+}
+
+// halt replies with halt. The returned error stops the connector
+func halt(message string) error {
+	session.Error(message)
+	_ = session.Reply("halt", map[string]any{
+		"message": message,
+	})
+	return errors.New(message)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Tee is a destination that forwards each row to several child connectors and aggregates their statuses.
+// It lets a single sync feed e.g. Mixpanel and Amplitude during a migration period.
+// Children are connector processes started from the configured commands. They inherit environment, including RPC_URL.
+
+//go:embed credentials.schema.json
+var credentialSchemaString string
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
+
+const finishTimeout = 10 * time.Minute
+
+var children []*sdk.Child
+var received int
+
+// session replies to the host. Set by the first message, children log with it
+var session *sdk.Session
+
+func main() {
+	if err := sdk.Run(context.Background(), os.Stdin, os.Stdout, sdk.HandlerFunc(handle)); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func handle(ctx context.Context, message sdk.IncomingMessage, replier sdk.Replier) error {
+	if session == nil {
+		session = &sdk.Session{Replier: replier}
+	}
+	switch message.Type {
+	case "describe":
+		_ = session.Reply("spec", map[string]any{
+			"roles":                 []string{"destination"},
+			"description":           "Tee Connector. Forwards rows to multiple destinations",
+			"connectionCredentials": credentialSchema,
+			"connector":             sdk.VersionInfo(nil),
+			"supportedFramings":     sdk.SupportedFramings,
+			"supportedCompressions": sdk.SupportedCompressions,
+		})
+		return sdk.ErrStop
+	case "describe-streams":
+		_ = session.Reply("stream-spec", map[string]any{
+			"roles":         []string{"destination"},
+			"defaultStream": "default",
+			"streams":       []any{map[string]any{"name": "default", "rowType": map[string]any{"type": "object"}}},
+		})
+	case "start-stream":
+		var payload struct {
+			Stream                string `json:"stream"`
+			SyncId                string `json:"syncId"`
+			ConnectionCredentials struct {
+				Destinations []sdk.ChildConfig `json:"destinations"`
+			} `json:"connectionCredentials"`
+		}
+		err := message.DecodePayload(&payload)
+		configs := payload.ConnectionCredentials.Destinations
+		if err == nil && len(configs) == 0 {
+			err = fmt.Errorf("destinations are required")
+		}
+		if err != nil {
+			return halt(fmt.Sprintf("Invalid connection credentials: %v", err))
+		}
+		for _, config := range configs {
+			child, err := sdk.StartChild(config, payload.Stream, payload.SyncId, session)
+			if err != nil {
+				return halt(err.Error())
+			}
+			children = append(children, child)
+		}
+		session.Info(fmt.Sprintf("Stream '%s' started. Forwarding rows to %d destinations", payload.Stream, len(children)))
+	case "row", "rows":
+		rows := 1
+		if message.Type == "rows" {
+			var payload struct {
+				Rows []json.RawMessage `json:"rows"`
+			}
+			_ = json.Unmarshal(message.Payload, &payload)
+			rows = len(payload.Rows)
+		}
+		received += rows
+		line, _ := json.Marshal(message)
+		// each child has its own queue, so a slow child doesn't hold rows of others
+		for _, child := range children {
+			child.SendRows(line, rows)
+		}
+	case "end-stream":
+		results := make(map[string]any, len(children))
+		rows := make(map[string]int, len(children))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, child := range children {
+			wg.Add(1)
+			go func(c *sdk.Child) {
+				defer wg.Done()
+				r := c.Finish(finishTimeout)
+				mu.Lock()
+				results[c.Name()] = r
+				rows[c.Name()] = c.Rows()
+				mu.Unlock()
+			}(child)
+		}
+		wg.Wait()
+		session.Info(fmt.Sprintf("Stream finished. %d rows forwarded to %d destinations", received, len(children)))
+		_ = session.Reply("stream-result", aggregate(received, results, rows))
+		return sdk.ErrStop
+	default:
+		session.Error("Unknown message type", message.Type)
+	}
+	return nil
+}
+
+// aggregate replies with rows received by tee. Children's stream-results are under destinations. A child that failed
+// without stream-result gets the rows forwarded to it counted as received and failed:
+//
+//	{"received":3,"destinations":{"mixpanel":{"received":3,"success":3,...},"amplitude":{"error":"...","received":3,"failed":3}}}
+func aggregate(received int, results map[string]any, rows map[string]int) map[string]any {
+	destinations := make(map[string]any, len(results))
+	for name, result := range results {
+		status, _ := result.(map[string]any)
+		if _, failed := status["error"]; failed || status == nil {
+			failedStatus := map[string]any{"received": rows[name], "failed": rows[name]}
+			for field, value := range status {
+				failedStatus[field] = value
+			}
+			if status == nil {
+				failedStatus["error"] = fmt.Sprintf("unexpected result: %v", result)
+			}
+			destinations[name] = failedStatus
+			continue
+		}
+		destinations[name] = status
+	}
+	return map[string]any{"received": received, "destinations": destinations}
+}
+
+// halt replies with halt. The returned error stops the connector
+func halt(message string) error {
+	session.Error(message)
+	_ = session.Reply("halt", map[string]any{
+		"message": message,
+	})
+	return errors.New(message)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name     string
+		received int
+		results  map[string]any
+		rows     map[string]int
+		want     map[string]any
+	}{
+		{
+			name:     "received is counted once",
+			received: 3,
+			results: map[string]any{
+				"a": map[string]any{"received": 3.0, "success": 2.0, "skipped": 0.0, "failed": 1.0},
+				"b": map[string]any{"received": 3.0, "success": 1.0, "skipped": 2.0, "failed": 0.0},
+			},
+			rows: map[string]int{"a": 3, "b": 3},
+			want: map[string]any{"received": 3, "destinations": map[string]any{
+				"a": map[string]any{"received": 3.0, "success": 2.0, "skipped": 0.0, "failed": 1.0},
+				"b": map[string]any{"received": 3.0, "success": 1.0, "skipped": 2.0, "failed": 0.0},
+			}},
+		},
+		{
+			name:     "child without stream-result",
+			received: 3,
+			results: map[string]any{
+				"a": map[string]any{"received": 3.0, "success": 3.0},
+				"b": map[string]any{"error": "destination exited without stream-result"},
+			},
+			rows: map[string]int{"a": 3, "b": 2},
+			want: map[string]any{"received": 3, "destinations": map[string]any{
+				"a": map[string]any{"received": 3.0, "success": 3.0},
+				"b": map[string]any{"error": "destination exited without stream-result", "received": 2, "failed": 2},
+			}},
+		},
+		{
+			name:    "no rows",
+			results: map[string]any{"a": map[string]any{"received": 0.0}},
+			want: map[string]any{"received": 0, "destinations": map[string]any{
+				"a": map[string]any{"received": 0.0},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := aggregate(test.received, test.results, test.rows); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("aggregate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// maxBatchSize is the maximum number of events in one request to Events API
+const maxBatchSize = 1000
+
+var businessApiUrl = "https://business-api.tiktok.com"
+
+// Codes of Business API errors that mean the request may succeed if retried: rate limit and internal errors
+var transientErrorCodes = map[int]bool{40100: true, 50000: true, 50002: true}
+
+// apiError is an error response of Business API. Business API responds with HTTP 200 and non-zero code to most
+// rejected requests
+type apiError struct {
+	StatusCode int    `json:"-"`
+	Code       int    `json:"code"`
+	Message    string `json:"message"`
+	RequestId  string `json:"request_id"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("Events API error %d (code %d, request_id %s): %s", e.StatusCode, e.Code, e.RequestId, e.Message)
+}
+
+// Retryable returns true if the request failed because of rate limits or temporary problems of TikTok
+func (e *apiError) Retryable() bool {
+	return transientErrorCodes[e.Code] || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// RateLimited returns true if the request was throttled
+func (e *apiError) RateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.Code == 40100
+}
+
+// trackResponse is a response of event/track endpoint
+type trackResponse struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestId string `json:"request_id"`
+}
+
+// eventsClient sends events to the pixel or offline event set
+type eventsClient struct {
+	httpClient    *http.Client
+	apiVersion    string
+	accessToken   string
+	eventSource   string
+	eventSourceId string
+	testEventCode string
+}
+
+// send sends the batch of events. Events API accepts or rejects the batch as a whole
+func (c *eventsClient) send(ctx context.Context, events []*trackEvent) (*sdk.BatchResponse, error) {
+	body := map[string]any{
+		"event_source":    c.eventSource,
+		"event_source_id": c.eventSourceId,
+		"data":            events,
+	}
+	if c.testEventCode != "" {
+		body["test_event_code"] = c.testEventCode
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/open_api/%s/event/track/", businessApiUrl, c.apiVersion)
+	response, err := c.post(ctx, url, b)
+	if err != nil {
+		return nil, err
+	}
+	return &sdk.BatchResponse{Accepted: len(events), TraceId: response.RequestId}, nil
+}
+
+func (c *eventsClient) post(ctx context.Context, url string, body []byte) (*trackResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Access-Token", c.accessToken)
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response trackResponse
+	if json.Unmarshal(b, &response) != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid response of Events API: %s", string(b))
+	}
+	if res.StatusCode != http.StatusOK || response.Code != 0 {
+		ae := &apiError{StatusCode: res.StatusCode, Code: response.Code, Message: response.Message, RequestId: response.RequestId}
+		if ae.Message == "" {
+			ae.Message = string(b)
+		}
+		return nil, ae
+	}
+	return &response, nil
+}
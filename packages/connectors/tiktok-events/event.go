@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// Rows are converted to events of Events API 2.0: event, time, id, user, page and properties.
+//
+// Customer information is normalized and hashed as required by TikTok, so the same person gets the same hash
+// regardless of formatting in the source, e.g. " John.Doe@Example.com" and "john.doe@example.com"
+
+// hashedColumns are columns of customer information sent in user object hashed
+var hashedColumns = map[string]bool{
+	"email":       true,
+	"phone":       true,
+	"external_id": true,
+}
+
+// plainColumns are columns sent in user object as is
+var plainColumns = map[string]bool{
+	"ttclid":     true,
+	"ttp":        true,
+	"ip":         true,
+	"user_agent": true,
+}
+
+// pageColumns map columns to parameters of page object
+var pageColumns = map[string]string{
+	"page_url": "url",
+	"referrer": "referrer",
+}
+
+// eventColumns are columns mapped to parameters of the event itself. Other columns go to properties
+var eventColumns = map[string]bool{
+	"event":      true,
+	"event_time": true,
+	"event_id":   true,
+}
+
+// trackEvent is an event of Events API
+type trackEvent struct {
+	Event      string            `json:"event"`
+	EventTime  int64             `json:"event_time"`
+	EventId    string            `json:"event_id"`
+	User       map[string]string `json:"user"`
+	Page       map[string]string `json:"page,omitempty"`
+	Properties map[string]any    `json:"properties,omitempty"`
+}
+
+// Key returns event and event_id, TikTok deduplicates events by them
+func (e *trackEvent) Key() (string, string) {
+	return e.Event, e.EventId
+}
+
+func (e *trackEvent) SetId(id string) {
+	e.EventId = id
+}
+
+// newTrackEvent converts the row to event. Returns error if the row is invalid, such events would make
+// Events API reject the whole batch
+func newTrackEvent(row map[string]any, now time.Time) (*trackEvent, error) {
+	event := &trackEvent{User: map[string]string{}, Page: map[string]string{}, Properties: map[string]any{}}
+	event.Event = sdk.StringValue(row["event"])
+	if event.Event == "" {
+		return nil, fmt.Errorf("event is required")
+	}
+	eventTime, err := sdk.ParseEventTime(row["event_time"], now)
+	if err != nil {
+		return nil, err
+	}
+	event.EventTime = eventTime.Unix()
+	for column, value := range row {
+		if hashedColumns[column] {
+			if hashed := hashValue(column, sdk.StringValue(value)); hashed != "" {
+				event.User[column] = hashed
+			}
+		} else if plainColumns[column] {
+			if s := sdk.StringValue(value); s != "" {
+				event.User[column] = s
+			}
+		} else if param, ok := pageColumns[column]; ok {
+			if s := sdk.StringValue(value); s != "" {
+				event.Page[param] = s
+			}
+		} else if !eventColumns[column] && value != nil {
+			event.Properties[column] = value
+		}
+	}
+	if len(event.User) == 0 {
+		return nil, fmt.Errorf("row has no customer information, at least one of email, phone, external_id, ttclid, ttp or ip is required")
+	}
+	event.EventId = sdk.StringValue(row["event_id"])
+	if event.EventId == "" {
+		event.EventId = sdk.DeriveEventId(event.Event, event.EventTime, event.User)
+	}
+	return event, nil
+}
+
+// hashValue normalizes value of user parameter and returns its SHA-256 hex. Values that are hashed already
+// are returned as is. Empty result means the value is empty after normalization
+func hashValue(param string, value string) string {
+	return sdk.HashSha256(value, func(value string) string { return normalize(param, value) })
+}
+
+// normalize applies normalization rules of TikTok to value of user parameter
+func normalize(param string, value string) string {
+	switch param {
+	case "email":
+		return strings.ToLower(value)
+	case "phone":
+		// E.164: + and digits with country code, without leading zeros of international prefix
+		digits := strings.TrimLeft(keepOnly(value, unicode.IsDigit), "0")
+		if digits == "" {
+			return ""
+		}
+		return "+" + digits
+	}
+	return value
+}
+
+func keepOnly(s string, keep func(r rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if keep(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
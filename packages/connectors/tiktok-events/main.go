@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+// TikTok Events API destination. Rows of events stream are sent as web events to a pixel, or as offline events to
+// an offline event set, in batches of up to 1000 events, see sdk.BatchedDestination. Customer information is hashed with SHA-256 before sending,
+// see event.go. Events are deduplicated by event_id: duplicates within a run are skipped, and TikTok deduplicates
+// events of different runs and events sent by the browser pixel with the same event and event_id. With dedupTtlDays
+// events delivered by previous runs are remembered in state and skipped. testEventCode sends events as test events,
+// so a configuration can be validated in Events Manager without affecting reporting
+
+//go:embed credentials.schema.json
+var credentialSchemaString string
+var credentialSchema = sdk.UnmarshalSchema(credentialSchemaString)
+
+//go:embed row.schema.json
+var rowSchemaString string
+var rowSchema = sdk.UnmarshalSchema(rowSchemaString)
+
+const streamEvents = "events"
+
+// Sources of events, event_source parameter of Events API
+const (
+	eventSourceWeb     = "web"
+	eventSourceOffline = "offline"
+)
+
+type tiktok struct {
+	sdk.BatchedDestination[*trackEvent]
+	client *eventsClient
+}
+
+func main() {
+	sdk.Serve(&tiktok{})
+}
+
+func (c *tiktok) Describe() (map[string]any, error) {
+	return map[string]any{
+		"roles":                 []string{"destination"},
+		"description":           "TikTok Events API Connector. Sends web events to TikTok pixel or offline events to offline event set",
+		"connectionCredentials": credentialSchema,
+	}, nil
+}
+
+func (c *tiktok) DescribeStreams() (map[string]any, error) {
+	return map[string]any{
+		"roles":         []string{"destination"},
+		"defaultStream": streamEvents,
+		"streams":       []any{map[string]any{"name": streamEvents, "rowType": rowSchema}},
+	}, nil
+}
+
+func (c *tiktok) StartStream(ctx context.Context, stream sdk.StartStream, session *sdk.Session) error {
+	if stream.Stream != streamEvents {
+		return fmt.Errorf("unknown stream: %s", stream.Stream)
+	}
+	creds := stream.ConnectionCredentials
+	client := &eventsClient{apiVersion: "v1.3", eventSource: eventSourceWeb}
+	client.accessToken, _ = creds["accessToken"].(string)
+	if eventSource, _ := creds["eventSource"].(string); eventSource != "" {
+		client.eventSource = eventSource
+	}
+	switch client.eventSource {
+	case eventSourceWeb:
+		client.eventSourceId, _ = creds["pixelCode"].(string)
+		if client.eventSourceId == "" {
+			return fmt.Errorf("pixelCode is required for web events")
+		}
+	case eventSourceOffline:
+		client.eventSourceId, _ = creds["offlineEventSetId"].(string)
+		if client.eventSourceId == "" {
+			return fmt.Errorf("offlineEventSetId is required for offline events")
+		}
+	default:
+		return fmt.Errorf("eventSource must be %s or %s, got: %s", eventSourceWeb, eventSourceOffline, client.eventSource)
+	}
+	if client.accessToken == "" {
+		return fmt.Errorf("accessToken is required")
+	}
+	if apiVersion, _ := creds["apiVersion"].(string); apiVersion != "" {
+		client.apiVersion = apiVersion
+	}
+	client.testEventCode, _ = creds["testEventCode"].(string)
+	httpClient, err := c.Start(stream.Stream, session, creds, sdk.BatchOptions{
+		Api:          "Events API",
+		MaxBatchSize: maxBatchSize,
+		EventColumn:  "event",
+		DedupPrefix:  []string{"type=tiktok-events.dedup", client.eventSource + "=" + client.eventSourceId},
+	})
+	if err != nil {
+		return err
+	}
+	client.httpClient = httpClient
+	c.client = client
+	c.NewEvent = func(row map[string]any) (*trackEvent, error) {
+		return newTrackEvent(row, time.Now())
+	}
+	c.Send = client.send
+	details := fmt.Sprintf("Event source: %s %s API version: %s", client.eventSource, client.eventSourceId, client.apiVersion)
+	if client.testEventCode != "" {
+		details += fmt.Sprintf(" Test event code: %s", client.testEventCode)
+	}
+	c.Started(details)
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/jitsucom/syncmaven/connector-sdk"
+)
+
+func TestHashValue(t *testing.T) {
+	tests := []struct {
+		param, value, normalized string
+	}{
+		{"email", " John.Doe@Example.com ", "john.doe@example.com"},
+		{"phone", "+1 (650) 555-1212", "+16505551212"},
+		{"phone", "00 44 20 7946 0958", "+442079460958"},
+		{"external_id", " Customer-1 ", "Customer-1"},
+	}
+	for _, test := range tests {
+		if got, want := hashValue(test.param, test.value), hashValue(test.param, test.normalized); got != want {
+			t.Errorf("hashValue(%s, %q) = %s, want hash of %q", test.param, test.value, got, test.normalized)
+		}
+	}
+	hashed := hashValue("email", "john.doe@example.com")
+	if got := hashValue("email", hashed); got != hashed {
+		t.Errorf("hashed value is hashed again: %s", got)
+	}
+	if got := hashValue("phone", "n/a"); got != "" {
+		t.Errorf("empty value after normalization must not be hashed: %s", got)
+	}
+}
+
+func TestNewTrackEvent(t *testing.T) {
+	row := map[string]any{
+		"event":      "CompletePayment",
+		"event_time": json.Number("1714557600"),
+		"email":      "John.Doe@Example.com",
+		"ttclid":     "E.C.P.abc",
+		"page_url":   "https://example.com/checkout",
+		"value":      json.Number("42.5"),
+		"currency":   "USD",
+	}
+	event, err := newTrackEvent(row, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventTime != 1714557600 || event.User["email"] != hashValue("email", "john.doe@example.com") || event.User["ttclid"] != "E.C.P.abc" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.Page["url"] != "https://example.com/checkout" {
+		t.Errorf("unexpected page: %v", event.Page)
+	}
+	if event.Properties["value"] != json.Number("42.5") || event.Properties["currency"] != "USD" || event.Properties["email"] != nil {
+		t.Errorf("unexpected properties: %v", event.Properties)
+	}
+	again, _ := newTrackEvent(row, time.Now().Add(time.Hour))
+	if event.EventId == "" || again.EventId != event.EventId {
+		t.Errorf("derived event_id must be stable: %s, %s", event.EventId, again.EventId)
+	}
+	if _, err = newTrackEvent(map[string]any{"event": "Lead", "value": 1.0}, time.Now()); err == nil {
+		t.Errorf("event without customer information must be rejected")
+	}
+}
+
+// TestEventsStream checks requests to Events API and handling of errors that Business API returns with HTTP 200.
+// Batching, deduplication and row errors are common to batched destinations, see sdk.BatchedDestination
+func TestEventsStream(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]trackEvent
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/open_api/v1.3/event/track/" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if token := r.Header.Get("Access-Token"); token != "token" {
+			t.Errorf("unexpected Access-Token: %s", token)
+		}
+		var body struct {
+			EventSource   string       `json:"event_source"`
+			EventSourceId string       `json:"event_source_id"`
+			TestEventCode string       `json:"test_event_code"`
+			Data          []trackEvent `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.EventSource != "web" || body.EventSourceId != "PIXEL" || body.TestEventCode != "TEST123" {
+			t.Errorf("unexpected request: %+v", body)
+		}
+		// Business API rejects requests with HTTP 200
+		if failures > 0 {
+			failures--
+			_, _ = w.Write([]byte(`{"code":40100,"message":"Too many requests","request_id":"r1"}`))
+			return
+		}
+		if body.Data[0].Event == "Invalid" {
+			_, _ = w.Write([]byte(`{"code":40002,"message":"Invalid parameter","request_id":"r2"}`))
+			return
+		}
+		batches = append(batches, body.Data)
+		_, _ = w.Write([]byte(`{"code":0,"message":"OK","request_id":"r3","data":{}}`))
+	}))
+	defer server.Close()
+	businessApiUrl = server.URL
+
+	replier := &sdk.RecordingReplier{}
+	c := &tiktok{}
+	ctx := context.Background()
+	err := c.StartStream(ctx, sdk.StartStream{
+		Stream:                streamEvents,
+		ConnectionCredentials: map[string]any{"pixelCode": "PIXEL", "accessToken": "token", "batchSize": 2.0, "testEventCode": "TEST123"},
+	}, &sdk.Session{Replier: replier})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RetryDelay = time.Millisecond
+	rows := []map[string]any{
+		{"event": "SubmitForm", "event_id": "1", "email": "a@example.com"},
+		{"event": "SubmitForm", "event_id": "2", "email": "b@example.com"},
+		{"event": "Invalid", "event_id": "3", "email": "c@example.com"},
+	}
+	for _, row := range rows {
+		if err = c.Row(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := c.EndStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := result.(sdk.BatchStatus); status.Received != 3 || status.Success != 2 || status.Failed != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if len(batches) != 1 || len(batches[0]) != 2 || batches[0][0].EventId != "1" || batches[0][1].EventId != "2" {
+		t.Errorf("unexpected batches: %+v", batches)
+	}
+	// code 40100 is retried, 40002 is not
+	rowErrors := replier.Replies("row-error")
+	if len(rowErrors) != 1 {
+		t.Fatalf("expected 1 row error, got %v", rowErrors)
+	}
+	if e := rowErrors[0].(map[string]any); e["class"] != sdk.RowErrorApi || e["retryable"] != false || e["insertId"] != "3" {
+		t.Errorf("unexpected row error: %v", e)
+	}
+}
+
+func TestStartStreamEventSource(t *testing.T) {
+	c := &tiktok{}
+	ctx := context.Background()
+	start := func(creds map[string]any) error {
+		return c.StartStream(ctx, sdk.StartStream{Stream: streamEvents, ConnectionCredentials: creds}, &sdk.Session{Replier: &sdk.RecordingReplier{}})
+	}
+	if err := start(map[string]any{"accessToken": "token", "eventSource": "offline", "pixelCode": "PIXEL"}); err == nil {
+		t.Error("offline events must require offlineEventSetId")
+	}
+	if err := start(map[string]any{"accessToken": "token", "eventSource": "offline", "offlineEventSetId": "SET"}); err != nil || c.client.eventSourceId != "SET" {
+		t.Errorf("unexpected offline event source: %v %s", err, c.client.eventSourceId)
+	}
+	if err := start(map[string]any{"accessToken": "token", "eventSource": "app", "pixelCode": "PIXEL"}); err == nil {
+		t.Error("unsupported event source must be rejected")
+	}
+}
@@ -0,0 +1,30 @@
+import { test } from "node:test";
+import assert from "assert";
+import { createAnonymizer } from "../../src/lib/anonymize";
+
+test("test-anonymize", () => {
+  const anonymize = createAnonymizer({
+    salt: "secret",
+    columns: { email: "email", name: "name", user_id: "hash", ip: "ip", phone: "phone", notes: "null" },
+  });
+  const row = {
+    email: "jane@acme.com",
+    name: "Jane Doe",
+    user_id: 42,
+    ip: "10.0.0.1",
+    phone: null,
+    notes: "x",
+    plan: "pro",
+  };
+  const res = anonymize(row);
+  assert.deepEqual(anonymize(row), res, "replacements must be deterministic");
+  assert.equal(res.plan, "pro");
+  assert.equal(res.phone, null);
+  assert.equal(res.notes, null);
+  assert.match(res.email, /^[a-z]+\.[a-z]+\.\d+@example\.com$/);
+  assert.match(res.name, /^[A-Z][a-z]+ [A-Z][a-z]+$/);
+  assert.match(res.user_id, /^[0-9a-f]{64}$/);
+  assert.match(res.ip, /^203\.0\.113\.\d+$/);
+  assert.equal(row.email, "jane@acme.com", "input row must not be modified");
+  assert.notEqual(createAnonymizer({ salt: "other", columns: { user_id: "hash" } })(row).user_id, res.user_id);
+});
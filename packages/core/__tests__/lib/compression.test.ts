@@ -0,0 +1,46 @@
+import { test } from "node:test";
+import assert from "assert";
+import readline from "readline";
+import { PassThrough } from "stream";
+import zlib from "zlib";
+import { createCompressor, decodeReplies } from "../../src/docker/container";
+
+async function readLines(input: NodeJS.ReadableStream): Promise<string[]> {
+  const lines: string[] = [];
+  for await (const line of readline.createInterface({ input })) {
+    lines.push(line);
+  }
+  return lines;
+}
+
+test("replies after hello reply are decompressed", async () => {
+  //zstd streams are available since Node 22.15
+  const compressions: ("gzip" | "zstd")[] = "createZstdCompress" in zlib ? ["gzip", "zstd"] : ["gzip"];
+  for (const compression of compressions) {
+    const stdout = new PassThrough();
+    const lines = readLines(decodeReplies(stdout, compression));
+    const log = JSON.stringify({ type: "log", payload: { level: "info", message: "starting" } });
+    const hello = JSON.stringify({ type: "hello", direction: "reply", payload: { framing: "ndjson", compression } });
+    stdout.write(`${log}\n${hello.substring(0, 10)}`);
+    stdout.write(hello.substring(10) + "\n");
+
+    const compressor = createCompressor(compression);
+    compressor.on("data", chunk => stdout.write(chunk));
+    compressor.on("end", () => stdout.end());
+    compressor.write(JSON.stringify({ type: "stream-result", payload: { received: 1 } }) + "\n");
+    compressor.end();
+
+    assert.deepEqual(
+      (await lines).map(line => JSON.parse(line).type),
+      ["log", "hello", "stream-result"],
+      `${compression}: plain lines before hello reply, decompressed after it`
+    );
+  }
+});
+
+test("connector output without hello reply is passed as is", async () => {
+  const stdout = new PassThrough();
+  const lines = readLines(decodeReplies(stdout, "gzip"));
+  stdout.end("panic: connector failed\n");
+  assert.deepEqual(await lines, ["panic: connector failed"]);
+});
@@ -74,6 +74,14 @@ export async function describeDestination(opts: CommonOpts & PackageOpts & { jso
   output.push(``);
   displayProperties(credentialsSchema);
   output.push(``);
+  const scheduling = description.payload.scheduling;
+  if (scheduling) {
+    output.push(`🕒 Scheduling hints`);
+    for (const [k, v] of Object.entries(scheduling)) {
+      output.push(`  ${k}: ${v}`);
+    }
+    output.push(``);
+  }
   output.push(`📌 To see a full JSON schema run the command with --json flag`);
 
   process.stdout.write(output.join("\n") + "\n");
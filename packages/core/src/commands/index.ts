@@ -105,6 +105,15 @@ export async function initCli(): Promise<Command> {
     )
     .option(commonOptions.debug.flag, commonOptions.debug.description)
     .option(commonOptions.fullRefresh.flag, commonOptions.fullRefresh.description)
+    .option(
+      "--start-date <date>",
+      "First day to send, YYYY-MM-DD. Overrides the range computed by the destination, e.g. to resend a week of data"
+    )
+    .option("--end-date <date>", "Last day to send, YYYY-MM-DD. Overrides the range computed by the destination")
+    .option(
+      "--dry-run",
+      "Validate, map and batch rows without sending them to the destination. Samples of payloads are printed, state is not changed"
+    )
     .action(sync);
 
   program
@@ -2,17 +2,29 @@ import { ConnectionDefinition, SyncDefinition } from "../types/objects";
 import assert from "assert";
 import {
   BaseChannel,
+  CheckpointMessage,
   DestinationChannel,
+  DryRunMessage,
   EnrichmentChannel,
   ExecutionContext,
   HaltMessage,
   LogMessage,
+  MetricMessage,
   MessageHandler,
+  LineageMessage,
+  PreflightMessage,
+  PreviewMessage,
+  RequestRestatementMessage,
+  RestatementRange,
+  RetryLaterMessage,
+  RowErrorMessage,
   StreamPersistenceStore,
+  WarningMessage,
 } from "@syncmaven/protocol";
 import { stringifyZodError } from "../lib/zod";
 import { configureEnvVars, readProject, untildify } from "../lib/project";
 import { createErrorThreshold } from "../lib/error-threshold";
+import { createAnonymizer } from "../lib/anonymize";
 import { Project } from "../types/project";
 import { createParser, SchemaBasedParser, stringifyParseError } from "../lib/uniparser";
 import { StdInOutChannel } from "../docker/docker-channel";
@@ -23,6 +35,7 @@ import { SqlQuery } from "../lib/sql";
 import { GenericColumnType } from "../datasources/types";
 import fs from "fs";
 import { trackEvent } from "../lib/telemetry";
+import { randomUUID } from "crypto";
 
 export function getDestinationChannel(
   pkg: ConnectionDefinition["package"],
@@ -74,6 +87,9 @@ export async function sync(
     state?: string;
     select?: string;
     fullRefresh?: boolean;
+    startDate?: string;
+    endDate?: string;
+    dryRun?: boolean;
     env?: string[];
   }
 ) {
@@ -98,7 +114,7 @@ export async function sync(
   let errors = false;
   for (const syncId of syncIds) {
     try {
-      await runSync({ project, syncId, store });
+      await runSync({ project, syncId, store, startDate: opts.startDate, endDate: opts.endDate, dryRun: opts.dryRun });
     } catch (e: any) {
       errors = true;
       console.error(`Failed to run sync: ${syncId}`, e);
@@ -134,11 +150,18 @@ function compareCursors(type: CursorState["type"], val1: CursorState["val"], val
   return val1 < val2 ? -1 : 1;
 }
 
+//maximum number of entries kept in the dead-letter store of a sync
+const maxRowErrors = 1000;
+
 export async function runSync(opts: {
   project: Project;
   syncId: string;
   store: StreamPersistenceStore;
   fullRefresh?: boolean;
+  startDate?: string;
+  endDate?: string;
+  //connectors validate, map and batch rows without sending them. Sync state is not changed
+  dryRun?: boolean;
 }) {
   const { project, syncId, store } = opts;
   const syncFactory = project.syncs[syncId];
@@ -148,7 +171,9 @@ export async function runSync(opts: {
     );
   }
   const sync: SyncDefinition = syncFactory();
-  console.info(`Running sync \`${syncId}\``, sync);
+  //identifies the run in connector logs, RPC calls and destination requests
+  const traceId = randomUUID();
+  console.info(`Running sync \`${syncId}\`. Trace id: ${traceId}`, sync);
   const modelId = sync.model;
   const checkpointEvery = sync.checkpointEvery;
 
@@ -167,6 +192,26 @@ export async function runSync(opts: {
 
   let halt = false;
   let haltError: any;
+  //column-level lineage reported by the connector
+  let lineage: LineageMessage["payload"] | undefined;
+  //set if connector asked to reschedule the sync
+  let retryLater: RetryLaterMessage["payload"] | undefined;
+  //number of warning messages received by category. Connectors may report totals in stream-result
+  const warningCounts: Record<string, number> = {};
+  //ranges connector asked to resend in previous runs, they are passed to start-stream of this run
+  const restatementStoreKey = [`syncId=${syncId}`, "$restatement"];
+  let pendingRestatements: RestatementRange[] = [];
+  //ranges connector asked to resend during this run
+  const requestedRestatements: RestatementRange[] = [];
+  //rows connector failed to write during this run, appended to the dead-letter store of the sync
+  const rowErrorsStoreKey = [`syncId=${syncId}`, "$row-errors"];
+  const rowErrors: (RowErrorMessage["payload"] & { at: string })[] = [];
+  //last checkpoint of each tenant, kept in the store until the run completes
+  const checkpointStoreKey = [`syncId=${syncId}`, "$checkpoint"];
+  const checkpoints: Record<string, CheckpointMessage["payload"] & { at: string }> = {};
+  //the last metric of the connector, kept in the store so an orchestrator can scrape it
+  const metricsStoreKey = [`syncId=${syncId}`, "$metrics"];
+  let metrics: MetricMessage["payload"] | undefined;
 
   const messageListener = message => {
     switch (message.type) {
@@ -177,6 +222,82 @@ export async function runSync(opts: {
           : "";
         console.log(`LOG [${syncId}] ${logMes.payload.level.toUpperCase()} ${logMes.payload.message}${params}`);
         break;
+      case "warning":
+        const warnMes = message as WarningMessage;
+        warningCounts[warnMes.payload.category] = (warningCounts[warnMes.payload.category] || 0) + 1;
+        console.debug(
+          `WARNING [${syncId}] ${warnMes.payload.category} ${warnMes.payload.message}${warnMes.payload.params?.length ? ` ${JSON.stringify(warnMes.payload.params)}` : ""}`
+        );
+        break;
+      case "row-error":
+        const rowErrorMes = message as RowErrorMessage;
+        rowErrors.push({ ...rowErrorMes.payload, at: new Date().toISOString() });
+        console.debug(
+          `ROW-ERROR [${syncId}] ${rowErrorMes.payload.class}${rowErrorMes.payload.retryable ? " (retryable)" : ""} ${rowErrorMes.payload.message} key: ${JSON.stringify(rowErrorMes.payload.key)}`
+        );
+        break;
+      case "preflight":
+        const preflightMes = message as PreflightMessage;
+        console.info(
+          `PREFLIGHT [${syncId}] ${preflightMes.payload.complete ? "" : "projected "}events: ${preflightMes.payload.projectedEvents} report: ${JSON.stringify(preflightMes.payload)}`
+        );
+        break;
+      case "preview":
+        const previewMes = message as PreviewMessage;
+        console.info(`PREVIEW [${syncId}] first run events: ${previewMes.payload.events.length}`);
+        previewMes.payload.events.forEach(e => console.info(`PREVIEW [${syncId}] ${JSON.stringify(e)}`));
+        break;
+      case "dry-run":
+        const dryRunMes = message as DryRunMessage;
+        console.info(
+          `DRY RUN [${syncId}] ${dryRunMes.payload.payloadCount} payloads in ${dryRunMes.payload.requests} requests would have been sent. Sample:`
+        );
+        dryRunMes.payload.payloads.forEach(p => console.info(`DRY RUN [${syncId}] ${JSON.stringify(p)}`));
+        break;
+      case "checkpoint":
+        const checkpointMes = message as CheckpointMessage;
+        const { tenant: checkpointTenant, committed, stateVersion, rows, ordinal } = checkpointMes.payload;
+        checkpoints[checkpointTenant || ""] = { ...checkpointMes.payload, at: new Date().toISOString() };
+        console.debug(
+          `CHECKPOINT [${syncId}]${checkpointTenant ? ` tenant: ${checkpointTenant}` : ""} rows: ${rows}${committed ? ` committed: ${committed.from === committed.to ? committed.from : `${committed.from}..${committed.to}`}` : ""}${stateVersion !== undefined ? ` state version: ${stateVersion}` : ""}${ordinal !== undefined ? ` ordinal: ${ordinal}` : ""}`
+        );
+        store.set(checkpointStoreKey, checkpoints).catch(e => console.warn(`Failed to save checkpoint: ${e?.message}`));
+        break;
+      case "metric":
+        const metricMes = message as MetricMessage;
+        metrics = metricMes.payload;
+        console.debug(
+          `METRIC [${syncId}] rows: ${metrics.rows}${metrics.rowsPerSecond !== undefined ? ` (${metrics.rowsPerSecond.toFixed(1)}/s)` : ""}${metrics.latencyMs ? ` latency p50: ${metrics.latencyMs.p50}ms p95: ${metrics.latencyMs.p95}ms` : ""}${metrics.queueDepth !== undefined ? ` queue: ${metrics.queueDepth}` : ""}${metrics.retries ? ` retries: ${metrics.retries}` : ""}`
+        );
+        store
+          .set(metricsStoreKey, { ...metrics, at: new Date().toISOString() })
+          .catch(e => console.warn(`Failed to save metrics: ${e?.message}`));
+        break;
+      case "lineage":
+        const lineageMes = message as LineageMessage;
+        lineage = lineageMes.payload;
+        console.debug(`LINEAGE [${syncId}] ${JSON.stringify(lineageMes.payload)}`);
+        break;
+      case "hello":
+        console.debug(`HELLO [${syncId}] framing: ${message.payload?.framing}`);
+        break;
+      case "retry-later":
+        const retryMes = message as RetryLaterMessage;
+        //connector is ending the run, no more rows should be sent
+        halt = true;
+        retryLater = retryMes.payload;
+        console.warn(
+          `RETRY-LATER [${syncId}] ${retryMes.payload.reason || "destination asked to retry"}. Retry in ${retryMes.payload.delaySeconds}s${retryMes.payload.retryAt ? ` (at ${retryMes.payload.retryAt})` : ""}, sent rows ${retryMes.payload.committed === false ? "are not" : "are"} committed`
+        );
+        break;
+      case "request-restatement":
+        const restatementMes = message as RequestRestatementMessage;
+        const tenant = restatementMes.payload.tenant;
+        requestedRestatements.push(...restatementMes.payload.ranges.map(r => ({ ...r, ...(tenant && { tenant }) })));
+        console.warn(
+          `REQUEST-RESTATEMENT [${syncId}] ${restatementMes.payload.reason || "destination lost data"}${tenant ? ` tenant: ${tenant}` : ""}. Ranges ${restatementMes.payload.ranges.map(r => (r.from === r.to ? r.from : `${r.from}..${r.to}`)).join(", ")} will be resent by the next run`
+        );
+        break;
       case "halt":
         const haltMes = message as HaltMessage;
         halt = true;
@@ -198,11 +319,40 @@ export async function runSync(opts: {
     }
   };
 
+  async function saveRestatements(ranges: RestatementRange[]) {
+    if (opts.dryRun) {
+      return;
+    }
+    if (ranges.length > 0) {
+      await store.set(restatementStoreKey, ranges);
+    } else if (pendingRestatements.length > 0) {
+      await store.del(restatementStoreKey);
+    }
+  }
+
+  async function saveRowErrors() {
+    if (rowErrors.length === 0 || opts.dryRun) {
+      return;
+    }
+    const existing = ((await store.get(rowErrorsStoreKey)) as any[] | undefined) || [];
+    //oldest entries are dropped first
+    await store.set(rowErrorsStoreKey, [...existing, ...rowErrors].slice(-maxRowErrors));
+    console.warn(
+      `Sync ${syncId} failed to write ${rowErrors.length} rows. They are added to ${rowErrorsStoreKey.join("/")}`
+    );
+  }
+
   const destinationChannel: DestinationChannel = getDestinationChannel(destination.package, messageListener);
   const enrichments: EnrichmentChannel[] = [];
   let datasource: DataSource | undefined = undefined;
   try {
     const connectionSpec = await destinationChannel.describe();
+    const dataAvailableAfter = connectionSpec.payload.scheduling?.dataAvailableAfter;
+    if (dataAvailableAfter && new Date().toISOString().substring(11, 16) < dataAvailableAfter) {
+      console.warn(
+        `Destination ${destinationId} prefers syncs after ${dataAvailableAfter} UTC, when data of the previous day is finalized. Days synced earlier may need restatement`
+      );
+    }
     const connectionCredentialsParser = createParser(connectionSpec.payload.connectionCredentials);
     const parsedCredentials = connectionCredentialsParser.safeParse(destination.credentials);
     if (!parsedCredentials.success) {
@@ -245,6 +395,12 @@ export async function runSync(opts: {
 
     let totalRows = 0;
     let enrichedRows = 0;
+    //rows sent to the destination are numbered, so checkpoints tell exactly how far the destination got
+    let rowOrdinal = 0;
+    const anonymize = sync.anonymize ? createAnonymizer(sync.anonymize) : undefined;
+    if (sync.anonymize) {
+      console.info(`Anonymizing columns: ${Object.keys(sync.anonymize.columns).join(", ")}`);
+    }
     const errorThreshold = createErrorThreshold();
     datasource = await createDatasource(model);
     const query = new SqlQuery(model.query, datasource.type(), datasource.toQueryParameter);
@@ -255,19 +411,32 @@ export async function runSync(opts: {
     }
     let maxCursorVal: CursorState | undefined = undefined;
     const cursorStoreKey = [`syncId=${syncId}`, `$lastCursor=${model.cursor}`];
-    if (model.cursor && opts.fullRefresh) {
+    if (model.cursor && opts.fullRefresh && !opts.dryRun) {
       await store.del(cursorStoreKey);
     }
-    const lastMaxCursor = model.cursor ? ((await store.get(cursorStoreKey)) as CursorState) : null;
+    const lastMaxCursor =
+      model.cursor && !(opts.fullRefresh && opts.dryRun) ? ((await store.get(cursorStoreKey)) as CursorState) : null;
     if (lastMaxCursor?.val && lastMaxCursor.type === "date") {
       lastMaxCursor.val = new Date(lastMaxCursor.val);
     }
 
     let streamStarted = false;
+    const previousCheckpoints = (await store.get(checkpointStoreKey)) as typeof checkpoints | undefined;
+    if (previousCheckpoints && Object.keys(previousCheckpoints).length > 0) {
+      console.warn(
+        `Previous run of sync ${syncId} didn't complete. It stopped after checkpoints: ${JSON.stringify(previousCheckpoints)}`
+      );
+    }
+    pendingRestatements = ((await store.get(restatementStoreKey)) as RestatementRange[] | undefined) || [];
+    if (pendingRestatements.length > 0) {
+      console.info(`Restating ranges requested by previous runs: ${JSON.stringify(pendingRestatements)}`);
+    }
+    //restatement is passed only to the first stream of the run, streams started by checkpoints continue it
+    let restateSent = false;
 
     async function checkpoint(completed: boolean) {
       const res = await destinationChannel.stopStream();
-      if (model.cursor) {
+      if (model.cursor && !opts.dryRun) {
         console.debug(`Max cursor value: ${maxCursorVal}`);
         await store.set(cursorStoreKey, maxCursorVal);
       }
@@ -281,6 +450,26 @@ export async function runSync(opts: {
           console.info(`  ${k}: ${JSON.stringify(v)}`);
         }
       }
+      if (metrics) {
+        console.info(
+          `  metrics: ${metrics.requests || 0} requests, ${metrics.bytesSent || 0} bytes sent, ${metrics.retries || 0} retries`
+        );
+      }
+      if (lineage) {
+        console.info(
+          `  lineage: ${lineage.columns.length} properties of ${lineage.destination}${lineage.dataset ? ` ${lineage.dataset}` : ""} mapped, ${lineage.dropped?.length || 0} columns dropped`
+        );
+      }
+      //prefer totals reported by connector, since it sends only first warnings of each category
+      const warnings = (res.payload as any)?.warnings || warningCounts;
+      const totalWarnings = Object.values(warnings).reduce((a: number, b: any) => a + (typeof b === "number" ? b : 0), 0);
+      if (totalWarnings > 0) {
+        console.warn(
+          `Sync ${syncId} ${completed ? "completed" : "checkpointed"} with ${totalWarnings} warnings: ${Object.entries(warnings)
+            .map(([k, v]) => `${k}: ${v}`)
+            .join(", ")}`
+        );
+      }
     }
 
     await datasource.executeQuery({
@@ -306,12 +495,19 @@ export async function runSync(opts: {
                   connectionCredentials: parsedCredentials.data,
                   streamOptions: sync.options || {},
                   syncId,
+                  traceId,
                   fullRefresh: !!opts.fullRefresh,
+                  ...(!restateSent && pendingRestatements.length > 0 && { restate: pendingRestatements }),
+                  ...(opts.startDate && { startDate: opts.startDate }),
+                  ...(opts.endDate && { endDate: opts.endDate }),
+                  ...(sync.featureFlags && { featureFlags: sync.featureFlags }),
+                  ...(opts.dryRun && { dryRun: true }),
                 },
               },
               context
             );
             streamStarted = true;
+            restateSent = true;
           }
           const parseResult = rowSchemaParser.safeParse(row);
           if (model.cursor) {
@@ -335,7 +531,9 @@ export async function runSync(opts: {
               if (halt) {
                 break;
               }
-              await destinationChannel.row({ type: "row", payload: { row } });
+              //enrichments see original values, only the destination gets replacements
+              const payload = { row: anonymize ? anonymize(row) : row, ordinal: ++rowOrdinal };
+              await destinationChannel.row({ type: "row", payload });
             }
           } else {
             const zodError = stringifyZodError(parseResult.error);
@@ -356,12 +554,28 @@ export async function runSync(opts: {
       },
     });
     await checkpoint(true);
+    //restated ranges are resent unless the run ended early, ranges requested by this run are resent by the next one
+    await saveRestatements(
+      haltError || retryLater ? [...pendingRestatements, ...requestedRestatements] : requestedRestatements
+    );
+    if (!haltError) {
+      await store.del(checkpointStoreKey);
+    }
+    if (retryLater) {
+      console.warn(
+        `Sync ${syncId} ended early because destination is rate limited. Run it again in ${retryLater.delaySeconds}s to send the remaining rows`
+      );
+    }
   } catch (e: any) {
+    if (requestedRestatements.length > 0) {
+      await saveRestatements([...pendingRestatements, ...requestedRestatements]);
+    }
     throw e;
   } finally {
     console.debug(`Closing all communications channels of sync '${syncId}'. It might take a while`);
     await closeChannels([...enrichments, destinationChannel]);
     console.debug(`All channels of '${syncId}' has been closed`);
+    await saveRowErrors();
     if (datasource && datasource.close) {
       await datasource.close();
     }
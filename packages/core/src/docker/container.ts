@@ -1,7 +1,16 @@
-import { IncomingMessage, Message, MessageHandler, SingletonMessageHandler } from "@syncmaven/protocol";
+import {
+  Compression,
+  Framing,
+  IncomingMessage,
+  Message,
+  MessageHandler,
+  SingletonMessageHandler,
+} from "@syncmaven/protocol";
 import Docker from "dockerode";
 import readline from "readline";
 import JSON5 from "json5";
+import zlib, { gzipSync } from "zlib";
+import { PassThrough, Readable, Transform } from "stream";
 
 import { spawn, ChildProcessWithoutNullStreams } from "child_process";
 import assert from "assert";
@@ -9,7 +18,11 @@ import assert from "assert";
 export interface StdIoContainer {
   init(): Promise<void>;
 
-  start(messagesHandler?: MessageHandler): Promise<any>;
+  /**
+   * Starts the connector. Messages are sent with the given framing and compression, connector must advertise them
+   * in spec
+   */
+  start(messagesHandler?: MessageHandler, framing?: Framing, compression?: Compression): Promise<any>;
 
   dispatchMessage(incomingMessage: IncomingMessage, messagesHandler?: SingletonMessageHandler): Promise<void>;
 
@@ -21,6 +34,112 @@ export interface StdIoContainer {
   close(): Promise<void>;
 }
 
+/**
+ * Encodes message sent to the connector. Messages are NDJSON lines unless other framing was requested with hello,
+ * see helloLine()
+ */
+export function encodeMessage(message: IncomingMessage, framing: Framing = "ndjson"): Buffer {
+  const json = Buffer.from(JSON.stringify(message));
+  if (framing === "ndjson") {
+    return Buffer.concat([json, Buffer.from("\n")]);
+  }
+  const body = framing === "gzip" ? gzipSync(json) : json;
+  const size = Buffer.alloc(4);
+  size.writeUInt32BE(body.length);
+  return Buffer.concat([size, body]);
+}
+
+/**
+ * The first line sent to the connector if framing other than NDJSON or compression is used
+ */
+function helloLine(framing: Framing, compression: Compression): string {
+  return JSON.stringify({ type: "hello", payload: { framing, compression } }) + "\n";
+}
+
+function needsHello(framing: Framing, compression: Compression) {
+  return framing !== "ndjson" || compression !== "none";
+}
+
+//zstd streams are available since Node 22.15, @types/node doesn't declare them yet
+const zstd = zlib as typeof zlib & { createZstdCompress?: () => Transform; createZstdDecompress?: () => Transform };
+
+/**
+ * Compressed stream of messages sent to the connector after hello line. Call flush() after each message, so
+ * the connector receives it without delay
+ */
+export function createCompressor(compression: Exclude<Compression, "none">): Transform {
+  if (compression === "gzip") {
+    return zlib.createGzip();
+  }
+  if (!zstd.createZstdCompress) {
+    throw new Error(`zstd compression requires Node.js 22.15 or later, running ${process.version}`);
+  }
+  return zstd.createZstdCompress();
+}
+
+function createDecompressor(compression: Exclude<Compression, "none">): Transform {
+  if (compression === "gzip") {
+    return zlib.createGunzip();
+  }
+  if (!zstd.createZstdDecompress) {
+    throw new Error(`zstd compression requires Node.js 22.15 or later, running ${process.version}`);
+  }
+  return zstd.createZstdDecompress();
+}
+
+/**
+ * Decodes stdout of the connector. With compression, lines up to hello reply are plain and the rest is compressed
+ * stream, see HelloMessage
+ */
+export function decodeReplies(input: Readable, compression: Compression): Readable {
+  if (compression === "none") {
+    return input;
+  }
+  const output = new PassThrough();
+  const decompressor = createDecompressor(compression);
+  decompressor.on("error", e => {
+    //connector was killed in the middle of a reply
+    console.warn(`Failed to decompress connector output: ${e?.message}`);
+    output.end();
+  });
+  decompressor.pipe(output);
+  //bytes before the end of hello reply, undefined after it
+  let plain: Buffer | undefined = Buffer.alloc(0);
+  input.on("data", (chunk: Buffer) => {
+    if (!plain) {
+      decompressor.write(chunk);
+      return;
+    }
+    plain = Buffer.concat([plain, chunk]);
+    let start = 0;
+    let end: number;
+    while (plain && (end = plain.indexOf("\n", start)) !== -1) {
+      const line = plain.subarray(start, end + 1);
+      output.write(line);
+      start = end + 1;
+      if (parseLine(line.toString().trim())?.type === "hello") {
+        const rest: Buffer = plain.subarray(start);
+        plain = undefined;
+        if (rest.length > 0) {
+          decompressor.write(rest);
+        }
+      }
+    }
+    if (plain) {
+      plain = plain.subarray(start);
+    }
+  });
+  input.on("end", () => {
+    if (plain) {
+      //connector exited before hello reply
+      output.end(plain);
+    } else {
+      decompressor.end();
+    }
+  });
+  return output;
+}
+
 /**
  * Parses message. If message malfromed, just ignores it
  * @param json
@@ -74,6 +193,9 @@ export class CommandContainer implements StdIoContainer {
   private lineReader?: readline.Interface;
   private cwd: string;
   private envs: Record<string, string>;
+  private framing: Framing = "ndjson";
+  //compressed stream piped to stdin after hello line, if compression is used
+  private compressor?: Transform;
 
   constructor(command: string, cwd: string, envs: Record<string, string> = {}) {
     this.command = command;
@@ -86,10 +208,15 @@ export class CommandContainer implements StdIoContainer {
     return Promise.resolve();
   }
 
-  async start(messagesHandler?: MessageHandler | undefined) {
+  async start(
+    messagesHandler?: MessageHandler | undefined,
+    framing: Framing = "ndjson",
+    compression: Compression = "none"
+  ) {
     if (messagesHandler) {
       this.messageHandler = messagesHandler;
     }
+    this.framing = framing;
     console.debug(
       `Starting command container with command: ${this.command} in ${this.cwd}\n\t(cd ${this.cwd} && ${this.command})`
     );
@@ -102,7 +229,15 @@ export class CommandContainer implements StdIoContainer {
     }) as ChildProcessWithoutNullStreams;
     assert(this.proc.stdout, "spawned process stdout is not defined");
     assert(this.proc.stdin, "spawned process stdout is not defined");
-    this.lineReader = readline.createInterface({ input: this.proc.stdout });
+    if (needsHello(this.framing, compression)) {
+      this.proc.stdin.write(helloLine(this.framing, compression));
+    }
+    this.compressor = undefined;
+    if (compression !== "none") {
+      this.compressor = createCompressor(compression);
+      this.compressor.pipe(this.proc.stdin);
+    }
+    this.lineReader = readline.createInterface({ input: decodeReplies(this.proc.stdout, compression) });
     this.lineReader.on("line", async data => {
       if (data.trim() !== "") {
         const message = parseRawMessage(parseLine(data.trim()));
@@ -141,7 +276,12 @@ export class CommandContainer implements StdIoContainer {
       throw new Error(`Illegal state: process is not running`);
     }
     console.debug(`Sending message to child process: ${JSON.stringify(incomingMessage)}`);
-    this.proc.stdin.write(JSON.stringify(incomingMessage) + "\n");
+    if (this.compressor) {
+      this.compressor.write(encodeMessage(incomingMessage, this.framing));
+      this.compressor.flush();
+    } else {
+      this.proc.stdin.write(encodeMessage(incomingMessage, this.framing));
+    }
     return Promise.resolve();
   }
 
@@ -164,8 +304,12 @@ export class DockerContainer implements StdIoContainer {
   private container: any;
   private containerStream?: any;
   private lineReader?: readline.Interface;
+  private errReader?: readline.Interface;
   private messageHandler: MessageHandler | undefined = undefined;
   private oneTimeMessageHandler: SingletonMessageHandler | undefined = undefined;
+  private framing: Framing = "ndjson";
+  //compressed stream piped to container stdin after hello line, if compression is used
+  private compressor?: Transform;
 
   constructor(image: string, envs: string[]) {
     this.image = image;
@@ -205,7 +349,7 @@ export class DockerContainer implements StdIoContainer {
     console.log(`Container created. Id: ${this.container.id}`);
   }
 
-  async start(messagesHandler?: MessageHandler) {
+  async start(messagesHandler?: MessageHandler, framing: Framing = "ndjson", compression: Compression = "none") {
     if (messagesHandler) {
       this.messageHandler = messagesHandler;
     }
@@ -234,7 +378,7 @@ export class DockerContainer implements StdIoContainer {
       } catch (e: any) {
         console.error(`Error occurred while handling message`, e);
       }
-    });
+    }, { framing, compression });
   }
 
   async dispatchMessage(incomingMessage: IncomingMessage, messagesHandler?: SingletonMessageHandler) {
@@ -247,7 +391,12 @@ export class DockerContainer implements StdIoContainer {
     console.debug(
       `Sending message to container ${this.container.id} of ${this.image}: ${JSON.stringify(incomingMessage)}`
     );
-    await this.containerStream.write(JSON.stringify(incomingMessage) + "\n");
+    if (this.compressor) {
+      this.compressor.write(encodeMessage(incomingMessage, this.framing));
+      this.compressor.flush();
+    } else {
+      await this.containerStream.write(encodeMessage(incomingMessage, this.framing));
+    }
   }
 
   async isContainerRunning() {
@@ -264,7 +413,10 @@ export class DockerContainer implements StdIoContainer {
     }
   }
 
-  async startContainer(stdoutHandler: (line: string) => Promise<void> | void) {
+  async startContainer(
+    stdoutHandler: (line: string) => Promise<void> | void,
+    { framing = "ndjson", compression = "none" }: { framing?: Framing; compression?: Compression } = {}
+  ) {
     if (!this.container) {
       //lazy init container on a first message
       await this.init();
@@ -273,6 +425,7 @@ export class DockerContainer implements StdIoContainer {
       console.info(`Container ${this.container.id} of ${this.image} is already running.`);
       return;
     }
+    this.framing = framing;
     if (this.containerStream) {
       console.warn(`Illegal state: container stream is set, but container is not running. Cleaning up...`);
     }
@@ -299,13 +452,32 @@ export class DockerContainer implements StdIoContainer {
       stderr: true,
       hijack: true,
     });
-    this.lineReader = readline.createInterface({ input: this.containerStream });
-    this.lineReader.on("line", async data => {
-      //console.debug(`Got '${data}' from container ${this.container.id} of ${this.image}`);
-      if (data.trim() !== "") {
-        await stdoutHandler(data);
-      }
+    if (needsHello(this.framing, compression)) {
+      await this.containerStream.write(helloLine(this.framing, compression));
+    }
+    this.compressor = undefined;
+    if (compression !== "none") {
+      this.compressor = createCompressor(compression);
+      this.compressor.pipe(this.containerStream);
+    }
+    //stdout and stderr are multiplexed in one stream, stdout must be separated to be decompressed
+    const stdout = new PassThrough();
+    const stderr = new PassThrough();
+    this.docker.modem.demuxStream(this.containerStream, stdout, stderr);
+    this.containerStream.on("end", () => {
+      stdout.end();
+      stderr.end();
     });
+    this.lineReader = readline.createInterface({ input: decodeReplies(stdout, compression) });
+    this.errReader = readline.createInterface({ input: stderr });
+    for (const reader of [this.lineReader, this.errReader]) {
+      reader.on("line", async data => {
+        //console.debug(`Got '${data}' from container ${this.container.id} of ${this.image}`);
+        if (data.trim() !== "") {
+          await stdoutHandler(data);
+        }
+      });
+    }
 
     console.log(`Starting container ${this.container.id} of ${this.image}...`);
     await this.container.start();
@@ -324,9 +496,11 @@ export class DockerContainer implements StdIoContainer {
       console.debug(`Container ${this.container?.id} of ${this.image} is already stopped`);
     }
     await runCleanup(() => this.lineReader?.close());
+    await runCleanup(() => this.errReader?.close());
     await runCleanup(() => this.containerStream?.close());
     this.containerStream = undefined;
     this.lineReader = undefined;
+    this.errReader = undefined;
   }
 
   /**
@@ -1,8 +1,11 @@
 import {
+  Compression,
   ConnectionSpecMessage,
+  CredentialsUpdatedMessage,
   DescribeStreamsMessage,
   DestinationChannel,
   ExecutionContext,
+  Framing,
   HaltMessage,
   MessageHandler,
   RowMessage,
@@ -14,6 +17,8 @@ import type { Response } from "express";
 import express from "express";
 
 import http from "http";
+import path from "path";
+import { createHmac, randomBytes, timingSafeEqual } from "crypto";
 import { CommandContainer, DockerContainer, StdIoContainer } from "./container";
 
 export type RpcHandler = (
@@ -28,6 +33,80 @@ export type RpcHandler = (
 
 type RpcServer = { port: number; close: () => Promise<void> | void };
 
+//signed requests older than that are rejected
+const signatureToleranceMs = 5 * 60 * 1000;
+//RPC methods that change state. Retried deliveries of them are applied once
+const stateChanges = ["/state.set", "/state.del", "/state.deleteByPrefix"];
+
+/**
+ * Checks X-Syncmaven-Timestamp and X-Syncmaven-Signature headers of the request against the raw body.
+ * Returns error message, or undefined if the signature is valid
+ */
+export function verifySignature(
+  headers: Record<string, string | string[] | undefined>,
+  rawBody: Buffer,
+  secret: string
+): string | undefined {
+  const timestamp = headers["x-syncmaven-timestamp"];
+  const signature = headers["x-syncmaven-signature"];
+  if (typeof timestamp !== "string" || typeof signature !== "string") {
+    return "Request is not signed";
+  }
+  const ts = parseInt(timestamp);
+  if (isNaN(ts) || Math.abs(Date.now() - ts * 1000) > signatureToleranceMs) {
+    return "Request timestamp is outside of tolerance";
+  }
+  const expected = Buffer.from(
+    "sha256=" + createHmac("sha256", secret).update(`${timestamp}.`).update(rawBody).digest("hex")
+  );
+  const actual = Buffer.from(signature);
+  if (actual.length !== expected.length || !timingSafeEqual(actual, expected)) {
+    return "Invalid signature";
+  }
+}
+
+/**
+ * Framing of messages sent to connectors, set with SYNCMAVEN_PROTOCOL_FRAMING env var. It's used only with connectors
+ * that advertise it in supportedFramings of spec, others get NDJSON
+ */
+function protocolFraming(): Framing {
+  const value = process.env.SYNCMAVEN_PROTOCOL_FRAMING || "ndjson";
+  const framing = Framing.safeParse(value);
+  if (!framing.success) {
+    throw new Error(`Invalid SYNCMAVEN_PROTOCOL_FRAMING: ${value}. Supported: ${Framing.options.join(", ")}`);
+  }
+  return framing.data;
+}
+
+/**
+ * Compression of protocol streams, set with SYNCMAVEN_PROTOCOL_COMPRESSION env var. Reduces IPC volume when host and
+ * connector run on different machines. It's used only with connectors that advertise it in supportedCompressions
+ * of spec
+ */
+function protocolCompression(): Compression {
+  const value = process.env.SYNCMAVEN_PROTOCOL_COMPRESSION || "none";
+  const compression = Compression.safeParse(value);
+  if (!compression.success) {
+    throw new Error(`Invalid SYNCMAVEN_PROTOCOL_COMPRESSION: ${value}. Supported: ${Compression.options.join(", ")}`);
+  }
+  return compression.data;
+}
+
+/**
+ * Resource limits connectors impose on themselves, so a misbehaving connector degrades predictably in shared runners.
+ * Set with SYNCMAVEN_CONNECTOR_MAX_MEMORY_MB and SYNCMAVEN_CONNECTOR_MAX_CONNECTIONS env vars
+ */
+function connectorLimits(): Record<string, string> {
+  return {
+    ...(process.env.SYNCMAVEN_CONNECTOR_MAX_MEMORY_MB && {
+      MAX_MEMORY_MB: process.env.SYNCMAVEN_CONNECTOR_MAX_MEMORY_MB,
+    }),
+    ...(process.env.SYNCMAVEN_CONNECTOR_MAX_CONNECTIONS && {
+      MAX_CONNECTIONS: process.env.SYNCMAVEN_CONNECTOR_MAX_CONNECTIONS,
+    }),
+  };
+}
+
 export type ChildProcessDef =
   | { dockerImage: string; command?: never }
   | { command: { exec: string; dir: string }; dockerImage?: never };
@@ -39,6 +118,13 @@ export class StdInOutChannel implements DestinationChannel {
   private ctx?: ExecutionContext;
   private inited: boolean = false;
   private messagesListener?: MessageHandler;
+  //shared with the connector, so it can sign RPC requests
+  private signingSecret: string = randomBytes(32).toString("hex");
+  //idempotency key of the last change applied to each state key, so a retried delivery is not applied twice
+  private lastApplied = new Map<string, string>();
+  //framings and compressions the connector advertised in spec, known after describe()
+  private supportedFramings?: string[];
+  private supportedCompressions?: string[];
 
   constructor(childProcess: ChildProcessDef, messagesListener?: MessageHandler) {
     this.childProcessDef = childProcess;
@@ -49,14 +135,29 @@ export class StdInOutChannel implements DestinationChannel {
     if (!this.inited) {
       this.rpcServer = await this.createRpcServer();
       if (this.childProcessDef.dockerImage) {
-        this.dockerContainer = new DockerContainer(this.childProcessDef.dockerImage, [
-          `RPC_URL=http://host.docker.internal:${this.rpcServer.port}`,
-        ]);
+        this.dockerContainer = new DockerContainer(
+          this.childProcessDef.dockerImage,
+          [
+            `RPC_URL=http://host.docker.internal:${this.rpcServer.port}`,
+            `RPC_SIGNING_SECRET=${this.signingSecret}`,
+            ...Object.entries(connectorLimits()).map(([name, value]) => `${name}=${value}`),
+          ]
+        );
       } else {
         const { exec, dir } = this.childProcessDef.command!;
-        this.dockerContainer = new CommandContainer(exec, dir, {
-          RPC_URL: `http://localhost:${this.rpcServer.port}`,
-        });
+        this.dockerContainer = new CommandContainer(
+          exec,
+          dir,
+          {
+            RPC_URL: `http://localhost:${this.rpcServer.port}`,
+            RPC_SIGNING_SECRET: this.signingSecret,
+            ...connectorLimits(),
+            //only connectors run as local commands capture requests, docker images never get it
+            ...(process.env.SYNCMAVEN_DEBUG_CAPTURE_DIR
+              ? { DEBUG_CAPTURE_DIR: path.resolve(process.env.SYNCMAVEN_DEBUG_CAPTURE_DIR) }
+              : {}),
+          }
+        );
       }
       this.inited = true;
     }
@@ -76,6 +177,8 @@ export class StdInOutChannel implements DestinationChannel {
     this.dockerContainer?.dispatchMessage({ type: "describe" }, async message => {
       switch (message.type) {
         case "spec":
+          this.supportedFramings = (message as ConnectionSpecMessage).payload.supportedFramings || [];
+          this.supportedCompressions = (message as ConnectionSpecMessage).payload.supportedCompressions || [];
           promiseResolve(message as ConnectionSpecMessage);
           return "done";
         case "halt":
@@ -113,10 +216,48 @@ export class StdInOutChannel implements DestinationChannel {
   async startStream(startStreamMessage: StartStreamMessage, ctx: ExecutionContext): Promise<void> {
     await this.init();
     this.ctx = ctx;
-    await this.dockerContainer?.start(this.messagesListener);
+    const framing = await this.negotiateFraming();
+    await this.dockerContainer?.start(this.messagesListener, framing, await this.negotiateCompression());
     await this.dockerContainer?.dispatchMessage(startStreamMessage);
   }
 
+  /**
+   * Returns framing requested with SYNCMAVEN_PROTOCOL_FRAMING if the connector supports it, NDJSON otherwise.
+   * Connectors that don't read frames would take a frame for a broken line
+   */
+  private async negotiateFraming(): Promise<Framing> {
+    const framing = protocolFraming();
+    if (framing === "ndjson") {
+      return framing;
+    }
+    if (!this.supportedFramings) {
+      await this.describe();
+    }
+    if (!this.supportedFramings!.includes(framing)) {
+      console.warn(`Connector doesn't support ${framing} framing, falling back to ndjson`);
+      return "ndjson";
+    }
+    return framing;
+  }
+
+  /**
+   * Returns compression requested with SYNCMAVEN_PROTOCOL_COMPRESSION if the connector supports it, none otherwise
+   */
+  private async negotiateCompression(): Promise<Compression> {
+    const compression = protocolCompression();
+    if (compression === "none") {
+      return compression;
+    }
+    if (!this.supportedCompressions) {
+      await this.describe();
+    }
+    if (!this.supportedCompressions!.includes(compression)) {
+      console.warn(`Connector doesn't support ${compression} compression, falling back to uncompressed streams`);
+      return "none";
+    }
+    return compression;
+  }
+
   async stopStream() {
     let promiseResolve;
     let promiseReject;
@@ -143,6 +284,10 @@ export class StdInOutChannel implements DestinationChannel {
     await this.dockerContainer?.dispatchMessage(rowMessage);
   }
 
+  async updateCredentials(msg: CredentialsUpdatedMessage): Promise<void> {
+    await this.dockerContainer?.dispatchMessage(msg);
+  }
+
   async close(): Promise<void> {
     await this.dockerContainer?.close();
     await this.rpcServer?.close();
@@ -154,6 +299,7 @@ export class StdInOutChannel implements DestinationChannel {
       body: any;
       path: string;
       query: any;
+      idempotencyKey?: string;
     },
     res: Response
   ): Promise<any> {
@@ -162,6 +308,14 @@ export class StdInOutChannel implements DestinationChannel {
     if (!ctx) {
       throw new Error("Context is not set");
     }
+    const stateKey = JSON.stringify(opts.body.key ?? opts.body.prefix);
+    const idempotencyKey = stateChanges.includes(opts.path) ? opts.idempotencyKey : undefined;
+    if (idempotencyKey && this.lastApplied.get(stateKey) === idempotencyKey) {
+      console.log(`RPC path:${opts.path} already applied, idempotency key: ${idempotencyKey}`);
+      return {};
+    }
+    //recorded once the change is applied, so a retry of a failed change is applied again
+    const applied = () => idempotencyKey && this.lastApplied.set(stateKey, idempotencyKey);
     const key = opts.body.key;
     switch (opts.path) {
       case "/state.get":
@@ -169,12 +323,15 @@ export class StdInOutChannel implements DestinationChannel {
         return v || {};
       case "/state.set":
         await ctx.store.set(opts.body.key, opts.body.value);
+        applied();
         return {};
       case "/state.del":
         await ctx.store.del(opts.body.key);
+        applied();
         return {};
       case "/state.deleteByPrefix":
         await ctx.store.deleteByPrefix(opts.body.prefix);
+        applied();
         return {};
       case "/state.size":
         const n = await ctx.store.size(opts.body.prefix);
@@ -195,14 +352,31 @@ export class StdInOutChannel implements DestinationChannel {
     const chan = this;
     return new Promise(resolve => {
       const app = express();
-      app.use(express.json());
+      app.use(
+        express.json({
+          verify: (req, res, buf) => {
+            (req as any).rawBody = buf;
+          },
+        })
+      );
       const server = http.createServer(app);
       app.use((req, res, next) => {
         const body = req.body;
         const path = req.path;
         const query = req.query;
+        //the connector always gets RPC_SIGNING_SECRET, so unsigned requests are rejected as well as invalid ones
+        if (chan.signingSecret) {
+          const error = verifySignature(req.headers, (req as any).rawBody || Buffer.alloc(0), chan.signingSecret);
+          if (error) {
+            const traceId = req.header("X-Syncmaven-Trace-Id");
+            console.error(`RPC path:${path} rejected: ${error}${traceId ? ` traceId: ${traceId}` : ""}`);
+            res.status(401).json({ error });
+            return;
+          }
+        }
+        const idempotencyKey = req.header("Idempotency-Key");
         chan
-          .handleRpcRequest({ body, path, query }, res)
+          .handleRpcRequest({ body, path, query, idempotencyKey }, res)
           .then(result => {
             if (typeof result !== "undefined") {
               res.json(result);
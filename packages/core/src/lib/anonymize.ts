@@ -0,0 +1,65 @@
+import crypto from "crypto";
+import { z } from "zod";
+
+/**
+ * How PII column is replaced. hash is hex HMAC-SHA256 of the value, null drops the value, others are fake values
+ * of the kind. Fake values are picked by the hash, so the same value gets the same replacement in every run
+ */
+export const anonymizeStrategies = ["hash", "email", "name", "first_name", "last_name", "phone", "ip", "null"] as const;
+
+export type AnonymizeStrategy = (typeof anonymizeStrategies)[number];
+
+export const AnonymizeSettings = z.object({
+  salt: z
+    .string()
+    .min(1)
+    .describe("Secret key of hashes. Syncs with the same salt get the same replacements, so they can be joined"),
+  columns: z.record(z.enum(anonymizeStrategies)).describe("Strategy by PII column name"),
+});
+
+export type AnonymizeSettings = z.infer<typeof AnonymizeSettings>;
+
+const firstNames = ["Alex", "Sam", "Jordan", "Taylor", "Morgan", "Casey", "Riley", "Jamie", "Robin", "Avery"];
+const lastNames = ["Smith", "Jones", "Brown", "Miller", "Davis", "Wilson", "Moore", "Clark", "Lewis", "Walker"];
+
+export type Anonymizer = (row: Record<string, any>) => Record<string, any>;
+
+export function createAnonymizer(settings: AnonymizeSettings): Anonymizer {
+  const hash = (value: any) => crypto.createHmac("sha256", settings.salt).update(String(value)).digest();
+  const replace = (strategy: AnonymizeStrategy, value: any) => {
+    if (value === null || value === undefined || strategy === "null") {
+      return null;
+    }
+    const h = hash(value);
+    const first = firstNames[h[0] % firstNames.length];
+    const last = lastNames[h[1] % lastNames.length];
+    switch (strategy) {
+      case "hash":
+        return h.toString("hex");
+      case "first_name":
+        return first;
+      case "last_name":
+        return last;
+      case "name":
+        return `${first} ${last}`;
+      case "email":
+        //example.com is reserved, so emails never reach real people
+        return `${first}.${last}.${h.readUInt32BE(2)}@example.com`.toLowerCase();
+      case "phone":
+        //555 numbers are fictional
+        return `+1555${String(h.readUInt32BE(6) % 10_000_000).padStart(7, "0")}`;
+      case "ip":
+        //TEST-NET-3 range, reserved for documentation
+        return `203.0.113.${h[10]}`;
+    }
+  };
+  return row => {
+    const res = { ...row };
+    for (const [column, strategy] of Object.entries(settings.columns)) {
+      if (column in res) {
+        res[column] = replace(strategy, res[column]);
+      }
+    }
+    return res;
+  };
+}
@@ -1,5 +1,6 @@
 import type { Simplify } from "type-fest";
 import { z } from "zod";
+import { AnonymizeSettings } from "../lib/anonymize";
 
 export const ModelDefinition = z.object({
   id: z.string().optional(),
@@ -38,6 +39,13 @@ export const SyncDefinition = z.object({
   enrichment: EnrichmentSettings.optional(),
   enrichments: z.array(EnrichmentSettings).optional(),
   checkpointEvery: z.number().describe("End stream and continue with a new one every N rows.").optional(),
+  anonymize: AnonymizeSettings.describe(
+    "Replaces PII columns before rows are sent, so production data can drive syncs into sandbox destinations"
+  ).optional(),
+  featureFlags: z
+    .record(z.union([z.boolean(), z.string(), z.number()]))
+    .describe("Experimental connector behaviors enabled for the sync. Sent to the destination in start-stream")
+    .optional(),
   options: z.any(),
 });
 
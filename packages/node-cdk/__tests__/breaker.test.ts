@@ -0,0 +1,51 @@
+import { test } from "node:test";
+import assert from "assert";
+import { CircuitBreaker, CircuitBreakers, CircuitOpenError } from "../src/breaker";
+import { streamResultPayload } from "../src/std";
+
+const fail = async () => {
+  throw new Error("502 Bad Gateway");
+};
+
+const sleep = (ms: number) => new Promise(resolve => setTimeout(resolve, ms));
+
+test("circuit breaker opens after consecutive failures and closes after a successful trial call", async () => {
+  const breaker = new CircuitBreaker("associations", { threshold: 2, cooldownMs: 50 });
+  await assert.rejects(breaker.call(fail), /502/, "failures are thrown, so the row fails");
+  assert.equal(await breaker.call(async () => "ok"), "ok");
+  assert.equal(breaker.state, "closed", "a success resets consecutive failures");
+
+  await assert.rejects(breaker.call(fail), /502/);
+  await assert.rejects(breaker.call(fail), /502/);
+  assert.equal(breaker.state, "open");
+  let called = false;
+  await assert.rejects(
+    breaker.call(async () => (called = true)),
+    CircuitOpenError
+  );
+  assert.equal(called, false, "endpoint isn't called while the circuit is open");
+
+  await sleep(60);
+  assert.equal(breaker.state, "half-open", "a trial call is allowed after the cooldown");
+  await assert.rejects(breaker.call(fail), /502/);
+  assert.equal(breaker.state, "open", "a failed trial call opens the circuit for another cooldown");
+
+  await sleep(60);
+  assert.equal(await breaker.call(async () => "ok"), "ok");
+  assert.equal(breaker.state, "closed");
+  assert.deepEqual(breaker.stats(), { state: "closed", calls: 6, failed: 4, skipped: 1 });
+});
+
+test("degraded endpoints are reported in stream-result", async () => {
+  const breakers = new CircuitBreakers({ threshold: 1 });
+  await breakers.get("companies.search").call(async () => "1");
+  await assert.rejects(breakers.get("associations").call(fail));
+  await assert.rejects(breakers.get("associations").call(fail), CircuitOpenError);
+
+  const counts = { received: 2, skipped: 0, success: 1, failed: 1 };
+  assert.deepEqual(streamResultPayload(counts, { handleRow: () => {}, breakers }), {
+    ...counts,
+    degraded: { associations: { state: "open", calls: 1, failed: 1, skipped: 1 } },
+  });
+  assert.deepEqual(streamResultPayload(counts, { handleRow: () => {} }), counts);
+});
@@ -0,0 +1,52 @@
+import { test } from "node:test";
+import assert from "assert";
+import fs from "fs";
+import os from "os";
+import path from "path";
+import { mergeStartStreamFile } from "../src/std";
+
+function withStartStreamFile(content: string, fn: () => void) {
+  const dir = fs.mkdtempSync(path.join(os.tmpdir(), "start-stream-"));
+  const file = path.join(dir, "stream.yaml");
+  fs.writeFileSync(file, content);
+  process.env.START_STREAM_FILE = file;
+  try {
+    fn();
+  } finally {
+    delete process.env.START_STREAM_FILE;
+    fs.rmSync(dir, { recursive: true, force: true });
+  }
+}
+
+test("start-stream payload is merged with START_STREAM_FILE, host values win", () => {
+  const file = ["streamOptions:", "  startDate: 2024-01-01", "  batchSize: 100", "connectionCredentials:", "  token: file"];
+  withStartStreamFile(file.join("\n") + "\n", () => {
+    const payload = mergeStartStreamFile({
+      streamOptions: { batchSize: 10 },
+      connectionCredentials: { token: "host" },
+      syncId: "sync-1",
+    });
+    assert.deepEqual(payload, {
+      streamOptions: { startDate: "2024-01-01", batchSize: 10 },
+      connectionCredentials: { token: "host" },
+      syncId: "sync-1",
+    });
+  });
+});
+
+test("start-stream payload is unchanged without START_STREAM_FILE or with an empty file", () => {
+  const payload = { streamOptions: { batchSize: 10 } };
+  assert.equal(mergeStartStreamFile(payload), payload);
+  withStartStreamFile("", () => {
+    assert.equal(mergeStartStreamFile(payload), payload);
+  });
+});
+
+test("START_STREAM_FILE must contain an object", () => {
+  withStartStreamFile("- a\n- b\n", () => {
+    assert.throws(() => mergeStartStreamFile({}), /must contain an object, got array/);
+  });
+  withStartStreamFile("stream: [\n", () => {
+    assert.throws(() => mergeStartStreamFile({}), /Cannot read START_STREAM_FILE/);
+  });
+});
@@ -0,0 +1,128 @@
+export type CircuitState = "closed" | "open" | "half-open";
+
+export type CircuitBreakerOptions = {
+  //consecutive failures that open the circuit
+  threshold?: number;
+  //how long calls are skipped once the circuit is open. After that a single trial call decides whether it's closed again
+  cooldownMs?: number;
+};
+
+export type CircuitBreakerStats = {
+  state: CircuitState;
+  calls: number;
+  failed: number;
+  //calls skipped while the circuit was open
+  skipped: number;
+};
+
+/**
+ * Thrown by CircuitBreaker.call while the circuit is open. Callers catch it to proceed without the optional feature
+ */
+export class CircuitOpenError extends Error {
+  readonly endpoint: string;
+
+  constructor(endpoint: string) {
+    super(`${endpoint} is skipped, circuit is open`);
+    this.endpoint = endpoint;
+  }
+}
+
+/**
+ * Circuit breaker of an endpoint that backs an optional feature, e.g. associations of HubSpot contacts. Failures are
+ * counted, warned and thrown as usual, so the row fails. After `threshold` consecutive failures the endpoint isn't
+ * called for `cooldownMs`: calls throw CircuitOpenError and are counted as skipped, so the feature is degraded
+ * without failing every row of the sync
+ */
+export class CircuitBreaker {
+  readonly endpoint: string;
+  private readonly threshold: number;
+  private readonly cooldownMs: number;
+  private consecutiveFailures = 0;
+  private openedAt: number | undefined = undefined;
+  private calls = 0;
+  private failed = 0;
+  private skipped = 0;
+
+  constructor(endpoint: string, opts: CircuitBreakerOptions = {}) {
+    this.endpoint = endpoint;
+    this.threshold = opts.threshold ?? 5;
+    this.cooldownMs = opts.cooldownMs ?? 60_000;
+  }
+
+  get state(): CircuitState {
+    if (this.openedAt === undefined) {
+      return "closed";
+    }
+    return Date.now() - this.openedAt >= this.cooldownMs ? "half-open" : "open";
+  }
+
+  /**
+   * Calls fn unless the circuit is open. Throws CircuitOpenError if the call is skipped, errors of fn are rethrown
+   */
+  async call<T>(fn: () => Promise<T>): Promise<T> {
+    const state = this.state;
+    if (state === "open") {
+      this.skipped++;
+      throw new CircuitOpenError(this.endpoint);
+    }
+    this.calls++;
+    try {
+      const res = await fn();
+      if (state === "half-open") {
+        console.info(`${this.endpoint} recovered, circuit is closed`);
+      }
+      this.consecutiveFailures = 0;
+      this.openedAt = undefined;
+      return res;
+    } catch (e: any) {
+      this.failed++;
+      this.consecutiveFailures++;
+      console.warn(`${this.endpoint} call failed: ${e?.message || e}`);
+      if (state === "half-open" || this.consecutiveFailures >= this.threshold) {
+        this.openedAt = Date.now();
+        console.warn(
+          `${this.endpoint} failed ${this.consecutiveFailures} times in a row, calls are skipped for ${this.cooldownMs / 1000}s`
+        );
+      }
+      throw e;
+    }
+  }
+
+  stats(): CircuitBreakerStats {
+    return { state: this.state, calls: this.calls, failed: this.failed, skipped: this.skipped };
+  }
+}
+
+/**
+ * Circuit breakers of endpoints called by a stream. Output streams expose them as `breakers`, so degraded
+ * endpoints are reported in stream-result
+ */
+export class CircuitBreakers {
+  private readonly opts: CircuitBreakerOptions;
+  private readonly breakers: Record<string, CircuitBreaker> = {};
+
+  constructor(opts: CircuitBreakerOptions = {}) {
+    this.opts = opts;
+  }
+
+  get(endpoint: string): CircuitBreaker {
+    if (!this.breakers[endpoint]) {
+      this.breakers[endpoint] = new CircuitBreaker(endpoint, this.opts);
+    }
+    return this.breakers[endpoint];
+  }
+
+  /**
+   * Stats of endpoints that failed at least once
+   */
+  degraded(): Record<string, CircuitBreakerStats> {
+    const res: Record<string, CircuitBreakerStats> = {};
+    for (const [endpoint, breaker] of Object.entries(this.breakers)) {
+      const stats = breaker.stats();
+      if (stats.failed > 0) {
+        res[endpoint] = stats;
+      }
+    }
+    return res;
+  }
+}
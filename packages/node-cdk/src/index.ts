@@ -1,6 +1,7 @@
 import { ZodType } from "zod";
 import type { ExecutionContext } from "@syncmaven/protocol";
 import crypto from "crypto";
+import type { CircuitBreakers } from "./breaker";
 
 type AnyRow = Record<string, any>;
 type AnyCredentials = any;
@@ -25,6 +26,8 @@ export type OutputStreamConfiguration<T extends AnyCredentials = AnyCredentials,
 export type OutputStream<RowType extends AnyRow = AnyRow> = {
   handleRow: (row: RowType, ctx: ExecutionContext) => Promise<void> | void;
   finish?: (ctx: ExecutionContext) => Promise<void>;
+  //circuit breakers of optional endpoints. Degraded endpoints are reported in stream-result
+  breakers?: CircuitBreakers;
 };
 
 export type DestinationProvider<T extends AnyCredentials = AnyCredentials> = {
@@ -184,6 +187,7 @@ export function normalizeEmail(email: string): string {
 }
 
 export * from "./rpc";
+export * from "./breaker";
 export * from "./std";
 export * from "./inmem-store";
 export * from "./test-kit";
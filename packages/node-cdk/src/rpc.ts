@@ -1,3 +1,24 @@
+import { createHash, createHmac } from "crypto";
+
+/**
+ * Headers that let the receiver verify authenticity of the request and dedupe retried deliveries.
+ * Signature is HMAC-SHA256 of `<timestamp>.<body>`, idempotency key is derived from the batch id,
+ * so retries of the same batch share it. Same scheme as connector-sdk SignRequest
+ */
+export function signatureHeaders(body: string, secret?: string, batchId?: string): Record<string, string> {
+  const headers: Record<string, string> = {};
+  if (secret) {
+    const timestamp = Math.floor(Date.now() / 1000).toString();
+    headers["X-Syncmaven-Timestamp"] = timestamp;
+    headers["X-Syncmaven-Signature"] =
+      "sha256=" + createHmac("sha256", secret).update(`${timestamp}.`).update(body).digest("hex");
+  }
+  if (batchId) {
+    headers["Idempotency-Key"] = createHash("sha256").update(batchId).digest("hex").substring(0, 32);
+  }
+  return headers;
+}
+
 export function tryJson(res: any) {
   if (typeof res === "string") {
     try {
@@ -1,7 +1,10 @@
+import { randomUUID } from "crypto";
+import fs from "fs";
 import readline from "readline";
-import { DestinationProvider, OutputStream, rpc } from "./index";
+import { CORE_SCHEMA, load } from "js-yaml";
+import { DestinationProvider, DestinationStream, OutputStream, rpc, signatureHeaders } from "./index";
 import { zodToJsonSchema } from "zod-to-json-schema";
-import { Entry, ExecutionContext, StartStreamMessage, StorageKey } from "@syncmaven/protocol";
+import { Entry, ExecutionContext, StartStreamMessage, StorageKey, StreamResult } from "@syncmaven/protocol";
 
 let readLine: readline.Interface;
 let stdProtocolEnabled = true;
@@ -28,6 +31,87 @@ function fatal(reason: string) {
   process.exit(1);
 }
 
+//number of rows reported in dry-run reply
+const dryRunSampleSize = 10;
+
+type DryRunStream = OutputStream & { report: () => { payloads: any[]; payloadCount: number; requests: number } };
+
+export type StreamCounts = { received: number; skipped: number; success: number; failed: number };
+
+/**
+ * Payload of stream-result reply. Endpoints degraded by circuit breakers of the stream are reported under `degraded`
+ */
+export function streamResultPayload(counts: StreamCounts, stream?: OutputStream, dryRun?: boolean): StreamResult {
+  const degraded = stream?.breakers?.degraded() || {};
+  return {
+    ...(dryRun && { dryRun: true }),
+    ...counts,
+    ...(Object.keys(degraded).length > 0 && { degraded }),
+  };
+}
+
+/**
+ * Output stream of dry run. Streams call destination APIs right from handleRow, so in dry run the stream isn't
+ * created at all: rows are only validated against its row type, and valid rows are reported in dry-run reply.
+ * Requests are estimated as one per row
+ */
+function createDryRunStream(stream: DestinationStream): DryRunStream {
+  const payloads: any[] = [];
+  let payloadCount = 0;
+  return {
+    handleRow: row => {
+      const parsed = stream.rowType.safeParse(row);
+      if (!parsed.success) {
+        throw new Error(`Invalid row: ${parsed.error.message}`);
+      }
+      payloadCount++;
+      if (payloads.length < dryRunSampleSize) {
+        payloads.push(parsed.data);
+      }
+    },
+    report: () => ({ payloads, payloadCount, requests: payloadCount }),
+  };
+}
+
+function isPlainObject(value: any): value is Record<string, any> {
+  return typeof value === "object" && value !== null && !Array.isArray(value);
+}
+
+function deepMerge(base: Record<string, any>, override: Record<string, any>): Record<string, any> {
+  const res = { ...base };
+  for (const [key, value] of Object.entries(override)) {
+    res[key] = isPlainObject(value) && isPlainObject(res[key]) ? deepMerge(res[key], value) : value;
+  }
+  return res;
+}
+
+/**
+ * Container deployments (e.g. Kubernetes) may mount stream config as a file instead of passing it through
+ * the protocol. START_STREAM_FILE points to a YAML or JSON document that is merged into start-stream payload.
+ * Values sent by the host take precedence over the file. Dates are kept as written, same as in connector-sdk
+ */
+export function mergeStartStreamFile(payload: any): StartStreamMessage["payload"] {
+  const path = process.env.START_STREAM_FILE;
+  if (!path) {
+    return payload;
+  }
+  let doc: any;
+  try {
+    doc = load(fs.readFileSync(path, "utf-8"), { schema: CORE_SCHEMA });
+  } catch (e: any) {
+    throw new Error(`Cannot read START_STREAM_FILE ${path}: ${e?.message || e}`);
+  }
+  if (doc === undefined || doc === null) {
+    return payload;
+  }
+  if (!isPlainObject(doc)) {
+    const kind = Array.isArray(doc) ? "array" : typeof doc;
+    throw new Error(`START_STREAM_FILE ${path} must contain an object, got ${kind}`);
+  }
+  log("info", `Merging start-stream payload with ${path}`);
+  return deepMerge(doc, payload || {}) as StartStreamMessage["payload"];
+}
+
 export async function stdProtocol(provider: DestinationProvider) {
   if (!stdProtocolEnabled) {
     return;
@@ -40,6 +124,7 @@ export async function stdProtocol(provider: DestinationProvider) {
   let failed = 0;
   let success = 0;
   let currentOutputStream: OutputStream | undefined = undefined;
+  let dryRun: DryRunStream | undefined = undefined;
   let ctx: ExecutionContext | undefined = undefined;
 
   const oldConsole = console;
@@ -99,25 +184,30 @@ export async function stdProtocol(provider: DestinationProvider) {
         });
       } else if (message.type === "start-stream") {
         try {
-          const streamName = message.payload.stream;
+          const payload = mergeStartStreamFile(message.payload);
+          const streamName = payload.stream;
           const stream = provider.streams.find(s => s.name === streamName);
           if (!stream) {
             fatal(`Unknown stream ${streamName}`);
           } else {
-            const payload = (message as StartStreamMessage).payload;
             if (!ctx) {
               ctx = createContext();
             }
-            currentOutputStream = await stream.createOutputStream(
-              {
-                streamId: payload.stream,
-                credentials: payload.connectionCredentials,
-                syncId: payload.syncId,
-                fullRefresh: payload.fullRefresh,
-                options: payload.streamOptions,
-              },
-              ctx
-            );
+            if (payload.dryRun) {
+              log("warn", "Dry run: rows are validated, nothing is sent to the destination");
+              currentOutputStream = dryRun = createDryRunStream(stream);
+            } else {
+              currentOutputStream = await stream.createOutputStream(
+                {
+                  streamId: payload.stream,
+                  credentials: payload.connectionCredentials,
+                  syncId: payload.syncId,
+                  fullRefresh: payload.fullRefresh,
+                  options: payload.streamOptions,
+                },
+                ctx
+              );
+            }
           }
         } catch (e: any) {
           fatal(`Failed to start stream: ${e.toString()}`);
@@ -128,8 +218,15 @@ export async function stdProtocol(provider: DestinationProvider) {
           if (currentOutputStream.finish) {
             await currentOutputStream.finish(ctx!);
           }
+          const result = streamResultPayload({ received, skipped, success, failed }, currentOutputStream, !!dryRun);
+          for (const [endpoint, stats] of Object.entries(result.degraded || {})) {
+            log("warn", `${endpoint} was degraded: ${stats.failed} calls failed, ${stats.skipped} skipped`);
+          }
+          if (dryRun) {
+            reply("dry-run", dryRun.report());
+          }
           setTimeout(() => {
-            reply("stream-result", { received, skipped, success, failed });
+            reply("stream-result", result);
             process.exit(0);
           }, 1000);
         } else {
@@ -222,6 +319,8 @@ function createContext(): ExecutionContext {
 }
 
 async function rpcCall(method: string, body: any): Promise<any> {
+  //rpc() serializes body the same way, so the signature matches the bytes sent
+  const payload = JSON.stringify(body);
   return rpc(`${process.env.RPC_URL}/${method}`, {
     method: "POST",
     headers: {
@@ -229,6 +328,7 @@ async function rpcCall(method: string, body: any): Promise<any> {
       ...(process.env.RPC_TOKEN && {
         Authorization: "Bearer " + process.env.RPC_TOKEN,
       }),
+      ...signatureHeaders(payload, process.env.RPC_SIGNING_SECRET, randomUUID()),
     },
     body: body,
   });
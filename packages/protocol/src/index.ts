@@ -49,13 +49,47 @@ export const DescribeConnectionMessage = MessageBase.merge(
 
 export type DescribeConnectionMessage = z.infer<typeof DescribeConnectionMessage>;
 
+/**
+ * Hints for host scheduler, so it avoids syncing days that are not finalized yet and will require restatement
+ */
+export const SchedulingHints = z.object({
+  preferredFrequency: z.enum(["hourly", "daily", "weekly"]).optional(),
+  //time of day (HH:MM, UTC) after which data of the previous day is finalized
+  dataAvailableAfter: z.string().optional(),
+  //number of recent days that may still change and are re-sent by subsequent runs
+  finalizationLagDays: z.number().optional(),
+  description: z.string().optional(),
+});
+
+export type SchedulingHints = z.infer<typeof SchedulingHints>;
+
+/**
+ * Function of connector expressions, e.g. routing rules. Hosts use them for autocomplete and docs
+ */
+export const ExpressionFunction = z.object({
+  name: z.string(),
+  //e.g. parse_url(url, part[, key])
+  signature: z.string(),
+  description: z.string(),
+  example: z.string().optional(),
+});
+
+export type ExpressionFunction = z.infer<typeof ExpressionFunction>;
+
 export const ConnectionSpecMessage = MessageBase.merge(
   z.object({
     type: z.literal("spec"),
     direction: z.literal("reply").default("reply").optional(),
     payload: z.object({
-      roles: z.array(z.enum(["enrichment", "destination"])),
+      roles: z.array(z.enum(["enrichment", "destination", "source"])),
       connectionCredentials: z.any(),
+      scheduling: SchedulingHints.optional(),
+      functions: z.array(ExpressionFunction).optional(),
+      //framings of incoming messages the connector reads, see HelloMessage. Connectors that don't advertise them
+      //get NDJSON
+      supportedFramings: z.array(z.string()).optional(),
+      //compressions of protocol streams the connector supports, see HelloMessage
+      supportedCompressions: z.array(z.string()).optional(),
     }),
   })
 );
@@ -80,7 +114,7 @@ export const StreamSpecMessage = MessageBase.merge(
     direction: z.literal("reply").default("reply").optional(),
     payload: z.object({
       roles: z
-        .array(z.enum(["destination"]))
+        .array(z.enum(["destination", "source"]))
         .optional()
         .default(["destination"]),
       defaultStream: z.string(),
@@ -88,6 +122,8 @@ export const StreamSpecMessage = MessageBase.merge(
         z.object({
           name: z.string(),
           rowType: z.any(),
+          //roles of the stream if it doesn't support all roles of the connector, e.g. a source-only stream
+          roles: z.array(z.enum(["destination", "source"])).optional(),
         })
       ),
     }),
@@ -96,6 +132,16 @@ export const StreamSpecMessage = MessageBase.merge(
 
 export type StreamSpecMessage = z.infer<typeof StreamSpecMessage>;
 
+//inclusive range of dates (YYYY-MM-DD) or RFC 3339 datetimes
+export const RestatementRange = z.object({
+  from: z.string(),
+  to: z.string(),
+  //tenant of the range, if the connector writes to several destinations
+  tenant: z.string().optional(),
+});
+
+export type RestatementRange = z.infer<typeof RestatementRange>;
+
 export const StartStreamMessage = MessageBase.merge(
   z.object({
     type: z.literal("start-stream"),
@@ -105,7 +151,44 @@ export const StartStreamMessage = MessageBase.merge(
       connectionCredentials: z.any(),
       streamOptions: z.any(),
       syncId: z.string(),
+      /**
+       * Identifies the run across systems. Connector adds it to log replies and sends it with X-Syncmaven-Trace-Id
+       * header on RPC calls and destination requests
+       */
+      traceId: z.string().optional(),
       fullRefresh: z.boolean().optional().default(false),
+      /**
+       * Parquet file with all rows of the stream: local path or http(s) URL, e.g. a signed URL of object storage.
+       * If set, the connector reads rows from the file and ends the stream itself, rows and end-stream are not sent
+       */
+      rowsFile: z
+        .object({
+          path: z.string().optional(),
+          url: z.string().optional(),
+          format: z.literal("parquet").optional(),
+        })
+        .optional(),
+      /**
+       * Ranges the connector asked to resend with request-restatement in previous runs. Connector sends them
+       * again, even though its state says they are sent
+       */
+      restate: z.array(RestatementRange).optional(),
+      /**
+       * Days to send (YYYY-MM-DD, inclusive). Override the range connector computes from its state and settings,
+       * e.g. initialSyncDays and lookbackWindow. Days of the range are resent, state is not changed
+       */
+      startDate: z.string().optional(),
+      endDate: z.string().optional(),
+      /**
+       * Experimental behaviors of the connector enabled for this sync, e.g. {"adaptiveConcurrency": true}.
+       * Connectors ignore flags they don't know
+       */
+      featureFlags: z.record(z.union([z.boolean(), z.string(), z.number()])).optional(),
+      /**
+       * Validate, map and batch rows without calling the destination API or writing state. Connectors reply dry-run
+       * with a sample of payloads that would have been sent
+       */
+      dryRun: z.boolean().optional(),
     }),
   })
 );
@@ -118,12 +201,76 @@ export const RowMessage = MessageBase.merge(
     direction: z.literal("incoming").default("incoming").optional(),
     payload: z.object({
       row: z.any(),
+      //monotonically increasing number of the row. Connectors report the highest ordinal delivered together with all
+      //rows before it in checkpoint and stream-result, so the stream can be resumed right after it
+      ordinal: z.number().int().optional(),
     }),
   })
 );
 
 export type RowMessage = z.infer<typeof RowMessage>;
 
+/**
+ * Batched alternative to `row` message. Saves envelope overhead for high-volume syncs
+ */
+export const RowsMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("rows"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z.object({
+      rows: z.array(z.any()),
+      //ordinals of the rows, see RowMessage
+      ordinals: z.array(z.number().int()).optional(),
+    }),
+  })
+);
+
+export type RowsMessage = z.infer<typeof RowsMessage>;
+
+/**
+ * Hands off rows in Arrow IPC format (stream or file) instead of JSON. Host writes record batches to path,
+ * e.g. a temp file on a shared volume or /dev/fd/N of an inherited pipe. With delete the file is owned
+ * by the connector and removed once read. Sent only to connectors with "arrow" capability
+ */
+export const RowsArrowMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("rows-arrow"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z.object({
+      path: z.string(),
+      delete: z.boolean().optional(),
+    }),
+  })
+);
+
+export type RowsArrowMessage = z.infer<typeof RowsArrowMessage>;
+
+/**
+ * Campaign metadata (objective, labels, budget) attached to ad data events by connectors supporting enrichment.
+ * Sent before rows, e.g. with rows of a campaigns stream
+ */
+export const CampaignMetadataMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("campaign-metadata"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z.object({
+      rows: z.array(
+        z
+          .object({
+            campaign_id: z.union([z.string(), z.number()]),
+            source: z.string().optional(),
+            objective: z.string().optional(),
+            labels: z.union([z.string(), z.array(z.string())]).optional(),
+            budget: z.number().optional(),
+          })
+          .passthrough()
+      ),
+    }),
+  })
+);
+
+export type CampaignMetadataMessage = z.infer<typeof CampaignMetadataMessage>;
+
 export const EndStreamMessage = MessageBase.merge(
   z.object({
     type: z.literal("end-stream"),
@@ -134,6 +281,169 @@ export const EndStreamMessage = MessageBase.merge(
 
 export type EndStreamMessage = z.infer<typeof EndStreamMessage>;
 
+/**
+ * Sent by the host to stop the stream before end-stream, e.g. when user cancelled the sync. Connector
+ * flushes or discards rows that are not sent yet according to the policy, saves state and replies with
+ * stream-result with "cancelled" status
+ */
+export const IncomingHaltMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("halt"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z
+      .object({
+        reason: z.string().optional(),
+        policy: z.enum(["flush", "discard"]).optional(),
+      })
+      .optional(),
+  })
+);
+
+export type IncomingHaltMessage = z.infer<typeof IncomingHaltMessage>;
+
+/**
+ * Sent by the host when credentials are rotated during the stream, e.g. an API token is replaced by secrets manager.
+ * Connector switches to the new credentials between batches without restarting the stream
+ */
+export const CredentialsUpdatedMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("credentials-updated"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z.object({
+      connectionCredentials: z.any(),
+    }),
+  })
+);
+
+export type CredentialsUpdatedMessage = z.infer<typeof CredentialsUpdatedMessage>;
+
+/**
+ * Verifies credentials without running a sync. Connector replies with connection-status
+ */
+export const CheckMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("check"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z.object({
+      connectionCredentials: z.any(),
+    }),
+  })
+);
+
+export type CheckMessage = z.infer<typeof CheckMessage>;
+
+export const Framing = z.enum(["ndjson", "length-prefixed", "gzip"]);
+
+export type Framing = z.infer<typeof Framing>;
+
+export const Compression = z.enum(["none", "gzip", "zstd"]);
+
+export type Compression = z.infer<typeof Compression>;
+
+/**
+ * Optional first message, sent as NDJSON line. Switches framing of subsequent incoming messages: with length-prefixed
+ * and gzip framings each message is preceded by its size as 4-byte big-endian unsigned integer, gzip frames are
+ * gzip-compressed JSON. Connector confirms the framing with hello reply. Replies are always NDJSON.
+ *
+ * Compression applies to whole protocol streams in both directions: everything the host sends after hello line
+ * and everything the connector writes after hello reply is a gzip or zstd stream, flushed after each message
+ */
+export const HelloMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("hello"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z.object({
+      framing: Framing.optional(),
+      compression: Compression.optional(),
+    }),
+  })
+);
+
+export type HelloMessage = z.infer<typeof HelloMessage>;
+
+export const HelloReplyMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("hello"),
+    direction: z.literal("reply"),
+    payload: z.object({
+      framing: Framing,
+      supportedFramings: z.array(z.string()).optional(),
+      compression: Compression.optional(),
+      supportedCompressions: z.array(z.string()).optional(),
+    }),
+  })
+);
+
+export type HelloReplyMessage = z.infer<typeof HelloReplyMessage>;
+
+export const ConnectionStatusMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("connection-status"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      status: z.enum(["ok", "failed"]),
+      //human-readable reason of the failure
+      reason: z.string().optional(),
+    }),
+  })
+);
+
+export type ConnectionStatusMessage = z.infer<typeof ConnectionStatusMessage>;
+
+/**
+ * Requests manifests of the last runs of the sync. Connectors write a manifest at the end of each run
+ * and reply with history-result
+ */
+export const HistoryMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("history"),
+    direction: z.literal("incoming").default("incoming").optional(),
+    payload: z.object({
+      syncId: z.string(),
+      limit: z.number().optional(),
+    }),
+  })
+);
+
+export type HistoryMessage = z.infer<typeof HistoryMessage>;
+
+export const RunManifest = z.object({
+  runId: z.string(),
+  syncId: z.string(),
+  status: z.string(),
+  exitCode: z.number(),
+  startedAt: z.string(),
+  finishedAt: z.string(),
+  //span of days covered by the run
+  firstDate: z.string().optional(),
+  lastDate: z.string().optional(),
+  days: z.number(),
+  received: z.number(),
+  success: z.number(),
+  skipped: z.number(),
+  failed: z.number(),
+  imported: z.number().optional(),
+  //hash of connection credentials, tells runs with different configuration apart
+  configHash: z.string(),
+  connector: z.any(),
+});
+
+export type RunManifest = z.infer<typeof RunManifest>;
+
+export const HistoryResultMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("history-result"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      syncId: z.string(),
+      //newest first
+      manifests: z.array(RunManifest),
+    }),
+  })
+);
+
+export type HistoryResultMessage = z.infer<typeof HistoryResultMessage>;
+
 const StatusObject = z.object({
   received: z.number(),
   success: z.number(),
@@ -141,11 +451,60 @@ const StatusObject = z.object({
   failed: z.number(),
 });
 
+/**
+ * Totals of the stream, statuses by key (e.g. by date) and run-level fields. Connectors may add counters of their own,
+ * e.g. duplicates
+ */
+export const StreamResult = StatusObject.partial()
+  .extend({
+    //statuses by key, e.g. by date
+    statuses: z.record(StatusObject.passthrough()).optional(),
+    //set if the run ended before end-stream, or failed if the state of atomic run was rolled back
+    status: z.enum(["cancelled", "terminated", "timeout", "retry_later", "failed"]).optional(),
+    reason: z.string().optional(),
+    //not all rows were sent, the next run sends the rest
+    partial: z.boolean().optional(),
+    //the next run resumes from where this one stopped
+    resumable: z.boolean().optional(),
+    //false if the state of atomic run was rolled back
+    committed: z.boolean().optional(),
+    //days left for the next runs by maxDaysPerRun
+    remainingDays: z.number().optional(),
+    retryAfterSeconds: z.number().optional(),
+    budget: z.object({ events: z.number(), bytes: z.number().optional() }).optional(),
+    rateLimit: z.object({ waitedRequests: z.number(), waitSeconds: z.number() }).optional(),
+    //number of warnings by category, see WarningMessage
+    warnings: z.record(z.number()).optional(),
+    //statuses of tenants by key in multi-tenant mode
+    tenants: z
+      .record(
+        z
+          .object({
+            days: z.record(StatusObject.passthrough()),
+            imported: z.number(),
+            failed: z.number(),
+          })
+          .passthrough()
+      )
+      .optional(),
+    unknownTenantRows: z.number().optional(),
+    //columns not selected by the projection, see LineageMessage
+    droppedColumns: z.array(z.string()).optional(),
+    //optional endpoints that failed, see CircuitBreaker of node-cdk
+    degraded: z
+      .record(z.object({ state: z.string(), calls: z.number(), failed: z.number(), skipped: z.number() }))
+      .optional(),
+    dryRun: z.boolean().optional(),
+  })
+  .passthrough();
+
+export type StreamResult = z.infer<typeof StreamResult>;
+
 export const StreamResultMessage = MessageBase.merge(
   z.object({
     type: z.literal("stream-result"),
     direction: z.literal("reply").default("reply").optional(),
-    payload: z.union([z.record(StatusObject), StatusObject]).optional(),
+    payload: StreamResult.optional(),
   })
 );
 
@@ -159,12 +518,224 @@ export const LogMessage = MessageBase.merge(
       level: z.enum(["debug", "info", "warn", "error"]),
       message: z.string(),
       params: z.array(z.any()).optional(),
+      //trace id of start-stream
+      traceId: z.string().optional(),
     }),
   })
 );
 
 export type LogMessage = z.infer<typeof LogMessage>;
 
+export const WarningMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("warning"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      //non-fatal data quality issue category, e.g. coercion, truncation, unknown_column, clock_skew
+      category: z.string(),
+      message: z.string(),
+      params: z.array(z.any()).optional(),
+      //set on the last warning of the category sent by connector. Further warnings are only counted in stream-result
+      last: z.boolean().optional(),
+    }),
+  })
+);
+
+export type WarningMessage = z.infer<typeof WarningMessage>;
+
+/**
+ * A row that wasn't written to destination. Sent for each failed row, so the host can route it to a dead-letter store
+ */
+export const RowErrorMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("row-error"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      //key fields of the row, e.g. date, source and campaign_id
+      key: z.record(z.any()),
+      insertId: z.string().optional(),
+      class: z.enum(["validation", "api", "rate-limit"]),
+      //true if the row may be written by resending it as is
+      retryable: z.boolean(),
+      message: z.string(),
+    }),
+  })
+);
+
+export type RowErrorMessage = z.infer<typeof RowErrorMessage>;
+
+export const PreflightMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("preflight"),
+    direction: z.literal("reply").default("reply").optional(),
+    //estimated size of the run, sent before anything is written to destination
+    payload: z.object({
+      sampledRows: z.number(),
+      //true if all rows of the run were received, so numbers are exact
+      complete: z.boolean(),
+      projectedEvents: z.number(),
+      projectedBytes: z.number().optional(),
+      apiCalls: z.number().optional(),
+      estimatedDurationSeconds: z.number().optional(),
+    }).passthrough(),
+  })
+);
+
+export type PreflightMessage = z.infer<typeof PreflightMessage>;
+
+export const PreviewMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("preview"),
+    direction: z.literal("reply").default("reply").optional(),
+    //first adapted destination payloads of the first run of the sync, sent before anything is written to destination
+    payload: z.object({
+      events: z.array(z.record(z.any())),
+    }).passthrough(),
+  })
+);
+
+export type PreviewMessage = z.infer<typeof PreviewMessage>;
+
+/**
+ * Sent before stream-result of dry run, see StartStreamMessage
+ */
+export const DryRunMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("dry-run"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      //sample of destination payloads that would have been sent, e.g. events
+      payloads: z.array(z.any()),
+      //total number of payloads that would have been sent
+      payloadCount: z.number(),
+      //number of API requests that would have been made
+      requests: z.number(),
+    }),
+  })
+);
+
+export type DryRunMessage = z.infer<typeof DryRunMessage>;
+
+/**
+ * Progress of the stream. Destinations send it each time they commit their state, e.g. after each written batch,
+ * sources send it with the state to resume from after each page. Host keeps the last checkpoint until the run
+ * completes, so it can report where a crashed run stopped
+ */
+export const CheckpointMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("checkpoint"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      stream: z.string(),
+      //rows processed by the stream so far
+      rows: z.number(),
+      //the last committed range of the destination state
+      committed: z.object({ from: z.string(), to: z.string() }).optional(),
+      //incremented each time the connector commits its state
+      stateVersion: z.number().optional(),
+      //tenant of the state, if the connector writes to several destinations
+      tenant: z.string().optional(),
+      //state of the source to resume from
+      state: z.any().optional(),
+      //the highest row ordinal delivered together with all rows before it
+      ordinal: z.number().optional(),
+    }),
+  })
+);
+
+export type CheckpointMessage = z.infer<typeof CheckpointMessage>;
+
+/**
+ * Performance counters sent periodically during the stream and once before stream-result. Counters are totals since
+ * the stream start, rowsPerSecond and latencyMs are computed over intervalSeconds since the previous metric
+ */
+export const MetricMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("metric"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      rows: z.number(),
+      rowsPerSecond: z.number().optional(),
+      bytesSent: z.number().optional(),
+      requests: z.number().optional(),
+      retries: z.number().optional(),
+      //latency of destination API requests
+      latencyMs: z.object({ p50: z.number(), p95: z.number() }).optional(),
+      //batches or rows waiting to be sent
+      queueDepth: z.number().optional(),
+      intervalSeconds: z.number().optional(),
+      elapsedSeconds: z.number().optional(),
+    }),
+  })
+);
+
+export type MetricMessage = z.infer<typeof MetricMessage>;
+
+/**
+ * Column-level lineage of the stream: source columns → destination properties with applied transforms,
+ * and columns that didn't reach the destination. Sent before stream-result
+ */
+export const LineageMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("lineage"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      stream: z.string(),
+      destination: z.string(),
+      //table, event or object the stream is written to
+      dataset: z.string().optional(),
+      columns: z.array(
+        z.object({
+          sources: z.array(z.string()),
+          destination: z.string(),
+          transforms: z.array(z.string()).optional(),
+        })
+      ),
+      dropped: z.array(z.object({ source: z.string(), reason: z.string() })).optional(),
+    }),
+  })
+);
+
+export type LineageMessage = z.infer<typeof LineageMessage>;
+
+/**
+ * Destination rate limited the run for long. Connector committed rows sent so far and ends the run,
+ * so the host can reschedule it instead of the connector sleeping
+ */
+export const RetryLaterMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("retry-later"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      delaySeconds: z.number(),
+      retryAt: z.string().optional(),
+      reason: z.string().optional(),
+      //whether state of sent rows was saved
+      committed: z.boolean().optional(),
+    }),
+  })
+);
+
+export type RetryLaterMessage = z.infer<typeof RetryLaterMessage>;
+
+/**
+ * Destination detected loss of data it had received, e.g. the project was purged or replaced. The host schedules
+ * the ranges for resend by the next run, see restate of start-stream
+ */
+export const RequestRestatementMessage = MessageBase.merge(
+  z.object({
+    type: z.literal("request-restatement"),
+    direction: z.literal("reply").default("reply").optional(),
+    payload: z.object({
+      ranges: z.array(RestatementRange.omit({ tenant: true })),
+      reason: z.string().optional(),
+      tenant: z.string().optional(),
+    }),
+  })
+);
+
+export type RequestRestatementMessage = z.infer<typeof RequestRestatementMessage>;
+
 export const HaltMessage = MessageBase.merge(
   z.object({
     type: z.literal("halt").optional(),
@@ -221,7 +792,15 @@ export const IncomingMessage = z.discriminatedUnion("type", [
   DescribeStreamsMessage,
   StartStreamMessage,
   EndStreamMessage,
+  IncomingHaltMessage,
+  CredentialsUpdatedMessage,
+  HistoryMessage,
+  CheckMessage,
+  HelloMessage,
   RowMessage,
+  RowsMessage,
+  RowsArrowMessage,
+  CampaignMetadataMessage,
   EnrichmentRequest,
   EnrichmentConnect,
 ]);
@@ -232,8 +811,21 @@ export const ReplyMessage = z.discriminatedUnion("type", [
   ConnectionSpecMessage,
   StreamSpecMessage,
   StreamResultMessage,
+  HistoryResultMessage,
+  ConnectionStatusMessage,
   LogMessage,
+  WarningMessage,
+  RowErrorMessage,
+  PreflightMessage,
+  PreviewMessage,
+  DryRunMessage,
+  RetryLaterMessage,
+  RequestRestatementMessage,
+  CheckpointMessage,
+  MetricMessage,
+  LineageMessage,
   HaltMessage,
+  HelloReplyMessage,
   EnrichmentResponse,
 ]);
 
@@ -261,7 +853,15 @@ export const messageProcessingFlow: Record<
   "describe-streams": { mode: "singleton" },
   "start-stream": { mode: "keep-alive" },
   "end-stream": { mode: "close" },
+  halt: { mode: "close" },
+  "credentials-updated": { mode: "singleton" },
+  history: { mode: "singleton" },
+  check: { mode: "singleton" },
+  hello: { mode: "singleton" },
   row: { mode: "singleton" },
+  rows: { mode: "singleton" },
+  "rows-arrow": { mode: "singleton" },
+  "campaign-metadata": { mode: "singleton" },
 
   //not working right now, we should not support it
   "enrichment-request": { mode: "keep-alive", expectReply: true },
@@ -271,7 +871,7 @@ export const messageProcessingFlow: Record<
 /**
  * Messages that are not replies to any particular message, but are system messages
  */
-export const systemMessageTypes: ReplyMessage["type"][] = ["halt", "log"];
+export const systemMessageTypes: ReplyMessage["type"][] = ["halt", "log", "warning", "row-error", "preflight", "preview", "dry-run", "retry-later", "request-restatement", "checkpoint", "metric", "lineage", "hello"];
 
 export type Message = Simplify<z.infer<typeof Message>>;
 
@@ -294,6 +894,8 @@ export interface DestinationChannel extends BaseChannel {
   startStream: (startStreamMessage: StartStreamMessage, ctx: ExecutionContext) => Promise<void>;
   row: (rowMessage: RowMessage) => Promise<void>;
   stopStream: () => Promise<StreamResultMessage>;
+  //passes credentials rotated during the stream to the connector
+  updateCredentials?: (msg: CredentialsUpdatedMessage) => Promise<void>;
 
   //dispatchMessage: (messages: IncomingMessage, channel: ReplyChannel, ctx: ExecutionContext) => Promise<void> | void;
 }
@@ -0,0 +1,371 @@
+package schemas
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Golden copies of all schemas of the repo are kept in testdata/golden. A schema change that can break existing
+// configurations or consumers of rows must bump the "version" keyword of the schema. Update golden copies with:
+//
+//	go test . -update
+var update = flag.Bool("update", false, "update golden copies of schemas")
+
+// packagesDir contains the schemas module and connectors
+const packagesDir = ".."
+
+const goldenDir = "testdata/golden"
+
+var metaSchemas = []string{"http://json-schema.org/draft-07/schema#", "https://json-schema.org/draft/2020-12/schema"}
+
+var jsonTypes = []string{"string", "number", "integer", "boolean", "object", "array", "null"}
+
+// repoSchemas finds schema files of connectors and canonical schemas. Keys are paths relative to packagesDir
+func repoSchemas(t *testing.T) map[string]map[string]any {
+	result := map[string]map[string]any{}
+	err := filepath.WalkDir(packagesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (d.Name() == "node_modules" || d.Name() == "testdata") {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".schema.json") {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var schema map[string]any
+		if err = json.Unmarshal(b, &schema); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		// connectors reply resolved schemas, so changes of canonical schemas are checked for every connector
+		if schema, err = Resolve(schema); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		rel, _ := filepath.Rel(packagesDir, path)
+		result[filepath.ToSlash(rel)] = schema
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSchemasAreValid(t *testing.T) {
+	for path, schema := range repoSchemas(t) {
+		if s, ok := schema["$schema"].(string); !ok || !slices.Contains(metaSchemas, s) {
+			t.Errorf("%s: $schema must be one of %v, got %v", path, metaSchemas, schema["$schema"])
+		}
+		for _, problem := range validateSchema(schema, "") {
+			t.Errorf("%s: %s", path, problem)
+		}
+	}
+}
+
+func TestSchemasCompatibility(t *testing.T) {
+	schemas := repoSchemas(t)
+	for path, schema := range schemas {
+		goldenPath := filepath.Join(goldenDir, filepath.FromSlash(path))
+		current, _ := json.MarshalIndent(schema, "", "  ")
+		current = append(current, '\n')
+		b, err := os.ReadFile(goldenPath)
+		if err == nil {
+			if bytes.Equal(b, current) {
+				continue
+			}
+			var golden map[string]any
+			if err = json.Unmarshal(b, &golden); err != nil {
+				t.Fatal(err)
+			}
+			// golden copies of incompatible schemas are not updated until the version is bumped
+			problems := incompatibilities(golden, schema, "")
+			if len(problems) > 0 && schemaVersion(schema) <= schemaVersion(golden) {
+				t.Errorf("%s: backward incompatible changes require version bump (version %v):\n  %s", path, schemaVersion(schema),
+					strings.Join(problems, "\n  "))
+				continue
+			}
+		}
+		if !*update {
+			t.Errorf("%s: schema differs from golden copy, run go test . -update", path)
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(goldenPath), 0o755); err == nil {
+			err = os.WriteFile(goldenPath, current, 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = filepath.WalkDir(goldenDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(goldenDir, path)
+		if _, ok := schemas[filepath.ToSlash(rel)]; ok {
+			return nil
+		}
+		if *update {
+			_ = os.Remove(path)
+		} else {
+			t.Errorf("%s: schema is removed, run go test . -update", rel)
+		}
+		return nil
+	})
+}
+
+// schemaVersion is the "version" keyword of the schema, 1 if it is not set
+func schemaVersion(schema map[string]any) float64 {
+	if v, ok := schema["version"].(float64); ok {
+		return v
+	}
+	return 1
+}
+
+// validateSchema checks keywords of the schema and its subschemas against the meta-schema
+func validateSchema(schema map[string]any, path string) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf("#%s: ", path)+fmt.Sprintf(format, args...))
+	}
+	if types, ok := schemaTypes(schema); ok {
+		if len(types) == 0 {
+			report("invalid type %v", schema["type"])
+		}
+		for i, typ := range types {
+			if !slices.Contains(jsonTypes, typ) || slices.Index(types, typ) != i {
+				report("invalid type %v", schema["type"])
+			}
+		}
+	}
+	if properties, ok := schema["properties"]; ok {
+		m, ok := properties.(map[string]any)
+		if !ok {
+			report("properties must be an object")
+		}
+		for name, property := range m {
+			problems = append(problems, validateSubschema(property, path+"/properties/"+name)...)
+		}
+	}
+	if required, ok := schema["required"]; ok {
+		names, ok := required.([]any)
+		if !ok {
+			report("required must be an array")
+		}
+		for i, name := range names {
+			if _, ok := name.(string); !ok || slices.Index(names, name) != i {
+				report("required must contain unique strings, got %v", required)
+			}
+		}
+	}
+	if enum, ok := schema["enum"]; ok {
+		if values, ok := enum.([]any); !ok || len(values) == 0 {
+			report("enum must be a non-empty array")
+		}
+	}
+	for _, keyword := range []string{"anyOf", "oneOf", "allOf"} {
+		if v, ok := schema[keyword]; ok {
+			subschemas, ok := v.([]any)
+			if !ok || len(subschemas) == 0 {
+				report("%s must be a non-empty array", keyword)
+			}
+			for i, subschema := range subschemas {
+				problems = append(problems, validateSubschema(subschema, fmt.Sprintf("%s/%s/%d", path, keyword, i))...)
+			}
+		}
+	}
+	for _, keyword := range []string{"items", "additionalProperties", "not"} {
+		if v, ok := schema[keyword]; ok {
+			problems = append(problems, validateSubschema(v, path+"/"+keyword)...)
+		}
+	}
+	for _, keyword := range []string{"minimum", "maximum", "minLength", "maxLength", "minItems", "maxItems"} {
+		if v, ok := schema[keyword]; ok {
+			if _, ok := v.(float64); !ok {
+				report("%s must be a number", keyword)
+			}
+		}
+	}
+	if v, ok := schema["default"]; ok && v != nil {
+		if types, ok := schemaTypes(schema); ok && !slices.Contains(types, valueType(v)) &&
+			!(valueType(v) == "integer" && slices.Contains(types, "number")) {
+			report("default %v doesn't match type %v", v, schema["type"])
+		}
+	}
+	return problems
+}
+
+// validateSubschema validates a schema or boolean schema
+func validateSubschema(v any, path string) []string {
+	switch v := v.(type) {
+	case bool:
+		return nil
+	case map[string]any:
+		return validateSchema(v, path)
+	default:
+		return []string{fmt.Sprintf("#%s: must be a schema", path)}
+	}
+}
+
+// schemaTypes returns types of "type" keyword. Returns false if it is not set
+func schemaTypes(schema map[string]any) ([]string, bool) {
+	switch v := schema["type"].(type) {
+	case nil:
+		return nil, false
+	case string:
+		return []string{v}, true
+	case []any:
+		types := make([]string, 0, len(v))
+		for _, t := range v {
+			s, _ := t.(string)
+			types = append(types, s)
+		}
+		return types, true
+	default:
+		return []string{}, true
+	}
+}
+
+func valueType(v any) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return "null"
+	}
+}
+
+// incompatibilities lists changes of the schema that break existing data: removed properties, removed required
+// properties, new required properties, narrowed types and removed enum values
+func incompatibilities(golden, current map[string]any, path string) []string {
+	var problems []string
+	goldenTypes, goldenTyped := schemaTypes(golden)
+	currentTypes, currentTyped := schemaTypes(current)
+	if currentTyped && !goldenTyped {
+		problems = append(problems, fmt.Sprintf("#%s: type narrowed to %v", path, current["type"]))
+	} else if currentTyped {
+		for _, typ := range goldenTypes {
+			if !slices.Contains(currentTypes, typ) && !(typ == "integer" && slices.Contains(currentTypes, "number")) {
+				problems = append(problems, fmt.Sprintf("#%s: type narrowed from %v to %v", path, golden["type"], current["type"]))
+				break
+			}
+		}
+	}
+	if goldenEnum, ok := golden["enum"].([]any); ok {
+		currentEnum, _ := current["enum"].([]any)
+		for _, value := range goldenEnum {
+			if currentEnum != nil && !slices.Contains(currentEnum, value) {
+				problems = append(problems, fmt.Sprintf("#%s: enum value %v removed", path, value))
+			}
+		}
+	} else if _, ok := current["enum"]; ok {
+		problems = append(problems, fmt.Sprintf("#%s: enum added", path))
+	}
+	goldenRequired, _ := golden["required"].([]any)
+	currentRequired, _ := current["required"].([]any)
+	goldenProperties, _ := golden["properties"].(map[string]any)
+	currentProperties, _ := current["properties"].(map[string]any)
+	for _, name := range goldenRequired {
+		if !slices.Contains(currentRequired, name) {
+			problems = append(problems, fmt.Sprintf("#%s: required property '%v' is no longer required", path, name))
+		}
+	}
+	for _, name := range currentRequired {
+		if !slices.Contains(goldenRequired, name) {
+			problems = append(problems, fmt.Sprintf("#%s: property '%v' is required", path, name))
+		}
+	}
+	names := make([]string, 0, len(goldenProperties))
+	for name := range goldenProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := currentProperties[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("#%s: property '%s' removed", path, name))
+			continue
+		}
+		g, _ := goldenProperties[name].(map[string]any)
+		c, _ := property.(map[string]any)
+		if g != nil && c != nil {
+			problems = append(problems, incompatibilities(g, c, path+"/properties/"+name)...)
+		}
+	}
+	return problems
+}
+
+func TestIncompatibilities(t *testing.T) {
+	golden := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"id":    map[string]any{"type": []any{"string", "integer"}},
+			"count": map[string]any{"type": "integer"},
+			"kind":  map[string]any{"type": "string", "enum": []any{"a", "b"}},
+			"note":  map[string]any{"type": "string"},
+		},
+		"required": []any{"id"},
+	}
+	compatible := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"id":    map[string]any{"type": []any{"string", "integer", "null"}},
+			"count": map[string]any{"type": "number"},
+			"kind":  map[string]any{"type": "string", "enum": []any{"a", "b", "c"}},
+			"note":  map[string]any{"type": "string"},
+			"extra": map[string]any{"type": "string"},
+		},
+		"required": []any{"id"},
+	}
+	if problems := incompatibilities(golden, compatible, ""); len(problems) > 0 {
+		t.Errorf("widening changes must be compatible, got %v", problems)
+	}
+	incompatible := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"id":    map[string]any{"type": "string"},
+			"count": map[string]any{"type": "integer"},
+			"kind":  map[string]any{"type": "string", "enum": []any{"a"}},
+		},
+		"required": []any{"count"},
+	}
+	expected := []string{
+		"#: required property 'id' is no longer required",
+		"#: property 'count' is required",
+		"#/properties/id: type narrowed from [string integer] to string",
+		"#/properties/kind: enum value b removed",
+		"#: property 'note' removed",
+	}
+	problems := incompatibilities(golden, incompatible, "")
+	sort.Strings(problems)
+	sort.Strings(expected)
+	if !slices.Equal(problems, expected) {
+		t.Errorf("incompatibilities = %q\nwant %q", problems, expected)
+	}
+	if problems := validateSchema(map[string]any{"type": "text", "required": "id", "default": 1}, ""); len(problems) != 3 {
+		t.Errorf("invalid schema must be reported, got %v", problems)
+	}
+}
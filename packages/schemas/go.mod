@@ -0,0 +1,3 @@
+module github.com/jitsucom/syncmaven/schemas
+
+go 1.22
@@ -0,0 +1,188 @@
+// Package schemas contains canonical JSON schemas of common row types, so connectors consuming the same logical
+// stream declare the same row shape. Connectors reference them in their row schemas with $ref:
+//
+//	{"$ref": "https://syncmaven.sh/schemas/AdData.json", "properties": {"currency": {"type": "string"}}}
+//
+// and resolve references with Resolve before replying stream-spec. Properties and required columns next to
+// $ref extend the canonical schema.
+//
+// Schemas of the repo are checked against golden copies in testdata/golden. Backward incompatible changes, e.g.
+// removed or narrowed properties, require incrementing "version" keyword of the schema (1 if not set)
+package schemas
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:embed *.schema.json
+var files embed.FS
+
+// BaseUrl is the prefix of $id of canonical schemas
+const BaseUrl = "https://syncmaven.sh/schemas/"
+
+// Canonical schemas. The values are shared and must not be modified, use Get for a copy
+var (
+	// AdData is daily advertising metrics of a campaign, ad group or ad
+	AdData = load("AdData")
+	// UserProfile is profile of a user of the product
+	UserProfile = load("UserProfile")
+	// AudienceMembership is membership of a person in an audience of an ad platform or a mailing list
+	AudienceMembership = load("AudienceMembership")
+	// Event is a behavioral event performed by a user
+	Event = load("Event")
+	// Contact is a contact record of a CRM or a support tool
+	Contact = load("Contact")
+)
+
+var all = map[string]map[string]any{
+	"AdData":             AdData,
+	"UserProfile":        UserProfile,
+	"AudienceMembership": AudienceMembership,
+	"Event":              Event,
+	"Contact":            Contact,
+}
+
+func load(name string) map[string]any {
+	var schema map[string]any
+	if err := json.Unmarshal(JSON(name), &schema); err != nil {
+		panic(fmt.Sprintf("invalid schema %s: %v", name, err))
+	}
+	return schema
+}
+
+// Names returns names of canonical schemas in alphabetical order
+func Names() []string {
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JSON returns canonical schema as JSON. Returns nil if there is no such schema
+func JSON(name string) []byte {
+	b, err := files.ReadFile(name + ".schema.json")
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// Get returns a copy of canonical schema
+func Get(name string) (map[string]any, bool) {
+	schema, ok := all[name]
+	if !ok {
+		return nil, false
+	}
+	return deepCopy(schema).(map[string]any), true
+}
+
+// Ref returns $ref of canonical schema
+func Ref(name string) string {
+	return BaseUrl + name + ".json"
+}
+
+// Resolve returns a copy of schema where references to canonical schemas are replaced with the schemas.
+// Other references, e.g. to local definitions, are kept
+func Resolve(schema map[string]any) (map[string]any, error) {
+	resolved, err := resolve(schema)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.(map[string]any), nil
+}
+
+func resolve(v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, value := range v {
+			r, err := resolve(value)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = r
+		}
+		ref, _ := v["$ref"].(string)
+		if !strings.HasPrefix(ref, BaseUrl) {
+			return result, nil
+		}
+		canonical, ok := Get(strings.TrimSuffix(strings.TrimPrefix(ref, BaseUrl), ".json"))
+		if !ok {
+			return nil, fmt.Errorf("unknown schema %s. Known schemas: %s", ref, strings.Join(Names(), ", "))
+		}
+		delete(result, "$ref")
+		return extend(canonical, result), nil
+	case []any:
+		result := make([]any, len(v))
+		for i, value := range v {
+			r, err := resolve(value)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = r
+		}
+		return result, nil
+	default:
+		return v, nil
+	}
+}
+
+// extend adds properties and required columns of extension to schema. Other keywords of extension replace ones
+// of schema
+func extend(schema map[string]any, extension map[string]any) map[string]any {
+	for key, value := range extension {
+		switch key {
+		case "properties":
+			properties, _ := schema["properties"].(map[string]any)
+			if properties == nil {
+				properties = map[string]any{}
+			}
+			extra, _ := value.(map[string]any)
+			for name, property := range extra {
+				properties[name] = property
+			}
+			schema["properties"] = properties
+		case "required":
+			required, _ := schema["required"].([]any)
+			extra, _ := value.([]any)
+		next:
+			for _, column := range extra {
+				for _, r := range required {
+					if r == column {
+						continue next
+					}
+				}
+				required = append(required, column)
+			}
+			schema["required"] = required
+		default:
+			schema[key] = value
+		}
+	}
+	return schema
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, value := range v {
+			result[key] = deepCopy(value)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, value := range v {
+			result[i] = deepCopy(value)
+		}
+		return result
+	default:
+		return v
+	}
+}
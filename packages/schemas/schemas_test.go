@@ -0,0 +1,54 @@
+package schemas
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchemas(t *testing.T) {
+	for _, name := range Names() {
+		schema, _ := Get(name)
+		if schema["$id"] != Ref(name) || schema["title"] != name {
+			t.Errorf("%s: unexpected $id %v or title %v", name, schema["$id"], schema["title"])
+		}
+		if _, ok := schema["properties"].(map[string]any); !ok {
+			t.Errorf("%s: properties are missing", name)
+		}
+	}
+	if len(Names()) != 5 {
+		t.Errorf("Names() = %v", Names())
+	}
+}
+
+func TestResolve(t *testing.T) {
+	schema := map[string]any{
+		"$ref":       Ref("AdData"),
+		"properties": map[string]any{"currency": map[string]any{"type": "string"}},
+		"required":   []any{"source", "currency"},
+	}
+	resolved, err := Resolve(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resolved["$ref"]; ok {
+		t.Error("$ref must be removed")
+	}
+	properties := resolved["properties"].(map[string]any)
+	if properties["currency"] == nil || properties["cost"] == nil {
+		t.Errorf("properties must be merged: %v", properties)
+	}
+	if required := resolved["required"]; !reflect.DeepEqual(required, []any{"date", "source", "campaign_id", "currency"}) {
+		t.Errorf("required = %v", required)
+	}
+	if _, ok := AdData["properties"].(map[string]any)["currency"]; ok {
+		t.Error("canonical schema must not be modified")
+	}
+
+	nested := map[string]any{"type": "array", "items": map[string]any{"$ref": "#/definitions/item"}}
+	if resolved, err := Resolve(nested); err != nil || !reflect.DeepEqual(resolved, nested) {
+		t.Errorf("local references must be kept, got %v %v", resolved, err)
+	}
+	if _, err := Resolve(map[string]any{"$ref": Ref("Unknown")}); err == nil {
+		t.Error("unknown schema must be reported")
+	}
+}